@@ -8,16 +8,29 @@ import (
 
 	"github.com/pavelbrm/pumpe/gate"
 	"github.com/pavelbrm/pumpe/handler"
+	"github.com/pavelbrm/pumpe/metrics"
 	"github.com/pavelbrm/pumpe/service"
 	"github.com/pavelbrm/pumpe/web"
 )
 
-func NewWeb(lg *slog.Logger, set *gate.Set) *web.App {
+// WebConfig controls which parts of the app are served.
+type WebConfig struct {
+	// ControlOnly disables the proxy, leaving only the API.
+	//
+	// Proxy requests get the default not found response instead.
+	ControlOnly bool
+}
+
+func NewWeb(lg *slog.Logger, scfg *gate.SetConfig, set *gate.Set, wcfg WebConfig) *web.App {
 	// System log messages are made from the app itself.
 	result := web.NewApp(lg.With(slog.String("app", "web")))
 
-	{
-		svc := service.NewPumpe(set)
+	reg := metrics.NewRegistry()
+
+	if !wcfg.ControlOnly {
+		svc := service.NewPumpe(scfg, set)
+		svc.SetMetrics(metrics.NewRequests(reg))
+
 		h := handler.NewPumpe(lg.With(slog.String("handler.name", "pumpe")), svc)
 
 		// Register the pumpe handler as the catch-all handler:
@@ -44,14 +57,38 @@ func NewWeb(lg *slog.Logger, set *gate.Set) *web.App {
 
 		result.Handle(http.MethodGet, "/v1/_service/gates", h.List)
 		result.Handle(http.MethodPost, "/v1/_service/gates", h.Create)
+		result.Handle(http.MethodGet, "/v1/_service/gates/:id", h.Get)
 		result.Handle(http.MethodPatch, "/v1/_service/gates/:id", h.Refresh)
+		result.Handle(http.MethodPost, "/v1/_service/gates/:id/probe", h.Probe)
 		result.Handle(http.MethodDelete, "/v1/_service/gates/:id", h.Stop)
+		result.Handle(http.MethodDelete, "/v1/_service/gates", h.StopIdle)
+		result.Handle(http.MethodPut, "/v1/_service/gates/tor/count", h.ScaleTors)
+
+		result.Handle(http.MethodGet, "/v1/_service/groups", h.Groups)
+		result.Handle(http.MethodPut, "/v1/_service/groups/:name", h.SetGroup)
+		result.Handle(http.MethodDelete, "/v1/_service/groups/:name", h.DeleteGroup)
+
+		result.Handle(http.MethodPost, "/v1/_service/reload", h.Reload)
+		result.Handle(http.MethodGet, "/v1/_service/config", h.Config)
+		result.Handle(http.MethodGet, "/v1/_service/stats", h.Stats)
+
+		result.Handle(http.MethodPost, "/v1/_service/pause", h.Pause)
+		result.Handle(http.MethodPost, "/v1/_service/resume", h.Resume)
 	}
 
 	{
-		h := handler.NewHealth()
+		h := handler.NewHealth(set)
 
 		result.Handle(http.MethodGet, "/v1/_internal/status", h.Status)
+		result.Handle(http.MethodGet, "/v1/_internal/ready", h.Ready)
+	}
+
+	{
+		metrics.RegisterGates(reg, set)
+
+		h := handler.NewMetrics(reg)
+
+		result.Handle(http.MethodGet, "/v1/_internal/metrics", h.Metrics)
 	}
 
 	return result
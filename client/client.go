@@ -0,0 +1,217 @@
+// Package client provides a client for the Pumpe control API.
+//
+// Errors reported by the API are mapped back to the gate and model errors they came from,
+// so that callers can check them with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/pavelbrm/pumpe/gate"
+	"github.com/pavelbrm/pumpe/model"
+)
+
+const (
+	ErrUnexpectedStatus model.Error = "client: unexpected response status"
+)
+
+// knownErrs lists errors the API responds with, to map them back from their messages.
+var knownErrs = []error{
+	gate.ErrKindUnknown,
+	gate.ErrKindNotSupported,
+	gate.ErrSetIsShutting,
+	gate.ErrSetPaused,
+	gate.ErrGateNotFound,
+	gate.ErrTorMaxReached,
+	gate.ErrGateIsRefreshing,
+	gate.ErrGateBusy,
+	gate.ErrTorStartFailed,
+	gate.ErrNetUnreachable,
+	gate.ErrTooManyAnnotations,
+	model.ErrInvalidParam,
+	model.ErrInvalidUUID,
+	model.ErrMissingParam,
+	model.ErrUnknownField,
+	context.DeadlineExceeded,
+}
+
+type httpDoer interface {
+	Do(r *http.Request) (*http.Response, error)
+}
+
+// GateIDs holds the ids of running gates by kind.
+type GateIDs struct {
+	Direct       []uuid.UUID `json:"direct"`
+	Tor          []uuid.UUID `json:"tor"`
+	TorWireGuard []uuid.UUID `json:"tor_wireguard"`
+	WireGuard    []uuid.UUID `json:"wireguard"`
+	OpenVPN      []uuid.UUID `json:"openvpn"`
+}
+
+type Client struct {
+	base string
+	doer httpDoer
+}
+
+// New returns a client for the API at base, e.g. http://127.0.0.1:8080.
+//
+// A nil doer falls back to http.DefaultClient.
+func New(base string, doer httpDoer) *Client {
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+
+	result := &Client{
+		base: strings.TrimSuffix(base, "/"),
+		doer: doer,
+	}
+
+	return result
+}
+
+// ListGates returns the ids of running gates.
+func (c *Client) ListGates(ctx context.Context) (*GateIDs, error) {
+	result := &GateIDs{}
+
+	if err := c.do(ctx, http.MethodGet, "/v1/_service/gates", nil, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// CreateGate starts a new gate of kind, and returns its id.
+func (c *Client) CreateGate(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
+	req := &struct {
+		Kind gate.Kind `json:"kind"`
+	}{
+		Kind: kind,
+	}
+
+	result := &struct {
+		ID uuid.UUID `json:"id"`
+	}{}
+
+	if err := c.do(ctx, http.MethodPost, "/v1/_service/gates", req, result); err != nil {
+		return uuid.Nil, err
+	}
+
+	return result.ID, nil
+}
+
+// RefreshGate refreshes the gate with id.
+func (c *Client) RefreshGate(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodPatch, "/v1/_service/gates/"+id.String(), nil, nil)
+}
+
+// AnnotateGate merges annots into the annotations of the gate with id, and returns the result.
+//
+// An empty value removes the annotation.
+func (c *Client) AnnotateGate(ctx context.Context, id uuid.UUID, annots map[string]string) (map[string]string, error) {
+	req := &struct {
+		Annotations map[string]string `json:"annotations"`
+	}{
+		Annotations: annots,
+	}
+
+	result := &struct {
+		Annotations map[string]string `json:"annotations"`
+	}{}
+
+	if err := c.do(ctx, http.MethodPatch, "/v1/_service/gates/"+id.String(), req, result); err != nil {
+		return nil, err
+	}
+
+	return result.Annotations, nil
+}
+
+// StopGate stops the gate with id, once its requests have finished.
+func (c *Client) StopGate(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, "/v1/_service/gates/"+id.String(), nil, nil)
+}
+
+// do sends a request with body encoded as JSON, if any, and decodes the data of the response into dst, if any.
+func (c *Client) do(ctx context.Context, method, path string, body, dst any) error {
+	var rbody io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		rbody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, rbody)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.doer.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errFromResponse(resp.StatusCode, data)
+	}
+
+	if dst == nil {
+		return nil
+	}
+
+	result := &struct {
+		Data any `json:"data"`
+	}{
+		Data: dst,
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// errFromResponse returns the known error the API responded with, or ErrUnexpectedStatus.
+//
+// Details the API adds to a known error are kept in the message.
+func errFromResponse(code int, data []byte) error {
+	resp := &struct {
+		Error string `json:"error"`
+	}{}
+
+	if err := json.Unmarshal(data, resp); err != nil || resp.Error == "" {
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, code)
+	}
+
+	for i := range knownErrs {
+		msg := knownErrs[i].Error()
+
+		if resp.Error == msg {
+			return knownErrs[i]
+		}
+
+		if rest, ok := strings.CutPrefix(resp.Error, msg); ok && strings.HasPrefix(rest, ":") {
+			return fmt.Errorf("%w%s", knownErrs[i], rest)
+		}
+	}
+
+	return fmt.Errorf("%w: %d: %s", ErrUnexpectedStatus, code, resp.Error)
+}
@@ -0,0 +1,263 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	should "github.com/stretchr/testify/assert"
+	must "github.com/stretchr/testify/require"
+
+	"github.com/pavelbrm/pumpe/gate"
+	"github.com/pavelbrm/pumpe/model"
+)
+
+type testCase[G, E any] struct {
+	name  string
+	given G
+	exp   E
+}
+
+type tcResponse struct {
+	code int
+	data string
+}
+
+func TestClient_ListGates(t *testing.T) {
+	type tcExpected struct {
+		val *GateIDs
+		err error
+	}
+
+	tests := []testCase[tcResponse, tcExpected]{
+		{
+			name:  "error_unexpected",
+			given: tcResponse{code: http.StatusInternalServerError, data: `{"error":"something_went_wrong"}`},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %d: %s", ErrUnexpectedStatus, http.StatusInternalServerError, "something_went_wrong"),
+			},
+		},
+
+		{
+			name:  "valid",
+			given: tcResponse{code: http.StatusOK, data: `{"data":{"direct":["facade00-0000-4000-a000-000000000000"],"tor":[],"wireguard":[],"openvpn":[]}}`},
+			exp: tcExpected{
+				val: &GateIDs{
+					Direct:    []uuid.UUID{uuid.MustParse("facade00-0000-4000-a000-000000000000")},
+					Tor:       []uuid.UUID{},
+					WireGuard: []uuid.UUID{},
+					OpenVPN:   []uuid.UUID{},
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, http.MethodGet, "/v1/_service/gates", "", tc.given)
+			defer srv.Close()
+
+			actual, err := New(srv.URL, nil).ListGates(context.Background())
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.val, actual)
+		})
+	}
+}
+
+func TestClient_CreateGate(t *testing.T) {
+	type tcExpected struct {
+		val uuid.UUID
+		err error
+	}
+
+	tests := []testCase[tcResponse, tcExpected]{
+		{
+			name:  "error_tor_max_reached",
+			given: tcResponse{code: http.StatusConflict, data: `{"error":"gate: reached maximum number of tor gates"}`},
+			exp: tcExpected{
+				err: gate.ErrTorMaxReached,
+			},
+		},
+
+		{
+			name:  "valid",
+			given: tcResponse{code: http.StatusCreated, data: `{"data":{"id":"facade00-0000-4000-a000-000000000000"}}`},
+			exp: tcExpected{
+				val: uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, http.MethodPost, "/v1/_service/gates", `{"kind":"tor"}`, tc.given)
+			defer srv.Close()
+
+			actual, err := New(srv.URL, nil).CreateGate(context.Background(), gate.KindTor)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.val, actual)
+		})
+	}
+}
+
+func TestClient_RefreshGate(t *testing.T) {
+	tests := []testCase[tcResponse, error]{
+		{
+			name:  "error_refreshing",
+			given: tcResponse{code: http.StatusConflict, data: `{"error":"gate: gate is refreshing"}`},
+			exp:   gate.ErrGateIsRefreshing,
+		},
+
+		{
+			name:  "valid",
+			given: tcResponse{code: http.StatusOK, data: `{}`},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, http.MethodPatch, "/v1/_service/gates/facade00-0000-4000-a000-000000000000", "", tc.given)
+			defer srv.Close()
+
+			err := New(srv.URL+"/", nil).RefreshGate(context.Background(), uuid.MustParse("facade00-0000-4000-a000-000000000000"))
+			should.Equal(t, tc.exp, err)
+		})
+	}
+}
+
+func TestClient_AnnotateGate(t *testing.T) {
+	type tcExpected struct {
+		val map[string]string
+		err error
+	}
+
+	tests := []testCase[tcResponse, tcExpected]{
+		{
+			name:  "error_too_many",
+			given: tcResponse{code: http.StatusUnprocessableEntity, data: `{"error":"gate: too many annotations"}`},
+			exp: tcExpected{
+				err: gate.ErrTooManyAnnotations,
+			},
+		},
+
+		{
+			name:  "valid",
+			given: tcResponse{code: http.StatusOK, data: `{"data":{"annotations":{"owner":"ops","region":"eu"}}}`},
+			exp: tcExpected{
+				val: map[string]string{"owner": "ops", "region": "eu"},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, http.MethodPatch, "/v1/_service/gates/facade00-0000-4000-a000-000000000000", `{"annotations":{"owner":"ops"}}`, tc.given)
+			defer srv.Close()
+
+			actual, err := New(srv.URL, nil).AnnotateGate(context.Background(), uuid.MustParse("facade00-0000-4000-a000-000000000000"), map[string]string{"owner": "ops"})
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.val, actual)
+		})
+	}
+}
+
+func TestClient_StopGate(t *testing.T) {
+	tests := []testCase[tcResponse, error]{
+		{
+			name:  "error_not_found",
+			given: tcResponse{code: http.StatusNotFound, data: `{"error":"gate: gate not found"}`},
+			exp:   gate.ErrGateNotFound,
+		},
+
+		{
+			name:  "valid",
+			given: tcResponse{code: http.StatusOK, data: `{}`},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, http.MethodDelete, "/v1/_service/gates/facade00-0000-4000-a000-000000000000", "", tc.given)
+			defer srv.Close()
+
+			err := New(srv.URL, nil).StopGate(context.Background(), uuid.MustParse("facade00-0000-4000-a000-000000000000"))
+			should.Equal(t, tc.exp, err)
+		})
+	}
+}
+
+func TestErrFromResponse(t *testing.T) {
+	tests := []testCase[tcResponse, error]{
+		{
+			name:  "not_json",
+			given: tcResponse{code: http.StatusBadGateway, data: "Bad Gateway"},
+			exp:   fmt.Errorf("%w: %d", ErrUnexpectedStatus, http.StatusBadGateway),
+		},
+
+		{
+			name:  "empty_error",
+			given: tcResponse{code: http.StatusInternalServerError, data: `{}`},
+			exp:   fmt.Errorf("%w: %d", ErrUnexpectedStatus, http.StatusInternalServerError),
+		},
+
+		{
+			name:  "known",
+			given: tcResponse{code: http.StatusBadRequest, data: `{"error":"invalid uuid"}`},
+			exp:   model.ErrInvalidUUID,
+		},
+
+		{
+			name:  "known_with_details",
+			given: tcResponse{code: http.StatusBadRequest, data: `{"error":"unknown field: \"kinds\""}`},
+			exp:   fmt.Errorf("%w%s", model.ErrUnknownField, `: "kinds"`),
+		},
+
+		{
+			name:  "known_prefix_only",
+			given: tcResponse{code: http.StatusBadRequest, data: `{"error":"invalid params"}`},
+			exp:   fmt.Errorf("%w: %d: %s", ErrUnexpectedStatus, http.StatusBadRequest, "invalid params"),
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := errFromResponse(tc.given.code, []byte(tc.given.data))
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+// newTestServer returns a server that checks the request, and responds with resp.
+func newTestServer(t *testing.T, method, path, body string, resp tcResponse) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		should.Equal(t, nil, err)
+
+		should.Equal(t, method, r.Method)
+		should.Equal(t, path, r.URL.Path)
+		should.Equal(t, body, string(data))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.code)
+		_, _ = io.WriteString(w, resp.data)
+	}))
+}
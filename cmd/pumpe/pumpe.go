@@ -1,31 +1,49 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/golocron/daemon/v2"
+	"github.com/google/uuid"
 
 	"github.com/pavelbrm/pumpe/app"
 	"github.com/pavelbrm/pumpe/gate"
 	"github.com/pavelbrm/pumpe/model"
+	"github.com/pavelbrm/pumpe/web"
 )
 
 func main() {
-	cfg := newSettingsFromEnv(rawEnvToMap(os.Environ()))
+	env := rawEnvToMap(os.Environ())
+
+	// Values from the process environment take precedence over those from the file.
+	denv, derr := readDotEnvFile(env["PUMPE_DOTENV"])
+	env = mergeEnv(denv, env)
+
+	cfg := newSettingsFromEnv(env)
 	plg := newLogger(os.Stderr, cfg.logLvl, cfg.logFmt, cfg.logAddSrc)
 	lg := plg.With(slog.String("service", "pumpe"))
 
 	ctx := context.Background()
+	if derr != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to read dotenv file", slog.Any("error", derr))
+
+		os.Exit(1)
+	}
 	if err := run(ctx, lg, cfg, os.Args); err != nil {
 		lg.LogAttrs(ctx, slog.LevelError, "finished with error", slog.Any("error", err))
 
@@ -38,7 +56,11 @@ func main() {
 }
 
 func run(pctx context.Context, lg *slog.Logger, cfg settings, args []string) error {
-	if cfg.wgDir == "" {
+	if cfg.shutdownTimeoutReq > cfg.shutdownTimeout {
+		lg.LogAttrs(pctx, slog.LevelWarn, "shutdown timeout exceeds maximum, using maximum", slog.Duration("requested", cfg.shutdownTimeoutReq), slog.Duration("max", cfg.shutdownTimeoutMax))
+	}
+
+	if cfg.wgDir == "" && cfg.wgFile == "" {
 		return model.Error("invalid wireguard config directory")
 	}
 
@@ -47,28 +69,140 @@ func run(pctx context.Context, lg *slog.Logger, cfg settings, args []string) err
 		return err
 	}
 
-	wcfgs, err := gate.ParseWGConfigs(gate.WGParseMode(cfg.wgParseMode), cfg.wgDir)
+	wcfgs, err := parseWGConfigs(cfg)
 	if err != nil {
 		if err2 := handleWGParseErr(pctx, lg, cfg.wgParseMode, err); err2 != nil {
 			return err2
 		}
 	}
 
+	if !cfg.wgListenPort {
+		ign := gate.IgnoreWGListenPorts(wcfgs)
+
+		for i := range ign {
+			lg.LogAttrs(pctx, slog.LevelWarn, "ignored listen port", slog.String("kind", "wireguard"), slog.String("wg.key", ign[i].Key()), slog.String("wg.endpoint", ign[i].Peer.Endpoint))
+		}
+	}
+
+	if cfg.wgDedup {
+		var dups []*gate.WGConfig
+		wcfgs, dups = gate.DedupWGConfigs(wcfgs)
+
+		for i := range dups {
+			lg.LogAttrs(pctx, slog.LevelWarn, "skipped duplicate config", slog.String("kind", "wireguard"), slog.String("wg.key", dups[i].Key()), slog.String("wg.endpoint", dups[i].Peer.Endpoint))
+		}
+	}
+
+	if dkind == gate.KindDirect && cfg.disableDirect {
+		return model.Error("cannot start: unable to use direct as default when disabled")
+	}
+
 	nwgs := len(wcfgs)
 	if dkind == gate.KindWireGuard && nwgs == 0 {
 		return model.Error("cannot start: unable to use wireguard as default without configs")
 	}
 
-	if cfg.randomiseKinds && (nwgs == 0 || cfg.torN == 0) {
+	var ocfgs []string
+	if cfg.ovpnDir != "" {
+		ocfgs, err = gate.ListOVPNConfigs(cfg.ovpnDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dkind == gate.KindOpenVPN && len(ocfgs) == 0 {
+		return model.Error("cannot start: unable to use openvpn as default without configs")
+	}
+
+	if dkind == gate.KindTor && (cfg.torN <= 0 || cfg.torOverWG) {
+		return model.Error("cannot start: unable to use tor as default without gates")
+	}
+
+	if cfg.torOverWG && nwgs == 0 {
+		return model.Error("cannot start: unable to chain tor over wireguard without configs")
+	}
+
+	if dkind == gate.KindTorWG && (cfg.torN <= 0 || !cfg.torOverWG) {
+		return model.Error("cannot start: unable to use tor_wireguard as default without chained gates")
+	}
+
+	kweights, err := parseKindWeights(cfg.kindWeights)
+	if err != nil {
+		return fmt.Errorf("cannot start: invalid random kind weights: %w", err)
+	}
+
+	if cfg.randomiseKinds && len(kweights) == 0 && (nwgs == 0 || cfg.torN == 0 || cfg.torOverWG) {
 		return model.Error("cannot start: unable to randomise kinds without both configured")
 	}
 
+	if cfg.randomiseKinds {
+		avail := map[gate.Kind]bool{
+			gate.KindDirect:    !cfg.disableDirect,
+			gate.KindTor:       cfg.torN > 0 && !cfg.torOverWG,
+			gate.KindWireGuard: nwgs > 0,
+			gate.KindOpenVPN:   len(ocfgs) > 0,
+			gate.KindTorWG:     cfg.torN > 0 && cfg.torOverWG,
+		}
+
+		if kind, ok := missingWeightedKind(kweights, avail); ok {
+			return fmt.Errorf("cannot start: unable to randomise kinds without %s gates", kind)
+		}
+	}
+
+	wskip, err := parseKindSet(cfg.warmupSkipKinds)
+	if err != nil {
+		return fmt.Errorf("cannot start: invalid warmup skip kinds: %w", err)
+	}
+
+	if gate.WarmupMode(cfg.warmupMode) == gate.WarmupModeTCP {
+		if _, _, err := net.SplitHostPort(cfg.warmupAddr); err != nil {
+			return fmt.Errorf("cannot start: invalid warmup address: %w", err)
+		}
+	}
+
+	if err := checkWarmupURLs(cfg.warmupURLs); err != nil {
+		return fmt.Errorf("cannot start: invalid warmup urls: %w", err)
+	}
+
+	cports, err := parsePorts(cfg.connectPorts)
+	if err != nil {
+		return fmt.Errorf("cannot start: invalid connect allowed ports: %w", err)
+	}
+
+	var connResp string
+	if cfg.connectResponseLine != "" {
+		connResp = cfg.connectResponseLine + "\r\n\r\n"
+
+		if err := gate.ValidateConnectResponse(connResp); err != nil {
+			return fmt.Errorf("cannot start: invalid connect response line: %w", err)
+		}
+	}
+
 	wgdns, err := netip.ParseAddr(cfg.wgDNS)
 	if err != nil {
 		return err
 	}
 
-	shutc := make(chan func(context.Context) error, 2)
+	daddrs, err := parseAddrs(cfg.directAddrs)
+	if err != nil {
+		return fmt.Errorf("cannot start: invalid direct local address: %w", err)
+	}
+
+	pdests, err := parsePrefixes(cfg.allowedPrivateDests)
+	if err != nil {
+		return fmt.Errorf("cannot start: invalid allowed private destination: %w", err)
+	}
+
+	// Nothing has been started so far, so a check can stop here.
+	if isCheckOnly(args) {
+		attrs := append(summaryAttrs(cfg, dkind, cfg.torN, nwgs), slog.Int("openvpn.count", len(ocfgs)), slog.String("wireguard.dns", wgdns.String()))
+
+		lg.LogAttrs(pctx, slog.LevelInfo, "configuration is valid", attrs...)
+
+		return nil
+	}
+
+	shutc := make(chan func(context.Context) error, 3)
 	killc := make(chan func() error, 1)
 
 	svc := &daemon.ServiceClosing{
@@ -76,39 +210,116 @@ func run(pctx context.Context, lg *slog.Logger, cfg settings, args []string) err
 			ShutTimeout: cfg.shutdownTimeout,
 
 			RunFn: func(ctx context.Context) error {
-				wgs, err := gate.NewWireGuards(lg, wcfgs, wgdns, cfg.httpClientTimeout)
+				// Reload gates on SIGHUP without restarting the server.
+				// The signal is caught from the start, so that it doesn't terminate the process while gates start and warm up.
+				// One that arrives by then is handled once the server starts.
+				hupc := make(chan os.Signal, 1)
+				signal.Notify(hupc, syscall.SIGHUP)
+				defer signal.Stop(hupc)
+
+				scfg := &gate.SetConfig{
+					Default:           dkind,
+					HTTPTimeout:       cfg.httpClientTimeout,
+					RandomLoopTout:    cfg.setRandomLoopTimeout,
+					RandomLoopDelay:   cfg.setRandomLoopDelay,
+					StateLoopTout:     cfg.setStateLoopTimeout,
+					StateLoopDelay:    cfg.setStateLoopDelay,
+					LoopJitter:        cfg.setLoopJitter,
+					TorStartupTout:    cfg.torStartupTimeout,
+					TorMax:            cfg.torMax,
+					Tor:               gate.TorConfig{TorrcFile: cfg.torrcFile, ControlPassword: cfg.torControlPassword},
+					FnBaseCtx:         func() context.Context { return ctx },
+					RandomiseKinds:    cfg.randomiseKinds,
+					RandomKindWeights: kweights,
+					WarmupOnCreate:    cfg.warmupOnCreate,
+					WarmupMode:        gate.WarmupMode(cfg.warmupMode),
+					WarmupAddr:        cfg.warmupAddr,
+					WarmupURLs:        cfg.warmupURLs,
+					WarmupRetries:     cfg.warmupRetries,
+					WarmupRetryDelay:  cfg.warmupRetryDelay,
+					SkipWarmup:        wskip,
+					RandomNoWait:      cfg.setRandomNoWait,
+					FallbackToDefault: cfg.fallbackToDefault,
+					DisableDirect:     cfg.disableDirect,
+
+					TorHTTPTimeout:    cfg.torHTTPClientTimeout,
+					WGHTTPTimeout:     cfg.wgHTTPClientTimeout,
+					DirectHTTPTimeout: cfg.directHTTPClientTimeout,
+
+					WGDir:            cfg.wgDir,
+					WGFile:           cfg.wgFile,
+					WGParseMode:      gate.WGParseMode(cfg.wgParseMode),
+					WGAllowedIPsMode: gate.WGAllowedIPsMode(cfg.wgAllowedIPsMode),
+					WGDNS:            wgdns,
+					WGDedup:          cfg.wgDedup,
+					WGListenPort:     cfg.wgListenPort,
+					Logger:           lg,
+
+					AllowedConnectPorts: cports,
+					BlockPrivateDests:   cfg.blockPrivateDests,
+					AllowedPrivateDests: pdests,
+					MaxDialRetries:      cfg.maxDialRetries,
+					MaxTunnels:          cfg.maxTunnels,
+					ConnectResponse:     connResp,
+					DialTimeout:         cfg.dialTimeout,
+					HalfCloseTimeout:    cfg.halfCloseTimeout,
+					MaxHeaderCount:      cfg.maxHeaderCount,
+					MaxHeaderBytes:      cfg.maxHeaderBytes,
+					MaxBodyBytes:        cfg.maxBodyBytes,
+					StripUserAgent:      cfg.stripUserAgent,
+					UserAgent:           cfg.userAgent,
+					XFFMode:             gate.XFFMode(cfg.xffMode),
+
+					FailThreshold:    cfg.failThreshold,
+					FailCooldown:     cfg.failCooldown,
+					TorMaxAge:        cfg.torMaxAge,
+					TorCheckInterval: cfg.torCheckInterval,
+					TorMin:           cfg.torMin,
+					TorAPIMax:        cfg.torAPIMax,
+
+					ShutdownTimeout: cfg.shutdownTimeout,
+				}
+
+				if cfg.torWarmupCheck {
+					scfg.Warmups = map[gate.Kind]gate.WarmupFunc{gate.KindTor: gate.WarmupTorCheck, gate.KindTorWG: gate.WarmupTorCheck}
+				}
+
+				wgs, err := gate.NewWireGuards(lg, wcfgs, wgdns, scfg.HTTPTimeoutFor(gate.KindWireGuard))
 				if err != nil {
 					return err
 				}
 
 				lg.LogAttrs(ctx, slog.LevelDebug, "initialised gates", slog.String("kind", "wireguard"))
 
-				tgs, err := gate.NewTors(ctx, cfg.torStartupTimeout, cfg.httpClientTimeout, cfg.torN)
-				if err != nil {
+				tgs, err := newTors(ctx, cfg, scfg, wgs)
+				switch {
+				case err == nil:
+					lg.LogAttrs(ctx, slog.LevelDebug, "initialised gates", slog.String("kind", "tor"))
+
+				case cfg.torOptional && !requiresTor(dkind, cfg.randomiseKinds, kweights):
+					lg.LogAttrs(ctx, slog.LevelWarn, "failed to start tor, continuing without it", slog.Any("error", err))
+
+				default:
 					// Stop WireGuard if failed to start Tor.
 					_ = gate.ShutdownList(ctx, wgs)
 
 					return err
 				}
 
-				lg.LogAttrs(ctx, slog.LevelDebug, "initialised gates", slog.String("kind", "tor"))
-
-				dct := gate.NewDirect(cfg.httpClientTimeout)
+				ovs, err := gate.NewOpenVPNs(ctx, ocfgs, cfg.ovpnStartupTimeout, scfg.HTTPTimeoutFor(gate.KindOpenVPN))
+				if err != nil {
+					// Stop the rest if failed to start OpenVPN.
+					_ = gate.ShutdownList(ctx, tgs)
+					_ = gate.ShutdownList(ctx, wgs)
 
-				scfg := &gate.SetConfig{
-					Default:         dkind,
-					HTTPTimeout:     cfg.httpClientTimeout,
-					RandomLoopTout:  cfg.setRandomLoopTimeout,
-					RandomLoopDelay: cfg.setRandomLoopDelay,
-					StateLoopTout:   cfg.setStateLoopTimeout,
-					StateLoopDelay:  cfg.setStateLoopDelay,
-					TorStartupTout:  cfg.torStartupTimeout,
-					TorMax:          cfg.torMax,
-					FnBaseCtx:       func() context.Context { return ctx },
-					RandomiseKinds:  cfg.randomiseKinds,
+					return err
 				}
 
-				set := gate.NewSet(scfg, dct, tgs, wgs)
+				lg.LogAttrs(ctx, slog.LevelDebug, "initialised gates", slog.String("kind", "openvpn"))
+
+				dcts := newDirects(daddrs, scfg.HTTPTimeoutFor(gate.KindDirect))
+
+				set := gate.NewSet(scfg, dcts, tgs, wgs, ovs)
 				if err := set.Warmup(ctx); err != nil {
 					// Stop everything if failed to warm up.
 					_ = set.Shutdown(ctx)
@@ -118,10 +329,25 @@ func run(pctx context.Context, lg *slog.Logger, cfg settings, args []string) err
 
 				lg.LogAttrs(ctx, slog.LevelDebug, "warmed up gates")
 
+				lg.LogAttrs(ctx, slog.LevelInfo, "effective configuration", summaryAttrs(cfg, dkind, len(tgs), len(wgs))...)
+
+				wapp := app.NewWeb(lg, scfg, set, app.WebConfig{ControlOnly: cfg.controlOnly})
+				wapp.SetLogSampling(cfg.logSampleN)
+				wapp.SetLatencyMode(web.LatencyMode(cfg.logLatencyMode))
+
 				srv := &http.Server{
-					Addr:        ":" + cfg.port,
-					Handler:     app.NewWeb(lg, set),
-					BaseContext: func(l net.Listener) context.Context { return ctx },
+					Addr:              ":" + cfg.port,
+					Handler:           wapp,
+					ReadHeaderTimeout: cfg.srvReadHeaderTimeout,
+					ReadTimeout:       cfg.srvReadTimeout,
+					WriteTimeout:      cfg.srvWriteTimeout,
+					IdleTimeout:       cfg.srvIdleTimeout,
+					BaseContext:       func(l net.Listener) context.Context { return ctx },
+				}
+
+				// Let requests in flight finish before the server stops accepting connections.
+				if cfg.drainGrace > 0 {
+					shutc <- newDrainFunc(set, cfg.drainGrace)
 				}
 
 				shutc <- srv.Shutdown
@@ -131,6 +357,11 @@ func run(pctx context.Context, lg *slog.Logger, cfg settings, args []string) err
 				killc <- srv.Close
 				close(killc)
 
+				go reloadOnSignal(ctx, lg, hupc, set, cfg.reloadTimeout)
+
+				go set.RunTorRotation(ctx)
+				go set.RunTorChecks(ctx)
+
 				lg.LogAttrs(ctx, slog.LevelInfo, "starting http server", slog.String("port", cfg.port))
 
 				serr := srv.ListenAndServe()
@@ -167,6 +398,21 @@ func run(pctx context.Context, lg *slog.Logger, cfg settings, args []string) err
 	return nil
 }
 
+// isCheckOnly reports whether args, starting with the program name, ask to only validate the configuration.
+func isCheckOnly(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+
+	for _, arg := range args[1:] {
+		if arg == "--check" || arg == "-check" {
+			return true
+		}
+	}
+
+	return false
+}
+
 func rawEnvToMap(raw []string) map[string]string {
 	if raw == nil {
 		return nil
@@ -182,25 +428,179 @@ func rawEnvToMap(raw []string) map[string]string {
 	return result
 }
 
+const errInvalidDotEnvLine model.Error = "invalid dotenv line"
+
+// readDotEnvFile reads variables from the file at fpath.
+//
+// An empty fpath is not an error, and results in no variables.
+func readDotEnvFile(fpath string) (map[string]string, error) {
+	if fpath == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return parseDotEnv(f)
+}
+
+// parseDotEnv parses KEY=VALUE lines from r.
+//
+// Blank lines and lines starting with # are skipped, and an optional export prefix is allowed.
+// Values may be enclosed in single or double quotes; an unquoted value ends at an inline comment.
+func parseDotEnv(r io.Reader) (map[string]string, error) {
+	result := make(map[string]string)
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		key, val, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%w: line %d", errInvalidDotEnvLine, n)
+		}
+
+		val, err := parseDotEnvValue(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d", err, n)
+		}
+
+		result[key] = val
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func parseDotEnvValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+
+	if q := raw[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(raw[1:], q)
+		if end < 0 {
+			return "", errInvalidDotEnvLine
+		}
+
+		return raw[1 : end+1], nil
+	}
+
+	if idx := strings.Index(raw, " #"); idx >= 0 {
+		raw = strings.TrimSpace(raw[:idx])
+	}
+
+	return raw, nil
+}
+
+// mergeEnv returns a union of base and over, with values from over taking precedence.
+func mergeEnv(base, over map[string]string) map[string]string {
+	if base == nil {
+		return over
+	}
+
+	result := make(map[string]string, len(base)+len(over))
+	for k, v := range base {
+		result[k] = v
+	}
+
+	for k, v := range over {
+		result[k] = v
+	}
+
+	return result
+}
+
 type settings struct {
-	shutdownTimeout      time.Duration
-	httpClientTimeout    time.Duration
-	setRandomLoopTimeout time.Duration
-	setRandomLoopDelay   time.Duration
-	setStateLoopTimeout  time.Duration
-	setStateLoopDelay    time.Duration
-	torStartupTimeout    time.Duration
-	torN                 int
-	torMax               int
-	wgParseMode          int
-	defKind              string
-	wgDir                string
-	wgDNS                string
-	port                 string
-	logLvl               string
-	logFmt               string
-	randomiseKinds       bool
-	logAddSrc            bool
+	shutdownTimeout         time.Duration
+	shutdownTimeoutReq      time.Duration
+	shutdownTimeoutMax      time.Duration
+	httpClientTimeout       time.Duration
+	torHTTPClientTimeout    time.Duration
+	wgHTTPClientTimeout     time.Duration
+	directHTTPClientTimeout time.Duration
+	setRandomLoopTimeout    time.Duration
+	setRandomLoopDelay      time.Duration
+	setStateLoopTimeout     time.Duration
+	setStateLoopDelay       time.Duration
+	setLoopJitter           time.Duration
+	torStartupTimeout       time.Duration
+	ovpnStartupTimeout      time.Duration
+	reloadTimeout           time.Duration
+	failCooldown            time.Duration
+	torMaxAge               time.Duration
+	torCheckInterval        time.Duration
+	warmupRetryDelay        time.Duration
+	dialTimeout             time.Duration
+	halfCloseTimeout        time.Duration
+	drainGrace              time.Duration
+	srvReadHeaderTimeout    time.Duration
+	srvReadTimeout          time.Duration
+	srvWriteTimeout         time.Duration
+	srvIdleTimeout          time.Duration
+	torN                    int
+	torMax                  int
+	torAPIMax               int
+	torMin                  int
+	wgParseMode             int
+	wgAllowedIPsMode        int
+	maxDialRetries          int
+	maxTunnels              int
+	maxHeaderCount          int
+	maxHeaderBytes          int
+	maxBodyBytes            int64
+	failThreshold           int
+	xffMode                 int
+	warmupMode              int
+	warmupRetries           int
+	logSampleN              int
+	logLatencyMode          int
+	connectPorts            []string
+	directAddrs             []string
+	allowedPrivateDests     []string
+	kindWeights             []string
+	warmupSkipKinds         []string
+	warmupURLs              []string
+	defKind                 string
+	wgDir                   string
+	wgFile                  string
+	wgDNS                   string
+	ovpnDir                 string
+	torrcFile               string
+	torControlPassword      string
+	userAgent               string
+	connectResponseLine     string
+	warmupAddr              string
+	port                    string
+	logLvl                  string
+	logFmt                  string
+	randomiseKinds          bool
+	warmupOnCreate          bool
+	setRandomNoWait         bool
+	fallbackToDefault       bool
+	torWarmupCheck          bool
+	wgDedup                 bool
+	wgListenPort            bool
+	disableDirect           bool
+	torOverWG               bool
+	torOptional             bool
+	stripUserAgent          bool
+	blockPrivateDests       bool
+	controlOnly             bool
+	logAddSrc               bool
 }
 
 func newSettingsFromEnv(env map[string]string) settings {
@@ -211,10 +611,26 @@ func newSettingsFromEnv(env map[string]string) settings {
 		// If no wireguard is needed, specify a path to an empty directory.
 		wgDir: env["PUMPE_WG_DIR"],
 
-		wgDNS:  env["PUMPE_WG_DNS"],
-		port:   env["PUMPE_PORT"],
-		logLvl: env["PUMPE_LOG_LEVEL"],
-		logFmt: env["PUMPE_LOG_FORMAT"],
+		// Takes precedence over wgDir when supplied.
+		wgFile: env["PUMPE_WG_FILE"],
+
+		// OpenVPN is disabled unless the directory is supplied.
+		ovpnDir: env["PUMPE_OVPN_DIR"],
+
+		// Tor starts with the defaults and cookie authentication unless supplied.
+		torrcFile:          env["PUMPE_TOR_TORRC_FILE"],
+		torControlPassword: env["PUMPE_TOR_CONTROL_PASSWORD"],
+
+		wgDNS:     env["PUMPE_WG_DNS"],
+		port:      env["PUMPE_PORT"],
+		logLvl:    env["PUMPE_LOG_LEVEL"],
+		logFmt:    env["PUMPE_LOG_FORMAT"],
+		userAgent: env["PUMPE_HTTP_USER_AGENT"],
+
+		connectResponseLine: env["PUMPE_CONNECT_RESPONSE_LINE"],
+
+		// Only used with the tcp warmup mode.
+		warmupAddr: env["PUMPE_WARMUP_ADDR"],
 	}
 
 	if result.defKind == "" {
@@ -223,17 +639,52 @@ func newSettingsFromEnv(env map[string]string) settings {
 
 	// Default to reporting errors.
 	result.wgParseMode, _ = strconv.Atoi(env["PUMPE_WG_PARSE_MODE"])
+	result.wgAllowedIPsMode, _ = strconv.Atoi(env["PUMPE_WG_ALLOWED_IPS_MODE"])
+
+	// Default to appending.
+	result.xffMode, _ = strconv.Atoi(env["PUMPE_HTTP_XFF_MODE"])
+
+	// Default to warming up with HTTP requests.
+	result.warmupMode, _ = strconv.Atoi(env["PUMPE_WARMUP_MODE"])
+
+	// Default to a single attempt.
+	result.warmupRetries, _ = strconv.Atoi(env["PUMPE_WARMUP_RETRIES"])
+	if result.warmupRetries < 0 {
+		result.warmupRetries = 0
+	}
 
-	result.shutdownTimeout, _ = time.ParseDuration(env["PUMPE_SHUTDOWN_TIMEOUT"])
-	if result.shutdownTimeout == 0 || result.shutdownTimeout > 60*time.Second {
+	result.warmupRetryDelay, _ = time.ParseDuration(env["PUMPE_WARMUP_RETRY_DELAY"])
+	if result.warmupRetryDelay < 0 {
+		result.warmupRetryDelay = 0
+	}
+
+	result.shutdownTimeoutMax, _ = time.ParseDuration(env["PUMPE_SHUTDOWN_TIMEOUT_MAX"])
+	if result.shutdownTimeoutMax <= 0 {
+		result.shutdownTimeoutMax = 60 * time.Second
+	}
+
+	// Keep the requested value to warn when it's overridden.
+	result.shutdownTimeoutReq, _ = time.ParseDuration(env["PUMPE_SHUTDOWN_TIMEOUT"])
+
+	result.shutdownTimeout = result.shutdownTimeoutReq
+	if result.shutdownTimeout <= 0 {
 		result.shutdownTimeout = 30 * time.Second
 	}
 
+	if result.shutdownTimeout > result.shutdownTimeoutMax {
+		result.shutdownTimeout = result.shutdownTimeoutMax
+	}
+
 	result.httpClientTimeout, _ = time.ParseDuration(env["PUMPE_HTTP_CLIENT_TIMEOUT"])
 	if result.httpClientTimeout == 0 {
 		result.httpClientTimeout = 60 * time.Second
 	}
 
+	// Per-kind timeouts fall back to httpClientTimeout when not set.
+	result.torHTTPClientTimeout, _ = time.ParseDuration(env["PUMPE_TOR_HTTP_CLIENT_TIMEOUT"])
+	result.wgHTTPClientTimeout, _ = time.ParseDuration(env["PUMPE_WG_HTTP_CLIENT_TIMEOUT"])
+	result.directHTTPClientTimeout, _ = time.ParseDuration(env["PUMPE_DIRECT_HTTP_CLIENT_TIMEOUT"])
+
 	result.setRandomLoopTimeout, _ = time.ParseDuration(env["PUMPE_SET_RANDOM_LOOP_TIMEOUT"])
 	if result.setRandomLoopTimeout == 0 || result.setRandomLoopTimeout > 60*time.Second {
 		result.setRandomLoopTimeout = 30 * time.Second
@@ -254,12 +705,51 @@ func newSettingsFromEnv(env map[string]string) settings {
 		result.setStateLoopDelay = 10 * time.Millisecond
 	}
 
+	// Loop delays are fixed unless the jitter is set.
+	result.setLoopJitter, _ = time.ParseDuration(env["PUMPE_SET_LOOP_JITTER"])
+	if result.setLoopJitter < 0 {
+		result.setLoopJitter = 0
+	}
+
 	result.torStartupTimeout, _ = time.ParseDuration(env["PUMPE_TOR_STARTUP_TIMEOUT"])
 	if result.torStartupTimeout == 0 || result.torStartupTimeout < 2*time.Minute {
 		// Apparently, 1 minute is not always enough.
 		result.torStartupTimeout = 3 * time.Minute
 	}
 
+	result.ovpnStartupTimeout, _ = time.ParseDuration(env["PUMPE_OVPN_STARTUP_TIMEOUT"])
+	if result.ovpnStartupTimeout <= 0 {
+		result.ovpnStartupTimeout = 60 * time.Second
+	}
+
+	result.reloadTimeout, _ = time.ParseDuration(env["PUMPE_RELOAD_TIMEOUT"])
+	if result.reloadTimeout == 0 {
+		result.reloadTimeout = 60 * time.Second
+	}
+
+	// Cooldowns are disabled unless the threshold is set.
+	result.failThreshold, _ = strconv.Atoi(env["PUMPE_GATE_FAIL_THRESHOLD"])
+	if result.failThreshold < 0 {
+		result.failThreshold = 0
+	}
+
+	result.failCooldown, _ = time.ParseDuration(env["PUMPE_GATE_FAIL_COOLDOWN"])
+	if result.failCooldown <= 0 {
+		result.failCooldown = 60 * time.Second
+	}
+
+	// Rotation is disabled unless the age is set.
+	result.torMaxAge, _ = time.ParseDuration(env["PUMPE_TOR_MAX_AGE"])
+	if result.torMaxAge < 0 {
+		result.torMaxAge = 0
+	}
+
+	// Checks are disabled unless the interval is set.
+	result.torCheckInterval, _ = time.ParseDuration(env["PUMPE_TOR_CHECK_INTERVAL"])
+	if result.torCheckInterval < 0 {
+		result.torCheckInterval = 0
+	}
+
 	result.torN, _ = strconv.Atoi(env["PUMPE_TOR_NUM"])
 
 	// Start tor only if it's the default kind.
@@ -273,14 +763,160 @@ func newSettingsFromEnv(env map[string]string) settings {
 		result.torMax = 128
 	}
 
+	// Only TorMax applies unless a separate limit is set.
+	result.torAPIMax, _ = strconv.Atoi(env["PUMPE_TOR_API_MAX"])
+	if result.torAPIMax < 0 {
+		result.torAPIMax = 0
+	}
+
+	// Keep at least one Tor gate when closing idle ones.
+	result.torMin, _ = strconv.Atoi(env["PUMPE_TOR_MIN"])
+	if result.torMin <= 0 {
+		result.torMin = 1
+	}
+
 	if result.wgDNS == "" {
 		result.wgDNS = "9.9.9.9"
 	}
 
+	result.connectPorts = parseList(env["PUMPE_CONNECT_ALLOWED_PORTS"])
+	result.directAddrs = parseList(env["PUMPE_DIRECT_LOCAL_ADDRS"])
+	result.allowedPrivateDests = parseList(env["PUMPE_ALLOWED_PRIVATE_DESTS"])
+	result.kindWeights = parseList(env["PUMPE_RANDOM_KIND_WEIGHTS"])
+	result.warmupSkipKinds = parseList(env["PUMPE_WARMUP_SKIP_KINDS"])
+	result.warmupURLs = parseList(env["PUMPE_WARMUP_URLS"])
+
+	// Dials are limited by the request alone unless the timeout is set.
+	result.dialTimeout, _ = time.ParseDuration(env["PUMPE_DIAL_TIMEOUT"])
+	if result.dialTimeout < 0 {
+		result.dialTimeout = 0
+	}
+
+	// Tunnels wait for both directions unless the timeout is set.
+	result.halfCloseTimeout, _ = time.ParseDuration(env["PUMPE_CONNECT_HALF_CLOSE_TIMEOUT"])
+	if result.halfCloseTimeout < 0 {
+		result.halfCloseTimeout = 0
+	}
+
+	// Shutdown starts right away unless the grace period is set.
+	result.drainGrace, _ = time.ParseDuration(env["PUMPE_SHUTDOWN_DRAIN_GRACE"])
+	if result.drainGrace < 0 {
+		result.drainGrace = 0
+	}
+
+	// Slow clients must not hold connections open before a request is read.
+	result.srvReadHeaderTimeout, _ = time.ParseDuration(env["PUMPE_SERVER_READ_HEADER_TIMEOUT"])
+	if result.srvReadHeaderTimeout <= 0 {
+		result.srvReadHeaderTimeout = 10 * time.Second
+	}
+
+	// Bodies and responses are limited by the client timeouts alone unless these are set.
+	//
+	// Tunnels and upgraded connections are not affected, as they are taken over from the server.
+	result.srvReadTimeout, _ = time.ParseDuration(env["PUMPE_SERVER_READ_TIMEOUT"])
+	if result.srvReadTimeout < 0 {
+		result.srvReadTimeout = 0
+	}
+
+	result.srvWriteTimeout, _ = time.ParseDuration(env["PUMPE_SERVER_WRITE_TIMEOUT"])
+	if result.srvWriteTimeout < 0 {
+		result.srvWriteTimeout = 0
+	}
+
+	result.srvIdleTimeout, _ = time.ParseDuration(env["PUMPE_SERVER_IDLE_TIMEOUT"])
+	if result.srvIdleTimeout <= 0 {
+		result.srvIdleTimeout = 120 * time.Second
+	}
+
+	// Tunnels are unlimited unless the limit is set.
+	result.maxTunnels, _ = strconv.Atoi(env["PUMPE_CONNECT_MAX_TUNNELS"])
+	if result.maxTunnels < 0 {
+		result.maxTunnels = 0
+	}
+
+	result.maxDialRetries, _ = strconv.Atoi(env["PUMPE_MAX_DIAL_RETRIES"])
+	if result.maxDialRetries < 0 || result.maxDialRetries > 5 {
+		result.maxDialRetries = 0
+	}
+
+	// The limits are generous, and only meant to stop abuse.
+	result.maxHeaderCount, _ = strconv.Atoi(env["PUMPE_HTTP_MAX_HEADER_COUNT"])
+	if result.maxHeaderCount < 1 {
+		result.maxHeaderCount = 100
+	}
+
+	result.maxHeaderBytes, _ = strconv.Atoi(env["PUMPE_HTTP_MAX_HEADER_BYTES"])
+	if result.maxHeaderBytes < 1 {
+		result.maxHeaderBytes = 64 << 10
+	}
+
+	// Bodies are limited unless the limit is explicitly set to zero.
+	result.maxBodyBytes = 32 << 20
+	if n, err := strconv.ParseInt(env["PUMPE_HTTP_MAX_BODY_BYTES"], 10, 64); err == nil && n >= 0 {
+		result.maxBodyBytes = n
+	}
+
+	// Log every request by default.
+	result.logSampleN, _ = strconv.Atoi(env["PUMPE_LOG_SAMPLE_N"])
+	if result.logSampleN < 1 {
+		result.logSampleN = 1
+	}
+
+	// Default to seconds.
+	result.logLatencyMode, _ = strconv.Atoi(env["PUMPE_LOG_LATENCY_MODE"])
+
 	if on, _ := strconv.ParseBool(env["PUMPE_RANDOMISE_KINDS"]); on {
 		result.randomiseKinds = on
 	}
 
+	if on, _ := strconv.ParseBool(env["PUMPE_WARMUP_ON_CREATE"]); on {
+		result.warmupOnCreate = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_SET_RANDOM_NO_WAIT"]); on {
+		result.setRandomNoWait = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_FALLBACK_TO_DEFAULT"]); on {
+		result.fallbackToDefault = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_TOR_WARMUP_CHECK"]); on {
+		result.torWarmupCheck = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_WG_DEDUP"]); on {
+		result.wgDedup = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_WG_LISTEN_PORT"]); on {
+		result.wgListenPort = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_DISABLE_DIRECT"]); on {
+		result.disableDirect = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_TOR_OVER_WIREGUARD"]); on {
+		result.torOverWG = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_TOR_OPTIONAL"]); on {
+		result.torOptional = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_HTTP_STRIP_USER_AGENT"]); on {
+		result.stripUserAgent = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_BLOCK_PRIVATE_DESTS"]); on {
+		result.blockPrivateDests = on
+	}
+
+	if on, _ := strconv.ParseBool(env["PUMPE_CONTROL_ONLY"]); on {
+		result.controlOnly = on
+	}
+
 	if result.port == "" {
 		result.port = "8080"
 	}
@@ -300,6 +936,162 @@ func newSettingsFromEnv(env map[string]string) settings {
 	return result
 }
 
+const errInvalidPort model.Error = "invalid port"
+
+// parsePorts parses ports, each of which must be in the range 1-65535.
+func parsePorts(raw []string) ([]int, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	result := make([]int, 0, len(raw))
+
+	for i := range raw {
+		port, err := strconv.Atoi(raw[i])
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("%w: %q", errInvalidPort, raw[i])
+		}
+
+		result = append(result, port)
+	}
+
+	return result, nil
+}
+
+func parseList(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+
+	var result []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+func parsePrefixes(raw []string) ([]netip.Prefix, error) {
+	result := make([]netip.Prefix, 0, len(raw))
+
+	for i := range raw {
+		pfx, err := netip.ParsePrefix(raw[i])
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, pfx)
+	}
+
+	return result, nil
+}
+
+func parseAddrs(raw []string) ([]netip.Addr, error) {
+	result := make([]netip.Addr, 0, len(raw))
+
+	for i := range raw {
+		addr, err := netip.ParseAddr(raw[i])
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, addr)
+	}
+
+	return result, nil
+}
+
+const errInvalidKindWeight model.Error = "invalid kind weight"
+
+// parseKindWeights parses kind=weight pairs, e.g. tor=3.
+//
+// Kinds with a zero weight are left out, so an empty result means the default split.
+func parseKindWeights(raw []string) (map[gate.Kind]int, error) {
+	result := make(map[gate.Kind]int, len(raw))
+
+	for i := range raw {
+		rkind, rweight, ok := strings.Cut(raw[i], "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", errInvalidKindWeight, raw[i])
+		}
+
+		kind, err := gate.ParseKind(strings.TrimSpace(rkind))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", errInvalidKindWeight, raw[i])
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(rweight))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("%w: %q", errInvalidKindWeight, raw[i])
+		}
+
+		if weight > 0 {
+			result[kind] = weight
+		}
+	}
+
+	return result, nil
+}
+
+const errInvalidWarmupURL model.Error = "invalid warmup url"
+
+// checkWarmupURLs reports the first of raw that is not an absolute http or https URL.
+func checkWarmupURLs(raw []string) error {
+	for i := range raw {
+		u, err := url.Parse(raw[i])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: %q", errInvalidWarmupURL, raw[i])
+		}
+	}
+
+	return nil
+}
+
+// parseKindSet parses a list of kinds, e.g. tor,openvpn.
+func parseKindSet(raw []string) (map[gate.Kind]bool, error) {
+	result := make(map[gate.Kind]bool, len(raw))
+
+	for i := range raw {
+		kind, err := gate.ParseKind(raw[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", err, raw[i])
+		}
+
+		result[kind] = true
+	}
+
+	return result, nil
+}
+
+// missingWeightedKind returns the first kind with a weight that is not available.
+func missingWeightedKind(weights map[gate.Kind]int, avail map[gate.Kind]bool) (gate.Kind, bool) {
+	kinds := []gate.Kind{gate.KindDirect, gate.KindTor, gate.KindWireGuard, gate.KindOpenVPN, gate.KindTorWG}
+
+	for i := range kinds {
+		if weights[kinds[i]] > 0 && !avail[kinds[i]] {
+			return kinds[i], true
+		}
+	}
+
+	return gate.KindUnknown, false
+}
+
+// newDirects returns a direct gate for each of laddrs, or the default one when laddrs is empty.
+func newDirects(laddrs []netip.Addr, tout time.Duration) []*gate.Direct {
+	if len(laddrs) == 0 {
+		return []*gate.Direct{gate.NewDirect(tout)}
+	}
+
+	result := make([]*gate.Direct, 0, len(laddrs))
+	for i := range laddrs {
+		result = append(result, gate.NewDirectFrom(laddrs[i], tout))
+	}
+
+	return result
+}
+
 func newLogger(w io.Writer, rawLvl, format string, addSrc bool) *slog.Logger {
 	var lvl slog.Level
 	_ = lvl.UnmarshalText([]byte(rawLvl))
@@ -320,6 +1112,11 @@ func newLogger(w io.Writer, rawLvl, format string, addSrc bool) *slog.Logger {
 }
 
 func handleWGParseErr(ctx context.Context, lg *slog.Logger, mode int, err error) error {
+	// A misconfigured path is not a parsing error, and must not be ignored.
+	if errors.Is(err, gate.ErrWGDirNotDir) || errors.Is(err, gate.ErrWGDirUnreadable) {
+		return err
+	}
+
 	switch gate.WGParseMode(mode) {
 	case gate.WGParseModeReport:
 		errs := model.UnwrapErrs(err)
@@ -342,6 +1139,147 @@ func handleWGParseErr(ctx context.Context, lg *slog.Logger, mode int, err error)
 	}
 }
 
+type reloader interface {
+	Reload(ctx context.Context) (*gate.ReloadResult, error)
+	WarmupIDs(ctx context.Context, ids []uuid.UUID) error
+}
+
+// requiresTor reports whether the service cannot serve requests without Tor gates.
+func requiresTor(dkind gate.Kind, randomise bool, weights map[gate.Kind]int) bool {
+	if dkind == gate.KindTor || dkind == gate.KindTorWG {
+		return true
+	}
+
+	return randomise && (len(weights) == 0 || weights[gate.KindTor] > 0 || weights[gate.KindTorWG] > 0)
+}
+
+// summaryAttrs describes the effective configuration and the number of gates started.
+//
+// Only settings that are safe to log are included.
+func summaryAttrs(cfg settings, dkind gate.Kind, ntor, nwg int) []slog.Attr {
+	result := []slog.Attr{
+		slog.String("default_kind", dkind.String()),
+		slog.Int("tor.count", ntor),
+		slog.Int("tor.max", cfg.torMax),
+		slog.Int("tor.api_max", cfg.torAPIMax),
+		slog.Bool("tor.optional", cfg.torOptional),
+		slog.Bool("tor.over_wireguard", cfg.torOverWG),
+		slog.Int("wireguard.count", nwg),
+		slog.Bool("direct.disabled", cfg.disableDirect),
+		slog.Int("direct.local_addrs", len(cfg.directAddrs)),
+		slog.Bool("randomise_kinds", cfg.randomiseKinds),
+		slog.String("port", cfg.port),
+		slog.Bool("control_only", cfg.controlOnly),
+		slog.Int("max_dial_retries", cfg.maxDialRetries),
+		slog.Int("max_tunnels", cfg.maxTunnels),
+		slog.Bool("block_private_dests", cfg.blockPrivateDests),
+		slog.Int("warmup.mode", cfg.warmupMode),
+		slog.Int("warmup.retries", cfg.warmupRetries),
+		slog.String("warmup.skip_kinds", strings.Join(cfg.warmupSkipKinds, ",")),
+		slog.String("warmup.urls", strings.Join(cfg.warmupURLs, ",")),
+		slog.Duration("timeout.http_client", cfg.httpClientTimeout),
+		slog.Duration("timeout.tor_startup", cfg.torStartupTimeout),
+		slog.Duration("timeout.dial", cfg.dialTimeout),
+		slog.Duration("timeout.shutdown", cfg.shutdownTimeout),
+		slog.Duration("timeout.drain_grace", cfg.drainGrace),
+		slog.Duration("timeout.server_read_header", cfg.srvReadHeaderTimeout),
+		slog.Duration("timeout.server_read", cfg.srvReadTimeout),
+		slog.Duration("timeout.server_write", cfg.srvWriteTimeout),
+		slog.Duration("timeout.server_idle", cfg.srvIdleTimeout),
+	}
+
+	return result
+}
+
+// parseWGConfigs parses WireGuard configs from the combined file if set, or from the directory otherwise.
+func parseWGConfigs(cfg settings) ([]*gate.WGConfig, error) {
+	mode := gate.WGParseMode(cfg.wgParseMode)
+	amode := gate.WGAllowedIPsMode(cfg.wgAllowedIPsMode)
+
+	if cfg.wgFile != "" {
+		return gate.ParseWGConfigsFile(mode, amode, cfg.wgFile)
+	}
+
+	return gate.ParseWGConfigs(mode, amode, cfg.wgDir)
+}
+
+// newTors starts Tor gates, chaining them over wgs when configured.
+func newTors(ctx context.Context, cfg settings, scfg *gate.SetConfig, wgs []*gate.WireGuard) ([]*gate.Tor, error) {
+	if cfg.torOverWG {
+		return gate.NewTorsOverWireGuard(ctx, scfg.Tor, cfg.torStartupTimeout, scfg.HTTPTimeoutFor(gate.KindTor), cfg.torN, wgs)
+	}
+
+	return gate.NewTors(ctx, scfg.Tor, cfg.torStartupTimeout, scfg.HTTPTimeoutFor(gate.KindTor), cfg.torN)
+}
+
+func reloadOnSignal(ctx context.Context, lg *slog.Logger, sigc <-chan os.Signal, rl reloader, tout time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-sigc:
+			reloadWithTimeout(ctx, lg, rl, tout)
+		}
+	}
+}
+
+// reloadWithTimeout reloads gates, warms up the added ones, and logs the outcome.
+//
+// Errors are never fatal, the running server must not be affected.
+func reloadWithTimeout(ctx context.Context, lg *slog.Logger, rl reloader, tout time.Duration) {
+	rctx, cancel := context.WithTimeout(ctx, tout)
+	defer cancel()
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "reloading gates")
+
+	res, err := rl.Reload(rctx)
+	if res == nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to reload gates", slog.Any("error", err))
+
+		return
+	}
+
+	if err != nil {
+		lg.LogAttrs(ctx, slog.LevelWarn, "reloaded gates with errors", slog.Any("error", err))
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "reloaded gates", slog.Int("added", len(res.Added)), slog.Int("removed", len(res.Removed)), slog.Int("unchanged", res.Unchanged))
+
+	if err := rl.WarmupIDs(rctx, res.Added); err != nil {
+		lg.LogAttrs(ctx, slog.LevelWarn, "failed to warm up gates", slog.Any("error", err))
+
+		return
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "warmed up gates")
+}
+
+type pauser interface {
+	Pause(ctx context.Context) error
+}
+
+// newDrainFunc returns a func that pauses routing of new requests, and waits for grace, or until ctx is done.
+//
+// Paused requests are refused with 503, which lets clients retry elsewhere during a rolling deploy.
+func newDrainFunc(p pauser, grace time.Duration) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if err := p.Pause(ctx); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+
+		return nil
+	}
+}
+
 func callFuncsCtxErr(ctx context.Context, fns <-chan func(context.Context) error) error {
 	var errs []error
 	for fn := range fns {
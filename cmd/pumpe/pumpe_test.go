@@ -3,17 +3,71 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	should "github.com/stretchr/testify/assert"
 	must "github.com/stretchr/testify/require"
 
+	"github.com/pavelbrm/pumpe/gate"
 	"github.com/pavelbrm/pumpe/model"
 )
 
+func TestIsCheckOnly(t *testing.T) {
+	tests := []struct {
+		name  string
+		given []string
+		exp   bool
+	}{
+		{
+			name: "nil",
+		},
+
+		{
+			name:  "program_only",
+			given: []string{"pumpe"},
+		},
+
+		{
+			name:  "program_named_check",
+			given: []string{"--check"},
+		},
+
+		{
+			name:  "other_args",
+			given: []string{"pumpe", "--verbose"},
+		},
+
+		{
+			name:  "double_dash",
+			given: []string{"pumpe", "--check"},
+			exp:   true,
+		},
+
+		{
+			name:  "single_dash",
+			given: []string{"pumpe", "-v", "-check"},
+			exp:   true,
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual := isCheckOnly(tests[i].given)
+			should.Equal(t, tests[i].exp, actual)
+		})
+	}
+}
+
 func TestRawEnvToMap(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -21,181 +75,1089 @@ func TestRawEnvToMap(t *testing.T) {
 		exp   map[string]string
 	}{
 		{
-			name: "nil",
+			name: "nil",
+		},
+
+		{
+			name:  "empty",
+			given: []string{},
+			exp:   map[string]string{},
+		},
+
+		{
+			name: "incomplete_inputs",
+			given: []string{
+				"TEST_SOME_KEY_01=",
+				"TEST_SOME_KEY_02",
+				"TEST_SOME_KEY_03/some_val_03",
+				"TEST_SOME_KEY_04==",
+			},
+			exp: map[string]string{
+				"TEST_SOME_KEY_01": "",
+				"TEST_SOME_KEY_04": "=",
+			},
+		},
+
+		{
+			name: "valid",
+			given: []string{
+				"TEST_SOME_KEY_01=some_val_01",
+				"TEST_SOME_KEY_02=some_val_02",
+				"TEST_SOME_KEY_03=some_val_03",
+			},
+			exp: map[string]string{
+				"TEST_SOME_KEY_01": "some_val_01",
+				"TEST_SOME_KEY_02": "some_val_02",
+				"TEST_SOME_KEY_03": "some_val_03",
+			},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual := rawEnvToMap(tests[i].given)
+			should.Equal(t, tests[i].exp, actual)
+		})
+	}
+}
+
+func TestReadDotEnvFile(t *testing.T) {
+	t.Run("empty_path", func(t *testing.T) {
+		actual, err := readDotEnvFile("")
+		must.Equal(t, nil, err)
+
+		should.Equal(t, map[string]string(nil), actual)
+	})
+
+	t.Run("error_not_found", func(t *testing.T) {
+		_, err := readDotEnvFile(filepath.Join(t.TempDir(), "missing.env"))
+		should.Equal(t, true, errors.Is(err, fs.ErrNotExist))
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		fpath := filepath.Join(t.TempDir(), ".env")
+
+		err := os.WriteFile(fpath, []byte("PUMPE_PORT=8081\nPUMPE_TOR_NUM=2\n"), 0o600)
+		must.Equal(t, nil, err)
+
+		actual, err := readDotEnvFile(fpath)
+		must.Equal(t, nil, err)
+
+		should.Equal(t, map[string]string{"PUMPE_PORT": "8081", "PUMPE_TOR_NUM": "2"}, actual)
+	})
+}
+
+func TestParseDotEnv(t *testing.T) {
+	type tcExpected struct {
+		val map[string]string
+		err error
+	}
+
+	tests := []struct {
+		name  string
+		given string
+		exp   tcExpected
+	}{
+		{
+			name:  "empty",
+			given: "",
+			exp: tcExpected{
+				val: map[string]string{},
+			},
+		},
+
+		{
+			name:  "error_no_separator",
+			given: "PUMPE_PORT=8081\nPUMPE_TOR_NUM\n",
+			exp: tcExpected{
+				err: fmt.Errorf("%w: line %d", errInvalidDotEnvLine, 2),
+			},
+		},
+
+		{
+			name:  "error_empty_key",
+			given: "=8081\n",
+			exp: tcExpected{
+				err: fmt.Errorf("%w: line %d", errInvalidDotEnvLine, 1),
+			},
+		},
+
+		{
+			name:  "error_unterminated_quote",
+			given: "PUMPE_HTTP_USER_AGENT=\"Mozilla/5.0\n",
+			exp: tcExpected{
+				err: fmt.Errorf("%w: line %d", errInvalidDotEnvLine, 1),
+			},
+		},
+
+		{
+			name: "valid",
+			given: strings.Join([]string{
+				"# Local settings.",
+				"",
+				"PUMPE_PORT=8081",
+				"export PUMPE_TOR_NUM=2",
+				"  PUMPE_WG_DIR = ./.wg  ",
+				"PUMPE_LOG_LEVEL=DEBUG # Verbose.",
+				"PUMPE_HTTP_USER_AGENT=\"Mozilla/5.0 (X11; Linux x86_64)\"",
+				"PUMPE_DEFAULT_KIND='direct' # Quoted.",
+				"PUMPE_WG_DNS=",
+				"PUMPE_CONNECT_ALLOWED_PORTS=443,8443",
+			}, "\n"),
+			exp: tcExpected{
+				val: map[string]string{
+					"PUMPE_PORT":                  "8081",
+					"PUMPE_TOR_NUM":               "2",
+					"PUMPE_WG_DIR":                "./.wg",
+					"PUMPE_LOG_LEVEL":             "DEBUG",
+					"PUMPE_HTTP_USER_AGENT":       "Mozilla/5.0 (X11; Linux x86_64)",
+					"PUMPE_DEFAULT_KIND":          "direct",
+					"PUMPE_WG_DNS":                "",
+					"PUMPE_CONNECT_ALLOWED_PORTS": "443,8443",
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual, err := parseDotEnv(strings.NewReader(tests[i].given))
+			must.Equal(t, tests[i].exp.err, err)
+
+			should.Equal(t, tests[i].exp.val, actual)
+		})
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	type tcGiven struct {
+		base map[string]string
+		over map[string]string
+	}
+
+	tests := []struct {
+		name  string
+		given tcGiven
+		exp   map[string]string
+	}{
+		{
+			name: "nil_base",
+			given: tcGiven{
+				over: map[string]string{"PUMPE_PORT": "8080"},
+			},
+			exp: map[string]string{"PUMPE_PORT": "8080"},
+		},
+
+		{
+			name: "nil_over",
+			given: tcGiven{
+				base: map[string]string{"PUMPE_PORT": "8081"},
+			},
+			exp: map[string]string{"PUMPE_PORT": "8081"},
+		},
+
+		{
+			name: "over_takes_precedence",
+			given: tcGiven{
+				base: map[string]string{"PUMPE_PORT": "8081", "PUMPE_TOR_NUM": "2"},
+				over: map[string]string{"PUMPE_PORT": "8080", "PUMPE_WG_DIR": "./.wg"},
+			},
+			exp: map[string]string{"PUMPE_PORT": "8080", "PUMPE_TOR_NUM": "2", "PUMPE_WG_DIR": "./.wg"},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual := mergeEnv(tests[i].given.base, tests[i].given.over)
+			should.Equal(t, tests[i].exp, actual)
+		})
+	}
+}
+
+func TestNewSettingsFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		given map[string]string
+		exp   settings
+	}{
+		{
+			name:  "defaults",
+			given: map[string]string{},
+			exp: settings{
+				shutdownTimeout:      30 * time.Second,
+				shutdownTimeoutMax:   60 * time.Second,
+				httpClientTimeout:    60 * time.Second,
+				setRandomLoopTimeout: 30 * time.Second,
+				setRandomLoopDelay:   10 * time.Millisecond,
+				setStateLoopTimeout:  30 * time.Second,
+				setStateLoopDelay:    10 * time.Millisecond,
+				torStartupTimeout:    3 * time.Minute,
+				ovpnStartupTimeout:   60 * time.Second,
+				reloadTimeout:        60 * time.Second,
+				failCooldown:         60 * time.Second,
+				srvReadHeaderTimeout: 10 * time.Second,
+				srvIdleTimeout:       120 * time.Second,
+				torN:                 4,
+				torMax:               128,
+				torMin:               1,
+				maxHeaderCount:       100,
+				maxHeaderBytes:       65536,
+				maxBodyBytes:         33554432,
+				logSampleN:           1,
+				defKind:              "tor",
+				wgDNS:                "9.9.9.9",
+				port:                 "8080",
+				logLvl:               "INFO",
+				logFmt:               "json",
+			},
+		},
+
+		{
+			name: "configured",
+			given: map[string]string{
+				"PUMPE_SHUTDOWN_TIMEOUT":           "29s",
+				"PUMPE_SHUTDOWN_TIMEOUT_MAX":       "2m",
+				"PUMPE_HTTP_CLIENT_TIMEOUT":        "59s",
+				"PUMPE_TOR_HTTP_CLIENT_TIMEOUT":    "2m",
+				"PUMPE_WG_HTTP_CLIENT_TIMEOUT":     "30s",
+				"PUMPE_DIRECT_HTTP_CLIENT_TIMEOUT": "10s",
+				"PUMPE_SET_RANDOM_LOOP_TIMEOUT":    "29s",
+				"PUMPE_SET_RANDOM_LOOP_DELAY":      "11ms",
+				"PUMPE_SET_STATE_LOOP_TIMEOUT":     "29s",
+				"PUMPE_SET_STATE_LOOP_DELAY":       "11ms",
+				"PUMPE_TOR_STARTUP_TIMEOUT":        "4m",
+				"PUMPE_OVPN_STARTUP_TIMEOUT":       "90s",
+				"PUMPE_OVPN_DIR":                   "/tmp/ovpn",
+				"PUMPE_TOR_TORRC_FILE":             "/etc/pumpe/torrc",
+				"PUMPE_TOR_CONTROL_PASSWORD":       "secret",
+				"PUMPE_RELOAD_TIMEOUT":             "30s",
+				"PUMPE_GATE_FAIL_THRESHOLD":        "3",
+				"PUMPE_GATE_FAIL_COOLDOWN":         "5m",
+				"PUMPE_TOR_MAX_AGE":                "6h",
+				"PUMPE_TOR_CHECK_INTERVAL":         "30s",
+				"PUMPE_TOR_NUM":                    "16",
+				"PUMPE_TOR_MAX":                    "64",
+				"PUMPE_TOR_API_MAX":                "16",
+				"PUMPE_TOR_MIN":                    "2",
+				"PUMPE_WG_PARSE_MODE":              "2",
+				"PUMPE_WG_ALLOWED_IPS_MODE":        "1",
+				"PUMPE_HTTP_XFF_MODE":              "1",
+				"PUMPE_WARMUP_MODE":                "1",
+				"PUMPE_WARMUP_ADDR":                "10.0.0.1:443",
+				"PUMPE_DEFAULT_KIND":               "direct",
+				"PUMPE_WG_DIR":                     "/tmp/wg-ini",
+				"PUMPE_WG_FILE":                    "/tmp/wg.conf",
+				"PUMPE_WG_DNS":                     "1.1.1.1",
+				"PUMPE_PORT":                       "8081",
+				"PUMPE_LOG_LEVEL":                  "DEBUG",
+				"PUMPE_LOG_FORMAT":                 "text",
+				"PUMPE_HTTP_USER_AGENT":            "Mozilla/5.0",
+				"PUMPE_CONNECT_RESPONSE_LINE":      "HTTP/1.0 200 OK",
+				"PUMPE_HTTP_STRIP_USER_AGENT":      "true",
+				"PUMPE_CONTROL_ONLY":               "true",
+				"PUMPE_RANDOMISE_KINDS":            "true",
+				"PUMPE_WARMUP_ON_CREATE":           "true",
+				"PUMPE_SET_RANDOM_NO_WAIT":         "true",
+				"PUMPE_FALLBACK_TO_DEFAULT":        "true",
+				"PUMPE_TOR_WARMUP_CHECK":           "true",
+				"PUMPE_WG_DEDUP":                   "true",
+				"PUMPE_WG_LISTEN_PORT":             "true",
+				"PUMPE_LOG_ADD_SOURCE":             "true",
+				"PUMPE_CONNECT_ALLOWED_PORTS":      "443, 8443",
+				"PUMPE_DIRECT_LOCAL_ADDRS":         "192.0.2.1, 192.0.2.2",
+				"PUMPE_ALLOWED_PRIVATE_DESTS":      "10.1.0.0/16, fd00::/8",
+				"PUMPE_BLOCK_PRIVATE_DESTS":        "true",
+				"PUMPE_RANDOM_KIND_WEIGHTS":        "tor=3, direct=1",
+				"PUMPE_WARMUP_SKIP_KINDS":          "tor, openvpn",
+				"PUMPE_WARMUP_URLS":                "https://a.example.com/health, http://b.example.com",
+				"PUMPE_MAX_DIAL_RETRIES":           "2",
+				"PUMPE_WARMUP_RETRIES":             "3",
+				"PUMPE_WARMUP_RETRY_DELAY":         "2s",
+				"PUMPE_CONNECT_MAX_TUNNELS":        "512",
+				"PUMPE_DIAL_TIMEOUT":               "15s",
+				"PUMPE_CONNECT_HALF_CLOSE_TIMEOUT": "30s",
+				"PUMPE_SHUTDOWN_DRAIN_GRACE":       "5s",
+				"PUMPE_SERVER_READ_HEADER_TIMEOUT": "5s",
+				"PUMPE_SERVER_READ_TIMEOUT":        "1m",
+				"PUMPE_SERVER_WRITE_TIMEOUT":       "2m",
+				"PUMPE_SERVER_IDLE_TIMEOUT":        "90s",
+				"PUMPE_SET_LOOP_JITTER":            "5ms",
+				"PUMPE_HTTP_MAX_HEADER_COUNT":      "50",
+				"PUMPE_HTTP_MAX_HEADER_BYTES":      "32768",
+				"PUMPE_HTTP_MAX_BODY_BYTES":        "0",
+				"PUMPE_LOG_SAMPLE_N":               "10",
+				"PUMPE_LOG_LATENCY_MODE":           "2",
+			},
+			exp: settings{
+				shutdownTimeout:         29 * time.Second,
+				shutdownTimeoutReq:      29 * time.Second,
+				shutdownTimeoutMax:      2 * time.Minute,
+				httpClientTimeout:       59 * time.Second,
+				torHTTPClientTimeout:    2 * time.Minute,
+				wgHTTPClientTimeout:     30 * time.Second,
+				directHTTPClientTimeout: 10 * time.Second,
+				setRandomLoopTimeout:    29 * time.Second,
+				setRandomLoopDelay:      11 * time.Millisecond,
+				setStateLoopTimeout:     29 * time.Second,
+				setStateLoopDelay:       11 * time.Millisecond,
+				setLoopJitter:           5 * time.Millisecond,
+				torStartupTimeout:       4 * time.Minute,
+				ovpnStartupTimeout:      90 * time.Second,
+				ovpnDir:                 "/tmp/ovpn",
+				torrcFile:               "/etc/pumpe/torrc",
+				torControlPassword:      "secret",
+				reloadTimeout:           30 * time.Second,
+				failCooldown:            5 * time.Minute,
+				srvReadHeaderTimeout:    5 * time.Second,
+				srvReadTimeout:          time.Minute,
+				srvWriteTimeout:         2 * time.Minute,
+				srvIdleTimeout:          90 * time.Second,
+				torMaxAge:               6 * time.Hour,
+				torCheckInterval:        30 * time.Second,
+				dialTimeout:             15 * time.Second,
+				halfCloseTimeout:        30 * time.Second,
+				drainGrace:              5 * time.Second,
+				torN:                    16,
+				torMax:                  64,
+				torAPIMax:               16,
+				torMin:                  2,
+				logSampleN:              10,
+				logLatencyMode:          2,
+				wgParseMode:             2,
+				wgAllowedIPsMode:        1,
+				xffMode:                 1,
+				warmupMode:              1,
+				warmupRetries:           3,
+				warmupRetryDelay:        2 * time.Second,
+				maxDialRetries:          2,
+				maxTunnels:              512,
+				maxHeaderCount:          50,
+				maxHeaderBytes:          32768,
+				failThreshold:           3,
+				connectPorts:            []string{"443", "8443"},
+				directAddrs:             []string{"192.0.2.1", "192.0.2.2"},
+				allowedPrivateDests:     []string{"10.1.0.0/16", "fd00::/8"},
+				kindWeights:             []string{"tor=3", "direct=1"},
+				warmupSkipKinds:         []string{"tor", "openvpn"},
+				warmupURLs:              []string{"https://a.example.com/health", "http://b.example.com"},
+				defKind:                 "direct",
+				wgDir:                   "/tmp/wg-ini",
+				wgFile:                  "/tmp/wg.conf",
+				wgDNS:                   "1.1.1.1",
+				port:                    "8081",
+				logLvl:                  "DEBUG",
+				logFmt:                  "text",
+				userAgent:               "Mozilla/5.0",
+				connectResponseLine:     "HTTP/1.0 200 OK",
+				warmupAddr:              "10.0.0.1:443",
+				stripUserAgent:          true,
+				blockPrivateDests:       true,
+				controlOnly:             true,
+				randomiseKinds:          true,
+				warmupOnCreate:          true,
+				setRandomNoWait:         true,
+				fallbackToDefault:       true,
+				torWarmupCheck:          true,
+				wgDedup:                 true,
+				wgListenPort:            true,
+				logAddSrc:               true,
+			},
+		},
+
+		{
+			name: "configured_exceeding_limits",
+			given: map[string]string{
+				"PUMPE_SHUTDOWN_TIMEOUT":           "61s",
+				"PUMPE_SET_RANDOM_LOOP_TIMEOUT":    "61s",
+				"PUMPE_SET_RANDOM_LOOP_DELAY":      "101ms",
+				"PUMPE_SET_STATE_LOOP_TIMEOUT":     "61s",
+				"PUMPE_SET_STATE_LOOP_DELAY":       "101ms",
+				"PUMPE_TOR_STARTUP_TIMEOUT":        "1m",
+				"PUMPE_WG_DIR":                     "/tmp/wg-ini",
+				"PUMPE_MAX_DIAL_RETRIES":           "6",
+				"PUMPE_WARMUP_RETRIES":             "-1",
+				"PUMPE_WARMUP_RETRY_DELAY":         "-1s",
+				"PUMPE_TOR_MAX_AGE":                "-1h",
+				"PUMPE_TOR_CHECK_INTERVAL":         "-1s",
+				"PUMPE_TOR_API_MAX":                "-1",
+				"PUMPE_DIAL_TIMEOUT":               "-1s",
+				"PUMPE_CONNECT_HALF_CLOSE_TIMEOUT": "-1s",
+				"PUMPE_SHUTDOWN_DRAIN_GRACE":       "-1s",
+				"PUMPE_SERVER_READ_HEADER_TIMEOUT": "-1s",
+				"PUMPE_SERVER_READ_TIMEOUT":        "-1s",
+				"PUMPE_SERVER_WRITE_TIMEOUT":       "-1s",
+				"PUMPE_SERVER_IDLE_TIMEOUT":        "-1s",
+				"PUMPE_SET_LOOP_JITTER":            "-1ms",
+				"PUMPE_HTTP_MAX_BODY_BYTES":        "-1",
+				"PUMPE_CONNECT_MAX_TUNNELS":        "-1",
+				"PUMPE_LOG_SAMPLE_N":               "-5",
+			},
+			exp: settings{
+				shutdownTimeout:      60 * time.Second,
+				shutdownTimeoutReq:   61 * time.Second,
+				shutdownTimeoutMax:   60 * time.Second,
+				httpClientTimeout:    60 * time.Second,
+				setRandomLoopTimeout: 30 * time.Second,
+				setRandomLoopDelay:   10 * time.Millisecond,
+				setStateLoopTimeout:  30 * time.Second,
+				setStateLoopDelay:    10 * time.Millisecond,
+				torStartupTimeout:    3 * time.Minute,
+				ovpnStartupTimeout:   60 * time.Second,
+				reloadTimeout:        60 * time.Second,
+				failCooldown:         60 * time.Second,
+				srvReadHeaderTimeout: 10 * time.Second,
+				srvIdleTimeout:       120 * time.Second,
+				torN:                 4,
+				torMax:               128,
+				torMin:               1,
+				maxHeaderCount:       100,
+				maxHeaderBytes:       65536,
+				maxBodyBytes:         33554432,
+				logSampleN:           1,
+				defKind:              "tor",
+				wgDir:                "/tmp/wg-ini",
+				wgDNS:                "9.9.9.9",
+				port:                 "8080",
+				logLvl:               "INFO",
+				logFmt:               "json",
+			},
+		},
+
+		{
+			name: "configured_shutdown_timeout_above_default_max",
+			given: map[string]string{
+				"PUMPE_SHUTDOWN_TIMEOUT":     "2m",
+				"PUMPE_SHUTDOWN_TIMEOUT_MAX": "3m",
+			},
+			exp: settings{
+				shutdownTimeout:      2 * time.Minute,
+				shutdownTimeoutReq:   2 * time.Minute,
+				shutdownTimeoutMax:   3 * time.Minute,
+				httpClientTimeout:    60 * time.Second,
+				setRandomLoopTimeout: 30 * time.Second,
+				setRandomLoopDelay:   10 * time.Millisecond,
+				setStateLoopTimeout:  30 * time.Second,
+				setStateLoopDelay:    10 * time.Millisecond,
+				torStartupTimeout:    3 * time.Minute,
+				ovpnStartupTimeout:   60 * time.Second,
+				reloadTimeout:        60 * time.Second,
+				failCooldown:         60 * time.Second,
+				srvReadHeaderTimeout: 10 * time.Second,
+				srvIdleTimeout:       120 * time.Second,
+				torN:                 4,
+				torMax:               128,
+				torMin:               1,
+				maxHeaderCount:       100,
+				maxHeaderBytes:       65536,
+				maxBodyBytes:         33554432,
+				logSampleN:           1,
+				defKind:              "tor",
+				wgDNS:                "9.9.9.9",
+				port:                 "8080",
+				logLvl:               "INFO",
+				logFmt:               "json",
+			},
+		},
+
+		{
+			name: "direct_no_tor",
+			given: map[string]string{
+				"PUMPE_DEFAULT_KIND": "direct",
+				"PUMPE_TOR_NUM":      "0",
+				"PUMPE_TOR_OPTIONAL": "true",
+			},
+			exp: settings{
+				shutdownTimeout:      30 * time.Second,
+				shutdownTimeoutMax:   60 * time.Second,
+				httpClientTimeout:    60 * time.Second,
+				setRandomLoopTimeout: 30 * time.Second,
+				setRandomLoopDelay:   10 * time.Millisecond,
+				setStateLoopTimeout:  30 * time.Second,
+				setStateLoopDelay:    10 * time.Millisecond,
+				torStartupTimeout:    3 * time.Minute,
+				ovpnStartupTimeout:   60 * time.Second,
+				reloadTimeout:        60 * time.Second,
+				failCooldown:         60 * time.Second,
+				srvReadHeaderTimeout: 10 * time.Second,
+				srvIdleTimeout:       120 * time.Second,
+				torMax:               128,
+				torMin:               1,
+				maxHeaderCount:       100,
+				maxHeaderBytes:       65536,
+				maxBodyBytes:         33554432,
+				logSampleN:           1,
+				defKind:              "direct",
+				wgDNS:                "9.9.9.9",
+				port:                 "8080",
+				logLvl:               "INFO",
+				logFmt:               "json",
+				torOptional:          true,
+			},
+		},
+
+		{
+			name: "tor_over_wireguard_no_direct",
+			given: map[string]string{
+				"PUMPE_DISABLE_DIRECT":     "true",
+				"PUMPE_TOR_OVER_WIREGUARD": "true",
+			},
+			exp: settings{
+				shutdownTimeout:      30 * time.Second,
+				shutdownTimeoutMax:   60 * time.Second,
+				httpClientTimeout:    60 * time.Second,
+				setRandomLoopTimeout: 30 * time.Second,
+				setRandomLoopDelay:   10 * time.Millisecond,
+				setStateLoopTimeout:  30 * time.Second,
+				setStateLoopDelay:    10 * time.Millisecond,
+				torStartupTimeout:    3 * time.Minute,
+				ovpnStartupTimeout:   60 * time.Second,
+				reloadTimeout:        60 * time.Second,
+				failCooldown:         60 * time.Second,
+				srvReadHeaderTimeout: 10 * time.Second,
+				srvIdleTimeout:       120 * time.Second,
+				torN:                 4,
+				torMax:               128,
+				torMin:               1,
+				maxHeaderCount:       100,
+				maxHeaderBytes:       65536,
+				maxBodyBytes:         33554432,
+				logSampleN:           1,
+				defKind:              "tor",
+				wgDNS:                "9.9.9.9",
+				port:                 "8080",
+				logLvl:               "INFO",
+				logFmt:               "json",
+				disableDirect:        true,
+				torOverWG:            true,
+			},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual := newSettingsFromEnv(tests[i].given)
+			should.Equal(t, tests[i].exp, actual)
+		})
+	}
+}
+
+func TestRun_startupChecks(t *testing.T) {
+	wgDir := t.TempDir()
+
+	tests := []struct {
+		name  string
+		given settings
+		args  []string
+		exp   error
+	}{
+		{
+			name:  "error_no_wg_dir",
+			given: settings{defKind: "tor", torN: 4},
+			exp:   model.Error("invalid wireguard config directory"),
+		},
+
+		{
+			name:  "error_tor_default_no_gates",
+			given: settings{defKind: "tor", torN: -1, wgDir: wgDir},
+			exp:   model.Error("cannot start: unable to use tor as default without gates"),
+		},
+
+		{
+			name:  "error_wireguard_default_no_configs",
+			given: settings{defKind: "wireguard", wgDir: wgDir},
+			exp:   model.Error("cannot start: unable to use wireguard as default without configs"),
+		},
+
+		{
+			name:  "error_openvpn_default_no_configs",
+			given: settings{defKind: "openvpn", wgDir: wgDir, ovpnDir: wgDir},
+			exp:   model.Error("cannot start: unable to use openvpn as default without configs"),
+		},
+
+		{
+			name:  "error_direct_default_disabled",
+			given: settings{defKind: "direct", wgDir: wgDir, disableDirect: true},
+			exp:   model.Error("cannot start: unable to use direct as default when disabled"),
+		},
+
+		{
+			name:  "error_kind_weights_invalid",
+			given: settings{defKind: "direct", wgDir: wgDir, randomiseKinds: true, kindWeights: []string{"tor:1"}},
+			exp:   fmt.Errorf("cannot start: invalid random kind weights: %w", fmt.Errorf("%w: %q", errInvalidKindWeight, "tor:1")),
+		},
+
+		{
+			name:  "error_warmup_skip_kinds_invalid",
+			given: settings{defKind: "direct", wgDir: wgDir, warmupSkipKinds: []string{"socks"}},
+			exp:   fmt.Errorf("cannot start: invalid warmup skip kinds: %w", fmt.Errorf("%w: %q", gate.ErrKindUnknown, "socks")),
+		},
+
+		{
+			name:  "error_connect_ports_invalid",
+			given: settings{defKind: "direct", wgDir: wgDir, connectPorts: []string{"443", "https"}},
+			exp:   fmt.Errorf("cannot start: invalid connect allowed ports: %w", fmt.Errorf("%w: %q", errInvalidPort, "https")),
+		},
+
+		{
+			name:  "error_warmup_urls_invalid",
+			given: settings{defKind: "direct", wgDir: wgDir, warmupURLs: []string{"https://a.example.com/health", "example.com"}},
+			exp:   fmt.Errorf("cannot start: invalid warmup urls: %w", fmt.Errorf("%w: %q", errInvalidWarmupURL, "example.com")),
+		},
+
+		{
+			name:  "error_connect_response_line_invalid",
+			given: settings{defKind: "direct", wgDir: wgDir, connectResponseLine: "HTTP/2 200 OK"},
+			exp:   fmt.Errorf("cannot start: invalid connect response line: %w", gate.ErrInvalidConnectResp),
+		},
+
+		{
+			name:  "error_randomise_kinds_no_wireguard",
+			given: settings{defKind: "direct", wgDir: wgDir, randomiseKinds: true},
+			exp:   model.Error("cannot start: unable to randomise kinds without both configured"),
+		},
+
+		{
+			name:  "error_randomise_kinds_weighted_direct_disabled",
+			given: settings{defKind: "tor", torN: 4, wgDir: wgDir, disableDirect: true, randomiseKinds: true, kindWeights: []string{"tor=3", "direct=1"}},
+			exp:   fmt.Errorf("cannot start: unable to randomise kinds without %s gates", gate.KindDirect),
+		},
+
+		{
+			name:  "error_direct_local_addr",
+			given: settings{defKind: "direct", wgDir: wgDir, wgDNS: "9.9.9.9", directAddrs: []string{"192.0.2.300"}},
+			exp: func() error {
+				_, err := netip.ParseAddr("192.0.2.300")
+
+				return fmt.Errorf("cannot start: invalid direct local address: %w", err)
+			}(),
+		},
+
+		{
+			name:  "error_allowed_private_dest",
+			given: settings{defKind: "direct", wgDir: wgDir, wgDNS: "9.9.9.9", allowedPrivateDests: []string{"10.1.0.0"}},
+			exp: func() error {
+				_, err := netip.ParsePrefix("10.1.0.0")
+
+				return fmt.Errorf("cannot start: invalid allowed private destination: %w", err)
+			}(),
+		},
+
+		{
+			name:  "error_check_no_wg_dir",
+			given: settings{defKind: "tor", torN: 4},
+			args:  []string{"pumpe", "--check"},
+			exp:   model.Error("invalid wireguard config directory"),
+		},
+
+		{
+			name:  "error_check_connect_ports_invalid",
+			given: settings{defKind: "direct", wgDir: wgDir, connectPorts: []string{"0"}},
+			args:  []string{"pumpe", "--check"},
+			exp:   fmt.Errorf("cannot start: invalid connect allowed ports: %w", fmt.Errorf("%w: %q", errInvalidPort, "0")),
+		},
+
+		{
+			name:  "valid_check",
+			given: settings{defKind: "direct", wgDir: wgDir, wgDNS: "9.9.9.9"},
+			args:  []string{"pumpe", "--check"},
+		},
+	}
+
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual := run(context.Background(), lg, tests[i].given, tests[i].args)
+			should.Equal(t, tests[i].exp, actual)
+		})
+	}
+}
+
+func TestSummaryAttrs(t *testing.T) {
+	type tcGiven struct {
+		cfg   settings
+		dkind gate.Kind
+		ntor  int
+		nwg   int
+	}
+
+	tests := []struct {
+		name  string
+		given tcGiven
+		exp   []slog.Attr
+	}{
+		{
+			name: "valid",
+			given: tcGiven{
+				cfg: settings{
+					shutdownTimeout:      30 * time.Second,
+					httpClientTimeout:    60 * time.Second,
+					torStartupTimeout:    3 * time.Minute,
+					torMax:               128,
+					maxDialRetries:       2,
+					maxTunnels:           512,
+					warmupMode:           1,
+					warmupRetries:        2,
+					warmupSkipKinds:      []string{"tor", "wireguard"},
+					warmupURLs:           []string{"https://a.example.com/health", "https://b.example.com/health"},
+					srvReadHeaderTimeout: 10 * time.Second,
+					srvIdleTimeout:       2 * time.Minute,
+					port:                 "8080",
+					userAgent:            "Mozilla/5.0",
+					randomiseKinds:       true,
+					torOptional:          true,
+				},
+				dkind: gate.KindTor,
+				ntor:  4,
+				nwg:   2,
+			},
+			exp: []slog.Attr{
+				slog.String("default_kind", "tor"),
+				slog.Int("tor.count", 4),
+				slog.Int("tor.max", 128),
+				slog.Int("tor.api_max", 0),
+				slog.Bool("tor.optional", true),
+				slog.Bool("tor.over_wireguard", false),
+				slog.Int("wireguard.count", 2),
+				slog.Bool("direct.disabled", false),
+				slog.Int("direct.local_addrs", 0),
+				slog.Bool("randomise_kinds", true),
+				slog.String("port", "8080"),
+				slog.Bool("control_only", false),
+				slog.Int("max_dial_retries", 2),
+				slog.Int("max_tunnels", 512),
+				slog.Bool("block_private_dests", false),
+				slog.Int("warmup.mode", 1),
+				slog.Int("warmup.retries", 2),
+				slog.String("warmup.skip_kinds", "tor,wireguard"),
+				slog.String("warmup.urls", "https://a.example.com/health,https://b.example.com/health"),
+				slog.Duration("timeout.http_client", 60*time.Second),
+				slog.Duration("timeout.tor_startup", 3*time.Minute),
+				slog.Duration("timeout.dial", 0),
+				slog.Duration("timeout.shutdown", 30*time.Second),
+				slog.Duration("timeout.drain_grace", 0),
+				slog.Duration("timeout.server_read_header", 10*time.Second),
+				slog.Duration("timeout.server_read", 0),
+				slog.Duration("timeout.server_write", 0),
+				slog.Duration("timeout.server_idle", 2*time.Minute),
+			},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual := summaryAttrs(tests[i].given.cfg, tests[i].given.dkind, tests[i].given.ntor, tests[i].given.nwg)
+			should.Equal(t, tests[i].exp, actual)
+		})
+	}
+}
+
+func TestRequiresTor(t *testing.T) {
+	type tcGiven struct {
+		dkind     gate.Kind
+		randomise bool
+		weights   map[gate.Kind]int
+	}
+
+	tests := []struct {
+		name  string
+		given tcGiven
+		exp   bool
+	}{
+		{
+			name:  "tor_default",
+			given: tcGiven{dkind: gate.KindTor},
+			exp:   true,
+		},
+
+		{
+			name:  "tor_wireguard_default",
+			given: tcGiven{dkind: gate.KindTorWG},
+			exp:   true,
+		},
+
+		{
+			name:  "randomise_kinds",
+			given: tcGiven{dkind: gate.KindWireGuard, randomise: true},
+			exp:   true,
+		},
+
+		{
+			name:  "randomise_kinds_weighted_tor_wireguard",
+			given: tcGiven{dkind: gate.KindWireGuard, randomise: true, weights: map[gate.Kind]int{gate.KindTorWG: 1, gate.KindDirect: 1}},
+			exp:   true,
+		},
+
+		{
+			name:  "randomise_kinds_weighted_tor",
+			given: tcGiven{dkind: gate.KindWireGuard, randomise: true, weights: map[gate.Kind]int{gate.KindTor: 1, gate.KindDirect: 1}},
+			exp:   true,
+		},
+
+		{
+			name:  "randomise_kinds_weighted_no_tor",
+			given: tcGiven{dkind: gate.KindWireGuard, randomise: true, weights: map[gate.Kind]int{gate.KindWireGuard: 1, gate.KindDirect: 1}},
+		},
+
+		{
+			name:  "wireguard_default",
+			given: tcGiven{dkind: gate.KindWireGuard},
+		},
+
+		{
+			name:  "direct_default",
+			given: tcGiven{dkind: gate.KindDirect},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual := requiresTor(tests[i].given.dkind, tests[i].given.randomise, tests[i].given.weights)
+			should.Equal(t, tests[i].exp, actual)
+		})
+	}
+}
+
+func TestParseKindWeights(t *testing.T) {
+	type tcExpected struct {
+		val map[gate.Kind]int
+		err error
+	}
+
+	tests := []struct {
+		name  string
+		given []string
+		exp   tcExpected
+	}{
+		{
+			name: "empty",
+			exp: tcExpected{
+				val: map[gate.Kind]int{},
+			},
+		},
+
+		{
+			name:  "error_no_separator",
+			given: []string{"tor"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %q", errInvalidKindWeight, "tor"),
+			},
+		},
+
+		{
+			name:  "error_unknown_kind",
+			given: []string{"socks=1"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %q", errInvalidKindWeight, "socks=1"),
+			},
+		},
+
+		{
+			name:  "error_invalid_weight",
+			given: []string{"tor=many"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %q", errInvalidKindWeight, "tor=many"),
+			},
+		},
+
+		{
+			name:  "error_negative_weight",
+			given: []string{"tor=-1"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %q", errInvalidKindWeight, "tor=-1"),
+			},
+		},
+
+		{
+			name:  "valid",
+			given: []string{"tor=3", " direct = 1", "wireguard=0"},
+			exp: tcExpected{
+				val: map[gate.Kind]int{gate.KindTor: 3, gate.KindDirect: 1},
+			},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual, err := parseKindWeights(tests[i].given)
+			must.Equal(t, tests[i].exp.err, err)
+
+			should.Equal(t, tests[i].exp.val, actual)
+		})
+	}
+}
+
+func TestParseKindSet(t *testing.T) {
+	type tcExpected struct {
+		val map[gate.Kind]bool
+		err error
+	}
+
+	tests := []struct {
+		name  string
+		given []string
+		exp   tcExpected
+	}{
+		{
+			name: "empty",
+			exp: tcExpected{
+				val: map[gate.Kind]bool{},
+			},
+		},
+
+		{
+			name:  "error_unknown_kind",
+			given: []string{"tor", "socks"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %q", gate.ErrKindUnknown, "socks"),
+			},
 		},
 
 		{
-			name:  "empty",
-			given: []string{},
-			exp:   map[string]string{},
+			name:  "valid",
+			given: []string{"tor", "openvpn", "tor"},
+			exp: tcExpected{
+				val: map[gate.Kind]bool{gate.KindTor: true, gate.KindOpenVPN: true},
+			},
 		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual, err := parseKindSet(tests[i].given)
+			must.Equal(t, tests[i].exp.err, err)
+
+			should.Equal(t, tests[i].exp.val, actual)
+		})
+	}
+}
+
+func TestMissingWeightedKind(t *testing.T) {
+	type tcGiven struct {
+		weights map[gate.Kind]int
+		avail   map[gate.Kind]bool
+	}
+
+	type tcExpected struct {
+		kind gate.Kind
+		ok   bool
+	}
 
+	tests := []struct {
+		name  string
+		given tcGiven
+		exp   tcExpected
+	}{
 		{
-			name: "incomplete_inputs",
-			given: []string{
-				"TEST_SOME_KEY_01=",
-				"TEST_SOME_KEY_02",
-				"TEST_SOME_KEY_03/some_val_03",
-				"TEST_SOME_KEY_04==",
+			name: "empty",
+			exp: tcExpected{
+				kind: gate.KindUnknown,
 			},
-			exp: map[string]string{
-				"TEST_SOME_KEY_01": "",
-				"TEST_SOME_KEY_04": "=",
+		},
+
+		{
+			name: "all_available",
+			given: tcGiven{
+				weights: map[gate.Kind]int{gate.KindTor: 3, gate.KindDirect: 1},
+				avail:   map[gate.Kind]bool{gate.KindTor: true, gate.KindDirect: true},
+			},
+			exp: tcExpected{
+				kind: gate.KindUnknown,
 			},
 		},
 
 		{
-			name: "valid",
-			given: []string{
-				"TEST_SOME_KEY_01=some_val_01",
-				"TEST_SOME_KEY_02=some_val_02",
-				"TEST_SOME_KEY_03=some_val_03",
+			name: "missing_wireguard",
+			given: tcGiven{
+				weights: map[gate.Kind]int{gate.KindTor: 3, gate.KindWireGuard: 1},
+				avail:   map[gate.Kind]bool{gate.KindTor: true, gate.KindDirect: true},
 			},
-			exp: map[string]string{
-				"TEST_SOME_KEY_01": "some_val_01",
-				"TEST_SOME_KEY_02": "some_val_02",
-				"TEST_SOME_KEY_03": "some_val_03",
+			exp: tcExpected{
+				kind: gate.KindWireGuard,
+				ok:   true,
 			},
 		},
 	}
 
 	for i := range tests {
 		t.Run(tests[i].name, func(t *testing.T) {
-			actual := rawEnvToMap(tests[i].given)
-			should.Equal(t, tests[i].exp, actual)
+			kind, ok := missingWeightedKind(tests[i].given.weights, tests[i].given.avail)
+			should.Equal(t, tests[i].exp.kind, kind)
+			should.Equal(t, tests[i].exp.ok, ok)
 		})
 	}
 }
 
-func TestNewSettingsFromEnv(t *testing.T) {
+func TestParsePorts(t *testing.T) {
+	type tcExpected struct {
+		val []int
+		err error
+	}
+
 	tests := []struct {
 		name  string
-		given map[string]string
-		exp   settings
+		given []string
+		exp   tcExpected
 	}{
 		{
-			name:  "defaults",
-			given: map[string]string{},
-			exp: settings{
-				shutdownTimeout:      30 * time.Second,
-				httpClientTimeout:    60 * time.Second,
-				setRandomLoopTimeout: 30 * time.Second,
-				setRandomLoopDelay:   10 * time.Millisecond,
-				setStateLoopTimeout:  30 * time.Second,
-				setStateLoopDelay:    10 * time.Millisecond,
-				torStartupTimeout:    3 * time.Minute,
-				torN:                 4,
-				torMax:               128,
-				defKind:              "tor",
-				wgDNS:                "9.9.9.9",
-				port:                 "8080",
-				logLvl:               "INFO",
-				logFmt:               "json",
-			},
+			name: "empty",
 		},
 
 		{
-			name: "configured",
-			given: map[string]string{
-				"PUMPE_SHUTDOWN_TIMEOUT":        "29s",
-				"PUMPE_HTTP_CLIENT_TIMEOUT":     "59s",
-				"PUMPE_SET_RANDOM_LOOP_TIMEOUT": "29s",
-				"PUMPE_SET_RANDOM_LOOP_DELAY":   "11ms",
-				"PUMPE_SET_STATE_LOOP_TIMEOUT":  "29s",
-				"PUMPE_SET_STATE_LOOP_DELAY":    "11ms",
-				"PUMPE_TOR_STARTUP_TIMEOUT":     "4m",
-				"PUMPE_TOR_NUM":                 "16",
-				"PUMPE_TOR_MAX":                 "64",
-				"PUMPE_WG_PARSE_MODE":           "2",
-				"PUMPE_DEFAULT_KIND":            "direct",
-				"PUMPE_WG_DIR":                  "/tmp/wg-ini",
-				"PUMPE_WG_DNS":                  "1.1.1.1",
-				"PUMPE_PORT":                    "8081",
-				"PUMPE_LOG_LEVEL":               "DEBUG",
-				"PUMPE_LOG_FORMAT":              "text",
-				"PUMPE_RANDOMISE_KINDS":         "true",
-				"PUMPE_LOG_ADD_SOURCE":          "true",
+			name:  "single",
+			given: []string{"443"},
+			exp: tcExpected{
+				val: []int{443},
 			},
-			exp: settings{
-				shutdownTimeout:      29 * time.Second,
-				httpClientTimeout:    59 * time.Second,
-				setRandomLoopTimeout: 29 * time.Second,
-				setRandomLoopDelay:   11 * time.Millisecond,
-				setStateLoopTimeout:  29 * time.Second,
-				setStateLoopDelay:    11 * time.Millisecond,
-				torStartupTimeout:    4 * time.Minute,
-				torN:                 16,
-				torMax:               64,
-				wgParseMode:          2,
-				defKind:              "direct",
-				wgDir:                "/tmp/wg-ini",
-				wgDNS:                "1.1.1.1",
-				port:                 "8081",
-				logLvl:               "DEBUG",
-				logFmt:               "text",
-				randomiseKinds:       true,
-				logAddSrc:            true,
+		},
+
+		{
+			name:  "multiple",
+			given: []string{"80", "443", "8443"},
+			exp: tcExpected{
+				val: []int{80, 443, 8443},
 			},
 		},
 
 		{
-			name: "configured_exceeding_limits",
-			given: map[string]string{
-				"PUMPE_SHUTDOWN_TIMEOUT":        "61s",
-				"PUMPE_SET_RANDOM_LOOP_TIMEOUT": "61s",
-				"PUMPE_SET_RANDOM_LOOP_DELAY":   "101ms",
-				"PUMPE_SET_STATE_LOOP_TIMEOUT":  "61s",
-				"PUMPE_SET_STATE_LOOP_DELAY":    "101ms",
-				"PUMPE_TOR_STARTUP_TIMEOUT":     "1m",
-				"PUMPE_WG_DIR":                  "/tmp/wg-ini",
+			name:  "error_not_number",
+			given: []string{"443", "https"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %q", errInvalidPort, "https"),
 			},
-			exp: settings{
-				shutdownTimeout:      30 * time.Second,
-				httpClientTimeout:    60 * time.Second,
-				setRandomLoopTimeout: 30 * time.Second,
-				setRandomLoopDelay:   10 * time.Millisecond,
-				setStateLoopTimeout:  30 * time.Second,
-				setStateLoopDelay:    10 * time.Millisecond,
-				torStartupTimeout:    3 * time.Minute,
-				torN:                 4,
-				torMax:               128,
-				defKind:              "tor",
-				wgDir:                "/tmp/wg-ini",
-				wgDNS:                "9.9.9.9",
-				port:                 "8080",
-				logLvl:               "INFO",
-				logFmt:               "json",
+		},
+
+		{
+			name:  "error_zero",
+			given: []string{"0"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %q", errInvalidPort, "0"),
 			},
 		},
 
 		{
-			name: "direct_no_tor",
-			given: map[string]string{
-				"PUMPE_DEFAULT_KIND": "direct",
-				"PUMPE_TOR_NUM":      "0",
+			name:  "error_out_of_range",
+			given: []string{"65536"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %q", errInvalidPort, "65536"),
 			},
-			exp: settings{
-				shutdownTimeout:      30 * time.Second,
-				httpClientTimeout:    60 * time.Second,
-				setRandomLoopTimeout: 30 * time.Second,
-				setRandomLoopDelay:   10 * time.Millisecond,
-				setStateLoopTimeout:  30 * time.Second,
-				setStateLoopDelay:    10 * time.Millisecond,
-				torStartupTimeout:    3 * time.Minute,
-				torMax:               128,
-				defKind:              "direct",
-				wgDNS:                "9.9.9.9",
-				port:                 "8080",
-				logLvl:               "INFO",
-				logFmt:               "json",
+		},
+
+		{
+			name:  "error_inner_space",
+			given: []string{"44 3"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %q", errInvalidPort, "44 3"),
 			},
 		},
 	}
 
 	for i := range tests {
 		t.Run(tests[i].name, func(t *testing.T) {
-			actual := newSettingsFromEnv(tests[i].given)
-			should.Equal(t, tests[i].exp, actual)
+			actual, err := parsePorts(tests[i].given)
+			must.Equal(t, tests[i].exp.err, err)
+
+			should.Equal(t, tests[i].exp.val, actual)
 		})
 	}
 }
@@ -248,6 +1210,17 @@ func TestHandleWGParseErr(t *testing.T) {
 			},
 		},
 
+		{
+			name: "error_ignore_not_dir",
+			given: tcGiven{
+				mode: 2,
+				err:  fmt.Errorf("%w: %s", gate.ErrWGDirNotDir, "/etc/pumpe/wg.conf"),
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %s", gate.ErrWGDirNotDir, "/etc/pumpe/wg.conf"),
+			},
+		},
+
 		{
 			name: "valid_report",
 			given: tcGiven{
@@ -310,6 +1283,87 @@ func TestHandleWGParseErr(t *testing.T) {
 	}
 }
 
+func TestNewDrainFunc(t *testing.T) {
+	type tcGiven struct {
+		p     *mockPauser
+		grace time.Duration
+		tout  time.Duration
+	}
+
+	type tcExpected struct {
+		paused bool
+		err    error
+	}
+
+	tests := []struct {
+		name  string
+		given tcGiven
+		exp   tcExpected
+	}{
+		{
+			name: "error_pause",
+			given: tcGiven{
+				p:     &mockPauser{err: gate.ErrSetIsShutting},
+				grace: time.Hour,
+				tout:  time.Hour,
+			},
+			exp: tcExpected{
+				err: gate.ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "valid_grace",
+			given: tcGiven{
+				p:     &mockPauser{},
+				grace: time.Millisecond,
+				tout:  time.Hour,
+			},
+			exp: tcExpected{
+				paused: true,
+			},
+		},
+
+		{
+			name: "valid_ctx_done",
+			given: tcGiven{
+				p:     &mockPauser{},
+				grace: time.Hour,
+				tout:  time.Millisecond,
+			},
+			exp: tcExpected{
+				paused: true,
+			},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tests[i].given.tout)
+			defer cancel()
+
+			err := newDrainFunc(tests[i].given.p, tests[i].given.grace)(ctx)
+			should.Equal(t, tests[i].exp.err, err)
+			should.Equal(t, tests[i].exp.paused, tests[i].given.p.paused)
+		})
+	}
+}
+
+type mockPauser struct {
+	paused bool
+	err    error
+}
+
+func (p *mockPauser) Pause(ctx context.Context) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	p.paused = true
+
+	return nil
+}
+
 func TestCallFuncsCtxErr(t *testing.T) {
 	type tcGiven struct {
 		ctx context.Context
@@ -462,3 +1516,107 @@ func TestCallFuncsErr(t *testing.T) {
 		})
 	}
 }
+
+func TestReloadWithTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		given *mockReloader
+		exp   []string
+	}{
+		{
+			name: "error_reload",
+			given: &mockReloader{
+				fnReload: func(ctx context.Context) (*gate.ReloadResult, error) {
+					return nil, model.Error("something_went_wrong")
+				},
+			},
+			exp: []string{
+				`level=INFO msg="reloading gates"`,
+				`level=ERROR msg="failed to reload gates" error=something_went_wrong`,
+			},
+		},
+
+		{
+			name: "error_partial_warmup",
+			given: &mockReloader{
+				fnReload: func(ctx context.Context) (*gate.ReloadResult, error) {
+					result := &gate.ReloadResult{
+						Added:     []uuid.UUID{uuid.MustParse("facade00-0000-4000-a000-000000000000")},
+						Unchanged: 2,
+					}
+
+					return result, model.Error("something_went_wrong")
+				},
+				fnWarmupIDs: func(ctx context.Context, ids []uuid.UUID) error {
+					if len(ids) != 1 || ids[0] != uuid.MustParse("facade00-0000-4000-a000-000000000000") {
+						return model.Error("unexpected_ids")
+					}
+
+					return model.Error("something_else_went_wrong")
+				},
+			},
+			exp: []string{
+				`level=INFO msg="reloading gates"`,
+				`level=WARN msg="reloaded gates with errors" error=something_went_wrong`,
+				`level=INFO msg="reloaded gates" added=1 removed=0 unchanged=2`,
+				`level=WARN msg="failed to warm up gates" error=something_else_went_wrong`,
+			},
+		},
+
+		{
+			name:  "valid",
+			given: &mockReloader{},
+			exp: []string{
+				`level=INFO msg="reloading gates"`,
+				`level=INFO msg="reloaded gates" added=0 removed=0 unchanged=0`,
+				`level=INFO msg="warmed up gates"`,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lgw := &strings.Builder{}
+
+			opts := &slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+
+					return a
+				},
+			}
+
+			lg := slog.New(slog.NewTextHandler(lgw, opts))
+
+			reloadWithTimeout(context.Background(), lg, tc.given, time.Second)
+
+			actual := strings.Split(strings.TrimSpace(lgw.String()), "\n")
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+type mockReloader struct {
+	fnReload    func(ctx context.Context) (*gate.ReloadResult, error)
+	fnWarmupIDs func(ctx context.Context, ids []uuid.UUID) error
+}
+
+func (r *mockReloader) Reload(ctx context.Context) (*gate.ReloadResult, error) {
+	if r.fnReload == nil {
+		return &gate.ReloadResult{}, nil
+	}
+
+	return r.fnReload(ctx)
+}
+
+func (r *mockReloader) WarmupIDs(ctx context.Context, ids []uuid.UUID) error {
+	if r.fnWarmupIDs == nil {
+		return nil
+	}
+
+	return r.fnWarmupIDs(ctx, ids)
+}
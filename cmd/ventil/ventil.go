@@ -11,6 +11,9 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/pavelbrm/pumpe/client"
+	"github.com/pavelbrm/pumpe/gate"
 )
 
 func main() {
@@ -36,6 +39,32 @@ func run(pctx context.Context, lg *slog.Logger, cfg settings, args []string) err
 		return err
 	}
 
+	// Create a gate to use via the API, and stop it when done.
+	if cfg.pumpeCreate != "" {
+		kind, err := gate.ParseKind(cfg.pumpeCreate)
+		if err != nil {
+			return err
+		}
+
+		api := client.New(cfg.pumpeURL, nil)
+
+		id, err := api.CreateGate(pctx, kind)
+		if err != nil {
+			return err
+		}
+
+		lg.LogAttrs(pctx, slog.LevelInfo, "created gate", slog.String("kind", kind.String()), slog.String("id", id.String()))
+
+		defer func() {
+			if err := api.StopGate(pctx, id); err != nil {
+				lg.LogAttrs(pctx, slog.LevelError, "failed to stop gate", slog.String("id", id.String()), slog.Any("error", err))
+			}
+		}()
+
+		cfg.pumpeKind = ""
+		cfg.pumpeID = id.String()
+	}
+
 	tst := &http.Transport{
 		Proxy: http.ProxyURL(proxyURL),
 	}
@@ -107,21 +136,23 @@ func rawEnvToMap(raw []string) map[string]string {
 }
 
 type settings struct {
-	pumpeURL  string
-	pumpeKind string
-	pumpeID   string
-	logLvl    string
-	logFmt    string
-	logAddSrc bool
+	pumpeURL    string
+	pumpeKind   string
+	pumpeID     string
+	pumpeCreate string
+	logLvl      string
+	logFmt      string
+	logAddSrc   bool
 }
 
 func newSettingsFromEnv(env map[string]string) settings {
 	result := settings{
-		pumpeURL:  env["VENTIL_PUMPE_URL"],
-		pumpeKind: env["VENTIL_PUMPE_KIND"],
-		pumpeID:   env["VENTIL_PUMPE_ID"],
-		logLvl:    env["VENTIL_LOG_LEVEL"],
-		logFmt:    env["VENTIL_LOG_FORMAT"],
+		pumpeURL:    env["VENTIL_PUMPE_URL"],
+		pumpeKind:   env["VENTIL_PUMPE_KIND"],
+		pumpeID:     env["VENTIL_PUMPE_ID"],
+		pumpeCreate: env["VENTIL_PUMPE_CREATE"],
+		logLvl:      env["VENTIL_LOG_LEVEL"],
+		logFmt:      env["VENTIL_LOG_FORMAT"],
 	}
 
 	if result.pumpeURL == "" {
@@ -4,14 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,26 +32,72 @@ const (
 	ErrNotImplemented         model.Error = "gate: not implemented"
 	ErrSetIsShutting          model.Error = "gate: set is shutting"
 	ErrSetIsWarmingUp         model.Error = "gate: set is warming up"
+	ErrSetPaused              model.Error = "gate: set is paused"
+	ErrSetNotReady            model.Error = "gate: set not ready"
+	ErrSetIsReloading         model.Error = "gate: set is reloading"
 	ErrNoRandomGate           model.Error = "gate: no random gate"
+	ErrNoReadyGate            model.Error = "gate: no ready gate"
 	ErrGateNotFound           model.Error = "gate: gate not found"
 	ErrTorMaxReached          model.Error = "gate: reached maximum number of tor gates"
 	ErrWarmupBadResponse      model.Error = "gate: warmup finished with bad response"
+	ErrWarmupNotTor           model.Error = "gate: warmup request did not go via tor"
 	ErrGateNotReady           model.Error = "gate: gate not ready"
 	ErrGateIsRefreshing       model.Error = "gate: gate is refreshing"
+	ErrGateBusy               model.Error = "gate: gate is busy"
+	ErrGroupNotFound          model.Error = "gate: group not found"
+	ErrInvalidProbeURL        model.Error = "gate: invalid probe url"
+	ErrProbeDestNotAllowed    model.Error = "gate: probe destination not allowed"
+	ErrInvalidConnectResp     model.Error = "gate: invalid connect response"
+	ErrTooManyAnnotations     model.Error = "gate: too many annotations"
+	ErrNoUpstreamGate         model.Error = "gate: no upstream gate"
+	ErrTorStartFailed         model.Error = "gate: tor failed to start"
+	ErrNetUnreachable         model.Error = "gate: network unreachable"
+	ErrSocksUnsupported       model.Error = "gate: unsupported socks request"
+	ErrIllegalStateTransition model.Error = "gate: illegal state transition"
 	ErrInvalidWGConfig        model.Error = "gate: invalid wireguard config"
 	ErrInvalidWGKey           model.Error = "gate: invalid wireguard key"
 	ErrInvalidWGIfacePvtKey   model.Error = "gate: invalid wireguard iface private key"
 	ErrInvalidWGIfaceAddr     model.Error = "gate: invalid wireguard iface address"
+	ErrInvalidWGListenPort    model.Error = "gate: invalid wireguard iface listen port"
 	ErrInvalidWGPeerPubKey    model.Error = "gate: invalid wireguard peer public key"
 	ErrInvalidWGPeerEndpoint  model.Error = "gate: invalid wireguard peer endpoint"
 	ErrInvalidWGPeerAllowedIP model.Error = "gate: invalid wireguard peer allowed_ip"
+	ErrWGEndpointResolve      model.Error = "gate: could not resolve wireguard endpoint"
+	ErrWGDestNotAllowed       model.Error = "gate: destination outside wireguard allowed_ip"
+	ErrWGDirNotDir            model.Error = "gate: wireguard config path is not a directory"
+	ErrWGDirUnreadable        model.Error = "gate: wireguard config directory is not readable"
+	ErrOVPNCommand            model.Error = "gate: openvpn command failed"
+	ErrOVPNMgmtClosed         model.Error = "gate: openvpn management interface closed"
+	ErrOVPNExited             model.Error = "gate: openvpn exited"
 )
 
+// maxAnnotations is the number of annotations a gate can have.
+const maxAnnotations = 32
+
+// DefConnectResponse is what clients get once a CONNECT tunnel is established, unless configured otherwise.
+const DefConnectResponse = "HTTP/1.1 200 Connection established\r\n\r\n"
+
+// defWarmupURL is requested via gates when no other warmup is configured.
+const defWarmupURL = "https://httpbin.org/status/200"
+
+// expectContinueTout is how long a request with Expect: 100-continue waits for the origin before sending its body.
+const expectContinueTout = time.Second
+
+// torRotateDelay is the longest interval between checks for Tor gates due for rotation.
+const torRotateDelay = time.Minute
+
+// torCheckTout is how long a Tor gate has to answer a check of its control connection.
+const torCheckTout = 10 * time.Second
+
 const (
 	KindUnknown   Kind = "unknown"
 	KindDirect    Kind = "direct"
 	KindTor       Kind = "tor"
 	KindWireGuard Kind = "wireguard"
+	KindOpenVPN   Kind = "openvpn"
+
+	// KindTorWG is a Tor gate which connects to the Tor network via a WireGuard gate.
+	KindTorWG Kind = "tor_wireguard"
 )
 
 type Kind string
@@ -73,7 +126,7 @@ func (x Kind) MarshalJSON() ([]byte, error) {
 	var s string
 
 	switch x {
-	case KindUnknown, KindDirect, KindTor, KindWireGuard:
+	case KindUnknown, KindDirect, KindTor, KindWireGuard, KindOpenVPN, KindTorWG:
 		s = string(x)
 	default:
 		return nil, ErrKindUnknown
@@ -90,6 +143,10 @@ func ParseKind(raw string) (Kind, error) {
 		return KindTor, nil
 	case KindWireGuard:
 		return KindWireGuard, nil
+	case KindOpenVPN:
+		return KindOpenVPN, nil
+	case KindTorWG:
+		return KindTorWG, nil
 
 	default:
 		return KindUnknown, ErrKindUnknown
@@ -99,10 +156,15 @@ func ParseKind(raw string) (Kind, error) {
 type ExitGate interface {
 	ID() uuid.UUID
 	Kind() Kind
+	CreatedAt() time.Time
+	LastUsed() time.Time
 
 	AddReq()
 	DidReq()
 
+	// AddBytes counts bytes received via the gate in, and sent via it in out.
+	AddBytes(in, out uint64)
+
 	netDialDoer
 }
 
@@ -110,6 +172,7 @@ type exitGateExt interface {
 	ExitGate
 
 	stateTracker
+	warmuper
 
 	refresh() error
 	close() error
@@ -131,9 +194,22 @@ type httpDoer interface {
 type stateTracker interface {
 	getState() state
 	toState(st state)
+	toStateErr(st state) error
 	isReady() bool
 	noReqs() bool
+	reqNum() uint64
+	bytesNum() (uint64, uint64)
 	resetReqs()
+	idleFor(now time.Time) (time.Duration, bool)
+	addFail() uint64
+	resetFails()
+	setWarmup(lat time.Duration, err error)
+	warmupErr() string
+	warmupLatency() time.Duration
+	annotate(annots map[string]string) (map[string]string, error)
+	annotations() map[string]string
+	lockOp() bool
+	unlockOp()
 }
 
 type torFactory interface {
@@ -144,48 +220,119 @@ type wgFactory interface {
 	new(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout time.Duration) (*WireGuard, error)
 }
 
+type ovpnFactory interface {
+	new(ctx context.Context, fpath string, stutout, cltout time.Duration) (*OpenVPN, error)
+}
+
 type Set struct {
 	cfg *SetConfig
+	lg  *slog.Logger
 
-	onceShut *sync.Once
-	shutting chan struct{}
-	warming  *struct{ value uint32 }
+	onceShut  *sync.Once
+	shutting  chan struct{}
+	warming   *struct{ value uint32 }
+	paused    *struct{ value uint32 }
+	reloading *struct{ value uint32 }
+	onceReady *sync.Once
+	ready     chan struct{}
 
-	drt *Direct
+	drs *model.Set[uuid.UUID, *Direct]
 	tgs *model.Set[uuid.UUID, *Tor]
 	wgs *model.Set[uuid.UUID, *WireGuard]
+	ovs *model.Set[uuid.UUID, *OpenVPN]
+
+	// tws holds the Tor gates chained over WireGuard, which are of a kind of their own.
+	tws *model.Set[uuid.UUID, *Tor]
+
+	// detached holds the gates taken out of the kind sets for maintenance or closing, until they are back or closed.
+	detached *model.Set[uuid.UUID, exitGateExt]
+
+	// apiTors holds the ids of Tor gates created via New, until they are closed.
+	apiTors *model.Set[uuid.UUID, struct{}]
+
+	// newMu guards newPending, the number of Tor gates being started by New, which count towards the limits.
+	newMu      *sync.Mutex
+	newPending int
+
+	// groups maps group names to the ids of their members, some of which might have been closed since.
+	groups *model.Set[string, *model.Set[uuid.UUID, struct{}]]
+
+	// intn returns a number in [0, n) for picking gates and kinds, and defaults to rand.IntN.
+	intn func(n int) int
 
 	tf torFactory
+	wf wgFactory
 }
 
-func NewSet(cfg *SetConfig, dct *Direct, tgs []*Tor, wgs []*WireGuard) *Set {
+func NewSet(cfg *SetConfig, dcts []*Direct, tgs []*Tor, wgs []*WireGuard, ovs []*OpenVPN) *Set {
 	result := &Set{
 		cfg: cfg,
+		lg:  cfg.logger(),
 
-		onceShut: &sync.Once{},
-		shutting: make(chan struct{}),
-		warming:  &struct{ value uint32 }{},
+		onceShut:  &sync.Once{},
+		shutting:  make(chan struct{}),
+		warming:   &struct{ value uint32 }{},
+		paused:    &struct{ value uint32 }{},
+		reloading: &struct{ value uint32 }{},
+		onceReady: &sync.Once{},
+		ready:     make(chan struct{}),
 
-		drt: dct,
+		drs: model.NewSetSize[uuid.UUID, *Direct](len(dcts)),
 		tgs: model.NewSetSize[uuid.UUID, *Tor](len(tgs)),
 		wgs: model.NewSetSize[uuid.UUID, *WireGuard](len(wgs)),
+		ovs: model.NewSetSize[uuid.UUID, *OpenVPN](len(ovs)),
+		tws: model.NewSet[uuid.UUID, *Tor](),
 
-		tf: &torCreator{},
+		detached: model.NewSet[uuid.UUID, exitGateExt](),
+		apiTors:  model.NewSet[uuid.UUID, struct{}](),
+		newMu:    &sync.Mutex{},
+		groups:   model.NewSet[string, *model.Set[uuid.UUID, struct{}]](),
+
+		tf: &torCreator{cfg: cfg.Tor},
+		wf: &wgCreator{},
+	}
+
+	if !cfg.DisableDirect {
+		for i := range dcts {
+			result.drs.Set(dcts[i].id, dcts[i])
+		}
 	}
 
 	for i := range tgs {
-		result.tgs.Set(tgs[i].id, tgs[i])
+		result.torsOf(tgs[i].Kind()).Set(tgs[i].id, tgs[i])
 	}
 
 	for i := range wgs {
 		result.wgs.Set(wgs[i].id, wgs[i])
 	}
 
+	for i := range ovs {
+		result.ovs.Set(ovs[i].id, ovs[i])
+	}
+
 	return result
 }
 
+// SetIntN makes s pick gates and kinds at random with fn, which must return a number in [0, n).
+//
+// It allows for reproducible picks in tests, and must be called before s is used.
+// A nil fn restores the default, rand.IntN.
+func (s *Set) SetIntN(fn func(n int) int) {
+	s.intn = fn
+
+	s.drs.SetIntN(fn)
+	s.tgs.SetIntN(fn)
+	s.wgs.SetIntN(fn)
+	s.ovs.SetIntN(fn)
+	s.tws.SetIntN(fn)
+}
+
 // ByID returns the gate identified by id if ready.
 func (s *Set) ByID(id uuid.UUID) (ExitGate, error) {
+	if s.IsPaused() {
+		return nil, ErrSetPaused
+	}
+
 	result, err := s.byID(id)
 	if err != nil {
 		return nil, err
@@ -198,217 +345,326 @@ func (s *Set) ByID(id uuid.UUID) (ExitGate, error) {
 	return result, nil
 }
 
-func (s *Set) ByKind(ctx context.Context, kind Kind) (ExitGate, error) {
-	if kind == KindDirect {
-		return s.drt, nil
-	}
+// ByIDWait returns the gate identified by id, waiting for it to become ready.
+//
+// A gate under maintenance is temporarily removed from the set, so it's waited for as well.
+// An unknown gate, or one being closed, results in ErrGateNotFound straight away.
+// When the wait times out, the last error is returned.
+func (s *Set) ByIDWait(ctx context.Context, id uuid.UUID) (ExitGate, error) {
+	rctx, cancel := context.WithTimeout(ctx, s.cfg.RandomLoopTout)
+	defer cancel()
 
-	return s.byKindReady(ctx, kind)
-}
+	tc := time.NewTicker(s.cfg.jittered(s.cfg.RandomLoopDelay))
+	defer tc.Stop()
 
-func (s *Set) Random(ctx context.Context) (ExitGate, error) {
-	return s.ByKind(ctx, s.kindOrDefault())
-}
+	for {
+		if s.isShutting() {
+			return nil, ErrSetIsShutting
+		}
 
-func (s *Set) New(ctx context.Context, kind Kind) (uuid.UUID, error) {
-	if kind != KindTor {
-		return uuid.Nil, ErrKindNotSupported
-	}
+		result, err := s.ByID(id)
+		if err == nil {
+			return result, nil
+		}
 
-	// Check if s is shutting.
-	// Starting a new tor instance takes time,
-	// and there is little sense in doing it during shutdown.
-	if s.isShutting() {
-		return uuid.Nil, ErrSetIsShutting
+		if !errors.Is(err, ErrGateNotReady) && !errors.Is(err, ErrGateNotFound) {
+			return nil, err
+		}
+
+		if errors.Is(err, ErrGateNotFound) && !s.isComingBack(id) {
+			return nil, err
+		}
+
+		select {
+		case <-rctx.Done():
+			return nil, err
+		case <-tc.C:
+			tc.Reset(s.cfg.jittered(s.cfg.RandomLoopDelay))
+		}
 	}
+}
 
-	if n := s.tgs.Len(); n >= s.cfg.TorMax {
-		return uuid.Nil, ErrTorMaxReached
+// isComingBack reports whether the gate identified by id is detached, and might be put back into the set.
+func (s *Set) isComingBack(id uuid.UUID) bool {
+	gt, ok := s.detached.Get(id)
+
+	return ok && gt.getState() != stateClosed
+}
+
+func (s *Set) ByKind(ctx context.Context, kind Kind) (ExitGate, error) {
+	if s.IsPaused() {
+		return nil, ErrSetPaused
 	}
 
-	gt, err := s.tf.new(s.cfg.BaseCtx(), s.cfg.TorStartupTout, s.cfg.HTTPTimeout)
-	if err != nil {
-		return uuid.Nil, err
+	result, err := s.byKindAny(ctx, kind)
+	if err == nil || !s.canFallBack(kind, err) {
+		return result, err
 	}
 
-	s.tgs.Set(gt.id, gt)
+	s.lg.LogAttrs(ctx, slog.LevelInfo, "fell back to default kind", slog.String("gate.kind", kind.String()), slog.String("gate.kind_default", s.cfg.Default.String()), slog.Any("error", err))
 
-	return gt.id, nil
+	return s.byKindAny(ctx, s.cfg.Default)
 }
 
-func (s *Set) GateIDs(kind Kind) ([]uuid.UUID, error) {
-	switch kind {
-	case KindDirect:
-		return []uuid.UUID{s.drt.id}, nil
-
-	case KindTor:
-		return s.tgs.Keys(), nil
+func (s *Set) byKindAny(ctx context.Context, kind Kind) (exitGateExt, error) {
+	if kind == KindDirect {
+		return s.byKind(KindDirect)
+	}
 
-	case KindWireGuard:
-		return s.wgs.Keys(), nil
+	return s.byKindReady(ctx, kind)
+}
 
-	default:
-		return nil, ErrKindUnknown
+// canFallBack reports whether a gate of the default kind can be picked when picking one of kind failed with err.
+//
+// Only the absence of gates of kind counts, while unknown kinds and gates that are not ready still fail.
+func (s *Set) canFallBack(kind Kind, err error) bool {
+	if !s.cfg.FallbackToDefault || kind == s.cfg.Default {
+		return false
 	}
+
+	return errors.Is(err, ErrNoRandomGate) || errors.Is(err, ErrKindNotSupported)
 }
 
-func (s *Set) RefreshOne(ctx context.Context, id uuid.UUID) error {
-	if id == s.drt.id {
-		return ErrKindNotSupported
+// ByIndex returns the gate of kind at the zero-based index i, with gates ordered by id.
+//
+// The order stays the same while the gates do, so that a gate can be picked without knowing its id.
+// Gates out for maintenance or being closed keep their places, and result in ErrGateNotReady.
+// An index out of range results in ErrGateNotFound.
+func (s *Set) ByIndex(kind Kind, i int) (ExitGate, error) {
+	if s.IsPaused() {
+		return nil, ErrSetPaused
 	}
 
-	gt, err := s.byID(id)
+	gts, err := s.attachedOf(kind)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if gt.Kind() != KindTor {
-		return ErrKindNotSupported
+	gts = withDetached(kind, gts, s.detached.Values())
+
+	if i < 0 || i >= len(gts) {
+		return nil, ErrGateNotFound
 	}
 
-	if err := s.forState(ctx, gt, stateMaintenance); err != nil {
-		return err
+	sort.Slice(gts, func(a, b int) bool { return gts[a].ID().String() < gts[b].ID().String() })
+
+	result := gts[i]
+	if !result.isReady() {
+		return nil, ErrGateNotReady
 	}
 
-	if err := refreshOne(ctx, gt); err != nil {
-		return err
+	return result, nil
+}
+
+func (s *Set) Random(ctx context.Context) (ExitGate, error) {
+	return s.ByKind(ctx, s.kindOrDefault())
+}
+
+// RandomExcept returns a random ready gate of kind other than the one identified by id.
+//
+// It returns ErrNoRandomGate when the excluded gate is the only one available.
+func (s *Set) RandomExcept(ctx context.Context, kind Kind, id uuid.UUID) (ExitGate, error) {
+	if s.IsPaused() {
+		return nil, ErrSetPaused
 	}
 
-	return s.toState(gt, stateReady)
+	if kind == KindDirect {
+		return s.byKindExcept(KindDirect, id)
+	}
+
+	return s.byKindReadyExcept(ctx, kind, id)
 }
 
-func (s *Set) CloseOne(ctx context.Context, id uuid.UUID) error {
-	if id == s.drt.id {
-		return ErrKindNotSupported
+// ReportResult records the outcome of a request via the gate identified by id.
+//
+// A success resets the failure count. After FailThreshold consecutive failures,
+// the gate is moved to maintenance for FailCooldown, and then made ready again.
+// Direct gates are never put on cooldown.
+func (s *Set) ReportResult(id uuid.UUID, ok bool) {
+	if s.cfg.FailThreshold <= 0 || s.isDirectID(id) {
+		return
 	}
 
 	gt, err := s.byID(id)
 	if err != nil {
-		return err
+		return
 	}
 
-	if err := s.forState(ctx, gt, stateClosed); err != nil {
-		return err
+	if ok {
+		gt.resetFails()
+
+		return
 	}
 
-	return shutdownOne(ctx, gt)
-}
+	if gt.addFail() < uint64(s.cfg.FailThreshold) {
+		return
+	}
 
-func (s *Set) Shutdown(ctx context.Context) error {
-	var errs []error
+	gt.resetFails()
 
-	s.onceShut.Do(func() {
-		closeOrSkip(s.shutting)
+	// Only a ready gate can go on cooldown, which also prevents overlapping cooldowns.
+	if err := gt.toStateErr(stateMaintenance); err != nil {
+		return
+	}
 
-		errc := make(chan error, 2)
-		wg := &sync.WaitGroup{}
+	s.logGate(context.Background(), slog.LevelInfo, "put gate on cooldown", gt, slog.Duration("cooldown", s.cfg.FailCooldown))
 
-		if s.tgs.Len() > 0 {
-			wg.Add(1)
+	go s.cooldown(gt)
+}
 
-			go func(tgs []*Tor) {
-				defer wg.Done()
+func (s *Set) New(ctx context.Context, kind Kind) (uuid.UUID, error) {
+	if kind != KindTor {
+		return uuid.Nil, ErrKindNotSupported
+	}
 
-				errc <- ShutdownList(ctx, tgs)
-			}(s.tgs.Values())
-		}
+	// Check if s is shutting.
+	// Starting a new tor instance takes time,
+	// and there is little sense in doing it during shutdown.
+	if s.isShutting() {
+		return uuid.Nil, ErrSetIsShutting
+	}
 
-		if s.wgs.Len() > 0 {
-			wg.Add(1)
+	if !s.reserveNew() {
+		return uuid.Nil, ErrTorMaxReached
+	}
 
-			go func(wgs []*WireGuard) {
-				defer wg.Done()
+	defer s.releaseNew()
 
-				errc <- ShutdownList(ctx, wgs)
-			}(s.wgs.Values())
+	gt, err := s.tf.new(s.cfg.BaseCtx(), s.cfg.TorStartupTout, s.cfg.HTTPTimeoutFor(KindTor))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// Don't hand out the new gate until it's proven healthy.
+	if s.cfg.WarmupOnCreate {
+		if resp := warmupOne(ctx, s.cfg.warmuperFor(gt)); resp.err != nil {
+			_ = shutdownOne(s.cfg.BaseCtx(), gt)
+
+			s.logGate(ctx, slog.LevelWarn, "closed new gate after failed warmup", gt, slog.Any("error", resp.err))
+
+			return uuid.Nil, typedNetErr(resp.err)
 		}
+	}
 
-		go func() { wg.Wait(); close(errc) }()
+	s.tgs.Set(gt.id, gt)
+	s.apiTors.Set(gt.id, struct{}{})
 
-		errs = collectErrs(errc)
-	})
+	s.logGate(ctx, slog.LevelInfo, "created gate", gt)
 
-	return errors.Join(errs...)
+	return gt.id, nil
 }
 
-func (s *Set) Warmup(ctx context.Context) error {
-	if s.isShutting() {
-		return errors.Join(ErrSetIsShutting)
-	}
+// reserveNew counts a Tor gate about to be started, and reports false without counting it when a limit is reached.
+//
+// Gates being started count towards the limits, so that concurrent calls to New cannot exceed them.
+func (s *Set) reserveNew() bool {
+	s.newMu.Lock()
+	defer s.newMu.Unlock()
 
-	if ok := atomic.CompareAndSwapUint32(&s.warming.value, 0, 1); !ok {
-		return errors.Join(ErrSetIsWarmingUp)
+	if s.tgs.Len()+s.tws.Len()+s.newPending >= s.cfg.TorMax {
+		return false
 	}
 
-	defer func() { atomic.StoreUint32(&s.warming.value, 0) }()
+	if s.cfg.TorAPIMax > 0 && s.apiTorNum()+s.newPending >= s.cfg.TorAPIMax {
+		return false
+	}
 
-	errc := make(chan error, 2)
-	wg := &sync.WaitGroup{}
+	s.newPending++
 
-	if s.tgs.Len() > 0 {
-		wg.Add(1)
+	return true
+}
 
-		go func(tgs []*Tor) {
-			defer wg.Done()
+func (s *Set) releaseNew() {
+	s.newMu.Lock()
+	s.newPending--
+	s.newMu.Unlock()
+}
 
-			_, err := WarmupList(ctx, tgs)
+// apiTorNum returns the number of Tor gates created via New which have not been closed.
+//
+// Gates detached for maintenance, e.g. being rotated or refreshed, still count, as they come back or get replaced.
+func (s *Set) apiTorNum() int {
+	return s.apiTors.Len()
+}
 
-			errc <- err
-		}(s.tgs.Values())
+// inheritAPITor makes nid count as created via New if oid did, so that replacing a gate doesn't lift SetConfig.TorAPIMax.
+func (s *Set) inheritAPITor(oid, nid uuid.UUID) {
+	if _, ok := s.apiTors.Get(oid); ok {
+		s.apiTors.Set(nid, struct{}{})
 	}
+}
 
-	if s.wgs.Len() > 0 {
-		wg.Add(1)
-
-		go func(wgs []*WireGuard) {
-			defer wg.Done()
+// SetGroup defines the group name as the gates identified by ids, replacing the group if it exists.
+//
+// Each of the gates must be running.
+func (s *Set) SetGroup(name string, ids []uuid.UUID) error {
+	members := model.NewSetSize[uuid.UUID, struct{}](len(ids))
 
-			_, err := WarmupList(ctx, wgs)
+	for i := range ids {
+		if _, err := s.byID(ids[i]); err != nil {
+			return fmt.Errorf("%w: %s", err, ids[i])
+		}
 
-			errc <- err
-		}(s.wgs.Values())
+		members.Set(ids[i], struct{}{})
 	}
 
-	go func() { wg.Wait(); close(errc) }()
-
-	return errors.Join(collectErrs(errc)...)
-}
+	s.groups.Set(name, members)
 
-func (s *Set) kindOrDefault() Kind {
-	return s.kindOrDefaultN(rand.Int())
+	return nil
 }
 
-func (s *Set) kindOrDefaultN(n int) Kind {
-	if !s.cfg.RandomiseKinds {
-		return s.cfg.Default
+// DeleteGroup removes the group name.
+func (s *Set) DeleteGroup(name string) error {
+	if _, ok := s.groups.Get(name); !ok {
+		return ErrGroupNotFound
 	}
 
-	return pickRandomKind(n)
+	s.groups.Remove(name)
+
+	return nil
 }
 
-func (s *Set) byID(id uuid.UUID) (exitGateExt, error) {
-	if id == s.drt.ID() {
-		return s.drt, nil
-	}
+// Groups returns the ids of running gates in each group, ordered by id.
+func (s *Set) Groups() map[string][]uuid.UUID {
+	names := s.groups.Keys()
 
-	result, ok := s.tgs.Get(id)
-	if !ok {
-		result, ok := s.wgs.Get(id)
+	result := make(map[string][]uuid.UUID, len(names))
+
+	for i := range names {
+		members, ok := s.groups.Get(names[i])
 		if !ok {
-			return nil, ErrGateNotFound
+			continue
 		}
 
-		return result, nil
+		ids := members.Keys()
+
+		running := make([]uuid.UUID, 0, len(ids))
+		for j := range ids {
+			if _, err := s.byID(ids[j]); err == nil {
+				running = append(running, ids[j])
+			}
+		}
+
+		sort.Slice(running, func(a, b int) bool { return running[a].String() < running[b].String() })
+
+		result[names[i]] = running
 	}
 
-	return result, nil
+	return result
 }
 
-func (s *Set) byKindReady(ctx context.Context, kind Kind) (exitGateExt, error) {
+// ByGroup returns a random ready gate from the group name, waiting for one to become ready.
+//
+// It returns ErrNoRandomGate when the group has no members.
+func (s *Set) ByGroup(ctx context.Context, name string) (ExitGate, error) {
+	if s.IsPaused() {
+		return nil, ErrSetPaused
+	}
+
 	rctx, cancel := context.WithTimeout(ctx, s.cfg.RandomLoopTout)
 	defer cancel()
 
-	tc := time.NewTicker(s.cfg.RandomLoopDelay)
+	tc := time.NewTicker(s.cfg.jittered(s.cfg.RandomLoopDelay))
 	defer tc.Stop()
 
 	for {
@@ -416,123 +672,1558 @@ func (s *Set) byKindReady(ctx context.Context, kind Kind) (exitGateExt, error) {
 			return nil, ErrSetIsShutting
 		}
 
-		result, err := s.byKind(kind)
+		result, err := s.byGroupReady(name)
 		if err != nil {
 			return nil, err
 		}
 
-		if result.isReady() {
+		if result != nil {
+			s.logGate(ctx, slog.LevelDebug, "picked gate", result, slog.String("gate.group", name))
+
 			return result, nil
 		}
 
+		if s.cfg.RandomNoWait {
+			return nil, ErrNoReadyGate
+		}
+
 		select {
 		case <-rctx.Done():
 			return nil, rctx.Err()
 		case <-tc.C:
+			tc.Reset(s.cfg.jittered(s.cfg.RandomLoopDelay))
 		}
 	}
 }
 
-func (s *Set) byKind(kind Kind) (exitGateExt, error) {
-	switch kind {
-	case KindDirect:
-		return s.drt, nil
+// byGroupReady returns a random ready gate from the group name, or nil if none of the members is ready.
+//
+// Closed members are removed from groups, so a member missing from the set is under maintenance, and is waited for.
+func (s *Set) byGroupReady(name string) (exitGateExt, error) {
+	members, ok := s.groups.Get(name)
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
 
-	case KindTor:
-		result, ok := s.tgs.Random()
-		if !ok {
-			return nil, ErrNoRandomGate
-		}
+	ids := members.Keys()
+	if len(ids) == 0 {
+		return nil, ErrNoRandomGate
+	}
 
-		return result, nil
+	ready := make([]exitGateExt, 0, len(ids))
 
-	case KindWireGuard:
-		result, ok := s.wgs.Random()
-		if !ok {
-			return nil, ErrNoRandomGate
+	for i := range ids {
+		if gt, err := s.byID(ids[i]); err == nil && gt.isReady() {
+			ready = append(ready, gt)
 		}
+	}
 
-		return result, nil
+	if len(ready) == 0 {
+		return nil, nil
+	}
+
+	return ready[s.randIntN(len(ready))], nil
+}
+
+// inheritGroups puts nid into the groups oid is in, so that replacing a gate keeps its groups.
+func (s *Set) inheritGroups(oid, nid uuid.UUID) {
+	groups := s.groups.Values()
+
+	for i := range groups {
+		if _, ok := groups[i].Get(oid); ok {
+			groups[i].Set(nid, struct{}{})
+			groups[i].Remove(oid)
+		}
+	}
+}
+
+// leaveGroups removes id from every group, so that a group of closed gates is seen as empty.
+func (s *Set) leaveGroups(id uuid.UUID) {
+	groups := s.groups.Values()
+
+	for i := range groups {
+		groups[i].Remove(id)
+	}
+}
+
+// NewN creates n gates of kind sequentially.
+//
+// It stops at the first error, and returns the ids of gates created so far along with the error.
+func (s *Set) NewN(ctx context.Context, kind Kind, n int) ([]uuid.UUID, error) {
+	// The limits are checked by New for each gate, so n is not trusted for the capacity.
+	result := make([]uuid.UUID, 0, max(min(n, s.cfg.TorMax), 0))
+
+	for i := 0; i < n; i++ {
+		id, err := s.New(ctx, kind)
+		if err != nil {
+			return result, err
+		}
+
+		result = append(result, id)
+	}
+
+	return result, nil
+}
+
+func (s *Set) GateIDs(kind Kind) ([]uuid.UUID, error) {
+	switch kind {
+	case KindDirect:
+		if s.drs.Len() == 0 {
+			return []uuid.UUID{}, nil
+		}
+
+		return s.drs.Keys(), nil
+
+	case KindTor:
+		return s.tgs.Keys(), nil
+
+	case KindWireGuard:
+		return s.wgs.Keys(), nil
+
+	case KindOpenVPN:
+		return s.ovs.Keys(), nil
+
+	case KindTorWG:
+		return s.tws.Keys(), nil
+
+	default:
+		return nil, ErrKindUnknown
+	}
+}
+
+// Count returns the number of gates of kind, without listing them.
+//
+// Direct gates are counted as registered, i.e. one unless disabled or bound to several local addresses.
+// An unknown kind has no gates.
+func (s *Set) Count(kind Kind) int {
+	switch kind {
+	case KindDirect:
+		return s.drs.Len()
+	case KindTor:
+		return s.tgs.Len()
+	case KindWireGuard:
+		return s.wgs.Len()
+	case KindOpenVPN:
+		return s.ovs.Len()
+	case KindTorWG:
+		return s.tws.Len()
+	default:
+		return 0
+	}
+}
+
+// Total returns the number of gates of all kinds.
+func (s *Set) Total() int {
+	return s.drs.Len() + s.tgs.Len() + s.wgs.Len() + s.ovs.Len() + s.tws.Len()
+}
+
+// ConfigView returns the settings s is running with.
+func (s *Set) ConfigView() *ConfigView {
+	result := &ConfigView{
+		Default:             s.cfg.Default,
+		RandomiseKinds:      s.cfg.RandomiseKinds,
+		RandomNoWait:        s.cfg.RandomNoWait,
+		FallbackToDefault:   s.cfg.FallbackToDefault,
+		DisableDirect:       s.cfg.DisableDirect,
+		WarmupOnCreate:      s.cfg.WarmupOnCreate,
+		TorHTTPTimeout:      s.cfg.HTTPTimeoutFor(KindTor),
+		WGHTTPTimeout:       s.cfg.HTTPTimeoutFor(KindWireGuard),
+		OVPNHTTPTimeout:     s.cfg.HTTPTimeoutFor(KindOpenVPN),
+		DirectHTTPTimeout:   s.cfg.HTTPTimeoutFor(KindDirect),
+		RandomLoopTout:      s.cfg.RandomLoopTout,
+		RandomLoopDelay:     s.cfg.RandomLoopDelay,
+		StateLoopTout:       s.cfg.StateLoopTout,
+		StateLoopDelay:      s.cfg.StateLoopDelay,
+		LoopJitter:          s.cfg.LoopJitter,
+		TorStartupTout:      s.cfg.TorStartupTout,
+		TorMax:              s.cfg.TorMax,
+		TorAPIMax:           s.cfg.TorAPIMax,
+		TorMin:              s.cfg.TorMin,
+		TorMaxAge:           s.cfg.TorMaxAge,
+		TorCheckInterval:    s.cfg.TorCheckInterval,
+		FailThreshold:       s.cfg.FailThreshold,
+		FailCooldown:        s.cfg.FailCooldown,
+		MaxDialRetries:      s.cfg.MaxDialRetries,
+		AllowedConnectPorts: slices.Clone(s.cfg.AllowedConnectPorts),
+	}
+
+	return result
+}
+
+// GateInfos returns details of the gates of kind.
+func (s *Set) GateInfos(kind Kind) ([]GateInfo, error) {
+	switch kind {
+	case KindDirect:
+		return newGateInfos(s.drs.Values()), nil
+
+	case KindTor:
+		return newGateInfos(s.tgs.Values()), nil
+
+	case KindWireGuard:
+		return newGateInfos(s.wgs.Values()), nil
+
+	case KindOpenVPN:
+		return newGateInfos(s.ovs.Values()), nil
+
+	case KindTorWG:
+		return newGateInfos(s.tws.Values()), nil
+
+	default:
+		return nil, ErrKindUnknown
+	}
+}
+
+// Stats returns aggregate stats of the gates, per kind in a fixed order.
+//
+// Each kind is counted over a snapshot, so the numbers are approximate while gates change state.
+// Gates that are out for maintenance or being closed are counted under their state, along with their requests.
+func (s *Set) Stats() SetStats {
+	detached := s.detached.Values()
+
+	result := SetStats{
+		Kinds: []KindStats{
+			newKindStats(KindDirect, s.drs.Values()),
+			newKindStats(KindTor, withDetached(KindTor, s.tgs.Values(), detached)),
+			newKindStats(KindWireGuard, withDetached(KindWireGuard, s.wgs.Values(), detached)),
+			newKindStats(KindOpenVPN, withDetached(KindOpenVPN, s.ovs.Values(), detached)),
+			newKindStats(KindTorWG, withDetached(KindTorWG, s.tws.Values(), detached)),
+		},
+	}
+
+	for i := range result.Kinds {
+		result.InFlight += result.Kinds[i].InFlight
+	}
+
+	return result
+}
+
+// GateInfo returns details of the gate identified by id, whether it's ready or not.
+func (s *Set) GateInfo(id uuid.UUID) (GateInfo, error) {
+	gt, err := s.byID(id)
+	if err != nil {
+		return GateInfo{}, err
+	}
+
+	return newGateInfo(gt), nil
+}
+
+// AnnotateOne merges annots into the annotations of the gate identified by id, and returns the result.
+//
+// An empty value removes the annotation. Annotations are for humans, and don't affect routing.
+func (s *Set) AnnotateOne(id uuid.UUID, annots map[string]string) (map[string]string, error) {
+	gt, err := s.byID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return gt.annotate(annots)
+}
+
+// maxProbeDrain is the most of a probe response body read before closing it, so that the connection can be reused.
+const maxProbeDrain = 64 << 10
+
+// ProbeOne sends a GET request to rawURL via the gate identified by id.
+//
+// Unlike warmup, the target is arbitrary, and any response counts as a result.
+// The latency is the time until the response headers are received.
+// When SetConfig.BlockPrivateDests is set, probes to private destinations are refused the same way as proxied requests.
+func (s *Set) ProbeOne(ctx context.Context, id uuid.UUID, rawURL string) (*ProbeResult, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, ErrInvalidProbeURL
+	}
+
+	gt, err := s.byID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !gt.isReady() {
+		return nil, ErrGateNotReady
+	}
+
+	// Keep the gate from being closed under the probe.
+	gt.AddReq()
+	defer gt.DidReq()
+
+	// Literal addresses are checked up front, as Tor gates don't check the address they dial.
+	if s.cfg.BlockPrivateDests {
+		if ip, err := netip.ParseAddr(target.Hostname()); err == nil {
+			if err := s.cfg.checkProbeDest(ip); err != nil {
+				return nil, err
+			}
+		}
+
+		ctx = WithDestCheck(ctx, s.cfg.checkProbeDest)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	resp, err := gt.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	lat := time.Since(now)
+
+	_, _ = io.CopyN(io.Discard, resp.Body, maxProbeDrain)
+	_ = resp.Body.Close()
+
+	result := &ProbeResult{
+		Status:  resp.StatusCode,
+		Latency: lat,
+	}
+
+	return result, nil
+}
+
+func (s *Set) RefreshOne(ctx context.Context, id uuid.UUID) error {
+	if s.isDirectID(id) {
+		return ErrKindNotSupported
+	}
+
+	gt, err := s.byID(id)
+	if err != nil {
+		return err
+	}
+
+	if k := gt.Kind(); k == KindDirect {
+		return ErrKindNotSupported
+	}
+
+	if !gt.lockOp() {
+		return ErrGateBusy
+	}
+
+	defer gt.unlockOp()
+
+	if err := s.forState(ctx, gt, stateMaintenance); err != nil {
+		return err
+	}
+
+	if err := refreshOne(ctx, gt); err != nil {
+		s.logGate(ctx, slog.LevelWarn, "failed to refresh gate", gt, slog.Any("error", err))
+
+		return typedNetErr(err)
+	}
+
+	s.logGate(ctx, slog.LevelInfo, "refreshed gate", gt)
+
+	return s.toState(gt, stateReady)
+}
+
+func (s *Set) CloseOne(ctx context.Context, id uuid.UUID) error {
+	if s.isDirectID(id) {
+		return ErrKindNotSupported
+	}
+
+	gt, err := s.byID(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkNoDependants(gt); err != nil {
+		return err
+	}
+
+	if !gt.lockOp() {
+		return ErrGateBusy
+	}
+
+	defer gt.unlockOp()
+
+	if err := s.forState(ctx, gt, stateClosed); err != nil {
+		return err
+	}
+
+	return s.shutdownOne(ctx, gt)
+}
+
+// ForceCloseOne closes the gate with id without waiting for its requests to finish.
+//
+// It is meant for gates that are stuck, and would not drain within StateLoopTout.
+func (s *Set) ForceCloseOne(ctx context.Context, id uuid.UUID) error {
+	if s.isDirectID(id) {
+		return ErrKindNotSupported
+	}
+
+	if s.isShutting() {
+		return ErrSetIsShutting
+	}
+
+	gt, err := s.byID(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkNoDependants(gt); err != nil {
+		return err
+	}
+
+	s.detach(ctx, gt, stateClosed)
+
+	return s.shutdownOne(ctx, gt)
+}
+
+// Shutdown closes all gates.
+//
+// When SetConfig.ShutdownTimeout is set, it bounds the shutdown even if ctx has no deadline.
+func (s *Set) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	s.onceShut.Do(func() {
+		closeOrSkip(s.shutting)
+
+		if s.cfg.ShutdownTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.cfg.ShutdownTimeout)
+			defer cancel()
+		}
+
+		s.lg.LogAttrs(ctx, slog.LevelInfo, "shutting down gates", slog.Int("tor", s.tgs.Len()), slog.Int("tor_wireguard", s.tws.Len()), slog.Int("wireguard", s.wgs.Len()), slog.Int("openvpn", s.ovs.Len()))
+
+		errc := make(chan error, 4)
+		wg := &sync.WaitGroup{}
+
+		if s.tgs.Len() > 0 {
+			wg.Add(1)
+
+			go func(tgs []*Tor) {
+				defer wg.Done()
+
+				errc <- ShutdownList(ctx, tgs)
+			}(s.tgs.Values())
+		}
+
+		if s.tws.Len() > 0 {
+			wg.Add(1)
+
+			go func(tws []*Tor) {
+				defer wg.Done()
+
+				errc <- ShutdownList(ctx, tws)
+			}(s.tws.Values())
+		}
+
+		if s.wgs.Len() > 0 {
+			wg.Add(1)
+
+			go func(wgs []*WireGuard) {
+				defer wg.Done()
+
+				errc <- ShutdownList(ctx, wgs)
+			}(s.wgs.Values())
+		}
+
+		if s.ovs.Len() > 0 {
+			wg.Add(1)
+
+			go func(ovs []*OpenVPN) {
+				defer wg.Done()
+
+				errc <- ShutdownList(ctx, ovs)
+			}(s.ovs.Values())
+		}
+
+		go func() { wg.Wait(); close(errc) }()
+
+		errs = collectErrs(errc)
+	})
+
+	return errors.Join(errs...)
+}
+
+func (s *Set) Warmup(ctx context.Context) error {
+	if s.isShutting() {
+		return errors.Join(ErrSetIsShutting)
+	}
+
+	if ok := atomic.CompareAndSwapUint32(&s.warming.value, 0, 1); !ok {
+		return errors.Join(ErrSetIsWarmingUp)
+	}
+
+	defer func() { atomic.StoreUint32(&s.warming.value, 0) }()
+
+	s.lg.LogAttrs(ctx, slog.LevelDebug, "warming up gates", slog.Int("tor", s.tgs.Len()), slog.Int("tor_wireguard", s.tws.Len()), slog.Int("wireguard", s.wgs.Len()), slog.Int("openvpn", s.ovs.Len()))
+
+	errc := make(chan error, 4)
+	wg := &sync.WaitGroup{}
+
+	if s.tgs.Len() > 0 && !s.cfg.SkipWarmup[KindTor] {
+		wg.Add(1)
+
+		go func(tgs []*Tor) {
+			defer wg.Done()
+
+			lats, err := WarmupList(ctx, warmupersFor(s.cfg, tgs))
+			s.logSlowest(ctx, KindTor, lats)
+
+			errc <- err
+		}(s.tgs.Values())
+	}
+
+	if s.tws.Len() > 0 && !s.cfg.SkipWarmup[KindTorWG] {
+		wg.Add(1)
+
+		go func(tws []*Tor) {
+			defer wg.Done()
+
+			lats, err := WarmupList(ctx, warmupersFor(s.cfg, tws))
+			s.logSlowest(ctx, KindTorWG, lats)
+
+			errc <- err
+		}(s.tws.Values())
+	}
+
+	if s.wgs.Len() > 0 && !s.cfg.SkipWarmup[KindWireGuard] {
+		wg.Add(1)
+
+		go func(wgs []*WireGuard) {
+			defer wg.Done()
+
+			lats, err := WarmupList(ctx, warmupersFor(s.cfg, wgs))
+			s.logSlowest(ctx, KindWireGuard, lats)
+
+			errc <- err
+		}(s.wgs.Values())
+	}
+
+	if s.ovs.Len() > 0 && !s.cfg.SkipWarmup[KindOpenVPN] {
+		wg.Add(1)
+
+		go func(ovs []*OpenVPN) {
+			defer wg.Done()
+
+			lats, err := WarmupList(ctx, warmupersFor(s.cfg, ovs))
+			s.logSlowest(ctx, KindOpenVPN, lats)
+
+			errc <- err
+		}(s.ovs.Values())
+	}
+
+	go func() { wg.Wait(); close(errc) }()
+
+	if errs := collectErrs(errc); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	s.onceReady.Do(func() { close(s.ready) })
+
+	return nil
+}
+
+// WarmupIDs warms up the gates with the given ids, such as those added by Reload.
+//
+// Unlike Warmup, it doesn't mark the set as warming, so the other gates keep serving requests.
+// Unknown ids and kinds in SetConfig.SkipWarmup are ignored.
+func (s *Set) WarmupIDs(ctx context.Context, ids []uuid.UUID) error {
+	if s.isShutting() {
+		return errors.Join(ErrSetIsShutting)
+	}
+
+	byKind := make(map[Kind][]exitGateExt)
+
+	for i := range ids {
+		gt, err := s.byID(ids[i])
+		if err != nil || s.cfg.SkipWarmup[gt.Kind()] {
+			continue
+		}
+
+		byKind[gt.Kind()] = append(byKind[gt.Kind()], gt)
+	}
+
+	if len(byKind) == 0 {
+		return nil
+	}
+
+	errc := make(chan error, len(byKind))
+	wg := &sync.WaitGroup{}
+
+	for kind, gts := range byKind {
+		wg.Add(1)
+
+		go func(kind Kind, gts []exitGateExt) {
+			defer wg.Done()
+
+			lats, err := WarmupList(ctx, warmupersFor(s.cfg, gts))
+			s.logSlowest(ctx, kind, lats)
+
+			errc <- err
+		}(kind, gts)
+	}
+
+	go func() { wg.Wait(); close(errc) }()
+
+	return errors.Join(collectErrs(errc)...)
+}
+
+// IsWarming reports whether a warmup is in progress.
+func (s *Set) IsWarming() bool {
+	return atomic.LoadUint32(&s.warming.value) == 1
+}
+
+// Pause stops handing out gates for requests until Resume is called.
+//
+// Gates are kept running, and requests in flight are not affected.
+func (s *Set) Pause(ctx context.Context) error {
+	if s.isShutting() {
+		return ErrSetIsShutting
+	}
+
+	if atomic.CompareAndSwapUint32(&s.paused.value, 0, 1) {
+		s.lg.LogAttrs(ctx, slog.LevelInfo, "paused set")
+	}
+
+	return nil
+}
+
+// Resume undoes Pause.
+func (s *Set) Resume(ctx context.Context) error {
+	if s.isShutting() {
+		return ErrSetIsShutting
+	}
+
+	if atomic.CompareAndSwapUint32(&s.paused.value, 1, 0) {
+		s.lg.LogAttrs(ctx, slog.LevelInfo, "resumed set")
+	}
+
+	return nil
+}
+
+// IsPaused reports whether the set is paused.
+func (s *Set) IsPaused() bool {
+	return atomic.LoadUint32(&s.paused.value) == 1
+}
+
+// Ready returns a channel that is closed after the first successful warmup.
+func (s *Set) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Reload re-reads the configured sources of gates.
+//
+// Currently, only WireGuard configs are reloaded from SetConfig.WGFile if set, or SetConfig.WGDir otherwise.
+func (s *Set) Reload(ctx context.Context) (*ReloadResult, error) {
+	if s.cfg.WGFile != "" {
+		return s.ReloadWireGuardsFile(ctx, s.cfg.WGFile)
+	}
+
+	return s.ReloadWireGuards(ctx, s.cfg.WGDir)
+}
+
+// ReloadWireGuards brings the WireGuard gates in line with the configs in dir.
+//
+// Gates for newly-added configs are started, gates for removed configs are closed,
+// and gates for unchanged configs are left running.
+// Configs are matched by WGConfig.Key.
+//
+// Parsing errors are handled according to SetConfig.WGParseMode, and peers without AllowedIPs according to SetConfig.WGAllowedIPsMode.
+// Errors encountered when starting or closing gates are collected and returned along with the result.
+func (s *Set) ReloadWireGuards(ctx context.Context, dir string) (*ReloadResult, error) {
+	return s.reloadWireGuards(ctx, func() ([]*WGConfig, error) {
+		return parseWGConfigs(s.cfg.WGParseMode, s.cfg.WGAllowedIPsMode, dir)
+	})
+}
+
+// ReloadWireGuardsFile is like ReloadWireGuards, but reads the configs from a single combined file.
+//
+// See ParseWGConfigsFile for the format.
+func (s *Set) ReloadWireGuardsFile(ctx context.Context, fpath string) (*ReloadResult, error) {
+	return s.reloadWireGuards(ctx, func() ([]*WGConfig, error) {
+		return ParseWGConfigsFile(s.cfg.WGParseMode, s.cfg.WGAllowedIPsMode, fpath)
+	})
+}
+
+func (s *Set) reloadWireGuards(ctx context.Context, fnParse func() ([]*WGConfig, error)) (*ReloadResult, error) {
+	if s.isShutting() {
+		return nil, ErrSetIsShutting
+	}
+
+	if ok := atomic.CompareAndSwapUint32(&s.reloading.value, 0, 1); !ok {
+		return nil, ErrSetIsReloading
+	}
+
+	defer func() { atomic.StoreUint32(&s.reloading.value, 0) }()
+
+	cfgs, err := fnParse()
+	if err != nil && (s.cfg.WGParseMode != WGParseModeReport || cfgs == nil) {
+		return nil, err
+	}
+
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if !s.cfg.WGListenPort {
+		ign := IgnoreWGListenPorts(cfgs)
+
+		for i := range ign {
+			s.lg.LogAttrs(ctx, slog.LevelWarn, "ignored listen port", slog.String("gate.kind", KindWireGuard.String()), slog.String("wg.key", ign[i].Key()), slog.String("wg.endpoint", ign[i].Peer.Endpoint))
+		}
+	}
+
+	if s.cfg.WGDedup {
+		var dups []*WGConfig
+		cfgs, dups = DedupWGConfigs(cfgs)
+
+		for i := range dups {
+			s.lg.LogAttrs(ctx, slog.LevelWarn, "skipped duplicate config", slog.String("gate.kind", KindWireGuard.String()), slog.String("wg.key", dups[i].Key()), slog.String("wg.endpoint", dups[i].Peer.Endpoint))
+		}
+	}
+
+	nextCfgs := make(map[string]*WGConfig, len(cfgs))
+	for i := range cfgs {
+		nextCfgs[cfgs[i].Key()] = cfgs[i]
+	}
+
+	curr := make(map[string]*WireGuard, s.wgs.Len())
+	for _, gt := range s.wgs.Values() {
+		curr[gt.key] = gt
+	}
+
+	result := &ReloadResult{}
+
+	for key, gt := range curr {
+		if _, ok := nextCfgs[key]; ok {
+			result.Unchanged++
+
+			continue
+		}
+
+		// The gate is kept until the Tor gates chained over it are closed.
+		if err := s.checkNoDependants(gt); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if err := s.forState(ctx, gt, stateClosed); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if err := s.shutdownOne(ctx, gt); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		result.Removed = append(result.Removed, gt.id)
+	}
+
+	for key, cfg := range nextCfgs {
+		if _, ok := curr[key]; ok {
+			continue
+		}
+
+		gt, err := s.wf.new(s.cfg.logger(), cfg, s.cfg.WGDNS, s.cfg.HTTPTimeoutFor(KindWireGuard))
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		s.wgs.Set(gt.id, gt)
+
+		s.logGate(ctx, slog.LevelInfo, "created gate", gt)
+
+		result.Added = append(result.Added, gt.id)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// CloseIdle closes the gates of kind that have had no requests for at least minIdle.
+//
+// The longest idle gates are closed first, and at least SetConfig.TorMin gates are kept.
+// Currently, only Tor gates can be closed this way.
+// It returns the ids of the closed gates, along with any errors encountered.
+func (s *Set) CloseIdle(ctx context.Context, kind Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+	if kind != KindTor {
+		return nil, ErrKindNotSupported
+	}
+
+	if s.isShutting() {
+		return nil, ErrSetIsShutting
+	}
+
+	now := timeNow()
+
+	type idleGate struct {
+		gt   *Tor
+		idle time.Duration
+	}
+
+	var cands []idleGate
+
+	for _, gt := range s.tgs.Values() {
+		if !gt.isReady() {
+			continue
+		}
+
+		if idle, ok := gt.idleFor(now); ok && idle >= minIdle {
+			cands = append(cands, idleGate{gt: gt, idle: idle})
+		}
+	}
+
+	sort.Slice(cands, func(i, j int) bool { return cands[i].idle > cands[j].idle })
+
+	var result []uuid.UUID
+	var errs []error
+
+	for i := range cands {
+		if s.tgs.Len() <= s.cfg.TorMin {
+			break
+		}
+
+		gt := cands[i].gt
+
+		if err := s.forState(ctx, gt, stateClosed); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if err := s.shutdownOne(ctx, gt); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		result = append(result, gt.id)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// ScaleTors creates or closes Tor gates until there are n of them.
+//
+// The target must not exceed SetConfig.TorMax, while SetConfig.TorMin does not apply as the target is explicit.
+// Gates chained over WireGuard are neither counted nor closed.
+// Gates are created one by one, and creation stops at the first error.
+// Gates with fewer requests in flight are closed first, and older ones among those.
+// It returns the ids of the created and closed gates, along with any errors encountered.
+func (s *Set) ScaleTors(ctx context.Context, n int) (*ScaleResult, error) {
+	if n > s.cfg.TorMax {
+		return nil, ErrTorMaxReached
+	}
+
+	if s.isShutting() {
+		return nil, ErrSetIsShutting
+	}
+
+	result := &ScaleResult{}
+
+	if cur := s.tgs.Len(); n >= cur {
+		var err error
+		result.Created, err = s.NewN(ctx, KindTor, n-cur)
+
+		return result, err
+	}
+
+	tgs := s.tgs.Values()
+
+	sort.Slice(tgs, func(i, j int) bool {
+		if ri, rj := tgs[i].reqNum(), tgs[j].reqNum(); ri != rj {
+			return ri < rj
+		}
+
+		return tgs[i].CreatedAt().Before(tgs[j].CreatedAt())
+	})
+
+	var errs []error
+
+	for i := range tgs {
+		if s.tgs.Len() <= n {
+			break
+		}
+
+		gt := tgs[i]
+
+		if err := s.forState(ctx, gt, stateClosed); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if err := s.shutdownOne(ctx, gt); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		result.Closed = append(result.Closed, gt.id)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// RotateTors replaces the Tor gates older than SetConfig.TorMaxAge with new ones.
+//
+// Each gate is drained before it's replaced, and is put back if the replacement cannot be created.
+// Gates chained over WireGuard are rotated, too, and their replacements are chained the same way.
+// It returns the ids of the replacements, along with any errors encountered.
+func (s *Set) RotateTors(ctx context.Context) ([]uuid.UUID, error) {
+	if s.cfg.TorMaxAge <= 0 {
+		return nil, nil
+	}
+
+	if s.isShutting() {
+		return nil, ErrSetIsShutting
+	}
+
+	now := timeNow()
+
+	var result []uuid.UUID
+	var errs []error
+
+	for _, gt := range s.allTors() {
+		if now.Sub(gt.CreatedAt()) < s.cfg.TorMaxAge {
+			continue
+		}
+
+		id, err := s.rotateTor(ctx, gt)
+		if id != uuid.Nil {
+			result = append(result, id)
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// RunTorRotation periodically rotates Tor gates until ctx is done or s is shutting.
+//
+// It does nothing when SetConfig.TorMaxAge is zero.
+func (s *Set) RunTorRotation(ctx context.Context) {
+	if s.cfg.TorMaxAge <= 0 {
+		return
+	}
+
+	delay := min(s.cfg.TorMaxAge, torRotateDelay)
+
+	tc := time.NewTicker(s.cfg.jittered(delay))
+	defer tc.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutting:
+			return
+		case <-tc.C:
+			tc.Reset(s.cfg.jittered(delay))
+		}
+
+		ids, err := s.RotateTors(ctx)
+		if err != nil {
+			s.lg.LogAttrs(ctx, slog.LevelWarn, "failed to rotate some tor gates", slog.Any("error", err))
+		}
+
+		if len(ids) > 0 {
+			s.lg.LogAttrs(ctx, slog.LevelInfo, "rotated tor gates", slog.Int("count", len(ids)))
+		}
+	}
+}
+
+// CheckTors replaces the Tor gates whose control connection is lost with new ones.
+//
+// Only ready gates are checked.
+// It returns the ids of the replacements, along with any errors encountered.
+func (s *Set) CheckTors(ctx context.Context) ([]uuid.UUID, error) {
+	if s.isShutting() {
+		return nil, ErrSetIsShutting
+	}
+
+	var result []uuid.UUID
+	var errs []error
+
+	for _, gt := range s.allTors() {
+		if !gt.isReady() {
+			continue
+		}
+
+		cctx, cancel := context.WithTimeout(ctx, torCheckTout)
+		cerr := gt.check(cctx)
+		cancel()
+
+		if cerr == nil {
+			continue
+		}
+
+		s.logGate(ctx, slog.LevelWarn, "lost tor control connection", gt, slog.Any("error", cerr))
+
+		id, err := s.healTor(ctx, gt)
+		if id != uuid.Nil {
+			result = append(result, id)
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// RunTorChecks periodically checks Tor gates until ctx is done or s is shutting.
+//
+// It does nothing when SetConfig.TorCheckInterval is zero.
+func (s *Set) RunTorChecks(ctx context.Context) {
+	if s.cfg.TorCheckInterval <= 0 {
+		return
+	}
+
+	tc := time.NewTicker(s.cfg.jittered(s.cfg.TorCheckInterval))
+	defer tc.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutting:
+			return
+		case <-tc.C:
+			tc.Reset(s.cfg.jittered(s.cfg.TorCheckInterval))
+		}
+
+		ids, err := s.CheckTors(ctx)
+		if err != nil {
+			s.lg.LogAttrs(ctx, slog.LevelWarn, "failed to replace some tor gates", slog.Any("error", err))
+		}
+
+		if len(ids) > 0 {
+			s.lg.LogAttrs(ctx, slog.LevelInfo, "replaced tor gates", slog.Int("count", len(ids)))
+		}
+	}
+}
+
+var (
+	// weightedKinds fixes the order of kinds for a weighted pick.
+	weightedKinds = []Kind{KindTor, KindWireGuard, KindDirect, KindOpenVPN, KindTorWG}
+
+	defKindWeights = map[Kind]int{KindTor: 1, KindWireGuard: 1}
+)
+
+func (s *Set) kindOrDefault() Kind {
+	return s.kindOrDefaultN(s.randIntN(math.MaxInt))
+}
+
+func (s *Set) kindOrDefaultN(n int) Kind {
+	if !s.cfg.RandomiseKinds {
+		return s.cfg.Default
+	}
+
+	return pickRandomKind(s.cfg.RandomKindWeights, n)
+}
+
+func (s *Set) randIntN(n int) int {
+	if s.intn == nil {
+		return rand.IntN(n)
+	}
+
+	return s.intn(n)
+}
+
+func (s *Set) byID(id uuid.UUID) (exitGateExt, error) {
+	if result, ok := s.drs.Get(id); ok {
+		return result, nil
+	}
+
+	// The default direct gate is known even when it's not registered.
+	if id == directID {
+		return nil, ErrKindNotSupported
+	}
+
+	if result, ok := s.tgs.Get(id); ok {
+		return result, nil
+	}
+
+	if result, ok := s.wgs.Get(id); ok {
+		return result, nil
+	}
+
+	if result, ok := s.ovs.Get(id); ok {
+		return result, nil
+	}
+
+	if result, ok := s.tws.Get(id); ok {
+		return result, nil
+	}
+
+	return nil, ErrGateNotFound
+}
+
+func (s *Set) byKindReady(ctx context.Context, kind Kind) (exitGateExt, error) {
+	return s.byKindReadyExcept(ctx, kind, uuid.Nil)
+}
+
+func (s *Set) byKindReadyExcept(ctx context.Context, kind Kind, id uuid.UUID) (exitGateExt, error) {
+	rctx, cancel := context.WithTimeout(ctx, s.cfg.RandomLoopTout)
+	defer cancel()
+
+	tc := time.NewTicker(s.cfg.jittered(s.cfg.RandomLoopDelay))
+	defer tc.Stop()
+
+	// Selection happens on every request, so only build the attributes when they are going to be logged.
+	debug := s.lg.Enabled(ctx, slog.LevelDebug)
+
+	for attempt := 1; ; attempt++ {
+		if s.isShutting() {
+			return nil, ErrSetIsShutting
+		}
+
+		result, err := s.byKindExcept(kind, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if result.isReady() {
+			if debug {
+				s.lg.LogAttrs(
+					ctx,
+					slog.LevelDebug,
+					"picked gate",
+					slog.String("gate.kind", kind.String()),
+					slog.String("gate.id", result.ID().String()),
+					slog.Uint64("gate.reqs", result.reqNum()),
+					slog.Int("attempt", attempt),
+				)
+			}
+
+			return result, nil
+		}
+
+		if debug {
+			s.lg.LogAttrs(
+				ctx,
+				slog.LevelDebug,
+				"skipped gate not ready",
+				slog.String("gate.kind", kind.String()),
+				slog.String("gate.id", result.ID().String()),
+				slog.Int("attempt", attempt),
+			)
+		}
+
+		if s.cfg.RandomNoWait {
+			// There is no next attempt, so look past the random pick for a gate that is ready.
+			if result := s.readyOfKindExcept(kind, id); result != nil {
+				s.logGate(ctx, slog.LevelDebug, "picked gate", result, slog.Int("attempt", attempt))
+
+				return result, nil
+			}
+
+			return nil, ErrNoReadyGate
+		}
+
+		select {
+		case <-rctx.Done():
+			return nil, rctx.Err()
+		case <-tc.C:
+			tc.Reset(s.cfg.jittered(s.cfg.RandomLoopDelay))
+		}
+	}
+}
+
+// readyOfKindExcept returns a random ready gate of kind, other than id, or nil if none is ready.
+func (s *Set) readyOfKindExcept(kind Kind, id uuid.UUID) exitGateExt {
+	gts, _ := s.attachedOf(kind)
+
+	ready := make([]exitGateExt, 0, len(gts))
+
+	for i := range gts {
+		if gts[i].ID() != id && gts[i].isReady() {
+			ready = append(ready, gts[i])
+		}
+	}
+
+	if len(ready) == 0 {
+		return nil
+	}
+
+	return ready[s.randIntN(len(ready))]
+}
+
+// attachedOf returns the gates of kind that are in the set, i.e. not detached.
+func (s *Set) attachedOf(kind Kind) ([]exitGateExt, error) {
+	switch kind {
+	case KindDirect:
+		return exitGates(s.drs.Values()), nil
+	case KindTor:
+		return exitGates(s.tgs.Values()), nil
+	case KindWireGuard:
+		return exitGates(s.wgs.Values()), nil
+	case KindOpenVPN:
+		return exitGates(s.ovs.Values()), nil
+	case KindTorWG:
+		return exitGates(s.tws.Values()), nil
+	default:
+		return nil, ErrKindUnknown
+	}
+}
+
+func (s *Set) byKind(kind Kind) (exitGateExt, error) {
+	return s.byKindExcept(kind, uuid.Nil)
+}
+
+func (s *Set) byKindExcept(kind Kind, id uuid.UUID) (exitGateExt, error) {
+	switch kind {
+	case KindDirect:
+		if s.drs.Len() == 0 {
+			return nil, ErrKindNotSupported
+		}
+
+		result, ok := s.drs.RandomExcept(id)
+		if !ok {
+			return nil, ErrNoRandomGate
+		}
+
+		return result, nil
+
+	case KindTor:
+		result, ok := s.tgs.RandomExcept(id)
+		if !ok {
+			return nil, ErrNoRandomGate
+		}
+
+		return result, nil
+
+	case KindWireGuard:
+		result, ok := s.wgs.RandomExcept(id)
+		if !ok {
+			return nil, ErrNoRandomGate
+		}
+
+		return result, nil
+
+	case KindOpenVPN:
+		result, ok := s.ovs.RandomExcept(id)
+		if !ok {
+			return nil, ErrNoRandomGate
+		}
+
+		return result, nil
+
+	case KindTorWG:
+		result, ok := s.tws.RandomExcept(id)
+		if !ok {
+			return nil, ErrNoRandomGate
+		}
+
+		return result, nil
 
 	default:
 		return nil, ErrKindUnknown
 	}
 }
 
-func (s *Set) forState(ctx context.Context, gt exitGateExt, st state) error {
-	// Consider what to do in a case of failure below.
-	//
-	// The gate has been moved to st (Maintenance or Closed), and removed from the set.
-	// There are a couple of situations when the gate would have to be put back:
-	// - if the incoming request's context is done, i.e. the client times out or abandons;
-	// - if the loop times out, i.e. the gate is busy serving connections.
-	//
-	// Possible options are:
-	// - do nothing, but the gate would be left in an unknown state;
-	// - recover the gate's state and put it back;
-	// - stop it.
-	//
-	// It's the caller who knows what needs to be done to the gate.
-	// Ideally, operations should be idempotent, so that the caller could retry.
-	// Therefore, option 2 is a reasonable choice.
-	origst := gt.getState()
-	gt.toState(st)
+func (s *Set) forState(ctx context.Context, gt exitGateExt, st state) error {
+	// Consider what to do in a case of failure below.
+	//
+	// The gate has been moved to st (Maintenance or Closed), and removed from the set.
+	// There are a couple of situations when the gate would have to be put back:
+	// - if the incoming request's context is done, i.e. the client times out or abandons;
+	// - if the loop times out, i.e. the gate is busy serving connections.
+	//
+	// Possible options are:
+	// - do nothing, but the gate would be left in an unknown state;
+	// - recover the gate's state and put it back;
+	// - stop it.
+	//
+	// It's the caller who knows what needs to be done to the gate.
+	// Ideally, operations should be idempotent, so that the caller could retry.
+	// Therefore, option 2 is a reasonable choice.
+	origst := s.detach(ctx, gt, st)
+
+	rctx, cancel := context.WithTimeout(ctx, s.cfg.StateLoopTout)
+	defer cancel()
+
+	tc := time.NewTicker(s.cfg.jittered(s.cfg.StateLoopDelay))
+	defer tc.Stop()
+
+	for {
+		if s.isShutting() {
+			return ErrSetIsShutting
+		}
+
+		if gt.noReqs() {
+			return nil
+		}
+
+		select {
+		case <-rctx.Done():
+			_ = s.toState(gt, origst)
+
+			return rctx.Err()
+		case <-tc.C:
+			tc.Reset(s.cfg.jittered(s.cfg.StateLoopDelay))
+		}
+	}
+}
+
+// detach moves gt to st, and removes it from the set.
+//
+// It returns the state gt was in before.
+func (s *Set) detach(ctx context.Context, gt exitGateExt, st state) state {
+	origst := gt.getState()
+	gt.toState(st)
+
+	s.logGate(ctx, slog.LevelDebug, "changed gate state", gt, slog.String("state.from", origst.String()), slog.String("state.to", st.String()))
+
+	id := gt.ID()
+
+	// Direct gates stay in the set.
+	// Others are tracked before they are removed, so that the gate is never missing from both.
+	if gt.Kind() != KindDirect {
+		s.detached.Set(id, gt)
+	}
+
+	switch gt.Kind() {
+	case KindTor:
+		s.tgs.Remove(id)
+	case KindWireGuard:
+		s.wgs.Remove(id)
+	case KindOpenVPN:
+		s.ovs.Remove(id)
+	case KindTorWG:
+		s.tws.Remove(id)
+	}
+
+	return origst
+}
+
+func (s *Set) toState(gt exitGateExt, st state) error {
+	gt.resetReqs()
+
+	origst := gt.getState()
+
+	// Don't put the gate back when the transition is rejected.
+	// For example, a closed gate must not become available again.
+	if err := gt.toStateErr(st); err != nil {
+		return err
+	}
+
+	s.logGate(context.Background(), slog.LevelDebug, "changed gate state", gt, slog.String("state.from", origst.String()), slog.String("state.to", st.String()))
+
+	// The gate is untracked only once it's back, so that it's never missing from both.
+	defer s.detached.Remove(gt.ID())
+
+	switch gtx := gt.(type) {
+	case *Direct:
+		return nil
+	case *Tor:
+		s.torsOf(gtx.Kind()).Set(gtx.id, gtx)
+
+		return nil
+
+	case *WireGuard:
+		s.wgs.Set(gtx.id, gtx)
+
+		return nil
+
+	case *OpenVPN:
+		s.ovs.Set(gtx.id, gtx)
+
+		return nil
+
+	default:
+		// Unreachable.
+		return ErrKindUnknown
+	}
+}
+
+// rotateTor drains gt, and replaces it with a new gate.
+//
+// It returns the id of the replacement, if one has been added.
+func (s *Set) rotateTor(ctx context.Context, gt *Tor) (uuid.UUID, error) {
+	if err := s.forState(ctx, gt, stateMaintenance); err != nil {
+		return uuid.Nil, err
+	}
+
+	ngt, err := s.newTorLike(gt)
+	if err != nil {
+		_ = s.toState(gt, stateReady)
+
+		return uuid.Nil, err
+	}
+
+	if s.cfg.WarmupOnCreate {
+		if resp := warmupOne(ctx, s.cfg.warmuperFor(ngt)); resp.err != nil {
+			_ = shutdownOne(s.cfg.BaseCtx(), ngt)
+			_ = s.toState(gt, stateReady)
+
+			return uuid.Nil, resp.err
+		}
+	}
+
+	s.torsOf(ngt.Kind()).Set(ngt.id, ngt)
+	s.inheritAPITor(gt.id, ngt.id)
+	s.inheritGroups(gt.id, ngt.id)
+
+	s.logGate(ctx, slog.LevelInfo, "created gate", ngt, slog.String("gate.replaces", gt.id.String()))
+
+	gt.toState(stateClosed)
+
+	return ngt.id, s.shutdownOne(ctx, gt)
+}
+
+// healTor replaces gt, whose control connection is lost, with a new Tor gate.
+//
+// Unlike rotateTor, gt is not drained first, as it's unlikely to serve requests well.
+// The replacement takes the place of gt in a single step, so the number of Tor gates never drops.
+// It returns the id of the replacement, if one has been added.
+func (s *Set) healTor(ctx context.Context, gt *Tor) (uuid.UUID, error) {
+	ngt, err := s.newTorLike(gt)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if s.cfg.WarmupOnCreate {
+		if resp := warmupOne(ctx, s.cfg.warmuperFor(ngt)); resp.err != nil {
+			_ = shutdownOne(s.cfg.BaseCtx(), ngt)
+
+			return uuid.Nil, resp.err
+		}
+	}
+
+	// The gate might have been stopped or rotated in the meantime.
+	if ok := s.torsOf(gt.Kind()).Replace(gt.id, ngt.id, ngt); !ok {
+		_ = shutdownOne(s.cfg.BaseCtx(), ngt)
+
+		return uuid.Nil, ErrGateNotFound
+	}
+
+	s.inheritAPITor(gt.id, ngt.id)
+	s.inheritGroups(gt.id, ngt.id)
+
+	s.logGate(ctx, slog.LevelInfo, "created gate", ngt, slog.String("gate.replaces", gt.id.String()))
+
+	gt.toState(stateClosed)
+
+	return ngt.id, s.shutdownOne(ctx, gt)
+}
+
+// newTorLike starts a Tor gate to replace gt, made the same way, so that a replacement of a chained gate is chained, too.
+func (s *Set) newTorLike(gt *Tor) (*Tor, error) {
+	tf := gt.tf
+	if tf == nil {
+		tf = s.tf
+	}
+
+	return tf.new(s.cfg.BaseCtx(), s.cfg.TorStartupTout, s.cfg.HTTPTimeoutFor(gt.Kind()))
+}
+
+// torsOf returns the gates of the Tor kind, either plain or chained over WireGuard.
+func (s *Set) torsOf(kind Kind) *model.Set[uuid.UUID, *Tor] {
+	if kind == KindTorWG {
+		return s.tws
+	}
+
+	return s.tgs
+}
+
+// allTors returns the Tor gates of both kinds.
+func (s *Set) allTors() []*Tor {
+	return append(s.tgs.Values(), s.tws.Values()...)
+}
+
+// checkNoDependants returns ErrGateBusy if Tor gates, including those under maintenance, are chained over gt.
+//
+// Closing gt would break them, as they reach the Tor network through it.
+func (s *Set) checkNoDependants(gt exitGateExt) error {
+	if gt.Kind() != KindWireGuard {
+		return nil
+	}
+
+	tws := withDetached(KindTorWG, s.tws.Values(), s.detached.Values())
+
+	for i := range tws {
+		if tg, ok := tws[i].(*Tor); ok && tg.upstream == gt.ID() {
+			return fmt.Errorf("%w: used as upstream by %s", ErrGateBusy, tg.ID())
+		}
+	}
+
+	return nil
+}
+
+// cooldown makes gt ready again after the configured cooldown, unless s is shutting.
+func (s *Set) cooldown(gt exitGateExt) {
+	tm := time.NewTimer(s.cfg.FailCooldown)
+	defer tm.Stop()
 
-	id := gt.ID()
+	select {
+	case <-s.shutting:
+		return
+	case <-tm.C:
+	}
 
-	switch gt.Kind() {
-	case KindTor:
-		s.tgs.Remove(id)
-	case KindWireGuard:
-		s.wgs.Remove(id)
+	// The transition is rejected if the gate has been closed in the meantime.
+	if err := gt.toStateErr(stateReady); err != nil {
+		return
 	}
 
-	rctx, cancel := context.WithTimeout(ctx, s.cfg.StateLoopTout)
-	defer cancel()
+	s.logGate(context.Background(), slog.LevelInfo, "gate is back from cooldown", gt)
+}
 
-	tc := time.NewTicker(s.cfg.StateLoopDelay)
-	defer tc.Stop()
+// shutdownOne closes gt, and logs the outcome.
+func (s *Set) shutdownOne(ctx context.Context, gt exitGateExt) error {
+	// The gate is out of the set for good, even if closing it fails.
+	s.apiTors.Remove(gt.ID())
+	s.detached.Remove(gt.ID())
+	s.leaveGroups(gt.ID())
 
-	for {
-		if s.isShutting() {
-			return ErrSetIsShutting
-		}
+	if err := shutdownOne(ctx, gt); err != nil {
+		s.logGate(ctx, slog.LevelWarn, "failed to close gate", gt, slog.Any("error", err))
 
-		if gt.noReqs() {
-			return nil
-		}
+		return err
+	}
 
-		select {
-		case <-rctx.Done():
-			_ = s.toState(gt, origst)
+	s.logGate(ctx, slog.LevelInfo, "closed gate", gt)
 
-			return rctx.Err()
-		case <-tc.C:
-		}
-	}
+	return nil
 }
 
-func (s *Set) toState(gt exitGateExt, st state) error {
-	gt.resetReqs()
-	gt.toState(st)
+// logSlowest logs the slowest gates of kind out of lats.
+func (s *Set) logSlowest(ctx context.Context, kind Kind, lats map[uuid.UUID]time.Duration) {
+	ids := slowestIDs(lats, numSlowestLogged)
 
-	switch gtx := gt.(type) {
-	case *Direct:
-		return nil
-	case *Tor:
-		s.tgs.Set(gtx.id, gtx)
+	for i := range ids {
+		s.lg.LogAttrs(ctx, slog.LevelInfo, "slow warmup", slog.String("gate.kind", kind.String()), slog.String("gate.id", ids[i].String()), slog.Duration("latency", lats[ids[i]]))
+	}
+}
 
-		return nil
+// logGate logs msg at lvl, along with the kind and id of gt.
+func (s *Set) logGate(ctx context.Context, lvl slog.Level, msg string, gt ExitGate, attrs ...slog.Attr) {
+	if !s.lg.Enabled(ctx, lvl) {
+		return
+	}
 
-	case *WireGuard:
-		s.wgs.Set(gtx.id, gtx)
+	gattrs := []slog.Attr{
+		slog.String("gate.kind", gt.Kind().String()),
+		slog.String("gate.id", gt.ID().String()),
+	}
 
-		return nil
+	s.lg.LogAttrs(ctx, lvl, msg, append(gattrs, attrs...)...)
+}
 
-	default:
-		// Unreachable.
-		return ErrKindUnknown
+// isDirectID reports whether id identifies a direct gate, whether it's registered or not.
+func (s *Set) isDirectID(id uuid.UUID) bool {
+	if id == directID {
+		return true
 	}
+
+	_, ok := s.drs.Get(id)
+
+	return ok
 }
 
 func (s *Set) isShutting() bool {
@@ -544,6 +2235,23 @@ func (s *Set) isShutting() bool {
 	}
 }
 
+const (
+	XFFModeAppend XFFMode = iota
+	XFFModeRemove
+	XFFModeReplace
+)
+
+// XFFMode controls how the X-Forwarded-For header is handled in forwarded HTTP requests.
+type XFFMode int
+
+const (
+	WarmupModeHTTP WarmupMode = iota
+	WarmupModeTCP
+)
+
+// WarmupMode controls how gates are warmed up when no custom warmup is set for their kind.
+type WarmupMode int
+
 type SetConfig struct {
 	Default         Kind
 	HTTPTimeout     time.Duration
@@ -553,8 +2261,177 @@ type SetConfig struct {
 	StateLoopDelay  time.Duration
 	TorStartupTout  time.Duration
 	TorMax          int
+	Tor             TorConfig
 	FnBaseCtx       func() context.Context
 	RandomiseKinds  bool
+	WarmupOnCreate  bool
+
+	// RandomKindWeights sets the share of requests for each kind when RandomiseKinds is on.
+	//
+	// Kinds without a positive weight are not picked.
+	// An empty RandomKindWeights splits requests equally between Tor and WireGuard.
+	RandomKindWeights map[Kind]int
+
+	// RandomNoWait makes picking a gate by kind fail with ErrNoReadyGate instead of waiting for a ready one.
+	RandomNoWait bool
+
+	// FallbackToDefault makes picking a gate of a kind that has no gates pick one of the Default kind instead.
+	FallbackToDefault bool
+
+	// DisableDirect prevents the direct gate from being registered and used.
+	DisableDirect bool
+
+	// Per-kind HTTP client timeouts.
+	//
+	// A zero value falls back to HTTPTimeout.
+	TorHTTPTimeout    time.Duration
+	WGHTTPTimeout     time.Duration
+	DirectHTTPTimeout time.Duration
+
+	// Settings for (re)loading WireGuard gates.
+	WGDir            string
+	WGFile           string
+	WGParseMode      WGParseMode
+	WGAllowedIPsMode WGAllowedIPsMode
+	WGDNS            netip.Addr
+
+	// WGDedup skips configs with the same content as an earlier one, and logs them.
+	WGDedup bool
+
+	// WGListenPort makes gates bind to ListenPort from their configs, which is ignored and logged otherwise.
+	WGListenPort bool
+
+	// Logger is used by the set and for gates created by it.
+	//
+	// The set logs creating, refreshing and closing gates at the info level,
+	// and state changes and gates picked for requests at the debug level.
+	// A nil Logger discards logs.
+	Logger *slog.Logger
+
+	// AllowedConnectPorts limits the destination ports for CONNECT requests.
+	//
+	// An empty list allows all ports.
+	AllowedConnectPorts []int
+
+	// BlockPrivateDests refuses requests to loopback, private and link-local addresses, so that internal services cannot be reached.
+	//
+	// Destinations given as IP literals are refused before a gate is picked.
+	// Hostnames are not resolved up front; the address a gate actually dials is checked instead, see WithDestCheck.
+	// Direct and OpenVPN gates check it in the dialer, and WireGuard gates check it before dialling through the tunnel, after resolving hostnames there.
+	// Tor gates skip the dial-time check, as hostnames are resolved on the exit side.
+	// Addresses within AllowedPrivateDests are let through.
+	BlockPrivateDests   bool
+	AllowedPrivateDests []netip.Prefix
+
+	// MaxHeaderCount and MaxHeaderBytes limit the number and total size of headers in forwarded HTTP requests.
+	//
+	// A zero value disables the respective check.
+	MaxHeaderCount int
+	MaxHeaderBytes int
+
+	// MaxBodyBytes limits the size of the body of forwarded HTTP requests.
+	//
+	// A zero MaxBodyBytes means no limit.
+	MaxBodyBytes int64
+
+	// StripUserAgent removes the User-Agent from forwarded HTTP requests.
+	//
+	// A non-empty UserAgent replaces it instead.
+	StripUserAgent bool
+	UserAgent      string
+
+	// XFFMode defaults to appending the client's address to X-Forwarded-For.
+	XFFMode XFFMode
+
+	// FailThreshold is the number of consecutive failures after which a gate is put on cooldown.
+	//
+	// A zero FailThreshold disables cooldowns.
+	FailThreshold int
+	FailCooldown  time.Duration
+
+	// TorMin is the number of Tor gates CloseIdle never goes below.
+	TorMin int
+
+	// TorAPIMax is the number of Tor gates that can run at once after being created via New, on top of the ones
+	// passed to NewSet, so that the API cannot use up TorMax.
+	//
+	// A zero TorAPIMax means that only TorMax applies.
+	TorAPIMax int
+
+	// TorMaxAge is the age after which a Tor gate is replaced with a new one.
+	//
+	// A zero TorMaxAge disables rotation.
+	TorMaxAge time.Duration
+
+	// TorCheckInterval is how often the control connections of Tor gates are checked.
+	//
+	// A gate whose control connection is lost is replaced with a new one.
+	// A zero TorCheckInterval disables the checks.
+	TorCheckInterval time.Duration
+
+	// HalfCloseTimeout limits how long a CONNECT tunnel may stay idle after one of its directions is done.
+	//
+	// A zero HalfCloseTimeout waits for both directions to finish.
+	HalfCloseTimeout time.Duration
+
+	// MaxTunnels limits the number of concurrent CONNECT tunnels across all gates.
+	//
+	// A zero MaxTunnels means no limit.
+	MaxTunnels int
+
+	// ConnectResponse is written to the client once a CONNECT tunnel is established.
+	//
+	// It must pass ValidateConnectResponse. An empty ConnectResponse falls back to DefConnectResponse.
+	ConnectResponse string
+
+	// DialTimeout limits connecting to the destination of a CONNECT request via a gate.
+	//
+	// A zero DialTimeout relies on the request context alone.
+	DialTimeout time.Duration
+
+	// MaxDialRetries is the number of times a failed request is retried via another gate of the same kind.
+	//
+	// It can be overridden per request.
+	MaxDialRetries int
+
+	// ShutdownTimeout limits how long Shutdown may take, in addition to the context given to it.
+	//
+	// A zero ShutdownTimeout relies on the context alone.
+	ShutdownTimeout time.Duration
+
+	// Warmups holds custom warmup checks per kind.
+	//
+	// Kinds without one are warmed up according to WarmupMode.
+	Warmups map[Kind]WarmupFunc
+
+	// LoopJitter adds a random delay of up to LoopJitter to each wait in the loops of the set.
+	//
+	// It spreads out operations on many gates that would otherwise happen at the same time.
+	// A zero LoopJitter keeps the delays fixed.
+	LoopJitter time.Duration
+
+	// SkipWarmup holds the kinds whose gates Warmup leaves out, so they are ready without a check.
+	//
+	// All kinds are warmed up by default.
+	SkipWarmup map[Kind]bool
+
+	// WarmupMode defaults to a plain GET request via the gate.
+	//
+	// With WarmupModeTCP, the gate only connects to WarmupAddr, a host:port, and the connect time is the latency.
+	WarmupMode WarmupMode
+	WarmupAddr string
+
+	// WarmupURLs are requested in order with the default WarmupMode, and a gate is healthy once one responds with a 2xx status.
+	//
+	// Empty WarmupURLs fall back to a single default URL.
+	WarmupURLs []string
+
+	// WarmupRetries is the number of extra attempts made when warming up a gate fails.
+	//
+	// Attempts are WarmupRetryDelay apart, and only the error of the last one counts.
+	// A zero WarmupRetries makes a single attempt.
+	WarmupRetries    int
+	WarmupRetryDelay time.Duration
 }
 
 func (c *SetConfig) BaseCtx() context.Context {
@@ -565,26 +2442,317 @@ func (c *SetConfig) BaseCtx() context.Context {
 	return c.FnBaseCtx()
 }
 
+// jittered returns d with a random extra delay of up to LoopJitter.
+func (c *SetConfig) jittered(d time.Duration) time.Duration {
+	if c.LoopJitter <= 0 {
+		return d
+	}
+
+	return d + rand.N(c.LoopJitter)
+}
+
+func (c *SetConfig) warmuperFor(gt exitGateExt) *recordingWarmuper {
+	var result warmuper = gt
+	switch fn := c.Warmups[gt.Kind()]; {
+	case fn != nil:
+		result = &funcWarmuper{gt: gt, fn: fn}
+	case c.WarmupMode == WarmupModeTCP:
+		result = &funcWarmuper{gt: gt, fn: WarmupDial(c.WarmupAddr)}
+	case len(c.WarmupURLs) > 0:
+		result = &funcWarmuper{gt: gt, fn: WarmupGet(c.WarmupURLs...)}
+	}
+
+	if c.WarmupRetries > 0 {
+		result = &retryingWarmuper{wmr: result, n: c.WarmupRetries, delay: c.WarmupRetryDelay}
+	}
+
+	return &recordingWarmuper{gt: gt, wmr: result}
+}
+
+// checkProbeDest returns ErrProbeDestNotAllowed for loopback, private and link-local addresses outside AllowedPrivateDests.
+func (c *SetConfig) checkProbeDest(ip netip.Addr) error {
+	ip = ip.Unmap()
+
+	if !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsUnspecified() {
+		return nil
+	}
+
+	for i := range c.AllowedPrivateDests {
+		if c.AllowedPrivateDests[i].Contains(ip) {
+			return nil
+		}
+	}
+
+	return ErrProbeDestNotAllowed
+}
+
+func (c *SetConfig) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return c.Logger
+}
+
+// GateInfo describes a gate.
+type GateInfo struct {
+	ID        uuid.UUID
+	Kind      Kind
+	Ready     bool
+	CreatedAt time.Time
+	LastUsed  time.Time
+
+	// WarmupErr is the error of the last warmup, and is empty if it succeeded or none was made.
+	WarmupErr string
+
+	// Latency is the latency of the last successful warmup, and is zero if none was made.
+	Latency time.Duration
+
+	// Reqs is the number of requests in flight.
+	Reqs uint64
+
+	// BytesIn and BytesOut are the totals of payload received and sent via the gate since it was created.
+	BytesIn  uint64
+	BytesOut uint64
+
+	// Annotations are set by operators via AnnotateOne.
+	Annotations map[string]string
+
+	// LocalAddr is the address a direct gate makes connections from, and is empty for other gates and unbound ones.
+	LocalAddr string
+}
+
+func newGateInfo(gt exitGateExt) GateInfo {
+	result := GateInfo{
+		ID:        gt.ID(),
+		Kind:      gt.Kind(),
+		Ready:     gt.isReady(),
+		CreatedAt: gt.CreatedAt(),
+		LastUsed:  gt.LastUsed(),
+		WarmupErr: gt.warmupErr(),
+		Latency:   gt.warmupLatency(),
+		Reqs:      gt.reqNum(),
+
+		Annotations: gt.annotations(),
+	}
+
+	result.BytesIn, result.BytesOut = gt.bytesNum()
+
+	if lgt, ok := gt.(interface{ LocalAddr() netip.Addr }); ok && lgt.LocalAddr().IsValid() {
+		result.LocalAddr = lgt.LocalAddr().String()
+	}
+
+	return result
+}
+
+func newGateInfos[T exitGateExt](gts []T) []GateInfo {
+	result := make([]GateInfo, 0, len(gts))
+
+	for i := range gts {
+		result = append(result, newGateInfo(gts[i]))
+	}
+
+	return result
+}
+
+// SetStats holds aggregate stats of a set.
+type SetStats struct {
+	Kinds    []KindStats
+	InFlight uint64
+}
+
+// KindStats holds the number of gates of a kind by state, and the requests in flight via them.
+type KindStats struct {
+	Kind        Kind
+	Total       int
+	Ready       int
+	Maintenance int
+	Closed      int
+	InFlight    uint64
+}
+
+// withDetached returns gts along with the detached gates of kind.
+func withDetached[T exitGateExt](kind Kind, gts []T, detached []exitGateExt) []exitGateExt {
+	result := exitGates(gts)
+
+	for i := range detached {
+		if detached[i].Kind() == kind {
+			result = append(result, detached[i])
+		}
+	}
+
+	return result
+}
+
+// exitGates returns gts as a slice of the interface type.
+func exitGates[T exitGateExt](gts []T) []exitGateExt {
+	result := make([]exitGateExt, 0, len(gts))
+	for i := range gts {
+		result = append(result, gts[i])
+	}
+
+	return result
+}
+
+func newKindStats[T exitGateExt](kind Kind, gts []T) KindStats {
+	result := KindStats{Kind: kind, Total: len(gts)}
+
+	for i := range gts {
+		switch gts[i].getState() {
+		case stateReady:
+			result.Ready++
+		case stateMaintenance:
+			result.Maintenance++
+		case stateClosed:
+			result.Closed++
+		}
+
+		result.InFlight += gts[i].reqNum()
+	}
+
+	return result
+}
+
+// ConfigView is the part of SetConfig that affects routing, safe to expose via the API.
+//
+// HTTP timeouts are effective values, with the fallback to HTTPTimeout applied.
+type ConfigView struct {
+	Default        Kind
+	RandomiseKinds bool
+	RandomNoWait   bool
+	DisableDirect  bool
+	WarmupOnCreate bool
+
+	FallbackToDefault bool
+
+	TorHTTPTimeout    time.Duration
+	WGHTTPTimeout     time.Duration
+	OVPNHTTPTimeout   time.Duration
+	DirectHTTPTimeout time.Duration
+	RandomLoopTout    time.Duration
+	RandomLoopDelay   time.Duration
+	StateLoopTout     time.Duration
+	StateLoopDelay    time.Duration
+	LoopJitter        time.Duration
+	TorStartupTout    time.Duration
+
+	TorMax              int
+	TorAPIMax           int
+	TorMin              int
+	TorMaxAge           time.Duration
+	TorCheckInterval    time.Duration
+	FailThreshold       int
+	FailCooldown        time.Duration
+	MaxDialRetries      int
+	AllowedConnectPorts []int
+}
+
+// ReloadResult describes the outcome of reloading gates.
+type ReloadResult struct {
+	Added     []uuid.UUID
+	Removed   []uuid.UUID
+	Unchanged int
+}
+
+// ProbeResult describes the response to a probe via a gate.
+type ProbeResult struct {
+	Status  int
+	Latency time.Duration
+}
+
+// ScaleResult describes the outcome of scaling gates.
+type ScaleResult struct {
+	Created []uuid.UUID
+	Closed  []uuid.UUID
+}
+
+// HTTPTimeoutFor returns the HTTP client timeout for kind.
+func (c *SetConfig) HTTPTimeoutFor(kind Kind) time.Duration {
+	var result time.Duration
+
+	switch kind {
+	case KindTor, KindTorWG:
+		result = c.TorHTTPTimeout
+
+	case KindWireGuard:
+		result = c.WGHTTPTimeout
+
+	case KindDirect:
+		result = c.DirectHTTPTimeout
+	}
+
+	if result == 0 {
+		return c.HTTPTimeout
+	}
+
+	return result
+}
+
+// ConnectResponseOrDefault returns ConnectResponse, or DefConnectResponse if it's empty.
+func (c *SetConfig) ConnectResponseOrDefault() string {
+	if c.ConnectResponse == "" {
+		return DefConnectResponse
+	}
+
+	return c.ConnectResponse
+}
+
+// ValidateConnectResponse checks that resp is a 200 response to CONNECT without a body.
+//
+// The status line must be of HTTP/1.0 or HTTP/1.1, and resp must end with an empty line.
+func ValidateConnectResponse(resp string) error {
+	if !strings.HasSuffix(resp, "\r\n\r\n") {
+		return ErrInvalidConnectResp
+	}
+
+	line, _, _ := strings.Cut(resp, "\r\n")
+	proto, rest, _ := strings.Cut(line, " ")
+	code, _, _ := strings.Cut(rest, " ")
+
+	if (proto != "HTTP/1.0" && proto != "HTTP/1.1") || code != "200" {
+		return ErrInvalidConnectResp
+	}
+
+	return nil
+}
+
 type Direct struct {
 	*baseGate
+
+	// laddr is the address connections are made from, and is invalid when the gate is not bound.
+	laddr netip.Addr
+
 	netd netDialer
 	doer httpDoer
 }
 
+// directID is the fixed id of the direct gate.
+var directID = uuid.MustParse("facade00-0000-4000-a000-000000000000")
+
 func NewDirect(tout time.Duration) *Direct {
-	id := uuid.MustParse("facade00-0000-4000-a000-000000000000")
+	id := directID
 
-	netd := &net.Dialer{Timeout: tout}
-	doer := &http.Client{
-		Timeout: tout,
-		Transport: &http.Transport{
-			DialContext: netd.DialContext,
-		},
-	}
+	netd := &net.Dialer{Timeout: tout, ControlContext: destControl(nil)}
+	doer := newHTTPClient(tout, netd.DialContext)
 
 	return newDirect(id, netd, doer)
 }
 
+// NewDirectFrom returns a direct gate which makes connections from laddr.
+//
+// The id is derived from laddr, so it stays the same across restarts.
+func NewDirectFrom(laddr netip.Addr, tout time.Duration) *Direct {
+	id := uuid.NewSHA1(directID, laddr.AsSlice())
+
+	netd := &net.Dialer{Timeout: tout, LocalAddr: &net.TCPAddr{IP: laddr.AsSlice()}, ControlContext: destControl(nil)}
+	doer := newHTTPClient(tout, netd.DialContext)
+
+	result := newDirect(id, netd, doer)
+	result.laddr = laddr
+
+	return result
+}
+
 func newDirect(id uuid.UUID, netd netDialer, doer httpDoer) *Direct {
 	result := &Direct{
 		baseGate: newBaseGateID(KindDirect, id),
@@ -595,6 +2763,11 @@ func newDirect(id uuid.UUID, netd netDialer, doer httpDoer) *Direct {
 	return result
 }
 
+// LocalAddr returns the address the gate makes connections from, which is invalid when the gate is not bound.
+func (g *Direct) LocalAddr() netip.Addr {
+	return g.laddr
+}
+
 func (g *Direct) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
 	return g.netd.DialContext(ctx, network, addr)
 }
@@ -644,15 +2817,21 @@ func ShutdownList[T interface{ close() error }](pctx context.Context, l []T) err
 	return errors.Join(collectErrs(errc)...)
 }
 
-func WarmupList[T interface {
-	warmup(context.Context) (time.Duration, error)
-}](pctx context.Context, l []T) ([]time.Duration, error) {
+// WarmupList warms up the gates in l concurrently, and returns the latency of each warmed up gate by id.
+//
+// Gates that failed are left out of the result, and their errors are joined.
+func WarmupList[T idWarmuper](pctx context.Context, l []T) (map[uuid.UUID]time.Duration, error) {
 	n := len(l)
 	if n == 0 {
 		return nil, nil
 	}
 
-	out := make(chan *warmupResponseWithErr, len(l))
+	type idResponse struct {
+		id uuid.UUID
+		*warmupResponseWithErr
+	}
+
+	out := make(chan idResponse, len(l))
 	wg := &sync.WaitGroup{}
 
 	ctx, cancel := context.WithCancel(pctx)
@@ -663,7 +2842,7 @@ func WarmupList[T interface {
 		go func(wu T) {
 			defer wg.Done()
 
-			out <- warmupOne(ctx, wu)
+			out <- idResponse{id: wu.ID(), warmupResponseWithErr: warmupOne(ctx, wu)}
 		}(l[i])
 	}
 
@@ -673,7 +2852,7 @@ func WarmupList[T interface {
 	}()
 
 	var (
-		result []time.Duration
+		result = make(map[uuid.UUID]time.Duration, n)
 		errs   []error
 	)
 
@@ -681,7 +2860,7 @@ func WarmupList[T interface {
 		if part.err != nil {
 			errs = append(errs, part.err)
 		} else {
-			result = append(result, part.latency)
+			result[part.id] = part.latency
 		}
 	}
 
@@ -730,14 +2909,43 @@ func shutdownOne[T interface{ close() error }](ctx context.Context, clr T) error
 	}
 }
 
+// typedNetErr wraps err with ErrNetUnreachable when the network or the host could not be reached, and returns it as is otherwise.
+func typedNetErr(err error) error {
+	if errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH) {
+		return fmt.Errorf("%w: %w", ErrNetUnreachable, err)
+	}
+
+	return err
+}
+
+// numSlowestLogged is the number of the slowest gates of each kind logged after a warmup.
+const numSlowestLogged = 3
+
+// slowestIDs returns up to n ids from lats, the slowest first.
+func slowestIDs(lats map[uuid.UUID]time.Duration, n int) []uuid.UUID {
+	result := make([]uuid.UUID, 0, len(lats))
+	for id := range lats {
+		result = append(result, id)
+	}
+
+	// Ties are broken by id to keep the order stable.
+	sort.Slice(result, func(i, j int) bool {
+		if li, lj := lats[result[i]], lats[result[j]]; li != lj {
+			return li > lj
+		}
+
+		return result[i].String() < result[j].String()
+	})
+
+	return result[:min(n, len(result))]
+}
+
 type warmupResponseWithErr struct {
 	latency time.Duration
 	err     error
 }
 
-func warmupOne[T interface {
-	warmup(context.Context) (time.Duration, error)
-}](ctx context.Context, wmr T) *warmupResponseWithErr {
+func warmupOne[T warmuper](ctx context.Context, wmr T) *warmupResponseWithErr {
 	out := make(chan *warmupResponseWithErr, 1)
 	go func() {
 		defer close(out)
@@ -754,8 +2962,195 @@ func warmupOne[T interface {
 	}
 }
 
+type warmuper interface {
+	warmup(context.Context) (time.Duration, error)
+}
+
+type idWarmuper interface {
+	ID() uuid.UUID
+
+	warmuper
+}
+
+// WarmupFunc checks that gt works, and returns the latency of the check.
+type WarmupFunc func(ctx context.Context, gt ExitGate) (time.Duration, error)
+
+// WarmupDial returns a WarmupFunc that connects to addr via the gate, without making a request.
+//
+// It suits environments where no external HTTP endpoint can be reached.
+func WarmupDial(addr string) WarmupFunc {
+	return func(ctx context.Context, gt ExitGate) (time.Duration, error) {
+		now := time.Now()
+		conn, err := gt.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return 0, err
+		}
+
+		result := time.Since(now)
+
+		_ = conn.Close()
+
+		return result, nil
+	}
+}
+
+// WarmupGet returns a WarmupFunc that requests urls via the gate in order, until one responds with a 2xx status.
+//
+// The latency is that of the successful request. When all requests fail, their errors are joined.
+func WarmupGet(urls ...string) WarmupFunc {
+	return func(ctx context.Context, gt ExitGate) (time.Duration, error) {
+		errs := make([]error, 0, len(urls))
+
+		for i := range urls {
+			result, err := warmupDoerURL(ctx, gt, urls[i])
+			if err == nil {
+				return result, nil
+			}
+
+			if cerr := ctx.Err(); cerr != nil {
+				return 0, cerr
+			}
+
+			errs = append(errs, fmt.Errorf("%s: %w", urls[i], err))
+		}
+
+		return 0, errors.Join(errs...)
+	}
+}
+
+// funcWarmuper warms up a gate with a custom function instead of its own warmup.
+type funcWarmuper struct {
+	gt ExitGate
+	fn WarmupFunc
+}
+
+func (w *funcWarmuper) warmup(ctx context.Context) (time.Duration, error) {
+	return w.fn(ctx, w.gt)
+}
+
+// recordingWarmuper keeps the outcome of the last warmup on the gate.
+type recordingWarmuper struct {
+	gt  exitGateExt
+	wmr warmuper
+}
+
+func (w *recordingWarmuper) ID() uuid.UUID {
+	return w.gt.ID()
+}
+
+func (w *recordingWarmuper) warmup(ctx context.Context) (time.Duration, error) {
+	result, err := w.wmr.warmup(ctx)
+	w.gt.setWarmup(result, err)
+
+	return result, err
+}
+
+// retryingWarmuper repeats a failed warmup up to n times, waiting delay before each attempt.
+type retryingWarmuper struct {
+	wmr   warmuper
+	n     int
+	delay time.Duration
+}
+
+func (w *retryingWarmuper) warmup(ctx context.Context) (time.Duration, error) {
+	result, err := w.wmr.warmup(ctx)
+
+	for i := 0; err != nil && i < w.n; i++ {
+		tm := time.NewTimer(w.delay)
+
+		select {
+		case <-ctx.Done():
+			tm.Stop()
+
+			return 0, ctx.Err()
+		case <-tm.C:
+		}
+
+		result, err = w.wmr.warmup(ctx)
+	}
+
+	return result, err
+}
+
+// warmupersFor returns l with warmups replaced according to cfg.
+func warmupersFor[T exitGateExt](cfg *SetConfig, l []T) []*recordingWarmuper {
+	result := make([]*recordingWarmuper, 0, len(l))
+
+	for i := range l {
+		result = append(result, cfg.warmuperFor(l[i]))
+	}
+
+	return result
+}
+
+// newHTTPClient returns a client for a gate which makes connections with dial.
+//
+// Compression is disabled so that the transport neither adds Accept-Encoding nor decodes responses.
+// Forwarded requests and responses are thus passed as is, leaving decoding to the client.
+//
+// A request with Expect: 100-continue has its body read only once the origin agrees, or expectContinueTout passes.
+// The body of a forwarded request is that of the client, so the client is asked for it only when the origin wants it.
+func newHTTPClient(tout time.Duration, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *http.Client {
+	result := &http.Client{
+		Timeout: tout,
+		Transport: &http.Transport{
+			DialContext:           dial,
+			DisableCompression:    true,
+			ExpectContinueTimeout: expectContinueTout,
+		},
+	}
+
+	return result
+}
+
+type destCheckKey struct{}
+
+// WithDestCheck returns a copy of ctx which makes gates call fn with the remote address right before connecting to it.
+//
+// A non-nil error from fn fails the dial, and is wrapped in the error returned.
+// The check applies to the address actually dialled, after hostnames are resolved.
+// Tor gates resolve hostnames on the exit side, so they do not apply it.
+func WithDestCheck(ctx context.Context, fn func(ip netip.Addr) error) context.Context {
+	return context.WithValue(ctx, destCheckKey{}, fn)
+}
+
+// checkDestIP calls the check set in ctx, if any, with ip.
+func checkDestIP(ctx context.Context, ip netip.Addr) error {
+	fn, ok := ctx.Value(destCheckKey{}).(func(ip netip.Addr) error)
+	if !ok || fn == nil {
+		return nil
+	}
+
+	return fn(ip.Unmap())
+}
+
+// destControl returns a dialer hook which applies the check set in the dial context, and then calls next, if given.
+func destControl(next func(network, address string, c syscall.RawConn) error) func(ctx context.Context, network, address string, c syscall.RawConn) error {
+	return func(ctx context.Context, network, address string, c syscall.RawConn) error {
+		ap, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+
+		if err := checkDestIP(ctx, ap.Addr()); err != nil {
+			return err
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		return next(network, address, c)
+	}
+}
+
 func warmupDoer(ctx context.Context, doer httpDoer) (time.Duration, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://httpbin.org/status/200", nil)
+	return warmupDoerURL(ctx, doer, defWarmupURL)
+}
+
+// warmupDoerURL makes a GET request to rawURL with doer, and expects a 2xx status.
+func warmupDoerURL(ctx context.Context, doer httpDoer, rawURL string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -772,21 +3167,27 @@ func warmupDoer(ctx context.Context, doer httpDoer) (time.Duration, error) {
 
 	_, _ = io.Copy(io.Discard, resp.Body)
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		return 0, ErrWarmupBadResponse
 	}
 
 	return time.Since(now), nil
 }
 
+// timeNow is used to timestamp gates.
+var timeNow = time.Now
+
 type baseGate struct {
 	kind  Kind
 	id    uuid.UUID
 	state *gateState
+
+	// createdAt is immutable, and needs no locking.
+	createdAt time.Time
 }
 
 func newBaseGateID(kind Kind, id uuid.UUID) *baseGate {
-	return &baseGate{kind: kind, id: id, state: newGateState()}
+	return &baseGate{kind: kind, id: id, state: newGateState(), createdAt: timeNow()}
 }
 
 func (g *baseGate) Kind() Kind {
@@ -797,6 +3198,17 @@ func (g *baseGate) ID() uuid.UUID {
 	return g.id
 }
 
+func (g *baseGate) CreatedAt() time.Time {
+	return g.createdAt
+}
+
+// LastUsed returns the time when a request was last started or finished on g.
+//
+// For a gate that has not served any requests, it is the time of creation.
+func (g *baseGate) LastUsed() time.Time {
+	return g.state.lastUsedAt()
+}
+
 func (g *baseGate) AddReq() {
 	g.state.addReq()
 }
@@ -809,10 +3221,62 @@ func (g *baseGate) noReqs() bool {
 	return g.state.noReqs()
 }
 
+func (g *baseGate) reqNum() uint64 {
+	return g.state.reqNum()
+}
+
+func (g *baseGate) AddBytes(in, out uint64) {
+	g.state.addBytes(in, out)
+}
+
+func (g *baseGate) bytesNum() (uint64, uint64) {
+	return g.state.bytesNum()
+}
+
 func (g *baseGate) resetReqs() {
 	g.state.resetReqs()
 }
 
+func (g *baseGate) idleFor(now time.Time) (time.Duration, bool) {
+	return g.state.idleFor(now)
+}
+
+func (g *baseGate) addFail() uint64 {
+	return g.state.addFail()
+}
+
+func (g *baseGate) resetFails() {
+	g.state.resetFails()
+}
+
+func (g *baseGate) setWarmup(lat time.Duration, err error) {
+	g.state.setWarmup(lat, err)
+}
+
+func (g *baseGate) warmupErr() string {
+	return g.state.warmupErr()
+}
+
+func (g *baseGate) warmupLatency() time.Duration {
+	return g.state.warmupLatency()
+}
+
+func (g *baseGate) annotate(annots map[string]string) (map[string]string, error) {
+	return g.state.annotate(annots)
+}
+
+func (g *baseGate) annotations() map[string]string {
+	return g.state.annotations()
+}
+
+func (g *baseGate) lockOp() bool {
+	return g.state.lockOp()
+}
+
+func (g *baseGate) unlockOp() {
+	g.state.unlockOp()
+}
+
 func (g *baseGate) getState() state {
 	return g.state.getState()
 }
@@ -822,14 +3286,33 @@ func (g *baseGate) isReady() bool {
 }
 
 func (g *baseGate) toState(st state) {
+	_ = g.toStateErr(st)
+}
+
+// toStateErr moves g to st, and reports an illegal transition.
+//
+// Transitioning to the current state is not an error.
+func (g *baseGate) toStateErr(st state) error {
+	if g.state.getState() == st {
+		return nil
+	}
+
+	var ok bool
+
 	switch st {
 	case stateReady:
-		g.state.toReady()
+		ok = g.state.toReady()
 	case stateMaintenance:
-		g.state.toMaint()
+		ok = g.state.toMaint()
 	case stateClosed:
-		g.state.toClosed()
+		ok = g.state.toClosed()
+	}
+
+	if !ok {
+		return ErrIllegalStateTransition
 	}
+
+	return nil
 }
 
 const (
@@ -840,42 +3323,79 @@ const (
 
 type state uint32
 
+func (x state) String() string {
+	switch x {
+	case stateReady:
+		return "ready"
+	case stateMaintenance:
+		return "maintenance"
+	case stateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
 type gateState struct {
 	state *struct{ value uint32 }
 	mu    *sync.Mutex
 	nreq  uint64
+	nfail uint64
+
+	// lastWarmupErr is the error of the last warmup, empty after a successful one.
+	lastWarmupErr string
+
+	// lastLatency is the latency of the last successful warmup, kept when a later one fails.
+	lastLatency time.Duration
+
+	// lastUsed is the time in Unix nanoseconds when a request was last started or finished.
+	lastUsed *struct{ value int64 }
+
+	// annots holds the annotations of the gate, and is nil until the first one is set.
+	annots map[string]string
+
+	// bytesIn and bytesOut are the totals received and sent via the gate.
+	bytesIn  *struct{ value uint64 }
+	bytesOut *struct{ value uint64 }
+
+	// op is set while a lifecycle operation, refresh or close, runs on the gate.
+	op *struct{ value uint32 }
 }
 
 func newGateState() *gateState {
 	result := &gateState{
-		state: &struct{ value uint32 }{},
-		mu:    &sync.Mutex{},
+		state:    &struct{ value uint32 }{},
+		mu:       &sync.Mutex{},
+		lastUsed: &struct{ value int64 }{value: timeNow().UnixNano()},
+		bytesIn:  &struct{ value uint64 }{},
+		bytesOut: &struct{ value uint64 }{},
+		op:       &struct{ value uint32 }{},
 	}
 
 	return result
 }
 
-func (s *gateState) toReady() {
+func (s *gateState) toReady() bool {
 	// StateReady can only be transitioned to from:
 	// - initial state;
 	// - StateMaintenance.
-	_ = atomic.CompareAndSwapUint32(&s.state.value, uint32(stateMaintenance), uint32(stateReady))
+	return atomic.CompareAndSwapUint32(&s.state.value, uint32(stateMaintenance), uint32(stateReady))
 }
 
-func (s *gateState) toMaint() {
+func (s *gateState) toMaint() bool {
 	// StateMaintenance can only be transitioned to from StateReady.
-	_ = atomic.CompareAndSwapUint32(&s.state.value, uint32(stateReady), uint32(stateMaintenance))
+	return atomic.CompareAndSwapUint32(&s.state.value, uint32(stateReady), uint32(stateMaintenance))
 }
 
-func (s *gateState) toClosed() {
+func (s *gateState) toClosed() bool {
 	// StateClosed can be transitioned to from:
 	// - StateReady;
 	// - StateMaintenance.
 	if ok := atomic.CompareAndSwapUint32(&s.state.value, uint32(stateReady), uint32(stateClosed)); ok {
-		return
+		return true
 	}
 
-	_ = atomic.CompareAndSwapUint32(&s.state.value, uint32(stateMaintenance), uint32(stateClosed))
+	return atomic.CompareAndSwapUint32(&s.state.value, uint32(stateMaintenance), uint32(stateClosed))
 }
 
 func (s *gateState) getState() state {
@@ -886,6 +3406,8 @@ func (s *gateState) addReq() {
 	s.mu.Lock()
 	s.nreq += 1
 	s.mu.Unlock()
+
+	s.touch()
 }
 
 func (s *gateState) didReq() {
@@ -894,6 +3416,28 @@ func (s *gateState) didReq() {
 		s.nreq -= 1
 	}
 	s.mu.Unlock()
+
+	s.touch()
+}
+
+func (s *gateState) touch() {
+	atomic.StoreInt64(&s.lastUsed.value, timeNow().UnixNano())
+}
+
+func (s *gateState) lastUsedAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastUsed.value)).UTC()
+}
+
+// idleFor returns how long there have been no requests as of now, and false if a request is in progress.
+func (s *gateState) idleFor(now time.Time) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nreq > 0 {
+		return 0, false
+	}
+
+	return now.Sub(s.lastUsedAt()), true
 }
 
 func (s *gateState) reqNum() uint64 {
@@ -903,6 +3447,15 @@ func (s *gateState) reqNum() uint64 {
 	return s.nreq
 }
 
+func (s *gateState) addBytes(in, out uint64) {
+	atomic.AddUint64(&s.bytesIn.value, in)
+	atomic.AddUint64(&s.bytesOut.value, out)
+}
+
+func (s *gateState) bytesNum() (uint64, uint64) {
+	return atomic.LoadUint64(&s.bytesIn.value), atomic.LoadUint64(&s.bytesOut.value)
+}
+
 func (s *gateState) noReqs() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -916,6 +3469,116 @@ func (s *gateState) resetReqs() {
 	s.mu.Unlock()
 }
 
+// addFail records a consecutive failure, and returns the number of failures so far.
+func (s *gateState) addFail() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nfail += 1
+
+	return s.nfail
+}
+
+func (s *gateState) failNum() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.nfail
+}
+
+func (s *gateState) resetFails() {
+	s.mu.Lock()
+	s.nfail = 0
+	s.mu.Unlock()
+}
+
+func (s *gateState) setWarmup(lat time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.lastWarmupErr = err.Error()
+
+		return
+	}
+
+	s.lastWarmupErr = ""
+	s.lastLatency = lat
+}
+
+func (s *gateState) warmupErr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastWarmupErr
+}
+
+func (s *gateState) warmupLatency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastLatency
+}
+
+// annotate merges annots into the annotations, unless the result would exceed maxAnnotations.
+func (s *gateState) annotate(annots map[string]string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]string, len(s.annots)+len(annots))
+	for k, v := range s.annots {
+		result[k] = v
+	}
+
+	for k, v := range annots {
+		if v == "" {
+			delete(result, k)
+
+			continue
+		}
+
+		result[k] = v
+	}
+
+	if len(result) > maxAnnotations {
+		return nil, ErrTooManyAnnotations
+	}
+
+	s.annots = result
+
+	return copyAnnotations(result), nil
+}
+
+func (s *gateState) annotations() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return copyAnnotations(s.annots)
+}
+
+// lockOp marks the start of a lifecycle operation, and reports false if another one is running.
+func (s *gateState) lockOp() bool {
+	return atomic.CompareAndSwapUint32(&s.op.value, 0, 1)
+}
+
+func (s *gateState) unlockOp() {
+	atomic.StoreUint32(&s.op.value, 0)
+}
+
+// copyAnnotations returns a copy of annots, or nil if it's empty, so that callers cannot change the original.
+func copyAnnotations(annots map[string]string) map[string]string {
+	if len(annots) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(annots))
+	for k, v := range annots {
+		result[k] = v
+	}
+
+	return result
+}
+
 func closeOrSkip[C chan T, T any](c C) {
 	select {
 	case <-c:
@@ -943,10 +3606,34 @@ func collectErrs(errc <-chan error) []error {
 	return errs
 }
 
-func pickRandomKind(n int) Kind {
-	if n%2 == 0 {
-		return KindTor
+// pickRandomKind picks a kind for n with the probability proportional to its weight.
+//
+// Kinds without a positive weight are never picked.
+// Without any, Tor and WireGuard are picked equally.
+func pickRandomKind(weights map[Kind]int, n int) Kind {
+	total := 0
+	for i := range weightedKinds {
+		total += max(weights[weightedKinds[i]], 0)
+	}
+
+	if total == 0 {
+		weights, total = defKindWeights, 2
+	}
+
+	n %= total
+	if n < 0 {
+		n += total
+	}
+
+	for i := range weightedKinds {
+		w := max(weights[weightedKinds[i]], 0)
+		if n < w {
+			return weightedKinds[i]
+		}
+
+		n -= w
 	}
 
-	return KindWireGuard
+	// Unreachable, as n is less than the total.
+	return KindTor
 }
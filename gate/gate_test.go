@@ -1,12 +1,24 @@
 package gate
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -14,6 +26,7 @@ import (
 	should "github.com/stretchr/testify/assert"
 	must "github.com/stretchr/testify/require"
 
+	"github.com/pavelbrm/pumpe/fakenet"
 	"github.com/pavelbrm/pumpe/model"
 )
 
@@ -23,6 +36,13 @@ type testCase[G, E any] struct {
 	exp   E
 }
 
+func TestMain(m *testing.M) {
+	// Gates created separately must be equal in comparisons.
+	timeNow = func() time.Time { return time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC) }
+
+	os.Exit(m.Run())
+}
+
 func TestKind_UnmarshalJSON(t *testing.T) {
 	type tcExpected struct {
 		kind Kind
@@ -41,7 +61,7 @@ func TestKind_UnmarshalJSON(t *testing.T) {
 
 		{
 			name:  "error_unknown_kind",
-			given: []byte(`"openvpn"`),
+			given: []byte(`"shadowsocks"`),
 			exp: tcExpected{
 				err: ErrKindUnknown,
 			},
@@ -83,7 +103,7 @@ func TestKind_MarshalJSON(t *testing.T) {
 	tests := []testCase[Kind, tcExpected]{
 		{
 			name:  "error_unknown_kind",
-			given: Kind("openvpn"),
+			given: Kind("shadowsocks"),
 			exp: tcExpected{
 				err: ErrKindUnknown,
 			},
@@ -113,6 +133,14 @@ func TestKind_MarshalJSON(t *testing.T) {
 			},
 		},
 
+		{
+			name:  "valid_tor_wireguard",
+			given: KindTorWG,
+			exp: tcExpected{
+				data: []byte(`"tor_wireguard"`),
+			},
+		},
+
 		{
 			name:  "valid_wireguard",
 			given: KindWireGuard,
@@ -170,6 +198,14 @@ func TestParseKind(t *testing.T) {
 			},
 		},
 
+		{
+			name:  "valid_tor_wireguard",
+			given: "tor_wireguard",
+			exp: tcExpected{
+				kind: KindTorWG,
+			},
+		},
+
 		{
 			name:  "valid_wireguard",
 			given: "wireguard",
@@ -177,6 +213,14 @@ func TestParseKind(t *testing.T) {
 				kind: KindWireGuard,
 			},
 		},
+
+		{
+			name:  "valid_openvpn",
+			given: "openvpn",
+			exp: tcExpected{
+				kind: KindOpenVPN,
+			},
+		},
 	}
 
 	for i := range tests {
@@ -193,10 +237,10 @@ func TestParseKind(t *testing.T) {
 
 func TestSet_ByID(t *testing.T) {
 	type tcGiven struct {
-		drt *Direct
-		tgs []*Tor
-		wgs []*WireGuard
-		id  uuid.UUID
+		drts []*Direct
+		tgs  []*Tor
+		wgs  []*WireGuard
+		id   uuid.UUID
 	}
 
 	type tcExpected struct {
@@ -208,8 +252,8 @@ func TestSet_ByID(t *testing.T) {
 		{
 			name: "error_not_found",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				id:  uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				id:   uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
 				err: ErrGateNotFound,
@@ -219,12 +263,12 @@ func TestSet_ByID(t *testing.T) {
 		{
 			name: "error_not_ready",
 			given: tcGiven{
-				drt: func() *Direct {
+				drts: []*Direct{func() *Direct {
 					gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
 					gt.toState(stateMaintenance)
 
 					return gt
-				}(),
+				}()},
 				id: uuid.MustParse("facade00-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
@@ -232,21 +276,45 @@ func TestSet_ByID(t *testing.T) {
 			},
 		},
 
+		{
+			name: "error_direct_disabled",
+			given: tcGiven{
+				id: uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrKindNotSupported,
+			},
+		},
+
 		{
 			name: "valid_direct",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				id:  uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				id:   uuid.MustParse("facade00-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
 				gate: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
 			},
 		},
 
+		{
+			name: "valid_direct_pool",
+			given: tcGiven{
+				drts: []*Direct{
+					newDirect(uuid.MustParse("facade01-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+					newDirect(uuid.MustParse("facade02-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id: uuid.MustParse("facade02-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				gate: newDirect(uuid.MustParse("facade02-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+			},
+		},
+
 		{
 			name: "valid_tor",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
 				tgs: []*Tor{
 					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
@@ -260,7 +328,7 @@ func TestSet_ByID(t *testing.T) {
 		{
 			name: "valid_wg",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
 				wgs: []*WireGuard{
 					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
@@ -276,7 +344,7 @@ func TestSet_ByID(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			set := NewSet(&SetConfig{}, tc.given.drt, tc.given.tgs, tc.given.wgs)
+			set := NewSet(&SetConfig{}, tc.given.drts, tc.given.tgs, tc.given.wgs, nil)
 
 			actual, err := set.ByID(tc.given.id)
 			must.Equal(t, tc.exp.err, err)
@@ -290,56 +358,131 @@ func TestSet_ByID(t *testing.T) {
 	}
 }
 
-func TestSet_ByKind(t *testing.T) {
+func TestSet_ByIndex(t *testing.T) {
 	type tcGiven struct {
-		drt  *Direct
-		tgs  []*Tor
-		wgs  []*WireGuard
-		kind Kind
+		tgs       []*Tor
+		kind      Kind
+		idx       int
+		fnPrepSet func(set *Set)
 	}
 
 	type tcExpected struct {
-		gate ExitGate
-		err  error
+		id  uuid.UUID
+		err error
+	}
+
+	newTgs := func() []*Tor {
+		return []*Tor{
+			newTor(uuid.MustParse("c0c0a002-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+			newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+			newTor(uuid.MustParse("c0c0a001-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+		}
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "valid_direct",
+			name: "error_unknown_kind",
 			given: tcGiven{
-				drt:  newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				kind: KindDirect,
+				tgs:  newTgs(),
+				kind: Kind("something"),
 			},
 			exp: tcExpected{
-				gate: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+				err: ErrKindUnknown,
 			},
 		},
 
 		{
-			name: "valid_tor",
+			name: "error_out_of_range",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				tgs: []*Tor{
-					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
+				tgs:  newTgs(),
 				kind: KindTor,
+				idx:  3,
 			},
 			exp: tcExpected{
-				gate: newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				err: ErrGateNotFound,
 			},
 		},
 
 		{
-			name: "valid_wg",
+			name: "error_negative",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				tgs:  newTgs(),
+				kind: KindTor,
+				idx:  -1,
+			},
+			exp: tcExpected{
+				err: ErrGateNotFound,
+			},
+		},
+
+		{
+			name: "error_not_ready",
+			given: tcGiven{
+				tgs: func() []*Tor {
+					result := newTgs()
+					result[1].toState(stateMaintenance)
+
+					return result
+				}(),
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				err: ErrGateNotReady,
+			},
+		},
+
+		{
+			name: "valid_first",
+			given: tcGiven{
+				tgs:  newTgs(),
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				id: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+			},
+		},
+
+		{
+			name: "error_detached",
+			given: tcGiven{
+				tgs:  newTgs(),
+				kind: KindTor,
+				idx:  1,
+				fnPrepSet: func(set *Set) {
+					gt, _ := set.tgs.Get(uuid.MustParse("c0c0a001-0000-4000-a000-000000000000"))
+					set.detach(context.Background(), gt, stateMaintenance)
 				},
-				kind: KindWireGuard,
 			},
 			exp: tcExpected{
-				gate: newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				err: ErrGateNotReady,
+			},
+		},
+
+		{
+			name: "valid_detached_keeps_order",
+			given: tcGiven{
+				tgs:  newTgs(),
+				kind: KindTor,
+				idx:  2,
+				fnPrepSet: func(set *Set) {
+					gt, _ := set.tgs.Get(uuid.MustParse("c0c0a001-0000-4000-a000-000000000000"))
+					set.detach(context.Background(), gt, stateMaintenance)
+				},
+			},
+			exp: tcExpected{
+				id: uuid.MustParse("c0c0a002-0000-4000-a000-000000000000"),
+			},
+		},
+
+		{
+			name: "valid_last",
+			given: tcGiven{
+				tgs:  newTgs(),
+				kind: KindTor,
+				idx:  2,
+			},
+			exp: tcExpected{
+				id: uuid.MustParse("c0c0a002-0000-4000-a000-000000000000"),
 			},
 		},
 	}
@@ -348,284 +491,290 @@ func TestSet_ByKind(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			cfg := &SetConfig{
-				RandomLoopTout:  10 * time.Second,
-				RandomLoopDelay: 10 * time.Millisecond,
+			set := NewSet(&SetConfig{}, nil, tc.given.tgs, nil, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
 			}
 
-			set := NewSet(cfg, tc.given.drt, tc.given.tgs, tc.given.wgs)
-
-			ctx := context.Background()
-
-			actual, err := set.ByKind(ctx, tc.given.kind)
+			actual, err := set.ByIndex(tc.given.kind, tc.given.idx)
 			must.Equal(t, tc.exp.err, err)
 
 			if tc.exp.err != nil {
 				return
 			}
 
-			should.Equal(t, tc.exp.gate, actual)
+			should.Equal(t, tc.exp.id, actual.ID())
 		})
 	}
 }
 
-func TestSet_New(t *testing.T) {
+func TestSet_ByIDWait(t *testing.T) {
 	type tcGiven struct {
-		cfg       *SetConfig
 		tgs       []*Tor
+		tout      time.Duration
 		fnPrepSet func(set *Set)
-		kind      Kind
+		id        uuid.UUID
 	}
 
 	type tcExpected struct {
-		id   uuid.UUID
-		gate exitGateExt
-		err  error
-		ok   bool
+		id  uuid.UUID
+		err error
+	}
+
+	newMaintTor := func() *Tor {
+		result := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+		result.toState(stateMaintenance)
+
+		return result
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_kind_not_supported",
+			name: "error_direct_kind_not_supported",
 			given: tcGiven{
-				cfg: &SetConfig{TorMax: 10},
-				fnPrepSet: func(set *Set) {
-					tf := &mockTorCreator{
-						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
-							return nil, model.Error("unexpected_new")
-						},
-					}
-
-					set.tf = tf
-				},
-				kind: KindDirect,
+				tout: time.Hour,
+				id:   uuid.MustParse("facade00-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
-				id:  uuid.Nil,
 				err: ErrKindNotSupported,
 			},
 		},
 
 		{
-			name: "error_set_is_shutting",
+			name: "error_shutting",
 			given: tcGiven{
-				cfg: &SetConfig{TorMax: 10},
+				tgs:  []*Tor{newMaintTor()},
+				tout: time.Hour,
 				fnPrepSet: func(set *Set) {
-					tf := &mockTorCreator{
-						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
-							return nil, model.Error("unexpected_new")
-						},
-					}
-
-					set.tf = tf
 					closeOrSkip(set.shutting)
 				},
-				kind: KindTor,
+				id: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
-				id:  uuid.Nil,
 				err: ErrSetIsShutting,
 			},
 		},
 
 		{
-			name: "error_tor_max_reached",
+			name: "error_not_found",
+			given: tcGiven{
+				tout: time.Hour,
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrGateNotFound,
+			},
+		},
+
+		{
+			name: "error_closing",
 			given: tcGiven{
-				cfg: &SetConfig{TorMax: 1},
 				tgs: []*Tor{
 					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
+				tout: time.Hour,
 				fnPrepSet: func(set *Set) {
-					tf := &mockTorCreator{
-						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
-							return nil, model.Error("unexpected_new")
-						},
+					gt, err := set.byID(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"))
+					if err != nil {
+						panic(err)
 					}
 
-					set.tf = tf
+					set.detach(context.Background(), gt, stateClosed)
 				},
-				kind: KindTor,
+				id: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
-				id:  uuid.Nil,
-				err: ErrTorMaxReached,
+				err: ErrGateNotFound,
 			},
 		},
 
 		{
-			name: "error_something_went_wrong",
+			name: "error_detached_timeout",
 			given: tcGiven{
-				cfg: &SetConfig{TorMax: 10},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				tout: 20 * time.Millisecond,
 				fnPrepSet: func(set *Set) {
-					tf := &mockTorCreator{
-						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
-							return nil, model.Error("something_went_wrong")
-						},
+					gt, err := set.byID(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"))
+					if err != nil {
+						panic(err)
 					}
 
-					set.tf = tf
+					set.detach(context.Background(), gt, stateMaintenance)
 				},
-				kind: KindTor,
+				id: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
-				id:  uuid.Nil,
-				err: model.Error("something_went_wrong"),
+				err: ErrGateNotFound,
 			},
 		},
 
 		{
-			name: "success",
+			name: "error_not_ready_timeout",
 			given: tcGiven{
-				cfg: &SetConfig{TorMax: 10},
+				tgs:  []*Tor{newMaintTor()},
+				tout: 20 * time.Millisecond,
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrGateNotReady,
+			},
+		},
+
+		{
+			name: "valid_ready",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				tout: time.Hour,
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				id: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+			},
+		},
+
+		{
+			name: "valid_becomes_ready",
+			given: tcGiven{
+				tgs:  []*Tor{newMaintTor()},
+				tout: 10 * time.Second,
 				fnPrepSet: func(set *Set) {
-					tf := &mockTorCreator{
-						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
-							return newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}), nil
-						},
+					gt, err := set.byID(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"))
+					if err != nil {
+						panic(err)
 					}
 
-					set.tf = tf
+					go func() {
+						time.Sleep(20 * time.Millisecond)
+
+						gt.toState(stateReady)
+					}()
 				},
-				kind: KindTor,
+				id: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
-				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
-				gate: newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				ok:   true,
+				id: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 			},
 		},
-	}
 
-	for i := range tests {
-		tc := tests[i]
+		{
+			name: "valid_detached_comes_back",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				tout: 10 * time.Second,
+				fnPrepSet: func(set *Set) {
+					gt, err := set.byID(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"))
+					if err != nil {
+						panic(err)
+					}
 
-		t.Run(tc.name, func(t *testing.T) {
-			drt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
-			set := NewSet(tc.given.cfg, drt, tc.given.tgs, nil)
-			tc.given.fnPrepSet(set)
+					set.detach(context.Background(), gt, stateMaintenance)
 
-			ctx := context.Background()
+					go func() {
+						time.Sleep(20 * time.Millisecond)
 
-			actual, err := set.New(ctx, tc.given.kind)
-			must.Equal(t, tc.exp.err, err)
+						_ = set.toState(gt, stateReady)
+					}()
+				},
+				id: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				id: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+			},
+		},
+	}
 
-			should.Equal(t, tc.exp.id, actual)
+	for i := range tests {
+		tc := tests[i]
 
-			actual2, ok := set.tgs.Get(tc.exp.id)
-			must.Equal(t, tc.exp.ok, ok)
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				RandomLoopTout:  tc.given.tout,
+				RandomLoopDelay: 5 * time.Millisecond,
+			}
 
-			if !tc.exp.ok {
+			set := NewSet(cfg, nil, tc.given.tgs, nil, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			actual, err := set.ByIDWait(context.Background(), tc.given.id)
+			must.Equal(t, tc.exp.err, err)
+
+			if tc.exp.err != nil {
 				return
 			}
 
-			should.Equal(t, tc.exp.gate, actual2)
+			should.Equal(t, tc.exp.id, actual.ID())
 		})
 	}
 }
 
-func TestSet_GateIDs(t *testing.T) {
+func TestSet_ByKind(t *testing.T) {
 	type tcGiven struct {
-		set  *Set
+		drts []*Direct
+		tgs  []*Tor
+		wgs  []*WireGuard
 		kind Kind
 	}
 
 	type tcExpected struct {
-		ids []uuid.UUID
-		err error
+		gate ExitGate
+		err  error
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_unknown_kind",
+			name: "error_direct_disabled",
 			given: tcGiven{
-				set: &Set{
-					drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-					tgs: model.NewSet[uuid.UUID, *Tor](),
-					wgs: model.NewSet[uuid.UUID, *WireGuard](),
-				},
-				kind: Kind("openvpn"),
+				kind: KindDirect,
 			},
 			exp: tcExpected{
-				err: ErrKindUnknown,
+				err: ErrKindNotSupported,
 			},
 		},
 
 		{
 			name: "valid_direct",
 			given: tcGiven{
-				set: &Set{
-					drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-					tgs: model.NewSet[uuid.UUID, *Tor](),
-					wgs: model.NewSet[uuid.UUID, *WireGuard](),
-				},
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
 				kind: KindDirect,
 			},
 			exp: tcExpected{
-				ids: []uuid.UUID{uuid.MustParse("facade00-0000-4000-a000-000000000000")},
+				gate: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
 			},
 		},
 
 		{
 			name: "valid_tor",
 			given: tcGiven{
-				set: &Set{
-					drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-					tgs: func() *model.Set[uuid.UUID, *Tor] {
-						mset := model.NewSet[uuid.UUID, *Tor]()
-
-						{
-							gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
-							mset.Set(gt.id, gt)
-						}
-
-						{
-							gt := newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
-							mset.Set(gt.id, gt)
-						}
-
-						return mset
-					}(),
-					wgs: model.NewSet[uuid.UUID, *WireGuard](),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
 				kind: KindTor,
 			},
 			exp: tcExpected{
-				ids: []uuid.UUID{
-					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
-					uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
-				},
+				gate: newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 			},
 		},
 
 		{
-			name: "valid_wireguard",
+			name: "valid_wg",
 			given: tcGiven{
-				set: &Set{
-					drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-					tgs: model.NewSet[uuid.UUID, *Tor](),
-					wgs: func() *model.Set[uuid.UUID, *WireGuard] {
-						mset := model.NewSet[uuid.UUID, *WireGuard]()
-
-						{
-							gt := newWireGuard(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
-							mset.Set(gt.id, gt)
-						}
-
-						{
-							gt := newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
-							mset.Set(gt.id, gt)
-						}
-
-						return mset
-					}(),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
 				kind: KindWireGuard,
 			},
 			exp: tcExpected{
-				ids: []uuid.UUID{
-					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
-					uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
-				},
+				gate: newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 			},
 		},
 	}
@@ -634,113 +783,141 @@ func TestSet_GateIDs(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			actual, err := tc.given.set.GateIDs(tc.given.kind)
+			cfg := &SetConfig{
+				RandomLoopTout:  10 * time.Second,
+				RandomLoopDelay: 10 * time.Millisecond,
+			}
+
+			set := NewSet(cfg, tc.given.drts, tc.given.tgs, tc.given.wgs, nil)
+
+			ctx := context.Background()
+
+			actual, err := set.ByKind(ctx, tc.given.kind)
 			must.Equal(t, tc.exp.err, err)
 
 			if tc.exp.err != nil {
 				return
 			}
 
-			should.ElementsMatch(t, tc.exp.ids, actual)
+			should.Equal(t, tc.exp.gate, actual)
 		})
 	}
 }
 
-func TestSet_RefreshOne(t *testing.T) {
+func TestSet_SetIntN(t *testing.T) {
+	newSet := func(seed uint64) *Set {
+		set := NewSet(
+			&SetConfig{RandomiseKinds: true, RandomLoopTout: 10 * time.Second, RandomLoopDelay: time.Millisecond},
+			nil,
+			[]*Tor{
+				newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+			},
+			[]*WireGuard{
+				newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				newWireGuard(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+			},
+			nil,
+		)
+
+		set.SetIntN(rand.New(rand.NewPCG(seed, seed)).IntN)
+
+		return set
+	}
+
+	picks := func(set *Set) []uuid.UUID {
+		result := make([]uuid.UUID, 0, 32)
+
+		for i := 0; i < 32; i++ {
+			gt, err := set.Random(context.Background())
+			must.Equal(t, nil, err)
+
+			result = append(result, gt.ID())
+		}
+
+		return result
+	}
+
+	t.Run("same_seed", func(t *testing.T) {
+		should.Equal(t, picks(newSet(42)), picks(newSet(42)))
+	})
+
+	t.Run("fixed", func(t *testing.T) {
+		set := newSet(42)
+		set.SetIntN(func(n int) int { return n - 1 })
+
+		gt, err := set.Random(context.Background())
+		must.Equal(t, nil, err)
+
+		// The even number picks Tor out of the default kinds, and then the gate added last.
+		should.Equal(t, uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), gt.ID())
+	})
+}
+
+func TestSet_ByKind_fallback(t *testing.T) {
 	type tcGiven struct {
-		drt       *Direct
-		tgs       []*Tor
-		wgs       []*WireGuard
-		fnPrepSet func(set *Set)
-		id        uuid.UUID
+		cfg  *SetConfig
+		kind Kind
 	}
 
 	type tcExpected struct {
-		st  state
-		err error
+		gate ExitGate
+		err  error
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_direct_kind_not_supported",
-			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				id:  uuid.MustParse("facade00-0000-4000-a000-000000000000"),
-			},
-			exp: tcExpected{
-				err: ErrKindNotSupported,
-			},
-		},
-
-		{
-			name: "error_not_found",
+			name: "error_disabled",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				id:  uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				cfg:  &SetConfig{Default: KindWireGuard},
+				kind: KindTor,
 			},
 			exp: tcExpected{
-				err: ErrGateNotFound,
+				err: ErrNoRandomGate,
 			},
 		},
 
 		{
-			name: "error_wireguard_not_supported",
+			name: "error_kind_unknown",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				cfg:  &SetConfig{Default: KindWireGuard, FallbackToDefault: true},
+				kind: Kind("something"),
 			},
 			exp: tcExpected{
-				err: ErrKindNotSupported,
+				err: ErrKindUnknown,
 			},
 		},
 
 		{
-			name: "error_for_state_shutting",
+			name: "error_default_unavailable",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				tgs: []*Tor{
-					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				fnPrepSet: func(set *Set) {
-					closeOrSkip(set.shutting)
-				},
-				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				cfg:  &SetConfig{Default: KindOpenVPN, FallbackToDefault: true},
+				kind: KindTor,
 			},
 			exp: tcExpected{
-				err: ErrSetIsShutting,
+				err: ErrNoRandomGate,
 			},
 		},
 
 		{
-			name: "error_refresh",
+			name: "valid_no_gates",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				tgs: []*Tor{
-					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{
-						fnSignal: func(s string) error { return model.Error("something_went_wrong") },
-					}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				cfg:  &SetConfig{Default: KindWireGuard, FallbackToDefault: true},
+				kind: KindTor,
 			},
 			exp: tcExpected{
-				err: model.Error("something_went_wrong"),
+				gate: newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 			},
 		},
 
 		{
-			name: "success",
+			name: "valid_direct_disabled",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				tgs: []*Tor{
-					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				cfg:  &SetConfig{Default: KindWireGuard, FallbackToDefault: true, DisableDirect: true},
+				kind: KindDirect,
 			},
 			exp: tcExpected{
-				st: stateReady,
+				gate: newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 			},
 		},
 	}
@@ -749,128 +926,109 @@ func TestSet_RefreshOne(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			cfg := &SetConfig{
-				StateLoopTout:  10 * time.Second,
-				StateLoopDelay: 10 * time.Millisecond,
-			}
+			tc.given.cfg.RandomLoopTout = 10 * time.Second
+			tc.given.cfg.RandomLoopDelay = 10 * time.Millisecond
 
-			set := NewSet(cfg, tc.given.drt, tc.given.tgs, tc.given.wgs)
-			if tc.given.fnPrepSet != nil {
-				tc.given.fnPrepSet(set)
-			}
+			drts := []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})}
+			wgs := []*WireGuard{newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})}
 
-			ctx := context.Background()
+			set := NewSet(tc.given.cfg, drts, nil, wgs, nil)
 
-			actual := set.RefreshOne(ctx, tc.given.id)
-			must.Equal(t, tc.exp.err, actual)
+			actual, err := set.ByKind(context.Background(), tc.given.kind)
+			must.Equal(t, tc.exp.err, err)
 
 			if tc.exp.err != nil {
 				return
 			}
 
-			gt, err := set.byID(tc.given.id)
-			must.Equal(t, nil, err)
-
-			should.Equal(t, tc.exp.st, gt.getState())
+			should.Equal(t, tc.exp.gate, actual)
 		})
 	}
 }
 
-func TestSet_CloseOne(t *testing.T) {
+func TestSet_RandomExcept(t *testing.T) {
 	type tcGiven struct {
-		drt       *Direct
-		tgs       []*Tor
-		wgs       []*WireGuard
-		fnPrepSet func(set *Set)
-		id        uuid.UUID
+		drts []*Direct
+		tgs  []*Tor
+		wgs  []*WireGuard
+		kind Kind
+		id   uuid.UUID
 	}
 
 	type tcExpected struct {
-		st  state
-		err error
+		gate ExitGate
+		err  error
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_direct_kind_not_supported",
+			name: "error_direct_excluded",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				id:  uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				kind: KindDirect,
+				id:   uuid.MustParse("facade00-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
-				err: ErrKindNotSupported,
+				err: ErrNoRandomGate,
 			},
 		},
 
 		{
-			name: "error_not_found",
+			name: "error_tor_only_excluded",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				id:  uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				kind: KindTor,
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
-				err: ErrGateNotFound,
+				err: ErrNoRandomGate,
 			},
 		},
 
 		{
-			name: "error_for_state_shutting",
+			name: "valid_direct",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				tgs: []*Tor{
-					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				fnPrepSet: func(set *Set) {
-					closeOrSkip(set.shutting)
-				},
-				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				kind: KindDirect,
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
-				err: ErrSetIsShutting,
+				gate: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
 			},
 		},
 
 		{
-			name: "error_shutdown",
+			name: "valid_tor",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
 				tgs: []*Tor{
-					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{
-						fnClose: func() error { return model.Error("something_went_wrong") },
-					}, &MockNetDialer{}, &MockHTTPDoer{}),
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000001"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
-				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				kind: KindTor,
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 			},
 			exp: tcExpected{
-				err: model.Error("something_went_wrong"),
+				gate: newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000001"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 			},
 		},
 
 		{
-			name: "success_tor",
+			name: "valid_wg",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				tgs: []*Tor{
-					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
-			},
-			exp: tcExpected{
-				st: stateClosed,
-			},
-		},
-
-		{
-			name: "success_wireguard",
-			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
 				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000001"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
-				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				kind: KindWireGuard,
+				id:   uuid.MustParse("decade00-0000-4000-a000-000000000001"),
 			},
 			exp: tcExpected{
-				st: stateClosed,
+				gate: newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 			},
 		},
 	}
@@ -880,344 +1038,256 @@ func TestSet_CloseOne(t *testing.T) {
 
 		t.Run(tc.name, func(t *testing.T) {
 			cfg := &SetConfig{
-				StateLoopTout:  10 * time.Second,
-				StateLoopDelay: 10 * time.Millisecond,
+				RandomLoopTout:  10 * time.Second,
+				RandomLoopDelay: 10 * time.Millisecond,
 			}
 
-			set := NewSet(cfg, tc.given.drt, tc.given.tgs, tc.given.wgs)
-			if tc.given.fnPrepSet != nil {
-				tc.given.fnPrepSet(set)
-			}
+			set := NewSet(cfg, tc.given.drts, tc.given.tgs, tc.given.wgs, nil)
 
 			ctx := context.Background()
 
-			var gt exitGateExt
-			if tc.exp.err == nil {
-				var err error
-				gt, err = set.byID(tc.given.id)
-				must.Equal(t, nil, err)
-			}
-
-			actual := set.CloseOne(ctx, tc.given.id)
-			must.Equal(t, tc.exp.err, actual)
+			actual, err := set.RandomExcept(ctx, tc.given.kind, tc.given.id)
+			must.Equal(t, tc.exp.err, err)
 
 			if tc.exp.err != nil {
 				return
 			}
 
-			should.Equal(t, tc.exp.st, gt.getState())
+			should.Equal(t, tc.exp.gate, actual)
 		})
 	}
 }
 
-func TestSet_Shutdown(t *testing.T) {
+func TestSet_New(t *testing.T) {
 	type tcGiven struct {
-		tgs []*Tor
-		wgs []*WireGuard
-
-		fnCtx func() context.Context
+		cfg       *SetConfig
+		tgs       []*Tor
+		fnPrepSet func(set *Set)
+		kind      Kind
 	}
 
-	tests := []testCase[tcGiven, error]{
-		{
-			name: "noop_no_tor_no_wg",
-		},
+	type tcExpected struct {
+		id   uuid.UUID
+		gate exitGateExt
+		err  error
+		ok   bool
+	}
 
+	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "success_tor",
+			name: "error_kind_not_supported",
 			given: tcGiven{
-				tgs: []*Tor{
-					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-			},
-		},
+				cfg: &SetConfig{TorMax: 10},
+				fnPrepSet: func(set *Set) {
+					tf := &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							return nil, model.Error("unexpected_new")
+						},
+					}
 
-		{
-			name: "success_wireguard",
-			given: tcGiven{
-				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+					set.tf = tf
 				},
+				kind: KindDirect,
+			},
+			exp: tcExpected{
+				id:  uuid.Nil,
+				err: ErrKindNotSupported,
 			},
 		},
 
 		{
-			name: "success_both",
+			name: "error_set_is_shutting",
 			given: tcGiven{
-				tgs: []*Tor{
-					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				cfg: &SetConfig{TorMax: 10},
+				fnPrepSet: func(set *Set) {
+					tf := &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							return nil, model.Error("unexpected_new")
+						},
+					}
+
+					set.tf = tf
+					closeOrSkip(set.shutting)
 				},
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				id:  uuid.Nil,
+				err: ErrSetIsShutting,
 			},
 		},
 
 		{
-			name: "error_context_cancelled",
+			name: "error_tor_max_reached",
 			given: tcGiven{
+				cfg: &SetConfig{TorMax: 1},
 				tgs: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev: &torDev{
-							fnClose: func() error {
-								tmr := time.NewTimer(20 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
-								}()
-
-								<-tmr.C
-
-								return model.Error("unexpected_close_tor")
-							},
-						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
-					},
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
-				wgs: []*WireGuard{
-					{
-						baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						dev: &wgDev{
-							fnDown: func() error {
-								tmr := time.NewTimer(20 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
-								}()
-
-								<-tmr.C
-
-								return model.Error("unexpected_close_wg")
-							},
+				fnPrepSet: func(set *Set) {
+					tf := &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							return nil, model.Error("unexpected_new")
 						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
-					},
-				},
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
+					}
 
-					return ctx
+					set.tf = tf
 				},
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				id:  uuid.Nil,
+				err: ErrTorMaxReached,
 			},
-			exp: errors.Join(errors.Join(context.Canceled), errors.Join(context.Canceled)),
 		},
 
 		{
-			name: "errors",
+			name: "error_tor_api_max_reached",
 			given: tcGiven{
+				cfg: &SetConfig{TorMax: 10, TorAPIMax: 1},
 				tgs: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev: &torDev{
-							fnClose: func() error {
-								return model.Error("something_went_wrong_tor")
-							},
-						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
-					},
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
-				wgs: []*WireGuard{
-					{
-						baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						dev: &wgDev{
-							fnDown: func() error {
-								return model.Error("something_went_wrong_wg")
-							},
+				fnPrepSet: func(set *Set) {
+					tf := &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							return nil, model.Error("unexpected_new")
 						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
-					},
+					}
+
+					set.tf = tf
+					set.apiTors.Set(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), struct{}{})
 				},
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				id:  uuid.Nil,
+				err: ErrTorMaxReached,
 			},
-			exp: errors.Join(
-				errors.Join(model.Error("something_went_wrong_tor")),
-				errors.Join(model.Error("something_went_wrong_wg")),
-			),
 		},
-	}
-
-	for i := range tests {
-		tc := tests[i]
-
-		t.Run(tc.name, func(t *testing.T) {
-			set := NewSet(&SetConfig{}, nil, tc.given.tgs, tc.given.wgs)
-
-			ctx := context.Background()
-			if tc.given.fnCtx != nil {
-				ctx = tc.given.fnCtx()
-			}
-
-			actual := set.Shutdown(ctx)
-			must.ElementsMatch(t, model.UnwrapErrs(tc.exp), model.UnwrapErrs(actual))
-
-			actual2 := set.Shutdown(context.Background())
-			should.Equal(t, nil, actual2)
-		})
-	}
-}
-
-func TestSet_Warmup(t *testing.T) {
-	type tcGiven struct {
-		tgs []*Tor
-		wgs []*WireGuard
-
-		fnPrepSet func(set *Set)
-		fnCtx     func() context.Context
-	}
 
-	tests := []testCase[tcGiven, error]{
 		{
-			name: "error_set_is_shutting",
+			name: "error_something_went_wrong",
 			given: tcGiven{
+				cfg: &SetConfig{TorMax: 10},
 				fnPrepSet: func(set *Set) {
-					closeOrSkip(set.shutting)
+					tf := &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							return nil, model.Error("something_went_wrong")
+						},
+					}
+
+					set.tf = tf
 				},
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				id:  uuid.Nil,
+				err: model.Error("something_went_wrong"),
 			},
-			exp: errors.Join(ErrSetIsShutting),
 		},
 
 		{
-			name: "error_set_is_warming_up",
+			name: "error_warmup_on_create",
 			given: tcGiven{
+				cfg: &SetConfig{TorMax: 10, WarmupOnCreate: true},
 				fnPrepSet: func(set *Set) {
-					atomic.StoreUint32(&set.warming.value, 1)
-				},
-			},
-			exp: errors.Join(ErrSetIsWarmingUp),
-		},
+					tf := &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							doer := &MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									return nil, model.Error("something_went_wrong")
+								},
+							}
 
-		{
-			name: "noop_no_tor_no_wg",
-		},
+							return newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, doer), nil
+						},
+					}
 
-		{
-			name: "success_tor",
-			given: tcGiven{
-				tgs: []*Tor{
-					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+					set.tf = tf
 				},
+				kind: KindTor,
 			},
-		},
-
-		{
-			name: "success_wireguard",
-			given: tcGiven{
-				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
+			exp: tcExpected{
+				id:  uuid.Nil,
+				err: model.Error("something_went_wrong"),
 			},
 		},
 
 		{
-			name: "success_both",
+			name: "success_warmup_on_create",
 			given: tcGiven{
-				tgs: []*Tor{
-					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				cfg: &SetConfig{TorMax: 10, WarmupOnCreate: true},
+				fnPrepSet: func(set *Set) {
+					tf := &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							return newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}), nil
+						},
+					}
+
+					set.tf = tf
 				},
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				gate: newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				ok:   true,
 			},
 		},
 
 		{
-			name: "error_context_cancelled",
+			name: "error_tor_api_max_detached",
 			given: tcGiven{
+				cfg: &SetConfig{TorMax: 10, TorAPIMax: 1},
 				tgs: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								tmr := time.NewTimer(20 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
-								}()
-
-								<-tmr.C
-
-								return nil, model.Error("unexpected_do_tor")
-							},
-						},
-					},
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 				},
-				wgs: []*WireGuard{
-					{
-						baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						dev:      &wgDev{},
-						netd:     &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								tmr := time.NewTimer(20 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
-								}()
-
-								<-tmr.C
-
-								return nil, model.Error("unexpected_do_wg")
-							},
+				fnPrepSet: func(set *Set) {
+					tf := &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							return nil, model.Error("unexpected_new")
 						},
-					},
-				},
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
+					}
 
-					return ctx
+					set.tf = tf
+
+					// The gate created via the API earlier is out of the set for maintenance, but not closed.
+					set.apiTors.Set(uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"), struct{}{})
 				},
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				id:  uuid.Nil,
+				err: ErrTorMaxReached,
 			},
-			exp: errors.Join(errors.Join(context.Canceled), errors.Join(context.Canceled)),
 		},
 
 		{
-			name: "errors",
+			name: "success",
 			given: tcGiven{
-				tgs: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								return nil, model.Error("something_went_wrong_tor")
-							},
-						},
-					},
-				},
-				wgs: []*WireGuard{
-					{
-						baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						dev:      &wgDev{},
-						netd:     &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								return nil, model.Error("something_went_wrong_wg")
-							},
+				cfg: &SetConfig{TorMax: 10, HTTPTimeout: 10 * time.Second, TorHTTPTimeout: 2 * time.Minute},
+				fnPrepSet: func(set *Set) {
+					tf := &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							if cltout != 2*time.Minute {
+								return nil, model.Error("unexpected_timeout")
+							}
+
+							return newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}), nil
 						},
-					},
+					}
+
+					set.tf = tf
 				},
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				gate: newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				ok:   true,
 			},
-			exp: errors.Join(
-				errors.Join(model.Error("something_went_wrong_tor")),
-				errors.Join(model.Error("something_went_wrong_wg")),
-			),
 		},
 	}
 
@@ -1225,60 +1295,120 @@ func TestSet_Warmup(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			set := NewSet(&SetConfig{}, nil, tc.given.tgs, tc.given.wgs)
-			if tc.given.fnPrepSet != nil {
-				tc.given.fnPrepSet(set)
-			}
+			drts := []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})}
+			set := NewSet(tc.given.cfg, drts, tc.given.tgs, nil, nil)
+			tc.given.fnPrepSet(set)
 
 			ctx := context.Background()
-			if tc.given.fnCtx != nil {
-				ctx = tc.given.fnCtx()
+
+			actual, err := set.New(ctx, tc.given.kind)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.id, actual)
+
+			actual2, ok := set.tgs.Get(tc.exp.id)
+			must.Equal(t, tc.exp.ok, ok)
+
+			if !tc.exp.ok {
+				return
 			}
 
-			actual := set.Warmup(ctx)
-			must.ElementsMatch(t, model.UnwrapErrs(tc.exp), model.UnwrapErrs(actual))
+			// The latency of a warmup varies, so it's only checked to be recorded.
+			if tc.given.cfg.WarmupOnCreate {
+				should.Equal(t, true, actual2.warmupLatency() > 0)
+
+				actual2.setWarmup(0, nil)
+			}
+
+			should.Equal(t, tc.exp.gate, actual2)
 		})
 	}
 }
 
-func TestSet_kindOrDefaultN(t *testing.T) {
+func TestSet_New_concurrent(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	set := NewSet(&SetConfig{TorMax: 1}, nil, nil, nil, nil)
+	set.tf = &mockTorCreator{
+		fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+			close(started)
+			<-release
+
+			return newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}), nil
+		},
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := set.New(context.Background(), KindTor)
+		errc <- err
+	}()
+
+	<-started
+
+	// The gate being started counts towards the limit.
+	_, err := set.New(context.Background(), KindTor)
+	should.Equal(t, ErrTorMaxReached, err)
+
+	close(release)
+
+	must.Equal(t, nil, <-errc)
+	should.Equal(t, 1, set.tgs.Len())
+}
+
+func TestSet_NewN(t *testing.T) {
 	type tcGiven struct {
-		cfg *SetConfig
-		n   int
+		cfg  *SetConfig
+		kind Kind
+		n    int
 	}
 
-	tests := []testCase[tcGiven, Kind]{
+	type tcExpected struct {
+		ids []uuid.UUID
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "default",
+			name: "error_kind_not_supported",
 			given: tcGiven{
-				cfg: &SetConfig{Default: KindTor},
-				n:   69,
+				cfg:  &SetConfig{TorMax: 10},
+				kind: KindDirect,
+				n:    2,
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{},
+				err: ErrKindNotSupported,
 			},
-			exp: KindTor,
 		},
 
 		{
-			name: "randomise_tor",
+			name: "error_partial_tor_max_reached",
 			given: tcGiven{
-				cfg: &SetConfig{
-					Default:        KindWireGuard,
-					RandomiseKinds: true,
-				},
-				n: 42,
+				cfg:  &SetConfig{TorMax: 1},
+				kind: KindTor,
+				n:    2,
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				err: ErrTorMaxReached,
 			},
-			exp: KindTor,
 		},
 
 		{
-			name: "randomise_wireguard",
+			name: "success",
 			given: tcGiven{
-				cfg: &SetConfig{
-					Default:        KindTor,
-					RandomiseKinds: true,
+				cfg:  &SetConfig{TorMax: 10},
+				kind: KindTor,
+				n:    2,
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000001"),
 				},
-				n: 69,
 			},
-			exp: KindWireGuard,
 		},
 	}
 
@@ -1286,91 +1416,239 @@ func TestSet_kindOrDefaultN(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			set := NewSet(tc.given.cfg, nil, nil, nil)
+			drts := []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})}
+			set := NewSet(tc.given.cfg, drts, nil, nil, nil)
 
-			actual := set.kindOrDefaultN(tc.given.n)
-			should.Equal(t, tc.exp, actual)
+			var n byte
+			set.tf = &mockTorCreator{
+				fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+					id := uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")
+					id[15] = n
+					n++
+
+					return newTor(id, &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}), nil
+				},
+			}
+
+			ctx := context.Background()
+
+			actual, err := set.NewN(ctx, tc.given.kind, tc.given.n)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.ids, actual)
+			should.Equal(t, len(tc.exp.ids), set.tgs.Len())
 		})
 	}
 }
 
-func TestSet_byKindReady(t *testing.T) {
+func TestSet_GateIDs(t *testing.T) {
 	type tcGiven struct {
-		drt *Direct
-		tgs []*Tor
-		wgs []*WireGuard
-
-		kind      Kind
-		fnPrepSet func(set *Set)
-		fnCtx     func() context.Context
+		set  *Set
+		kind Kind
 	}
 
 	type tcExpected struct {
-		gt  exitGateExt
+		ids []uuid.UUID
 		err error
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_direct_context_cancelled",
+			name: "error_unknown_kind",
 			given: tcGiven{
-				drt: func() *Direct {
-					gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
-					gt.toState(stateMaintenance)
+				set: &Set{
+					drs: func() *model.Set[uuid.UUID, *Direct] {
+						mset := model.NewSet[uuid.UUID, *Direct]()
 
-					return gt
-				}(),
-				kind: KindDirect,
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
+						gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+						mset.Set(gt.id, gt)
 
-					return ctx
-				},
-			},
+						return mset
+					}(),
+					tgs: model.NewSet[uuid.UUID, *Tor](),
+					wgs: model.NewSet[uuid.UUID, *WireGuard](),
+				},
+				kind: Kind("shadowsocks"),
+			},
 			exp: tcExpected{
-				err: context.Canceled,
+				err: ErrKindUnknown,
 			},
 		},
 
 		{
-			name: "error_set_shutting",
+			name: "valid_direct_disabled",
 			given: tcGiven{
-				drt: func() *Direct {
-					gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
-					gt.toState(stateMaintenance)
+				set: &Set{
+					drs: model.NewSet[uuid.UUID, *Direct](),
+					tgs: model.NewSet[uuid.UUID, *Tor](),
+					wgs: model.NewSet[uuid.UUID, *WireGuard](),
+				},
+				kind: KindDirect,
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{},
+			},
+		},
 
-					return gt
-				}(),
+		{
+			name: "valid_direct",
+			given: tcGiven{
+				set: &Set{
+					drs: func() *model.Set[uuid.UUID, *Direct] {
+						mset := model.NewSet[uuid.UUID, *Direct]()
+
+						gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+						mset.Set(gt.id, gt)
+
+						return mset
+					}(),
+					tgs: model.NewSet[uuid.UUID, *Tor](),
+					wgs: model.NewSet[uuid.UUID, *WireGuard](),
+				},
 				kind: KindDirect,
-				fnPrepSet: func(set *Set) {
-					closeOrSkip(set.shutting)
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{uuid.MustParse("facade00-0000-4000-a000-000000000000")},
+			},
+		},
+
+		{
+			name: "valid_direct_pool",
+			given: tcGiven{
+				set: &Set{
+					drs: func() *model.Set[uuid.UUID, *Direct] {
+						mset := model.NewSet[uuid.UUID, *Direct]()
+
+						{
+							gt := newDirect(uuid.MustParse("facade01-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+							mset.Set(gt.id, gt)
+						}
+
+						{
+							gt := newDirect(uuid.MustParse("facade02-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+							mset.Set(gt.id, gt)
+						}
+
+						return mset
+					}(),
+					tgs: model.NewSet[uuid.UUID, *Tor](),
+					wgs: model.NewSet[uuid.UUID, *WireGuard](),
 				},
+				kind: KindDirect,
 			},
 			exp: tcExpected{
-				err: ErrSetIsShutting,
+				ids: []uuid.UUID{
+					uuid.MustParse("facade01-0000-4000-a000-000000000000"),
+					uuid.MustParse("facade02-0000-4000-a000-000000000000"),
+				},
 			},
 		},
 
 		{
-			name: "error_unknown_kind",
+			name: "valid_tor",
 			given: tcGiven{
-				drt:  newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				kind: Kind("something_else"),
+				set: &Set{
+					drs: func() *model.Set[uuid.UUID, *Direct] {
+						mset := model.NewSet[uuid.UUID, *Direct]()
+
+						gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+						mset.Set(gt.id, gt)
+
+						return mset
+					}(),
+					tgs: func() *model.Set[uuid.UUID, *Tor] {
+						mset := model.NewSet[uuid.UUID, *Tor]()
+
+						{
+							gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+							mset.Set(gt.id, gt)
+						}
+
+						{
+							gt := newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+							mset.Set(gt.id, gt)
+						}
+
+						return mset
+					}(),
+					wgs: model.NewSet[uuid.UUID, *WireGuard](),
+				},
+				kind: KindTor,
 			},
 			exp: tcExpected{
-				err: ErrKindUnknown,
+				ids: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				},
 			},
 		},
 
 		{
-			name: "valid_direct",
+			name: "valid_wireguard",
 			given: tcGiven{
-				drt:  newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				kind: KindDirect,
+				set: &Set{
+					drs: func() *model.Set[uuid.UUID, *Direct] {
+						mset := model.NewSet[uuid.UUID, *Direct]()
+
+						gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+						mset.Set(gt.id, gt)
+
+						return mset
+					}(),
+					tgs: model.NewSet[uuid.UUID, *Tor](),
+					wgs: func() *model.Set[uuid.UUID, *WireGuard] {
+						mset := model.NewSet[uuid.UUID, *WireGuard]()
+
+						{
+							gt := newWireGuard(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+							mset.Set(gt.id, gt)
+						}
+
+						{
+							gt := newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+							mset.Set(gt.id, gt)
+						}
+
+						return mset
+					}(),
+				},
+				kind: KindWireGuard,
 			},
 			exp: tcExpected{
-				gt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+				ids: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				},
+			},
+		},
+
+		{
+			name: "valid_openvpn",
+			given: tcGiven{
+				set: &Set{
+					drs: func() *model.Set[uuid.UUID, *Direct] {
+						mset := model.NewSet[uuid.UUID, *Direct]()
+
+						gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+						mset.Set(gt.id, gt)
+
+						return mset
+					}(),
+					tgs: model.NewSet[uuid.UUID, *Tor](),
+					wgs: model.NewSet[uuid.UUID, *WireGuard](),
+					ovs: func() *model.Set[uuid.UUID, *OpenVPN] {
+						mset := model.NewSet[uuid.UUID, *OpenVPN]()
+
+						gt := newOpenVPN(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &ovpnDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+						mset.Set(gt.id, gt)
+
+						return mset
+					}(),
+				},
+				kind: KindOpenVPN,
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
 			},
 		},
 	}
@@ -1379,117 +1657,132 @@ func TestSet_byKindReady(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			cfg := &SetConfig{
-				RandomLoopTout:  10 * time.Second,
-				RandomLoopDelay: 10 * time.Millisecond,
-			}
-
-			set := NewSet(cfg, tc.given.drt, tc.given.tgs, tc.given.wgs)
-			if tc.given.fnPrepSet != nil {
-				tc.given.fnPrepSet(set)
-			}
-
-			ctx := context.Background()
-			if tc.given.fnCtx != nil {
-				ctx = tc.given.fnCtx()
-			}
-
-			gt, err := set.byKindReady(ctx, tc.given.kind)
+			actual, err := tc.given.set.GateIDs(tc.given.kind)
 			must.Equal(t, tc.exp.err, err)
 
 			if tc.exp.err != nil {
 				return
 			}
 
-			should.Equal(t, tc.exp.gt, gt)
+			should.ElementsMatch(t, tc.exp.ids, actual)
 		})
 	}
 }
 
-func TestSet_byKind(t *testing.T) {
-	type tcGiven struct {
-		drt *Direct
-		tgs []*Tor
-		wgs []*WireGuard
+func TestSet_Count(t *testing.T) {
+	set := NewSet(
+		&SetConfig{},
+		[]*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+		[]*Tor{
+			newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+			newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+		},
+		[]*WireGuard{newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})},
+		nil,
+	)
 
-		kind Kind
-	}
+	tests := []testCase[Kind, int]{
+		{
+			name:  "unknown",
+			given: Kind("shadowsocks"),
+		},
 
-	type tcExpected struct {
-		gt  exitGateExt
-		err error
-	}
+		{
+			name:  "direct",
+			given: KindDirect,
+			exp:   1,
+		},
 
-	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_unknown_kind",
-			given: tcGiven{
-				drt:  newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				kind: Kind("something_else"),
-			},
-			exp: tcExpected{
-				err: ErrKindUnknown,
-			},
+			name:  "tor",
+			given: KindTor,
+			exp:   2,
 		},
 
 		{
-			name: "valid_direct",
-			given: tcGiven{
-				drt:  newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				kind: KindDirect,
-			},
-			exp: tcExpected{
-				gt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-			},
+			name:  "wireguard",
+			given: KindWireGuard,
+			exp:   1,
 		},
 
 		{
-			name: "error_tor_no_random_gate",
-			given: tcGiven{
-				drt:  newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				kind: KindTor,
-			},
-			exp: tcExpected{
-				err: ErrNoRandomGate,
-			},
+			name:  "openvpn",
+			given: KindOpenVPN,
 		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, set.Count(tc.given))
+		})
+	}
+
+	t.Run("total", func(t *testing.T) {
+		should.Equal(t, 4, set.Total())
+	})
+}
+
+func TestSet_SetGroup(t *testing.T) {
+	type tcGiven struct {
+		name string
+		ids  []uuid.UUID
+	}
 
+	type tcExpected struct {
+		groups map[string][]uuid.UUID
+		err    error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_wireguard_no_random_gate",
+			name: "error_not_found",
 			given: tcGiven{
-				drt:  newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				kind: KindWireGuard,
+				name: "fast",
+				ids: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("beef0000-0000-4000-a000-000000000000"),
+				},
 			},
 			exp: tcExpected{
-				err: ErrNoRandomGate,
+				groups: map[string][]uuid.UUID{
+					"slow": {uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				},
+				err: fmt.Errorf("%w: %s", ErrGateNotFound, "beef0000-0000-4000-a000-000000000000"),
 			},
 		},
 
 		{
-			name: "valid_tor",
+			name: "valid_new",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				tgs: []*Tor{
-					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				name: "fast",
+				ids: []uuid.UUID{
+					uuid.MustParse("decade00-0000-4000-a000-000000000000"),
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 				},
-				kind: KindTor,
 			},
 			exp: tcExpected{
-				gt: newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				groups: map[string][]uuid.UUID{
+					"fast": {
+						uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+						uuid.MustParse("decade00-0000-4000-a000-000000000000"),
+					},
+					"slow": {uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				},
 			},
 		},
 
 		{
-			name: "valid_wireguard",
+			name: "valid_replace",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				kind: KindWireGuard,
+				name: "slow",
+				ids:  []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
 			},
 			exp: tcExpected{
-				gt: newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				groups: map[string][]uuid.UUID{
+					"slow": {uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				},
 			},
 		},
 	}
@@ -1498,124 +1791,143 @@ func TestSet_byKind(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			set := NewSet(&SetConfig{}, tc.given.drt, tc.given.tgs, tc.given.wgs)
+			set := NewSet(
+				&SetConfig{},
+				nil,
+				[]*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+					newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				[]*WireGuard{newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})},
+				nil,
+			)
 
-			gt, err := set.byKind(tc.given.kind)
-			must.Equal(t, tc.exp.err, err)
+			must.Equal(t, nil, set.SetGroup("slow", []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")}))
 
-			if tc.exp.err != nil {
-				return
-			}
+			err := set.SetGroup(tc.given.name, tc.given.ids)
+			must.Equal(t, tc.exp.err, err)
 
-			should.Equal(t, tc.exp.gt, gt)
+			should.Equal(t, tc.exp.groups, set.Groups())
 		})
 	}
 }
 
-func TestSet_forState(t *testing.T) {
-	type tcGiven struct {
-		drt *Direct
-		tgs []*Tor
-		wgs []*WireGuard
+func TestSet_DeleteGroup(t *testing.T) {
+	set := NewSet(&SetConfig{}, nil, nil, nil, nil)
 
-		id        uuid.UUID
-		forState  state
-		fnPrepSet func(set *Set)
-		fnCtx     func() context.Context
+	must.Equal(t, nil, set.SetGroup("fast", nil))
+
+	t.Run("error_not_found", func(t *testing.T) {
+		should.Equal(t, ErrGroupNotFound, set.DeleteGroup("slow"))
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		must.Equal(t, nil, set.DeleteGroup("fast"))
+
+		should.Equal(t, map[string][]uuid.UUID{}, set.Groups())
+	})
+}
+
+func TestSet_Groups_closed(t *testing.T) {
+	tgs := []*Tor{
+		newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+		newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+	}
+
+	set := NewSet(&SetConfig{}, nil, tgs, nil, nil)
+
+	must.Equal(t, nil, set.SetGroup("fast", []uuid.UUID{tgs[0].id, tgs[1].id}))
+
+	set.tgs.Remove(tgs[0].id)
+
+	should.Equal(t, map[string][]uuid.UUID{"fast": {tgs[1].id}}, set.Groups())
+}
+
+func TestSet_ByGroup(t *testing.T) {
+	type tcGiven struct {
+		name   string
+		noWait bool
+		ready  []bool
+		closed []uuid.UUID
 	}
 
 	type tcExpected struct {
-		st      state
-		hasReqs bool
-		err     error
+		ids []uuid.UUID
+		err error
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_direct_context_cancelled",
+			name: "error_not_found",
 			given: tcGiven{
-				drt: func() *Direct {
-					gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
-					gt.AddReq()
-
-					return gt
-				}(),
-				id:       uuid.MustParse("facade00-0000-4000-a000-000000000000"),
-				forState: stateMaintenance,
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
-
-					return ctx
-				},
+				name:  "slow",
+				ready: []bool{true, true, true},
 			},
 			exp: tcExpected{
-				st:  stateReady,
-				err: context.Canceled,
+				err: ErrGroupNotFound,
 			},
 		},
 
 		{
-			name: "error_set_shutting",
+			name: "error_empty",
 			given: tcGiven{
-				drt: func() *Direct {
-					gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
-					gt.AddReq()
+				name:  "empty",
+				ready: []bool{true, true, true},
+			},
+			exp: tcExpected{
+				err: ErrNoRandomGate,
+			},
+		},
 
-					return gt
-				}(),
-				id:       uuid.MustParse("facade00-0000-4000-a000-000000000000"),
-				forState: stateMaintenance,
-				fnPrepSet: func(set *Set) {
-					closeOrSkip(set.shutting)
+		{
+			name: "error_all_closed",
+			given: tcGiven{
+				name:  "fast",
+				ready: []bool{true, true, true},
+				closed: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("decade00-0000-4000-a000-000000000000"),
 				},
 			},
 			exp: tcExpected{
-				st:      stateMaintenance,
-				hasReqs: true,
-				err:     ErrSetIsShutting,
+				err: ErrNoRandomGate,
 			},
 		},
 
 		{
-			name: "valid_direct",
+			name: "error_no_ready",
 			given: tcGiven{
-				drt:      newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				id:       uuid.MustParse("facade00-0000-4000-a000-000000000000"),
-				forState: stateMaintenance,
+				name:   "fast",
+				noWait: true,
+				ready:  []bool{true, false, false},
 			},
 			exp: tcExpected{
-				st: stateMaintenance,
+				err: ErrNoReadyGate,
 			},
 		},
 
 		{
-			name: "valid_tor",
+			name: "valid_one_ready",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				tgs: []*Tor{
-					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				id:       uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
-				forState: stateMaintenance,
+				name:  "fast",
+				ready: []bool{true, false, true},
 			},
 			exp: tcExpected{
-				st: stateMaintenance,
+				ids: []uuid.UUID{uuid.MustParse("decade00-0000-4000-a000-000000000000")},
 			},
 		},
 
 		{
-			name: "valid_wg",
+			name: "valid_any",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
-				},
-				id:       uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
-				forState: stateMaintenance,
+				name:  "fast",
+				ready: []bool{true, true, true},
 			},
 			exp: tcExpected{
-				st: stateMaintenance,
+				ids: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("decade00-0000-4000-a000-000000000000"),
+				},
 			},
 		},
 	}
@@ -1624,105 +1936,219 @@ func TestSet_forState(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			cfg := &SetConfig{
-				StateLoopTout:  10 * time.Second,
-				StateLoopDelay: 10 * time.Millisecond,
+			tgs := []*Tor{
+				newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
 			}
 
-			set := NewSet(cfg, tc.given.drt, tc.given.tgs, tc.given.wgs)
-			if tc.given.fnPrepSet != nil {
-				tc.given.fnPrepSet(set)
-			}
+			wgs := []*WireGuard{newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})}
 
-			ctx := context.Background()
-			if tc.given.fnCtx != nil {
-				ctx = tc.given.fnCtx()
+			gates := []exitGateExt{tgs[0], tgs[1], wgs[0]}
+			for j := range gates {
+				if !tc.given.ready[j] {
+					gates[j].toState(stateMaintenance)
+				}
 			}
 
-			gt, err := set.byID(tc.given.id)
-			must.Equal(t, nil, err)
+			set := NewSet(&SetConfig{RandomLoopTout: 10 * time.Second, RandomLoopDelay: time.Millisecond, RandomNoWait: tc.given.noWait, StateLoopTout: time.Second, StateLoopDelay: time.Millisecond}, nil, tgs, wgs, nil)
 
-			{
-				err := set.forState(ctx, gt, tc.given.forState)
-				must.Equal(t, tc.exp.err, err)
+			must.Equal(t, nil, set.SetGroup("fast", []uuid.UUID{tgs[1].id, wgs[0].id}))
+			must.Equal(t, nil, set.SetGroup("empty", nil))
+
+			for j := range tc.given.closed {
+				must.Equal(t, nil, set.CloseOne(context.Background(), tc.given.closed[j]))
 			}
 
-			should.Equal(t, tc.exp.st, gt.getState())
-			should.NotEqual(t, tc.exp.hasReqs, gt.noReqs())
+			actual, err := set.ByGroup(context.Background(), tc.given.name)
+			must.Equal(t, tc.exp.err, err)
 
-			switch gt.Kind() {
-			case KindDirect:
+			if tc.exp.err != nil {
 				return
-			case KindTor:
-				_, ok := set.tgs.Get(tc.given.id)
-				should.Equal(t, false, ok)
-			case KindWireGuard:
-				_, ok := set.wgs.Get(tc.given.id)
-				should.Equal(t, false, ok)
 			}
+
+			should.Contains(t, tc.exp.ids, actual.ID())
 		})
 	}
 }
 
-func TestSet_toState(t *testing.T) {
-	type tcGiven struct {
-		drt *Direct
-		tgs []*Tor
-		wgs []*WireGuard
+func TestSet_inheritGroups(t *testing.T) {
+	tgs := []*Tor{
+		newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+		newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+	}
 
-		id     uuid.UUID
-		fromSt state
-		toSt   state
+	set := NewSet(&SetConfig{}, nil, tgs[:1], nil, nil)
+
+	must.Equal(t, nil, set.SetGroup("fast", []uuid.UUID{tgs[0].id}))
+	must.Equal(t, nil, set.SetGroup("slow", nil))
+
+	set.tgs.Replace(tgs[0].id, tgs[1].id, tgs[1])
+	set.inheritGroups(tgs[0].id, tgs[1].id)
+
+	should.Equal(t, map[string][]uuid.UUID{"fast": {tgs[1].id}, "slow": {}}, set.Groups())
+}
+
+func TestSet_ConfigView(t *testing.T) {
+	tests := []testCase[*SetConfig, *ConfigView]{
+		{
+			name:  "empty",
+			given: &SetConfig{},
+			exp:   &ConfigView{},
+		},
+
+		{
+			name: "timeouts_fallback",
+			given: &SetConfig{
+				Default:        KindWireGuard,
+				RandomiseKinds: true,
+				HTTPTimeout:    30 * time.Second,
+				TorHTTPTimeout: 60 * time.Second,
+				StateLoopTout:  10 * time.Second,
+				LoopJitter:     5 * time.Millisecond,
+				TorMax:         8,
+				TorMin:         2,
+				FnBaseCtx:      context.Background,
+
+				AllowedConnectPorts: []int{443},
+			},
+			exp: &ConfigView{
+				Default:           KindWireGuard,
+				RandomiseKinds:    true,
+				TorHTTPTimeout:    60 * time.Second,
+				WGHTTPTimeout:     30 * time.Second,
+				OVPNHTTPTimeout:   30 * time.Second,
+				DirectHTTPTimeout: 30 * time.Second,
+				StateLoopTout:     10 * time.Second,
+				LoopJitter:        5 * time.Millisecond,
+				TorMax:            8,
+				TorMin:            2,
+
+				AllowedConnectPorts: []int{443},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			set := &Set{cfg: tc.given}
+
+			actual := set.ConfigView()
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestSet_GateInfos(t *testing.T) {
+	type tcGiven struct {
+		set  *Set
+		kind Kind
 	}
 
 	type tcExpected struct {
-		st  state
-		err error
+		infos []GateInfo
+		err   error
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "direct_maint_to_ready",
+			name: "error_unknown_kind",
 			given: tcGiven{
-				drt:    newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				id:     uuid.MustParse("facade00-0000-4000-a000-000000000000"),
-				fromSt: stateMaintenance,
-				toSt:   stateReady,
+				set:  NewSet(&SetConfig{}, nil, nil, nil, nil),
+				kind: KindUnknown,
 			},
 			exp: tcExpected{
-				st: stateReady,
+				err: ErrKindUnknown,
 			},
 		},
 
 		{
-			name: "tor_maint_ready",
+			name: "valid_direct_disabled",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				tgs: []*Tor{
-					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				set:  NewSet(&SetConfig{DisableDirect: true}, []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})}, nil, nil, nil),
+				kind: KindDirect,
+			},
+			exp: tcExpected{
+				infos: []GateInfo{},
+			},
+		},
+
+		{
+			name: "valid_direct",
+			given: tcGiven{
+				set:  NewSet(&SetConfig{}, []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})}, nil, nil, nil),
+				kind: KindDirect,
+			},
+			exp: tcExpected{
+				infos: []GateInfo{
+					{
+						ID:        uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+						Kind:      KindDirect,
+						Ready:     true,
+						CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+						LastUsed:  time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+					},
 				},
-				id:     uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
-				fromSt: stateMaintenance,
-				toSt:   stateReady,
+			},
+		},
+
+		{
+			name: "valid_direct_bound",
+			given: tcGiven{
+				set: func() *Set {
+					gt := newDirect(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+					gt.laddr = netip.MustParseAddr("192.0.2.1")
+
+					return NewSet(&SetConfig{}, []*Direct{gt}, nil, nil, nil)
+				}(),
+				kind: KindDirect,
 			},
 			exp: tcExpected{
-				st: stateReady,
+				infos: []GateInfo{
+					{
+						ID:        uuid.MustParse("decade00-0000-4000-a000-000000000000"),
+						Kind:      KindDirect,
+						Ready:     true,
+						CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+						LastUsed:  time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+						LocalAddr: "192.0.2.1",
+					},
+				},
 			},
 		},
 
 		{
-			name: "wg_maint_ready",
+			name: "valid_tor",
 			given: tcGiven{
-				drt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
-				wgs: []*WireGuard{
-					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				set: func() *Set {
+					gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+					gt.toState(stateMaintenance)
+
+					return NewSet(&SetConfig{}, nil, []*Tor{gt}, nil, nil)
+				}(),
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				infos: []GateInfo{
+					{
+						ID:        uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+						Kind:      KindTor,
+						CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+						LastUsed:  time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+					},
 				},
-				id:     uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
-				fromSt: stateMaintenance,
-				toSt:   stateReady,
+			},
+		},
+
+		{
+			name: "valid_wireguard_empty",
+			given: tcGiven{
+				set:  NewSet(&SetConfig{}, nil, nil, nil, nil),
+				kind: KindWireGuard,
 			},
 			exp: tcExpected{
-				st: stateReady,
+				infos: []GateInfo{},
 			},
 		},
 	}
@@ -1731,61 +2157,138 @@ func TestSet_toState(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			cfg := &SetConfig{
-				StateLoopTout:  10 * time.Second,
-				StateLoopDelay: 10 * time.Millisecond,
-			}
+			actual, err := tc.given.set.GateInfos(tc.given.kind)
+			must.Equal(t, tc.exp.err, err)
 
-			set := NewSet(cfg, tc.given.drt, tc.given.tgs, tc.given.wgs)
+			should.Equal(t, tc.exp.infos, actual)
+		})
+	}
+}
 
-			ctx := context.Background()
+func TestSet_Stats(t *testing.T) {
+	tests := []testCase[*Set, SetStats]{
+		{
+			name:  "empty",
+			given: NewSet(&SetConfig{DisableDirect: true}, nil, nil, nil, nil),
+			exp: SetStats{
+				Kinds: []KindStats{
+					{Kind: KindDirect},
+					{Kind: KindTor},
+					{Kind: KindWireGuard},
+					{Kind: KindOpenVPN},
+					{Kind: KindTorWG},
+				},
+			},
+		},
 
-			gt, err := set.byID(tc.given.id)
-			must.Equal(t, nil, err)
+		{
+			name: "mixed",
+			given: func() *Set {
+				dgt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+				dgt.AddReq()
 
-			{
-				err := set.forState(ctx, gt, tc.given.fromSt)
-				must.Equal(t, nil, err)
-			}
+				tgt1 := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+				tgt1.AddReq()
+				tgt1.AddReq()
 
-			actual := set.toState(gt, tc.given.toSt)
-			must.Equal(t, tc.exp.err, actual)
+				tgt2 := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000001"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+				tgt2.toState(stateMaintenance)
 
-			if tc.exp.err != nil {
-				return
-			}
+				tgt3 := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000002"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+				tgt3.toState(stateClosed)
 
-			gt2, err := set.byID(tc.given.id)
-			must.Equal(t, nil, err)
+				tgt4 := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000003"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+				tgt4.kind = KindTorWG
+				tgt4.AddReq()
 
-			should.Equal(t, tc.exp.st, gt2.getState())
-			should.Equal(t, true, gt2.noReqs())
-		})
-	}
-}
+				wgt := newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+				wgt.toState(stateMaintenance)
+				wgt.AddReq()
 
-func TestSet_isShutting(t *testing.T) {
-	tests := []testCase[*Set, bool]{
-		{
-			name:  "false_invalid",
-			given: &Set{},
+				return NewSet(&SetConfig{}, []*Direct{dgt}, []*Tor{tgt1, tgt2, tgt3, tgt4}, []*WireGuard{wgt}, nil)
+			}(),
+			exp: SetStats{
+				Kinds: []KindStats{
+					{Kind: KindDirect, Total: 1, Ready: 1, InFlight: 1},
+					{Kind: KindTor, Total: 3, Ready: 1, Maintenance: 1, Closed: 1, InFlight: 2},
+					{Kind: KindWireGuard, Total: 1, Maintenance: 1, InFlight: 1},
+					{Kind: KindOpenVPN},
+					{Kind: KindTorWG, Total: 1, Ready: 1, InFlight: 1},
+				},
+				InFlight: 5,
+			},
 		},
 
 		{
-			name:  "false",
-			given: &Set{shutting: make(chan struct{})},
+			name: "detached",
+			given: func() *Set {
+				tgt1 := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+
+				tgt2 := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000001"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+				tgt2.AddReq()
+				tgt2.AddReq()
+
+				wgt := newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+				wgt.AddReq()
+
+				result := NewSet(&SetConfig{DisableDirect: true}, nil, []*Tor{tgt1, tgt2}, []*WireGuard{wgt}, nil)
+				result.detach(context.Background(), tgt2, stateMaintenance)
+				result.detach(context.Background(), wgt, stateClosed)
+
+				return result
+			}(),
+			exp: SetStats{
+				Kinds: []KindStats{
+					{Kind: KindDirect},
+					{Kind: KindTor, Total: 2, Ready: 1, Maintenance: 1, InFlight: 2},
+					{Kind: KindWireGuard, Total: 1, Closed: 1, InFlight: 1},
+					{Kind: KindOpenVPN},
+					{Kind: KindTorWG},
+				},
+				InFlight: 3,
+			},
 		},
+	}
 
-		{
-			name: "true",
-			given: &Set{
-				shutting: func() chan struct{} {
-					out := make(chan struct{})
-					close(out)
-					return out
-				}(),
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.given.Stats()
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestSet_GateInfo(t *testing.T) {
+	type tcExpected struct {
+		info GateInfo
+		err  error
+	}
+
+	tests := []testCase[uuid.UUID, tcExpected]{
+		{
+			name:  "error_not_found",
+			given: uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+			exp: tcExpected{
+				err: ErrGateNotFound,
+			},
+		},
+
+		{
+			name:  "valid_not_ready",
+			given: uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+			exp: tcExpected{
+				info: GateInfo{
+					ID:        uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					Kind:      KindTor,
+					CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+					LastUsed:  time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+					WarmupErr: "context deadline exceeded",
+					BytesIn:   512,
+					BytesOut:  128,
+				},
 			},
-			exp: true,
 		},
 	}
 
@@ -1793,23 +2296,87 @@ func TestSet_isShutting(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			should.Equal(t, tc.exp, tc.given.isShutting())
+			gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+			gt.toState(stateMaintenance)
+			gt.setWarmup(0, context.DeadlineExceeded)
+			gt.AddBytes(500, 128)
+			gt.AddBytes(12, 0)
+
+			set := NewSet(&SetConfig{}, nil, []*Tor{gt}, nil, nil)
+
+			actual, err := set.GateInfo(tc.given)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.info, actual)
 		})
 	}
 }
 
-func TestSetConfig_BaseCtx(t *testing.T) {
-	tests := []testCase[*SetConfig, context.Context]{
+func TestSet_AnnotateOne(t *testing.T) {
+	type tcGiven struct {
+		id     uuid.UUID
+		before map[string]string
+		annots map[string]string
+	}
+
+	type tcExpected struct {
+		val  map[string]string
+		info map[string]string
+		err  error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name:  "default",
-			given: &SetConfig{},
-			exp:   context.Background(),
+			name: "error_not_found",
+			given: tcGiven{
+				id:     uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+				annots: map[string]string{"owner": "ops"},
+			},
+			exp: tcExpected{
+				err: ErrGateNotFound,
+			},
 		},
 
 		{
-			name:  "configured",
-			given: &SetConfig{FnBaseCtx: func() context.Context { return context.TODO() }},
-			exp:   context.TODO(),
+			name: "error_too_many",
+			given: tcGiven{
+				id:     uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				before: map[string]string{"owner": "ops"},
+				annots: func() map[string]string {
+					result := make(map[string]string, maxAnnotations)
+					for i := 0; i < maxAnnotations; i++ {
+						result[fmt.Sprintf("key%d", i)] = "value"
+					}
+
+					return result
+				}(),
+			},
+			exp: tcExpected{
+				info: map[string]string{"owner": "ops"},
+				err:  ErrTooManyAnnotations,
+			},
+		},
+
+		{
+			name: "valid_empty",
+			given: tcGiven{
+				id:     uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				annots: map[string]string{},
+			},
+			exp: tcExpected{},
+		},
+
+		{
+			name: "valid_merge_and_remove",
+			given: tcGiven{
+				id:     uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				before: map[string]string{"owner": "ops", "note": "flaky"},
+				annots: map[string]string{"note": "", "region": "eu"},
+			},
+			exp: tcExpected{
+				val:  map[string]string{"owner": "ops", "region": "eu"},
+				info: map[string]string{"owner": "ops", "region": "eu"},
+			},
 		},
 	}
 
@@ -1817,139 +2384,4294 @@ func TestSetConfig_BaseCtx(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			should.Equal(t, tc.exp, tc.given.BaseCtx())
+			gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+
+			_, err := gt.annotate(tc.given.before)
+			must.Equal(t, nil, err)
+
+			set := NewSet(&SetConfig{}, nil, []*Tor{gt}, nil, nil)
+
+			actual, err := set.AnnotateOne(tc.given.id, tc.given.annots)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.val, actual)
+
+			info, err := set.GateInfo(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"))
+			must.Equal(t, nil, err)
+
+			should.Equal(t, tc.exp.info, info.Annotations)
 		})
 	}
 }
 
-func TestShutdownList(t *testing.T) {
+func TestSet_ProbeOne(t *testing.T) {
 	type tcGiven struct {
-		list  []*Tor
-		fnCtx func() context.Context
+		cfg  *SetConfig
+		id   uuid.UUID
+		url  string
+		st   state
+		doer *MockHTTPDoer
+		body *strings.Reader
 	}
 
-	tests := []testCase[tcGiven, error]{
+	type tcExpected struct {
+		status int
+		left   int
+		err    error
+	}
+
+	drained := strings.NewReader(strings.Repeat("a", maxProbeDrain+16))
+
+	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "empty",
+			name: "error_invalid_url",
 			given: tcGiven{
-				list: []*Tor{},
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				url:  "ftp://example.com",
+				doer: &MockHTTPDoer{},
+			},
+			exp: tcExpected{
+				err: ErrInvalidProbeURL,
 			},
 		},
 
 		{
-			name: "error_single_context_canceled",
+			name: "error_no_host",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev: &torDev{
-							fnClose: func() error {
-								tmr := time.NewTimer(20 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
-								}()
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				url:  "https:///status",
+				doer: &MockHTTPDoer{},
+			},
+			exp: tcExpected{
+				err: ErrInvalidProbeURL,
+			},
+		},
 
-								<-tmr.C
+		{
+			name: "error_not_found",
+			given: tcGiven{
+				id:   uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+				url:  "https://example.com",
+				doer: &MockHTTPDoer{},
+			},
+			exp: tcExpected{
+				err: ErrGateNotFound,
+			},
+		},
 
-								return model.Error("unexpected_close")
-							},
-						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
+		{
+			name: "error_not_ready",
+			given: tcGiven{
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				url:  "https://example.com",
+				st:   stateMaintenance,
+				doer: &MockHTTPDoer{},
+			},
+			exp: tcExpected{
+				err: ErrGateNotReady,
+			},
+		},
+
+		{
+			name: "error_do",
+			given: tcGiven{
+				id:  uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				url: "https://example.com",
+				doer: &MockHTTPDoer{
+					FnDo: func(r *http.Request) (*http.Response, error) {
+						return nil, model.Error("something_went_wrong")
 					},
 				},
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
 
-					return ctx
+		{
+			name: "error_dest_not_allowed",
+			given: tcGiven{
+				cfg: &SetConfig{BlockPrivateDests: true},
+				id:  uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				url: "http://internal.example.com",
+				doer: &MockHTTPDoer{
+					FnDo: func(r *http.Request) (*http.Response, error) {
+						// Acts as the dialer of the gate.
+						if err := checkDestIP(r.Context(), netip.MustParseAddr("10.0.0.1")); err != nil {
+							return nil, err
+						}
+
+						return NewMockResponse(), nil
+					},
 				},
 			},
-			exp: errors.Join(context.Canceled),
+			exp: tcExpected{
+				err: ErrProbeDestNotAllowed,
+			},
 		},
 
 		{
-			name: "error_single",
+			name: "error_dest_literal_not_allowed",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev: &torDev{
-							fnClose: func() error {
-								return model.Error("something_went_wrong")
-							},
-						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
+				cfg: &SetConfig{BlockPrivateDests: true},
+				id:  uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				url: "http://[::1]:8080/admin",
+				doer: &MockHTTPDoer{
+					FnDo: func(r *http.Request) (*http.Response, error) {
+						return nil, model.Error("unexpected_do")
 					},
 				},
 			},
-			exp: errors.Join(model.Error("something_went_wrong")),
+			exp: tcExpected{
+				err: ErrProbeDestNotAllowed,
+			},
 		},
 
 		{
-			name: "success_single",
+			name: "valid_dest_allowed",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer:       &MockHTTPDoer{},
+				cfg: &SetConfig{BlockPrivateDests: true, AllowedPrivateDests: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+				id:  uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				url: "http://internal.example.com",
+				doer: &MockHTTPDoer{
+					FnDo: func(r *http.Request) (*http.Response, error) {
+						if err := checkDestIP(r.Context(), netip.MustParseAddr("10.0.0.1")); err != nil {
+							return nil, err
+						}
+
+						return NewMockResponse(), nil
 					},
 				},
 			},
+			exp: tcExpected{
+				status: http.StatusOK,
+			},
 		},
 
 		{
-			name: "error_multiple_context_canceled",
+			name: "valid_drains_body",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev: &torDev{
-							fnClose: func() error {
-								tmr := time.NewTimer(20 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
-								}()
+				id:  uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				url: "https://example.com",
+				doer: &MockHTTPDoer{
+					FnDo: func(r *http.Request) (*http.Response, error) {
+						resp := NewMockResponse()
+						resp.Body = io.NopCloser(drained)
 
-								<-tmr.C
+						return resp, nil
+					},
+				},
+				body: drained,
+			},
+			exp: tcExpected{
+				status: http.StatusOK,
+				left:   16,
+			},
+		},
 
-								return model.Error("unexpected_close_01")
-							},
-						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
+		{
+			name: "valid_any_status",
+			given: tcGiven{
+				id:  uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				url: "https://example.com/status",
+				doer: &MockHTTPDoer{
+					FnDo: func(r *http.Request) (*http.Response, error) {
+						if r.Method != http.MethodGet || r.URL.String() != "https://example.com/status" {
+							return nil, model.Error("unexpected_request")
+						}
+
+						resp := NewMockResponse()
+						resp.StatusCode = http.StatusForbidden
+
+						return resp, nil
 					},
+				},
+			},
+			exp: tcExpected{
+				status: http.StatusForbidden,
+			},
+		},
+	}
 
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev: &torDev{
-							fnClose: func() error {
-								tmr := time.NewTimer(10 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, tc.given.doer)
+			gt.toState(tc.given.st)
+
+			cfg := tc.given.cfg
+			if cfg == nil {
+				cfg = &SetConfig{}
+			}
+
+			set := NewSet(cfg, nil, []*Tor{gt}, nil, nil)
+
+			actual, err := set.ProbeOne(context.Background(), tc.given.id, tc.given.url)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, true, gt.noReqs())
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.status, actual.Status)
+
+			if tc.given.body != nil {
+				should.Equal(t, tc.exp.left, tc.given.body.Len())
+			}
+		})
+	}
+}
+
+func TestSet_RefreshOne(t *testing.T) {
+	type tcGiven struct {
+		drts      []*Direct
+		tgs       []*Tor
+		wgs       []*WireGuard
+		fnPrepSet func(set *Set)
+		id        uuid.UUID
+	}
+
+	type tcExpected struct {
+		st  state
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_direct_kind_not_supported",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				id:   uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrKindNotSupported,
+			},
+		},
+
+		{
+			name: "error_not_found",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				id:   uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrGateNotFound,
+			},
+		},
+
+		{
+			name: "error_busy",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				fnPrepSet: func(set *Set) {
+					gt, _ := set.tgs.Get(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"))
+					gt.lockOp()
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrGateBusy,
+			},
+		},
+
+		{
+			name: "error_for_state_shutting",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "error_refresh",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{
+						fnSignal: func(s string) error { return model.Error("something_went_wrong") },
+					}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "error_refresh_wireguard",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrInvalidWGPeerEndpoint,
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st: stateReady,
+			},
+		},
+
+		{
+			name: "success_wireguard",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				wgs: []*WireGuard{
+					func() *WireGuard {
+						result := newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+						result.endpoint = "127.0.0.1:58120"
+
+						return result
+					}(),
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st: stateReady,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				StateLoopTout:  10 * time.Second,
+				StateLoopDelay: 10 * time.Millisecond,
+			}
+
+			set := NewSet(cfg, tc.given.drts, tc.given.tgs, tc.given.wgs, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			ctx := context.Background()
+
+			actual := set.RefreshOne(ctx, tc.given.id)
+			must.Equal(t, tc.exp.err, actual)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			gt, err := set.byID(tc.given.id)
+			must.Equal(t, nil, err)
+
+			should.Equal(t, tc.exp.st, gt.getState())
+		})
+	}
+}
+
+func TestSet_CloseOne(t *testing.T) {
+	type tcGiven struct {
+		drts      []*Direct
+		tgs       []*Tor
+		wgs       []*WireGuard
+		fnPrepSet func(set *Set)
+		id        uuid.UUID
+	}
+
+	type tcExpected struct {
+		st  state
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_direct_kind_not_supported",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				id:   uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrKindNotSupported,
+			},
+		},
+
+		{
+			name: "error_not_found",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				id:   uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrGateNotFound,
+			},
+		},
+
+		{
+			name: "error_busy",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				fnPrepSet: func(set *Set) {
+					gt, _ := set.tgs.Get(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"))
+					gt.lockOp()
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrGateBusy,
+			},
+		},
+
+		{
+			name: "error_wireguard_upstream",
+			given: tcGiven{
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				fnPrepSet: func(set *Set) {
+					gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+					gt.kind = KindTorWG
+					gt.upstream = uuid.MustParse("ad0be000-0000-4000-a000-000000000000")
+
+					set.tws.Set(gt.id, gt)
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: used as upstream by %s", ErrGateBusy, uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")),
+			},
+		},
+
+		{
+			name: "error_for_state_shutting",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "error_shutdown",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{
+						fnClose: func() error { return model.Error("something_went_wrong") },
+					}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "success_tor",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st: stateClosed,
+			},
+		},
+
+		{
+			name: "success_tor_api",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				fnPrepSet: func(set *Set) {
+					set.apiTors.Set(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), struct{}{})
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st: stateClosed,
+			},
+		},
+
+		{
+			name: "success_wireguard",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st: stateClosed,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				StateLoopTout:  10 * time.Second,
+				StateLoopDelay: 10 * time.Millisecond,
+			}
+
+			set := NewSet(cfg, tc.given.drts, tc.given.tgs, tc.given.wgs, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			ctx := context.Background()
+
+			var gt exitGateExt
+			if tc.exp.err == nil {
+				var err error
+				gt, err = set.byID(tc.given.id)
+				must.Equal(t, nil, err)
+			}
+
+			actual := set.CloseOne(ctx, tc.given.id)
+			must.Equal(t, tc.exp.err, actual)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.st, gt.getState())
+
+			// A closed gate no longer counts towards TorAPIMax, nor in stats.
+			should.Equal(t, 0, set.apiTors.Len())
+			should.Equal(t, 0, set.detached.Len())
+		})
+	}
+}
+
+func TestSet_ForceCloseOne(t *testing.T) {
+	type tcGiven struct {
+		tgs       []*Tor
+		fnPrepSet func(set *Set)
+		id        uuid.UUID
+	}
+
+	type tcExpected struct {
+		st  state
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_direct_kind_not_supported",
+			given: tcGiven{
+				id: uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrKindNotSupported,
+			},
+		},
+
+		{
+			name: "error_shutting",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "error_not_found",
+			given: tcGiven{
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: ErrGateNotFound,
+			},
+		},
+
+		{
+			name: "error_wireguard_upstream",
+			given: tcGiven{
+				fnPrepSet: func(set *Set) {
+					wgt := newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+					set.wgs.Set(wgt.id, wgt)
+
+					gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+					gt.kind = KindTorWG
+					gt.upstream = wgt.id
+
+					set.tws.Set(gt.id, gt)
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: used as upstream by %s", ErrGateBusy, uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")),
+			},
+		},
+
+		{
+			name: "error_shutdown",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{
+						fnClose: func() error { return model.Error("something_went_wrong") },
+					}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "success_busy",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				fnPrepSet: func(set *Set) {
+					// The request never finishes, so CloseOne would wait for the whole StateLoopTout.
+					gt, _ := set.byID(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"))
+					gt.AddReq()
+				},
+				id: uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st: stateClosed,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				StateLoopTout:  time.Hour,
+				StateLoopDelay: 10 * time.Millisecond,
+			}
+
+			drts := []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})}
+
+			set := NewSet(cfg, drts, tc.given.tgs, nil, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			ctx := context.Background()
+
+			var gt exitGateExt
+			if tc.exp.err == nil {
+				var err error
+				gt, err = set.byID(tc.given.id)
+				must.Equal(t, nil, err)
+			}
+
+			actual := set.ForceCloseOne(ctx, tc.given.id)
+			must.Equal(t, tc.exp.err, actual)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.st, gt.getState())
+
+			_, err := set.byID(tc.given.id)
+			should.Equal(t, ErrGateNotFound, err)
+		})
+	}
+}
+
+func TestSet_Shutdown(t *testing.T) {
+	type tcGiven struct {
+		tgs  []*Tor
+		wgs  []*WireGuard
+		tout time.Duration
+
+		fnCtx func() context.Context
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "noop_no_tor_no_wg",
+		},
+
+		{
+			name: "success_tor",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+			},
+		},
+
+		{
+			name: "success_wireguard",
+			given: tcGiven{
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+			},
+		},
+
+		{
+			name: "success_both",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+			},
+		},
+
+		{
+			name: "error_context_cancelled",
+			given: tcGiven{
+				tgs: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev: &torDev{
+							fnClose: func() error {
+								tmr := time.NewTimer(20 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return model.Error("unexpected_close_tor")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+				},
+				wgs: []*WireGuard{
+					{
+						baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						dev: &wgDev{
+							fnDown: func() error {
+								tmr := time.NewTimer(20 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return model.Error("unexpected_close_wg")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+				},
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: errors.Join(errors.Join(context.Canceled), errors.Join(context.Canceled)),
+		},
+
+		{
+			name: "error_shutdown_timeout",
+			given: tcGiven{
+				tgs: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev: &torDev{
+							fnClose: func() error {
+								tmr := time.NewTimer(20 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return model.Error("unexpected_close_tor")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+				},
+				tout: 10 * time.Millisecond,
+			},
+			exp: errors.Join(errors.Join(context.DeadlineExceeded)),
+		},
+
+		{
+			name: "errors",
+			given: tcGiven{
+				tgs: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev: &torDev{
+							fnClose: func() error {
+								return model.Error("something_went_wrong_tor")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+				},
+				wgs: []*WireGuard{
+					{
+						baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						dev: &wgDev{
+							fnDown: func() error {
+								return model.Error("something_went_wrong_wg")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+				},
+			},
+			exp: errors.Join(
+				errors.Join(model.Error("something_went_wrong_tor")),
+				errors.Join(model.Error("something_went_wrong_wg")),
+			),
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			set := NewSet(&SetConfig{ShutdownTimeout: tc.given.tout}, nil, tc.given.tgs, tc.given.wgs, nil)
+
+			ctx := context.Background()
+			if tc.given.fnCtx != nil {
+				ctx = tc.given.fnCtx()
+			}
+
+			actual := set.Shutdown(ctx)
+			must.ElementsMatch(t, model.UnwrapErrs(tc.exp), model.UnwrapErrs(actual))
+
+			actual2 := set.Shutdown(context.Background())
+			should.Equal(t, nil, actual2)
+		})
+	}
+}
+
+func TestSet_Warmup(t *testing.T) {
+	type tcGiven struct {
+		tgs     []*Tor
+		wgs     []*WireGuard
+		warmups map[Kind]WarmupFunc
+		skip    map[Kind]bool
+
+		fnPrepSet func(set *Set)
+		fnCtx     func() context.Context
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "error_set_is_shutting",
+			given: tcGiven{
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+			},
+			exp: errors.Join(ErrSetIsShutting),
+		},
+
+		{
+			name: "error_set_is_warming_up",
+			given: tcGiven{
+				fnPrepSet: func(set *Set) {
+					atomic.StoreUint32(&set.warming.value, 1)
+				},
+			},
+			exp: errors.Join(ErrSetIsWarmingUp),
+		},
+
+		{
+			name: "noop_no_tor_no_wg",
+		},
+
+		{
+			name: "success_tor",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+			},
+		},
+
+		{
+			name: "success_wireguard",
+			given: tcGiven{
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+			},
+		},
+
+		{
+			name: "success_both",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+			},
+		},
+
+		{
+			name: "error_context_cancelled",
+			given: tcGiven{
+				tgs: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								tmr := time.NewTimer(20 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return nil, model.Error("unexpected_do_tor")
+							},
+						},
+					},
+				},
+				wgs: []*WireGuard{
+					{
+						baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						dev:      &wgDev{},
+						netd:     &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								tmr := time.NewTimer(20 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return nil, model.Error("unexpected_do_wg")
+							},
+						},
+					},
+				},
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: errors.Join(errors.Join(context.Canceled), errors.Join(context.Canceled)),
+		},
+
+		{
+			name: "errors",
+			given: tcGiven{
+				tgs: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								return nil, model.Error("something_went_wrong_tor")
+							},
+						},
+					},
+				},
+				wgs: []*WireGuard{
+					{
+						baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						dev:      &wgDev{},
+						netd:     &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								return nil, model.Error("something_went_wrong_wg")
+							},
+						},
+					},
+				},
+			},
+			exp: errors.Join(
+				errors.Join(model.Error("something_went_wrong_tor")),
+				errors.Join(model.Error("something_went_wrong_wg")),
+			),
+		},
+
+		{
+			name: "error_custom_warmup",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				warmups: map[Kind]WarmupFunc{
+					KindTor: func(ctx context.Context, gt ExitGate) (time.Duration, error) {
+						return 0, ErrWarmupNotTor
+					},
+				},
+			},
+			exp: errors.Join(errors.Join(ErrWarmupNotTor)),
+		},
+
+		{
+			name: "success_custom_warmup",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{
+						FnDo: func(r *http.Request) (*http.Response, error) {
+							return nil, model.Error("unexpected_default_warmup")
+						},
+					}),
+				},
+				warmups: map[Kind]WarmupFunc{
+					KindTor: func(ctx context.Context, gt ExitGate) (time.Duration, error) {
+						return time.Millisecond, nil
+					},
+				},
+			},
+		},
+
+		{
+			name: "success_skip_tor",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{
+						FnDo: func(r *http.Request) (*http.Response, error) {
+							return nil, model.Error("unexpected_tor_warmup")
+						},
+					}),
+				},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				skip: map[Kind]bool{KindTor: true},
+			},
+		},
+
+		{
+			name: "error_skip_other_kind",
+			given: tcGiven{
+				tgs: []*Tor{
+					newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{
+						FnDo: func(r *http.Request) (*http.Response, error) {
+							return nil, model.Error("something_went_wrong_tor")
+						},
+					}),
+				},
+				skip: map[Kind]bool{KindWireGuard: true},
+			},
+			exp: errors.Join(errors.Join(model.Error("something_went_wrong_tor"))),
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			set := NewSet(&SetConfig{Warmups: tc.given.warmups, SkipWarmup: tc.given.skip}, nil, tc.given.tgs, tc.given.wgs, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			ctx := context.Background()
+			if tc.given.fnCtx != nil {
+				ctx = tc.given.fnCtx()
+			}
+
+			actual := set.Warmup(ctx)
+			must.ElementsMatch(t, model.UnwrapErrs(tc.exp), model.UnwrapErrs(actual))
+
+			select {
+			case <-set.Ready():
+				should.Equal(t, nil, tc.exp)
+			default:
+				should.NotEqual(t, nil, tc.exp)
+			}
+		})
+	}
+}
+
+func TestSet_WarmupIDs(t *testing.T) {
+	type tcGiven struct {
+		wgs  []*WireGuard
+		ids  []uuid.UUID
+		skip map[Kind]bool
+
+		fnPrepSet func(set *Set)
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "error_set_is_shutting",
+			given: tcGiven{
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+			},
+			exp: errors.Join(ErrSetIsShutting),
+		},
+
+		{
+			name: "noop_unknown_id",
+			given: tcGiven{
+				ids: []uuid.UUID{uuid.MustParse("c0ffee00-0000-4000-a000-000000000000")},
+			},
+		},
+
+		{
+			name: "success_warming_flag_ignored",
+			given: tcGiven{
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("c0ffee00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				ids: []uuid.UUID{uuid.MustParse("c0ffee00-0000-4000-a000-000000000000")},
+				fnPrepSet: func(set *Set) {
+					atomic.StoreUint32(&set.warming.value, 1)
+				},
+			},
+		},
+
+		{
+			name: "success_skip_wireguard",
+			given: tcGiven{
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("c0ffee00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{
+						FnDo: func(r *http.Request) (*http.Response, error) {
+							return nil, model.Error("unexpected_wg_warmup")
+						},
+					}),
+				},
+				ids:  []uuid.UUID{uuid.MustParse("c0ffee00-0000-4000-a000-000000000000")},
+				skip: map[Kind]bool{KindWireGuard: true},
+			},
+		},
+
+		{
+			name: "error_only_given_ids",
+			given: tcGiven{
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("c0ffee00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{
+						FnDo: func(r *http.Request) (*http.Response, error) {
+							return nil, model.Error("something_went_wrong_wg")
+						},
+					}),
+					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{
+						FnDo: func(r *http.Request) (*http.Response, error) {
+							return nil, model.Error("unexpected_wg_warmup")
+						},
+					}),
+				},
+				ids: []uuid.UUID{uuid.MustParse("c0ffee00-0000-4000-a000-000000000000")},
+			},
+			exp: errors.Join(errors.Join(model.Error("something_went_wrong_wg"))),
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			set := NewSet(&SetConfig{SkipWarmup: tc.given.skip}, nil, nil, tc.given.wgs, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			actual := set.WarmupIDs(context.Background(), tc.given.ids)
+			must.ElementsMatch(t, model.UnwrapErrs(tc.exp), model.UnwrapErrs(actual))
+		})
+	}
+}
+
+func TestSet_WarmupIDs_KeepsReadyGates(t *testing.T) {
+	ctx := context.Background()
+
+	tgt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+	tgt.toState(stateReady)
+
+	wgt := newWireGuard(uuid.MustParse("c0ffee00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+
+	started, release := make(chan struct{}), make(chan struct{})
+
+	cfg := &SetConfig{
+		RandomLoopTout:  time.Second,
+		RandomLoopDelay: time.Millisecond,
+		Warmups: map[Kind]WarmupFunc{
+			KindWireGuard: func(ctx context.Context, gt ExitGate) (time.Duration, error) {
+				close(started)
+				<-release
+
+				return time.Millisecond, nil
+			},
+		},
+	}
+
+	set := NewSet(cfg, nil, []*Tor{tgt}, []*WireGuard{wgt}, nil)
+
+	errc := make(chan error, 1)
+	go func() { errc <- set.WarmupIDs(ctx, []uuid.UUID{wgt.ID()}) }()
+
+	<-started
+
+	// The gates that were ready before the reload keep serving requests.
+	should.Equal(t, false, set.IsWarming())
+
+	actual, err := set.ByKind(ctx, KindTor)
+	must.Equal(t, nil, err)
+
+	should.Equal(t, tgt.ID(), actual.ID())
+
+	close(release)
+	must.Equal(t, nil, <-errc)
+
+	should.Equal(t, stateReady, wgt.getState())
+
+	closeOrSkip(set.shutting)
+}
+
+func TestSet_IsWarming(t *testing.T) {
+	set := NewSet(&SetConfig{}, nil, nil, nil, nil)
+	should.Equal(t, false, set.IsWarming())
+
+	atomic.StoreUint32(&set.warming.value, 1)
+	should.Equal(t, true, set.IsWarming())
+}
+
+func TestSet_Pause(t *testing.T) {
+	ctx := context.Background()
+
+	gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+	gt.toState(stateReady)
+
+	set := NewSet(&SetConfig{RandomLoopTout: time.Second, RandomLoopDelay: time.Millisecond}, nil, []*Tor{gt}, nil, nil)
+
+	must.Equal(t, nil, set.Pause(ctx))
+	should.Equal(t, true, set.IsPaused())
+
+	{
+		_, err := set.ByID(gt.id)
+		should.Equal(t, ErrSetPaused, err)
+	}
+
+	{
+		_, err := set.ByKind(ctx, KindTor)
+		should.Equal(t, ErrSetPaused, err)
+	}
+
+	{
+		_, err := set.RandomExcept(ctx, KindTor, uuid.Nil)
+		should.Equal(t, ErrSetPaused, err)
+	}
+
+	// Gates are kept running while paused.
+	should.Equal(t, stateReady, gt.getState())
+
+	must.Equal(t, nil, set.Resume(ctx))
+	should.Equal(t, false, set.IsPaused())
+
+	actual, err := set.ByKind(ctx, KindTor)
+	must.Equal(t, nil, err)
+
+	should.Equal(t, gt.ID(), actual.ID())
+
+	closeOrSkip(set.shutting)
+
+	should.Equal(t, ErrSetIsShutting, set.Pause(ctx))
+	should.Equal(t, ErrSetIsShutting, set.Resume(ctx))
+}
+
+func TestSet_ReloadWireGuards(t *testing.T) {
+	type tcGiven struct {
+		cfg       *SetConfig
+		wgs       []*WireGuard
+		fnPrepSet func(set *Set)
+		dir       string
+	}
+
+	type tcExpected struct {
+		res *ReloadResult
+		err error
+		ids []uuid.UUID
+	}
+
+	const validKey = "b9bb1500aa4bb7ceeaf21dc6d2a28317e41509d52f2098d0c0eaae4da777161d"
+
+	newWireGuardKey := func(id uuid.UUID, key string) *WireGuard {
+		result := newWireGuard(id, &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+		result.key = key
+
+		return result
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_set_is_shutting",
+			given: tcGiven{
+				cfg: &SetConfig{WGParseMode: WGParseModeIgnore},
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+				dir: "./testdata/wg",
+			},
+			exp: tcExpected{
+				err: ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "error_set_is_reloading",
+			given: tcGiven{
+				cfg: &SetConfig{WGParseMode: WGParseModeIgnore},
+				fnPrepSet: func(set *Set) {
+					atomic.StoreUint32(&set.reloading.value, 1)
+				},
+				dir: "./testdata/wg",
+			},
+			exp: tcExpected{
+				err: ErrSetIsReloading,
+			},
+		},
+
+		{
+			name: "error_parse_stop",
+			given: tcGiven{
+				cfg: &SetConfig{WGParseMode: WGParseModeStop},
+				dir: "./testdata/wg",
+			},
+			exp: tcExpected{
+				err: ErrInvalidWGConfig,
+			},
+		},
+
+		{
+			name: "added",
+			given: tcGiven{
+				cfg: &SetConfig{WGParseMode: WGParseModeIgnore},
+				dir: "./testdata/wg",
+			},
+			exp: tcExpected{
+				res: &ReloadResult{
+					Added: []uuid.UUID{uuid.MustParse("decade00-0000-4000-a000-000000000000")},
+				},
+				ids: []uuid.UUID{uuid.MustParse("decade00-0000-4000-a000-000000000000")},
+			},
+		},
+
+		{
+			name: "unchanged_and_removed",
+			given: tcGiven{
+				cfg: &SetConfig{
+					WGParseMode:    WGParseModeIgnore,
+					StateLoopTout:  10 * time.Second,
+					StateLoopDelay: 10 * time.Millisecond,
+				},
+				wgs: []*WireGuard{
+					newWireGuardKey(uuid.MustParse("decade00-0000-4000-a000-000000000001"), validKey),
+					newWireGuardKey(uuid.MustParse("decade00-0000-4000-a000-000000000002"), "removed"),
+				},
+				fnPrepSet: func(set *Set) {
+					set.wf = &mockWGCreator{
+						fnNew: func(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout time.Duration) (*WireGuard, error) {
+							return nil, model.Error("unexpected_new")
+						},
+					}
+				},
+				dir: "./testdata/wg",
+			},
+			exp: tcExpected{
+				res: &ReloadResult{
+					Removed:   []uuid.UUID{uuid.MustParse("decade00-0000-4000-a000-000000000002")},
+					Unchanged: 1,
+				},
+				ids: []uuid.UUID{uuid.MustParse("decade00-0000-4000-a000-000000000001")},
+			},
+		},
+
+		{
+			name: "kept_upstream",
+			given: tcGiven{
+				cfg: &SetConfig{
+					WGParseMode:    WGParseModeIgnore,
+					StateLoopTout:  10 * time.Second,
+					StateLoopDelay: 10 * time.Millisecond,
+				},
+				wgs: []*WireGuard{
+					newWireGuardKey(uuid.MustParse("decade00-0000-4000-a000-000000000001"), validKey),
+					newWireGuardKey(uuid.MustParse("decade00-0000-4000-a000-000000000002"), "removed"),
+				},
+				fnPrepSet: func(set *Set) {
+					gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+					gt.kind = KindTorWG
+					gt.upstream = uuid.MustParse("decade00-0000-4000-a000-000000000002")
+
+					set.tws.Set(gt.id, gt)
+				},
+				dir: "./testdata/wg",
+			},
+			exp: tcExpected{
+				res: &ReloadResult{
+					Unchanged: 1,
+				},
+				err: errors.Join(
+					fmt.Errorf("%w: used as upstream by %s", ErrGateBusy, uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")),
+				),
+				ids: []uuid.UUID{
+					uuid.MustParse("decade00-0000-4000-a000-000000000001"),
+					uuid.MustParse("decade00-0000-4000-a000-000000000002"),
+				},
+			},
+		},
+
+		{
+			name: "report_errors",
+			given: tcGiven{
+				cfg: &SetConfig{WGParseMode: WGParseModeReport},
+				fnPrepSet: func(set *Set) {
+					set.wf = &mockWGCreator{
+						fnNew: func(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout time.Duration) (*WireGuard, error) {
+							return nil, model.Error("something_went_wrong")
+						},
+					}
+				},
+				dir: "./testdata/wg",
+			},
+			exp: tcExpected{
+				res: &ReloadResult{},
+				err: errors.Join(
+					errors.Join(
+						fmt.Errorf("failed to parse file: %s: %w", ".invalid_data", ErrInvalidWGConfig),
+						fmt.Errorf("failed to parse file: %s: %w", "wg_invalid_no_iface.ini", ErrInvalidWGConfig),
+						fmt.Errorf("failed to parse file: %s: %w", "wg_invalid_no_peer.ini", ErrInvalidWGConfig),
+					),
+					model.Error("something_went_wrong"),
+				),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			set := NewSet(tc.given.cfg, nil, nil, tc.given.wgs, nil)
+			set.wf = &mockWGCreator{}
+
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			ctx := context.Background()
+
+			actual, err := set.ReloadWireGuards(ctx, tc.given.dir)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.res, actual)
+
+			if tc.exp.res == nil {
+				return
+			}
+
+			should.ElementsMatch(t, tc.exp.ids, set.wgs.Keys())
+		})
+	}
+}
+
+func TestSet_Reload_wgFile(t *testing.T) {
+	cfg := &SetConfig{
+		WGDir:       "this/path/does/not/exist",
+		WGFile:      "./testdata/wg_file/wg_combined.conf",
+		WGParseMode: WGParseModeIgnore,
+	}
+
+	set := NewSet(cfg, nil, nil, nil, nil)
+	set.wf = &mockWGCreator{
+		fnNew: func(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout time.Duration) (*WireGuard, error) {
+			result := newWireGuard(uuid.New(), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+			result.key = cfg.Key()
+
+			return result, nil
+		},
+	}
+
+	actual, err := set.Reload(context.Background())
+	must.Equal(t, nil, err)
+
+	should.Equal(t, 2, len(actual.Added))
+	should.ElementsMatch(t, actual.Added, set.wgs.Keys())
+}
+
+func TestSet_kindOrDefaultN(t *testing.T) {
+	type tcGiven struct {
+		cfg *SetConfig
+		n   int
+	}
+
+	tests := []testCase[tcGiven, Kind]{
+		{
+			name: "default",
+			given: tcGiven{
+				cfg: &SetConfig{Default: KindTor},
+				n:   69,
+			},
+			exp: KindTor,
+		},
+
+		{
+			name: "randomise_tor",
+			given: tcGiven{
+				cfg: &SetConfig{
+					Default:        KindWireGuard,
+					RandomiseKinds: true,
+				},
+				n: 42,
+			},
+			exp: KindTor,
+		},
+
+		{
+			name: "randomise_wireguard",
+			given: tcGiven{
+				cfg: &SetConfig{
+					Default:        KindTor,
+					RandomiseKinds: true,
+				},
+				n: 69,
+			},
+			exp: KindWireGuard,
+		},
+
+		{
+			name: "randomise_weighted_direct",
+			given: tcGiven{
+				cfg: &SetConfig{
+					Default:           KindTor,
+					RandomiseKinds:    true,
+					RandomKindWeights: map[Kind]int{KindTor: 1, KindDirect: 2},
+				},
+				n: 2,
+			},
+			exp: KindDirect,
+		},
+
+		{
+			name: "weights_without_randomise",
+			given: tcGiven{
+				cfg: &SetConfig{
+					Default:           KindWireGuard,
+					RandomKindWeights: map[Kind]int{KindDirect: 1},
+				},
+				n: 69,
+			},
+			exp: KindWireGuard,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			set := NewSet(tc.given.cfg, nil, nil, nil, nil)
+
+			actual := set.kindOrDefaultN(tc.given.n)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestSet_byKindReady(t *testing.T) {
+	type tcGiven struct {
+		drts []*Direct
+		tgs  []*Tor
+		wgs  []*WireGuard
+
+		kind      Kind
+		noWait    bool
+		fnPrepSet func(set *Set)
+		fnCtx     func() context.Context
+	}
+
+	type tcExpected struct {
+		gt  exitGateExt
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_no_wait",
+			given: tcGiven{
+				tgs: func() []*Tor {
+					gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+					gt.toState(stateMaintenance)
+
+					return []*Tor{gt}
+				}(),
+				kind:   KindTor,
+				noWait: true,
+			},
+			exp: tcExpected{
+				err: ErrNoReadyGate,
+			},
+		},
+
+		{
+			name: "error_direct_context_cancelled",
+			given: tcGiven{
+				drts: []*Direct{func() *Direct {
+					gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+					gt.toState(stateMaintenance)
+
+					return gt
+				}()},
+				kind: KindDirect,
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: tcExpected{
+				err: context.Canceled,
+			},
+		},
+
+		{
+			name: "error_set_shutting",
+			given: tcGiven{
+				drts: []*Direct{func() *Direct {
+					gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+					gt.toState(stateMaintenance)
+
+					return gt
+				}()},
+				kind: KindDirect,
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+			},
+			exp: tcExpected{
+				err: ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "error_unknown_kind",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				kind: Kind("something_else"),
+			},
+			exp: tcExpected{
+				err: ErrKindUnknown,
+			},
+		},
+
+		{
+			name: "valid_direct",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				kind: KindDirect,
+			},
+			exp: tcExpected{
+				gt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+			},
+		},
+		{
+			name: "valid_no_wait_pick_not_ready",
+			given: tcGiven{
+				tgs: func() []*Tor {
+					gt1 := newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+					gt1.toState(stateMaintenance)
+
+					gt2 := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+
+					return []*Tor{gt1, gt2}
+				}(),
+				kind:   KindTor,
+				noWait: true,
+				fnPrepSet: func(set *Set) {
+					// The random pick is always the first gate, which is not ready.
+					set.SetIntN(func(n int) int { return 0 })
+				},
+			},
+			exp: tcExpected{
+				gt: newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				RandomLoopTout:  10 * time.Second,
+				RandomLoopDelay: 10 * time.Millisecond,
+				RandomNoWait:    tc.given.noWait,
+			}
+
+			set := NewSet(cfg, tc.given.drts, tc.given.tgs, tc.given.wgs, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			ctx := context.Background()
+			if tc.given.fnCtx != nil {
+				ctx = tc.given.fnCtx()
+			}
+
+			gt, err := set.byKindReady(ctx, tc.given.kind)
+			must.Equal(t, tc.exp.err, err)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.gt, gt)
+		})
+	}
+}
+
+func TestSet_byKindReady_debugLog(t *testing.T) {
+	gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+	gt.AddReq()
+
+	buf := &bytes.Buffer{}
+
+	cfg := &SetConfig{
+		RandomLoopTout:  10 * time.Second,
+		RandomLoopDelay: 10 * time.Millisecond,
+		Logger: slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+
+				return a
+			},
+		})),
+	}
+
+	set := NewSet(cfg, nil, []*Tor{gt}, nil, nil)
+
+	_, err := set.byKindReady(context.Background(), KindTor)
+	must.Equal(t, nil, err)
+
+	should.Equal(t, "level=DEBUG msg=\"picked gate\" gate.kind=tor gate.id=c0c0a000-0000-4000-a000-000000000000 gate.reqs=1 attempt=1\n", buf.String())
+}
+
+func TestSet_logSlowest(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &SetConfig{
+		Logger: slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+
+				return a
+			},
+		})),
+	}
+
+	set := NewSet(cfg, nil, nil, nil, nil)
+
+	lats := map[uuid.UUID]time.Duration{
+		uuid.MustParse("facade00-0000-4000-a000-000000000000"): time.Second,
+		uuid.MustParse("decade00-0000-4000-a000-000000000000"): 2 * time.Second,
+		uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"): 3 * time.Second,
+		uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"): 4 * time.Second,
+	}
+
+	set.logSlowest(context.Background(), KindTor, lats)
+
+	exp := "level=INFO msg=\"slow warmup\" gate.kind=tor gate.id=5ca1ab1e-0000-4000-a000-000000000000 latency=4s\n" +
+		"level=INFO msg=\"slow warmup\" gate.kind=tor gate.id=c0c0a000-0000-4000-a000-000000000000 latency=3s\n" +
+		"level=INFO msg=\"slow warmup\" gate.kind=tor gate.id=decade00-0000-4000-a000-000000000000 latency=2s\n"
+
+	should.Equal(t, exp, buf.String())
+}
+
+func TestSet_shutdownOne_log(t *testing.T) {
+	type tcExpected struct {
+		log string
+		err error
+	}
+
+	tests := []testCase[*Tor, tcExpected]{
+		{
+			name: "error_close",
+			given: newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{
+				fnClose: func() error {
+					return model.Error("something_went_wrong")
+				},
+			}, &MockNetDialer{}, &MockHTTPDoer{}),
+			exp: tcExpected{
+				log: "level=WARN msg=\"failed to close gate\" gate.kind=tor gate.id=c0c0a000-0000-4000-a000-000000000000 error=something_went_wrong\n",
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name:  "success",
+			given: newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+			exp: tcExpected{
+				log: "level=INFO msg=\"closed gate\" gate.kind=tor gate.id=c0c0a000-0000-4000-a000-000000000000\n",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+
+			cfg := &SetConfig{
+				Logger: slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+					ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+						if a.Key == slog.TimeKey {
+							return slog.Attr{}
+						}
+
+						return a
+					},
+				})),
+			}
+
+			set := NewSet(cfg, nil, nil, nil, nil)
+
+			err := set.shutdownOne(context.Background(), tc.given)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.log, buf.String())
+		})
+	}
+}
+
+func TestSet_byKind(t *testing.T) {
+	type tcGiven struct {
+		drts []*Direct
+		tgs  []*Tor
+		wgs  []*WireGuard
+
+		kind Kind
+	}
+
+	type tcExpected struct {
+		gt  exitGateExt
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_unknown_kind",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				kind: Kind("something_else"),
+			},
+			exp: tcExpected{
+				err: ErrKindUnknown,
+			},
+		},
+
+		{
+			name: "valid_direct",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				kind: KindDirect,
+			},
+			exp: tcExpected{
+				gt: newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{}),
+			},
+		},
+
+		{
+			name: "error_tor_no_random_gate",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				err: ErrNoRandomGate,
+			},
+		},
+
+		{
+			name: "error_wireguard_no_random_gate",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				kind: KindWireGuard,
+			},
+			exp: tcExpected{
+				err: ErrNoRandomGate,
+			},
+		},
+
+		{
+			name: "valid_tor",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				kind: KindTor,
+			},
+			exp: tcExpected{
+				gt: newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+			},
+		},
+
+		{
+			name: "valid_wireguard",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				kind: KindWireGuard,
+			},
+			exp: tcExpected{
+				gt: newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			set := NewSet(&SetConfig{}, tc.given.drts, tc.given.tgs, tc.given.wgs, nil)
+
+			gt, err := set.byKind(tc.given.kind)
+			must.Equal(t, tc.exp.err, err)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.gt, gt)
+		})
+	}
+}
+
+func TestSet_forState(t *testing.T) {
+	type tcGiven struct {
+		drts []*Direct
+		tgs  []*Tor
+		wgs  []*WireGuard
+
+		id        uuid.UUID
+		forState  state
+		fnPrepSet func(set *Set)
+		fnCtx     func() context.Context
+	}
+
+	type tcExpected struct {
+		st      state
+		hasReqs bool
+		err     error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_direct_context_cancelled",
+			given: tcGiven{
+				drts: []*Direct{func() *Direct {
+					gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+					gt.AddReq()
+
+					return gt
+				}()},
+				id:       uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+				forState: stateMaintenance,
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: tcExpected{
+				st:  stateReady,
+				err: context.Canceled,
+			},
+		},
+
+		{
+			name: "error_set_shutting",
+			given: tcGiven{
+				drts: []*Direct{func() *Direct {
+					gt := newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})
+					gt.AddReq()
+
+					return gt
+				}()},
+				id:       uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+				forState: stateMaintenance,
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+			},
+			exp: tcExpected{
+				st:      stateMaintenance,
+				hasReqs: true,
+				err:     ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "valid_direct",
+			given: tcGiven{
+				drts:     []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				id:       uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+				forState: stateMaintenance,
+			},
+			exp: tcExpected{
+				st: stateMaintenance,
+			},
+		},
+
+		{
+			name: "valid_tor",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id:       uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				forState: stateMaintenance,
+			},
+			exp: tcExpected{
+				st: stateMaintenance,
+			},
+		},
+
+		{
+			name: "valid_wg",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id:       uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				forState: stateMaintenance,
+			},
+			exp: tcExpected{
+				st: stateMaintenance,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				StateLoopTout:  10 * time.Second,
+				StateLoopDelay: 10 * time.Millisecond,
+			}
+
+			set := NewSet(cfg, tc.given.drts, tc.given.tgs, tc.given.wgs, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			ctx := context.Background()
+			if tc.given.fnCtx != nil {
+				ctx = tc.given.fnCtx()
+			}
+
+			gt, err := set.byID(tc.given.id)
+			must.Equal(t, nil, err)
+
+			{
+				err := set.forState(ctx, gt, tc.given.forState)
+				must.Equal(t, tc.exp.err, err)
+			}
+
+			should.Equal(t, tc.exp.st, gt.getState())
+			should.NotEqual(t, tc.exp.hasReqs, gt.noReqs())
+
+			switch gt.Kind() {
+			case KindDirect:
+				return
+			case KindTor:
+				_, ok := set.tgs.Get(tc.given.id)
+				should.Equal(t, false, ok)
+			case KindWireGuard:
+				_, ok := set.wgs.Get(tc.given.id)
+				should.Equal(t, false, ok)
+			}
+		})
+	}
+}
+
+func TestSet_toState(t *testing.T) {
+	type tcGiven struct {
+		drts []*Direct
+		tgs  []*Tor
+		wgs  []*WireGuard
+
+		id     uuid.UUID
+		fromSt state
+		toSt   state
+	}
+
+	type tcExpected struct {
+		st  state
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "direct_maint_to_ready",
+			given: tcGiven{
+				drts:   []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				id:     uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+				fromSt: stateMaintenance,
+				toSt:   stateReady,
+			},
+			exp: tcExpected{
+				st: stateReady,
+			},
+		},
+
+		{
+			name: "tor_maint_ready",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id:     uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				fromSt: stateMaintenance,
+				toSt:   stateReady,
+			},
+			exp: tcExpected{
+				st: stateReady,
+			},
+		},
+
+		{
+			name: "wg_maint_ready",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id:     uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				fromSt: stateMaintenance,
+				toSt:   stateReady,
+			},
+			exp: tcExpected{
+				st: stateReady,
+			},
+		},
+
+		{
+			name: "error_tor_closed_to_ready",
+			given: tcGiven{
+				drts: []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})},
+				tgs: []*Tor{
+					newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+				id:     uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				fromSt: stateClosed,
+				toSt:   stateReady,
+			},
+			exp: tcExpected{
+				st:  stateClosed,
+				err: ErrIllegalStateTransition,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				StateLoopTout:  10 * time.Second,
+				StateLoopDelay: 10 * time.Millisecond,
+			}
+
+			set := NewSet(cfg, tc.given.drts, tc.given.tgs, tc.given.wgs, nil)
+
+			ctx := context.Background()
+
+			gt, err := set.byID(tc.given.id)
+			must.Equal(t, nil, err)
+
+			{
+				err := set.forState(ctx, gt, tc.given.fromSt)
+				must.Equal(t, nil, err)
+			}
+
+			actual := set.toState(gt, tc.given.toSt)
+			must.Equal(t, tc.exp.err, actual)
+
+			if tc.exp.err != nil {
+				should.Equal(t, tc.exp.st, gt.getState())
+
+				// The gate must not be put back into the set.
+				_, err := set.byID(tc.given.id)
+				should.Equal(t, ErrGateNotFound, err)
+
+				return
+			}
+
+			gt2, err := set.byID(tc.given.id)
+			must.Equal(t, nil, err)
+
+			should.Equal(t, tc.exp.st, gt2.getState())
+			should.Equal(t, true, gt2.noReqs())
+		})
+	}
+}
+
+func TestSet_ReportResult(t *testing.T) {
+	type tcGiven struct {
+		threshold int
+		nfail     uint64
+		id        uuid.UUID
+		ok        bool
+	}
+
+	type tcExpected struct {
+		st    state
+		nfail uint64
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "disabled",
+			given: tcGiven{
+				nfail: 1,
+				id:    uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st:    stateReady,
+				nfail: 1,
+			},
+		},
+
+		{
+			name: "not_found",
+			given: tcGiven{
+				threshold: 1,
+				nfail:     1,
+				id:        uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st:    stateReady,
+				nfail: 1,
+			},
+		},
+
+		{
+			name: "success_resets",
+			given: tcGiven{
+				threshold: 3,
+				nfail:     2,
+				id:        uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				ok:        true,
+			},
+			exp: tcExpected{
+				st: stateReady,
+			},
+		},
+
+		{
+			name: "failure_below_threshold",
+			given: tcGiven{
+				threshold: 3,
+				nfail:     1,
+				id:        uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st:    stateReady,
+				nfail: 2,
+			},
+		},
+
+		{
+			name: "failure_reached_threshold",
+			given: tcGiven{
+				threshold: 3,
+				nfail:     2,
+				id:        uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+			},
+			exp: tcExpected{
+				st: stateMaintenance,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			gt := newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+			gt.state.nfail = tc.given.nfail
+
+			cfg := &SetConfig{
+				FailThreshold: tc.given.threshold,
+				FailCooldown:  time.Hour,
+			}
+
+			set := NewSet(cfg, []*Direct{newDirect(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &MockNetDialer{}, &MockHTTPDoer{})}, []*Tor{gt}, nil, nil)
+			defer closeOrSkip(set.shutting)
+
+			set.ReportResult(tc.given.id, tc.given.ok)
+
+			should.Equal(t, tc.exp.st, gt.getState())
+			should.Equal(t, tc.exp.nfail, gt.state.failNum())
+		})
+	}
+}
+
+func TestSet_cooldown(t *testing.T) {
+	type tcGiven struct {
+		shutting bool
+		cooldown time.Duration
+		fromSt   state
+	}
+
+	tests := []testCase[tcGiven, state]{
+		{
+			name: "shutting",
+			given: tcGiven{
+				shutting: true,
+				cooldown: time.Hour,
+				fromSt:   stateMaintenance,
+			},
+			exp: stateMaintenance,
+		},
+
+		{
+			name: "closed",
+			given: tcGiven{
+				cooldown: time.Millisecond,
+				fromSt:   stateClosed,
+			},
+			exp: stateClosed,
+		},
+
+		{
+			name: "recovered",
+			given: tcGiven{
+				cooldown: time.Millisecond,
+				fromSt:   stateMaintenance,
+			},
+			exp: stateReady,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			gt := newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+			gt.toState(tc.given.fromSt)
+
+			set := NewSet(&SetConfig{FailCooldown: tc.given.cooldown}, nil, []*Tor{gt}, nil, nil)
+			if tc.given.shutting {
+				closeOrSkip(set.shutting)
+			}
+
+			set.cooldown(gt)
+
+			should.Equal(t, tc.exp, gt.getState())
+		})
+	}
+}
+
+func TestSet_CloseIdle(t *testing.T) {
+	type tcGiven struct {
+		tgs       []*Tor
+		torMin    int
+		kind      Kind
+		minIdle   time.Duration
+		fnPrepSet func(set *Set)
+	}
+
+	type tcExpected struct {
+		ids    []uuid.UUID
+		setIDs []uuid.UUID
+		err    error
+	}
+
+	newIdleTor := func(id string, idle time.Duration, dev *torDev) *Tor {
+		result := newTor(uuid.MustParse(id), dev, &MockNetDialer{}, &MockHTTPDoer{})
+		result.state.lastUsed.value = timeNow().Add(-idle).UnixNano()
+
+		return result
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_kind_not_supported",
+			given: tcGiven{
+				tgs:     []*Tor{newIdleTor("c0c0a000-0000-4000-a000-000000000000", 2*time.Hour, &torDev{})},
+				kind:    KindWireGuard,
+				minIdle: time.Hour,
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				err:    ErrKindNotSupported,
+			},
+		},
+
+		{
+			name: "error_shutting",
+			given: tcGiven{
+				tgs:     []*Tor{newIdleTor("c0c0a000-0000-4000-a000-000000000000", 2*time.Hour, &torDev{})},
+				kind:    KindTor,
+				minIdle: time.Hour,
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				err:    ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "error_close",
+			given: tcGiven{
+				tgs: []*Tor{
+					newIdleTor("c0c0a000-0000-4000-a000-000000000000", 2*time.Hour, &torDev{
+						fnClose: func() error {
+							return model.Error("something_went_wrong")
+						},
+					}),
+				},
+				kind:    KindTor,
+				minIdle: time.Hour,
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{},
+				err:    errors.Join(model.Error("something_went_wrong")),
+			},
+		},
+
+		{
+			name: "none_idle_enough",
+			given: tcGiven{
+				tgs: []*Tor{
+					newIdleTor("c0c0a000-0000-4000-a000-000000000000", time.Minute, &torDev{}),
+					newIdleTor("c0c0a001-0000-4000-a000-000000000000", 0, &torDev{}),
+				},
+				kind:    KindTor,
+				minIdle: time.Hour,
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("c0c0a001-0000-4000-a000-000000000000"),
+				},
+			},
+		},
+
+		{
+			name: "skips_busy_and_not_ready",
+			given: tcGiven{
+				tgs: []*Tor{
+					func() *Tor {
+						result := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+						result.AddReq()
+						result.state.lastUsed.value = timeNow().Add(-2 * time.Hour).UnixNano()
+
+						return result
+					}(),
+
+					func() *Tor {
+						result := newIdleTor("c0c0a001-0000-4000-a000-000000000000", 2*time.Hour, &torDev{})
+						result.toState(stateMaintenance)
+
+						return result
+					}(),
+				},
+				kind:    KindTor,
+				minIdle: time.Hour,
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("c0c0a001-0000-4000-a000-000000000000"),
+				},
+			},
+		},
+
+		{
+			name: "keeps_min",
+			given: tcGiven{
+				tgs: []*Tor{
+					newIdleTor("c0c0a000-0000-4000-a000-000000000000", 2*time.Hour, &torDev{}),
+					newIdleTor("c0c0a001-0000-4000-a000-000000000000", 3*time.Hour, &torDev{}),
+					newIdleTor("c0c0a002-0000-4000-a000-000000000000", 90*time.Minute, &torDev{}),
+				},
+				torMin:  2,
+				kind:    KindTor,
+				minIdle: time.Hour,
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{uuid.MustParse("c0c0a001-0000-4000-a000-000000000000")},
+				setIDs: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("c0c0a002-0000-4000-a000-000000000000"),
+				},
+			},
+		},
+
+		{
+			name: "closes_all_idle",
+			given: tcGiven{
+				tgs: []*Tor{
+					newIdleTor("c0c0a000-0000-4000-a000-000000000000", 2*time.Hour, &torDev{}),
+					newIdleTor("c0c0a001-0000-4000-a000-000000000000", 3*time.Hour, &torDev{}),
+					newIdleTor("c0c0a002-0000-4000-a000-000000000000", time.Minute, &torDev{}),
+				},
+				kind:    KindTor,
+				minIdle: time.Hour,
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{
+					uuid.MustParse("c0c0a001-0000-4000-a000-000000000000"),
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				},
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a002-0000-4000-a000-000000000000")},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				StateLoopTout:  10 * time.Second,
+				StateLoopDelay: 10 * time.Millisecond,
+				TorMin:         tc.given.torMin,
+			}
+
+			set := NewSet(cfg, nil, tc.given.tgs, nil, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			actual, err := set.CloseIdle(context.Background(), tc.given.kind, tc.given.minIdle)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.ids, actual)
+			should.ElementsMatch(t, tc.exp.setIDs, set.tgs.Keys())
+		})
+	}
+}
+
+func TestSet_ScaleTors(t *testing.T) {
+	type tcGiven struct {
+		tgs       []*Tor
+		n         int
+		fnPrepSet func(set *Set)
+	}
+
+	type tcExpected struct {
+		res    *ScaleResult
+		setIDs []uuid.UUID
+		err    error
+	}
+
+	newAgedTor := func(id string, age time.Duration, reqs int) *Tor {
+		result := newTor(uuid.MustParse(id), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+		result.createdAt = timeNow().Add(-age)
+
+		for i := 0; i < reqs; i++ {
+			result.AddReq()
+		}
+
+		return result
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_tor_max_reached",
+			given: tcGiven{
+				tgs: []*Tor{newAgedTor("c0c0a000-0000-4000-a000-000000000000", time.Hour, 0)},
+				n:   5,
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				err:    ErrTorMaxReached,
+			},
+		},
+
+		{
+			name: "error_shutting",
+			given: tcGiven{
+				tgs: []*Tor{newAgedTor("c0c0a000-0000-4000-a000-000000000000", time.Hour, 0)},
+				n:   2,
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				err:    ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "unchanged",
+			given: tcGiven{
+				tgs: []*Tor{newAgedTor("c0c0a000-0000-4000-a000-000000000000", time.Hour, 0)},
+				n:   1,
+			},
+			exp: tcExpected{
+				res:    &ScaleResult{Created: []uuid.UUID{}},
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+			},
+		},
+
+		{
+			name: "scale_up",
+			given: tcGiven{
+				tgs: []*Tor{newAgedTor("c0c0a000-0000-4000-a000-000000000000", time.Hour, 0)},
+				n:   3,
+			},
+			exp: tcExpected{
+				res: &ScaleResult{
+					Created: []uuid.UUID{
+						uuid.MustParse("c0c0b000-0000-4000-a000-000000000000"),
+						uuid.MustParse("c0c0b000-0000-4000-a000-000000000001"),
+					},
+				},
+				setIDs: []uuid.UUID{
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					uuid.MustParse("c0c0b000-0000-4000-a000-000000000000"),
+					uuid.MustParse("c0c0b000-0000-4000-a000-000000000001"),
+				},
+			},
+		},
+
+		{
+			name: "scale_down",
+			given: tcGiven{
+				tgs: []*Tor{
+					newAgedTor("c0c0a000-0000-4000-a000-000000000000", 3*time.Hour, 1),
+					newAgedTor("c0c0a001-0000-4000-a000-000000000000", time.Hour, 0),
+					newAgedTor("c0c0a002-0000-4000-a000-000000000000", 2*time.Hour, 0),
+					newAgedTor("c0c0a003-0000-4000-a000-000000000000", 30*time.Minute, 0),
+				},
+				n: 1,
+			},
+			exp: tcExpected{
+				res: &ScaleResult{
+					Closed: []uuid.UUID{
+						uuid.MustParse("c0c0a002-0000-4000-a000-000000000000"),
+						uuid.MustParse("c0c0a001-0000-4000-a000-000000000000"),
+						uuid.MustParse("c0c0a003-0000-4000-a000-000000000000"),
+					},
+				},
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				TorMax:         4,
+				StateLoopTout:  10 * time.Second,
+				StateLoopDelay: 10 * time.Millisecond,
+			}
+
+			set := NewSet(cfg, nil, tc.given.tgs, nil, nil)
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			var n byte
+			set.tf = &mockTorCreator{
+				fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+					id := uuid.MustParse("c0c0b000-0000-4000-a000-000000000000")
+					id[15] = n
+					n++
+
+					return newTor(id, &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}), nil
+				},
+			}
+
+			actual, err := set.ScaleTors(context.Background(), tc.given.n)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.res, actual)
+			should.ElementsMatch(t, tc.exp.setIDs, set.tgs.Keys())
+		})
+	}
+}
+
+func TestSet_RotateTors(t *testing.T) {
+	type tcGiven struct {
+		maxAge    time.Duration
+		gt        *Tor
+		tf        *mockTorCreator
+		fnPrepSet func(set *Set)
+	}
+
+	type tcExpected struct {
+		ids    []uuid.UUID
+		setIDs []uuid.UUID
+		st     state
+		err    error
+	}
+
+	newOldTor := func(dev *torDev) *Tor {
+		result := newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), dev, &MockNetDialer{}, &MockHTTPDoer{})
+		result.createdAt = time.Date(2023, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+		return result
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "disabled",
+			given: tcGiven{
+				gt: newOldTor(&torDev{}),
+				tf: &mockTorCreator{},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				st:     stateReady,
+			},
+		},
+
+		{
+			name: "error_shutting",
+			given: tcGiven{
+				maxAge: time.Hour,
+				gt:     newOldTor(&torDev{}),
+				tf:     &mockTorCreator{},
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				st:     stateReady,
+				err:    ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "not_due",
+			given: tcGiven{
+				maxAge: 48 * time.Hour,
+				gt:     newOldTor(&torDev{}),
+				tf: &mockTorCreator{
+					fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+						panic("unexpected_new")
+					},
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				st:     stateReady,
+			},
+		},
+
+		{
+			name: "error_new",
+			given: tcGiven{
+				maxAge: time.Hour,
+				gt:     newOldTor(&torDev{}),
+				tf: &mockTorCreator{
+					fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				st:     stateReady,
+				err:    errors.Join(model.Error("something_went_wrong")),
+			},
+		},
+
+		{
+			name: "error_close",
+			given: tcGiven{
+				maxAge: time.Hour,
+				gt: newOldTor(&torDev{
+					fnClose: func() error {
+						return model.Error("something_went_wrong")
+					},
+				}),
+				tf: &mockTorCreator{},
+			},
+			exp: tcExpected{
+				ids:    []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				st:     stateClosed,
+				err:    errors.Join(model.Error("something_went_wrong")),
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				maxAge: time.Hour,
+				gt:     newOldTor(&torDev{}),
+				tf:     &mockTorCreator{},
+			},
+			exp: tcExpected{
+				ids:    []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				st:     stateClosed,
+			},
+		},
+		{
+			name: "success_chained",
+			given: tcGiven{
+				maxAge: time.Hour,
+				gt: func() *Tor {
+					result := newOldTor(&torDev{})
+					result.kind = KindTorWG
+					result.tf = &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							result := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+							result.kind = KindTorWG
+
+							return result, nil
+						},
+					}
+
+					return result
+				}(),
+				tf: &mockTorCreator{
+					fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+						panic("unexpected_new")
+					},
+				},
+			},
+			exp: tcExpected{
+				ids:    []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				st:     stateClosed,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				StateLoopTout:  10 * time.Second,
+				StateLoopDelay: 10 * time.Millisecond,
+				TorMaxAge:      tc.given.maxAge,
+			}
+
+			set := NewSet(cfg, nil, []*Tor{tc.given.gt}, nil, nil)
+			set.tf = tc.given.tf
+
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			actual, err := set.RotateTors(context.Background())
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.ids, actual)
+			should.Equal(t, tc.exp.setIDs, set.torsOf(tc.given.gt.Kind()).Keys())
+			should.Equal(t, tc.exp.st, tc.given.gt.getState())
+		})
+	}
+}
+
+func TestSet_CheckTors(t *testing.T) {
+	type tcGiven struct {
+		gt        *Tor
+		tf        *mockTorCreator
+		fnPrepSet func(set *Set)
+	}
+
+	type tcExpected struct {
+		ids    []uuid.UUID
+		setIDs []uuid.UUID
+		st     state
+		err    error
+	}
+
+	newLostTor := func() *Tor {
+		dev := &torDev{
+			fnCheck: func(ctx context.Context) error {
+				return model.Error("connection_lost")
+			},
+		}
+
+		return newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), dev, &MockNetDialer{}, &MockHTTPDoer{})
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_shutting",
+			given: tcGiven{
+				gt: newLostTor(),
+				tf: &mockTorCreator{},
+				fnPrepSet: func(set *Set) {
+					closeOrSkip(set.shutting)
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				st:     stateReady,
+				err:    ErrSetIsShutting,
+			},
+		},
+
+		{
+			name: "alive",
+			given: tcGiven{
+				gt: newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				tf: &mockTorCreator{
+					fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+						panic("unexpected_new")
+					},
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				st:     stateReady,
+			},
+		},
+
+		{
+			name: "alive_check_bounded",
+			given: tcGiven{
+				gt: newTor(uuid.MustParse("ad0be000-0000-4000-a000-000000000000"), &torDev{
+					fnCheck: func(ctx context.Context) error {
+						if _, ok := ctx.Deadline(); !ok {
+							return model.Error("unbounded_check")
+						}
+
+						return nil
+					},
+				}, &MockNetDialer{}, &MockHTTPDoer{}),
+				tf: &mockTorCreator{
+					fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+						panic("unexpected_new")
+					},
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				st:     stateReady,
+			},
+		},
+
+		{
+			name: "not_ready",
+			given: tcGiven{
+				gt: newLostTor(),
+				tf: &mockTorCreator{
+					fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+						panic("unexpected_new")
+					},
+				},
+				fnPrepSet: func(set *Set) {
+					for _, gt := range set.tgs.Values() {
+						gt.toState(stateMaintenance)
+					}
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				st:     stateMaintenance,
+			},
+		},
+
+		{
+			name: "error_new",
+			given: tcGiven{
+				gt: newLostTor(),
+				tf: &mockTorCreator{
+					fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+			},
+			exp: tcExpected{
+				setIDs: []uuid.UUID{uuid.MustParse("ad0be000-0000-4000-a000-000000000000")},
+				st:     stateReady,
+				err:    errors.Join(model.Error("something_went_wrong")),
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				gt: newLostTor(),
+				tf: &mockTorCreator{},
+			},
+			exp: tcExpected{
+				ids:    []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				st:     stateClosed,
+			},
+		},
+		{
+			name: "success_chained",
+			given: tcGiven{
+				gt: func() *Tor {
+					result := newLostTor()
+					result.kind = KindTorWG
+					result.tf = &mockTorCreator{
+						fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+							result := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+							result.kind = KindTorWG
+
+							return result, nil
+						},
+					}
+
+					return result
+				}(),
+				tf: &mockTorCreator{
+					fnNew: func(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
+						panic("unexpected_new")
+					},
+				},
+			},
+			exp: tcExpected{
+				ids:    []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				setIDs: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+				st:     stateClosed,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &SetConfig{
+				StateLoopTout:  10 * time.Second,
+				StateLoopDelay: 10 * time.Millisecond,
+			}
+
+			set := NewSet(cfg, nil, []*Tor{tc.given.gt}, nil, nil)
+			set.tf = tc.given.tf
+
+			if tc.given.fnPrepSet != nil {
+				tc.given.fnPrepSet(set)
+			}
+
+			actual, err := set.CheckTors(context.Background())
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.ids, actual)
+			should.Equal(t, tc.exp.setIDs, set.torsOf(tc.given.gt.Kind()).Keys())
+			should.Equal(t, tc.exp.st, tc.given.gt.getState())
+		})
+	}
+}
+
+func TestSet_isShutting(t *testing.T) {
+	tests := []testCase[*Set, bool]{
+		{
+			name:  "false_invalid",
+			given: &Set{},
+		},
+
+		{
+			name:  "false",
+			given: &Set{shutting: make(chan struct{})},
+		},
+
+		{
+			name: "true",
+			given: &Set{
+				shutting: func() chan struct{} {
+					out := make(chan struct{})
+					close(out)
+					return out
+				}(),
+			},
+			exp: true,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, tc.given.isShutting())
+		})
+	}
+}
+
+func TestSetConfig_BaseCtx(t *testing.T) {
+	tests := []testCase[*SetConfig, context.Context]{
+		{
+			name:  "default",
+			given: &SetConfig{},
+			exp:   context.Background(),
+		},
+
+		{
+			name:  "configured",
+			given: &SetConfig{FnBaseCtx: func() context.Context { return context.TODO() }},
+			exp:   context.TODO(),
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, tc.given.BaseCtx())
+		})
+	}
+}
+
+func TestSetConfig_jittered(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		cfg := &SetConfig{}
+		should.Equal(t, 10*time.Millisecond, cfg.jittered(10*time.Millisecond))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		cfg := &SetConfig{LoopJitter: 5 * time.Millisecond}
+
+		for i := 0; i < 100; i++ {
+			actual := cfg.jittered(10 * time.Millisecond)
+
+			should.GreaterOrEqual(t, actual, 10*time.Millisecond)
+			should.Less(t, actual, 15*time.Millisecond)
+		}
+	})
+}
+
+func TestTypedNetErr(t *testing.T) {
+	tests := []testCase[error, error]{
+		{
+			name: "nil",
+		},
+
+		{
+			name:  "other",
+			given: model.Error("something_went_wrong"),
+			exp:   model.Error("something_went_wrong"),
+		},
+
+		{
+			name:  "net_unreachable",
+			given: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)},
+			exp:   ErrNetUnreachable,
+		},
+
+		{
+			name:  "host_unreachable",
+			given: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)},
+			exp:   ErrNetUnreachable,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := typedNetErr(tc.given)
+
+			if tc.exp != ErrNetUnreachable {
+				should.Equal(t, tc.exp, actual)
+
+				return
+			}
+
+			should.ErrorIs(t, actual, ErrNetUnreachable)
+			should.ErrorIs(t, actual, tc.given)
+		})
+	}
+}
+
+func TestSetConfig_ConnectResponseOrDefault(t *testing.T) {
+	tests := []testCase[*SetConfig, string]{
+		{
+			name:  "default",
+			given: &SetConfig{},
+			exp:   "HTTP/1.1 200 Connection established\r\n\r\n",
+		},
+
+		{
+			name:  "configured",
+			given: &SetConfig{ConnectResponse: "HTTP/1.0 200 OK\r\n\r\n"},
+			exp:   "HTTP/1.0 200 OK\r\n\r\n",
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, tc.given.ConnectResponseOrDefault())
+		})
+	}
+}
+
+func TestValidateConnectResponse(t *testing.T) {
+	tests := []testCase[string, error]{
+		{
+			name:  "error_empty",
+			given: "",
+			exp:   ErrInvalidConnectResp,
+		},
+
+		{
+			name:  "error_no_empty_line",
+			given: "HTTP/1.1 200 OK\r\n",
+			exp:   ErrInvalidConnectResp,
+		},
+
+		{
+			name:  "error_proto",
+			given: "HTTP/2 200 OK\r\n\r\n",
+			exp:   ErrInvalidConnectResp,
+		},
+
+		{
+			name:  "error_code",
+			given: "HTTP/1.1 204 No Content\r\n\r\n",
+			exp:   ErrInvalidConnectResp,
+		},
+
+		{
+			name:  "valid_default",
+			given: DefConnectResponse,
+		},
+
+		{
+			name:  "valid_http10",
+			given: "HTTP/1.0 200 OK\r\n\r\n",
+		},
+
+		{
+			name:  "valid_no_reason",
+			given: "HTTP/1.1 200\r\n\r\n",
+		},
+
+		{
+			name:  "valid_with_header",
+			given: "HTTP/1.1 200 OK\r\nProxy-Agent: pumpe\r\n\r\n",
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, ValidateConnectResponse(tc.given))
+		})
+	}
+}
+
+func TestSetConfig_HTTPTimeoutFor(t *testing.T) {
+	type tcGiven struct {
+		cfg  *SetConfig
+		kind Kind
+	}
+
+	tests := []testCase[tcGiven, time.Duration]{
+		{
+			name: "default_tor",
+			given: tcGiven{
+				cfg:  &SetConfig{HTTPTimeout: 10 * time.Second},
+				kind: KindTor,
+			},
+			exp: 10 * time.Second,
+		},
+
+		{
+			name: "configured_tor",
+			given: tcGiven{
+				cfg:  &SetConfig{HTTPTimeout: 10 * time.Second, TorHTTPTimeout: 2 * time.Minute},
+				kind: KindTor,
+			},
+			exp: 2 * time.Minute,
+		},
+
+		{
+			name: "configured_wireguard",
+			given: tcGiven{
+				cfg:  &SetConfig{HTTPTimeout: 10 * time.Second, WGHTTPTimeout: 20 * time.Second},
+				kind: KindWireGuard,
+			},
+			exp: 20 * time.Second,
+		},
+
+		{
+			name: "configured_direct",
+			given: tcGiven{
+				cfg:  &SetConfig{HTTPTimeout: 10 * time.Second, DirectHTTPTimeout: 5 * time.Second},
+				kind: KindDirect,
+			},
+			exp: 5 * time.Second,
+		},
+
+		{
+			name: "unknown",
+			given: tcGiven{
+				cfg:  &SetConfig{HTTPTimeout: 10 * time.Second, TorHTTPTimeout: 2 * time.Minute},
+				kind: KindUnknown,
+			},
+			exp: 10 * time.Second,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, tc.given.cfg.HTTPTimeoutFor(tc.given.kind))
+		})
+	}
+}
+
+func TestNewDirectFrom(t *testing.T) {
+	type tcExpected struct {
+		id    uuid.UUID
+		laddr string
+	}
+
+	tests := []testCase[netip.Addr, tcExpected]{
+		{
+			name:  "ipv4",
+			given: netip.MustParseAddr("192.0.2.1"),
+			exp: tcExpected{
+				id:    uuid.NewSHA1(directID, []byte{192, 0, 2, 1}),
+				laddr: "192.0.2.1:0",
+			},
+		},
+
+		{
+			name:  "ipv6",
+			given: netip.MustParseAddr("2001:db8::1"),
+			exp: tcExpected{
+				id:    uuid.NewSHA1(directID, netip.MustParseAddr("2001:db8::1").AsSlice()),
+				laddr: "[2001:db8::1]:0",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := NewDirectFrom(tc.given, time.Second)
+
+			should.Equal(t, tc.exp.id, actual.ID())
+			should.NotEqual(t, directID, actual.ID())
+			should.Equal(t, KindDirect, actual.Kind())
+
+			netd, ok := actual.netd.(*net.Dialer)
+			must.Equal(t, true, ok)
+
+			should.Equal(t, tc.exp.laddr, netd.LocalAddr.String())
+			should.Equal(t, tc.given, actual.LocalAddr())
+		})
+	}
+}
+
+func TestDestControl(t *testing.T) {
+	type tcGiven struct {
+		check   func(ip netip.Addr) error
+		next    func(network, address string, c syscall.RawConn) error
+		address string
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "valid_no_check",
+			given: tcGiven{
+				address: "127.0.0.1:443",
+			},
+		},
+
+		{
+			name: "error_check",
+			given: tcGiven{
+				check: func(ip netip.Addr) error {
+					if ip != netip.MustParseAddr("127.0.0.1") {
+						return model.Error("unexpected_ip")
+					}
+
+					return model.Error("dest_not_allowed")
+				},
+				next: func(network, address string, c syscall.RawConn) error {
+					return model.Error("unexpected_next")
+				},
+				address: "127.0.0.1:443",
+			},
+			exp: model.Error("dest_not_allowed"),
+		},
+
+		{
+			name: "error_next",
+			given: tcGiven{
+				check: func(ip netip.Addr) error {
+					return nil
+				},
+				next: func(network, address string, c syscall.RawConn) error {
+					return model.Error("something_went_wrong")
+				},
+				address: "[2001:db8::1]:443",
+			},
+			exp: model.Error("something_went_wrong"),
+		},
+
+		{
+			name: "valid_checked",
+			given: tcGiven{
+				check: func(ip netip.Addr) error {
+					if ip != netip.MustParseAddr("2001:db8::1") {
+						return model.Error("unexpected_ip")
+					}
+
+					return nil
+				},
+				address: "[2001:db8::1]:443",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.given.check != nil {
+				ctx = WithDestCheck(ctx, tc.given.check)
+			}
+
+			fn := destControl(tc.given.next)
+
+			actual := fn(ctx, "tcp", tc.given.address, nil)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestShutdownList(t *testing.T) {
+	type tcGiven struct {
+		list  []*Tor
+		fnCtx func() context.Context
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "empty",
+			given: tcGiven{
+				list: []*Tor{},
+			},
+		},
+
+		{
+			name: "error_single_context_canceled",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev: &torDev{
+							fnClose: func() error {
+								tmr := time.NewTimer(20 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return model.Error("unexpected_close")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+				},
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: errors.Join(context.Canceled),
+		},
+
+		{
+			name: "error_single",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev: &torDev{
+							fnClose: func() error {
+								return model.Error("something_went_wrong")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+				},
+			},
+			exp: errors.Join(model.Error("something_went_wrong")),
+		},
+
+		{
+			name: "success_single",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer:       &MockHTTPDoer{},
+					},
+				},
+			},
+		},
+
+		{
+			name: "error_multiple_context_canceled",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev: &torDev{
+							fnClose: func() error {
+								tmr := time.NewTimer(20 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return model.Error("unexpected_close_01")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev: &torDev{
+							fnClose: func() error {
+								tmr := time.NewTimer(10 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return model.Error("unexpected_close_02")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+				},
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: errors.Join(context.Canceled, context.Canceled),
+		},
+
+		{
+			name: "error_multiple",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev: &torDev{
+							fnClose: func() error {
+								return model.Error("something_went_wrong")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev: &torDev{
+							fnClose: func() error {
+								return model.Error("something_went_wrong")
+							},
+						},
+						netd: &MockNetDialer{},
+						doer: &MockHTTPDoer{},
+					},
+				},
+			},
+			// The order of the errors is not important.
+			exp: errors.Join(model.Error("something_went_wrong"), model.Error("something_went_wrong")),
+		},
+
+		{
+			name: "success_multiple",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer:       &MockHTTPDoer{},
+					},
+
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer:       &MockHTTPDoer{},
+					},
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.given.fnCtx != nil {
+				ctx = tc.given.fnCtx()
+			}
+
+			actual := ShutdownList(ctx, tc.given.list)
+			must.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestWarmupList(t *testing.T) {
+	type tcGiven struct {
+		list  []*Tor
+		fnCtx func() context.Context
+	}
+
+	type tcExpected struct {
+		ids []uuid.UUID
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "empty",
+			given: tcGiven{
+				list: []*Tor{},
+			},
+		},
+
+		{
+			name: "error_single_context_canceled",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								tmr := time.NewTimer(20 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return nil, model.Error("unexpected_do")
+							},
+						},
+					},
+				},
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: tcExpected{
+				err: errors.Join(context.Canceled),
+			},
+		},
+
+		{
+			name: "error_single",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								return nil, model.Error("something_went_wrong")
+							},
+						},
+					},
+				},
+			},
+			exp: tcExpected{
+				err: errors.Join(model.Error("something_went_wrong")),
+			},
+		},
+
+		{
+			name: "success_single",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer:       &MockHTTPDoer{},
+					},
+				},
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{uuid.MustParse("facade00-0000-4000-a000-000000000000")},
+			},
+		},
+
+		{
+			name: "error_multiple_context_canceled",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								tmr := time.NewTimer(20 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
+								}()
+
+								<-tmr.C
+
+								return nil, model.Error("unexpected_do_01")
+							},
+						},
+					},
+
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								tmr := time.NewTimer(15 * time.Second)
+								defer func() {
+									if !tmr.Stop() {
+										<-tmr.C
+									}
 								}()
 
-								<-tmr.C
+								<-tmr.C
+
+								return nil, model.Error("unexpected_do_02")
+							},
+						},
+					},
+				},
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: tcExpected{
+				err: errors.Join(context.Canceled, context.Canceled),
+			},
+		},
+
+		{
+			name: "error_multiple",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								return nil, model.Error("something_went_wrong")
+							},
+						},
+					},
+
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer: &MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								return nil, model.Error("something_went_wrong")
+							},
+						},
+					},
+				},
+			},
+			// The order of the errors is not important.
+			exp: tcExpected{
+				err: errors.Join(model.Error("something_went_wrong"), model.Error("something_went_wrong")),
+			},
+		},
+
+		{
+			name: "success_multiple",
+			given: tcGiven{
+				list: []*Tor{
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer:       &MockHTTPDoer{},
+					},
+
+					{
+						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+						refreshing: &struct{ value uint32 }{},
+						dev:        &torDev{},
+						netd:       &MockNetDialer{},
+						doer:       &MockHTTPDoer{},
+					},
+				},
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{
+					uuid.MustParse("decade00-0000-4000-a000-000000000000"),
+					uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.given.fnCtx != nil {
+				ctx = tc.given.fnCtx()
+			}
+
+			actual, err := WarmupList(ctx, tc.given.list)
+			must.Equal(t, tc.exp.err, err)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, len(tc.exp.ids), len(actual))
+
+			for j := range tc.exp.ids {
+				lat, ok := actual[tc.exp.ids[j]]
+				should.Equal(t, true, ok)
+				should.Equal(t, true, lat > 0)
+			}
+		})
+	}
+}
+
+func TestSlowestIDs(t *testing.T) {
+	type tcGiven struct {
+		lats map[uuid.UUID]time.Duration
+		n    int
+	}
+
+	tests := []testCase[tcGiven, []uuid.UUID]{
+		{
+			name: "empty",
+			given: tcGiven{
+				n: 3,
+			},
+			exp: []uuid.UUID{},
+		},
+
+		{
+			name: "fewer_than_n",
+			given: tcGiven{
+				lats: map[uuid.UUID]time.Duration{
+					uuid.MustParse("facade00-0000-4000-a000-000000000000"): time.Second,
+					uuid.MustParse("decade00-0000-4000-a000-000000000000"): 2 * time.Second,
+				},
+				n: 3,
+			},
+			exp: []uuid.UUID{
+				uuid.MustParse("decade00-0000-4000-a000-000000000000"),
+				uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+			},
+		},
+
+		{
+			name: "limited_ties_by_id",
+			given: tcGiven{
+				lats: map[uuid.UUID]time.Duration{
+					uuid.MustParse("facade00-0000-4000-a000-000000000000"): time.Second,
+					uuid.MustParse("decade00-0000-4000-a000-000000000000"): time.Second,
+					uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"): 3 * time.Second,
+					uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"): time.Millisecond,
+				},
+				n: 2,
+			},
+			exp: []uuid.UUID{
+				uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				uuid.MustParse("decade00-0000-4000-a000-000000000000"),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := slowestIDs(tc.given.lats, tc.given.n)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestRefreshOne(t *testing.T) {
+	type tcGiven struct {
+		tor   *Tor
+		fnCtx func() context.Context
+	}
+
+	type tcExpected struct {
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_context_canceled",
+			given: tcGiven{
+				tor: &Tor{
+					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+					refreshing: &struct{ value uint32 }{},
+					dev: &torDev{
+						fnSignal: func(s string) error {
+							tmr := time.NewTimer(20 * time.Second)
+							defer func() {
+								if !tmr.Stop() {
+									<-tmr.C
+								}
+							}()
+
+							<-tmr.C
+
+							return model.Error("unexpected_signal")
+						},
+					},
+					netd: &MockNetDialer{},
+					doer: &MockHTTPDoer{},
+				},
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: tcExpected{
+				err: context.Canceled,
+			},
+		},
+
+		{
+			name: "error_refresh",
+			given: tcGiven{
+				tor: &Tor{
+					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+					refreshing: &struct{ value uint32 }{},
+					dev: &torDev{
+						fnSignal: func(s string) error {
+							return model.Error("something_went_wrong")
+						},
+					},
+					netd: &MockNetDialer{},
+					doer: &MockHTTPDoer{},
+				},
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				tor: &Tor{
+					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+					refreshing: &struct{ value uint32 }{},
+					dev:        &torDev{},
+					netd:       &MockNetDialer{},
+					doer:       &MockHTTPDoer{},
+				},
+			},
+			exp: tcExpected{},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.given.fnCtx != nil {
+				ctx = tc.given.fnCtx()
+			}
+
+			actual := refreshOne(ctx, tc.given.tor)
+			must.Equal(t, tc.exp.err, actual)
+		})
+	}
+}
+
+func TestShutdownOne(t *testing.T) {
+	type tcGiven struct {
+		gate  interface{ close() error }
+		fnCtx func() context.Context
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "error_context_canceled",
+			given: tcGiven{
+				gate: &Tor{
+					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+					refreshing: &struct{ value uint32 }{},
+					dev: &torDev{
+						fnClose: func() error {
+							tmr := time.NewTimer(20 * time.Second)
+							defer func() {
+								if !tmr.Stop() {
+									<-tmr.C
+								}
+							}()
+
+							<-tmr.C
+
+							return model.Error("unexpected_close")
+						},
+					},
+					netd: &MockNetDialer{},
+					doer: &MockHTTPDoer{},
+				},
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+			},
+			exp: context.Canceled,
+		},
+
+		{
+			name: "error_close",
+			given: tcGiven{
+				gate: &Tor{
+					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+					refreshing: &struct{ value uint32 }{},
+					dev: &torDev{
+						fnClose: func() error {
+							return model.Error("something_went_wrong")
+						},
+					},
+					netd: &MockNetDialer{},
+					doer: &MockHTTPDoer{},
+				},
+			},
+			exp: model.Error("something_went_wrong"),
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				gate: &Tor{
+					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+					refreshing: &struct{ value uint32 }{},
+					dev:        &torDev{},
+					netd:       &MockNetDialer{},
+					doer:       &MockHTTPDoer{},
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.given.fnCtx != nil {
+				ctx = tc.given.fnCtx()
+			}
+
+			actual := shutdownOne(ctx, tc.given.gate)
+			must.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestWarmupOne(t *testing.T) {
+	type tcGiven struct {
+		gate interface {
+			warmup(context.Context) (time.Duration, error)
+		}
+		fnCtx func() context.Context
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "error_context_canceled",
+			given: tcGiven{
+				gate: &Tor{
+					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+					refreshing: &struct{ value uint32 }{},
+					dev:        &torDev{},
+					netd:       &MockNetDialer{},
+					doer: &MockHTTPDoer{
+						FnDo: func(r *http.Request) (*http.Response, error) {
+							tmr := time.NewTimer(20 * time.Second)
+							defer func() {
+								if !tmr.Stop() {
+									<-tmr.C
+								}
+							}()
+
+							<-tmr.C
 
-								return model.Error("unexpected_close_02")
-							},
+							return nil, model.Error("unexpected_do")
 						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
 					},
 				},
 				fnCtx: func() context.Context {
@@ -1959,61 +6681,36 @@ func TestShutdownList(t *testing.T) {
 					return ctx
 				},
 			},
-			exp: errors.Join(context.Canceled, context.Canceled),
+			exp: context.Canceled,
 		},
 
 		{
-			name: "error_multiple",
+			name: "error_do",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev: &torDev{
-							fnClose: func() error {
-								return model.Error("something_went_wrong")
-							},
-						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
-					},
-
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev: &torDev{
-							fnClose: func() error {
-								return model.Error("something_went_wrong")
-							},
+				gate: &Tor{
+					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+					refreshing: &struct{ value uint32 }{},
+					dev:        &torDev{},
+					netd:       &MockNetDialer{},
+					doer: &MockHTTPDoer{
+						FnDo: func(r *http.Request) (*http.Response, error) {
+							return nil, model.Error("something_went_wrong")
 						},
-						netd: &MockNetDialer{},
-						doer: &MockHTTPDoer{},
 					},
 				},
 			},
-			// The order of the errors is not important.
-			exp: errors.Join(model.Error("something_went_wrong"), model.Error("something_went_wrong")),
+			exp: model.Error("something_went_wrong"),
 		},
 
 		{
-			name: "success_multiple",
+			name: "success",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer:       &MockHTTPDoer{},
-					},
-
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer:       &MockHTTPDoer{},
-					},
+				gate: &Tor{
+					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+					refreshing: &struct{ value uint32 }{},
+					dev:        &torDev{},
+					netd:       &MockNetDialer{},
+					doer:       &MockHTTPDoer{},
 				},
 			},
 		},
@@ -2028,224 +6725,138 @@ func TestShutdownList(t *testing.T) {
 				ctx = tc.given.fnCtx()
 			}
 
-			actual := ShutdownList(ctx, tc.given.list)
-			must.Equal(t, tc.exp, actual)
+			actual := warmupOne(ctx, tc.given.gate)
+			must.Equal(t, tc.exp, actual.err)
+
+			if tc.exp != nil {
+				return
+			}
+
+			should.Equal(t, true, actual.latency > 0)
 		})
 	}
 }
 
-func TestWarmupList(t *testing.T) {
+func TestRecordingWarmuper(t *testing.T) {
 	type tcGiven struct {
-		list  []*Tor
-		fnCtx func() context.Context
+		prev error
+		fnDo func(r *http.Request) (*http.Response, error)
 	}
 
 	type tcExpected struct {
-		n   int
+		msg string
 		err error
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "empty",
-			given: tcGiven{
-				list: []*Tor{},
-			},
-		},
-
-		{
-			name: "error_single_context_canceled",
+			name: "error",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								tmr := time.NewTimer(20 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
-								}()
-
-								<-tmr.C
-
-								return nil, model.Error("unexpected_do")
-							},
-						},
-					},
-				},
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
-
-					return ctx
+				fnDo: func(r *http.Request) (*http.Response, error) {
+					return nil, model.Error("something_went_wrong")
 				},
 			},
 			exp: tcExpected{
-				err: errors.Join(context.Canceled),
+				msg: "something_went_wrong",
+				err: model.Error("something_went_wrong"),
 			},
 		},
 
 		{
-			name: "error_single",
+			name: "success_clears_previous",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								return nil, model.Error("something_went_wrong")
-							},
-						},
-					},
+				prev: model.Error("something_went_wrong"),
+				fnDo: func(r *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
 				},
 			},
-			exp: tcExpected{
-				err: errors.Join(model.Error("something_went_wrong")),
-			},
 		},
+	}
 
-		{
-			name: "success_single",
-			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer:       &MockHTTPDoer{},
-					},
-				},
-			},
-			exp: tcExpected{
-				n: 1,
-			},
-		},
+	for i := range tests {
+		tc := tests[i]
 
-		{
-			name: "error_multiple_context_canceled",
-			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								tmr := time.NewTimer(20 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
-								}()
+		t.Run(tc.name, func(t *testing.T) {
+			gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{FnDo: tc.given.fnDo})
+			gt.setWarmup(0, tc.given.prev)
 
-								<-tmr.C
+			wmr := (&SetConfig{}).warmuperFor(gt)
 
-								return nil, model.Error("unexpected_do_01")
-							},
-						},
-					},
+			_, err := wmr.warmup(context.Background())
+			must.Equal(t, tc.exp.err, err)
 
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								tmr := time.NewTimer(15 * time.Second)
-								defer func() {
-									if !tmr.Stop() {
-										<-tmr.C
-									}
-								}()
+			should.Equal(t, tc.exp.msg, gt.warmupErr())
+			should.Equal(t, tc.exp.err == nil, gt.warmupLatency() > 0)
+		})
+	}
+}
 
-								<-tmr.C
+func TestRetryingWarmuper(t *testing.T) {
+	type tcGiven struct {
+		n        int
+		delay    time.Duration
+		failures int
+		fnCtx    func() context.Context
+	}
 
-								return nil, model.Error("unexpected_do_02")
-							},
-						},
-					},
-				},
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
+	type tcExpected struct {
+		attempts int
+		err      error
+	}
 
-					return ctx
-				},
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_no_retries",
+			given: tcGiven{
+				failures: 1,
 			},
 			exp: tcExpected{
-				err: errors.Join(context.Canceled, context.Canceled),
+				attempts: 1,
+				err:      model.Error("attempt_1"),
 			},
 		},
 
 		{
-			name: "error_multiple",
+			name: "error_all_attempts",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								return nil, model.Error("something_went_wrong")
-							},
-						},
-					},
+				n:        2,
+				delay:    time.Millisecond,
+				failures: 3,
+			},
+			exp: tcExpected{
+				attempts: 3,
+				err:      model.Error("attempt_3"),
+			},
+		},
 
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer: &MockHTTPDoer{
-							FnDo: func(r *http.Request) (*http.Response, error) {
-								return nil, model.Error("something_went_wrong")
-							},
-						},
-					},
+		{
+			name: "error_context_canceled",
+			given: tcGiven{
+				n:        2,
+				delay:    20 * time.Second,
+				failures: 3,
+				fnCtx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
 				},
 			},
-			// The order of the errors is not important.
 			exp: tcExpected{
-				err: errors.Join(model.Error("something_went_wrong"), model.Error("something_went_wrong")),
+				attempts: 1,
+				err:      context.Canceled,
 			},
 		},
 
 		{
-			name: "success_multiple",
+			name: "success_after_retry",
 			given: tcGiven{
-				list: []*Tor{
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer:       &MockHTTPDoer{},
-					},
-
-					{
-						baseGate:   newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
-						refreshing: &struct{ value uint32 }{},
-						dev:        &torDev{},
-						netd:       &MockNetDialer{},
-						doer:       &MockHTTPDoer{},
-					},
-				},
+				n:        2,
+				delay:    time.Millisecond,
+				failures: 1,
 			},
 			exp: tcExpected{
-				n: 2,
+				attempts: 2,
 			},
 		},
 	}
@@ -2254,105 +6865,144 @@ func TestWarmupList(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
+			var attempts int
+
+			fn := func(ctx context.Context, gt ExitGate) (time.Duration, error) {
+				attempts++
+
+				if attempts <= tc.given.failures {
+					return 0, model.Error(fmt.Sprintf("attempt_%d", attempts))
+				}
+
+				return time.Millisecond, nil
+			}
+
+			gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+
+			wmr := &retryingWarmuper{wmr: &funcWarmuper{gt: gt, fn: fn}, n: tc.given.n, delay: tc.given.delay}
+
 			ctx := context.Background()
 			if tc.given.fnCtx != nil {
 				ctx = tc.given.fnCtx()
 			}
 
-			actual, err := WarmupList(ctx, tc.given.list)
+			_, err := wmr.warmup(ctx)
 			must.Equal(t, tc.exp.err, err)
 
-			if tc.exp.err != nil {
-				return
-			}
-
-			should.Equal(t, tc.exp.n, len(actual))
-
-			for j := range actual {
-				should.Equal(t, true, actual[j] > 0)
-			}
+			should.Equal(t, tc.exp.attempts, attempts)
 		})
 	}
 }
 
-func TestRefreshOne(t *testing.T) {
-	type tcGiven struct {
-		tor   *Tor
-		fnCtx func() context.Context
-	}
-
+func TestWarmupDial(t *testing.T) {
 	type tcExpected struct {
-		err error
+		closed bool
+		err    error
 	}
 
-	tests := []testCase[tcGiven, tcExpected]{
+	tests := []testCase[*MockNetDialer, tcExpected]{
 		{
-			name: "error_context_canceled",
-			given: tcGiven{
-				tor: &Tor{
-					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-					refreshing: &struct{ value uint32 }{},
-					dev: &torDev{
-						fnSignal: func(s string) error {
-							tmr := time.NewTimer(20 * time.Second)
-							defer func() {
-								if !tmr.Stop() {
-									<-tmr.C
-								}
-							}()
+			name: "error_dial",
+			given: &MockNetDialer{
+				FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return nil, model.Error("something_went_wrong")
+				},
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
 
-							<-tmr.C
+		{
+			name: "success",
+			exp: tcExpected{
+				closed: true,
+			},
+		},
+	}
 
-							return model.Error("unexpected_signal")
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				closed  bool
+				network string
+				addr    string
+			)
+
+			netd := &MockNetDialer{
+				FnDialContext: func(ctx context.Context, nw, a string) (net.Conn, error) {
+					network, addr = nw, a
+
+					if tc.given != nil {
+						return tc.given.DialContext(ctx, nw, a)
+					}
+
+					conn := &fakenet.MockConn{
+						FnClose: func() error {
+							closed = true
+
+							return nil
 						},
-					},
-					netd: &MockNetDialer{},
-					doer: &MockHTTPDoer{},
-				},
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
+					}
 
-					return ctx
+					return conn, nil
 				},
+			}
+
+			gt := newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, netd, &MockHTTPDoer{})
+
+			_, err := WarmupDial("10.0.0.1:443")(context.Background(), gt)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, "tcp", network)
+			should.Equal(t, "10.0.0.1:443", addr)
+			should.Equal(t, tc.exp.closed, closed)
+		})
+	}
+}
+
+func TestWarmupGet(t *testing.T) {
+	type tcExpected struct {
+		urls []string
+		err  error
+	}
+
+	tests := []testCase[map[string]int, tcExpected]{
+		{
+			name: "error_all_failed",
+			given: map[string]int{
+				"http://10.0.0.1/health": http.StatusInternalServerError,
 			},
 			exp: tcExpected{
-				err: context.Canceled,
+				urls: []string{"http://10.0.0.1/health", "http://10.0.0.2/health"},
+				err: errors.Join(
+					fmt.Errorf("%s: %w", "http://10.0.0.1/health", ErrWarmupBadResponse),
+					fmt.Errorf("%s: %w", "http://10.0.0.2/health", model.Error("something_went_wrong")),
+				),
 			},
 		},
 
 		{
-			name: "error_refresh",
-			given: tcGiven{
-				tor: &Tor{
-					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-					refreshing: &struct{ value uint32 }{},
-					dev: &torDev{
-						fnSignal: func(s string) error {
-							return model.Error("something_went_wrong")
-						},
-					},
-					netd: &MockNetDialer{},
-					doer: &MockHTTPDoer{},
-				},
+			name: "success_first",
+			given: map[string]int{
+				"http://10.0.0.1/health": http.StatusNoContent,
 			},
 			exp: tcExpected{
-				err: model.Error("something_went_wrong"),
+				urls: []string{"http://10.0.0.1/health"},
 			},
 		},
 
 		{
-			name: "success",
-			given: tcGiven{
-				tor: &Tor{
-					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-					refreshing: &struct{ value uint32 }{},
-					dev:        &torDev{},
-					netd:       &MockNetDialer{},
-					doer:       &MockHTTPDoer{},
-				},
+			name: "success_second",
+			given: map[string]int{
+				"http://10.0.0.1/health": http.StatusServiceUnavailable,
+				"http://10.0.0.2/health": http.StatusOK,
+			},
+			exp: tcExpected{
+				urls: []string{"http://10.0.0.1/health", "http://10.0.0.2/health"},
 			},
-			exp: tcExpected{},
 		},
 	}
 
@@ -2360,86 +7010,73 @@ func TestRefreshOne(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			ctx := context.Background()
-			if tc.given.fnCtx != nil {
-				ctx = tc.given.fnCtx()
+			var urls []string
+
+			doer := &MockHTTPDoer{
+				FnDo: func(r *http.Request) (*http.Response, error) {
+					urls = append(urls, r.URL.String())
+
+					code, ok := tc.given[r.URL.String()]
+					if !ok {
+						return nil, model.Error("something_went_wrong")
+					}
+
+					result := NewMockResponse()
+					result.StatusCode = code
+
+					return result, nil
+				},
 			}
 
-			actual := refreshOne(ctx, tc.given.tor)
-			must.Equal(t, tc.exp.err, actual)
+			gt := newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, doer)
+
+			actual, err := WarmupGet("http://10.0.0.1/health", "http://10.0.0.2/health")(context.Background(), gt)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.urls, urls)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, true, actual > 0)
 		})
 	}
 }
 
-func TestShutdownOne(t *testing.T) {
-	type tcGiven struct {
-		gate  interface{ close() error }
-		fnCtx func() context.Context
-	}
+func TestSetConfig_warmuperFor(t *testing.T) {
+	errDo := model.Error("unexpected_do")
 
-	tests := []testCase[tcGiven, error]{
+	tests := []testCase[*SetConfig, error]{
 		{
-			name: "error_context_canceled",
-			given: tcGiven{
-				gate: &Tor{
-					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-					refreshing: &struct{ value uint32 }{},
-					dev: &torDev{
-						fnClose: func() error {
-							tmr := time.NewTimer(20 * time.Second)
-							defer func() {
-								if !tmr.Stop() {
-									<-tmr.C
-								}
-							}()
-
-							<-tmr.C
-
-							return model.Error("unexpected_close")
-						},
-					},
-					netd: &MockNetDialer{},
-					doer: &MockHTTPDoer{},
-				},
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
+			name:  "http",
+			given: &SetConfig{},
+			exp:   errDo,
+		},
 
-					return ctx
-				},
-			},
-			exp: context.Canceled,
+		{
+			name:  "tcp",
+			given: &SetConfig{WarmupMode: WarmupModeTCP, WarmupAddr: "10.0.0.1:443"},
 		},
 
 		{
-			name: "error_close",
-			given: tcGiven{
-				gate: &Tor{
-					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-					refreshing: &struct{ value uint32 }{},
-					dev: &torDev{
-						fnClose: func() error {
-							return model.Error("something_went_wrong")
-						},
-					},
-					netd: &MockNetDialer{},
-					doer: &MockHTTPDoer{},
-				},
-			},
-			exp: model.Error("something_went_wrong"),
+			name:  "urls",
+			given: &SetConfig{WarmupURLs: []string{"http://10.0.0.1/health"}},
+			exp:   errors.Join(fmt.Errorf("%s: %w", "http://10.0.0.1/health", errDo)),
 		},
 
 		{
-			name: "success",
-			given: tcGiven{
-				gate: &Tor{
-					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-					refreshing: &struct{ value uint32 }{},
-					dev:        &torDev{},
-					netd:       &MockNetDialer{},
-					doer:       &MockHTTPDoer{},
+			name: "custom_over_tcp",
+			given: &SetConfig{
+				WarmupMode: WarmupModeTCP,
+				WarmupAddr: "10.0.0.1:443",
+				Warmups: map[Kind]WarmupFunc{
+					KindTor: func(ctx context.Context, gt ExitGate) (time.Duration, error) {
+						return 0, ErrWarmupNotTor
+					},
 				},
 			},
+			exp: ErrWarmupNotTor,
 		},
 	}
 
@@ -2447,87 +7084,107 @@ func TestShutdownOne(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			ctx := context.Background()
-			if tc.given.fnCtx != nil {
-				ctx = tc.given.fnCtx()
+			doer := &MockHTTPDoer{
+				FnDo: func(r *http.Request) (*http.Response, error) {
+					return nil, errDo
+				},
 			}
 
-			actual := shutdownOne(ctx, tc.given.gate)
-			must.Equal(t, tc.exp, actual)
+			gt := newTor(uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, doer)
+
+			_, err := tc.given.warmuperFor(gt).warmup(context.Background())
+			must.Equal(t, tc.exp, err)
 		})
 	}
 }
 
-func TestWarmupOne(t *testing.T) {
-	type tcGiven struct {
-		gate interface {
-			warmup(context.Context) (time.Duration, error)
-		}
-		fnCtx func() context.Context
+func TestNewHTTPClient(t *testing.T) {
+	var gzbody bytes.Buffer
+	{
+		zw := gzip.NewWriter(&gzbody)
+		_, err := zw.Write([]byte("My name is Bane."))
+		must.Equal(t, nil, err)
+		must.Equal(t, nil, zw.Close())
+	}
+
+	type tcExpected struct {
+		accEnc string
+	}
+
+	tests := []testCase[string, tcExpected]{
+		{
+			name: "no_accept_encoding",
+		},
+
+		{
+			name:  "accept_encoding",
+			given: "gzip",
+			exp: tcExpected{
+				accEnc: "gzip",
+			},
+		},
 	}
 
-	tests := []testCase[tcGiven, error]{
-		{
-			name: "error_context_canceled",
-			given: tcGiven{
-				gate: &Tor{
-					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-					refreshing: &struct{ value uint32 }{},
-					dev:        &torDev{},
-					netd:       &MockNetDialer{},
-					doer: &MockHTTPDoer{
-						FnDo: func(r *http.Request) (*http.Response, error) {
-							tmr := time.NewTimer(20 * time.Second)
-							defer func() {
-								if !tmr.Stop() {
-									<-tmr.C
-								}
-							}()
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			accEncc := make(chan string, 1)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accEncc <- r.Header.Get("Accept-Encoding")
+
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write(gzbody.Bytes())
+			}))
+			defer srv.Close()
+
+			netd := &net.Dialer{Timeout: time.Second}
+			client := newHTTPClient(time.Second, netd.DialContext)
+
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			must.Equal(t, nil, err)
+
+			if tc.given != "" {
+				req.Header.Set("Accept-Encoding", tc.given)
+			}
+
+			resp, err := client.Do(req)
+			must.Equal(t, nil, err)
+
+			defer func() { _ = resp.Body.Close() }()
 
-							<-tmr.C
+			actual, err := io.ReadAll(resp.Body)
+			must.Equal(t, nil, err)
 
-							return nil, model.Error("unexpected_do")
-						},
-					},
-				},
-				fnCtx: func() context.Context {
-					ctx, cancel := context.WithCancel(context.Background())
-					cancel()
+			should.Equal(t, tc.exp.accEnc, <-accEncc)
+			should.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+			should.Equal(t, false, resp.Uncompressed)
+			should.Equal(t, gzbody.Bytes(), actual)
+		})
+	}
+}
 
-					return ctx
-				},
-			},
-			exp: context.Canceled,
-		},
+func TestNewHTTPClient_expectContinue(t *testing.T) {
+	type tcExpected struct {
+		code int
+		read bool
+	}
 
+	tests := []testCase[bool, tcExpected]{
 		{
-			name: "error_do",
-			given: tcGiven{
-				gate: &Tor{
-					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-					refreshing: &struct{ value uint32 }{},
-					dev:        &torDev{},
-					netd:       &MockNetDialer{},
-					doer: &MockHTTPDoer{
-						FnDo: func(r *http.Request) (*http.Response, error) {
-							return nil, model.Error("something_went_wrong")
-						},
-					},
-				},
+			name: "rejected",
+			exp: tcExpected{
+				code: http.StatusExpectationFailed,
 			},
-			exp: model.Error("something_went_wrong"),
 		},
 
 		{
-			name: "success",
-			given: tcGiven{
-				gate: &Tor{
-					baseGate:   newBaseGateID(KindTor, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
-					refreshing: &struct{ value uint32 }{},
-					dev:        &torDev{},
-					netd:       &MockNetDialer{},
-					doer:       &MockHTTPDoer{},
-				},
+			name:  "accepted",
+			given: true,
+			exp: tcExpected{
+				code: http.StatusOK,
+				read: true,
 			},
 		},
 	}
@@ -2536,23 +7193,55 @@ func TestWarmupOne(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			ctx := context.Background()
-			if tc.given.fnCtx != nil {
-				ctx = tc.given.fnCtx()
-			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tc.given {
+					// Give the client time to send the body, had it not waited.
+					time.Sleep(100 * time.Millisecond)
 
-			actual := warmupOne(ctx, tc.given.gate)
-			must.Equal(t, tc.exp, actual.err)
+					w.WriteHeader(http.StatusExpectationFailed)
+					return
+				}
 
-			if tc.exp != nil {
-				return
-			}
+				_, _ = io.Copy(io.Discard, r.Body)
+			}))
+			defer srv.Close()
 
-			should.Equal(t, true, actual.latency > 0)
+			netd := &net.Dialer{Timeout: time.Second}
+			client := newHTTPClient(5*time.Second, netd.DialContext)
+
+			read := &atomic.Bool{}
+			body := &flagReader{Reader: strings.NewReader("Peace is a lie."), read: read}
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL, body)
+			must.Equal(t, nil, err)
+
+			req.ContentLength = 15
+			req.Header.Set("Expect", "100-continue")
+
+			resp, err := client.Do(req)
+			must.Equal(t, nil, err)
+
+			_ = resp.Body.Close()
+
+			should.Equal(t, tc.exp.code, resp.StatusCode)
+			should.Equal(t, tc.exp.read, read.Load())
 		})
 	}
 }
 
+// flagReader records whether it has been read from.
+type flagReader struct {
+	io.Reader
+
+	read *atomic.Bool
+}
+
+func (r *flagReader) Read(p []byte) (int, error) {
+	r.read.Store(true)
+
+	return r.Reader.Read(p)
+}
+
 func TestWarmupDoer(t *testing.T) {
 	type tcGiven struct {
 		doer  httpDoer
@@ -2641,6 +7330,14 @@ func TestBaseGate(t *testing.T) {
 		should.Equal(t, uuid.MustParse("decade00-0000-4000-a000-000000000000"), gt.ID())
 	})
 
+	t.Run("created_at", func(t *testing.T) {
+		should.Equal(t, time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), gt.CreatedAt())
+	})
+
+	t.Run("last_used", func(t *testing.T) {
+		should.Equal(t, time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), gt.LastUsed())
+	})
+
 	t.Run("add_req", func(t *testing.T) {
 		gt.AddReq()
 		should.Equal(t, uint64(1), gt.state.reqNum())
@@ -2760,6 +7457,141 @@ func TestBaseGate_toState(t *testing.T) {
 	}
 }
 
+func TestBaseGate_toStateErr(t *testing.T) {
+	type tcGiven struct {
+		gt *baseGate
+		st state
+	}
+
+	type tcExpected struct {
+		st  state
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "ready_to_ready",
+			given: tcGiven{
+				gt: newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+				st: stateReady,
+			},
+			exp: tcExpected{st: stateReady},
+		},
+
+		{
+			name: "ready_to_maint",
+			given: tcGiven{
+				gt: newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+				st: stateMaintenance,
+			},
+			exp: tcExpected{st: stateMaintenance},
+		},
+
+		{
+			name: "maint_to_closed",
+			given: tcGiven{
+				gt: func() *baseGate {
+					gt := newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000"))
+					gt.state.toMaint()
+
+					return gt
+				}(),
+				st: stateClosed,
+			},
+			exp: tcExpected{st: stateClosed},
+		},
+
+		{
+			name: "closed_to_closed",
+			given: tcGiven{
+				gt: func() *baseGate {
+					gt := newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000"))
+					gt.state.toClosed()
+
+					return gt
+				}(),
+				st: stateClosed,
+			},
+			exp: tcExpected{st: stateClosed},
+		},
+
+		{
+			name: "error_closed_to_ready",
+			given: tcGiven{
+				gt: func() *baseGate {
+					gt := newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000"))
+					gt.state.toClosed()
+
+					return gt
+				}(),
+				st: stateReady,
+			},
+			exp: tcExpected{st: stateClosed, err: ErrIllegalStateTransition},
+		},
+
+		{
+			name: "error_closed_to_maint",
+			given: tcGiven{
+				gt: func() *baseGate {
+					gt := newBaseGateID(KindTor, uuid.MustParse("decade00-0000-4000-a000-000000000000"))
+					gt.state.toClosed()
+
+					return gt
+				}(),
+				st: stateMaintenance,
+			},
+			exp: tcExpected{st: stateClosed, err: ErrIllegalStateTransition},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.given.gt.toStateErr(tc.given.st)
+			must.Equal(t, tc.exp.err, actual)
+
+			should.Equal(t, tc.exp.st, tc.given.gt.getState())
+		})
+	}
+}
+
+func TestState_String(t *testing.T) {
+	tests := []testCase[state, string]{
+		{
+			name:  "ready",
+			given: stateReady,
+			exp:   "ready",
+		},
+
+		{
+			name:  "maintenance",
+			given: stateMaintenance,
+			exp:   "maintenance",
+		},
+
+		{
+			name:  "closed",
+			given: stateClosed,
+			exp:   "closed",
+		},
+
+		{
+			name:  "unknown",
+			given: state(42),
+			exp:   "unknown",
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, tc.given.String())
+		})
+	}
+}
+
 func TestGateState(t *testing.T) {
 	t.Run("states", func(t *testing.T) {
 		st := &gateState{
@@ -2788,6 +7620,27 @@ func TestGateState(t *testing.T) {
 		})
 	})
 
+	t.Run("idle_for", func(t *testing.T) {
+		st := newGateState()
+		now := timeNow().Add(time.Hour)
+
+		idle, ok := st.idleFor(now)
+		should.Equal(t, true, ok)
+		should.Equal(t, time.Hour, idle)
+
+		st.addReq()
+
+		idle, ok = st.idleFor(now)
+		should.Equal(t, false, ok)
+		should.Equal(t, time.Duration(0), idle)
+
+		st.didReq()
+
+		idle, ok = st.idleFor(now)
+		should.Equal(t, true, ok)
+		should.Equal(t, time.Hour, idle)
+	})
+
 	t.Run("counters", func(t *testing.T) {
 		st := newGateState()
 
@@ -2832,6 +7685,23 @@ func TestGateState(t *testing.T) {
 			should.Equal(t, stateClosed, st.getState())
 		})
 	})
+
+	t.Run("op_lock", func(t *testing.T) {
+		st := newGateState()
+
+		t.Run("lock", func(t *testing.T) {
+			should.Equal(t, true, st.lockOp())
+		})
+
+		t.Run("lock_busy", func(t *testing.T) {
+			should.Equal(t, false, st.lockOp())
+		})
+
+		t.Run("unlock", func(t *testing.T) {
+			st.unlockOp()
+			should.Equal(t, true, st.lockOp())
+		})
+	})
 }
 
 func TestCloseOrSkip(t *testing.T) {
@@ -2990,7 +7860,85 @@ func TestPickRandomKind(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			should.Equal(t, tc.exp, pickRandomKind(tc.given))
+			should.Equal(t, tc.exp, pickRandomKind(nil, tc.given))
+		})
+	}
+}
+
+func TestPickRandomKind_weights(t *testing.T) {
+	type tcGiven struct {
+		weights map[Kind]int
+		n       int
+	}
+
+	tests := []testCase[tcGiven, map[Kind]int]{
+		{
+			name: "tor_and_wireguard_equal",
+			given: tcGiven{
+				weights: map[Kind]int{KindTor: 1, KindWireGuard: 1},
+				n:       4,
+			},
+			exp: map[Kind]int{KindTor: 2, KindWireGuard: 2},
+		},
+
+		{
+			name: "tor_and_direct",
+			given: tcGiven{
+				weights: map[Kind]int{KindTor: 3, KindDirect: 1},
+				n:       8,
+			},
+			exp: map[Kind]int{KindTor: 6, KindDirect: 2},
+		},
+
+		{
+			name: "all_kinds",
+			given: tcGiven{
+				weights: map[Kind]int{KindTor: 1, KindWireGuard: 2, KindDirect: 3, KindOpenVPN: 4},
+				n:       10,
+			},
+			exp: map[Kind]int{KindTor: 1, KindWireGuard: 2, KindDirect: 3, KindOpenVPN: 4},
+		},
+
+		{
+			name: "non_positive_skipped",
+			given: tcGiven{
+				weights: map[Kind]int{KindTor: 0, KindWireGuard: -1, KindDirect: 1},
+				n:       3,
+			},
+			exp: map[Kind]int{KindDirect: 3},
+		},
+
+		{
+			name: "unknown_kind_ignored",
+			given: tcGiven{
+				weights: map[Kind]int{KindUnknown: 5, KindWireGuard: 1},
+				n:       3,
+			},
+			exp: map[Kind]int{KindWireGuard: 3},
+		},
+
+		{
+			name: "none_positive_default",
+			given: tcGiven{
+				weights: map[Kind]int{KindDirect: 0},
+				n:       4,
+			},
+			exp: map[Kind]int{KindTor: 2, KindWireGuard: 2},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := make(map[Kind]int)
+
+			// Every n within one cycle of the total weight is picked once.
+			for n := 0; n < tc.given.n; n++ {
+				actual[pickRandomKind(tc.given.weights, n)]++
+			}
+
+			should.Equal(t, tc.exp, actual)
 		})
 	}
 }
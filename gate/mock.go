@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/http"
 	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -12,13 +13,18 @@ import (
 )
 
 type MockExitGate struct {
-	FnID   func() uuid.UUID
-	FnKind func() Kind
+	FnID        func() uuid.UUID
+	FnKind      func() Kind
+	FnCreatedAt func() time.Time
+	FnLastUsed  func() time.Time
 
 	Reqs     struct{ Value int64 }
 	FnAddReq func()
 	FnDidReq func()
 
+	Bytes      struct{ In, Out uint64 }
+	FnAddBytes func(in, out uint64)
+
 	Dialer *MockNetDialer
 	Doer   *MockHTTPDoer
 }
@@ -39,6 +45,22 @@ func (g *MockExitGate) Kind() Kind {
 	return g.FnKind()
 }
 
+func (g *MockExitGate) CreatedAt() time.Time {
+	if g.FnCreatedAt == nil {
+		return time.Time{}
+	}
+
+	return g.FnCreatedAt()
+}
+
+func (g *MockExitGate) LastUsed() time.Time {
+	if g.FnLastUsed == nil {
+		return time.Time{}
+	}
+
+	return g.FnLastUsed()
+}
+
 func (g *MockExitGate) AddReq() {
 	if g.FnAddReq == nil {
 		_ = atomic.AddInt64(&g.Reqs.Value, 1)
@@ -59,6 +81,17 @@ func (g *MockExitGate) DidReq() {
 	g.FnDidReq()
 }
 
+func (g *MockExitGate) AddBytes(in, out uint64) {
+	if g.FnAddBytes == nil {
+		_ = atomic.AddUint64(&g.Bytes.In, in)
+		_ = atomic.AddUint64(&g.Bytes.Out, out)
+
+		return
+	}
+
+	g.FnAddBytes(in, out)
+}
+
 func (g *MockExitGate) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
 	if g.Dialer == nil {
 		return &fakenet.MockConn{}, nil
@@ -2,6 +2,8 @@ package gate
 
 import (
 	"context"
+	"log/slog"
+	"net/netip"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,3 +20,57 @@ func (c *mockTorCreator) new(ctx context.Context, dtout, cltout time.Duration) (
 
 	return c.fnNew(ctx, dtout, cltout)
 }
+
+type mockWGCreator struct {
+	fnNew func(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout time.Duration) (*WireGuard, error)
+}
+
+func (c *mockWGCreator) new(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout time.Duration) (*WireGuard, error) {
+	if c.fnNew == nil {
+		result := newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+		result.key = cfg.Key()
+
+		return result, nil
+	}
+
+	return c.fnNew(lg, cfg, dnsAddr, tout)
+}
+
+type mockOVPNCreator struct {
+	fnNew func(ctx context.Context, fpath string, stutout, cltout time.Duration) (*OpenVPN, error)
+}
+
+func (c *mockOVPNCreator) new(ctx context.Context, fpath string, stutout, cltout time.Duration) (*OpenVPN, error) {
+	if c.fnNew == nil {
+		result := newOpenVPN(uuid.MustParse("0b0e0000-0000-4000-a000-000000000000"), &ovpnDev{}, &MockNetDialer{}, &MockHTTPDoer{})
+		result.key = fpath
+
+		return result, nil
+	}
+
+	return c.fnNew(ctx, fpath, stutout, cltout)
+}
+
+type mockHostResolver struct {
+	fnLookupNetIP func(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+func (r *mockHostResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if r.fnLookupNetIP == nil {
+		return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+	}
+
+	return r.fnLookupNetIP(ctx, network, host)
+}
+
+type mockHostLookuper struct {
+	fnLookupContextHost func(ctx context.Context, host string) ([]string, error)
+}
+
+func (r *mockHostLookuper) LookupContextHost(ctx context.Context, host string) ([]string, error) {
+	if r.fnLookupContextHost == nil {
+		return []string{"127.0.0.1"}, nil
+	}
+
+	return r.fnLookupContextHost(ctx, host)
+}
@@ -0,0 +1,480 @@
+package gate
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ovpnMgmtHost    = "127.0.0.1"
+	ovpnStopTimeout = 10 * time.Second
+)
+
+// OpenVPN is a gate backed by an openvpn process, controlled via its management interface.
+//
+// The process is started with routes from the server ignored, so that the host routing stays intact.
+// Connections are bound to the tun device of the gate instead, which requires Linux and CAP_NET_RAW.
+type OpenVPN struct {
+	*baseGate
+
+	// key identifies the config the gate was created from.
+	key string
+
+	refreshing *struct{ value uint32 }
+	dev        ovpnRestartCloser
+	netd       netDialer
+	doer       httpDoer
+}
+
+func NewOpenVPN(ctx context.Context, fpath string, stutout, cltout time.Duration) (*OpenVPN, error) {
+	return newOpenVPNWithFactory(ctx, fpath, stutout, cltout, &ovpnCreator{})
+}
+
+func newOpenVPNWithFactory(ctx context.Context, fpath string, stutout, cltout time.Duration, of ovpnFactory) (*OpenVPN, error) {
+	return of.new(ctx, fpath, stutout, cltout)
+}
+
+func newOpenVPN(id uuid.UUID, dev ovpnRestartCloser, netd netDialer, doer httpDoer) *OpenVPN {
+	result := &OpenVPN{
+		baseGate:   newBaseGateID(KindOpenVPN, id),
+		refreshing: &struct{ value uint32 }{},
+		dev:        dev,
+		netd:       netd,
+		doer:       doer,
+	}
+
+	return result
+}
+
+func (g *OpenVPN) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return g.netd.DialContext(ctx, network, addr)
+}
+
+func (g *OpenVPN) Do(r *http.Request) (*http.Response, error) {
+	return g.doer.Do(r)
+}
+
+func (g *OpenVPN) warmup(ctx context.Context) (time.Duration, error) {
+	return warmupDoer(ctx, g.doer)
+}
+
+// refresh makes openvpn reconnect, and waits until the connection is up again.
+func (g *OpenVPN) refresh() error {
+	if ok := atomic.CompareAndSwapUint32(&g.refreshing.value, 0, 1); !ok {
+		return ErrGateIsRefreshing
+	}
+
+	defer func() { atomic.StoreUint32(&g.refreshing.value, 0) }()
+
+	return g.dev.restart()
+}
+
+func (g *OpenVPN) close() error {
+	return g.dev.close()
+}
+
+// NewOpenVPNs starts a gate for each of the configs in fpaths.
+//
+// When one fails to start, the gates started before it are closed.
+func NewOpenVPNs(ctx context.Context, fpaths []string, stutout, cltout time.Duration) ([]*OpenVPN, error) {
+	return newOpenVPNsWithFactory(ctx, fpaths, stutout, cltout, &ovpnCreator{})
+}
+
+func newOpenVPNsWithFactory(ctx context.Context, fpaths []string, stutout, cltout time.Duration, of ovpnFactory) ([]*OpenVPN, error) {
+	var result []*OpenVPN
+
+	for i := range fpaths {
+		ov, err := newOpenVPNWithFactory(ctx, fpaths[i], stutout, cltout, of)
+		if err != nil {
+			for j := range result {
+				_ = result[j].close()
+			}
+
+			return nil, err
+		}
+
+		result = append(result, ov)
+	}
+
+	return result, nil
+}
+
+// ListOVPNConfigs returns the paths to the OpenVPN configs in dir.
+//
+// Configs are regular files with either the .ovpn or .conf extension.
+func ListOVPNConfigs(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+
+	for i := range files {
+		if !files[i].Type().IsRegular() {
+			continue
+		}
+
+		name := files[i].Name()
+
+		if ext := filepath.Ext(name); ext != ".ovpn" && ext != ".conf" {
+			continue
+		}
+
+		result = append(result, filepath.Join(dir, name))
+	}
+
+	return result, nil
+}
+
+type ovpnCreator struct {
+	// bin is the openvpn binary, looked up in PATH when empty.
+	bin string
+}
+
+func (c *ovpnCreator) new(ctx context.Context, fpath string, stutout, cltout time.Duration) (*OpenVPN, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(ovpnMgmtHost, "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = ln.Close() }()
+
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
+	ifname := ovpnIfaceName(id)
+
+	// Options given after the config take precedence over the ones in it.
+	cmd := exec.Command(
+		c.binary(),
+		"--config", fpath,
+		"--dev", ifname,
+		"--dev-type", "tun",
+		"--route-nopull",
+		"--management", ovpnMgmtHost, port,
+		"--management-client",
+		"--management-hold",
+	)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	waitc := make(chan error, 1)
+	go func() { waitc <- cmd.Wait() }()
+
+	kill := func() {
+		_ = cmd.Process.Kill()
+		<-waitc
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, stutout)
+	defer cancel()
+
+	conn, err := acceptCtx(sctx, ln)
+	if err != nil {
+		kill()
+
+		return nil, err
+	}
+
+	mgmt := newOVPNMgmt(conn)
+
+	if err := mgmt.start(sctx); err != nil {
+		_ = conn.Close()
+		kill()
+
+		return nil, err
+	}
+
+	odev := &ovpnDev{
+		fnRestart: func() error {
+			rctx, cancel := context.WithTimeout(context.Background(), stutout)
+			defer cancel()
+
+			return mgmt.restart(rctx)
+		},
+		fnClose: func() error {
+			defer func() { _ = conn.Close() }()
+
+			cctx, cancel := context.WithTimeout(context.Background(), ovpnStopTimeout)
+			defer cancel()
+
+			if err := mgmt.command(cctx, "signal SIGTERM"); err != nil {
+				kill()
+
+				return err
+			}
+
+			select {
+			case <-waitc:
+				return nil
+			case <-cctx.Done():
+				kill()
+
+				return cctx.Err()
+			}
+		},
+	}
+
+	netd := &net.Dialer{Timeout: cltout, ControlContext: destControl(ovpnControl(ifname))}
+	doer := newHTTPClient(cltout, netd.DialContext)
+
+	result := newOpenVPN(id, odev, netd, doer)
+	result.key = fpath
+
+	return result, nil
+}
+
+func (c *ovpnCreator) binary() string {
+	if c.bin == "" {
+		return "openvpn"
+	}
+
+	return c.bin
+}
+
+type ovpnRestartCloser interface {
+	restart() error
+	close() error
+}
+
+type ovpnDev struct {
+	fnRestart func() error
+	fnClose   func() error
+}
+
+func (d *ovpnDev) restart() error {
+	if d.fnRestart == nil {
+		return nil
+	}
+
+	return d.fnRestart()
+}
+
+func (d *ovpnDev) close() error {
+	if d.fnClose == nil {
+		return nil
+	}
+
+	return d.fnClose()
+}
+
+// ovpnMgmt is a client for the management interface of openvpn.
+//
+// Commands are sent one at a time, and real-time state notifications are delivered separately.
+type ovpnMgmt struct {
+	conn net.Conn
+	mu   *sync.Mutex
+
+	// pending holds a reply slot for each command sent, in order, as openvpn replies to commands in order.
+	//
+	// A command that gives up waiting leaves its slot in place, so its late reply goes there,
+	// and is not taken for the reply to the next command.
+	pmu     *sync.Mutex
+	pending []chan string
+
+	states chan string
+	done   chan struct{}
+}
+
+func newOVPNMgmt(conn net.Conn) *ovpnMgmt {
+	result := &ovpnMgmt{
+		conn:   conn,
+		mu:     &sync.Mutex{},
+		pmu:    &sync.Mutex{},
+		states: make(chan string, 16),
+		done:   make(chan struct{}),
+	}
+
+	go result.read()
+
+	return result
+}
+
+// start turns on state notifications, releases the hold, and waits until the connection is up.
+func (m *ovpnMgmt) start(ctx context.Context) error {
+	if err := m.command(ctx, "state on"); err != nil {
+		return err
+	}
+
+	if err := m.command(ctx, "hold release"); err != nil {
+		return err
+	}
+
+	return m.waitConnected(ctx)
+}
+
+// restart makes openvpn reconnect, and waits until the connection is up again.
+func (m *ovpnMgmt) restart(ctx context.Context) error {
+	m.drainStates()
+
+	if err := m.command(ctx, "signal SIGUSR1"); err != nil {
+		return err
+	}
+
+	return m.waitConnected(ctx)
+}
+
+func (m *ovpnMgmt) command(ctx context.Context, cmd string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reply := m.pushPending()
+
+	if _, err := io.WriteString(m.conn, cmd+"\n"); err != nil {
+		m.dropPending(reply)
+
+		return err
+	}
+
+	select {
+	case line := <-reply:
+		if msg, ok := strings.CutPrefix(line, "ERROR:"); ok {
+			return fmt.Errorf("%w: %s", ErrOVPNCommand, strings.TrimSpace(msg))
+		}
+
+		return nil
+
+	case <-m.done:
+		return ErrOVPNMgmtClosed
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *ovpnMgmt) waitConnected(ctx context.Context) error {
+	for {
+		select {
+		case st := <-m.states:
+			// The format is: time,state,description,local_ip,remote_ip,...
+			parts := strings.Split(st, ",")
+			if len(parts) < 2 {
+				continue
+			}
+
+			switch parts[1] {
+			case "CONNECTED":
+				return nil
+			case "EXITING":
+				return ErrOVPNExited
+			}
+
+		case <-m.done:
+			return ErrOVPNMgmtClosed
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (m *ovpnMgmt) drainStates() {
+	for {
+		select {
+		case <-m.states:
+		default:
+			return
+		}
+	}
+}
+
+func (m *ovpnMgmt) read() {
+	defer close(m.done)
+
+	sc := bufio.NewScanner(m.conn)
+
+	for sc.Scan() {
+		line := sc.Text()
+
+		switch {
+		case strings.HasPrefix(line, ">STATE:"):
+			// Nobody waits for states most of the time, so drop them rather than block.
+			select {
+			case m.states <- strings.TrimPrefix(line, ">STATE:"):
+			default:
+			}
+
+		case strings.HasPrefix(line, "SUCCESS:"), strings.HasPrefix(line, "ERROR:"):
+			// A slot has room for exactly one reply, so this never blocks.
+			if reply := m.popPending(); reply != nil {
+				reply <- line
+			}
+		}
+	}
+}
+
+// pushPending adds a reply slot for a command about to be sent.
+func (m *ovpnMgmt) pushPending() chan string {
+	result := make(chan string, 1)
+
+	m.pmu.Lock()
+	m.pending = append(m.pending, result)
+	m.pmu.Unlock()
+
+	return result
+}
+
+// dropPending removes the slot of a command that has not been sent.
+func (m *ovpnMgmt) dropPending(reply chan string) {
+	m.pmu.Lock()
+	defer m.pmu.Unlock()
+
+	if n := len(m.pending); n > 0 && m.pending[n-1] == reply {
+		m.pending = m.pending[:n-1]
+	}
+}
+
+// popPending returns the slot of the oldest command without a reply, or nil when there is none.
+func (m *ovpnMgmt) popPending() chan string {
+	m.pmu.Lock()
+	defer m.pmu.Unlock()
+
+	if len(m.pending) == 0 {
+		return nil
+	}
+
+	result := m.pending[0]
+	m.pending = m.pending[1:]
+
+	return result
+}
+
+// ovpnIfaceName returns a name for the tun device that fits into IFNAMSIZ.
+func ovpnIfaceName(id uuid.UUID) string {
+	return fmt.Sprintf("pmp%x", id[:4])
+}
+
+func acceptCtx(ctx context.Context, ln net.Listener) (net.Conn, error) {
+	stop := context.AfterFunc(ctx, func() { _ = ln.Close() })
+	defer stop()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		if cerr := ctx.Err(); cerr != nil {
+			return nil, cerr
+		}
+
+		return nil, err
+	}
+
+	return conn, nil
+}
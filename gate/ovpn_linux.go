@@ -0,0 +1,17 @@
+//go:build linux
+
+package gate
+
+import "syscall"
+
+// ovpnControl binds sockets to the device ifname.
+func ovpnControl(ifname string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) { err = syscall.BindToDevice(int(fd), ifname) }); cerr != nil {
+			return cerr
+		}
+
+		return err
+	}
+}
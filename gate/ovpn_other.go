@@ -0,0 +1,12 @@
+//go:build !linux
+
+package gate
+
+import "syscall"
+
+// ovpnControl fails, as binding sockets to a device is only supported on Linux.
+func ovpnControl(ifname string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return ErrNotImplemented
+	}
+}
@@ -0,0 +1,338 @@
+package gate
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	should "github.com/stretchr/testify/assert"
+	must "github.com/stretchr/testify/require"
+
+	"github.com/pavelbrm/pumpe/model"
+)
+
+func TestOpenVPN_refresh(t *testing.T) {
+	type tcExpected struct {
+		refreshing uint32
+		err        error
+	}
+
+	tests := []testCase[*OpenVPN, tcExpected]{
+		{
+			name: "error_refreshing",
+			given: &OpenVPN{
+				baseGate:   newBaseGateID(KindOpenVPN, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+				refreshing: &struct{ value uint32 }{value: 1},
+				dev:        &ovpnDev{},
+				netd:       &MockNetDialer{},
+				doer:       &MockHTTPDoer{},
+			},
+			exp: tcExpected{
+				refreshing: 1,
+				err:        ErrGateIsRefreshing,
+			},
+		},
+
+		{
+			name: "error_restart",
+			given: &OpenVPN{
+				baseGate:   newBaseGateID(KindOpenVPN, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+				refreshing: &struct{ value uint32 }{},
+				dev: &ovpnDev{
+					fnRestart: func() error {
+						return model.Error("something_went_wrong")
+					},
+				},
+				netd: &MockNetDialer{},
+				doer: &MockHTTPDoer{},
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "success",
+			given: &OpenVPN{
+				baseGate:   newBaseGateID(KindOpenVPN, uuid.MustParse("facade00-0000-4000-a000-000000000000")),
+				refreshing: &struct{ value uint32 }{},
+				dev:        &ovpnDev{},
+				netd:       &MockNetDialer{},
+				doer:       &MockHTTPDoer{},
+			},
+			exp: tcExpected{},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.given.refresh()
+			must.Equal(t, tc.exp.err, actual)
+
+			should.Equal(t, tc.exp.refreshing, atomic.LoadUint32(&tc.given.refreshing.value))
+		})
+	}
+}
+
+func TestNewOpenVPNsWithFactory(t *testing.T) {
+	t.Run("error_closes_started", func(t *testing.T) {
+		var closed []string
+
+		of := &mockOVPNCreator{
+			fnNew: func(ctx context.Context, fpath string, stutout, cltout time.Duration) (*OpenVPN, error) {
+				if fpath == "c.ovpn" {
+					return nil, model.Error("something_went_wrong")
+				}
+
+				dev := &ovpnDev{
+					fnClose: func() error {
+						closed = append(closed, fpath)
+
+						return nil
+					},
+				}
+
+				return newOpenVPN(uuid.New(), dev, &MockNetDialer{}, &MockHTTPDoer{}), nil
+			},
+		}
+
+		actual, err := newOpenVPNsWithFactory(context.Background(), []string{"a.ovpn", "b.ovpn", "c.ovpn"}, time.Second, time.Second, of)
+		must.Equal(t, model.Error("something_went_wrong"), err)
+
+		should.Equal(t, 0, len(actual))
+		should.Equal(t, []string{"a.ovpn", "b.ovpn"}, closed)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		actual, err := newOpenVPNsWithFactory(context.Background(), []string{"a.ovpn", "b.ovpn"}, time.Second, time.Second, &mockOVPNCreator{})
+		must.Equal(t, nil, err)
+
+		must.Equal(t, 2, len(actual))
+		should.Equal(t, "a.ovpn", actual[0].key)
+		should.Equal(t, "b.ovpn", actual[1].key)
+	})
+}
+
+func TestListOVPNConfigs(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"a.ovpn", "b.conf", "c.txt"} {
+		must.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("client\n"), 0o600))
+	}
+
+	must.NoError(t, os.Mkdir(filepath.Join(dir, "d.ovpn"), 0o700))
+
+	t.Run("error_no_dir", func(t *testing.T) {
+		_, err := ListOVPNConfigs(filepath.Join(dir, "none"))
+		should.Equal(t, true, os.IsNotExist(err))
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		actual, err := ListOVPNConfigs(dir)
+		must.Equal(t, nil, err)
+
+		should.Equal(t, []string{filepath.Join(dir, "a.ovpn"), filepath.Join(dir, "b.conf")}, actual)
+	})
+}
+
+func TestOVPNMgmt_start(t *testing.T) {
+	type tcExpected struct {
+		cmds []string
+		err  error
+	}
+
+	tests := []testCase[func(cmd string) []string, tcExpected]{
+		{
+			name: "error_command",
+			given: func(cmd string) []string {
+				return []string{"ERROR: unknown command, enter 'help' for more options"}
+			},
+			exp: tcExpected{
+				cmds: []string{"state on"},
+				err:  fmt.Errorf("%w: %s", ErrOVPNCommand, "unknown command, enter 'help' for more options"),
+			},
+		},
+
+		{
+			name: "error_mgmt_closed",
+			given: func(cmd string) []string {
+				return nil
+			},
+			exp: tcExpected{
+				cmds: []string{"state on"},
+				err:  ErrOVPNMgmtClosed,
+			},
+		},
+
+		{
+			name: "error_exited",
+			given: func(cmd string) []string {
+				if cmd == "hold release" {
+					return []string{"SUCCESS: hold release succeeded", ">STATE:1700000000,EXITING,auth-failure,,,,,"}
+				}
+
+				return []string{"SUCCESS: real-time state notification set to ON"}
+			},
+			exp: tcExpected{
+				cmds: []string{"state on", "hold release"},
+				err:  ErrOVPNExited,
+			},
+		},
+
+		{
+			name: "success",
+			given: func(cmd string) []string {
+				if cmd == "hold release" {
+					return []string{
+						"SUCCESS: hold release succeeded",
+						">STATE:1700000000,WAIT,,,,,,",
+						">STATE:1700000001,CONNECTED,SUCCESS,10.8.0.2,192.0.2.1,1194,,",
+					}
+				}
+
+				return []string{"SUCCESS: real-time state notification set to ON"}
+			},
+			exp: tcExpected{
+				cmds: []string{"state on", "hold release"},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			cconn, sconn := net.Pipe()
+			defer func() { _ = cconn.Close() }()
+
+			cmdc := make(chan []string, 1)
+			go func() { cmdc <- serveOVPNMgmt(sconn, tc.given) }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := newOVPNMgmt(cconn).start(ctx)
+			must.Equal(t, tc.exp.err, err)
+
+			_ = cconn.Close()
+
+			should.Equal(t, tc.exp.cmds, <-cmdc)
+		})
+	}
+}
+
+func TestOVPNMgmt_restart(t *testing.T) {
+	cconn, sconn := net.Pipe()
+	defer func() { _ = cconn.Close() }()
+
+	cmdc := make(chan []string, 1)
+	go func() {
+		cmdc <- serveOVPNMgmt(sconn, func(cmd string) []string {
+			return []string{
+				"SUCCESS: signal SIGUSR1 thrown",
+				">STATE:1700000000,RECONNECTING,SIGUSR1,,,,,",
+				">STATE:1700000001,CONNECTED,SUCCESS,10.8.0.2,192.0.2.1,1194,,",
+			}
+		})
+	}()
+
+	mgmt := newOVPNMgmt(cconn)
+
+	// A stale state must not be taken for the result of the restart.
+	mgmt.states <- "1600000000,CONNECTED,SUCCESS,10.8.0.2,192.0.2.1,1194,,"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := mgmt.restart(ctx)
+	must.Equal(t, nil, err)
+
+	_ = cconn.Close()
+
+	should.Equal(t, []string{"signal SIGUSR1"}, <-cmdc)
+}
+
+func TestOVPNMgmt_command_lateReply(t *testing.T) {
+	cconn, sconn := net.Pipe()
+	defer func() { _ = cconn.Close() }()
+
+	release := make(chan struct{})
+
+	cmdc := make(chan []string, 1)
+	go func() {
+		cmdc <- serveOVPNMgmt(sconn, func(cmd string) []string {
+			if cmd == "state on" {
+				<-release
+
+				return []string{"SUCCESS: real-time state notification set to ON"}
+			}
+
+			return []string{"ERROR: hold release failed"}
+		})
+	}()
+
+	mgmt := newOVPNMgmt(cconn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := mgmt.command(ctx, "state on")
+	must.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel2()
+
+	// The late reply to the first command must not be taken for the reply to this one.
+	err = mgmt.command(ctx2, "hold release")
+	must.Equal(t, fmt.Errorf("%w: %s", ErrOVPNCommand, "hold release failed"), err)
+
+	_ = cconn.Close()
+
+	should.Equal(t, []string{"state on", "hold release"}, <-cmdc)
+}
+
+func TestOVPNIfaceName(t *testing.T) {
+	actual := ovpnIfaceName(uuid.MustParse("decade00-0000-4000-a000-000000000000"))
+	should.Equal(t, "pmpdecade00", actual)
+}
+
+// serveOVPNMgmt plays the openvpn side of the management interface on conn.
+//
+// It returns the received commands when conn is closed, or after the first command that gets no reply.
+func serveOVPNMgmt(conn net.Conn, fnReply func(cmd string) []string) []string {
+	defer func() { _ = conn.Close() }()
+
+	var result []string
+
+	sc := bufio.NewScanner(conn)
+
+	for sc.Scan() {
+		cmd := sc.Text()
+		result = append(result, cmd)
+
+		lines := fnReply(cmd)
+		if len(lines) == 0 {
+			return result
+		}
+
+		for i := range lines {
+			if _, err := io.WriteString(conn, lines[i]+"\n"); err != nil {
+				return result
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,208 @@
+package gate
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xFF
+
+	socksCmdConnect = 0x01
+
+	socksAtypIPv4   = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6   = 0x04
+
+	socksRepSuccess        = 0x00
+	socksRepFailure        = 0x01
+	socksRepCmdUnsupported = 0x07
+
+	socksDialTimeout = 30 * time.Second
+)
+
+// socksForwarder is a minimal local SOCKS5 server that dials destinations via an upstream dialer.
+//
+// It supports only CONNECT without authentication, which is enough for Tor's Socks5Proxy option.
+type socksForwarder struct {
+	ln   net.Listener
+	netd netDialer
+}
+
+func newSocksForwarder(netd netDialer) (*socksForwarder, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &socksForwarder{
+		ln:   ln,
+		netd: netd,
+	}
+
+	go result.serve()
+
+	return result, nil
+}
+
+func (f *socksForwarder) addr() string {
+	return f.ln.Addr().String()
+}
+
+func (f *socksForwarder) close() error {
+	return f.ln.Close()
+}
+
+func (f *socksForwarder) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go f.handle(conn)
+	}
+}
+
+func (f *socksForwarder) handle(src net.Conn) {
+	defer func() { _ = src.Close() }()
+
+	addr, err := socksHandshake(src)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), socksDialTimeout)
+	defer cancel()
+
+	dst, err := f.netd.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		_, _ = src.Write(socksReply(socksRepFailure))
+
+		return
+	}
+	defer func() { _ = dst.Close() }()
+
+	if _, err := src.Write(socksReply(socksRepSuccess)); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		_, _ = io.Copy(dst, src)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		_, _ = io.Copy(src, dst)
+		done <- struct{}{}
+	}()
+
+	// Closing both connections on return unblocks the other direction.
+	<-done
+}
+
+// socksHandshake negotiates a SOCKS5 CONNECT request on rw, and returns the requested address.
+func socksHandshake(rw io.ReadWriter) (string, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(rw, hdr); err != nil {
+		return "", err
+	}
+
+	if hdr[0] != socksVersion {
+		return "", ErrSocksUnsupported
+	}
+
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return "", err
+	}
+
+	if !hasSocksMethod(methods, socksMethodNoAuth) {
+		_, _ = rw.Write([]byte{socksVersion, socksMethodNoAcceptable})
+
+		return "", ErrSocksUnsupported
+	}
+
+	if _, err := rw.Write([]byte{socksVersion, socksMethodNoAuth}); err != nil {
+		return "", err
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(rw, req); err != nil {
+		return "", err
+	}
+
+	if req[0] != socksVersion {
+		return "", ErrSocksUnsupported
+	}
+
+	if req[1] != socksCmdConnect {
+		_, _ = rw.Write(socksReply(socksRepCmdUnsupported))
+
+		return "", ErrSocksUnsupported
+	}
+
+	var host string
+
+	switch req[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		size := net.IPv4len
+		if req[3] == socksAtypIPv6 {
+			size = net.IPv6len
+		}
+
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(rw, ip); err != nil {
+			return "", err
+		}
+
+		host = ip.String()
+
+	case socksAtypDomain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(rw, size); err != nil {
+			return "", err
+		}
+
+		domain := make([]byte, size[0])
+		if _, err := io.ReadFull(rw, domain); err != nil {
+			return "", err
+		}
+
+		host = string(domain)
+
+	default:
+		return "", ErrSocksUnsupported
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(rw, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func hasSocksMethod(methods []byte, m byte) bool {
+	for i := range methods {
+		if methods[i] == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// socksReply returns a reply with code rep and an empty bound address.
+func socksReply(rep byte) []byte {
+	return []byte{socksVersion, rep, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0}
+}
@@ -0,0 +1,207 @@
+package gate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	should "github.com/stretchr/testify/assert"
+	must "github.com/stretchr/testify/require"
+
+	"github.com/pavelbrm/pumpe/model"
+)
+
+func TestSocksHandshake(t *testing.T) {
+	type tcExpected struct {
+		addr string
+		sent []byte
+		err  error
+	}
+
+	tests := []testCase[[]byte, tcExpected]{
+		{
+			name:  "error_empty",
+			given: []byte{},
+			exp: tcExpected{
+				err: io.EOF,
+			},
+		},
+
+		{
+			name:  "error_version",
+			given: []byte{0x04, 0x01, 0x00},
+			exp: tcExpected{
+				err: ErrSocksUnsupported,
+			},
+		},
+
+		{
+			name:  "error_no_acceptable_method",
+			given: []byte{0x05, 0x01, 0x02},
+			exp: tcExpected{
+				sent: []byte{0x05, 0xFF},
+				err:  ErrSocksUnsupported,
+			},
+		},
+
+		{
+			name:  "error_command_not_connect",
+			given: []byte{0x05, 0x01, 0x00, 0x05, 0x02, 0x00, 0x01},
+			exp: tcExpected{
+				sent: []byte{0x05, 0x00, 0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0},
+				err:  ErrSocksUnsupported,
+			},
+		},
+
+		{
+			name:  "error_address_type",
+			given: []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x02},
+			exp: tcExpected{
+				sent: []byte{0x05, 0x00},
+				err:  ErrSocksUnsupported,
+			},
+		},
+
+		{
+			name:  "valid_ipv4",
+			given: []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1, 0x01, 0xBB},
+			exp: tcExpected{
+				addr: "10.0.0.1:443",
+				sent: []byte{0x05, 0x00},
+			},
+		},
+
+		{
+			name:  "valid_ipv6",
+			given: append([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x04}, append(net.IPv6loopback, 0x23, 0x29)...),
+			exp: tcExpected{
+				addr: "[::1]:9001",
+				sent: []byte{0x05, 0x00},
+			},
+		},
+
+		{
+			name:  "valid_domain",
+			given: append(append([]byte{0x05, 0x02, 0x02, 0x00, 0x05, 0x01, 0x00, 0x03, 0x0B}, "httpbin.org"...), 0x00, 0x50),
+			exp: tcExpected{
+				addr: "httpbin.org:80",
+				sent: []byte{0x05, 0x00},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			sent := &bytes.Buffer{}
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{Reader: bytes.NewReader(tc.given), Writer: sent}
+
+			actual, err := socksHandshake(rw)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.sent, sent.Bytes())
+			should.Equal(t, tc.exp.addr, actual)
+		})
+	}
+}
+
+func TestSocksForwarder(t *testing.T) {
+	type tcExpected struct {
+		reply []byte
+		data  []byte
+	}
+
+	tests := []testCase[*MockNetDialer, tcExpected]{
+		{
+			name: "error_dial",
+			given: &MockNetDialer{
+				FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return nil, model.Error("something_went_wrong")
+				},
+			},
+			exp: tcExpected{
+				reply: []byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0},
+			},
+		},
+
+		{
+			name: "valid",
+			given: &MockNetDialer{
+				FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					if addr != "10.0.0.1:443" {
+						return nil, model.Error("unexpected_addr")
+					}
+
+					c1, c2 := net.Pipe()
+
+					go func() {
+						defer func() { _ = c2.Close() }()
+
+						buf := make([]byte, 4)
+						if _, err := io.ReadFull(c2, buf); err != nil {
+							return
+						}
+
+						_, _ = c2.Write(bytes.ToUpper(buf))
+					}()
+
+					return c1, nil
+				},
+			},
+			exp: tcExpected{
+				reply: []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0},
+				data:  []byte("PING"),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			fwd, err := newSocksForwarder(tc.given)
+			must.Equal(t, nil, err)
+
+			defer func() { _ = fwd.close() }()
+
+			conn, err := net.Dial("tcp", fwd.addr())
+			must.Equal(t, nil, err)
+
+			defer func() { _ = conn.Close() }()
+
+			_, err = conn.Write([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1, 0x01, 0xBB})
+			must.Equal(t, nil, err)
+
+			method := make([]byte, 2)
+			_, err = io.ReadFull(conn, method)
+			must.Equal(t, nil, err)
+
+			should.Equal(t, []byte{0x05, 0x00}, method)
+
+			reply := make([]byte, 10)
+			_, err = io.ReadFull(conn, reply)
+			must.Equal(t, nil, err)
+
+			should.Equal(t, tc.exp.reply, reply)
+
+			if tc.exp.data == nil {
+				return
+			}
+
+			_, err = conn.Write([]byte("ping"))
+			must.Equal(t, nil, err)
+
+			data := make([]byte, 4)
+			_, err = io.ReadFull(conn, data)
+			must.Equal(t, nil, err)
+
+			should.Equal(t, tc.exp.data, data)
+		})
+	}
+}
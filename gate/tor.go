@@ -2,8 +2,16 @@ package gate
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"slices"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -17,10 +25,48 @@ type Tor struct {
 	dev        torSignalCloser
 	netd       netDialer
 	doer       httpDoer
+
+	// tf, when set, makes replacements of the gate, so that they are chained the same way.
+	tf torFactory
+
+	// upstream is the id of the WireGuard gate the gate is chained over, if any.
+	upstream uuid.UUID
 }
 
-func NewTor(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
-	return newTorWithFactory(ctx, dtout, cltout, &torCreator{})
+// TorConfig holds optional settings for starting Tor.
+//
+// The zero value starts Tor with the defaults, and cookie authentication on the control port.
+type TorConfig struct {
+	// TorrcFile is a torrc to start Tor with, e.g. for hardening options.
+	//
+	// Options that pumpe sets on the command line, such as SocksPort and ControlPort, take precedence.
+	TorrcFile string
+
+	// ControlPassword, when set, replaces cookie authentication on the control port with the password.
+	ControlPassword string
+}
+
+func (c TorConfig) startConf() (*tor.StartConf, error) {
+	result := &tor.StartConf{TempDataDirBase: "/tmp", TorrcFile: c.TorrcFile}
+
+	if c.ControlPassword == "" {
+		return result, nil
+	}
+
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	result.DisableCookieAuth = true
+	result.DisableEagerAuth = true
+	result.ExtraArgs = []string{"--HashedControlPassword", hashTorPassword(c.ControlPassword, salt)}
+
+	return result, nil
+}
+
+func NewTor(ctx context.Context, tcfg TorConfig, dtout, cltout time.Duration) (*Tor, error) {
+	return newTorWithFactory(ctx, dtout, cltout, &torCreator{cfg: tcfg})
 }
 
 func newTorWithFactory(ctx context.Context, dtout, cltout time.Duration, tf torFactory) (*Tor, error) {
@@ -51,6 +97,42 @@ func (g *Tor) warmup(ctx context.Context) (time.Duration, error) {
 	return warmupDoer(ctx, g.doer)
 }
 
+// WarmupTorCheck is a WarmupFunc that makes sure that requests via gt actually go via Tor.
+//
+// It asks the Tor Project's check service, which is more costly than the default warmup.
+func WarmupTorCheck(ctx context.Context, gt ExitGate) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://check.torproject.org/api/ip", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	resp, err := gt.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, ErrWarmupBadResponse
+	}
+
+	result := &struct {
+		IsTor bool `json:"IsTor"`
+	}{}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(result); err != nil {
+		return 0, err
+	}
+
+	if !result.IsTor {
+		return 0, ErrWarmupNotTor
+	}
+
+	return time.Since(now), nil
+}
+
 func (g *Tor) refresh() error {
 	if ok := atomic.CompareAndSwapUint32(&g.refreshing.value, 0, 1); !ok {
 		return ErrGateIsRefreshing
@@ -61,12 +143,17 @@ func (g *Tor) refresh() error {
 	return g.dev.signal("NEWNYM")
 }
 
+// check reports whether the control connection of the Tor device is alive.
+func (g *Tor) check(ctx context.Context) error {
+	return g.dev.check(ctx)
+}
+
 func (g *Tor) close() error {
 	return g.dev.close()
 }
 
-func NewTors(ctx context.Context, stutout, cltout time.Duration, n int) ([]*Tor, error) {
-	tf := &torCreator{}
+func NewTors(ctx context.Context, tcfg TorConfig, stutout, cltout time.Duration, n int) ([]*Tor, error) {
+	tf := &torCreator{cfg: tcfg}
 
 	var result []*Tor
 
@@ -82,40 +169,128 @@ func NewTors(ctx context.Context, stutout, cltout time.Duration, n int) ([]*Tor,
 	return result, nil
 }
 
-type torCreator struct{}
+// NewTorsOverWireGuard starts n Tor gates that connect to the Tor network via wgs, assigned in turn.
+//
+// This makes a static two-hop chain: traffic goes through a WireGuard gate first, and then through Tor.
+// The gates are of KindTorWG. An upstream WireGuard gate can't be closed by Set while Tor gates use it.
+func NewTorsOverWireGuard(ctx context.Context, tcfg TorConfig, stutout, cltout time.Duration, n int, wgs []*WireGuard) ([]*Tor, error) {
+	if n > 0 && len(wgs) == 0 {
+		return nil, ErrNoUpstreamGate
+	}
+
+	var result []*Tor
+
+	for i := 0; i < n; i++ {
+		tg, err := newTorWithFactory(ctx, stutout, cltout, &torCreator{cfg: tcfg, upstream: wgs[i%len(wgs)]})
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, tg)
+	}
+
+	return result, nil
+}
+
+type torCreator struct {
+	cfg TorConfig
+
+	// upstream, when set, is used by Tor to connect to the network.
+	upstream *WireGuard
+}
 
 func (c *torCreator) new(ctx context.Context, dtout, cltout time.Duration) (*Tor, error) {
-	dev, err := tor.Start(ctx, &tor.StartConf{TempDataDirBase: "/tmp"})
+	scfg, err := c.cfg.startConf()
 	if err != nil {
 		return nil, err
 	}
 
+	cleanup := func() {}
+
+	if c.upstream != nil {
+		fwd, err := newSocksForwarder(c.upstream)
+		if err != nil {
+			return nil, err
+		}
+
+		scfg.ExtraArgs = append(slices.Clone(scfg.ExtraArgs), "--Socks5Proxy", fwd.addr())
+		cleanup = func() { _ = fwd.close() }
+	}
+
+	dev, err := tor.Start(ctx, scfg)
+	if err != nil {
+		cleanup()
+
+		return nil, fmt.Errorf("%w: %w", ErrTorStartFailed, err)
+	}
+
+	if c.cfg.ControlPassword != "" {
+		if err := dev.Control.Authenticate(c.cfg.ControlPassword); err != nil {
+			_ = dev.Close()
+			cleanup()
+
+			return nil, fmt.Errorf("%w: %w", ErrTorStartFailed, err)
+		}
+	}
+
 	dctx, cancel := context.WithTimeout(ctx, dtout)
 	defer cancel()
 
+	// Waits until Tor has bootstrapped.
 	tnet, err := dev.Dialer(dctx, &tor.DialConf{})
 	if err != nil {
-		return nil, err
+		_ = dev.Close()
+		cleanup()
+
+		return nil, fmt.Errorf("%w: %w", ErrTorStartFailed, err)
 	}
 
-	tdev := &torDev{fnSignal: dev.Control.Signal, fnClose: dev.Close}
-	doer := &http.Client{
-		Timeout: cltout,
-		Transport: &http.Transport{
-			DialContext: tnet.DialContext,
+	tdev := &torDev{
+		fnSignal: dev.Control.Signal,
+		fnCheck: func(ctx context.Context) error {
+			// GetInfo doesn't take a context, and would block for as long as Tor doesn't answer.
+			errc := make(chan error, 1)
+
+			go func() {
+				_, err := dev.Control.GetInfo("version")
+				errc <- err
+			}()
+
+			select {
+			case err := <-errc:
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+		fnClose: func() error {
+			defer cleanup()
+
+			return dev.Close()
 		},
 	}
+	doer := newHTTPClient(cltout, tnet.DialContext)
+
+	result := newTor(uuid.New(), tdev, tnet, doer)
+
+	if c.upstream != nil {
+		result.kind = KindTorWG
+		result.tf = c
+		result.upstream = c.upstream.id
+	}
 
-	return newTor(uuid.New(), tdev, tnet, doer), nil
+	return result, nil
 }
 
 type torSignalCloser interface {
 	signal(s string) error
+	check(ctx context.Context) error
 	close() error
 }
 
 type torDev struct {
 	fnSignal func(string) error
+	fnCheck  func(ctx context.Context) error
 	fnClose  func() error
 }
 
@@ -127,6 +302,14 @@ func (d *torDev) signal(s string) error {
 	return d.fnSignal(s)
 }
 
+func (d *torDev) check(ctx context.Context) error {
+	if d.fnCheck == nil {
+		return nil
+	}
+
+	return d.fnCheck(ctx)
+}
+
 func (d *torDev) close() error {
 	if d.fnClose == nil {
 		return nil
@@ -134,3 +317,27 @@ func (d *torDev) close() error {
 
 	return d.fnClose()
 }
+
+// hashTorPassword hashes pwd in the format of HashedControlPassword, as tor --hash-password does.
+//
+// It's the iterated and salted S2K from RFC 2440, with the count Tor uses.
+func hashTorPassword(pwd string, salt []byte) string {
+	const indicator = 96
+
+	count := (16 + (indicator & 15)) << ((indicator >> 4) + 6)
+
+	data := append(slices.Clone(salt), pwd...)
+
+	h := sha1.New()
+
+	for count > 0 {
+		n := min(count, len(data))
+		_, _ = h.Write(data[:n])
+
+		count -= n
+	}
+
+	spec := append(slices.Clone(salt), indicator)
+
+	return "16:" + strings.ToUpper(hex.EncodeToString(h.Sum(spec)))
+}
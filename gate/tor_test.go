@@ -1,9 +1,15 @@
 package gate
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/cretz/bine/tor"
 	"github.com/google/uuid"
 	should "github.com/stretchr/testify/assert"
 	must "github.com/stretchr/testify/require"
@@ -75,3 +81,180 @@ func TestTor_refresh(t *testing.T) {
 		})
 	}
 }
+
+func TestWarmupTorCheck(t *testing.T) {
+	tests := []testCase[*MockHTTPDoer, error]{
+		{
+			name: "error_do",
+			given: &MockHTTPDoer{
+				FnDo: func(r *http.Request) (*http.Response, error) {
+					return nil, model.Error("something_went_wrong")
+				},
+			},
+			exp: model.Error("something_went_wrong"),
+		},
+
+		{
+			name: "error_non_200",
+			given: &MockHTTPDoer{
+				FnDo: func(r *http.Request) (*http.Response, error) {
+					result := NewMockResponse()
+					result.StatusCode = http.StatusServiceUnavailable
+
+					return result, nil
+				},
+			},
+			exp: ErrWarmupBadResponse,
+		},
+
+		{
+			name: "error_not_tor",
+			given: &MockHTTPDoer{
+				FnDo: func(r *http.Request) (*http.Response, error) {
+					result := NewMockResponse()
+					result.Body = io.NopCloser(strings.NewReader(`{"IsTor":false,"IP":"192.0.2.1"}`))
+
+					return result, nil
+				},
+			},
+			exp: ErrWarmupNotTor,
+		},
+
+		{
+			name: "success",
+			given: &MockHTTPDoer{
+				FnDo: func(r *http.Request) (*http.Response, error) {
+					if r.URL.Host != "check.torproject.org" {
+						return nil, model.Error("unexpected_host")
+					}
+
+					result := NewMockResponse()
+					result.Body = io.NopCloser(strings.NewReader(`{"IsTor":true,"IP":"192.0.2.1"}`))
+
+					return result, nil
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			gt := newTor(uuid.MustParse("facade00-0000-4000-a000-000000000000"), &torDev{}, &MockNetDialer{}, tc.given)
+
+			_, err := WarmupTorCheck(context.Background(), gt)
+			must.Equal(t, tc.exp, err)
+		})
+	}
+}
+
+func TestNewTorsOverWireGuard(t *testing.T) {
+	type tcGiven struct {
+		n   int
+		wgs []*WireGuard
+	}
+
+	type tcExpected struct {
+		tgs []*Tor
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_no_upstream",
+			given: tcGiven{
+				n: 1,
+			},
+			exp: tcExpected{
+				err: ErrNoUpstreamGate,
+			},
+		},
+
+		{
+			name: "valid_none",
+			given: tcGiven{
+				wgs: []*WireGuard{
+					newWireGuard(uuid.MustParse("decade00-0000-4000-a000-000000000000"), &wgDev{}, &MockNetDialer{}, &MockHTTPDoer{}),
+				},
+			},
+			exp: tcExpected{},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			actual, err := NewTorsOverWireGuard(ctx, TorConfig{}, time.Second, time.Second, tc.given.n, tc.given.wgs)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.tgs, actual)
+		})
+	}
+}
+
+func TestTorConfig_startConf(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		actual, err := TorConfig{}.startConf()
+		must.Equal(t, nil, err)
+
+		should.Equal(t, &tor.StartConf{TempDataDirBase: "/tmp"}, actual)
+	})
+
+	t.Run("torrc_file", func(t *testing.T) {
+		actual, err := TorConfig{TorrcFile: "/etc/pumpe/torrc"}.startConf()
+		must.Equal(t, nil, err)
+
+		should.Equal(t, &tor.StartConf{TempDataDirBase: "/tmp", TorrcFile: "/etc/pumpe/torrc"}, actual)
+	})
+
+	t.Run("control_password", func(t *testing.T) {
+		actual, err := TorConfig{ControlPassword: "secret"}.startConf()
+		must.Equal(t, nil, err)
+
+		should.Equal(t, true, actual.DisableCookieAuth)
+		should.Equal(t, true, actual.DisableEagerAuth)
+
+		must.Equal(t, 2, len(actual.ExtraArgs))
+		should.Equal(t, "--HashedControlPassword", actual.ExtraArgs[0])
+		should.Equal(t, 3+2*(8+1+20), len(actual.ExtraArgs[1]))
+	})
+}
+
+func TestHashTorPassword(t *testing.T) {
+	type tcGiven struct {
+		pwd  string
+		salt []byte
+	}
+
+	tests := []testCase[tcGiven, string]{
+		{
+			name: "empty",
+			given: tcGiven{
+				salt: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			},
+			exp: "16:01020304050607086065211D79F81BCDC6AADB3C64E62820DB4E005378",
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				pwd:  "secret",
+				salt: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			},
+			exp: "16:0102030405060708604A9FC603431AADC6ADA83362A68B19F5D1E99637",
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := hashTorPassword(tc.given.pwd, tc.given.salt)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package gate
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
@@ -15,7 +16,10 @@ import (
 	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -31,13 +35,43 @@ const (
 	WGParseModeIgnore
 )
 
+const (
+	WGAllowedIPsStrict WGAllowedIPsMode = iota
+	WGAllowedIPsFullTunnel
+)
+
+const wgResolveTimeout = 10 * time.Second
+
+// WGConfigSeparator separates configs in a combined file.
+const WGConfigSeparator = "---"
+
 type WGParseMode int
 
+// WGAllowedIPsMode controls how a peer without AllowedIPs is handled.
+//
+// With WGAllowedIPsStrict such a config is invalid.
+// With WGAllowedIPsFullTunnel the peer is given wgFullTunnelIPs instead.
+type WGAllowedIPsMode int
+
+var wgFullTunnelIPs = []string{"0.0.0.0/0", "::/0"}
+
 type WireGuard struct {
 	*baseGate
-	dev  wgDownCloser
-	netd netDialer
-	doer httpDoer
+
+	// key identifies the config the gate was created from.
+	key string
+
+	// pubKey is the peer public key in hex, and endpoint is the peer endpoint as given in the config.
+	//
+	// They are used on refresh to re-resolve the endpoint and update the peer.
+	pubKey   string
+	endpoint string
+	rsv      hostResolver
+
+	refreshing *struct{ value uint32 }
+	dev        wgSetDownCloser
+	netd       netDialer
+	doer       httpDoer
 }
 
 func NewWireGuard(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout time.Duration) (*WireGuard, error) {
@@ -48,12 +82,13 @@ func newWireGuardWithFactory(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr,
 	return wf.new(lg, cfg, dnsAddr, tout)
 }
 
-func newWireGuard(id uuid.UUID, dev wgDownCloser, netd netDialer, doer httpDoer) *WireGuard {
+func newWireGuard(id uuid.UUID, dev wgSetDownCloser, netd netDialer, doer httpDoer) *WireGuard {
 	result := &WireGuard{
-		baseGate: newBaseGateID(KindWireGuard, id),
-		dev:      dev,
-		netd:     netd,
-		doer:     doer,
+		baseGate:   newBaseGateID(KindWireGuard, id),
+		refreshing: &struct{ value uint32 }{},
+		dev:        dev,
+		netd:       netd,
+		doer:       doer,
 	}
 
 	return result
@@ -71,8 +106,27 @@ func (g *WireGuard) warmup(ctx context.Context) (time.Duration, error) {
 	return warmupDoer(ctx, g.doer)
 }
 
+// refresh re-resolves the peer endpoint, and updates the device with the result.
+//
+// This helps when the peer is given by a hostname whose address has changed.
 func (g *WireGuard) refresh() error {
-	return ErrNotImplemented
+	if ok := atomic.CompareAndSwapUint32(&g.refreshing.value, 0, 1); !ok {
+		return ErrGateIsRefreshing
+	}
+
+	defer func() { atomic.StoreUint32(&g.refreshing.value, 0) }()
+
+	rsv := g.rsv
+	if rsv == nil {
+		rsv = net.DefaultResolver
+	}
+
+	endpoint, err := resolveEndpoint(context.Background(), rsv, g.endpoint)
+	if err != nil {
+		return err
+	}
+
+	return g.dev.set("public_key=" + g.pubKey + "\nendpoint=" + endpoint + "\n")
 }
 
 func (g *WireGuard) close() error {
@@ -106,6 +160,9 @@ type WGConfig struct {
 	Iface struct {
 		PrivateKey string
 		Address    []string
+
+		// ListenPort is the source UDP port, chosen by the system when zero.
+		ListenPort int
 	}
 
 	Peer struct {
@@ -115,6 +172,33 @@ type WGConfig struct {
 	}
 }
 
+// Key returns a stable key derived from the content of c.
+//
+// Configs with equal content have equal keys.
+func (c *WGConfig) Key() string {
+	h := sha256.New()
+
+	fields := []string{
+		c.Iface.PrivateKey,
+		strings.Join(c.Iface.Address, ","),
+		c.Peer.PublicKey,
+		c.Peer.Endpoint,
+		strings.Join(c.Peer.AllowedIPs, ","),
+	}
+
+	// Added only when set, so that keys of configs without it stay the same.
+	if c.Iface.ListenPort > 0 {
+		fields = append(fields, strconv.Itoa(c.Iface.ListenPort))
+	}
+
+	for i := range fields {
+		_, _ = io.WriteString(h, fields[i])
+		_, _ = h.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func (c *WGConfig) toProto() (string, error) {
 	pvtKey, err := recodeBase64ToHex(c.Iface.PrivateKey)
 	if err != nil {
@@ -128,6 +212,11 @@ func (c *WGConfig) toProto() (string, error) {
 
 	cfg := &bytes.Buffer{}
 	cfg.WriteString("private_key=" + pvtKey + "\n")
+
+	if c.Iface.ListenPort > 0 {
+		cfg.WriteString("listen_port=" + strconv.Itoa(c.Iface.ListenPort) + "\n")
+	}
+
 	cfg.WriteString("public_key=" + pubKey + "\n")
 	cfg.WriteString("endpoint=" + c.Peer.Endpoint + "\n")
 
@@ -148,11 +237,129 @@ func (c *WGConfig) toProto() (string, error) {
 //
 // - WGParseModeIgnore -> ignore all encountered _parsing_ errors;
 //   - non-parsing errors may still be reported.
-func ParseWGConfigs(mode WGParseMode, dir string) ([]*WGConfig, error) {
-	return parseWGConfigs(mode, dir)
+//
+// A peer without AllowedIPs is handled based on amode.
+func ParseWGConfigs(mode WGParseMode, amode WGAllowedIPsMode, dir string) ([]*WGConfig, error) {
+	return parseWGConfigs(mode, amode, dir)
+}
+
+// ParseWGConfigsFile parses multiple configs from a single file.
+//
+// Configs in the file are separated by lines consisting of WGConfigSeparator.
+// Errors and peers without AllowedIPs are handled based on the modes, as in ParseWGConfigs.
+func ParseWGConfigsFile(mode WGParseMode, amode WGAllowedIPsMode, fpath string) ([]*WGConfig, error) {
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseWGConfigsCombined(mode, amode, data)
 }
 
+// DedupWGConfigs returns cfgs without the configs whose content repeats an earlier one, and the skipped configs.
+//
+// Identical configs would start gates competing for the same peer.
+func DedupWGConfigs(cfgs []*WGConfig) ([]*WGConfig, []*WGConfig) {
+	result := make([]*WGConfig, 0, len(cfgs))
+
+	var dups []*WGConfig
+
+	seen := make(map[string]struct{}, len(cfgs))
+
+	for i := range cfgs {
+		key := cfgs[i].Key()
+
+		if _, ok := seen[key]; ok {
+			dups = append(dups, cfgs[i])
+
+			continue
+		}
+
+		seen[key] = struct{}{}
+
+		result = append(result, cfgs[i])
+	}
+
+	return result, dups
+}
+
+// IgnoreWGListenPorts clears ListenPort in cfgs, and returns the configs that had it set.
+//
+// Provider configs often come with a port meant for a server, which would make gates compete for it.
+func IgnoreWGListenPorts(cfgs []*WGConfig) []*WGConfig {
+	var result []*WGConfig
+
+	for i := range cfgs {
+		if cfgs[i].Iface.ListenPort == 0 {
+			continue
+		}
+
+		cfgs[i].Iface.ListenPort = 0
+
+		result = append(result, cfgs[i])
+	}
+
+	return result
+}
+
+// ParseWGConfig parses the config in fpath, which must have AllowedIPs for the peer.
 func ParseWGConfig(fpath string) (*WGConfig, error) {
+	return parseWGConfigFile(WGAllowedIPsStrict, fpath)
+}
+
+// ValidateWGConfig checks the config in data without bringing up a device.
+//
+// In addition to what ParseWGConfig checks, keys must be valid base64-encoded keys,
+// addresses and AllowedIPs must be in CIDR notation, and the endpoint must be a host and port.
+// The endpoint is not resolved.
+func ValidateWGConfig(data []byte) error {
+	cfg, err := parseWGConfigINI(WGAllowedIPsStrict, data)
+	if err != nil {
+		return err
+	}
+
+	return validateWGConfig(cfg)
+}
+
+func validateWGConfig(cfg *WGConfig) error {
+	if _, err := recodeBase64ToHex(cfg.Iface.PrivateKey); err != nil {
+		return ErrInvalidWGIfacePvtKey
+	}
+
+	for i := range cfg.Iface.Address {
+		if _, err := netip.ParsePrefix(cfg.Iface.Address[i]); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidWGIfaceAddr, cfg.Iface.Address[i])
+		}
+	}
+
+	if _, err := recodeBase64ToHex(cfg.Peer.PublicKey); err != nil {
+		return ErrInvalidWGPeerPubKey
+	}
+
+	if !isValidEndpoint(cfg.Peer.Endpoint) {
+		return fmt.Errorf("%w: %s", ErrInvalidWGPeerEndpoint, cfg.Peer.Endpoint)
+	}
+
+	if _, err := parseAllowedPrefixes(cfg.Peer.AllowedIPs); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// isValidEndpoint reports whether endpoint is a non-empty host with a port in the valid range.
+func isValidEndpoint(endpoint string) bool {
+	host, raw, err := net.SplitHostPort(endpoint)
+	if err != nil || host == "" {
+		return false
+	}
+
+	port, err := strconv.Atoi(raw)
+
+	return err == nil && port > 0 && port <= 65535
+}
+
+func parseWGConfigFile(amode WGAllowedIPsMode, fpath string) (*WGConfig, error) {
 	f, err := os.Open(fpath)
 	if err != nil {
 		return nil, err
@@ -165,19 +372,29 @@ func ParseWGConfig(fpath string) (*WGConfig, error) {
 		return nil, err
 	}
 
-	return parseWGConfigINI(data)
+	return parseWGConfigINI(amode, data)
 }
 
-func parseWGConfigs(mode WGParseMode, dir string) ([]*WGConfig, error) {
+func parseWGConfigs(mode WGParseMode, amode WGAllowedIPsMode, dir string) ([]*WGConfig, error) {
+	// Reading a file as a directory fails with a cryptic error, so check it upfront.
+	// Other errors from stat are left for ReadDir to report.
+	if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrWGDirNotDir, dir)
+	}
+
 	files, err := os.ReadDir(dir)
 	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return nil, fmt.Errorf("%w: %s", ErrWGDirUnreadable, dir)
+		}
+
 		return nil, err
 	}
 
-	return parseWGConfigsDir(mode, dir, files)
+	return parseWGConfigsDir(mode, amode, dir, files)
 }
 
-func parseWGConfigsDir(mode WGParseMode, dpath string, files []fs.DirEntry) ([]*WGConfig, error) {
+func parseWGConfigsDir(mode WGParseMode, amode WGAllowedIPsMode, dpath string, files []fs.DirEntry) ([]*WGConfig, error) {
 	result := make([]*WGConfig, 0)
 
 	var errs []error
@@ -189,7 +406,7 @@ func parseWGConfigsDir(mode WGParseMode, dpath string, files []fs.DirEntry) ([]*
 
 		name := files[i].Name()
 
-		cfg, err := ParseWGConfig(filepath.Join(dpath, name))
+		cfg, err := parseWGConfigFile(amode, filepath.Join(dpath, name))
 		if err != nil {
 			if mode == WGParseModeIgnore {
 				continue
@@ -213,7 +430,67 @@ func parseWGConfigsDir(mode WGParseMode, dpath string, files []fs.DirEntry) ([]*
 	return result, nil
 }
 
-func parseWGConfigINI(data []byte) (*WGConfig, error) {
+func parseWGConfigsCombined(mode WGParseMode, amode WGAllowedIPsMode, data []byte) ([]*WGConfig, error) {
+	result := make([]*WGConfig, 0)
+
+	var errs []error
+
+	blocks := splitWGConfigs(data)
+	for i := range blocks {
+		cfg, err := parseWGConfigINI(amode, blocks[i])
+		if err != nil {
+			if mode == WGParseModeIgnore {
+				continue
+			}
+
+			if mode == WGParseModeReport {
+				errs = append(errs, fmt.Errorf("failed to parse config: %d: %w", i, err))
+				continue
+			}
+
+			return nil, err
+		}
+
+		result = append(result, cfg)
+	}
+
+	if len(errs) > 0 {
+		return result, errors.Join(errs...)
+	}
+
+	return result, nil
+}
+
+// splitWGConfigs splits data into blocks separated by WGConfigSeparator, skipping blank ones.
+func splitWGConfigs(data []byte) [][]byte {
+	var result [][]byte
+
+	var block []byte
+
+	flush := func() {
+		if len(bytes.TrimSpace(block)) > 0 {
+			result = append(result, block)
+		}
+
+		block = nil
+	}
+
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if string(bytes.TrimSpace(line)) == WGConfigSeparator {
+			flush()
+
+			continue
+		}
+
+		block = append(block, line...)
+	}
+
+	flush()
+
+	return result
+}
+
+func parseWGConfigINI(amode WGAllowedIPsMode, data []byte) (*WGConfig, error) {
 	f, err := ini.LoadBytes(data)
 	if err != nil {
 		return nil, err
@@ -238,6 +515,15 @@ func parseWGConfigINI(data []byte) (*WGConfig, error) {
 		return nil, ErrInvalidWGIfaceAddr
 	}
 
+	if raw := siface.Get("ListenPort"); raw != "" {
+		port, err := strconv.Atoi(raw)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, ErrInvalidWGListenPort
+		}
+
+		result.Iface.ListenPort = port
+	}
+
 	result.Peer.PublicKey = speer.Get("PublicKey")
 	if result.Peer.PublicKey == "" {
 		return nil, ErrInvalidWGPeerPubKey
@@ -250,7 +536,11 @@ func parseWGConfigINI(data []byte) (*WGConfig, error) {
 
 	result.Peer.AllowedIPs = splitTrimString(speer.Get("AllowedIPs"), ",")
 	if len(result.Peer.AllowedIPs) == 0 {
-		return nil, ErrInvalidWGPeerAllowedIP
+		if amode != WGAllowedIPsFullTunnel {
+			return nil, ErrInvalidWGPeerAllowedIP
+		}
+
+		result.Peer.AllowedIPs = slices.Clone(wgFullTunnelIPs)
 	}
 
 	return result, nil
@@ -336,7 +626,53 @@ func recodeHexToBase64(val string) (string, error) {
 	return base64.StdEncoding.EncodeToString(raw), nil
 }
 
-type wgCreator struct{}
+// hostResolver is implemented by *net.Resolver.
+type hostResolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// resolveEndpoint returns endpoint with its host resolved to an IP address.
+//
+// Endpoints that already contain an IP address are returned as is.
+func resolveEndpoint(ctx context.Context, rsv hostResolver, endpoint string) (string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", ErrInvalidWGPeerEndpoint
+	}
+
+	if _, err := netip.ParseAddr(host); err == nil {
+		return endpoint, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wgResolveTimeout)
+	defer cancel()
+
+	addrs, err := rsv.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrWGEndpointResolve, host, err)
+	}
+
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrWGEndpointResolve, host)
+	}
+
+	return net.JoinHostPort(addrs[0].Unmap().String(), port), nil
+}
+
+type wgCreator struct {
+	// rsv resolves peer endpoints given as hostnames.
+	//
+	// A nil value falls back to net.DefaultResolver.
+	rsv hostResolver
+}
+
+func (c *wgCreator) resolver() hostResolver {
+	if c.rsv == nil {
+		return net.DefaultResolver
+	}
+
+	return c.rsv
+}
 
 func (c *wgCreator) new(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout time.Duration) (*WireGuard, error) {
 	addrs, err := parseIPAddrsFromCIDR(cfg.Iface.Address)
@@ -348,7 +684,26 @@ func (c *wgCreator) new(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout
 		return nil, ErrInvalidWGIfaceAddr
 	}
 
-	pcfg, err := cfg.toProto()
+	endpoint, err := resolveEndpoint(context.Background(), c.resolver(), cfg.Peer.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// The original config is left intact so that its key does not depend on resolution.
+	rcfg := *cfg
+	rcfg.Peer.Endpoint = endpoint
+
+	pcfg, err := rcfg.toProto()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := recodeBase64ToHex(cfg.Peer.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := parseAllowedPrefixes(cfg.Peer.AllowedIPs)
 	if err != nil {
 		return nil, err
 	}
@@ -375,27 +730,136 @@ func (c *wgCreator) new(lg *slog.Logger, cfg *WGConfig, dnsAddr netip.Addr, tout
 		return nil, err
 	}
 
-	wdev := &wgDev{fnDown: dev.Down, fnClose: dev.Close}
-	doer := &http.Client{
-		Timeout: tout,
-		Transport: &http.Transport{
-			DialContext: tnet.DialContext,
-		},
+	wdev := &wgDev{fnSet: dev.IpcSet, fnDown: dev.Down, fnClose: dev.Close}
+	netd := &wgScopedDialer{netd: tnet, rsv: tnet, allowed: allowed}
+	doer := newHTTPClient(tout, netd.DialContext)
+
+	result := newWireGuard(id, wdev, netd, doer)
+	result.key = cfg.Key()
+	result.pubKey = pubKey
+	result.endpoint = cfg.Peer.Endpoint
+	result.rsv = c.rsv
+
+	return result, nil
+}
+
+// wgHostLookuper is implemented by *netstack.Net.
+type wgHostLookuper interface {
+	LookupContextHost(ctx context.Context, host string) ([]string, error)
+}
+
+// wgScopedDialer dials only destinations within the allowed prefixes of the peer.
+//
+// Without it, traffic to any destination goes into the tunnel, and gets dropped by the device
+// when it is out of scope, which looks like a timeout to the client.
+// Hostnames are resolved through the tunnel, and the first allowed address is dialled.
+// The check set with WithDestCheck, if any, is applied to the address before dialling it.
+type wgScopedDialer struct {
+	netd    netDialer
+	rsv     wgHostLookuper
+	allowed []netip.Prefix
+}
+
+func (d *wgScopedDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return d.netd.DialContext(ctx, network, addr)
 	}
 
-	return newWireGuard(id, wdev, tnet, doer), nil
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !d.isAllowed(ip) {
+			return nil, fmt.Errorf("%w: %s", ErrWGDestNotAllowed, host)
+		}
+
+		if err := checkDestIP(ctx, ip); err != nil {
+			return nil, err
+		}
+
+		return d.netd.DialContext(ctx, network, addr)
+	}
+
+	raw, err := d.rsv.LookupContextHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+
+	for i := range raw {
+		ip, err := netip.ParseAddr(raw[i])
+		if err != nil || !d.isAllowed(ip) {
+			continue
+		}
+
+		if err := checkDestIP(ctx, ip); err != nil {
+			lastErr = err
+			continue
+		}
+
+		conn, err := d.netd.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return conn, nil
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrWGDestNotAllowed, host)
+}
+
+func (d *wgScopedDialer) isAllowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+
+	for i := range d.allowed {
+		if d.allowed[i].Contains(ip) {
+			return true
+		}
+	}
+
+	return false
 }
 
-type wgDownCloser interface {
+// parseAllowedPrefixes parses AllowedIPs of a peer into prefixes with host bits cleared.
+func parseAllowedPrefixes(raw []string) ([]netip.Prefix, error) {
+	result := make([]netip.Prefix, 0, len(raw))
+
+	for i := range raw {
+		pfx, err := netip.ParsePrefix(raw[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidWGPeerAllowedIP, raw[i])
+		}
+
+		result = append(result, pfx.Masked())
+	}
+
+	return result, nil
+}
+
+type wgSetDownCloser interface {
+	set(cfg string) error
 	down() error
 	close()
 }
 
 type wgDev struct {
+	fnSet   func(string) error
 	fnDown  func() error
 	fnClose func()
 }
 
+func (d *wgDev) set(cfg string) error {
+	if d.fnSet == nil {
+		return nil
+	}
+
+	return d.fnSet(cfg)
+}
+
 func (d *wgDev) down() error {
 	if d.fnDown == nil {
 		return nil
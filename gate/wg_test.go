@@ -1,17 +1,22 @@
 package gate
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
+	"net"
 	"net/netip"
+	"sync/atomic"
 	"syscall"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/kenshaw/ini"
 	should "github.com/stretchr/testify/assert"
 	must "github.com/stretchr/testify/require"
 
+	"github.com/pavelbrm/pumpe/fakenet"
 	"github.com/pavelbrm/pumpe/model"
 )
 
@@ -51,6 +56,248 @@ func TestWireGuard_close(t *testing.T) {
 	}
 }
 
+func TestWireGuard_refresh(t *testing.T) {
+	type tcExpected struct {
+		cfg        string
+		refreshing uint32
+		err        error
+	}
+
+	tests := []testCase[*WireGuard, tcExpected]{
+		{
+			name: "error_refreshing",
+			given: &WireGuard{
+				baseGate:   newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+				refreshing: &struct{ value uint32 }{value: 1},
+				dev: &wgDev{
+					fnSet: func(cfg string) error {
+						panic("unexpected_set")
+					},
+				},
+			},
+			exp: tcExpected{
+				refreshing: 1,
+				err:        ErrGateIsRefreshing,
+			},
+		},
+
+		{
+			name: "error_resolve",
+			given: &WireGuard{
+				baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+				endpoint: "wg.example.com:51820",
+				rsv: &mockHostResolver{
+					fnLookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+				refreshing: &struct{ value uint32 }{},
+				dev: &wgDev{
+					fnSet: func(cfg string) error {
+						panic("unexpected_set")
+					},
+				},
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %s: %w", ErrWGEndpointResolve, "wg.example.com", model.Error("something_went_wrong")),
+			},
+		},
+
+		{
+			name: "error_set",
+			given: &WireGuard{
+				baseGate:   newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+				endpoint:   "127.0.0.1:58120",
+				refreshing: &struct{ value uint32 }{},
+				dev: &wgDev{
+					fnSet: func(cfg string) error {
+						return model.Error("something_went_wrong")
+					},
+				},
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "success",
+			given: &WireGuard{
+				baseGate: newBaseGateID(KindWireGuard, uuid.MustParse("decade00-0000-4000-a000-000000000000")),
+				pubKey:   "cafe",
+				endpoint: "wg.example.com:51820",
+				rsv: &mockHostResolver{
+					fnLookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+						return []netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil
+					},
+				},
+				refreshing: &struct{ value uint32 }{},
+			},
+			exp: tcExpected{
+				cfg: "public_key=cafe\nendpoint=10.0.0.1:51820\n",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg string
+			if tc.given.dev == nil {
+				tc.given.dev = &wgDev{
+					fnSet: func(val string) error {
+						cfg = val
+
+						return nil
+					},
+				}
+			}
+
+			actual := tc.given.refresh()
+			must.Equal(t, tc.exp.err, actual)
+
+			should.Equal(t, tc.exp.refreshing, atomic.LoadUint32(&tc.given.refreshing.value))
+			should.Equal(t, tc.exp.cfg, cfg)
+		})
+	}
+}
+
+func TestWGConfig_Key(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		cfg, err := ParseWGConfig("./testdata/wg/wg_valid.ini")
+		must.Equal(t, nil, err)
+
+		should.Equal(t, "b9bb1500aa4bb7ceeaf21dc6d2a28317e41509d52f2098d0c0eaae4da777161d", cfg.Key())
+	})
+
+	t.Run("differs_by_endpoint", func(t *testing.T) {
+		cfg1, err := ParseWGConfig("./testdata/wg/wg_valid.ini")
+		must.Equal(t, nil, err)
+
+		cfg2, err := ParseWGConfig("./testdata/wg/wg_valid.ini")
+		must.Equal(t, nil, err)
+
+		should.Equal(t, cfg1.Key(), cfg2.Key())
+
+		cfg2.Peer.Endpoint = "127.0.0.1:58121"
+		should.NotEqual(t, cfg1.Key(), cfg2.Key())
+	})
+
+	t.Run("differs_by_listen_port", func(t *testing.T) {
+		cfg1, err := ParseWGConfig("./testdata/wg/wg_valid.ini")
+		must.Equal(t, nil, err)
+
+		cfg2, err := ParseWGConfig("./testdata/wg/wg_valid.ini")
+		must.Equal(t, nil, err)
+
+		cfg2.Iface.ListenPort = 51820
+		should.NotEqual(t, cfg1.Key(), cfg2.Key())
+	})
+}
+
+func TestDedupWGConfigs(t *testing.T) {
+	newCfg := func(endpoint string) *WGConfig {
+		result := &WGConfig{}
+		result.Iface.PrivateKey = "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k="
+		result.Iface.Address = []string{"10.0.0.2/32"}
+		result.Peer.PublicKey = "xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg="
+		result.Peer.Endpoint = endpoint
+		result.Peer.AllowedIPs = []string{"0.0.0.0/0"}
+
+		return result
+	}
+
+	type tcExpected struct {
+		val  []*WGConfig
+		dups []*WGConfig
+	}
+
+	tests := []testCase[[]*WGConfig, tcExpected]{
+		{
+			name: "empty",
+			exp: tcExpected{
+				val: []*WGConfig{},
+			},
+		},
+
+		{
+			name:  "no_dups",
+			given: []*WGConfig{newCfg("127.0.0.1:58120"), newCfg("127.0.0.1:58121")},
+			exp: tcExpected{
+				val: []*WGConfig{newCfg("127.0.0.1:58120"), newCfg("127.0.0.1:58121")},
+			},
+		},
+
+		{
+			name:  "dups",
+			given: []*WGConfig{newCfg("127.0.0.1:58120"), newCfg("127.0.0.1:58121"), newCfg("127.0.0.1:58120"), newCfg("127.0.0.1:58120")},
+			exp: tcExpected{
+				val:  []*WGConfig{newCfg("127.0.0.1:58120"), newCfg("127.0.0.1:58121")},
+				dups: []*WGConfig{newCfg("127.0.0.1:58120"), newCfg("127.0.0.1:58120")},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual, dups := DedupWGConfigs(tc.given)
+			should.Equal(t, tc.exp.val, actual)
+			should.Equal(t, tc.exp.dups, dups)
+		})
+	}
+}
+
+func TestIgnoreWGListenPorts(t *testing.T) {
+	newCfg := func(port int) *WGConfig {
+		result := &WGConfig{}
+		result.Iface.PrivateKey = "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k="
+		result.Iface.ListenPort = port
+
+		return result
+	}
+
+	type tcExpected struct {
+		val     []*WGConfig
+		ignored []*WGConfig
+	}
+
+	tests := []testCase[[]*WGConfig, tcExpected]{
+		{
+			name: "empty",
+		},
+
+		{
+			name:  "no_ports",
+			given: []*WGConfig{newCfg(0), newCfg(0)},
+			exp: tcExpected{
+				val: []*WGConfig{newCfg(0), newCfg(0)},
+			},
+		},
+
+		{
+			name:  "ports",
+			given: []*WGConfig{newCfg(51820), newCfg(0), newCfg(51821)},
+			exp: tcExpected{
+				val:     []*WGConfig{newCfg(0), newCfg(0), newCfg(0)},
+				ignored: []*WGConfig{newCfg(0), newCfg(0)},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := IgnoreWGListenPorts(tc.given)
+			should.Equal(t, tc.exp.ignored, actual)
+			should.Equal(t, tc.exp.val, tc.given)
+		})
+	}
+}
+
 func TestWGConfig_toProto(t *testing.T) {
 	type tcExpected struct {
 		proto string
@@ -64,6 +311,7 @@ func TestWGConfig_toProto(t *testing.T) {
 				Iface: struct {
 					PrivateKey string
 					Address    []string
+					ListenPort int
 				}{
 					PrivateKey: "aW52YWxpZA==",
 					Address:    []string{"192.168.4.28/32"},
@@ -89,6 +337,7 @@ func TestWGConfig_toProto(t *testing.T) {
 				Iface: struct {
 					PrivateKey string
 					Address    []string
+					ListenPort int
 				}{
 					PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
 					Address:    []string{"192.168.4.28/32"},
@@ -102,34 +351,565 @@ func TestWGConfig_toProto(t *testing.T) {
 					Endpoint:   "127.0.0.1:58120",
 					AllowedIPs: []string{"0.0.0.0/0"},
 				},
-			},
-			exp: tcExpected{
-				err: ErrInvalidWGKey,
+			},
+			exp: tcExpected{
+				err: ErrInvalidWGKey,
+			},
+		},
+
+		{
+			name: "valid",
+			given: &WGConfig{
+				Iface: struct {
+					PrivateKey string
+					Address    []string
+					ListenPort int
+				}{
+					PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
+					Address:    []string{"192.168.4.28/32"},
+				},
+				Peer: struct {
+					PublicKey  string
+					Endpoint   string
+					AllowedIPs []string
+				}{
+					PublicKey:  "xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=",
+					Endpoint:   "127.0.0.1:58120",
+					AllowedIPs: []string{"0.0.0.0/0"},
+				},
+			},
+			exp: tcExpected{
+				proto: "private_key=087ec6e14bbed210e7215cdc73468dfa23f080a1bfb8665b2fd809bd99d28379\npublic_key=c4c8e984c5322c8184c72265b92b250fdb63688705f504ba003c88f03393cf28\nendpoint=127.0.0.1:58120\nallowed_ip=0.0.0.0/0\n",
+			},
+		},
+		{
+			name: "valid_listen_port",
+			given: &WGConfig{
+				Iface: struct {
+					PrivateKey string
+					Address    []string
+					ListenPort int
+				}{
+					PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
+					Address:    []string{"192.168.4.28/32"},
+					ListenPort: 51820,
+				},
+				Peer: struct {
+					PublicKey  string
+					Endpoint   string
+					AllowedIPs []string
+				}{
+					PublicKey:  "xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=",
+					Endpoint:   "127.0.0.1:58120",
+					AllowedIPs: []string{"0.0.0.0/0"},
+				},
+			},
+			exp: tcExpected{
+				proto: "private_key=087ec6e14bbed210e7215cdc73468dfa23f080a1bfb8665b2fd809bd99d28379\nlisten_port=51820\npublic_key=c4c8e984c5322c8184c72265b92b250fdb63688705f504ba003c88f03393cf28\nendpoint=127.0.0.1:58120\nallowed_ip=0.0.0.0/0\n",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := tc.given.toProto()
+			must.Equal(t, tc.exp.err, err)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.proto, actual)
+		})
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	type tcGiven struct {
+		rsv      *mockHostResolver
+		endpoint string
+	}
+
+	type tcExpected struct {
+		val string
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_invalid_endpoint",
+			given: tcGiven{
+				rsv:      &mockHostResolver{},
+				endpoint: "wg.example.com",
+			},
+			exp: tcExpected{
+				err: ErrInvalidWGPeerEndpoint,
+			},
+		},
+
+		{
+			name: "error_lookup",
+			given: tcGiven{
+				rsv: &mockHostResolver{
+					fnLookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+				endpoint: "wg.example.com:51820",
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %s: %w", ErrWGEndpointResolve, "wg.example.com", model.Error("something_went_wrong")),
+			},
+		},
+
+		{
+			name: "error_no_addrs",
+			given: tcGiven{
+				rsv: &mockHostResolver{
+					fnLookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+						return nil, nil
+					},
+				},
+				endpoint: "wg.example.com:51820",
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %s", ErrWGEndpointResolve, "wg.example.com"),
+			},
+		},
+
+		{
+			name: "valid_ipv4_as_is",
+			given: tcGiven{
+				rsv: &mockHostResolver{
+					fnLookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+						panic("unexpected_lookup")
+					},
+				},
+				endpoint: "127.0.0.1:58120",
+			},
+			exp: tcExpected{
+				val: "127.0.0.1:58120",
+			},
+		},
+
+		{
+			name: "valid_ipv6_as_is",
+			given: tcGiven{
+				rsv: &mockHostResolver{
+					fnLookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+						panic("unexpected_lookup")
+					},
+				},
+				endpoint: "[::1]:58120",
+			},
+			exp: tcExpected{
+				val: "[::1]:58120",
+			},
+		},
+
+		{
+			name: "valid_hostname",
+			given: tcGiven{
+				rsv: &mockHostResolver{
+					fnLookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+						if host != "wg.example.com" {
+							return nil, model.Error("unexpected_host")
+						}
+
+						return []netip.Addr{netip.MustParseAddr("::ffff:10.0.0.1"), netip.MustParseAddr("10.0.0.2")}, nil
+					},
+				},
+				endpoint: "wg.example.com:51820",
+			},
+			exp: tcExpected{
+				val: "10.0.0.1:51820",
+			},
+		},
+
+		{
+			name: "valid_hostname_ipv6",
+			given: tcGiven{
+				rsv: &mockHostResolver{
+					fnLookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+						return []netip.Addr{netip.MustParseAddr("2001:db8::1")}, nil
+					},
+				},
+				endpoint: "wg.example.com:51820",
+			},
+			exp: tcExpected{
+				val: "[2001:db8::1]:51820",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := resolveEndpoint(context.Background(), tc.given.rsv, tc.given.endpoint)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.val, actual)
+		})
+	}
+}
+
+func TestWGScopedDialer_DialContext(t *testing.T) {
+	type tcGiven struct {
+		rsv     *mockHostLookuper
+		allowed []netip.Prefix
+		check   func(ip netip.Addr) error
+		addr    string
+	}
+
+	type tcExpected struct {
+		dialed []string
+		err    error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_ip_not_allowed",
+			given: tcGiven{
+				rsv:     &mockHostLookuper{},
+				allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				addr:    "192.168.0.1:443",
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %s", ErrWGDestNotAllowed, "192.168.0.1"),
+			},
+		},
+
+		{
+			name: "error_ipv6_not_allowed",
+			given: tcGiven{
+				rsv:     &mockHostLookuper{},
+				allowed: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")},
+				addr:    "[2001:db8::1]:443",
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %s", ErrWGDestNotAllowed, "2001:db8::1"),
+			},
+		},
+
+		{
+			name: "error_lookup",
+			given: tcGiven{
+				rsv: &mockHostLookuper{
+					fnLookupContextHost: func(ctx context.Context, host string) ([]string, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+				allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				addr:    "example.com:443",
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "error_host_not_allowed",
+			given: tcGiven{
+				rsv: &mockHostLookuper{
+					fnLookupContextHost: func(ctx context.Context, host string) ([]string, error) {
+						return []string{"192.168.0.1", "2001:db8::1"}, nil
+					},
+				},
+				allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				addr:    "example.com:443",
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %s", ErrWGDestNotAllowed, "example.com"),
+			},
+		},
+
+		{
+			name: "error_ip_dest_check",
+			given: tcGiven{
+				rsv:     &mockHostLookuper{},
+				allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				check: func(ip netip.Addr) error {
+					return model.Error("dest_not_allowed")
+				},
+				addr: "10.1.2.3:443",
+			},
+			exp: tcExpected{
+				err: model.Error("dest_not_allowed"),
+			},
+		},
+
+		{
+			name: "error_host_dest_check",
+			given: tcGiven{
+				rsv: &mockHostLookuper{
+					fnLookupContextHost: func(ctx context.Context, host string) ([]string, error) {
+						return []string{"10.0.0.1"}, nil
+					},
+				},
+				allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				check: func(ip netip.Addr) error {
+					return model.Error("dest_not_allowed")
+				},
+				addr: "example.com:443",
+			},
+			exp: tcExpected{
+				err: model.Error("dest_not_allowed"),
+			},
+		},
+
+		{
+			name: "valid_ip_allowed",
+			given: tcGiven{
+				rsv: &mockHostLookuper{
+					fnLookupContextHost: func(ctx context.Context, host string) ([]string, error) {
+						panic("unexpected_lookup")
+					},
+				},
+				allowed: []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16"), netip.MustParsePrefix("10.0.0.0/8")},
+				addr:    "10.1.2.3:443",
+			},
+			exp: tcExpected{
+				dialed: []string{"10.1.2.3:443"},
+			},
+		},
+
+		{
+			name: "valid_host_first_allowed",
+			given: tcGiven{
+				rsv: &mockHostLookuper{
+					fnLookupContextHost: func(ctx context.Context, host string) ([]string, error) {
+						return []string{"192.168.0.1", "10.0.0.1", "10.0.0.2"}, nil
+					},
+				},
+				allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				addr:    "example.com:443",
+			},
+			exp: tcExpected{
+				dialed: []string{"10.0.0.1:443"},
+			},
+		},
+
+		{
+			name: "valid_host_dest_check_skipped",
+			given: tcGiven{
+				rsv: &mockHostLookuper{
+					fnLookupContextHost: func(ctx context.Context, host string) ([]string, error) {
+						return []string{"10.0.0.1", "10.0.0.2"}, nil
+					},
+				},
+				allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				check: func(ip netip.Addr) error {
+					if ip == netip.MustParseAddr("10.0.0.1") {
+						return model.Error("dest_not_allowed")
+					}
+
+					return nil
+				},
+				addr: "example.com:443",
+			},
+			exp: tcExpected{
+				dialed: []string{"10.0.0.2:443"},
+			},
+		},
+
+		{
+			name: "valid_full_tunnel",
+			given: tcGiven{
+				rsv: &mockHostLookuper{
+					fnLookupContextHost: func(ctx context.Context, host string) ([]string, error) {
+						return []string{"2001:db8::1"}, nil
+					},
+				},
+				allowed: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")},
+				addr:    "example.com:443",
+			},
+			exp: tcExpected{
+				dialed: []string{"[2001:db8::1]:443"},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			var dialed []string
+
+			netd := &wgScopedDialer{
+				netd: &MockNetDialer{
+					FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+						dialed = append(dialed, addr)
+
+						return &fakenet.MockConn{}, nil
+					},
+				},
+				rsv:     tc.given.rsv,
+				allowed: tc.given.allowed,
+			}
+
+			ctx := context.Background()
+			if tc.given.check != nil {
+				ctx = WithDestCheck(ctx, tc.given.check)
+			}
+
+			_, err := netd.DialContext(ctx, "tcp", tc.given.addr)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.dialed, dialed)
+		})
+	}
+}
+
+func TestParseAllowedPrefixes(t *testing.T) {
+	type tcExpected struct {
+		val []netip.Prefix
+		err error
+	}
+
+	tests := []testCase[[]string, tcExpected]{
+		{
+			name:  "error_not_cidr",
+			given: []string{"10.0.0.0/8", "192.168.0.1"},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %s", ErrInvalidWGPeerAllowedIP, "192.168.0.1"),
+			},
+		},
+
+		{
+			name:  "valid_masked",
+			given: []string{"10.1.2.3/8", "::/0"},
+			exp: tcExpected{
+				val: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::/0")},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := parseAllowedPrefixes(tc.given)
+			must.Equal(t, tc.exp.err, err)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.val, actual)
+		})
+	}
+}
+
+func TestParseWGConfigs(t *testing.T) {
+	type tcGiven struct {
+		mode WGParseMode
+		dir  string
+	}
+
+	type tcExpected struct {
+		wgs []*WGConfig
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_not_exist",
+			given: tcGiven{
+				dir: "this/path/does/not/exist",
+			},
+			exp: tcExpected{
+				err: &fs.PathError{Op: "open", Path: "this/path/does/not/exist", Err: syscall.ENOENT},
+			},
+		},
+
+		{
+			name: "error_not_dir",
+			given: tcGiven{
+				dir: "./testdata/wg/wg_valid.ini",
+			},
+			exp: tcExpected{
+				err: fmt.Errorf("%w: %s", ErrWGDirNotDir, "./testdata/wg/wg_valid.ini"),
+			},
+		},
+
+		{
+			name: "error_stop",
+			given: tcGiven{
+				mode: WGParseModeStop,
+				dir:  "./testdata/wg",
+			},
+			exp: tcExpected{
+				err: ErrInvalidWGConfig,
+			},
+		},
+
+		{
+			name: "error_report",
+			given: tcGiven{
+				mode: WGParseModeReport,
+				dir:  "./testdata/wg",
+			},
+			exp: tcExpected{
+				wgs: []*WGConfig{
+					{
+						Iface: struct {
+							PrivateKey string
+							Address    []string
+							ListenPort int
+						}{
+							PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
+							Address:    []string{"192.168.4.28/32"},
+						},
+						Peer: struct {
+							PublicKey  string
+							Endpoint   string
+							AllowedIPs []string
+						}{
+							PublicKey:  "xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=",
+							Endpoint:   "127.0.0.1:58120",
+							AllowedIPs: []string{"0.0.0.0/0"},
+						},
+					},
+				},
+				err: errors.Join(
+					fmt.Errorf("failed to parse file: %s: %w", ".invalid_data", ErrInvalidWGConfig),
+					fmt.Errorf("failed to parse file: %s: %w", "wg_invalid_no_iface.ini", ErrInvalidWGConfig),
+					fmt.Errorf("failed to parse file: %s: %w", "wg_invalid_no_peer.ini", ErrInvalidWGConfig),
+				),
 			},
 		},
 
 		{
-			name: "valid",
-			given: &WGConfig{
-				Iface: struct {
-					PrivateKey string
-					Address    []string
-				}{
-					PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
-					Address:    []string{"192.168.4.28/32"},
-				},
-				Peer: struct {
-					PublicKey  string
-					Endpoint   string
-					AllowedIPs []string
-				}{
-					PublicKey:  "xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=",
-					Endpoint:   "127.0.0.1:58120",
-					AllowedIPs: []string{"0.0.0.0/0"},
-				},
+			name: "valid_ignore",
+			given: tcGiven{
+				mode: WGParseModeIgnore,
+				dir:  "./testdata/wg",
 			},
 			exp: tcExpected{
-				proto: "private_key=087ec6e14bbed210e7215cdc73468dfa23f080a1bfb8665b2fd809bd99d28379\npublic_key=c4c8e984c5322c8184c72265b92b250fdb63688705f504ba003c88f03393cf28\nendpoint=127.0.0.1:58120\nallowed_ip=0.0.0.0/0\n",
+				wgs: []*WGConfig{
+					{
+						Iface: struct {
+							PrivateKey string
+							Address    []string
+							ListenPort int
+						}{
+							PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
+							Address:    []string{"192.168.4.28/32"},
+						},
+						Peer: struct {
+							PublicKey  string
+							Endpoint   string
+							AllowedIPs []string
+						}{
+							PublicKey:  "xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=",
+							Endpoint:   "127.0.0.1:58120",
+							AllowedIPs: []string{"0.0.0.0/0"},
+						},
+					},
+				},
 			},
 		},
 	}
@@ -138,22 +918,22 @@ func TestWGConfig_toProto(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			actual, err := tc.given.toProto()
+			actual, err := parseWGConfigs(tc.given.mode, WGAllowedIPsStrict, tc.given.dir)
 			must.Equal(t, tc.exp.err, err)
 
-			if tc.exp.err != nil {
+			if tc.given.mode == WGParseModeStop && tc.exp.err != nil {
 				return
 			}
 
-			should.Equal(t, tc.exp.proto, actual)
+			should.Equal(t, tc.exp.wgs, actual)
 		})
 	}
 }
 
-func TestParseWGConfigs(t *testing.T) {
+func TestParseWGConfigsFile(t *testing.T) {
 	type tcGiven struct {
-		mode WGParseMode
-		dir  string
+		mode  WGParseMode
+		fpath string
 	}
 
 	type tcExpected struct {
@@ -165,7 +945,7 @@ func TestParseWGConfigs(t *testing.T) {
 		{
 			name: "error_not_exist",
 			given: tcGiven{
-				dir: "this/path/does/not/exist",
+				fpath: "this/path/does/not/exist",
 			},
 			exp: tcExpected{
 				err: &fs.PathError{Op: "open", Path: "this/path/does/not/exist", Err: syscall.ENOENT},
@@ -175,8 +955,8 @@ func TestParseWGConfigs(t *testing.T) {
 		{
 			name: "error_stop",
 			given: tcGiven{
-				mode: WGParseModeStop,
-				dir:  "./testdata/wg",
+				mode:  WGParseModeStop,
+				fpath: "./testdata/wg_file/wg_combined.conf",
 			},
 			exp: tcExpected{
 				err: ErrInvalidWGConfig,
@@ -186,8 +966,8 @@ func TestParseWGConfigs(t *testing.T) {
 		{
 			name: "error_report",
 			given: tcGiven{
-				mode: WGParseModeReport,
-				dir:  "./testdata/wg",
+				mode:  WGParseModeReport,
+				fpath: "./testdata/wg_file/wg_combined.conf",
 			},
 			exp: tcExpected{
 				wgs: []*WGConfig{
@@ -195,6 +975,7 @@ func TestParseWGConfigs(t *testing.T) {
 						Iface: struct {
 							PrivateKey string
 							Address    []string
+							ListenPort int
 						}{
 							PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
 							Address:    []string{"192.168.4.28/32"},
@@ -209,11 +990,28 @@ func TestParseWGConfigs(t *testing.T) {
 							AllowedIPs: []string{"0.0.0.0/0"},
 						},
 					},
+					{
+						Iface: struct {
+							PrivateKey string
+							Address    []string
+							ListenPort int
+						}{
+							PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
+							Address:    []string{"192.168.4.29/32"},
+						},
+						Peer: struct {
+							PublicKey  string
+							Endpoint   string
+							AllowedIPs []string
+						}{
+							PublicKey:  "xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=",
+							Endpoint:   "127.0.0.1:58121",
+							AllowedIPs: []string{"0.0.0.0/0"},
+						},
+					},
 				},
 				err: errors.Join(
-					fmt.Errorf("failed to parse file: %s: %w", ".invalid_data", ErrInvalidWGConfig),
-					fmt.Errorf("failed to parse file: %s: %w", "wg_invalid_no_iface.ini", ErrInvalidWGConfig),
-					fmt.Errorf("failed to parse file: %s: %w", "wg_invalid_no_peer.ini", ErrInvalidWGConfig),
+					fmt.Errorf("failed to parse config: %d: %w", 1, ErrInvalidWGConfig),
 				),
 			},
 		},
@@ -221,8 +1019,8 @@ func TestParseWGConfigs(t *testing.T) {
 		{
 			name: "valid_ignore",
 			given: tcGiven{
-				mode: WGParseModeIgnore,
-				dir:  "./testdata/wg",
+				mode:  WGParseModeIgnore,
+				fpath: "./testdata/wg_file/wg_combined.conf",
 			},
 			exp: tcExpected{
 				wgs: []*WGConfig{
@@ -230,6 +1028,7 @@ func TestParseWGConfigs(t *testing.T) {
 						Iface: struct {
 							PrivateKey string
 							Address    []string
+							ListenPort int
 						}{
 							PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
 							Address:    []string{"192.168.4.28/32"},
@@ -244,6 +1043,25 @@ func TestParseWGConfigs(t *testing.T) {
 							AllowedIPs: []string{"0.0.0.0/0"},
 						},
 					},
+					{
+						Iface: struct {
+							PrivateKey string
+							Address    []string
+							ListenPort int
+						}{
+							PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
+							Address:    []string{"192.168.4.29/32"},
+						},
+						Peer: struct {
+							PublicKey  string
+							Endpoint   string
+							AllowedIPs []string
+						}{
+							PublicKey:  "xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=",
+							Endpoint:   "127.0.0.1:58121",
+							AllowedIPs: []string{"0.0.0.0/0"},
+						},
+					},
 				},
 			},
 		},
@@ -253,18 +1071,42 @@ func TestParseWGConfigs(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			actual, err := parseWGConfigs(tc.given.mode, tc.given.dir)
+			actual, err := ParseWGConfigsFile(tc.given.mode, WGAllowedIPsStrict, tc.given.fpath)
 			must.Equal(t, tc.exp.err, err)
 
-			if tc.given.mode == WGParseModeStop && tc.exp.err != nil {
-				return
-			}
-
 			should.Equal(t, tc.exp.wgs, actual)
 		})
 	}
 }
 
+func TestSplitWGConfigs(t *testing.T) {
+	tests := []testCase[[]byte, [][]byte]{
+		{
+			name: "empty",
+		},
+
+		{
+			name:  "single",
+			given: []byte("[Interface]\n"),
+			exp:   [][]byte{[]byte("[Interface]\n")},
+		},
+
+		{
+			name:  "multiple_blank_blocks",
+			given: []byte("---\n[Interface]\n  ---  \n\n---\n[Peer]"),
+			exp:   [][]byte{[]byte("[Interface]\n"), []byte("[Peer]")},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, splitWGConfigs(tc.given))
+		})
+	}
+}
+
 func TestParseWGConfig(t *testing.T) {
 	type tcExpected struct {
 		cfg *WGConfig
@@ -296,6 +1138,7 @@ func TestParseWGConfig(t *testing.T) {
 					Iface: struct {
 						PrivateKey string
 						Address    []string
+						ListenPort int
 					}{
 						PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
 						Address:    []string{"192.168.4.28/32"},
@@ -330,6 +1173,82 @@ func TestParseWGConfig(t *testing.T) {
 	}
 }
 
+func TestValidateWGConfig(t *testing.T) {
+	const (
+		iface = "[Interface]\nPrivateKey = CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=\nAddress = 192.168.4.28/32\n\n"
+		peer  = "[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = 0.0.0.0/0\nEndpoint = 127.0.0.1:58120\n"
+	)
+
+	tests := []testCase[[]byte, error]{
+		{
+			name:  "error_invalid_no_peer",
+			given: []byte(iface),
+			exp:   ErrInvalidWGConfig,
+		},
+
+		{
+			name:  "error_iface_pvt_key",
+			given: []byte("[Interface]\nPrivateKey = not_a_key\nAddress = 192.168.4.28/32\n\n" + peer),
+			exp:   ErrInvalidWGIfacePvtKey,
+		},
+
+		{
+			name:  "error_iface_pvt_key_length",
+			given: []byte("[Interface]\nPrivateKey = Zm9v\nAddress = 192.168.4.28/32\n\n" + peer),
+			exp:   ErrInvalidWGIfacePvtKey,
+		},
+
+		{
+			name:  "error_iface_addr",
+			given: []byte("[Interface]\nPrivateKey = CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=\nAddress = 192.168.4.28\n\n" + peer),
+			exp:   fmt.Errorf("%w: %s", ErrInvalidWGIfaceAddr, "192.168.4.28"),
+		},
+
+		{
+			name:  "error_peer_pub_key",
+			given: []byte(iface + "[Peer]\nPublicKey = not_a_key\nAllowedIPs = 0.0.0.0/0\nEndpoint = 127.0.0.1:58120\n"),
+			exp:   ErrInvalidWGPeerPubKey,
+		},
+
+		{
+			name:  "error_peer_endpoint_no_port",
+			given: []byte(iface + "[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = 0.0.0.0/0\nEndpoint = 127.0.0.1\n"),
+			exp:   fmt.Errorf("%w: %s", ErrInvalidWGPeerEndpoint, "127.0.0.1"),
+		},
+
+		{
+			name:  "error_peer_endpoint_port",
+			given: []byte(iface + "[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = 0.0.0.0/0\nEndpoint = vpn.example.com:70000\n"),
+			exp:   fmt.Errorf("%w: %s", ErrInvalidWGPeerEndpoint, "vpn.example.com:70000"),
+		},
+
+		{
+			name:  "error_peer_allowed_ip",
+			given: []byte(iface + "[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = 0.0.0.0\nEndpoint = 127.0.0.1:58120\n"),
+			exp:   fmt.Errorf("%w: %s", ErrInvalidWGPeerAllowedIP, "0.0.0.0"),
+		},
+
+		{
+			name:  "valid",
+			given: []byte(iface + peer),
+		},
+
+		{
+			name:  "valid_hostname",
+			given: []byte(iface + "[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = 0.0.0.0/0, ::/0\nEndpoint = vpn.example.com:51820\n"),
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := ValidateWGConfig(tc.given)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
 func TestParseWFConfigINI(t *testing.T) {
 	type tcExpected struct {
 		cfg *WGConfig
@@ -406,6 +1325,41 @@ func TestParseWFConfigINI(t *testing.T) {
 			},
 		},
 
+		{
+			name:  "error_invalid_listen_port",
+			given: []byte("[Interface]\nPrivateKey = CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=\nAddress = 192.168.4.28/32\nListenPort = 65536\n\n[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = 0.0.0.0/0\nEndpoint = 127.0.0.1:58120\n"),
+			exp: tcExpected{
+				err: ErrInvalidWGListenPort,
+			},
+		},
+
+		{
+			name:  "valid_listen_port",
+			given: []byte("[Interface]\nPrivateKey = CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=\nAddress = 192.168.4.28/32\nListenPort = 51820\n\n[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = 0.0.0.0/0\nEndpoint = 127.0.0.1:58120\n"),
+			exp: tcExpected{
+				cfg: &WGConfig{
+					Iface: struct {
+						PrivateKey string
+						Address    []string
+						ListenPort int
+					}{
+						PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
+						Address:    []string{"192.168.4.28/32"},
+						ListenPort: 51820,
+					},
+					Peer: struct {
+						PublicKey  string
+						Endpoint   string
+						AllowedIPs []string
+					}{
+						PublicKey:  "xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=",
+						Endpoint:   "127.0.0.1:58120",
+						AllowedIPs: []string{"0.0.0.0/0"},
+					},
+				},
+			},
+		},
+
 		{
 			name:  "valid",
 			given: []byte("[Interface]\nPrivateKey = CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=\nAddress = 192.168.4.28/32\nDNS = 8.8.8.8\n\n[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = 0.0.0.0/0\nEndpoint = 127.0.0.1:58120\n"),
@@ -414,6 +1368,7 @@ func TestParseWFConfigINI(t *testing.T) {
 					Iface: struct {
 						PrivateKey string
 						Address    []string
+						ListenPort int
 					}{
 						PrivateKey: "CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=",
 						Address:    []string{"192.168.4.28/32"},
@@ -436,7 +1391,7 @@ func TestParseWFConfigINI(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			actual, err := parseWGConfigINI(tc.given)
+			actual, err := parseWGConfigINI(WGAllowedIPsStrict, tc.given)
 			must.Equal(t, tc.exp.err, err)
 
 			if tc.exp.err != nil {
@@ -448,6 +1403,68 @@ func TestParseWFConfigINI(t *testing.T) {
 	}
 }
 
+func TestParseWGConfigINI_allowedIPsMode(t *testing.T) {
+	type tcGiven struct {
+		amode WGAllowedIPsMode
+		data  []byte
+	}
+
+	type tcExpected struct {
+		allowed []string
+		err     error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_strict_no_allowed_ips",
+			given: tcGiven{
+				amode: WGAllowedIPsStrict,
+				data:  []byte("[Interface]\nPrivateKey = CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=\nAddress = 192.168.4.28/32\n\n[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nEndpoint = 127.0.0.1:58120\n"),
+			},
+			exp: tcExpected{
+				err: ErrInvalidWGPeerAllowedIP,
+			},
+		},
+
+		{
+			name: "valid_full_tunnel_no_allowed_ips",
+			given: tcGiven{
+				amode: WGAllowedIPsFullTunnel,
+				data:  []byte("[Interface]\nPrivateKey = CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=\nAddress = 192.168.4.28/32\n\n[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = \nEndpoint = 127.0.0.1:58120\n"),
+			},
+			exp: tcExpected{
+				allowed: []string{"0.0.0.0/0", "::/0"},
+			},
+		},
+
+		{
+			name: "valid_full_tunnel_allowed_ips",
+			given: tcGiven{
+				amode: WGAllowedIPsFullTunnel,
+				data:  []byte("[Interface]\nPrivateKey = CH7G4Uu+0hDnIVzcc0aN+iPwgKG/uGZbL9gJvZnSg3k=\nAddress = 192.168.4.28/32\n\n[Peer]\nPublicKey = xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=\nAllowedIPs = 10.0.0.0/8\nEndpoint = 127.0.0.1:58120\n"),
+			},
+			exp: tcExpected{
+				allowed: []string{"10.0.0.0/8"},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := parseWGConfigINI(tc.given.amode, tc.given.data)
+			must.Equal(t, tc.exp.err, err)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.allowed, actual.Peer.AllowedIPs)
+		})
+	}
+}
+
 func TestSplitTrimString(t *testing.T) {
 	type tcGiven struct {
 		raw string
@@ -6,12 +6,20 @@ import (
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+
+	"github.com/pavelbrm/pumpe/gate"
 )
 
-type Health struct{}
+type readier interface {
+	Ready() <-chan struct{}
+}
 
-func NewHealth() *Health {
-	return &Health{}
+type Health struct {
+	rdr readier
+}
+
+func NewHealth(rdr readier) *Health {
+	return &Health{rdr: rdr}
 }
 
 func (h *Health) Status(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -32,6 +40,32 @@ func (h *Health) status(w http.ResponseWriter, r *http.Request, _ httprouter.Par
 	_ = respondWithDataJSON(w, result, http.StatusOK)
 }
 
+// Ready reports whether the service has finished the initial warmup.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	now := time.Now().UTC()
+
+	h.ready(w, r, p, now)
+}
+
+func (h *Health) ready(w http.ResponseWriter, _ *http.Request, _ httprouter.Params, now time.Time) {
+	select {
+	case <-h.rdr.Ready():
+	default:
+		_ = respondWithErrJSON(w, gate.ErrSetNotReady, http.StatusServiceUnavailable)
+		return
+	}
+
+	result := &struct {
+		Status string    `json:"status"`
+		Time   time.Time `json:"time"`
+	}{
+		Status: "ready",
+		Time:   now,
+	}
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
 func respondWithDataJSON(w http.ResponseWriter, data any, code int) error {
 	result, err := json.Marshal(&struct {
 		Data any `json:"data"`
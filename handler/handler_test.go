@@ -50,7 +50,7 @@ func TestHealth_status(t *testing.T) {
 		tc := tests[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			h := NewHealth()
+			h := NewHealth(&mockReadier{})
 
 			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
 			rw := httptest.NewRecorder()
@@ -78,6 +78,76 @@ func TestHealth_status(t *testing.T) {
 	}
 }
 
+func TestHealth_ready(t *testing.T) {
+	type tcExpected struct {
+		code int
+		data *struct {
+			Status string    `json:"status"`
+			Time   time.Time `json:"time"`
+		}
+		err string
+	}
+
+	tests := []testCase[*mockReadier, tcExpected]{
+		{
+			name: "not_ready",
+			given: &mockReadier{
+				fnReady: func() <-chan struct{} { return make(chan struct{}) },
+			},
+			exp: tcExpected{
+				code: http.StatusServiceUnavailable,
+				err:  "gate: set not ready",
+			},
+		},
+
+		{
+			name:  "ready",
+			given: &mockReadier{},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: &struct {
+					Status string    `json:"status"`
+					Time   time.Time `json:"time"`
+				}{
+					Status: "ready",
+					Time:   time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHealth(tc.given)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			rw := httptest.NewRecorder()
+
+			h.ready(rw, req, nil, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			actual := &struct {
+				Data *struct {
+					Status string    `json:"status"`
+					Time   time.Time `json:"time"`
+				} `json:"data"`
+				Error string `json:"error"`
+			}{}
+
+			{
+				err := json.Unmarshal(rw.Body.Bytes(), actual)
+				must.Equal(t, nil, err)
+			}
+
+			should.Equal(t, tc.exp.data, actual.Data)
+			should.Equal(t, tc.exp.err, actual.Error)
+		})
+	}
+}
+
 func TestRspondWithDataJSON(t *testing.T) {
 	type tcGiven struct {
 		data any
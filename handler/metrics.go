@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/pavelbrm/pumpe/metrics"
+)
+
+type metricsWriter interface {
+	WriteTo(w io.Writer) (int64, error)
+}
+
+type Metrics struct {
+	reg metricsWriter
+}
+
+func NewMetrics(reg metricsWriter) *Metrics {
+	return &Metrics{reg: reg}
+}
+
+// Metrics renders the metrics in the OpenMetrics text format.
+func (h *Metrics) Metrics(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = h.reg.WriteTo(w)
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	should "github.com/stretchr/testify/assert"
+
+	"github.com/pavelbrm/pumpe/metrics"
+)
+
+func TestMetrics_Metrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	g := reg.NewGauge("pumpe_test", "Test gauge.")
+	g.Inc()
+
+	h := NewMetrics(reg)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080/v1/_internal/metrics", nil)
+
+	h.Metrics(rw, req, nil)
+
+	should.Equal(t, http.StatusOK, rw.Code)
+	should.Equal(t, metrics.ContentType, rw.Header().Get("Content-Type"))
+	should.Equal(t, "# TYPE pumpe_test gauge\n# HELP pumpe_test Test gauge.\npumpe_test 1\n# EOF\n", rw.Body.String())
+}
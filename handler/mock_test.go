@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -10,51 +11,84 @@ import (
 )
 
 type mockPumpeSvc struct {
-	fnHandleConnect func(ctx context.Context, w http.ResponseWriter, r *http.Request) error
-	fnHandleHTTP    func(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	fnHandleConnect func(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error)
+	fnHandleHTTP    func(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error)
 }
 
-func (s *mockPumpeSvc) HandleConnect(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+func (s *mockPumpeSvc) HandleConnect(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
 	if s.fnHandleConnect == nil {
-		return nil
+		return &gate.MockExitGate{}, nil
 	}
 
 	return s.fnHandleConnect(ctx, w, r)
 }
 
-func (s *mockPumpeSvc) HandleHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+func (s *mockPumpeSvc) HandleHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
 	if s.fnHandleHTTP == nil {
-		return nil
+		return &gate.MockExitGate{}, nil
 	}
 
 	return s.fnHandleHTTP(ctx, w, r)
 }
 
 type mockProxySvc struct {
-	fnGates   func(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uuid.UUID }, error)
-	fnCreate  func(ctx context.Context, kind gate.Kind) (uuid.UUID, error)
-	fnRefresh func(ctx context.Context, id uuid.UUID) error
-	fnStop    func(ctx context.Context, id uuid.UUID) error
+	fnGates        func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }, error)
+	fnGatesVerbose func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }, error)
+	fnGate         func(ctx context.Context, id uuid.UUID) (gate.GateInfo, error)
+	fnCreate       func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error)
+	fnRefresh      func(ctx context.Context, id uuid.UUID) error
+	fnAnnotate     func(ctx context.Context, id uuid.UUID, annots map[string]string) (map[string]string, error)
+	fnProbe        func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error)
+	fnStop         func(ctx context.Context, id uuid.UUID) error
+	fnForceStop    func(ctx context.Context, id uuid.UUID) error
+	fnStopIdle     func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error)
+	fnScaleTors    func(ctx context.Context, n int) (*gate.ScaleResult, error)
+	fnReload       func(ctx context.Context) (*gate.ReloadResult, error)
+	fnConfig       func(ctx context.Context) *gate.ConfigView
+	fnStats        func(ctx context.Context) gate.SetStats
+	fnPause        func(ctx context.Context) error
+	fnResume       func(ctx context.Context) error
+	fnGroups       func(ctx context.Context) map[string][]uuid.UUID
+	fnSetGroup     func(ctx context.Context, name string, ids []uuid.UUID) error
+	fnDeleteGroup  func(ctx context.Context, name string) error
 }
 
-func (s *mockProxySvc) Gates(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uuid.UUID }, error) {
+func (s *mockProxySvc) Gates(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }, error) {
 	if s.fnGates == nil {
 		return &struct {
-			Direct    []uuid.UUID
-			Tor       []uuid.UUID
-			WireGuard []uuid.UUID
+			Direct       []uuid.UUID
+			Tor          []uuid.UUID
+			TorWireGuard []uuid.UUID
+			WireGuard    []uuid.UUID
+			OpenVPN      []uuid.UUID
 		}{}, nil
 	}
 
 	return s.fnGates(ctx)
 }
 
-func (s *mockProxySvc) Create(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
+func (s *mockProxySvc) GatesVerbose(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }, error) {
+	if s.fnGatesVerbose == nil {
+		return &struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }{}, nil
+	}
+
+	return s.fnGatesVerbose(ctx)
+}
+
+func (s *mockProxySvc) Gate(ctx context.Context, id uuid.UUID) (gate.GateInfo, error) {
+	if s.fnGate == nil {
+		return gate.GateInfo{ID: id, Kind: gate.KindTor}, nil
+	}
+
+	return s.fnGate(ctx, id)
+}
+
+func (s *mockProxySvc) Create(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
 	if s.fnCreate == nil {
-		return uuid.MustParse("f100ded0-0000-4000-a000-000000000000"), nil
+		return kind, []uuid.UUID{uuid.MustParse("f100ded0-0000-4000-a000-000000000000")}, nil
 	}
 
-	return s.fnCreate(ctx, kind)
+	return s.fnCreate(ctx, kind, n)
 }
 
 func (s *mockProxySvc) Refresh(ctx context.Context, id uuid.UUID) error {
@@ -65,6 +99,22 @@ func (s *mockProxySvc) Refresh(ctx context.Context, id uuid.UUID) error {
 	return s.fnRefresh(ctx, id)
 }
 
+func (s *mockProxySvc) Annotate(ctx context.Context, id uuid.UUID, annots map[string]string) (map[string]string, error) {
+	if s.fnAnnotate == nil {
+		return annots, nil
+	}
+
+	return s.fnAnnotate(ctx, id, annots)
+}
+
+func (s *mockProxySvc) Probe(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+	if s.fnProbe == nil {
+		return &gate.ProbeResult{Status: 200}, nil
+	}
+
+	return s.fnProbe(ctx, id, rawURL)
+}
+
 func (s *mockProxySvc) Stop(ctx context.Context, id uuid.UUID) error {
 	if s.fnStop == nil {
 		return nil
@@ -72,3 +122,106 @@ func (s *mockProxySvc) Stop(ctx context.Context, id uuid.UUID) error {
 
 	return s.fnStop(ctx, id)
 }
+
+func (s *mockProxySvc) ForceStop(ctx context.Context, id uuid.UUID) error {
+	if s.fnForceStop == nil {
+		return nil
+	}
+
+	return s.fnForceStop(ctx, id)
+}
+
+func (s *mockProxySvc) StopIdle(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+	if s.fnStopIdle == nil {
+		return nil, nil
+	}
+
+	return s.fnStopIdle(ctx, kind, minIdle)
+}
+
+func (s *mockProxySvc) ScaleTors(ctx context.Context, n int) (*gate.ScaleResult, error) {
+	if s.fnScaleTors == nil {
+		return &gate.ScaleResult{}, nil
+	}
+
+	return s.fnScaleTors(ctx, n)
+}
+
+func (s *mockProxySvc) Reload(ctx context.Context) (*gate.ReloadResult, error) {
+	if s.fnReload == nil {
+		return &gate.ReloadResult{}, nil
+	}
+
+	return s.fnReload(ctx)
+}
+
+func (s *mockProxySvc) Config(ctx context.Context) *gate.ConfigView {
+	if s.fnConfig == nil {
+		return &gate.ConfigView{Default: gate.KindTor, TorMax: 10}
+	}
+
+	return s.fnConfig(ctx)
+}
+
+func (s *mockProxySvc) Stats(ctx context.Context) gate.SetStats {
+	if s.fnStats == nil {
+		return gate.SetStats{}
+	}
+
+	return s.fnStats(ctx)
+}
+
+func (s *mockProxySvc) Pause(ctx context.Context) error {
+	if s.fnPause == nil {
+		return nil
+	}
+
+	return s.fnPause(ctx)
+}
+
+func (s *mockProxySvc) Resume(ctx context.Context) error {
+	if s.fnResume == nil {
+		return nil
+	}
+
+	return s.fnResume(ctx)
+}
+
+func (s *mockProxySvc) Groups(ctx context.Context) map[string][]uuid.UUID {
+	if s.fnGroups == nil {
+		return nil
+	}
+
+	return s.fnGroups(ctx)
+}
+
+func (s *mockProxySvc) SetGroup(ctx context.Context, name string, ids []uuid.UUID) error {
+	if s.fnSetGroup == nil {
+		return nil
+	}
+
+	return s.fnSetGroup(ctx, name, ids)
+}
+
+func (s *mockProxySvc) DeleteGroup(ctx context.Context, name string) error {
+	if s.fnDeleteGroup == nil {
+		return nil
+	}
+
+	return s.fnDeleteGroup(ctx, name)
+}
+
+type mockReadier struct {
+	fnReady func() <-chan struct{}
+}
+
+func (r *mockReadier) Ready() <-chan struct{} {
+	if r.fnReady == nil {
+		result := make(chan struct{})
+		close(result)
+
+		return result
+	}
+
+	return r.fnReady()
+}
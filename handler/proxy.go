@@ -1,12 +1,18 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -16,10 +22,25 @@ import (
 )
 
 type proxySvc interface {
-	Gates(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uuid.UUID }, error)
-	Create(ctx context.Context, kind gate.Kind) (uuid.UUID, error)
+	Gates(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }, error)
+	GatesVerbose(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }, error)
+	Gate(ctx context.Context, id uuid.UUID) (gate.GateInfo, error)
+	Create(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error)
 	Refresh(ctx context.Context, id uuid.UUID) error
+	Annotate(ctx context.Context, id uuid.UUID, annots map[string]string) (map[string]string, error)
+	Probe(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error)
 	Stop(ctx context.Context, id uuid.UUID) error
+	ForceStop(ctx context.Context, id uuid.UUID) error
+	StopIdle(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error)
+	ScaleTors(ctx context.Context, n int) (*gate.ScaleResult, error)
+	Reload(ctx context.Context) (*gate.ReloadResult, error)
+	Config(ctx context.Context) *gate.ConfigView
+	Stats(ctx context.Context) gate.SetStats
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+	Groups(ctx context.Context) map[string][]uuid.UUID
+	SetGroup(ctx context.Context, name string, ids []uuid.UUID) error
+	DeleteGroup(ctx context.Context, name string) error
 }
 
 type Proxy struct {
@@ -39,6 +60,12 @@ func NewProxy(lg *slog.Logger, svc proxySvc) *Proxy {
 }
 
 func (h *Proxy) List(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); verbose {
+		h.listVerbose(w, r)
+
+		return
+	}
+
 	lg := h.lg.With(slog.String("handler.method", "list"))
 
 	ctx := r.Context()
@@ -69,13 +96,17 @@ func (h *Proxy) List(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 	lg.LogAttrs(ctx, slog.LevelInfo, "fetched gate ids")
 
 	result := &struct {
-		Direct    []uuid.UUID `json:"direct"`
-		Tor       []uuid.UUID `json:"tor"`
-		WireGuard []uuid.UUID `json:"wireguard"`
+		Direct       []uuid.UUID `json:"direct"`
+		Tor          []uuid.UUID `json:"tor"`
+		TorWireGuard []uuid.UUID `json:"tor_wireguard"`
+		WireGuard    []uuid.UUID `json:"wireguard"`
+		OpenVPN      []uuid.UUID `json:"openvpn"`
 	}{
-		Direct:    gids.Direct,
-		Tor:       gids.Tor,
-		WireGuard: gids.WireGuard,
+		Direct:       gids.Direct,
+		Tor:          gids.Tor,
+		TorWireGuard: gids.TorWireGuard,
+		WireGuard:    gids.WireGuard,
+		OpenVPN:      gids.OpenVPN,
 	}
 
 	// Non-Go clients might not understand Go JSON encoding rules.
@@ -87,13 +118,125 @@ func (h *Proxy) List(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 		result.Tor = []uuid.UUID{}
 	}
 
+	if result.TorWireGuard == nil {
+		result.TorWireGuard = []uuid.UUID{}
+	}
+
 	if result.WireGuard == nil {
 		result.WireGuard = []uuid.UUID{}
 	}
 
+	if result.OpenVPN == nil {
+		result.OpenVPN = []uuid.UUID{}
+	}
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
+// listVerbose responds with details of all gates.
+func (h *Proxy) listVerbose(w http.ResponseWriter, r *http.Request) {
+	lg := h.lg.With(slog.String("handler.method", "list_verbose"))
+
+	ctx := r.Context()
+
+	by := r.URL.Query().Get("sort")
+	if !isValidGateSort(by) {
+		lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "sort"))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidParam, http.StatusBadRequest)
+		return
+	}
+
+	infos, err := h.svc.GatesVerbose(ctx)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+			lg.LogAttrs(ctx, slog.LevelError, "cancelled request", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, model.StatusClientClosedConn)
+			return
+
+		case errors.Is(err, gate.ErrKindUnknown):
+			lg.LogAttrs(ctx, slog.LevelError, "requested unknown gate kind", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not fetch gate details", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "fetched gate details")
+
+	result := &struct {
+		Direct       []gateInfo `json:"direct"`
+		Tor          []gateInfo `json:"tor"`
+		TorWireGuard []gateInfo `json:"tor_wireguard"`
+		WireGuard    []gateInfo `json:"wireguard"`
+		OpenVPN      []gateInfo `json:"openvpn"`
+	}{
+		Direct:       newGateInfos(sortGateInfos(infos.Direct, by)),
+		Tor:          newGateInfos(sortGateInfos(infos.Tor, by)),
+		TorWireGuard: newGateInfos(sortGateInfos(infos.TorWireGuard, by)),
+		WireGuard:    newGateInfos(sortGateInfos(infos.WireGuard, by)),
+		OpenVPN:      newGateInfos(sortGateInfos(infos.OpenVPN, by)),
+	}
+
 	_ = respondWithDataJSON(w, result, http.StatusOK)
 }
 
+// Get responds with details of a single gate.
+func (h *Proxy) Get(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lg := h.lg.With(slog.String("handler.method", "get"))
+
+	ctx := r.Context()
+
+	gid := p.ByName("id")
+	if gid == "" {
+		lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "id"))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidParam, http.StatusBadRequest)
+		return
+	}
+
+	id, err := uuid.Parse(gid)
+	if err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to parse uuid", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidUUID, http.StatusBadRequest)
+		return
+	}
+
+	info, err := h.svc.Gate(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, gate.ErrGateNotFound):
+			lg.LogAttrs(ctx, slog.LevelError, "requested gate not found", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusNotFound)
+			return
+
+		case errors.Is(err, gate.ErrKindNotSupported):
+			lg.LogAttrs(ctx, slog.LevelError, "requested unsupported gate kind", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusUnprocessableEntity)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not fetch gate details", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_ = respondWithDataJSON(w, newGateInfo(info), http.StatusOK)
+}
+
 func (h *Proxy) Create(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	lg := h.lg.With(slog.String("handler.method", "create"))
 
@@ -107,18 +250,40 @@ func (h *Proxy) Create(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 		return
 	}
 
+	// A missing count means a single gate.
 	req := &struct {
-		Kind gate.Kind `json:"kind"`
+		Kind  gate.Kind `json:"kind"`
+		Count *int      `json:"count"`
 	}{}
-	if err := json.Unmarshal(raw, req); err != nil {
+	if err := decodeStrict(raw, req); err != nil {
 		lg.LogAttrs(ctx, slog.LevelError, "failed to parse request", slog.Any("error", err))
 
 		_ = respondWithErrJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
-	id, err := h.svc.Create(ctx, req.Kind)
-	if err != nil {
+	if req.Kind == "" {
+		lg.LogAttrs(ctx, slog.LevelError, "missing param", slog.String("param.name", "kind"))
+
+		_ = respondWithErrJSON(w, model.ErrMissingParam, http.StatusBadRequest)
+		return
+	}
+
+	count := 1
+	if req.Count != nil {
+		count = *req.Count
+	}
+
+	// Each gate is a process, so the count must not go beyond what the set can hold anyway.
+	if count <= 0 || count > h.svc.Config(ctx).TorMax {
+		lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "count"))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidParam, http.StatusBadRequest)
+		return
+	}
+
+	kind, ids, err := h.svc.Create(ctx, req.Kind, count)
+	if err != nil && len(ids) == 0 {
 		switch {
 		case errors.Is(err, context.Canceled):
 			lg.LogAttrs(ctx, slog.LevelError, "cancelled request", slog.Any("error", err))
@@ -144,6 +309,18 @@ func (h *Proxy) Create(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 			_ = respondWithErrJSON(w, err, http.StatusConflict)
 			return
 
+		case errors.Is(err, gate.ErrTorStartFailed):
+			lg.LogAttrs(ctx, slog.LevelError, "tor failed to start", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			return
+
+		case errors.Is(err, gate.ErrNetUnreachable):
+			lg.LogAttrs(ctx, slog.LevelError, "network unreachable", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			return
+
 		default:
 			lg.LogAttrs(ctx, slog.LevelError, "could not create new gate", slog.Any("error", err))
 
@@ -152,12 +329,37 @@ func (h *Proxy) Create(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 		}
 	}
 
-	lg.LogAttrs(ctx, slog.LevelInfo, "created new gate", slog.String("kind", req.Kind.String()), slog.String("id", id.String()))
+	if count == 1 {
+		lg.LogAttrs(ctx, slog.LevelInfo, "created new gate", slog.String("kind", kind.String()), slog.String("id", ids[0].String()))
+
+		result := &struct {
+			ID   uuid.UUID `json:"id"`
+			Kind gate.Kind `json:"kind"`
+		}{
+			ID:   ids[0],
+			Kind: kind,
+		}
+
+		_ = respondWithDataJSON(w, result, http.StatusCreated)
+		return
+	}
 
 	result := &struct {
-		ID uuid.UUID `json:"id"`
+		IDs   []uuid.UUID `json:"ids"`
+		Kind  gate.Kind   `json:"kind"`
+		Error string      `json:"error,omitempty"`
 	}{
-		ID: id,
+		IDs:  ids,
+		Kind: kind,
+	}
+
+	// Report partial success.
+	if err != nil {
+		lg.LogAttrs(ctx, slog.LevelWarn, "created fewer gates than requested", slog.String("kind", kind.String()), slog.Int("count", len(ids)), slog.Any("error", err))
+
+		result.Error = err.Error()
+	} else {
+		lg.LogAttrs(ctx, slog.LevelInfo, "created new gates", slog.String("kind", kind.String()), slog.Int("count", len(ids)))
 	}
 
 	_ = respondWithDataJSON(w, result, http.StatusCreated)
@@ -184,6 +386,20 @@ func (h *Proxy) Refresh(w http.ResponseWriter, r *http.Request, p httprouter.Par
 		return
 	}
 
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to read request", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// A request with a body changes annotations, and one without refreshes the gate.
+	if len(bytes.TrimSpace(raw)) > 0 {
+		h.annotate(w, r, id, raw)
+		return
+	}
+
 	if err := h.svc.Refresh(ctx, id); err != nil {
 		switch {
 		case errors.Is(err, context.Canceled):
@@ -222,6 +438,18 @@ func (h *Proxy) Refresh(w http.ResponseWriter, r *http.Request, p httprouter.Par
 			_ = respondWithErrJSON(w, err, http.StatusConflict)
 			return
 
+		case errors.Is(err, gate.ErrGateBusy):
+			lg.LogAttrs(ctx, slog.LevelError, "gate is busy", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusConflict)
+			return
+
+		case errors.Is(err, gate.ErrNetUnreachable):
+			lg.LogAttrs(ctx, slog.LevelError, "network unreachable", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			return
+
 		default:
 			lg.LogAttrs(ctx, slog.LevelError, "could not refresh gate", slog.Any("error", err))
 
@@ -235,8 +463,77 @@ func (h *Proxy) Refresh(w http.ResponseWriter, r *http.Request, p httprouter.Par
 	_ = respondWithJSON(w, h.empty, http.StatusOK)
 }
 
-func (h *Proxy) Stop(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	lg := h.lg.With(slog.String("handler.method", "stop"))
+// annotate merges the annotations in raw into those of the gate, and responds with the result.
+func (h *Proxy) annotate(w http.ResponseWriter, r *http.Request, id uuid.UUID, raw []byte) {
+	lg := h.lg.With(slog.String("handler.method", "annotate"))
+
+	ctx := r.Context()
+
+	req := &struct {
+		Annotations map[string]string `json:"annotations"`
+	}{}
+	if err := decodeStrict(raw, req); err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to parse request", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if req.Annotations == nil {
+		lg.LogAttrs(ctx, slog.LevelError, "missing param", slog.String("param.name", "annotations"))
+
+		_ = respondWithErrJSON(w, model.ErrMissingParam, http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := req.Annotations[""]; ok {
+		lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "annotations"))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidParam, http.StatusBadRequest)
+		return
+	}
+
+	annots, err := h.svc.Annotate(ctx, id, req.Annotations)
+	if err != nil {
+		switch {
+		case errors.Is(err, gate.ErrGateNotFound):
+			lg.LogAttrs(ctx, slog.LevelError, "requested gate not found", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusNotFound)
+			return
+
+		case errors.Is(err, gate.ErrTooManyAnnotations):
+			lg.LogAttrs(ctx, slog.LevelError, "too many annotations", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusUnprocessableEntity)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not annotate gate", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "annotated gate", slog.String("id", id.String()))
+
+	if annots == nil {
+		annots = make(map[string]string)
+	}
+
+	result := &struct {
+		Annotations map[string]string `json:"annotations"`
+	}{
+		Annotations: annots,
+	}
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
+// Probe sends a GET request to the given url via the gate, and responds with the status and latency.
+func (h *Proxy) Probe(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lg := h.lg.With(slog.String("handler.method", "probe"))
 
 	ctx := r.Context()
 
@@ -256,7 +553,33 @@ func (h *Proxy) Stop(w http.ResponseWriter, r *http.Request, p httprouter.Params
 		return
 	}
 
-	if err := h.svc.Stop(ctx, id); err != nil {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to read request", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	req := &struct {
+		URL string `json:"url"`
+	}{}
+	if err := decodeStrict(raw, req); err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to parse request", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if req.URL == "" {
+		lg.LogAttrs(ctx, slog.LevelError, "missing param", slog.String("param.name", "url"))
+
+		_ = respondWithErrJSON(w, model.ErrMissingParam, http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.svc.Probe(ctx, id, req.URL)
+	if err != nil {
 		switch {
 		case errors.Is(err, context.Canceled):
 			lg.LogAttrs(ctx, slog.LevelError, "cancelled request", slog.Any("error", err))
@@ -270,10 +593,10 @@ func (h *Proxy) Stop(w http.ResponseWriter, r *http.Request, p httprouter.Params
 			_ = respondWithErrJSON(w, err, http.StatusGatewayTimeout)
 			return
 
-		case errors.Is(err, gate.ErrSetIsShutting):
-			lg.LogAttrs(ctx, slog.LevelError, "service is shutting down", slog.Any("error", err))
+		case errors.Is(err, gate.ErrInvalidProbeURL):
+			lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "url"))
 
-			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			_ = respondWithErrJSON(w, err, http.StatusBadRequest)
 			return
 
 		case errors.Is(err, gate.ErrGateNotFound):
@@ -282,21 +605,775 @@ func (h *Proxy) Stop(w http.ResponseWriter, r *http.Request, p httprouter.Params
 			_ = respondWithErrJSON(w, err, http.StatusNotFound)
 			return
 
-		case errors.Is(err, gate.ErrKindNotSupported):
-			lg.LogAttrs(ctx, slog.LevelError, "requested unsupported gate kind", slog.Any("error", err))
+		case errors.Is(err, gate.ErrGateNotReady):
+			lg.LogAttrs(ctx, slog.LevelError, "gate not ready", slog.Any("error", err))
 
-			_ = respondWithErrJSON(w, err, http.StatusUnprocessableEntity)
+			_ = respondWithErrJSON(w, err, http.StatusConflict)
+			return
+
+		case errors.Is(err, gate.ErrProbeDestNotAllowed):
+			lg.LogAttrs(ctx, slog.LevelError, "probe destination not allowed", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, gate.ErrProbeDestNotAllowed, http.StatusForbidden)
 			return
 
+		// The target could not be reached via the gate.
 		default:
-			lg.LogAttrs(ctx, slog.LevelError, "could not stop gate", slog.Any("error", err))
+			lg.LogAttrs(ctx, slog.LevelError, "could not probe gate", slog.Any("error", err))
 
-			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
 			return
 		}
 	}
 
-	lg.LogAttrs(ctx, slog.LevelInfo, "stopped gate", slog.String("id", id.String()))
+	lg.LogAttrs(ctx, slog.LevelInfo, "probed gate", slog.String("id", id.String()), slog.Int("status", res.Status), slog.Duration("latency", res.Latency))
 
-	_ = respondWithJSON(w, h.empty, http.StatusOK)
+	result := &struct {
+		Status  int    `json:"status"`
+		Latency string `json:"latency"`
+	}{
+		Status:  res.Status,
+		Latency: res.Latency.String(),
+	}
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
+func (h *Proxy) Stop(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lg := h.lg.With(slog.String("handler.method", "stop"))
+
+	ctx := r.Context()
+
+	gid := p.ByName("id")
+	if gid == "" {
+		lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "id"))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidParam, http.StatusBadRequest)
+		return
+	}
+
+	id, err := uuid.Parse(gid)
+	if err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to parse uuid", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidUUID, http.StatusBadRequest)
+		return
+	}
+
+	// With force, the gate is closed without waiting for its requests to finish.
+	fnStop := h.svc.Stop
+	if force, _ := strconv.ParseBool(r.URL.Query().Get("force")); force {
+		fnStop = h.svc.ForceStop
+	}
+
+	if err := fnStop(ctx, id); err != nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+			lg.LogAttrs(ctx, slog.LevelError, "cancelled request", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, model.StatusClientClosedConn)
+			return
+
+		case errors.Is(err, context.DeadlineExceeded):
+			lg.LogAttrs(ctx, slog.LevelError, "request timed out", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusGatewayTimeout)
+			return
+
+		case errors.Is(err, gate.ErrSetIsShutting):
+			lg.LogAttrs(ctx, slog.LevelError, "service is shutting down", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			return
+
+		case errors.Is(err, gate.ErrGateNotFound):
+			lg.LogAttrs(ctx, slog.LevelError, "requested gate not found", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusNotFound)
+			return
+
+		case errors.Is(err, gate.ErrKindNotSupported):
+			lg.LogAttrs(ctx, slog.LevelError, "requested unsupported gate kind", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusUnprocessableEntity)
+			return
+
+		case errors.Is(err, gate.ErrGateBusy):
+			lg.LogAttrs(ctx, slog.LevelError, "gate is busy", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusConflict)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not stop gate", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "stopped gate", slog.String("id", id.String()))
+
+	_ = respondWithJSON(w, h.empty, http.StatusOK)
+}
+
+// StopIdle stops the gates that have had no requests for at least the duration in the idle query param.
+//
+// The kind query param defaults to tor.
+func (h *Proxy) StopIdle(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	lg := h.lg.With(slog.String("handler.method", "stop_idle"))
+
+	ctx := r.Context()
+
+	query := r.URL.Query()
+
+	minIdle, err := time.ParseDuration(query.Get("idle"))
+	if err != nil || minIdle <= 0 {
+		lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "idle"))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidParam, http.StatusBadRequest)
+		return
+	}
+
+	kind := gate.KindTor
+	if raw := query.Get("kind"); raw != "" {
+		kind, err = gate.ParseKind(raw)
+		if err != nil {
+			lg.LogAttrs(ctx, slog.LevelError, "requested unknown gate kind", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ids, err := h.svc.StopIdle(ctx, kind, minIdle)
+	if err != nil && len(ids) == 0 {
+		switch {
+		case errors.Is(err, context.Canceled):
+			lg.LogAttrs(ctx, slog.LevelError, "cancelled request", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, model.StatusClientClosedConn)
+			return
+
+		case errors.Is(err, gate.ErrSetIsShutting):
+			lg.LogAttrs(ctx, slog.LevelError, "service is shutting down", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			return
+
+		case errors.Is(err, gate.ErrKindNotSupported):
+			lg.LogAttrs(ctx, slog.LevelError, "requested unsupported gate kind", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusUnprocessableEntity)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not stop idle gates", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result := &struct {
+		IDs    []uuid.UUID `json:"ids"`
+		Errors []string    `json:"errors,omitempty"`
+	}{
+		IDs: ids,
+	}
+
+	// Non-Go clients might not understand Go JSON encoding rules.
+	if result.IDs == nil {
+		result.IDs = []uuid.UUID{}
+	}
+
+	// Report partial success.
+	if err != nil {
+		result.Errors = errStrings(err)
+
+		lg.LogAttrs(ctx, slog.LevelWarn, "stopped idle gates with errors", slog.Any("error", err))
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "stopped idle gates", slog.String("kind", kind.String()), slog.Int("count", len(ids)))
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
+func (h *Proxy) Reload(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	lg := h.lg.With(slog.String("handler.method", "reload"))
+
+	ctx := r.Context()
+
+	res, err := h.svc.Reload(ctx)
+	if err != nil && res == nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+			lg.LogAttrs(ctx, slog.LevelError, "cancelled request", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, model.StatusClientClosedConn)
+			return
+
+		case errors.Is(err, gate.ErrSetIsShutting):
+			lg.LogAttrs(ctx, slog.LevelError, "service is shutting down", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			return
+
+		case errors.Is(err, gate.ErrSetIsReloading):
+			lg.LogAttrs(ctx, slog.LevelError, "service is reloading", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusConflict)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not reload gates", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result := &struct {
+		Added     []uuid.UUID `json:"added"`
+		Removed   []uuid.UUID `json:"removed"`
+		Unchanged int         `json:"unchanged"`
+		Errors    []string    `json:"errors,omitempty"`
+	}{
+		Added:     res.Added,
+		Removed:   res.Removed,
+		Unchanged: res.Unchanged,
+	}
+
+	// Non-Go clients might not understand Go JSON encoding rules.
+	if result.Added == nil {
+		result.Added = []uuid.UUID{}
+	}
+
+	if result.Removed == nil {
+		result.Removed = []uuid.UUID{}
+	}
+
+	// Report partial success.
+	if err != nil {
+		result.Errors = errStrings(err)
+
+		lg.LogAttrs(ctx, slog.LevelWarn, "reloaded gates with errors", slog.Any("error", err))
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "reloaded gates", slog.Int("added", len(res.Added)), slog.Int("removed", len(res.Removed)), slog.Int("unchanged", res.Unchanged))
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
+// ScaleTors creates or stops Tor gates until there are as many as the count in the request.
+func (h *Proxy) ScaleTors(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	lg := h.lg.With(slog.String("handler.method", "scale_tors"))
+
+	ctx := r.Context()
+
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to read request", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// A missing count must not be taken for zero, which would stop all gates.
+	req := &struct {
+		Count *int `json:"count"`
+	}{}
+	if err := decodeStrict(raw, req); err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to parse request", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if req.Count == nil {
+		lg.LogAttrs(ctx, slog.LevelError, "missing param", slog.String("param.name", "count"))
+
+		_ = respondWithErrJSON(w, model.ErrMissingParam, http.StatusBadRequest)
+		return
+	}
+
+	if *req.Count < 0 {
+		lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "count"))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidParam, http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.svc.ScaleTors(ctx, *req.Count)
+	if err != nil && res == nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+			lg.LogAttrs(ctx, slog.LevelError, "cancelled request", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, model.StatusClientClosedConn)
+			return
+
+		case errors.Is(err, gate.ErrSetIsShutting):
+			lg.LogAttrs(ctx, slog.LevelError, "service is shutting down", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			return
+
+		case errors.Is(err, gate.ErrTorMaxReached):
+			lg.LogAttrs(ctx, slog.LevelError, "reached maximum number of tor gates", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusConflict)
+			return
+
+		case errors.Is(err, gate.ErrTorStartFailed):
+			lg.LogAttrs(ctx, slog.LevelError, "tor failed to start", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not scale gates", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result := &struct {
+		Created []uuid.UUID `json:"created"`
+		Stopped []uuid.UUID `json:"stopped"`
+		Errors  []string    `json:"errors,omitempty"`
+	}{
+		Created: res.Created,
+		Stopped: res.Closed,
+	}
+
+	// Non-Go clients might not understand Go JSON encoding rules.
+	if result.Created == nil {
+		result.Created = []uuid.UUID{}
+	}
+
+	if result.Stopped == nil {
+		result.Stopped = []uuid.UUID{}
+	}
+
+	// Report partial success.
+	if err != nil {
+		result.Errors = errStrings(err)
+
+		lg.LogAttrs(ctx, slog.LevelWarn, "scaled gates with errors", slog.Any("error", err))
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "scaled gates", slog.String("kind", "tor"), slog.Int("created", len(res.Created)), slog.Int("stopped", len(res.Closed)))
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
+// decodeStrict unmarshals raw into dst, and fails on fields that dst does not have.
+//
+// This prevents clients from assuming that a misspelt field took effect.
+func decodeStrict(raw []byte, dst any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		// The decoder has no dedicated error type for this.
+		if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("%w: %s", model.ErrUnknownField, name)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// errStrings flattens joined errors into a list of messages.
+func errStrings(err error) []string {
+	errs := model.UnwrapErrs(err)
+	if errs == nil {
+		return []string{err.Error()}
+	}
+
+	var result []string
+	for i := range errs {
+		result = append(result, errStrings(errs[i])...)
+	}
+
+	return result
+}
+
+// Config responds with the settings the gates are managed with.
+//
+// Durations are given as strings, e.g. "1m30s".
+func (h *Proxy) Config(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	lg := h.lg.With(slog.String("handler.method", "config"))
+
+	ctx := r.Context()
+
+	cfg := h.svc.Config(ctx)
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "fetched config")
+
+	result := &configView{
+		Default:             cfg.Default,
+		RandomiseKinds:      cfg.RandomiseKinds,
+		RandomNoWait:        cfg.RandomNoWait,
+		FallbackToDefault:   cfg.FallbackToDefault,
+		DisableDirect:       cfg.DisableDirect,
+		WarmupOnCreate:      cfg.WarmupOnCreate,
+		TorHTTPTimeout:      cfg.TorHTTPTimeout.String(),
+		WGHTTPTimeout:       cfg.WGHTTPTimeout.String(),
+		OVPNHTTPTimeout:     cfg.OVPNHTTPTimeout.String(),
+		DirectHTTPTimeout:   cfg.DirectHTTPTimeout.String(),
+		RandomLoopTimeout:   cfg.RandomLoopTout.String(),
+		RandomLoopDelay:     cfg.RandomLoopDelay.String(),
+		StateLoopTimeout:    cfg.StateLoopTout.String(),
+		StateLoopDelay:      cfg.StateLoopDelay.String(),
+		LoopJitter:          cfg.LoopJitter.String(),
+		TorStartupTimeout:   cfg.TorStartupTout.String(),
+		TorMax:              cfg.TorMax,
+		TorAPIMax:           cfg.TorAPIMax,
+		TorMin:              cfg.TorMin,
+		TorMaxAge:           cfg.TorMaxAge.String(),
+		TorCheckInterval:    cfg.TorCheckInterval.String(),
+		FailThreshold:       cfg.FailThreshold,
+		FailCooldown:        cfg.FailCooldown.String(),
+		MaxDialRetries:      cfg.MaxDialRetries,
+		AllowedConnectPorts: cfg.AllowedConnectPorts,
+	}
+
+	// Non-Go clients might not understand Go JSON encoding rules.
+	if result.AllowedConnectPorts == nil {
+		result.AllowedConnectPorts = []int{}
+	}
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
+// Stats responds with the number of gates by kind and state, and the requests in flight.
+func (h *Proxy) Stats(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+
+	stats := h.svc.Stats(ctx)
+
+	result := &setStats{
+		Kinds:    make([]kindStats, 0, len(stats.Kinds)),
+		InFlight: stats.InFlight,
+	}
+
+	for i := range stats.Kinds {
+		ks := kindStats{
+			Kind:        stats.Kinds[i].Kind,
+			Total:       stats.Kinds[i].Total,
+			Ready:       stats.Kinds[i].Ready,
+			Maintenance: stats.Kinds[i].Maintenance,
+			Closed:      stats.Kinds[i].Closed,
+			InFlight:    stats.Kinds[i].InFlight,
+		}
+
+		result.Kinds = append(result.Kinds, ks)
+	}
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
+// Pause stops routing new requests via gates until Resume is called.
+//
+// Gates are kept running, and requests in flight are not affected.
+func (h *Proxy) Pause(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	h.pauseResume(w, r, "pause", h.svc.Pause)
+}
+
+func (h *Proxy) Resume(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	h.pauseResume(w, r, "resume", h.svc.Resume)
+}
+
+func (h *Proxy) pauseResume(w http.ResponseWriter, r *http.Request, method string, fn func(ctx context.Context) error) {
+	lg := h.lg.With(slog.String("handler.method", method))
+
+	ctx := r.Context()
+
+	if err := fn(ctx); err != nil {
+		switch {
+		case errors.Is(err, gate.ErrSetIsShutting):
+			lg.LogAttrs(ctx, slog.LevelError, "service is shutting down", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusBadGateway)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not "+method+" set", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_ = respondWithJSON(w, h.empty, http.StatusOK)
+}
+
+// Groups responds with the ids of running gates in each group.
+func (h *Proxy) Groups(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	groups := h.svc.Groups(r.Context())
+
+	// Non-Go clients might not understand Go JSON encoding rules.
+	result := make(map[string][]uuid.UUID, len(groups))
+	for name, ids := range groups {
+		if ids == nil {
+			ids = []uuid.UUID{}
+		}
+
+		result[name] = ids
+	}
+
+	_ = respondWithDataJSON(w, result, http.StatusOK)
+}
+
+// SetGroup defines a group as the gates with the ids from the request, replacing the group if it exists.
+func (h *Proxy) SetGroup(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lg := h.lg.With(slog.String("handler.method", "set_group"))
+
+	ctx := r.Context()
+
+	name := p.ByName("name")
+	if name == "" {
+		lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "name"))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidParam, http.StatusBadRequest)
+		return
+	}
+
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to read request", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	req := &struct {
+		IDs []uuid.UUID `json:"ids"`
+	}{}
+	if err := decodeStrict(raw, req); err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "failed to parse request", slog.Any("error", err))
+
+		_ = respondWithErrJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		lg.LogAttrs(ctx, slog.LevelError, "missing param", slog.String("param.name", "ids"))
+
+		_ = respondWithErrJSON(w, model.ErrMissingParam, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.SetGroup(ctx, name, req.IDs); err != nil {
+		switch {
+		case errors.Is(err, gate.ErrGateNotFound):
+			lg.LogAttrs(ctx, slog.LevelError, "requested gate not found", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusNotFound)
+			return
+
+		case errors.Is(err, gate.ErrKindNotSupported):
+			lg.LogAttrs(ctx, slog.LevelError, "requested unsupported gate kind", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusUnprocessableEntity)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not set group", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "set group", slog.String("name", name), slog.Int("size", len(req.IDs)))
+
+	_ = respondWithJSON(w, h.empty, http.StatusOK)
+}
+
+func (h *Proxy) DeleteGroup(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lg := h.lg.With(slog.String("handler.method", "delete_group"))
+
+	ctx := r.Context()
+
+	name := p.ByName("name")
+	if name == "" {
+		lg.LogAttrs(ctx, slog.LevelError, "invalid param", slog.String("param.name", "name"))
+
+		_ = respondWithErrJSON(w, model.ErrInvalidParam, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.DeleteGroup(ctx, name); err != nil {
+		switch {
+		case errors.Is(err, gate.ErrGroupNotFound):
+			lg.LogAttrs(ctx, slog.LevelError, "requested group not found", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusNotFound)
+			return
+
+		default:
+			lg.LogAttrs(ctx, slog.LevelError, "could not delete group", slog.Any("error", err))
+
+			_ = respondWithErrJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "deleted group", slog.String("name", name))
+
+	_ = respondWithJSON(w, h.empty, http.StatusOK)
+}
+
+type configView struct {
+	Default             gate.Kind `json:"default"`
+	RandomiseKinds      bool      `json:"randomise_kinds"`
+	RandomNoWait        bool      `json:"random_no_wait"`
+	FallbackToDefault   bool      `json:"fallback_to_default"`
+	DisableDirect       bool      `json:"disable_direct"`
+	WarmupOnCreate      bool      `json:"warmup_on_create"`
+	TorHTTPTimeout      string    `json:"tor_http_timeout"`
+	WGHTTPTimeout       string    `json:"wg_http_timeout"`
+	OVPNHTTPTimeout     string    `json:"ovpn_http_timeout"`
+	DirectHTTPTimeout   string    `json:"direct_http_timeout"`
+	RandomLoopTimeout   string    `json:"random_loop_timeout"`
+	RandomLoopDelay     string    `json:"random_loop_delay"`
+	StateLoopTimeout    string    `json:"state_loop_timeout"`
+	StateLoopDelay      string    `json:"state_loop_delay"`
+	LoopJitter          string    `json:"loop_jitter"`
+	TorStartupTimeout   string    `json:"tor_startup_timeout"`
+	TorMax              int       `json:"tor_max"`
+	TorAPIMax           int       `json:"tor_api_max"`
+	TorMin              int       `json:"tor_min"`
+	TorMaxAge           string    `json:"tor_max_age"`
+	TorCheckInterval    string    `json:"tor_check_interval"`
+	FailThreshold       int       `json:"fail_threshold"`
+	FailCooldown        string    `json:"fail_cooldown"`
+	MaxDialRetries      int       `json:"max_dial_retries"`
+	AllowedConnectPorts []int     `json:"allowed_connect_ports"`
+}
+
+type setStats struct {
+	Kinds    []kindStats `json:"kinds"`
+	InFlight uint64      `json:"in_flight"`
+}
+
+type kindStats struct {
+	Kind        gate.Kind `json:"kind"`
+	Total       int       `json:"total"`
+	Ready       int       `json:"ready"`
+	Maintenance int       `json:"maintenance"`
+	Closed      int       `json:"closed"`
+	InFlight    uint64    `json:"in_flight"`
+}
+
+type gateInfo struct {
+	ID        uuid.UUID `json:"id"`
+	Kind      gate.Kind `json:"kind"`
+	Ready     bool      `json:"ready"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	WarmupErr string    `json:"warmup_error,omitempty"`
+	Latency   string    `json:"latency"`
+	Reqs      uint64    `json:"reqs"`
+	BytesIn   uint64    `json:"bytes_in"`
+	BytesOut  uint64    `json:"bytes_out"`
+	LocalAddr string    `json:"local_addr,omitempty"`
+
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// newGateInfos converts infos, and never returns nil for non-Go clients.
+func newGateInfos(infos []gate.GateInfo) []gateInfo {
+	result := make([]gateInfo, 0, len(infos))
+
+	for i := range infos {
+		result = append(result, newGateInfo(infos[i]))
+	}
+
+	return result
+}
+
+func newGateInfo(info gate.GateInfo) gateInfo {
+	result := gateInfo{
+		ID:        info.ID,
+		Kind:      info.Kind,
+		Ready:     info.Ready,
+		CreatedAt: info.CreatedAt,
+		LastUsed:  info.LastUsed,
+		WarmupErr: info.WarmupErr,
+		Latency:   info.Latency.String(),
+		Reqs:      info.Reqs,
+		BytesIn:   info.BytesIn,
+		BytesOut:  info.BytesOut,
+		LocalAddr: info.LocalAddr,
+
+		Annotations: info.Annotations,
+	}
+
+	return result
+}
+
+const (
+	gateSortLatency = "latency"
+	gateSortLoad    = "load"
+)
+
+func isValidGateSort(by string) bool {
+	switch by {
+	case "", gateSortLatency, gateSortLoad:
+		return true
+	default:
+		return false
+	}
+}
+
+// sortGateInfos sorts infos in place, and returns them for convenience.
+//
+// By latency, gates with no successful warmup yet go last. Ties are broken by id, so the order is stable between calls.
+func sortGateInfos(infos []gate.GateInfo, by string) []gate.GateInfo {
+	var less func(a, b gate.GateInfo) (bool, bool)
+
+	switch by {
+	case gateSortLatency:
+		less = func(a, b gate.GateInfo) (bool, bool) {
+			switch {
+			case a.Latency == b.Latency:
+				return false, false
+			case a.Latency == 0:
+				return false, true
+			case b.Latency == 0:
+				return true, true
+			default:
+				return a.Latency < b.Latency, true
+			}
+		}
+
+	case gateSortLoad:
+		less = func(a, b gate.GateInfo) (bool, bool) {
+			if a.Reqs == b.Reqs {
+				return false, false
+			}
+
+			return a.Reqs < b.Reqs, true
+		}
+
+	default:
+		return infos
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if ok, decided := less(infos[i], infos[j]); decided {
+			return ok
+		}
+
+		return infos[i].ID.String() < infos[j].ID.String()
+	})
+
+	return infos
 }
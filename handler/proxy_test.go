@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -27,9 +31,11 @@ func TestProxy_List(t *testing.T) {
 	type tcExpected struct {
 		code int
 		data *struct {
-			Direct    []uuid.UUID `json:"direct"`
-			Tor       []uuid.UUID `json:"tor"`
-			WireGuard []uuid.UUID `json:"wireguard"`
+			Direct       []uuid.UUID `json:"direct"`
+			Tor          []uuid.UUID `json:"tor"`
+			TorWireGuard []uuid.UUID `json:"tor_wireguard"`
+			WireGuard    []uuid.UUID `json:"wireguard"`
+			OpenVPN      []uuid.UUID `json:"openvpn"`
 		}
 		err *struct {
 			Error string `json:"error"`
@@ -41,7 +47,7 @@ func TestProxy_List(t *testing.T) {
 			name: "error_context_cancelled",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uuid.UUID }, error) {
+					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }, error) {
 						return nil, context.Canceled
 					},
 				},
@@ -58,7 +64,7 @@ func TestProxy_List(t *testing.T) {
 			name: "error_kind_unknown",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uuid.UUID }, error) {
+					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }, error) {
 						return nil, gate.ErrKindUnknown
 					},
 				},
@@ -75,7 +81,7 @@ func TestProxy_List(t *testing.T) {
 			name: "error_default",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uuid.UUID }, error) {
+					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }, error) {
 						return nil, model.Error("something_went_wrong")
 					},
 				},
@@ -92,8 +98,8 @@ func TestProxy_List(t *testing.T) {
 			name: "success_empty",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uuid.UUID }, error) {
-						result := &struct{ Direct, Tor, WireGuard []uuid.UUID }{}
+					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }, error) {
+						result := &struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }{}
 
 						return result, nil
 					},
@@ -102,13 +108,17 @@ func TestProxy_List(t *testing.T) {
 			exp: tcExpected{
 				code: http.StatusOK,
 				data: &struct {
-					Direct    []uuid.UUID `json:"direct"`
-					Tor       []uuid.UUID `json:"tor"`
-					WireGuard []uuid.UUID `json:"wireguard"`
+					Direct       []uuid.UUID `json:"direct"`
+					Tor          []uuid.UUID `json:"tor"`
+					TorWireGuard []uuid.UUID `json:"tor_wireguard"`
+					WireGuard    []uuid.UUID `json:"wireguard"`
+					OpenVPN      []uuid.UUID `json:"openvpn"`
 				}{
-					Direct:    []uuid.UUID{},
-					Tor:       []uuid.UUID{},
-					WireGuard: []uuid.UUID{},
+					Direct:       []uuid.UUID{},
+					Tor:          []uuid.UUID{},
+					TorWireGuard: []uuid.UUID{},
+					WireGuard:    []uuid.UUID{},
+					OpenVPN:      []uuid.UUID{},
 				},
 			},
 		},
@@ -117,17 +127,23 @@ func TestProxy_List(t *testing.T) {
 			name: "success",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uuid.UUID }, error) {
-						result := &struct{ Direct, Tor, WireGuard []uuid.UUID }{
+					fnGates: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }, error) {
+						result := &struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }{
 							Direct: []uuid.UUID{
 								uuid.MustParse("decade00-0000-4000-a000-000000000000"),
 							},
 							Tor: []uuid.UUID{
 								uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
 							},
+							TorWireGuard: []uuid.UUID{
+								uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+							},
 							WireGuard: []uuid.UUID{
 								uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 							},
+							OpenVPN: []uuid.UUID{
+								uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+							},
 						}
 
 						return result, nil
@@ -137,9 +153,11 @@ func TestProxy_List(t *testing.T) {
 			exp: tcExpected{
 				code: http.StatusOK,
 				data: &struct {
-					Direct    []uuid.UUID `json:"direct"`
-					Tor       []uuid.UUID `json:"tor"`
-					WireGuard []uuid.UUID `json:"wireguard"`
+					Direct       []uuid.UUID `json:"direct"`
+					Tor          []uuid.UUID `json:"tor"`
+					TorWireGuard []uuid.UUID `json:"tor_wireguard"`
+					WireGuard    []uuid.UUID `json:"wireguard"`
+					OpenVPN      []uuid.UUID `json:"openvpn"`
 				}{
 					Direct: []uuid.UUID{
 						uuid.MustParse("decade00-0000-4000-a000-000000000000"),
@@ -147,9 +165,15 @@ func TestProxy_List(t *testing.T) {
 					Tor: []uuid.UUID{
 						uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
 					},
+					TorWireGuard: []uuid.UUID{
+						uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+					},
 					WireGuard: []uuid.UUID{
 						uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 					},
+					OpenVPN: []uuid.UUID{
+						uuid.MustParse("facade00-0000-4000-a000-000000000000"),
+					},
 				},
 			},
 		},
@@ -184,9 +208,11 @@ func TestProxy_List(t *testing.T) {
 
 			actual := &struct {
 				Data *struct {
-					Direct    []uuid.UUID `json:"direct"`
-					Tor       []uuid.UUID `json:"tor"`
-					WireGuard []uuid.UUID `json:"wireguard"`
+					Direct       []uuid.UUID `json:"direct"`
+					Tor          []uuid.UUID `json:"tor"`
+					TorWireGuard []uuid.UUID `json:"tor_wireguard"`
+					WireGuard    []uuid.UUID `json:"wireguard"`
+					OpenVPN      []uuid.UUID `json:"openvpn"`
 				}
 			}{}
 
@@ -198,34 +224,27 @@ func TestProxy_List(t *testing.T) {
 	}
 }
 
-func TestProxy_Create(t *testing.T) {
+func TestProxy_ListVerbose(t *testing.T) {
 	type tcGiven struct {
-		svc *mockProxySvc
-		req []byte
+		svc  *mockProxySvc
+		sort string
 	}
 
 	type tcExpected struct {
 		code int
-		data *struct {
-			ID uuid.UUID `json:"id"`
-		}
-		err *struct {
-			Error string `json:"error"`
-		}
+		data []byte
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_unknown_kind",
+			name: "error_invalid_sort",
 			given: tcGiven{
-				svc: &mockProxySvc{},
-				req: []byte(`{"kind": "openvpn"}`),
+				svc:  &mockProxySvc{},
+				sort: "age",
 			},
 			exp: tcExpected{
 				code: http.StatusBadRequest,
-				err: &struct {
-					Error string `json:"error"`
-				}{Error: gate.ErrKindUnknown.Error()},
+				data: []byte(`{"error":"invalid param"}`),
 			},
 		},
 
@@ -233,111 +252,177 @@ func TestProxy_Create(t *testing.T) {
 			name: "error_context_cancelled",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnCreate: func(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
-						return uuid.Nil, context.Canceled
+					fnGatesVerbose: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }, error) {
+						return nil, context.Canceled
 					},
 				},
-				req: []byte(`{"kind": "tor"}`),
 			},
 			exp: tcExpected{
 				code: model.StatusClientClosedConn,
-				err: &struct {
-					Error string `json:"error"`
-				}{Error: context.Canceled.Error()},
+				data: []byte(`{"error":"context canceled"}`),
 			},
 		},
 
 		{
-			name: "error_set_is_shutting",
+			name: "error_default",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnCreate: func(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
-						return uuid.Nil, gate.ErrSetIsShutting
+					fnGatesVerbose: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }, error) {
+						return nil, model.Error("something_went_wrong")
 					},
 				},
-				req: []byte(`{"kind": "tor"}`),
 			},
 			exp: tcExpected{
-				code: http.StatusBadGateway,
-				err: &struct {
-					Error string `json:"error"`
-				}{Error: gate.ErrSetIsShutting.Error()},
+				code: http.StatusInternalServerError,
+				data: []byte(`{"error":"something_went_wrong"}`),
 			},
 		},
 
 		{
-			name: "error_kind_not_supported",
+			name: "success_empty",
 			given: tcGiven{
-				svc: &mockProxySvc{
-					fnCreate: func(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
-						return uuid.Nil, gate.ErrKindNotSupported
-					},
-				},
-				req: []byte(`{"kind": "direct"}`),
+				svc: &mockProxySvc{},
 			},
 			exp: tcExpected{
-				code: http.StatusUnprocessableEntity,
-				err: &struct {
-					Error string `json:"error"`
-				}{Error: gate.ErrKindNotSupported.Error()},
+				code: http.StatusOK,
+				data: []byte(`{"data":{"direct":[],"tor":[],"tor_wireguard":[],"wireguard":[],"openvpn":[]}}`),
 			},
 		},
 
 		{
-			name: "error_tor_max_reached",
+			name: "success",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnCreate: func(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
-						return uuid.Nil, gate.ErrTorMaxReached
+					fnGatesVerbose: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }, error) {
+						result := &struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }{
+							Direct: []gate.GateInfo{
+								{
+									ID:        uuid.MustParse("decade00-0000-4000-a000-000000000000"),
+									Kind:      gate.KindDirect,
+									Ready:     true,
+									CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+									LastUsed:  time.Date(2024, time.January, 1, 0, 30, 0, 0, time.UTC),
+									LocalAddr: "192.0.2.1",
+								},
+							},
+							Tor: []gate.GateInfo{
+								{
+									ID:        uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+									Kind:      gate.KindTor,
+									Ready:     true,
+									CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+									LastUsed:  time.Date(2024, time.January, 1, 0, 30, 0, 0, time.UTC),
+									Latency:   500 * time.Millisecond,
+									Reqs:      2,
+									BytesIn:   4096,
+									BytesOut:  512,
+								},
+							},
+						}
+
+						return result, nil
 					},
 				},
-				req: []byte(`{"kind": "tor"}`),
 			},
 			exp: tcExpected{
-				code: http.StatusConflict,
-				err: &struct {
-					Error string `json:"error"`
-				}{Error: gate.ErrTorMaxReached.Error()},
+				code: http.StatusOK,
+				data: []byte(`{"data":{"direct":[{"id":"decade00-0000-4000-a000-000000000000","kind":"direct","ready":true,"created_at":"2024-01-01T00:00:00Z","last_used":"2024-01-01T00:30:00Z","latency":"0s","reqs":0,"bytes_in":0,"bytes_out":0,"local_addr":"192.0.2.1"}],"tor":[{"id":"ad0be000-0000-4000-a000-000000000000","kind":"tor","ready":true,"created_at":"2024-01-01T00:00:00Z","last_used":"2024-01-01T00:30:00Z","latency":"500ms","reqs":2,"bytes_in":4096,"bytes_out":512}],"tor_wireguard":[],"wireguard":[],"openvpn":[]}}`),
 			},
 		},
 
 		{
-			name: "error_default",
+			name: "success_sort_latency",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnCreate: func(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
-						return uuid.Nil, model.Error("something_went_wrong")
+					fnGatesVerbose: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }, error) {
+						result := &struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }{
+							Tor: []gate.GateInfo{
+								{
+									ID:        uuid.MustParse("c0ffee00-0000-4000-a000-000000000000"),
+									Kind:      gate.KindTor,
+									Ready:     true,
+									CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+									LastUsed:  time.Date(2024, time.January, 1, 0, 30, 0, 0, time.UTC),
+									Latency:   0,
+									Reqs:      1,
+								},
+								{
+									ID:        uuid.MustParse("b0a710ad-0000-4000-a000-000000000000"),
+									Kind:      gate.KindTor,
+									Ready:     true,
+									CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+									LastUsed:  time.Date(2024, time.January, 1, 0, 30, 0, 0, time.UTC),
+									Latency:   500 * time.Millisecond,
+									Reqs:      0,
+								},
+								{
+									ID:        uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+									Kind:      gate.KindTor,
+									Ready:     true,
+									CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+									LastUsed:  time.Date(2024, time.January, 1, 0, 30, 0, 0, time.UTC),
+									Latency:   200 * time.Millisecond,
+									Reqs:      1,
+								},
+							},
+						}
+
+						return result, nil
 					},
 				},
-				req: []byte(`{"kind": "tor"}`),
+				sort: "latency",
 			},
 			exp: tcExpected{
-				code: http.StatusInternalServerError,
-				err: &struct {
-					Error string `json:"error"`
-				}{Error: "something_went_wrong"},
+				code: http.StatusOK,
+				data: []byte(`{"data":{"direct":[],"tor":[{"id":"ad0be000-0000-4000-a000-000000000000","kind":"tor","ready":true,"created_at":"2024-01-01T00:00:00Z","last_used":"2024-01-01T00:30:00Z","latency":"200ms","reqs":1,"bytes_in":0,"bytes_out":0},{"id":"b0a710ad-0000-4000-a000-000000000000","kind":"tor","ready":true,"created_at":"2024-01-01T00:00:00Z","last_used":"2024-01-01T00:30:00Z","latency":"500ms","reqs":0,"bytes_in":0,"bytes_out":0},{"id":"c0ffee00-0000-4000-a000-000000000000","kind":"tor","ready":true,"created_at":"2024-01-01T00:00:00Z","last_used":"2024-01-01T00:30:00Z","latency":"0s","reqs":1,"bytes_in":0,"bytes_out":0}],"tor_wireguard":[],"wireguard":[],"openvpn":[]}}`),
 			},
 		},
 
 		{
-			name: "success",
+			name: "success_sort_load",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnCreate: func(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
-						if kind != gate.KindTor {
-							return uuid.Nil, model.Error("unexpected_kind")
+					fnGatesVerbose: func(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }, error) {
+						result := &struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }{
+							Tor: []gate.GateInfo{
+								{
+									ID:        uuid.MustParse("c0ffee00-0000-4000-a000-000000000000"),
+									Kind:      gate.KindTor,
+									Ready:     true,
+									CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+									LastUsed:  time.Date(2024, time.January, 1, 0, 30, 0, 0, time.UTC),
+									Latency:   0,
+									Reqs:      1,
+								},
+								{
+									ID:        uuid.MustParse("b0a710ad-0000-4000-a000-000000000000"),
+									Kind:      gate.KindTor,
+									Ready:     true,
+									CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+									LastUsed:  time.Date(2024, time.January, 1, 0, 30, 0, 0, time.UTC),
+									Latency:   500 * time.Millisecond,
+									Reqs:      0,
+								},
+								{
+									ID:        uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+									Kind:      gate.KindTor,
+									Ready:     true,
+									CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+									LastUsed:  time.Date(2024, time.January, 1, 0, 30, 0, 0, time.UTC),
+									Latency:   200 * time.Millisecond,
+									Reqs:      1,
+								},
+							},
 						}
 
-						return uuid.MustParse("f100ded0-0000-4000-a000-000000000000"), nil
+						return result, nil
 					},
 				},
-				req: []byte(`{"kind": "tor"}`),
+				sort: "load",
 			},
 			exp: tcExpected{
-				code: http.StatusCreated,
-				data: &struct {
-					ID uuid.UUID `json:"id"`
-				}{ID: uuid.MustParse("f100ded0-0000-4000-a000-000000000000")},
+				code: http.StatusOK,
+				data: []byte(`{"data":{"direct":[],"tor":[{"id":"b0a710ad-0000-4000-a000-000000000000","kind":"tor","ready":true,"created_at":"2024-01-01T00:00:00Z","last_used":"2024-01-01T00:30:00Z","latency":"500ms","reqs":0,"bytes_in":0,"bytes_out":0},{"id":"ad0be000-0000-4000-a000-000000000000","kind":"tor","ready":true,"created_at":"2024-01-01T00:00:00Z","last_used":"2024-01-01T00:30:00Z","latency":"200ms","reqs":1,"bytes_in":0,"bytes_out":0},{"id":"c0ffee00-0000-4000-a000-000000000000","kind":"tor","ready":true,"created_at":"2024-01-01T00:00:00Z","last_used":"2024-01-01T00:30:00Z","latency":"0s","reqs":1,"bytes_in":0,"bytes_out":0}],"tor_wireguard":[],"wireguard":[],"openvpn":[]}}`),
 			},
 		},
 	}
@@ -349,41 +434,19 @@ func TestProxy_Create(t *testing.T) {
 			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
 			h := NewProxy(lg, tc.given.svc)
 
-			req := httptest.NewRequest(http.MethodPost, "http://localhost/gates", bytes.NewReader(tc.given.req))
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/gates?verbose=true&sort="+tc.given.sort, nil)
 
 			rw := httptest.NewRecorder()
-			h.Create(rw, req, nil)
+			h.List(rw, req, nil)
 
 			must.Equal(t, tc.exp.code, rw.Code)
 
-			if tc.exp.err != nil {
-				actual := &struct {
-					Error string `json:"error"`
-				}{}
-
-				err := json.Unmarshal(rw.Body.Bytes(), actual)
-				must.Equal(t, nil, err)
-
-				should.Equal(t, tc.exp.err, actual)
-
-				return
-			}
-
-			actual := &struct {
-				Data *struct {
-					ID uuid.UUID `json:"id"`
-				}
-			}{}
-
-			err := json.Unmarshal(rw.Body.Bytes(), actual)
-			must.Equal(t, nil, err)
-
-			should.Equal(t, tc.exp.data, actual.Data)
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
 		})
 	}
 }
 
-func TestProxy_Refresh(t *testing.T) {
+func TestProxy_Get(t *testing.T) {
 	type tcGiven struct {
 		svc *mockProxySvc
 		id  string
@@ -392,9 +455,6 @@ func TestProxy_Refresh(t *testing.T) {
 	type tcExpected struct {
 		code int
 		data []byte
-		err  *struct {
-			Error string `json:"error"`
-		}
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
@@ -405,9 +465,7 @@ func TestProxy_Refresh(t *testing.T) {
 			},
 			exp: tcExpected{
 				code: http.StatusBadRequest,
-				err: &struct {
-					Error string `json:"error"`
-				}{Error: model.ErrInvalidParam.Error()},
+				data: []byte(`{"error":"invalid param"}`),
 			},
 		},
 
@@ -417,83 +475,192 @@ func TestProxy_Refresh(t *testing.T) {
 				svc: &mockProxySvc{},
 				id:  "something_else",
 			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"invalid uuid"}`),
+			},
+		},
+
+		{
+			name: "error_not_found",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnGate: func(ctx context.Context, id uuid.UUID) (gate.GateInfo, error) {
+						return gate.GateInfo{}, gate.ErrGateNotFound
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusNotFound,
+				data: []byte(`{"error":"gate: gate not found"}`),
+			},
+		},
+
+		{
+			name: "error_default",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnGate: func(ctx context.Context, id uuid.UUID) (gate.GateInfo, error) {
+						return gate.GateInfo{}, model.Error("something_went_wrong")
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusInternalServerError,
+				data: []byte(`{"error":"something_went_wrong"}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnGate: func(ctx context.Context, id uuid.UUID) (gate.GateInfo, error) {
+						result := gate.GateInfo{
+							ID:        id,
+							Kind:      gate.KindTor,
+							CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+							LastUsed:  time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+							WarmupErr: "context deadline exceeded",
+						}
+
+						return result, nil
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"id":"f100ded0-0000-4000-a000-000000000000","kind":"tor","ready":false,"created_at":"2024-01-01T00:00:00Z","last_used":"2024-01-01T00:00:00Z","warmup_error":"context deadline exceeded","latency":"0s","reqs":0,"bytes_in":0,"bytes_out":0}}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given.svc)
+
+			uri := "http://localhost/gates/" + tc.given.id
+			req := httptest.NewRequest(http.MethodGet, uri, nil)
+
+			rw := httptest.NewRecorder()
+			h.Get(rw, req, httprouter.Params{{Key: "id", Value: tc.given.id}})
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_Create(t *testing.T) {
+	type tcGiven struct {
+		svc *mockProxySvc
+		req []byte
+	}
+
+	type tcExpected struct {
+		code int
+		data *struct {
+			ID    uuid.UUID   `json:"id"`
+			IDs   []uuid.UUID `json:"ids"`
+			Kind  gate.Kind   `json:"kind"`
+			Error string      `json:"error"`
+		}
+		err *struct {
+			Error string `json:"error"`
+		}
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_unknown_kind",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				req: []byte(`{"kind": "shadowsocks"}`),
+			},
 			exp: tcExpected{
 				code: http.StatusBadRequest,
 				err: &struct {
 					Error string `json:"error"`
-				}{Error: model.ErrInvalidUUID.Error()},
+				}{Error: gate.ErrKindUnknown.Error()},
 			},
 		},
 
 		{
-			name: "error_context_cancelled",
+			name: "error_unknown_field",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
-						return context.Canceled
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, model.Error("unexpected_create")
 					},
 				},
-				id: "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"kind": "tor", "cnt": 2}`),
 			},
 			exp: tcExpected{
-				code: model.StatusClientClosedConn,
+				code: http.StatusBadRequest,
 				err: &struct {
 					Error string `json:"error"`
-				}{Error: context.Canceled.Error()},
+				}{Error: `unknown field: "cnt"`},
 			},
 		},
 
 		{
-			name: "error_deadline_exceeded",
+			name: "error_missing_kind",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
-						return context.DeadlineExceeded
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, model.Error("unexpected_create")
 					},
 				},
-				id: "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"count": 2}`),
 			},
 			exp: tcExpected{
-				code: http.StatusGatewayTimeout,
+				code: http.StatusBadRequest,
 				err: &struct {
 					Error string `json:"error"`
-				}{Error: context.DeadlineExceeded.Error()},
+				}{Error: model.ErrMissingParam.Error()},
 			},
 		},
 
 		{
-			name: "error_set_is_shutting",
+			name: "error_context_cancelled",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
-						return gate.ErrSetIsShutting
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, context.Canceled
 					},
 				},
-				id: "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"kind": "tor"}`),
 			},
 			exp: tcExpected{
-				code: http.StatusBadGateway,
+				code: model.StatusClientClosedConn,
 				err: &struct {
 					Error string `json:"error"`
-				}{Error: gate.ErrSetIsShutting.Error()},
+				}{Error: context.Canceled.Error()},
 			},
 		},
 
 		{
-			name: "error_gate_not_found",
+			name: "error_set_is_shutting",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
-						return gate.ErrGateNotFound
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, gate.ErrSetIsShutting
 					},
 				},
-				id: "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"kind": "tor"}`),
 			},
 			exp: tcExpected{
-				code: http.StatusNotFound,
+				code: http.StatusBadGateway,
 				err: &struct {
 					Error string `json:"error"`
-				}{Error: gate.ErrGateNotFound.Error()},
+				}{Error: gate.ErrSetIsShutting.Error()},
 			},
 		},
 
@@ -501,11 +668,11 @@ func TestProxy_Refresh(t *testing.T) {
 			name: "error_kind_not_supported",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
-						return gate.ErrKindNotSupported
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, gate.ErrKindNotSupported
 					},
 				},
-				id: "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"kind": "direct"}`),
 			},
 			exp: tcExpected{
 				code: http.StatusUnprocessableEntity,
@@ -516,58 +683,214 @@ func TestProxy_Refresh(t *testing.T) {
 		},
 
 		{
-			name: "error_gate_is_refreshing",
+			name: "error_tor_max_reached",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
-						return gate.ErrGateIsRefreshing
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, gate.ErrTorMaxReached
 					},
 				},
-				id: "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"kind": "tor"}`),
 			},
 			exp: tcExpected{
 				code: http.StatusConflict,
 				err: &struct {
 					Error string `json:"error"`
-				}{Error: gate.ErrGateIsRefreshing.Error()},
+				}{Error: gate.ErrTorMaxReached.Error()},
 			},
 		},
 
 		{
-			name: "error_default",
+			name: "error_tor_start_failed",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
-						return model.Error("something_went_wrong")
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, fmt.Errorf("%w: %w", gate.ErrTorStartFailed, context.DeadlineExceeded)
 					},
 				},
-				id: "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"kind": "tor"}`),
 			},
 			exp: tcExpected{
-				code: http.StatusInternalServerError,
+				code: http.StatusBadGateway,
 				err: &struct {
 					Error string `json:"error"`
-				}{Error: "something_went_wrong"},
+				}{Error: "gate: tor failed to start: context deadline exceeded"},
 			},
 		},
 
 		{
-			name: "success",
+			name: "error_net_unreachable",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
-						if id != uuid.MustParse("f100ded0-0000-4000-a000-000000000000") {
-							return model.Error("unexpected_id")
-						}
-
-						return nil
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, fmt.Errorf("%w: %w", gate.ErrNetUnreachable, syscall.ENETUNREACH)
 					},
 				},
-				id: "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"kind": "tor"}`),
 			},
 			exp: tcExpected{
-				code: http.StatusOK,
-				data: []byte("{}"),
+				code: http.StatusBadGateway,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: "gate: network unreachable: network is unreachable"},
+			},
+		},
+
+		{
+			name: "error_default",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, model.Error("something_went_wrong")
+					},
+				},
+				req: []byte(`{"kind": "tor"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusInternalServerError,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: "something_went_wrong"},
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						if kind != gate.KindTor {
+							return gate.KindUnknown, nil, model.Error("unexpected_kind")
+						}
+
+						return gate.KindTor, []uuid.UUID{uuid.MustParse("f100ded0-0000-4000-a000-000000000000")}, nil
+					},
+				},
+				req: []byte(`{"kind": "tor"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusCreated,
+				data: &struct {
+					ID    uuid.UUID   `json:"id"`
+					IDs   []uuid.UUID `json:"ids"`
+					Kind  gate.Kind   `json:"kind"`
+					Error string      `json:"error"`
+				}{ID: uuid.MustParse("f100ded0-0000-4000-a000-000000000000"), Kind: gate.KindTor},
+			},
+		},
+
+		{
+			name: "error_invalid_count",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, model.Error("unexpected_create")
+					},
+				},
+				req: []byte(`{"kind": "tor", "count": -1}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: model.ErrInvalidParam.Error()},
+			},
+		},
+
+		{
+			name: "error_zero_count",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, model.Error("unexpected_create")
+					},
+				},
+				req: []byte(`{"kind": "tor", "count": 0}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: model.ErrInvalidParam.Error()},
+			},
+		},
+
+		{
+			name: "error_count_above_tor_max",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindUnknown, nil, model.Error("unexpected_create")
+					},
+				},
+				req: []byte(`{"kind": "tor", "count": 1000000000000}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: model.ErrInvalidParam.Error()},
+			},
+		},
+
+		{
+			name: "success_count",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						if n != 2 {
+							return gate.KindUnknown, nil, model.Error("unexpected_n")
+						}
+
+						result := []uuid.UUID{
+							uuid.MustParse("f100ded0-0000-4000-a000-000000000000"),
+							uuid.MustParse("f100ded0-0000-4000-a000-000000000001"),
+						}
+
+						return gate.KindTor, result, nil
+					},
+				},
+				req: []byte(`{"kind": "tor", "count": 2}`),
+			},
+			exp: tcExpected{
+				code: http.StatusCreated,
+				data: &struct {
+					ID    uuid.UUID   `json:"id"`
+					IDs   []uuid.UUID `json:"ids"`
+					Kind  gate.Kind   `json:"kind"`
+					Error string      `json:"error"`
+				}{
+					IDs: []uuid.UUID{
+						uuid.MustParse("f100ded0-0000-4000-a000-000000000000"),
+						uuid.MustParse("f100ded0-0000-4000-a000-000000000001"),
+					},
+					Kind: gate.KindTor,
+				},
+			},
+		},
+
+		{
+			name: "success_count_partial",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnCreate: func(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+						return gate.KindTor, []uuid.UUID{uuid.MustParse("f100ded0-0000-4000-a000-000000000000")}, gate.ErrTorMaxReached
+					},
+				},
+				req: []byte(`{"kind": "tor", "count": 3}`),
+			},
+			exp: tcExpected{
+				code: http.StatusCreated,
+				data: &struct {
+					ID    uuid.UUID   `json:"id"`
+					IDs   []uuid.UUID `json:"ids"`
+					Kind  gate.Kind   `json:"kind"`
+					Error string      `json:"error"`
+				}{
+					IDs:   []uuid.UUID{uuid.MustParse("f100ded0-0000-4000-a000-000000000000")},
+					Kind:  gate.KindTor,
+					Error: gate.ErrTorMaxReached.Error(),
+				},
 			},
 		},
 	}
@@ -579,11 +902,10 @@ func TestProxy_Refresh(t *testing.T) {
 			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
 			h := NewProxy(lg, tc.given.svc)
 
-			uri := "http://localhost/gates/" + tc.given.id
-			req := httptest.NewRequest(http.MethodPatch, uri, nil)
+			req := httptest.NewRequest(http.MethodPost, "http://localhost/gates", bytes.NewReader(tc.given.req))
 
 			rw := httptest.NewRecorder()
-			h.Refresh(rw, req, httprouter.Params{{Key: "id", Value: tc.given.id}})
+			h.Create(rw, req, nil)
 
 			must.Equal(t, tc.exp.code, rw.Code)
 
@@ -600,12 +922,24 @@ func TestProxy_Refresh(t *testing.T) {
 				return
 			}
 
-			should.Equal(t, tc.exp.data, rw.Body.Bytes())
+			actual := &struct {
+				Data *struct {
+					ID    uuid.UUID   `json:"id"`
+					IDs   []uuid.UUID `json:"ids"`
+					Kind  gate.Kind   `json:"kind"`
+					Error string      `json:"error"`
+				}
+			}{}
+
+			err := json.Unmarshal(rw.Body.Bytes(), actual)
+			must.Equal(t, nil, err)
+
+			should.Equal(t, tc.exp.data, actual.Data)
 		})
 	}
 }
 
-func TestProxy_Stop(t *testing.T) {
+func TestProxy_Refresh(t *testing.T) {
 	type tcGiven struct {
 		svc *mockProxySvc
 		id  string
@@ -651,7 +985,7 @@ func TestProxy_Stop(t *testing.T) {
 			name: "error_context_cancelled",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnStop: func(ctx context.Context, id uuid.UUID) error {
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
 						return context.Canceled
 					},
 				},
@@ -669,7 +1003,7 @@ func TestProxy_Stop(t *testing.T) {
 			name: "error_deadline_exceeded",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnStop: func(ctx context.Context, id uuid.UUID) error {
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
 						return context.DeadlineExceeded
 					},
 				},
@@ -687,7 +1021,7 @@ func TestProxy_Stop(t *testing.T) {
 			name: "error_set_is_shutting",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnStop: func(ctx context.Context, id uuid.UUID) error {
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
 						return gate.ErrSetIsShutting
 					},
 				},
@@ -705,7 +1039,7 @@ func TestProxy_Stop(t *testing.T) {
 			name: "error_gate_not_found",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnStop: func(ctx context.Context, id uuid.UUID) error {
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
 						return gate.ErrGateNotFound
 					},
 				},
@@ -723,7 +1057,7 @@ func TestProxy_Stop(t *testing.T) {
 			name: "error_kind_not_supported",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnStop: func(ctx context.Context, id uuid.UUID) error {
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
 						return gate.ErrKindNotSupported
 					},
 				},
@@ -737,11 +1071,65 @@ func TestProxy_Stop(t *testing.T) {
 			},
 		},
 
+		{
+			name: "error_gate_is_refreshing",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
+						return gate.ErrGateIsRefreshing
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusConflict,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrGateIsRefreshing.Error()},
+			},
+		},
+
+		{
+			name: "error_gate_busy",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
+						return gate.ErrGateBusy
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusConflict,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrGateBusy.Error()},
+			},
+		},
+
+		{
+			name: "error_net_unreachable",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
+						return fmt.Errorf("%w: %w", gate.ErrNetUnreachable, syscall.EHOSTUNREACH)
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: "gate: network unreachable: no route to host"},
+			},
+		},
+
 		{
 			name: "error_default",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnStop: func(ctx context.Context, id uuid.UUID) error {
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
 						return model.Error("something_went_wrong")
 					},
 				},
@@ -759,7 +1147,7 @@ func TestProxy_Stop(t *testing.T) {
 			name: "success",
 			given: tcGiven{
 				svc: &mockProxySvc{
-					fnStop: func(ctx context.Context, id uuid.UUID) error {
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
 						if id != uuid.MustParse("f100ded0-0000-4000-a000-000000000000") {
 							return model.Error("unexpected_id")
 						}
@@ -784,10 +1172,10 @@ func TestProxy_Stop(t *testing.T) {
 			h := NewProxy(lg, tc.given.svc)
 
 			uri := "http://localhost/gates/" + tc.given.id
-			req := httptest.NewRequest(http.MethodDelete, uri, nil)
+			req := httptest.NewRequest(http.MethodPatch, uri, nil)
 
 			rw := httptest.NewRecorder()
-			h.Stop(rw, req, httprouter.Params{{Key: "id", Value: tc.given.id}})
+			h.Refresh(rw, req, httprouter.Params{{Key: "id", Value: tc.given.id}})
 
 			must.Equal(t, tc.exp.code, rw.Code)
 
@@ -808,3 +1196,1614 @@ func TestProxy_Stop(t *testing.T) {
 		})
 	}
 }
+
+func TestProxy_Refresh_annotate(t *testing.T) {
+	type tcGiven struct {
+		svc *mockProxySvc
+		req []byte
+	}
+
+	type tcExpected struct {
+		code int
+		data []byte
+		err  *struct {
+			Error string `json:"error"`
+		}
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_unknown_field",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				req: []byte(`{"labels":{"owner":"ops"}}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: `unknown field: "labels"`},
+			},
+		},
+
+		{
+			name: "error_missing_param",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				req: []byte(`{}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: model.ErrMissingParam.Error()},
+			},
+		},
+
+		{
+			name: "error_empty_key",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				req: []byte(`{"annotations":{"":"ops"}}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: model.ErrInvalidParam.Error()},
+			},
+		},
+
+		{
+			name: "error_gate_not_found",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnAnnotate: func(ctx context.Context, id uuid.UUID, annots map[string]string) (map[string]string, error) {
+						return nil, gate.ErrGateNotFound
+					},
+				},
+				req: []byte(`{"annotations":{"owner":"ops"}}`),
+			},
+			exp: tcExpected{
+				code: http.StatusNotFound,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrGateNotFound.Error()},
+			},
+		},
+
+		{
+			name: "error_too_many",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnAnnotate: func(ctx context.Context, id uuid.UUID, annots map[string]string) (map[string]string, error) {
+						return nil, gate.ErrTooManyAnnotations
+					},
+				},
+				req: []byte(`{"annotations":{"owner":"ops"}}`),
+			},
+			exp: tcExpected{
+				code: http.StatusUnprocessableEntity,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrTooManyAnnotations.Error()},
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnRefresh: func(ctx context.Context, id uuid.UUID) error {
+						return model.Error("unexpected_refresh")
+					},
+					fnAnnotate: func(ctx context.Context, id uuid.UUID, annots map[string]string) (map[string]string, error) {
+						if id != uuid.MustParse("f100ded0-0000-4000-a000-000000000000") {
+							return nil, model.Error("unexpected_id")
+						}
+
+						return map[string]string{"owner": annots["owner"], "region": "eu"}, nil
+					},
+				},
+				req: []byte(`{"annotations":{"owner":"ops"}}`),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"annotations":{"owner":"ops","region":"eu"}}}`),
+			},
+		},
+
+		{
+			name: "success_all_removed",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnAnnotate: func(ctx context.Context, id uuid.UUID, annots map[string]string) (map[string]string, error) {
+						return nil, nil
+					},
+				},
+				req: []byte(`{"annotations":{"owner":""}}`),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"annotations":{}}}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given.svc)
+
+			uri := "http://localhost/gates/f100ded0-0000-4000-a000-000000000000"
+			req := httptest.NewRequest(http.MethodPatch, uri, bytes.NewReader(tc.given.req))
+
+			rw := httptest.NewRecorder()
+			h.Refresh(rw, req, httprouter.Params{{Key: "id", Value: "f100ded0-0000-4000-a000-000000000000"}})
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			if tc.exp.err != nil {
+				actual := &struct {
+					Error string `json:"error"`
+				}{}
+
+				err := json.Unmarshal(rw.Body.Bytes(), actual)
+				must.Equal(t, nil, err)
+
+				should.Equal(t, tc.exp.err, actual)
+
+				return
+			}
+
+			should.Equal(t, tc.exp.data, rw.Body.Bytes())
+		})
+	}
+}
+
+func TestProxy_Probe(t *testing.T) {
+	type tcGiven struct {
+		svc *mockProxySvc
+		id  string
+		req []byte
+	}
+
+	type tcExpected struct {
+		code int
+		data []byte
+		err  *struct {
+			Error string `json:"error"`
+		}
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_invalid_uuid",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				id:  "something_else",
+				req: []byte(`{"url": "https://example.com"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: model.ErrInvalidUUID.Error()},
+			},
+		},
+
+		{
+			name: "error_unknown_field",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"uri": "https://example.com"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: `unknown field: "uri"`},
+			},
+		},
+
+		{
+			name: "error_missing_url",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: model.ErrMissingParam.Error()},
+			},
+		},
+
+		{
+			name: "error_context_cancelled",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnProbe: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						return nil, context.Canceled
+					},
+				},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"url": "https://example.com"}`),
+			},
+			exp: tcExpected{
+				code: model.StatusClientClosedConn,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: context.Canceled.Error()},
+			},
+		},
+
+		{
+			name: "error_deadline_exceeded",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnProbe: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						return nil, context.DeadlineExceeded
+					},
+				},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"url": "https://example.com"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusGatewayTimeout,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: context.DeadlineExceeded.Error()},
+			},
+		},
+
+		{
+			name: "error_invalid_url",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnProbe: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						return nil, gate.ErrInvalidProbeURL
+					},
+				},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"url": "https://example.com"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrInvalidProbeURL.Error()},
+			},
+		},
+
+		{
+			name: "error_gate_not_found",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnProbe: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						return nil, gate.ErrGateNotFound
+					},
+				},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"url": "https://example.com"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusNotFound,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrGateNotFound.Error()},
+			},
+		},
+
+		{
+			name: "error_gate_not_ready",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnProbe: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						return nil, gate.ErrGateNotReady
+					},
+				},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"url": "https://example.com"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusConflict,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrGateNotReady.Error()},
+			},
+		},
+
+		{
+			name: "error_dest_not_allowed",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnProbe: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						return nil, fmt.Errorf("dial: %w", gate.ErrProbeDestNotAllowed)
+					},
+				},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"url": "http://10.0.0.1"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusForbidden,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrProbeDestNotAllowed.Error()},
+			},
+		},
+
+		{
+			name: "error_default",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnProbe: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"url": "https://example.com"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: "something_went_wrong"},
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnProbe: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						if id != uuid.MustParse("f100ded0-0000-4000-a000-000000000000") {
+							return nil, model.Error("unexpected_id")
+						}
+
+						if rawURL != "https://example.com" {
+							return nil, model.Error("unexpected_url")
+						}
+
+						return &gate.ProbeResult{Status: http.StatusForbidden, Latency: 150 * time.Millisecond}, nil
+					},
+				},
+				id:  "f100ded0-0000-4000-a000-000000000000",
+				req: []byte(`{"url": "https://example.com"}`),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"status":403,"latency":"150ms"}}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given.svc)
+
+			uri := "http://localhost/gates/" + tc.given.id + "/probe"
+			req := httptest.NewRequest(http.MethodPost, uri, bytes.NewReader(tc.given.req))
+
+			rw := httptest.NewRecorder()
+			h.Probe(rw, req, httprouter.Params{{Key: "id", Value: tc.given.id}})
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			if tc.exp.err != nil {
+				actual := &struct {
+					Error string `json:"error"`
+				}{}
+
+				err := json.Unmarshal(rw.Body.Bytes(), actual)
+				must.Equal(t, nil, err)
+
+				should.Equal(t, tc.exp.err, actual)
+
+				return
+			}
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_Stop(t *testing.T) {
+	type tcGiven struct {
+		svc   *mockProxySvc
+		id    string
+		query string
+	}
+
+	type tcExpected struct {
+		code int
+		data []byte
+		err  *struct {
+			Error string `json:"error"`
+		}
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_invalid_param",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: model.ErrInvalidParam.Error()},
+			},
+		},
+
+		{
+			name: "error_invalid_uuid",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				id:  "something_else",
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: model.ErrInvalidUUID.Error()},
+			},
+		},
+
+		{
+			name: "error_context_cancelled",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStop: func(ctx context.Context, id uuid.UUID) error {
+						return context.Canceled
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: model.StatusClientClosedConn,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: context.Canceled.Error()},
+			},
+		},
+
+		{
+			name: "error_deadline_exceeded",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStop: func(ctx context.Context, id uuid.UUID) error {
+						return context.DeadlineExceeded
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusGatewayTimeout,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: context.DeadlineExceeded.Error()},
+			},
+		},
+
+		{
+			name: "error_set_is_shutting",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStop: func(ctx context.Context, id uuid.UUID) error {
+						return gate.ErrSetIsShutting
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrSetIsShutting.Error()},
+			},
+		},
+
+		{
+			name: "error_gate_not_found",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStop: func(ctx context.Context, id uuid.UUID) error {
+						return gate.ErrGateNotFound
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusNotFound,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrGateNotFound.Error()},
+			},
+		},
+
+		{
+			name: "error_kind_not_supported",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStop: func(ctx context.Context, id uuid.UUID) error {
+						return gate.ErrKindNotSupported
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusUnprocessableEntity,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrKindNotSupported.Error()},
+			},
+		},
+
+		{
+			name: "error_gate_busy",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStop: func(ctx context.Context, id uuid.UUID) error {
+						return gate.ErrGateBusy
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusConflict,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrGateBusy.Error()},
+			},
+		},
+
+		{
+			name: "error_default",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStop: func(ctx context.Context, id uuid.UUID) error {
+						return model.Error("something_went_wrong")
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusInternalServerError,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: "something_went_wrong"},
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStop: func(ctx context.Context, id uuid.UUID) error {
+						if id != uuid.MustParse("f100ded0-0000-4000-a000-000000000000") {
+							return model.Error("unexpected_id")
+						}
+
+						return nil
+					},
+				},
+				id: "f100ded0-0000-4000-a000-000000000000",
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte("{}"),
+			},
+		},
+
+		{
+			name: "error_force",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnForceStop: func(ctx context.Context, id uuid.UUID) error {
+						return gate.ErrGateNotFound
+					},
+				},
+				id:    "f100ded0-0000-4000-a000-000000000000",
+				query: "force=true",
+			},
+			exp: tcExpected{
+				code: http.StatusNotFound,
+				err: &struct {
+					Error string `json:"error"`
+				}{Error: gate.ErrGateNotFound.Error()},
+			},
+		},
+
+		{
+			name: "success_force",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStop: func(ctx context.Context, id uuid.UUID) error {
+						return model.Error("unexpected_graceful")
+					},
+					fnForceStop: func(ctx context.Context, id uuid.UUID) error {
+						if id != uuid.MustParse("f100ded0-0000-4000-a000-000000000000") {
+							return model.Error("unexpected_id")
+						}
+
+						return nil
+					},
+				},
+				id:    "f100ded0-0000-4000-a000-000000000000",
+				query: "force=true",
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte("{}"),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given.svc)
+
+			uri := "http://localhost/gates/" + tc.given.id
+			if tc.given.query != "" {
+				uri += "?" + tc.given.query
+			}
+			req := httptest.NewRequest(http.MethodDelete, uri, nil)
+
+			rw := httptest.NewRecorder()
+			h.Stop(rw, req, httprouter.Params{{Key: "id", Value: tc.given.id}})
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			if tc.exp.err != nil {
+				actual := &struct {
+					Error string `json:"error"`
+				}{}
+
+				err := json.Unmarshal(rw.Body.Bytes(), actual)
+				must.Equal(t, nil, err)
+
+				should.Equal(t, tc.exp.err, actual)
+
+				return
+			}
+
+			should.Equal(t, tc.exp.data, rw.Body.Bytes())
+		})
+	}
+}
+
+func TestProxy_StopIdle(t *testing.T) {
+	type tcGiven struct {
+		svc   *mockProxySvc
+		query string
+	}
+
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_no_idle",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"invalid param"}`),
+			},
+		},
+
+		{
+			name: "error_invalid_idle",
+			given: tcGiven{
+				svc:   &mockProxySvc{},
+				query: "?idle=-1h",
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"invalid param"}`),
+			},
+		},
+
+		{
+			name: "error_unknown_kind",
+			given: tcGiven{
+				svc:   &mockProxySvc{},
+				query: "?idle=1h&kind=something",
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"gate: unknown kind"}`),
+			},
+		},
+
+		{
+			name: "error_context_cancelled",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStopIdle: func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+						return nil, context.Canceled
+					},
+				},
+				query: "?idle=1h",
+			},
+			exp: tcExpected{
+				code: model.StatusClientClosedConn,
+				data: []byte(`{"error":"context canceled"}`),
+			},
+		},
+
+		{
+			name: "error_set_is_shutting",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStopIdle: func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+						return nil, gate.ErrSetIsShutting
+					},
+				},
+				query: "?idle=1h",
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				data: []byte(`{"error":"gate: set is shutting"}`),
+			},
+		},
+
+		{
+			name: "error_kind_not_supported",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStopIdle: func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+						return nil, gate.ErrKindNotSupported
+					},
+				},
+				query: "?idle=1h&kind=wireguard",
+			},
+			exp: tcExpected{
+				code: http.StatusUnprocessableEntity,
+				data: []byte(`{"error":"gate: unsupported kind"}`),
+			},
+		},
+
+		{
+			name: "error_default",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStopIdle: func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+				query: "?idle=1h",
+			},
+			exp: tcExpected{
+				code: http.StatusInternalServerError,
+				data: []byte(`{"error":"something_went_wrong"}`),
+			},
+		},
+
+		{
+			name: "success_partial",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStopIdle: func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+						return []uuid.UUID{uuid.MustParse("f100ded0-0000-4000-a000-000000000000")}, errors.Join(model.Error("error_01"))
+					},
+				},
+				query: "?idle=1h",
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"ids":["f100ded0-0000-4000-a000-000000000000"],"errors":["error_01"]}}`),
+			},
+		},
+
+		{
+			name: "success_none",
+			given: tcGiven{
+				svc:   &mockProxySvc{},
+				query: "?idle=1h",
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"ids":[]}}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnStopIdle: func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+						if kind != gate.KindTor || minIdle != 10*time.Minute {
+							return nil, model.Error("unexpected_params")
+						}
+
+						return []uuid.UUID{uuid.MustParse("f100ded0-0000-4000-a000-000000000000")}, nil
+					},
+				},
+				query: "?idle=10m&kind=tor",
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"ids":["f100ded0-0000-4000-a000-000000000000"]}}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given.svc)
+
+			req := httptest.NewRequest(http.MethodDelete, "http://localhost/v1/_service/gates"+tc.given.query, nil)
+
+			rw := httptest.NewRecorder()
+			h.StopIdle(rw, req, nil)
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_ScaleTors(t *testing.T) {
+	type tcGiven struct {
+		svc *mockProxySvc
+		req []byte
+	}
+
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_unknown_field",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				req: []byte(`{"cnt": 2}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"unknown field: \"cnt\""}`),
+			},
+		},
+
+		{
+			name: "error_missing_count",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				req: []byte(`{}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"missing param"}`),
+			},
+		},
+
+		{
+			name: "error_negative_count",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				req: []byte(`{"count": -1}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"invalid param"}`),
+			},
+		},
+
+		{
+			name: "error_tor_max_reached",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnScaleTors: func(ctx context.Context, n int) (*gate.ScaleResult, error) {
+						return nil, gate.ErrTorMaxReached
+					},
+				},
+				req: []byte(`{"count": 256}`),
+			},
+			exp: tcExpected{
+				code: http.StatusConflict,
+				data: []byte(`{"error":"gate: reached maximum number of tor gates"}`),
+			},
+		},
+
+		{
+			name: "error_set_is_shutting",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnScaleTors: func(ctx context.Context, n int) (*gate.ScaleResult, error) {
+						return nil, gate.ErrSetIsShutting
+					},
+				},
+				req: []byte(`{"count": 2}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				data: []byte(`{"error":"gate: set is shutting"}`),
+			},
+		},
+
+		{
+			name: "error_default",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnScaleTors: func(ctx context.Context, n int) (*gate.ScaleResult, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+				req: []byte(`{"count": 2}`),
+			},
+			exp: tcExpected{
+				code: http.StatusInternalServerError,
+				data: []byte(`{"error":"something_went_wrong"}`),
+			},
+		},
+
+		{
+			name: "success_partial",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnScaleTors: func(ctx context.Context, n int) (*gate.ScaleResult, error) {
+						result := &gate.ScaleResult{
+							Closed: []uuid.UUID{uuid.MustParse("c0c0a000-0000-4000-a000-000000000000")},
+						}
+
+						return result, errors.Join(model.Error("something_went_wrong"))
+					},
+				},
+				req: []byte(`{"count": 0}`),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"created":[],"stopped":["c0c0a000-0000-4000-a000-000000000000"],"errors":["something_went_wrong"]}}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnScaleTors: func(ctx context.Context, n int) (*gate.ScaleResult, error) {
+						if n != 2 {
+							return nil, model.Error("unexpected_count")
+						}
+
+						result := &gate.ScaleResult{
+							Created: []uuid.UUID{uuid.MustParse("c0c0b000-0000-4000-a000-000000000000")},
+						}
+
+						return result, nil
+					},
+				},
+				req: []byte(`{"count": 2}`),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"created":["c0c0b000-0000-4000-a000-000000000000"],"stopped":[]}}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given.svc)
+
+			req := httptest.NewRequest(http.MethodPut, "http://localhost/v1/_service/gates/tor/count", bytes.NewReader(tc.given.req))
+
+			rw := httptest.NewRecorder()
+			h.ScaleTors(rw, req, nil)
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_Reload(t *testing.T) {
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[*mockProxySvc, tcExpected]{
+		{
+			name: "error_context_cancelled",
+			given: &mockProxySvc{
+				fnReload: func(ctx context.Context) (*gate.ReloadResult, error) {
+					return nil, context.Canceled
+				},
+			},
+			exp: tcExpected{
+				code: model.StatusClientClosedConn,
+				data: []byte(`{"error":"context canceled"}`),
+			},
+		},
+
+		{
+			name: "error_set_is_shutting",
+			given: &mockProxySvc{
+				fnReload: func(ctx context.Context) (*gate.ReloadResult, error) {
+					return nil, gate.ErrSetIsShutting
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				data: []byte(`{"error":"gate: set is shutting"}`),
+			},
+		},
+
+		{
+			name: "error_set_is_reloading",
+			given: &mockProxySvc{
+				fnReload: func(ctx context.Context) (*gate.ReloadResult, error) {
+					return nil, gate.ErrSetIsReloading
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusConflict,
+				data: []byte(`{"error":"gate: set is reloading"}`),
+			},
+		},
+
+		{
+			name: "error_default",
+			given: &mockProxySvc{
+				fnReload: func(ctx context.Context) (*gate.ReloadResult, error) {
+					return nil, model.Error("something_went_wrong")
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusInternalServerError,
+				data: []byte(`{"error":"something_went_wrong"}`),
+			},
+		},
+
+		{
+			name: "success_partial",
+			given: &mockProxySvc{
+				fnReload: func(ctx context.Context) (*gate.ReloadResult, error) {
+					result := &gate.ReloadResult{
+						Added: []uuid.UUID{uuid.MustParse("f100ded0-0000-4000-a000-000000000000")},
+					}
+
+					return result, errors.Join(errors.Join(model.Error("error_01")), model.Error("error_02"))
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"added":["f100ded0-0000-4000-a000-000000000000"],"removed":[],"unchanged":0,"errors":["error_01","error_02"]}}`),
+			},
+		},
+
+		{
+			name:  "success",
+			given: &mockProxySvc{},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"added":[],"removed":[],"unchanged":0}}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given)
+
+			req := httptest.NewRequest(http.MethodPost, "http://localhost/reload", nil)
+
+			rw := httptest.NewRecorder()
+			h.Reload(rw, req, nil)
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_Pause(t *testing.T) {
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[*mockProxySvc, tcExpected]{
+		{
+			name: "error_set_is_shutting",
+			given: &mockProxySvc{
+				fnPause: func(ctx context.Context) error {
+					return gate.ErrSetIsShutting
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				data: []byte(`{"error":"gate: set is shutting"}`),
+			},
+		},
+
+		{
+			name: "error_default",
+			given: &mockProxySvc{
+				fnPause: func(ctx context.Context) error {
+					return model.Error("something_went_wrong")
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusInternalServerError,
+				data: []byte(`{"error":"something_went_wrong"}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: &mockProxySvc{
+				fnResume: func(ctx context.Context) error {
+					return model.Error("unexpected_resume")
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given)
+
+			req := httptest.NewRequest(http.MethodPost, "http://localhost/pause", nil)
+
+			rw := httptest.NewRecorder()
+			h.Pause(rw, req, nil)
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_Resume(t *testing.T) {
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[*mockProxySvc, tcExpected]{
+		{
+			name: "error_set_is_shutting",
+			given: &mockProxySvc{
+				fnResume: func(ctx context.Context) error {
+					return gate.ErrSetIsShutting
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				data: []byte(`{"error":"gate: set is shutting"}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: &mockProxySvc{
+				fnPause: func(ctx context.Context) error {
+					return model.Error("unexpected_pause")
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given)
+
+			req := httptest.NewRequest(http.MethodPost, "http://localhost/resume", nil)
+
+			rw := httptest.NewRecorder()
+			h.Resume(rw, req, nil)
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_Stats(t *testing.T) {
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[*mockProxySvc, tcExpected]{
+		{
+			name:  "empty",
+			given: &mockProxySvc{},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"kinds":[],"in_flight":0}}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: &mockProxySvc{
+				fnStats: func(ctx context.Context) gate.SetStats {
+					result := gate.SetStats{
+						Kinds: []gate.KindStats{
+							{Kind: gate.KindDirect, Total: 1, Ready: 1, InFlight: 1},
+							{Kind: gate.KindTor, Total: 3, Ready: 1, Maintenance: 1, Closed: 1, InFlight: 2},
+						},
+						InFlight: 3,
+					}
+
+					return result
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"kinds":[{"kind":"direct","total":1,"ready":1,"maintenance":0,"closed":0,"in_flight":1},{"kind":"tor","total":3,"ready":1,"maintenance":1,"closed":1,"in_flight":2}],"in_flight":3}}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/stats", nil)
+
+			rw := httptest.NewRecorder()
+			h.Stats(rw, req, nil)
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_Groups(t *testing.T) {
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[*mockProxySvc, tcExpected]{
+		{
+			name:  "empty",
+			given: &mockProxySvc{},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{}}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: &mockProxySvc{
+				fnGroups: func(ctx context.Context) map[string][]uuid.UUID {
+					result := map[string][]uuid.UUID{
+						"fast": {uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"), uuid.MustParse("decade00-0000-4000-a000-000000000000")},
+						"slow": nil,
+					}
+
+					return result
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"fast":["c0c0a000-0000-4000-a000-000000000000","decade00-0000-4000-a000-000000000000"],"slow":[]}}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/v1/_service/groups", nil)
+
+			rw := httptest.NewRecorder()
+			h.Groups(rw, req, nil)
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_SetGroup(t *testing.T) {
+	type tcGiven struct {
+		svc  *mockProxySvc
+		name string
+		req  []byte
+	}
+
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_invalid_param",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+				req: []byte(`{"ids":["c0c0a000-0000-4000-a000-000000000000"]}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"invalid param"}`),
+			},
+		},
+
+		{
+			name: "error_unknown_field",
+			given: tcGiven{
+				svc:  &mockProxySvc{},
+				name: "fast",
+				req:  []byte(`{"gates":["c0c0a000-0000-4000-a000-000000000000"]}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"unknown field: \"gates\""}`),
+			},
+		},
+
+		{
+			name: "error_missing_ids",
+			given: tcGiven{
+				svc:  &mockProxySvc{},
+				name: "fast",
+				req:  []byte(`{"ids":[]}`),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"missing param"}`),
+			},
+		},
+
+		{
+			name: "error_gate_not_found",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnSetGroup: func(ctx context.Context, name string, ids []uuid.UUID) error {
+						return fmt.Errorf("%w: %s", gate.ErrGateNotFound, ids[0])
+					},
+				},
+				name: "fast",
+				req:  []byte(`{"ids":["c0c0a000-0000-4000-a000-000000000000"]}`),
+			},
+			exp: tcExpected{
+				code: http.StatusNotFound,
+				data: []byte(`{"error":"gate: gate not found: c0c0a000-0000-4000-a000-000000000000"}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnSetGroup: func(ctx context.Context, name string, ids []uuid.UUID) error {
+						if name != "fast" || len(ids) != 2 {
+							return model.Error("unexpected_group")
+						}
+
+						return nil
+					},
+				},
+				name: "fast",
+				req:  []byte(`{"ids":["c0c0a000-0000-4000-a000-000000000000","decade00-0000-4000-a000-000000000000"]}`),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given.svc)
+
+			req := httptest.NewRequest(http.MethodPut, "http://localhost/v1/_service/groups/"+tc.given.name, bytes.NewReader(tc.given.req))
+
+			rw := httptest.NewRecorder()
+			h.SetGroup(rw, req, httprouter.Params{{Key: "name", Value: tc.given.name}})
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_DeleteGroup(t *testing.T) {
+	type tcGiven struct {
+		svc  *mockProxySvc
+		name string
+	}
+
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_invalid_param",
+			given: tcGiven{
+				svc: &mockProxySvc{},
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"invalid param"}`),
+			},
+		},
+
+		{
+			name: "error_not_found",
+			given: tcGiven{
+				svc: &mockProxySvc{
+					fnDeleteGroup: func(ctx context.Context, name string) error {
+						return gate.ErrGroupNotFound
+					},
+				},
+				name: "slow",
+			},
+			exp: tcExpected{
+				code: http.StatusNotFound,
+				data: []byte(`{"error":"gate: group not found"}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: tcGiven{
+				svc:  &mockProxySvc{},
+				name: "fast",
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given.svc)
+
+			req := httptest.NewRequest(http.MethodDelete, "http://localhost/v1/_service/groups/"+tc.given.name, nil)
+
+			rw := httptest.NewRecorder()
+			h.DeleteGroup(rw, req, httprouter.Params{{Key: "name", Value: tc.given.name}})
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
+
+func TestProxy_Config(t *testing.T) {
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[*mockProxySvc, tcExpected]{
+		{
+			name:  "defaults",
+			given: &mockProxySvc{},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"default":"tor","randomise_kinds":false,"random_no_wait":false,"fallback_to_default":false,"disable_direct":false,"warmup_on_create":false,"tor_http_timeout":"0s","wg_http_timeout":"0s","ovpn_http_timeout":"0s","direct_http_timeout":"0s","random_loop_timeout":"0s","random_loop_delay":"0s","state_loop_timeout":"0s","state_loop_delay":"0s","loop_jitter":"0s","tor_startup_timeout":"0s","tor_max":10,"tor_api_max":0,"tor_min":0,"tor_max_age":"0s","tor_check_interval":"0s","fail_threshold":0,"fail_cooldown":"0s","max_dial_retries":0,"allowed_connect_ports":[]}}`),
+			},
+		},
+
+		{
+			name: "success",
+			given: &mockProxySvc{
+				fnConfig: func(ctx context.Context) *gate.ConfigView {
+					result := &gate.ConfigView{
+						Default:             gate.KindWireGuard,
+						RandomiseKinds:      true,
+						TorHTTPTimeout:      time.Minute,
+						WGHTTPTimeout:       30 * time.Second,
+						OVPNHTTPTimeout:     time.Minute,
+						DirectHTTPTimeout:   30 * time.Second,
+						RandomLoopTout:      5 * time.Second,
+						RandomLoopDelay:     10 * time.Millisecond,
+						StateLoopTout:       30 * time.Second,
+						StateLoopDelay:      10 * time.Millisecond,
+						LoopJitter:          5 * time.Millisecond,
+						TorStartupTout:      90 * time.Second,
+						TorMax:              8,
+						TorAPIMax:           4,
+						TorMin:              1,
+						MaxDialRetries:      2,
+						AllowedConnectPorts: []int{443, 8443},
+					}
+
+					return result
+				},
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				data: []byte(`{"data":{"default":"wireguard","randomise_kinds":true,"random_no_wait":false,"fallback_to_default":false,"disable_direct":false,"warmup_on_create":false,"tor_http_timeout":"1m0s","wg_http_timeout":"30s","ovpn_http_timeout":"1m0s","direct_http_timeout":"30s","random_loop_timeout":"5s","random_loop_delay":"10ms","state_loop_timeout":"30s","state_loop_delay":"10ms","loop_jitter":"5ms","tor_startup_timeout":"1m30s","tor_max":8,"tor_api_max":4,"tor_min":1,"tor_max_age":"0s","tor_check_interval":"0s","fail_threshold":0,"fail_cooldown":"0s","max_dial_retries":2,"allowed_connect_ports":[443,8443]}}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+			h := NewProxy(lg, tc.given)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/config", nil)
+
+			rw := httptest.NewRecorder()
+			h.Config(rw, req, nil)
+
+			must.Equal(t, tc.exp.code, rw.Code)
+
+			should.Equal(t, string(tc.exp.data), rw.Body.String())
+		})
+	}
+}
@@ -5,12 +5,13 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/pavelbrm/pumpe/gate"
 	"github.com/pavelbrm/pumpe/web"
 )
 
 type pumpeSvc interface {
-	HandleConnect(ctx context.Context, w http.ResponseWriter, r *http.Request) error
-	HandleHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	HandleConnect(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error)
+	HandleHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error)
 }
 
 type Pumpe struct {
@@ -41,10 +42,14 @@ func (h *Pumpe) Handle(w http.ResponseWriter, r *http.Request) {
 	case r.Method == http.MethodConnect:
 		lg.LogAttrs(ctx, slog.LevelDebug, "handling request")
 
-		if err := h.svc.HandleConnect(ctx, w, r); err != nil {
-			lg.LogAttrs(ctx, slog.LevelError, "request ended with error", slog.Any("error", err))
+		gt, err := h.svc.HandleConnect(ctx, w, r)
+		if err != nil {
+			// The connection is hijacked, so the status is not seen by the app.
+			web.MarkFailed(w)
 		}
 
+		h.logResult(ctx, lg, gt, err)
+
 		return
 
 	case r.URL.Scheme == "http":
@@ -56,10 +61,14 @@ func (h *Pumpe) Handle(w http.ResponseWriter, r *http.Request) {
 
 		lg.LogAttrs(ctx, slog.LevelDebug, "handling request")
 
-		if err := h.svc.HandleHTTP(ctx, w, r); err != nil {
-			lg.LogAttrs(ctx, slog.LevelError, "request ended with error", slog.Any("error", err))
+		gt, err := h.svc.HandleHTTP(ctx, w, r)
+		if err != nil {
+			// Upgraded connections are hijacked, too.
+			web.MarkFailed(w)
 		}
 
+		h.logResult(ctx, lg, gt, err)
+
 		return
 
 	default:
@@ -70,3 +79,21 @@ func (h *Pumpe) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// logResult logs the outcome of a request along with the gate that served it, if any.
+func (h *Pumpe) logResult(ctx context.Context, lg *slog.Logger, gt gate.ExitGate, err error) {
+	if gt != nil {
+		lg = lg.With(
+			slog.String("gate.id", gt.ID().String()),
+			slog.String("gate.kind", gt.Kind().String()),
+		)
+	}
+
+	if err != nil {
+		lg.LogAttrs(ctx, slog.LevelError, "request ended with error", slog.Any("error", err))
+
+		return
+	}
+
+	lg.LogAttrs(ctx, slog.LevelInfo, "handled request")
+}
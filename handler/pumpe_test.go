@@ -10,6 +10,7 @@ import (
 
 	should "github.com/stretchr/testify/assert"
 
+	"github.com/pavelbrm/pumpe/gate"
 	"github.com/pavelbrm/pumpe/model"
 )
 
@@ -36,6 +37,7 @@ func TestPumpe_Handle(t *testing.T) {
 			exp: tcExpected{
 				msgs: []string{
 					`level=DEBUG msg="handling request" handler.method=handle http.method=CONNECT http.host=https: http.client.ip=192.0.2.1:1234`,
+					`level=INFO msg="handled request" handler.method=handle http.method=CONNECT http.host=https: http.client.ip=192.0.2.1:1234 gate.id=5ca1ab1e-0000-4000-a000-000000000000 gate.kind=direct`,
 				},
 			},
 		},
@@ -44,8 +46,8 @@ func TestPumpe_Handle(t *testing.T) {
 			name: "connect_error",
 			given: tcGiven{
 				svc: &mockPumpeSvc{
-					fnHandleConnect: func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-						return model.Error("something_went_wrong")
+					fnHandleConnect: func(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
+						return nil, model.Error("something_went_wrong")
 					},
 				},
 				req: httptest.NewRequest(http.MethodConnect, "https://httpbin.org/ip", nil),
@@ -69,6 +71,7 @@ func TestPumpe_Handle(t *testing.T) {
 			exp: tcExpected{
 				msgs: []string{
 					`level=DEBUG msg="handling request" handler.method=handle http.method=CONNECT http.host=http: http.client.ip=192.0.2.1:1234`,
+					`level=INFO msg="handled request" handler.method=handle http.method=CONNECT http.host=http: http.client.ip=192.0.2.1:1234 gate.id=5ca1ab1e-0000-4000-a000-000000000000 gate.kind=direct`,
 				},
 			},
 		},
@@ -83,6 +86,7 @@ func TestPumpe_Handle(t *testing.T) {
 			exp: tcExpected{
 				msgs: []string{
 					`level=DEBUG msg="handling request" handler.method=handle http.method=GET http.host=httpbin.org http.client.ip=192.0.2.1:1234 http.scheme=http http.uri.path=/ip http.header.user_agent=""`,
+					`level=INFO msg="handled request" handler.method=handle http.method=GET http.host=httpbin.org http.client.ip=192.0.2.1:1234 http.scheme=http http.uri.path=/ip http.header.user_agent="" gate.id=5ca1ab1e-0000-4000-a000-000000000000 gate.kind=direct`,
 				},
 			},
 		},
@@ -91,8 +95,8 @@ func TestPumpe_Handle(t *testing.T) {
 			name: "http_error",
 			given: tcGiven{
 				svc: &mockPumpeSvc{
-					fnHandleHTTP: func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-						return model.Error("something_went_wrong")
+					fnHandleHTTP: func(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
+						return nil, model.Error("something_went_wrong")
 					},
 				},
 				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org/ip", nil),
@@ -106,12 +110,35 @@ func TestPumpe_Handle(t *testing.T) {
 			},
 		},
 
+		{
+			name: "http_error_with_gate",
+			given: tcGiven{
+				svc: &mockPumpeSvc{
+					fnHandleHTTP: func(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							FnKind: func() gate.Kind { return gate.KindTor },
+						}
+
+						return result, model.Error("something_went_wrong")
+					},
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org/ip", nil),
+			},
+
+			exp: tcExpected{
+				msgs: []string{
+					`level=DEBUG msg="handling request" handler.method=handle http.method=GET http.host=httpbin.org http.client.ip=192.0.2.1:1234 http.scheme=http http.uri.path=/ip http.header.user_agent=""`,
+					`level=ERROR msg="request ended with error" handler.method=handle http.method=GET http.host=httpbin.org http.client.ip=192.0.2.1:1234 http.scheme=http http.uri.path=/ip http.header.user_agent="" gate.id=5ca1ab1e-0000-4000-a000-000000000000 gate.kind=tor error=something_went_wrong`,
+				},
+			},
+		},
+
 		{
 			name: "default_error",
 			given: tcGiven{
 				svc: &mockPumpeSvc{
-					fnHandleHTTP: func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-						return model.Error("something_went_wrong")
+					fnHandleHTTP: func(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
+						return nil, model.Error("something_went_wrong")
 					},
 				},
 				req: httptest.NewRequest(http.MethodGet, "htxp://httpbin.org/ip", nil),
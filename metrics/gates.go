@@ -0,0 +1,43 @@
+package metrics
+
+import "github.com/pavelbrm/pumpe/gate"
+
+type statser interface {
+	Stats() gate.SetStats
+}
+
+// RegisterGates registers the number of gates by kind and state, and the requests in flight via them.
+//
+// Both are read from the set when rendering, so nothing has to be updated as gates come and go.
+func RegisterGates(r *Registry, set statser) {
+	r.NewGaugeFunc("pumpe_gates", "Gates by kind and state.", []string{"kind", "state"}, func() []Sample {
+		st := set.Stats()
+
+		result := make([]Sample, 0, 3*len(st.Kinds))
+
+		for i := range st.Kinds {
+			kind := st.Kinds[i].Kind.String()
+
+			result = append(
+				result,
+				Sample{Values: []string{kind, "ready"}, Value: int64(st.Kinds[i].Ready)},
+				Sample{Values: []string{kind, "maintenance"}, Value: int64(st.Kinds[i].Maintenance)},
+				Sample{Values: []string{kind, "closed"}, Value: int64(st.Kinds[i].Closed)},
+			)
+		}
+
+		return result
+	})
+
+	r.NewGaugeFunc("pumpe_gate_requests_in_flight", "Requests in flight via gates by kind.", []string{"kind"}, func() []Sample {
+		st := set.Stats()
+
+		result := make([]Sample, 0, len(st.Kinds))
+
+		for i := range st.Kinds {
+			result = append(result, Sample{Values: []string{st.Kinds[i].Kind.String()}, Value: int64(st.Kinds[i].InFlight)})
+		}
+
+		return result
+	})
+}
@@ -0,0 +1,257 @@
+// Package metrics keeps counters and gauges, and renders them in the OpenMetrics text format.
+//
+// It covers only what Pumpe needs, so that no metrics library is required.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the rendered metrics.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+// Registry holds metric families, and renders them in the order they were registered.
+type Registry struct {
+	mu   *sync.Mutex
+	fams []family
+}
+
+func NewRegistry() *Registry {
+	result := &Registry{
+		mu: &sync.Mutex{},
+	}
+
+	return result
+}
+
+// NewCounterVec registers a family of counters with the given label names.
+//
+// The name must not have the _total suffix, which is added to samples when rendering.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	result := &CounterVec{
+		labels: labels,
+		mu:     &sync.RWMutex{},
+		cs:     make(map[string]*labelledCounter),
+	}
+
+	r.register(family{name: name, help: help, typ: typeCounter, labels: labels, fnSamples: result.samples})
+
+	return result
+}
+
+// NewGauge registers a gauge without labels.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	result := &Gauge{}
+
+	r.register(family{name: name, help: help, typ: typeGauge, fnSamples: func() []Sample {
+		return []Sample{{Value: result.Value()}}
+	}})
+
+	return result
+}
+
+// NewGaugeFunc registers a family of gauges whose samples are taken from fn when rendering.
+//
+// Each sample must have a value for each of the labels.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, fn func() []Sample) {
+	r.register(family{name: name, help: help, typ: typeGauge, labels: labels, fnSamples: fn})
+}
+
+// WriteTo renders all metrics to w.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	fams := make([]family, len(r.fams))
+	copy(fams, r.fams)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for i := range fams {
+		fams[i].write(bw)
+	}
+
+	_, _ = bw.WriteString("# EOF\n")
+
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+func (r *Registry) register(fam family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fams = append(r.fams, fam)
+}
+
+// Sample is a single value of a metric, with label values in the order of the family's labels.
+type Sample struct {
+	Values []string
+	Value  int64
+}
+
+// Counter is a monotonically increasing value, safe for concurrent use.
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+// Gauge is a value that can go up and down, safe for concurrent use.
+type Gauge struct {
+	v atomic.Int64
+}
+
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+// CounterVec is a family of counters, one for each combination of label values.
+type CounterVec struct {
+	labels []string
+	mu     *sync.RWMutex
+	cs     map[string]*labelledCounter
+}
+
+// With returns the counter for the label values, creating it on first use.
+func (v *CounterVec) With(values ...string) *Counter {
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	lc, ok := v.cs[key]
+	v.mu.RUnlock()
+
+	if ok {
+		return lc.c
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if lc, ok := v.cs[key]; ok {
+		return lc.c
+	}
+
+	lc = &labelledCounter{values: values, c: &Counter{}}
+	v.cs[key] = lc
+
+	return lc.c
+}
+
+// samples returns the counters sorted by label values, so that the output is stable.
+func (v *CounterVec) samples() []Sample {
+	v.mu.RLock()
+
+	result := make([]Sample, 0, len(v.cs))
+	for _, lc := range v.cs {
+		result = append(result, Sample{Values: lc.values, Value: int64(lc.c.Value())})
+	}
+
+	v.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return strings.Join(result[i].Values, "\xff") < strings.Join(result[j].Values, "\xff")
+	})
+
+	return result
+}
+
+type labelledCounter struct {
+	values []string
+	c      *Counter
+}
+
+type family struct {
+	name      string
+	help      string
+	typ       string
+	labels    []string
+	fnSamples func() []Sample
+}
+
+func (f family) write(w *bufio.Writer) {
+	_, _ = w.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+	_, _ = w.WriteString("# HELP " + f.name + " " + escape(f.help) + "\n")
+
+	name := f.name
+	if f.typ == typeCounter {
+		name += "_total"
+	}
+
+	samples := f.fnSamples()
+
+	for i := range samples {
+		_, _ = w.WriteString(name)
+
+		writeLabels(w, f.labels, samples[i].Values)
+
+		_, _ = w.WriteString(" " + strconv.FormatInt(samples[i].Value, 10) + "\n")
+	}
+}
+
+func writeLabels(w *bufio.Writer, names, values []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	_ = w.WriteByte('{')
+
+	for i := range names {
+		if i > 0 {
+			_ = w.WriteByte(',')
+		}
+
+		var val string
+		if i < len(values) {
+			val = values[i]
+		}
+
+		_, _ = w.WriteString(names[i] + `="` + escape(val) + `"`)
+	}
+
+	_ = w.WriteByte('}')
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+
+	return n, err
+}
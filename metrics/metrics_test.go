@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	should "github.com/stretchr/testify/assert"
+	must "github.com/stretchr/testify/require"
+
+	"github.com/pavelbrm/pumpe/gate"
+)
+
+type testCase[G, E any] struct {
+	name  string
+	given G
+	exp   E
+}
+
+func TestRegistry_WriteTo(t *testing.T) {
+	tests := []testCase[func(r *Registry), string]{
+		{
+			name:  "empty",
+			given: func(r *Registry) {},
+			exp:   "# EOF\n",
+		},
+
+		{
+			name: "counter_vec",
+			given: func(r *Registry) {
+				cv := r.NewCounterVec("pumpe_requests", "Proxy requests.", "kind", "outcome")
+				cv.With("tor", "ok").Inc()
+				cv.With("tor", "ok").Inc()
+				cv.With("direct", "error").Inc()
+			},
+			exp: "# TYPE pumpe_requests counter\n" +
+				"# HELP pumpe_requests Proxy requests.\n" +
+				"pumpe_requests_total{kind=\"direct\",outcome=\"error\"} 1\n" +
+				"pumpe_requests_total{kind=\"tor\",outcome=\"ok\"} 2\n" +
+				"# EOF\n",
+		},
+
+		{
+			name: "gauge",
+			given: func(r *Registry) {
+				g := r.NewGauge("pumpe_requests_in_flight", "Requests being handled.")
+				g.Inc()
+				g.Inc()
+				g.Dec()
+			},
+			exp: "# TYPE pumpe_requests_in_flight gauge\n" +
+				"# HELP pumpe_requests_in_flight Requests being handled.\n" +
+				"pumpe_requests_in_flight 1\n" +
+				"# EOF\n",
+		},
+
+		{
+			name: "gauge_func_escaped",
+			given: func(r *Registry) {
+				r.NewGaugeFunc("pumpe_gates", "Gates by \"kind\".", []string{"kind"}, func() []Sample {
+					return []Sample{{Values: []string{"a\\b\"c\nd"}, Value: 3}}
+				})
+			},
+			exp: "# TYPE pumpe_gates gauge\n" +
+				"# HELP pumpe_gates Gates by \\\"kind\\\".\n" +
+				"pumpe_gates{kind=\"a\\\\b\\\"c\\nd\"} 3\n" +
+				"# EOF\n",
+		},
+
+		{
+			name: "registration_order",
+			given: func(r *Registry) {
+				r.NewGauge("pumpe_b", "B.")
+				r.NewGauge("pumpe_a", "A.")
+			},
+			exp: "# TYPE pumpe_b gauge\n# HELP pumpe_b B.\npumpe_b 0\n" +
+				"# TYPE pumpe_a gauge\n# HELP pumpe_a A.\npumpe_a 0\n" +
+				"# EOF\n",
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			reg := NewRegistry()
+			tc.given(reg)
+
+			buf := &bytes.Buffer{}
+
+			n, err := reg.WriteTo(buf)
+			must.Equal(t, nil, err)
+
+			should.Equal(t, tc.exp, buf.String())
+			should.Equal(t, int64(len(tc.exp)), n)
+		})
+	}
+}
+
+func TestCounterVec_With(t *testing.T) {
+	cv := NewRegistry().NewCounterVec("pumpe_requests", "Proxy requests.", "kind")
+
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				cv.With("tor").Inc()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	should.Equal(t, uint64(800), cv.With("tor").Value())
+}
+
+func TestRequests(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		var m *Requests
+
+		m.Start()
+		m.Finish("tor", true)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		reg := NewRegistry()
+		m := NewRequests(reg)
+
+		m.Start()
+		m.Start()
+		m.Finish("tor", true)
+
+		buf := &bytes.Buffer{}
+		_, err := reg.WriteTo(buf)
+		must.Equal(t, nil, err)
+
+		exp := "# TYPE pumpe_requests counter\n" +
+			"# HELP pumpe_requests Proxy requests by gate kind and outcome.\n" +
+			"pumpe_requests_total{kind=\"tor\",outcome=\"ok\"} 1\n" +
+			"# TYPE pumpe_requests_in_flight gauge\n" +
+			"# HELP pumpe_requests_in_flight Proxy requests being handled.\n" +
+			"pumpe_requests_in_flight 1\n" +
+			"# EOF\n"
+
+		should.Equal(t, exp, buf.String())
+	})
+}
+
+func TestRegisterGates(t *testing.T) {
+	set := &mockStatser{
+		st: gate.SetStats{
+			Kinds: []gate.KindStats{
+				{Kind: gate.KindDirect, Total: 1, Ready: 1, InFlight: 2},
+				{Kind: gate.KindTor, Total: 4, Ready: 2, Maintenance: 1, Closed: 1, InFlight: 5},
+			},
+			InFlight: 7,
+		},
+	}
+
+	reg := NewRegistry()
+	RegisterGates(reg, set)
+
+	buf := &bytes.Buffer{}
+	_, err := reg.WriteTo(buf)
+	must.Equal(t, nil, err)
+
+	exp := "# TYPE pumpe_gates gauge\n" +
+		"# HELP pumpe_gates Gates by kind and state.\n" +
+		"pumpe_gates{kind=\"direct\",state=\"ready\"} 1\n" +
+		"pumpe_gates{kind=\"direct\",state=\"maintenance\"} 0\n" +
+		"pumpe_gates{kind=\"direct\",state=\"closed\"} 0\n" +
+		"pumpe_gates{kind=\"tor\",state=\"ready\"} 2\n" +
+		"pumpe_gates{kind=\"tor\",state=\"maintenance\"} 1\n" +
+		"pumpe_gates{kind=\"tor\",state=\"closed\"} 1\n" +
+		"# TYPE pumpe_gate_requests_in_flight gauge\n" +
+		"# HELP pumpe_gate_requests_in_flight Requests in flight via gates by kind.\n" +
+		"pumpe_gate_requests_in_flight{kind=\"direct\"} 2\n" +
+		"pumpe_gate_requests_in_flight{kind=\"tor\"} 5\n" +
+		"# EOF\n"
+
+	should.Equal(t, exp, buf.String())
+}
+
+type mockStatser struct {
+	st gate.SetStats
+}
+
+func (s *mockStatser) Stats() gate.SetStats {
+	return s.st
+}
@@ -0,0 +1,48 @@
+package metrics
+
+const (
+	outcomeOK    = "ok"
+	outcomeError = "error"
+)
+
+// Requests tracks proxy requests by gate kind and outcome, along with the requests in flight.
+//
+// A nil Requests does nothing, so that tracking is optional for its users.
+type Requests struct {
+	total    *CounterVec
+	inFlight *Gauge
+}
+
+func NewRequests(r *Registry) *Requests {
+	result := &Requests{
+		total:    r.NewCounterVec("pumpe_requests", "Proxy requests by gate kind and outcome.", "kind", "outcome"),
+		inFlight: r.NewGauge("pumpe_requests_in_flight", "Proxy requests being handled."),
+	}
+
+	return result
+}
+
+// Start counts a request in flight until Finish is called for it.
+func (m *Requests) Start() {
+	if m == nil {
+		return
+	}
+
+	m.inFlight.Inc()
+}
+
+// Finish counts a finished request via a gate of kind.
+func (m *Requests) Finish(kind string, ok bool) {
+	if m == nil {
+		return
+	}
+
+	m.inFlight.Dec()
+
+	outcome := outcomeOK
+	if !ok {
+		outcome = outcomeError
+	}
+
+	m.total.With(kind, outcome).Inc()
+}
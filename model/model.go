@@ -15,6 +15,8 @@ const (
 	ErrSomethingWentWrong    Error = "something went wrong"
 	ErrInvalidParam          Error = "invalid param"
 	ErrInvalidUUID           Error = "invalid uuid"
+	ErrMissingParam          Error = "missing param"
+	ErrUnknownField          Error = "unknown field"
 	ErrHijackingNotSupported Error = "model: connection hijacking is not supported"
 )
 
@@ -24,9 +26,21 @@ func (e Error) Error() string {
 	return string(e)
 }
 
+// Set is a map safe for concurrent use, which also picks random values.
+//
+// Values are kept in a slice, so that a random pick depends only on the source of randomness.
 type Set[K comparable, V any] struct {
-	mu  sync.RWMutex
-	set map[K]V
+	mu    sync.RWMutex
+	idx   map[K]int
+	items []setItem[K, V]
+
+	// intn returns a number in [0, n), and defaults to rand.IntN.
+	intn func(n int) int
+}
+
+type setItem[K comparable, V any] struct {
+	k K
+	v V
 }
 
 func NewSet[K comparable, V any]() *Set[K, V] {
@@ -35,40 +49,92 @@ func NewSet[K comparable, V any]() *Set[K, V] {
 
 func NewSetSize[K comparable, V any](size int) *Set[K, V] {
 	result := &Set[K, V]{
-		set: make(map[K]V, size),
+		idx:   make(map[K]int, size),
+		items: make([]setItem[K, V], 0, size),
 	}
 
 	return result
 }
 
+// SetIntN makes s pick random values with fn, which must return a number in [0, n).
+//
+// It allows for reproducible picks, e.g. with a seeded *rand.Rand guarded for concurrent use.
+// A nil fn restores the default, rand.IntN.
+func (s *Set[K, V]) SetIntN(fn func(n int) int) {
+	s.mu.Lock()
+	s.intn = fn
+	s.mu.Unlock()
+}
+
 func (s *Set[K, V]) Get(k K) (V, bool) {
 	s.mu.RLock()
-	v, ok := s.set[k]
-	s.mu.RUnlock()
+	defer s.mu.RUnlock()
 
-	return v, ok
+	i, ok := s.idx[k]
+	if !ok {
+		var v V
+		return v, false
+	}
+
+	return s.items[i].v, true
 }
 
 func (s *Set[K, V]) Set(k K, v V) {
 	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if s.set == nil {
-		s.set = make(map[K]V)
+	if s.idx == nil {
+		s.idx = make(map[K]int)
 	}
 
-	s.set[k] = v
-	s.mu.Unlock()
+	if i, ok := s.idx[k]; ok {
+		s.items[i].v = v
+
+		return
+	}
+
+	s.idx[k] = len(s.items)
+	s.items = append(s.items, setItem[K, V]{k: k, v: v})
 }
 
 func (s *Set[K, V]) Remove(k K) {
 	s.mu.Lock()
-	delete(s.set, k)
+	s.remove(k)
 	s.mu.Unlock()
 }
 
+// Replace puts v under k in place of the value under old, as a single step.
+//
+// It does nothing and returns false when old is not in s.
+func (s *Set[K, V]) Replace(old, k K, v V) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.idx[old]
+	if !ok {
+		return false
+	}
+
+	if old != k {
+		if _, ok := s.idx[k]; ok {
+			s.remove(old)
+			s.items[s.idx[k]].v = v
+
+			return true
+		}
+
+		delete(s.idx, old)
+		s.idx[k] = i
+	}
+
+	s.items[i] = setItem[K, V]{k: k, v: v}
+
+	return true
+}
+
 func (s *Set[K, V]) Len() int {
 	s.mu.RLock()
-	l := len(s.set)
+	l := len(s.items)
 	s.mu.RUnlock()
 
 	return l
@@ -78,39 +144,53 @@ func (s *Set[K, V]) Random() (V, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	n := len(s.set)
+	n := len(s.items)
 	if n == 0 {
 		var v V
 		return v, false
 	}
 
-	lim := rand.IntN(n)
-	for k := range s.set {
-		if lim == 0 {
-			return s.set[k], true
-		}
+	return s.items[s.randIntN(n)].v, true
+}
 
-		lim--
+// RandomExcept returns a random value which key is not k.
+func (s *Set[K, V]) RandomExcept(k K) (V, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := len(s.items)
+
+	skip, ok := s.idx[k]
+	if ok {
+		n--
 	}
 
-	// Unreachable.
-	var v V
+	if n <= 0 {
+		var v V
+		return v, false
+	}
 
-	return v, false
+	// Pick among the others, as if the excluded item was not in the slice.
+	i := s.randIntN(n)
+	if ok && i >= skip {
+		i++
+	}
+
+	return s.items[i].v, true
 }
 
 func (s *Set[K, V]) Keys() []K {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	n := len(s.set)
+	n := len(s.items)
 	if n == 0 {
 		return nil
 	}
 
 	result := make([]K, 0, n)
-	for k := range s.set {
-		result = append(result, k)
+	for i := range s.items {
+		result = append(result, s.items[i].k)
 	}
 
 	return result
@@ -120,19 +200,47 @@ func (s *Set[K, V]) Values() []V {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	n := len(s.set)
+	n := len(s.items)
 	if n == 0 {
 		return nil
 	}
 
 	result := make([]V, 0, n)
-	for k := range s.set {
-		result = append(result, s.set[k])
+	for i := range s.items {
+		result = append(result, s.items[i].v)
 	}
 
 	return result
 }
 
+// remove deletes k by moving the last item into its place, and must be called with s.mu held.
+func (s *Set[K, V]) remove(k K) {
+	i, ok := s.idx[k]
+	if !ok {
+		return
+	}
+
+	last := len(s.items) - 1
+	if i != last {
+		s.items[i] = s.items[last]
+		s.idx[s.items[i].k] = i
+	}
+
+	// Let go of the value, which might be large.
+	s.items[last] = setItem[K, V]{}
+	s.items = s.items[:last]
+
+	delete(s.idx, k)
+}
+
+func (s *Set[K, V]) randIntN(n int) int {
+	if s.intn == nil {
+		return rand.IntN(n)
+	}
+
+	return s.intn(n)
+}
+
 // UnwrapErrs unwraps errs if it represents an unwrappable error.
 //
 // If errs can't be unwrapped, the result is nil.
@@ -226,6 +226,100 @@ func TestSet_Remove(t *testing.T) {
 	}
 }
 
+func TestSet_Replace(t *testing.T) {
+	type tcGiven struct {
+		set *Set[string, string]
+		old string
+		k   string
+		v   string
+	}
+
+	type tcExpected struct {
+		ok   bool
+		keys []string
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "empty_literal",
+			given: tcGiven{
+				set: &Set[string, string]{},
+				old: "k_01",
+				k:   "k_02",
+				v:   "v_02",
+			},
+		},
+
+		{
+			name: "not_found",
+			given: tcGiven{
+				set: func() *Set[string, string] {
+					set := NewSet[string, string]()
+					set.Set("k_03", "v_03")
+
+					return set
+				}(),
+				old: "k_01",
+				k:   "k_02",
+				v:   "v_02",
+			},
+			exp: tcExpected{
+				keys: []string{"k_03"},
+			},
+		},
+
+		{
+			name: "replaced",
+			given: tcGiven{
+				set: func() *Set[string, string] {
+					set := NewSet[string, string]()
+					set.Set("k_01", "v_01")
+
+					return set
+				}(),
+				old: "k_01",
+				k:   "k_02",
+				v:   "v_02",
+			},
+			exp: tcExpected{
+				ok:   true,
+				keys: []string{"k_02"},
+			},
+		},
+
+		{
+			name: "replaced_existing",
+			given: tcGiven{
+				set: func() *Set[string, string] {
+					set := NewSet[string, string]()
+					set.Set("k_01", "v_01")
+					set.Set("k_02", "v_02")
+					set.Set("k_03", "v_03")
+
+					return set
+				}(),
+				old: "k_01",
+				k:   "k_03",
+				v:   "v_03_replaced",
+			},
+			exp: tcExpected{
+				ok:   true,
+				keys: []string{"k_03", "k_02"},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ok := tc.given.set.Replace(tc.given.old, tc.given.k, tc.given.v)
+			should.Equal(t, tc.exp.ok, ok)
+			should.Equal(t, tc.exp.keys, tc.given.set.Keys())
+		})
+	}
+}
+
 func TestSet_Len(t *testing.T) {
 	tests := []testCase[*Set[string, string], int]{
 		{
@@ -325,6 +419,159 @@ func TestSet_Random(t *testing.T) {
 	}
 }
 
+func TestSet_RandomExcept(t *testing.T) {
+	type tcGiven struct {
+		set *Set[string, string]
+		k   string
+	}
+
+	type tcExpected struct {
+		val string
+		ok  bool
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "empty_literal",
+			given: tcGiven{
+				set: &Set[string, string]{},
+				k:   "k_01",
+			},
+		},
+
+		{
+			name: "only_excluded",
+			given: tcGiven{
+				set: func() *Set[string, string] {
+					set := NewSet[string, string]()
+					set.Set("k_01", "v_01")
+
+					return set
+				}(),
+				k: "k_01",
+			},
+		},
+
+		{
+			name: "single_other",
+			given: tcGiven{
+				set: func() *Set[string, string] {
+					set := NewSet[string, string]()
+					set.Set("k_01", "v_01")
+					set.Set("k_02", "v_02")
+
+					return set
+				}(),
+				k: "k_01",
+			},
+			exp: tcExpected{val: "v_02", ok: true},
+		},
+
+		{
+			name: "missing_excluded",
+			given: tcGiven{
+				set: func() *Set[string, string] {
+					set := NewSet[string, string]()
+					set.Set("k_02", "v_02")
+
+					return set
+				}(),
+				k: "k_01",
+			},
+			exp: tcExpected{val: "v_02", ok: true},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			// Repeat to cover the random pick.
+			for j := 0; j < 10; j++ {
+				actual, ok := tc.given.set.RandomExcept(tc.given.k)
+				should.Equal(t, tc.exp.ok, ok)
+				should.Equal(t, tc.exp.val, actual)
+			}
+		})
+	}
+}
+
+func TestSet_SetIntN(t *testing.T) {
+	type tcGiven struct {
+		picks []int
+		k     string
+	}
+
+	tests := []testCase[tcGiven, []string]{
+		{
+			name: "random",
+			given: tcGiven{
+				picks: []int{2, 0, 1},
+			},
+			exp: []string{"v_03", "v_01", "v_02"},
+		},
+
+		{
+			name: "random_except",
+			given: tcGiven{
+				picks: []int{0, 1},
+				k:     "k_02",
+			},
+			exp: []string{"v_01", "v_03"},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			set := NewSet[string, string]()
+			set.Set("k_01", "v_01")
+			set.Set("k_02", "v_02")
+			set.Set("k_03", "v_03")
+
+			var next int
+			set.SetIntN(func(n int) int {
+				result := tc.given.picks[next] % n
+				next++
+
+				return result
+			})
+
+			actual := make([]string, 0, len(tc.given.picks))
+
+			for range tc.given.picks {
+				var val string
+				if tc.given.k == "" {
+					val, _ = set.Random()
+				} else {
+					val, _ = set.RandomExcept(tc.given.k)
+				}
+
+				actual = append(actual, val)
+			}
+
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+
+	t.Run("removed", func(t *testing.T) {
+		set := NewSet[string, string]()
+		set.Set("k_01", "v_01")
+		set.Set("k_02", "v_02")
+		set.Set("k_03", "v_03")
+		set.Remove("k_01")
+
+		set.SetIntN(func(n int) int { return 0 })
+
+		actual, ok := set.Random()
+		should.Equal(t, true, ok)
+		should.Equal(t, "v_03", actual)
+
+		should.Equal(t, []string{"k_03", "k_02"}, set.Keys())
+	})
+}
+
 func TestSet_Keys(t *testing.T) {
 	tests := []testCase[*Set[string, string], []string]{
 		{
@@ -3,6 +3,7 @@ package service
 import (
 	"bytes"
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -85,9 +86,17 @@ func (w *mockReadWriteCloser) CloseRead() error {
 }
 
 type mockGateSet struct {
-	fnByID   func(id uuid.UUID) (gate.ExitGate, error)
-	fnByKind func(ctx context.Context, kind gate.Kind) (gate.ExitGate, error)
-	fnRandom func(ctx context.Context) (gate.ExitGate, error)
+	fnByID     func(id uuid.UUID) (gate.ExitGate, error)
+	fnByIDWait func(ctx context.Context, id uuid.UUID) (gate.ExitGate, error)
+	fnByKind   func(ctx context.Context, kind gate.Kind) (gate.ExitGate, error)
+	fnByIndex  func(kind gate.Kind, i int) (gate.ExitGate, error)
+	fnByGroup  func(ctx context.Context, name string) (gate.ExitGate, error)
+	fnRandom   func(ctx context.Context) (gate.ExitGate, error)
+
+	fnRandomExcept func(ctx context.Context, kind gate.Kind, id uuid.UUID) (gate.ExitGate, error)
+	fnReportResult func(id uuid.UUID, ok bool)
+	fnReady        func() <-chan struct{}
+	fnIsPaused     func() bool
 }
 
 func (s *mockGateSet) ByID(id uuid.UUID) (gate.ExitGate, error) {
@@ -102,6 +111,14 @@ func (s *mockGateSet) ByID(id uuid.UUID) (gate.ExitGate, error) {
 	return s.fnByID(id)
 }
 
+func (s *mockGateSet) ByIDWait(ctx context.Context, id uuid.UUID) (gate.ExitGate, error) {
+	if s.fnByIDWait == nil {
+		return s.ByID(id)
+	}
+
+	return s.fnByIDWait(ctx, id)
+}
+
 func (s *mockGateSet) ByKind(ctx context.Context, kind gate.Kind) (gate.ExitGate, error) {
 	if s.fnByKind == nil {
 		result := &gate.MockExitGate{
@@ -114,6 +131,28 @@ func (s *mockGateSet) ByKind(ctx context.Context, kind gate.Kind) (gate.ExitGate
 	return s.fnByKind(ctx, kind)
 }
 
+func (s *mockGateSet) ByIndex(kind gate.Kind, i int) (gate.ExitGate, error) {
+	if s.fnByIndex == nil {
+		result := &gate.MockExitGate{
+			FnKind: func() gate.Kind { return kind },
+		}
+
+		return result, nil
+	}
+
+	return s.fnByIndex(kind, i)
+}
+
+func (s *mockGateSet) ByGroup(ctx context.Context, name string) (gate.ExitGate, error) {
+	if s.fnByGroup == nil {
+		result := &gate.MockExitGate{}
+
+		return result, nil
+	}
+
+	return s.fnByGroup(ctx, name)
+}
+
 func (s *mockGateSet) Random(ctx context.Context) (gate.ExitGate, error) {
 	if s.fnRandom == nil {
 		result := &gate.MockExitGate{}
@@ -124,11 +163,78 @@ func (s *mockGateSet) Random(ctx context.Context) (gate.ExitGate, error) {
 	return s.fnRandom(ctx)
 }
 
+func (s *mockGateSet) RandomExcept(ctx context.Context, kind gate.Kind, id uuid.UUID) (gate.ExitGate, error) {
+	if s.fnRandomExcept == nil {
+		return nil, gate.ErrNoRandomGate
+	}
+
+	return s.fnRandomExcept(ctx, kind, id)
+}
+
+func (s *mockGateSet) ReportResult(id uuid.UUID, ok bool) {
+	if s.fnReportResult == nil {
+		return
+	}
+
+	s.fnReportResult(id, ok)
+}
+
+func (s *mockGateSet) Ready() <-chan struct{} {
+	if s.fnReady == nil {
+		return closedReady()
+	}
+
+	return s.fnReady()
+}
+
+func (s *mockGateSet) IsPaused() bool {
+	if s.fnIsPaused == nil {
+		return false
+	}
+
+	return s.fnIsPaused()
+}
+
+// mockGateSetMin implements only the required part of gateSet, and supports no selection.
+type mockGateSetMin struct{}
+
+func (s *mockGateSetMin) ReportResult(id uuid.UUID, ok bool) {}
+
+func (s *mockGateSetMin) Ready() <-chan struct{} {
+	return closedReady()
+}
+
+func closedReady() <-chan struct{} {
+	result := make(chan struct{})
+	close(result)
+
+	return result
+}
+
+func (s *mockGateSetMin) IsPaused() bool {
+	return false
+}
+
 type mockGateSetProxy struct {
 	fnGateIDs    func(kind gate.Kind) ([]uuid.UUID, error)
-	fnNew        func(ctx context.Context, kind gate.Kind) (uuid.UUID, error)
+	fnGateInfos  func(kind gate.Kind) ([]gate.GateInfo, error)
+	fnGateInfo   func(id uuid.UUID) (gate.GateInfo, error)
+	fnNewN       func(ctx context.Context, kind gate.Kind, n int) ([]uuid.UUID, error)
 	fnRefreshOne func(ctx context.Context, id uuid.UUID) error
+	fnAnnotate   func(id uuid.UUID, annots map[string]string) (map[string]string, error)
+	fnProbeOne   func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error)
 	fnCloseOne   func(ctx context.Context, id uuid.UUID) error
+	fnForceClose func(ctx context.Context, id uuid.UUID) error
+	fnCloseIdle  func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error)
+	fnScaleTors  func(ctx context.Context, n int) (*gate.ScaleResult, error)
+	fnReload     func(ctx context.Context) (*gate.ReloadResult, error)
+	fnConfigView func() *gate.ConfigView
+	fnStats      func() gate.SetStats
+	fnPause      func(ctx context.Context) error
+	fnResume     func(ctx context.Context) error
+	fnGroups     func() map[string][]uuid.UUID
+	fnSetGroup   func(name string, ids []uuid.UUID) error
+	fnDelGroup   func(name string) error
 }
 
 func (s *mockGateSetProxy) GateIDs(kind gate.Kind) ([]uuid.UUID, error) {
@@ -139,12 +245,28 @@ func (s *mockGateSetProxy) GateIDs(kind gate.Kind) ([]uuid.UUID, error) {
 	return s.fnGateIDs(kind)
 }
 
-func (s *mockGateSetProxy) New(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
-	if s.fnNew == nil {
-		return uuid.MustParse("decade00-0000-4000-a000-000000000000"), nil
+func (s *mockGateSetProxy) GateInfos(kind gate.Kind) ([]gate.GateInfo, error) {
+	if s.fnGateInfos == nil {
+		return nil, nil
+	}
+
+	return s.fnGateInfos(kind)
+}
+
+func (s *mockGateSetProxy) GateInfo(id uuid.UUID) (gate.GateInfo, error) {
+	if s.fnGateInfo == nil {
+		return gate.GateInfo{ID: id}, nil
 	}
 
-	return s.fnNew(ctx, kind)
+	return s.fnGateInfo(id)
+}
+
+func (s *mockGateSetProxy) NewN(ctx context.Context, kind gate.Kind, n int) ([]uuid.UUID, error) {
+	if s.fnNewN == nil {
+		return []uuid.UUID{uuid.MustParse("decade00-0000-4000-a000-000000000000")}, nil
+	}
+
+	return s.fnNewN(ctx, kind, n)
 }
 
 func (s *mockGateSetProxy) RefreshOne(ctx context.Context, id uuid.UUID) error {
@@ -155,6 +277,22 @@ func (s *mockGateSetProxy) RefreshOne(ctx context.Context, id uuid.UUID) error {
 	return s.fnRefreshOne(ctx, id)
 }
 
+func (s *mockGateSetProxy) AnnotateOne(id uuid.UUID, annots map[string]string) (map[string]string, error) {
+	if s.fnAnnotate == nil {
+		return annots, nil
+	}
+
+	return s.fnAnnotate(id, annots)
+}
+
+func (s *mockGateSetProxy) ProbeOne(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+	if s.fnProbeOne == nil {
+		return &gate.ProbeResult{Status: 200}, nil
+	}
+
+	return s.fnProbeOne(ctx, id, rawURL)
+}
+
 func (s *mockGateSetProxy) CloseOne(ctx context.Context, id uuid.UUID) error {
 	if s.fnCloseOne == nil {
 		return nil
@@ -162,3 +300,91 @@ func (s *mockGateSetProxy) CloseOne(ctx context.Context, id uuid.UUID) error {
 
 	return s.fnCloseOne(ctx, id)
 }
+
+func (s *mockGateSetProxy) ForceCloseOne(ctx context.Context, id uuid.UUID) error {
+	if s.fnForceClose == nil {
+		return nil
+	}
+
+	return s.fnForceClose(ctx, id)
+}
+
+func (s *mockGateSetProxy) CloseIdle(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+	if s.fnCloseIdle == nil {
+		return nil, nil
+	}
+
+	return s.fnCloseIdle(ctx, kind, minIdle)
+}
+
+func (s *mockGateSetProxy) ScaleTors(ctx context.Context, n int) (*gate.ScaleResult, error) {
+	if s.fnScaleTors == nil {
+		return &gate.ScaleResult{}, nil
+	}
+
+	return s.fnScaleTors(ctx, n)
+}
+
+func (s *mockGateSetProxy) Reload(ctx context.Context) (*gate.ReloadResult, error) {
+	if s.fnReload == nil {
+		return &gate.ReloadResult{}, nil
+	}
+
+	return s.fnReload(ctx)
+}
+
+func (s *mockGateSetProxy) ConfigView() *gate.ConfigView {
+	if s.fnConfigView == nil {
+		return &gate.ConfigView{}
+	}
+
+	return s.fnConfigView()
+}
+
+func (s *mockGateSetProxy) Stats() gate.SetStats {
+	if s.fnStats == nil {
+		return gate.SetStats{}
+	}
+
+	return s.fnStats()
+}
+
+func (s *mockGateSetProxy) Pause(ctx context.Context) error {
+	if s.fnPause == nil {
+		return nil
+	}
+
+	return s.fnPause(ctx)
+}
+
+func (s *mockGateSetProxy) Resume(ctx context.Context) error {
+	if s.fnResume == nil {
+		return nil
+	}
+
+	return s.fnResume(ctx)
+}
+
+func (s *mockGateSetProxy) Groups() map[string][]uuid.UUID {
+	if s.fnGroups == nil {
+		return nil
+	}
+
+	return s.fnGroups()
+}
+
+func (s *mockGateSetProxy) SetGroup(name string, ids []uuid.UUID) error {
+	if s.fnSetGroup == nil {
+		return nil
+	}
+
+	return s.fnSetGroup(name, ids)
+}
+
+func (s *mockGateSetProxy) DeleteGroup(name string) error {
+	if s.fnDelGroup == nil {
+		return nil
+	}
+
+	return s.fnDelGroup(name)
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -10,9 +11,24 @@ import (
 
 type gateSetProxy interface {
 	GateIDs(kind gate.Kind) ([]uuid.UUID, error)
-	New(ctx context.Context, kind gate.Kind) (uuid.UUID, error)
+	GateInfos(kind gate.Kind) ([]gate.GateInfo, error)
+	GateInfo(id uuid.UUID) (gate.GateInfo, error)
+	NewN(ctx context.Context, kind gate.Kind, n int) ([]uuid.UUID, error)
 	RefreshOne(ctx context.Context, id uuid.UUID) error
+	AnnotateOne(id uuid.UUID, annots map[string]string) (map[string]string, error)
+	ProbeOne(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error)
 	CloseOne(ctx context.Context, id uuid.UUID) error
+	ForceCloseOne(ctx context.Context, id uuid.UUID) error
+	CloseIdle(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error)
+	ScaleTors(ctx context.Context, n int) (*gate.ScaleResult, error)
+	Reload(ctx context.Context) (*gate.ReloadResult, error)
+	ConfigView() *gate.ConfigView
+	Stats() gate.SetStats
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+	Groups() map[string][]uuid.UUID
+	SetGroup(name string, ids []uuid.UUID) error
+	DeleteGroup(name string) error
 }
 
 type Proxy struct {
@@ -27,7 +43,7 @@ func NewProxy(set gateSetProxy) *Proxy {
 	return result
 }
 
-func (s *Proxy) Gates(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uuid.UUID }, error) {
+func (s *Proxy) Gates(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }, error) {
 	dct, err := s.set.GateIDs(gate.KindDirect)
 	if err != nil {
 		return nil, err
@@ -43,23 +59,147 @@ func (s *Proxy) Gates(ctx context.Context) (*struct{ Direct, Tor, WireGuard []uu
 		return nil, err
 	}
 
-	result := &struct{ Direct, Tor, WireGuard []uuid.UUID }{
-		Direct:    dct,
-		Tor:       tgs,
-		WireGuard: wgs,
+	ovs, err := s.set.GateIDs(gate.KindOpenVPN)
+	if err != nil {
+		return nil, err
+	}
+
+	tws, err := s.set.GateIDs(gate.KindTorWG)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }{
+		Direct:       dct,
+		Tor:          tgs,
+		TorWireGuard: tws,
+		WireGuard:    wgs,
+		OpenVPN:      ovs,
+	}
+
+	return result, nil
+}
+
+// GatesVerbose returns details of all gates.
+func (s *Proxy) GatesVerbose(ctx context.Context) (*struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }, error) {
+	dct, err := s.set.GateInfos(gate.KindDirect)
+	if err != nil {
+		return nil, err
+	}
+
+	tgs, err := s.set.GateInfos(gate.KindTor)
+	if err != nil {
+		return nil, err
+	}
+
+	wgs, err := s.set.GateInfos(gate.KindWireGuard)
+	if err != nil {
+		return nil, err
+	}
+
+	ovs, err := s.set.GateInfos(gate.KindOpenVPN)
+	if err != nil {
+		return nil, err
+	}
+
+	tws, err := s.set.GateInfos(gate.KindTorWG)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }{
+		Direct:       dct,
+		Tor:          tgs,
+		TorWireGuard: tws,
+		WireGuard:    wgs,
+		OpenVPN:      ovs,
 	}
 
 	return result, nil
 }
 
-func (s *Proxy) Create(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
-	return s.set.New(ctx, kind)
+// Gate returns details of the gate identified by id.
+func (s *Proxy) Gate(ctx context.Context, id uuid.UUID) (gate.GateInfo, error) {
+	return s.set.GateInfo(id)
+}
+
+// Create creates n gates of kind, at least one, and returns the kind they were created as.
+//
+// On failure, it returns the ids of gates created before the error.
+func (s *Proxy) Create(ctx context.Context, kind gate.Kind, n int) (gate.Kind, []uuid.UUID, error) {
+	ids, err := s.set.NewN(ctx, kind, max(n, 1))
+
+	return kind, ids, err
 }
 
 func (s *Proxy) Refresh(ctx context.Context, id uuid.UUID) error {
 	return s.set.RefreshOne(ctx, id)
 }
 
+// Annotate merges annots into the annotations of the gate with id, and returns the result.
+func (s *Proxy) Annotate(ctx context.Context, id uuid.UUID, annots map[string]string) (map[string]string, error) {
+	return s.set.AnnotateOne(id, annots)
+}
+
+// Probe sends a GET request to rawURL via the gate with id.
+func (s *Proxy) Probe(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+	return s.set.ProbeOne(ctx, id, rawURL)
+}
+
 func (s *Proxy) Stop(ctx context.Context, id uuid.UUID) error {
 	return s.set.CloseOne(ctx, id)
 }
+
+// ForceStop stops the gate with id without waiting for its requests to finish.
+func (s *Proxy) ForceStop(ctx context.Context, id uuid.UUID) error {
+	return s.set.ForceCloseOne(ctx, id)
+}
+
+// StopIdle stops the gates of kind that have had no requests for at least minIdle.
+func (s *Proxy) StopIdle(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+	return s.set.CloseIdle(ctx, kind, minIdle)
+}
+
+// ScaleTors creates or stops Tor gates until there are n of them.
+func (s *Proxy) ScaleTors(ctx context.Context, n int) (*gate.ScaleResult, error) {
+	return s.set.ScaleTors(ctx, n)
+}
+
+func (s *Proxy) Reload(ctx context.Context) (*gate.ReloadResult, error) {
+	return s.set.Reload(ctx)
+}
+
+// Stats returns aggregate stats of the gates.
+func (s *Proxy) Stats(ctx context.Context) gate.SetStats {
+	return s.set.Stats()
+}
+
+// Config returns the settings the gates are managed with.
+func (s *Proxy) Config(ctx context.Context) *gate.ConfigView {
+	return s.set.ConfigView()
+}
+
+// Pause stops routing new requests via gates, keeping the gates running.
+func (s *Proxy) Pause(ctx context.Context) error {
+	return s.set.Pause(ctx)
+}
+
+// Resume resumes routing requests after Pause.
+func (s *Proxy) Resume(ctx context.Context) error {
+	return s.set.Resume(ctx)
+}
+
+// Groups returns the ids of running gates in each group.
+func (s *Proxy) Groups(ctx context.Context) map[string][]uuid.UUID {
+	return s.set.Groups()
+}
+
+// SetGroup defines the group name as the gates with ids, replacing the group if it exists.
+func (s *Proxy) SetGroup(ctx context.Context, name string, ids []uuid.UUID) error {
+	return s.set.SetGroup(name, ids)
+}
+
+// DeleteGroup removes the group name.
+func (s *Proxy) DeleteGroup(ctx context.Context, name string) error {
+	return s.set.DeleteGroup(name)
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	should "github.com/stretchr/testify/assert"
@@ -14,7 +15,7 @@ import (
 
 func TestProxy_Gates(t *testing.T) {
 	type tcExpected struct {
-		result *struct{ Direct, Tor, WireGuard []uuid.UUID }
+		result *struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []uuid.UUID }
 		err    error
 	}
 
@@ -80,9 +81,11 @@ func TestProxy_Gates(t *testing.T) {
 			given: &mockGateSetProxy{},
 			exp: tcExpected{
 				result: &struct {
-					Direct    []uuid.UUID
-					Tor       []uuid.UUID
-					WireGuard []uuid.UUID
+					Direct       []uuid.UUID
+					Tor          []uuid.UUID
+					TorWireGuard []uuid.UUID
+					WireGuard    []uuid.UUID
+					OpenVPN      []uuid.UUID
 				}{},
 			},
 		},
@@ -111,6 +114,12 @@ func TestProxy_Gates(t *testing.T) {
 
 						return result, nil
 
+					case gate.KindOpenVPN:
+						return []uuid.UUID{uuid.MustParse("facade00-0000-4000-a000-000000000000")}, nil
+
+					case gate.KindTorWG:
+						return []uuid.UUID{uuid.MustParse("b0a710ad-0000-4000-a000-000000000000")}, nil
+
 					default:
 						return nil, model.Error("unexpected_gate_ids")
 					}
@@ -118,19 +127,23 @@ func TestProxy_Gates(t *testing.T) {
 			},
 			exp: tcExpected{
 				result: &struct {
-					Direct    []uuid.UUID
-					Tor       []uuid.UUID
-					WireGuard []uuid.UUID
+					Direct       []uuid.UUID
+					Tor          []uuid.UUID
+					TorWireGuard []uuid.UUID
+					WireGuard    []uuid.UUID
+					OpenVPN      []uuid.UUID
 				}{
 					Direct: []uuid.UUID{uuid.MustParse("f100ded0-0000-4000-a000-000000000000")},
 					Tor: []uuid.UUID{
 						uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
 						uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
 					},
+					TorWireGuard: []uuid.UUID{uuid.MustParse("b0a710ad-0000-4000-a000-000000000000")},
 					WireGuard: []uuid.UUID{
 						uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
 						uuid.MustParse("decade00-0000-4000-a000-000000000000"),
 					},
+					OpenVPN: []uuid.UUID{uuid.MustParse("facade00-0000-4000-a000-000000000000")},
 				},
 			},
 		},
@@ -156,15 +169,157 @@ func TestProxy_Gates(t *testing.T) {
 	}
 }
 
+func TestProxy_GatesVerbose(t *testing.T) {
+	type tcExpected struct {
+		result *struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }
+		err    error
+	}
+
+	tests := []testCase[*mockGateSetProxy, tcExpected]{
+		{
+			name: "error_direct",
+			given: &mockGateSetProxy{
+				fnGateInfos: func(kind gate.Kind) ([]gate.GateInfo, error) {
+					return nil, model.Error("something_went_wrong")
+				},
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "error_wireguard",
+			given: &mockGateSetProxy{
+				fnGateInfos: func(kind gate.Kind) ([]gate.GateInfo, error) {
+					if kind == gate.KindWireGuard {
+						return nil, model.Error("something_went_wrong")
+					}
+
+					return nil, nil
+				},
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "valid_data",
+			given: &mockGateSetProxy{
+				fnGateInfos: func(kind gate.Kind) ([]gate.GateInfo, error) {
+					if kind != gate.KindTor {
+						return nil, nil
+					}
+
+					result := []gate.GateInfo{
+						{
+							ID:        uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+							Kind:      gate.KindTor,
+							Ready:     true,
+							CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+						},
+					}
+
+					return result, nil
+				},
+			},
+			exp: tcExpected{
+				result: &struct{ Direct, Tor, TorWireGuard, WireGuard, OpenVPN []gate.GateInfo }{
+					Tor: []gate.GateInfo{
+						{
+							ID:        uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+							Kind:      gate.KindTor,
+							Ready:     true,
+							CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given)
+
+			ctx := context.Background()
+
+			actual, err := svc.GatesVerbose(ctx)
+			must.Equal(t, tc.exp.err, err)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.result, actual)
+		})
+	}
+}
+
+func TestProxy_Gate(t *testing.T) {
+	type tcExpected struct {
+		info gate.GateInfo
+		err  error
+	}
+
+	tests := []testCase[*mockGateSetProxy, tcExpected]{
+		{
+			name: "error",
+			given: &mockGateSetProxy{
+				fnGateInfo: func(id uuid.UUID) (gate.GateInfo, error) {
+					return gate.GateInfo{}, gate.ErrGateNotFound
+				},
+			},
+			exp: tcExpected{
+				err: gate.ErrGateNotFound,
+			},
+		},
+
+		{
+			name: "valid",
+			given: &mockGateSetProxy{
+				fnGateInfo: func(id uuid.UUID) (gate.GateInfo, error) {
+					return gate.GateInfo{ID: id, Kind: gate.KindTor, WarmupErr: "something_went_wrong"}, nil
+				},
+			},
+			exp: tcExpected{
+				info: gate.GateInfo{
+					ID:        uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+					Kind:      gate.KindTor,
+					WarmupErr: "something_went_wrong",
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given)
+
+			actual, err := svc.Gate(context.Background(), uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"))
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.info, actual)
+		})
+	}
+}
+
 func TestProxy_Create(t *testing.T) {
 	type tcGiven struct {
 		set  *mockGateSetProxy
 		kind gate.Kind
+		n    int
 	}
 
 	type tcExpected struct {
-		id  uuid.UUID
-		err error
+		kind gate.Kind
+		ids  []uuid.UUID
+		err  error
 	}
 
 	tests := []testCase[tcGiven, tcExpected]{
@@ -172,33 +327,87 @@ func TestProxy_Create(t *testing.T) {
 			name: "error",
 			given: tcGiven{
 				set: &mockGateSetProxy{
-					fnNew: func(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
-						return uuid.Nil, model.Error("something_went_wrong")
+					fnNewN: func(ctx context.Context, kind gate.Kind, n int) ([]uuid.UUID, error) {
+						return []uuid.UUID{}, model.Error("something_went_wrong")
 					},
 				},
 				kind: gate.KindTor,
 			},
 			exp: tcExpected{
-				err: model.Error("something_went_wrong"),
+				kind: gate.KindTor,
+				ids:  []uuid.UUID{},
+				err:  model.Error("something_went_wrong"),
 			},
 		},
 
 		{
-			name: "valid",
+			name: "error_partial",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnNewN: func(ctx context.Context, kind gate.Kind, n int) ([]uuid.UUID, error) {
+						return []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")}, gate.ErrTorMaxReached
+					},
+				},
+				kind: gate.KindTor,
+				n:    2,
+			},
+			exp: tcExpected{
+				kind: gate.KindTor,
+				ids:  []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")},
+				err:  gate.ErrTorMaxReached,
+			},
+		},
+
+		{
+			name: "valid_default_one",
 			given: tcGiven{
 				set: &mockGateSetProxy{
-					fnNew: func(ctx context.Context, kind gate.Kind) (uuid.UUID, error) {
+					fnNewN: func(ctx context.Context, kind gate.Kind, n int) ([]uuid.UUID, error) {
 						if kind != gate.KindTor {
-							return uuid.Nil, model.Error("unexpected_kind")
+							return nil, model.Error("unexpected_kind")
 						}
 
-						return uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"), nil
+						if n != 1 {
+							return nil, model.Error("unexpected_n")
+						}
+
+						return []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")}, nil
 					},
 				},
 				kind: gate.KindTor,
 			},
 			exp: tcExpected{
-				id: uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+				kind: gate.KindTor,
+				ids:  []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")},
+			},
+		},
+
+		{
+			name: "valid_many",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnNewN: func(ctx context.Context, kind gate.Kind, n int) ([]uuid.UUID, error) {
+						if n != 2 {
+							return nil, model.Error("unexpected_n")
+						}
+
+						result := []uuid.UUID{
+							uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+							uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000001"),
+						}
+
+						return result, nil
+					},
+				},
+				kind: gate.KindTor,
+				n:    2,
+			},
+			exp: tcExpected{
+				kind: gate.KindTor,
+				ids: []uuid.UUID{
+					uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+					uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000001"),
+				},
 			},
 		},
 	}
@@ -211,14 +420,11 @@ func TestProxy_Create(t *testing.T) {
 
 			ctx := context.Background()
 
-			actual, err := svc.Create(ctx, tc.given.kind)
+			kind, actual, err := svc.Create(ctx, tc.given.kind, tc.given.n)
 			must.Equal(t, tc.exp.err, err)
 
-			if tc.exp.err != nil {
-				return
-			}
-
-			should.Equal(t, tc.exp.id, actual)
+			should.Equal(t, tc.exp.kind, kind)
+			should.Equal(t, tc.exp.ids, actual)
 		})
 	}
 }
@@ -274,6 +480,140 @@ func TestProxy_Refresh(t *testing.T) {
 	}
 }
 
+func TestProxy_Annotate(t *testing.T) {
+	type tcGiven struct {
+		set    *mockGateSetProxy
+		id     uuid.UUID
+		annots map[string]string
+	}
+
+	type tcExpected struct {
+		val map[string]string
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnAnnotate: func(id uuid.UUID, annots map[string]string) (map[string]string, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+				id:     uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+				annots: map[string]string{"owner": "ops"},
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnAnnotate: func(id uuid.UUID, annots map[string]string) (map[string]string, error) {
+						if id != uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000") {
+							return nil, model.Error("unexpected_id")
+						}
+
+						return map[string]string{"owner": annots["owner"], "region": "eu"}, nil
+					},
+				},
+				id:     uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+				annots: map[string]string{"owner": "ops"},
+			},
+			exp: tcExpected{
+				val: map[string]string{"owner": "ops", "region": "eu"},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given.set)
+
+			ctx := context.Background()
+
+			actual, err := svc.Annotate(ctx, tc.given.id, tc.given.annots)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.val, actual)
+		})
+	}
+}
+
+func TestProxy_Probe(t *testing.T) {
+	type tcGiven struct {
+		set *mockGateSetProxy
+		id  uuid.UUID
+		url string
+	}
+
+	type tcExpected struct {
+		res *gate.ProbeResult
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnProbeOne: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						return nil, gate.ErrGateNotFound
+					},
+				},
+				id:  uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+				url: "https://example.com",
+			},
+			exp: tcExpected{
+				err: gate.ErrGateNotFound,
+			},
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnProbeOne: func(ctx context.Context, id uuid.UUID, rawURL string) (*gate.ProbeResult, error) {
+						if id != uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000") {
+							return nil, model.Error("unexpected_id")
+						}
+
+						if rawURL != "https://example.com" {
+							return nil, model.Error("unexpected_url")
+						}
+
+						return &gate.ProbeResult{Status: 204, Latency: time.Second}, nil
+					},
+				},
+				id:  uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+				url: "https://example.com",
+			},
+			exp: tcExpected{
+				res: &gate.ProbeResult{Status: 204, Latency: time.Second},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given.set)
+
+			actual, err := svc.Probe(context.Background(), tc.given.id, tc.given.url)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.res, actual)
+		})
+	}
+}
+
 func TestProxy_Stop(t *testing.T) {
 	type tcGiven struct {
 		set *mockGateSetProxy
@@ -324,3 +664,403 @@ func TestProxy_Stop(t *testing.T) {
 		})
 	}
 }
+
+func TestProxy_ForceStop(t *testing.T) {
+	type tcGiven struct {
+		set *mockGateSetProxy
+		id  uuid.UUID
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "error",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnForceClose: func(ctx context.Context, id uuid.UUID) error {
+						return model.Error("something_went_wrong")
+					},
+				},
+				id: uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+			},
+			exp: model.Error("something_went_wrong"),
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnCloseOne: func(ctx context.Context, id uuid.UUID) error {
+						return model.Error("unexpected_graceful")
+					},
+					fnForceClose: func(ctx context.Context, id uuid.UUID) error {
+						if id != uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000") {
+							return model.Error("unexpected_id")
+						}
+
+						return nil
+					},
+				},
+				id: uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000"),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given.set)
+
+			ctx := context.Background()
+
+			actual := svc.ForceStop(ctx, tc.given.id)
+			must.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestProxy_StopIdle(t *testing.T) {
+	type tcGiven struct {
+		set     *mockGateSetProxy
+		kind    gate.Kind
+		minIdle time.Duration
+	}
+
+	type tcExpected struct {
+		ids []uuid.UUID
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnCloseIdle: func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+				kind:    gate.KindTor,
+				minIdle: time.Hour,
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnCloseIdle: func(ctx context.Context, kind gate.Kind, minIdle time.Duration) ([]uuid.UUID, error) {
+						if kind != gate.KindTor || minIdle != time.Hour {
+							return nil, model.Error("unexpected_params")
+						}
+
+						return []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")}, nil
+					},
+				},
+				kind:    gate.KindTor,
+				minIdle: time.Hour,
+			},
+			exp: tcExpected{
+				ids: []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given.set)
+
+			ctx := context.Background()
+
+			actual, err := svc.StopIdle(ctx, tc.given.kind, tc.given.minIdle)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.ids, actual)
+		})
+	}
+}
+
+func TestProxy_ScaleTors(t *testing.T) {
+	type tcExpected struct {
+		res *gate.ScaleResult
+		err error
+	}
+
+	tests := []testCase[*mockGateSetProxy, tcExpected]{
+		{
+			name: "error",
+			given: &mockGateSetProxy{
+				fnScaleTors: func(ctx context.Context, n int) (*gate.ScaleResult, error) {
+					return nil, gate.ErrTorMaxReached
+				},
+			},
+			exp: tcExpected{
+				err: gate.ErrTorMaxReached,
+			},
+		},
+
+		{
+			name: "valid",
+			given: &mockGateSetProxy{
+				fnScaleTors: func(ctx context.Context, n int) (*gate.ScaleResult, error) {
+					if n != 2 {
+						return nil, model.Error("unexpected_count")
+					}
+
+					return &gate.ScaleResult{Created: []uuid.UUID{uuid.MustParse("c0c0b000-0000-4000-a000-000000000000")}}, nil
+				},
+			},
+			exp: tcExpected{
+				res: &gate.ScaleResult{Created: []uuid.UUID{uuid.MustParse("c0c0b000-0000-4000-a000-000000000000")}},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given)
+
+			actual, err := svc.ScaleTors(context.Background(), 2)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.res, actual)
+		})
+	}
+}
+
+func TestProxy_Reload(t *testing.T) {
+	type tcExpected struct {
+		res *gate.ReloadResult
+		err error
+	}
+
+	tests := []testCase[*mockGateSetProxy, tcExpected]{
+		{
+			name: "error",
+			given: &mockGateSetProxy{
+				fnReload: func(ctx context.Context) (*gate.ReloadResult, error) {
+					return nil, model.Error("something_went_wrong")
+				},
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "valid",
+			given: &mockGateSetProxy{
+				fnReload: func(ctx context.Context) (*gate.ReloadResult, error) {
+					result := &gate.ReloadResult{
+						Added:     []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")},
+						Unchanged: 1,
+					}
+
+					return result, nil
+				},
+			},
+			exp: tcExpected{
+				res: &gate.ReloadResult{
+					Added:     []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")},
+					Unchanged: 1,
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given)
+
+			actual, err := svc.Reload(context.Background())
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.exp.res, actual)
+		})
+	}
+}
+
+func TestProxy_Pause(t *testing.T) {
+	tests := []testCase[*mockGateSetProxy, error]{
+		{
+			name: "error",
+			given: &mockGateSetProxy{
+				fnPause: func(ctx context.Context) error {
+					return gate.ErrSetIsShutting
+				},
+			},
+			exp: gate.ErrSetIsShutting,
+		},
+
+		{
+			name: "valid",
+			given: &mockGateSetProxy{
+				fnResume: func(ctx context.Context) error {
+					return model.Error("unexpected_resume")
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given)
+
+			actual := svc.Pause(context.Background())
+			must.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestProxy_Resume(t *testing.T) {
+	tests := []testCase[*mockGateSetProxy, error]{
+		{
+			name: "error",
+			given: &mockGateSetProxy{
+				fnResume: func(ctx context.Context) error {
+					return gate.ErrSetIsShutting
+				},
+			},
+			exp: gate.ErrSetIsShutting,
+		},
+
+		{
+			name: "valid",
+			given: &mockGateSetProxy{
+				fnPause: func(ctx context.Context) error {
+					return model.Error("unexpected_pause")
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given)
+
+			actual := svc.Resume(context.Background())
+			must.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestProxy_Stats(t *testing.T) {
+	set := &mockGateSetProxy{
+		fnStats: func() gate.SetStats {
+			return gate.SetStats{Kinds: []gate.KindStats{{Kind: gate.KindTor, Total: 2, Ready: 1}}, InFlight: 3}
+		},
+	}
+
+	svc := NewProxy(set)
+
+	actual := svc.Stats(context.Background())
+	should.Equal(t, gate.SetStats{Kinds: []gate.KindStats{{Kind: gate.KindTor, Total: 2, Ready: 1}}, InFlight: 3}, actual)
+}
+
+func TestProxy_Groups(t *testing.T) {
+	set := &mockGateSetProxy{
+		fnGroups: func() map[string][]uuid.UUID {
+			return map[string][]uuid.UUID{"fast": {uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")}}
+		},
+	}
+
+	svc := NewProxy(set)
+
+	actual := svc.Groups(context.Background())
+	should.Equal(t, map[string][]uuid.UUID{"fast": {uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")}}, actual)
+}
+
+func TestProxy_SetGroup(t *testing.T) {
+	type tcGiven struct {
+		set  *mockGateSetProxy
+		name string
+		ids  []uuid.UUID
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "error",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnSetGroup: func(name string, ids []uuid.UUID) error {
+						return gate.ErrGateNotFound
+					},
+				},
+				name: "fast",
+				ids:  []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")},
+			},
+			exp: gate.ErrGateNotFound,
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				set: &mockGateSetProxy{
+					fnSetGroup: func(name string, ids []uuid.UUID) error {
+						if name != "fast" || len(ids) != 1 || ids[0] != uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000") {
+							return model.Error("unexpected_group")
+						}
+
+						return nil
+					},
+				},
+				name: "fast",
+				ids:  []uuid.UUID{uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000")},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewProxy(tc.given.set)
+
+			actual := svc.SetGroup(context.Background(), tc.given.name, tc.given.ids)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestProxy_DeleteGroup(t *testing.T) {
+	set := &mockGateSetProxy{
+		fnDelGroup: func(name string) error {
+			if name != "fast" {
+				return gate.ErrGroupNotFound
+			}
+
+			return nil
+		},
+	}
+
+	svc := NewProxy(set)
+
+	should.Equal(t, gate.ErrGroupNotFound, svc.DeleteGroup(context.Background(), "slow"))
+	should.Equal(t, nil, svc.DeleteGroup(context.Background(), "fast"))
+}
+
+func TestProxy_Config(t *testing.T) {
+	set := &mockGateSetProxy{
+		fnConfigView: func() *gate.ConfigView {
+			return &gate.ConfigView{Default: gate.KindTor, RandomiseKinds: true}
+		},
+	}
+
+	svc := NewProxy(set)
+
+	actual := svc.Config(context.Background())
+	should.Equal(t, &gate.ConfigView{Default: gate.KindTor, RandomiseKinds: true}, actual)
+}
@@ -2,31 +2,103 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/pavelbrm/pumpe/gate"
+	"github.com/pavelbrm/pumpe/metrics"
 	"github.com/pavelbrm/pumpe/model"
 	"github.com/pavelbrm/pumpe/web"
 )
 
+const (
+	ErrConnectPortNotAllowed model.Error = "service: connect to port not allowed"
+	ErrInvalidRetries        model.Error = "service: invalid retries"
+	ErrHeadersTooLarge       model.Error = "service: request headers too large"
+	ErrBodyTooLarge          model.Error = "service: request body too large"
+	ErrDialTimeout           model.Error = "service: dial timed out"
+	ErrTooManyTunnels        model.Error = "service: too many tunnels"
+	ErrInvalidGateIndex      model.Error = "service: invalid gate index"
+	ErrGateIndexNoType       model.Error = "service: gate index requires gate type"
+	ErrDestNotAllowed        model.Error = "service: destination not allowed"
+	ErrSelectionUnsupported  model.Error = "service: gate selection not supported by set"
+)
+
 const (
 	headerProxyGateID   = "Proxy-Pumpe-Gate-Id"
 	headerProxyGateType = "Proxy-Pumpe-Gate-Type"
+	headerProxyRetries  = "Proxy-Pumpe-Retries"
+	headerProxyWait     = "Proxy-Pumpe-Wait"
+	headerProxyNoFwd    = "Proxy-Pumpe-No-Forward"
+	headerProxyGateIdx  = "Proxy-Pumpe-Gate-Index"
+	headerProxyGateGrp  = "Proxy-Pumpe-Gate-Group"
 )
 
+// Query params that select a gate for plain HTTP requests from clients that cannot set headers.
+const (
+	queryProxyGateID   = "_pumpe_gate_id"
+	queryProxyGateType = "_pumpe_gate_type"
+)
+
+// maxDialRetries is the upper bound for retries requested via headerProxyRetries.
+const maxDialRetries = 5
+
+// retryAfterWarmup is the number of seconds clients are asked to wait while gates are warming up.
+const retryAfterWarmup = "5"
+
+// gateSet is what Pumpe requires from a set of gates.
+//
+// The ways of selecting a gate are optional, see byIDer, byKinder, byGrouper and randomer.
+// A request that needs a way the set does not support fails with ErrSelectionUnsupported.
 type gateSet interface {
+	ReportResult(id uuid.UUID, ok bool)
+	Ready() <-chan struct{}
+	IsPaused() bool
+}
+
+type byIDer interface {
 	ByID(id uuid.UUID) (gate.ExitGate, error)
+	ByIDWait(ctx context.Context, id uuid.UUID) (gate.ExitGate, error)
+}
+
+type byKinder interface {
 	ByKind(ctx context.Context, kind gate.Kind) (gate.ExitGate, error)
+	ByIndex(kind gate.Kind, i int) (gate.ExitGate, error)
+}
+
+type byGrouper interface {
+	ByGroup(ctx context.Context, name string) (gate.ExitGate, error)
+}
+
+type randomer interface {
 	Random(ctx context.Context) (gate.ExitGate, error)
+	RandomExcept(ctx context.Context, kind gate.Kind, id uuid.UUID) (gate.ExitGate, error)
 }
 
+// The built-in set must support every way of selecting a gate.
+var _ interface {
+	gateSet
+	byIDer
+	byKinder
+	byGrouper
+	randomer
+} = (*gate.Set)(nil)
+
 type readCloser interface {
 	CloseRead() error
 }
@@ -35,56 +107,187 @@ type writeCloser interface {
 	CloseWrite() error
 }
 
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
 type Pumpe struct {
+	cfg     *gate.SetConfig
 	hopHdr  []string
 	data200 []byte
 	set     gateSet
+
+	// ids, kinds, grps and rnd are nil when set does not support the respective way of selecting a gate.
+	ids   byIDer
+	kinds byKinder
+	grps  byGrouper
+	rnd   randomer
+
+	// tunnels is the number of active CONNECT tunnels.
+	tunnels *atomic.Int64
+
+	mtr *metrics.Requests
 }
 
-func NewPumpe(set gateSet) *Pumpe {
+func NewPumpe(cfg *gate.SetConfig, set gateSet) *Pumpe {
 	result := &Pumpe{
+		cfg:     cfg,
 		hopHdr:  newHopHeaders(),
-		data200: []byte("HTTP/1.1 200 Connection established\r\n\r\n"),
+		data200: []byte(cfg.ConnectResponseOrDefault()),
 		set:     set,
+		tunnels: &atomic.Int64{},
 	}
 
+	result.ids, _ = set.(byIDer)
+	result.kinds, _ = set.(byKinder)
+	result.grps, _ = set.(byGrouper)
+	result.rnd, _ = set.(randomer)
+
 	return result
 }
 
-func (s *Pumpe) HandleConnect(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+// SetMetrics makes the service record the requests it handles in m.
+func (s *Pumpe) SetMetrics(m *metrics.Requests) {
+	s.mtr = m
+}
+
+// HandleConnect tunnels the connection via a gate, and returns the gate used, if any.
+func (s *Pumpe) HandleConnect(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
+	s.mtr.Start()
+
+	gt, err := s.handleConnect(ctx, w, r)
+
+	s.mtr.Finish(kindLabel(gt), err == nil)
+
+	return gt, err
+}
+
+func (s *Pumpe) handleConnect(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
 	hj, ok := w.(http.Hijacker)
 	if !ok {
-		return model.ErrHijackingNotSupported
+		return nil, model.ErrHijackingNotSupported
+	}
+
+	if err := s.rejectUnavailable(w, r); err != nil {
+		return nil, err
 	}
 
 	srcConn, _, err := hj.Hijack()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() { _ = srcConn.Close() }()
 
-	dialer, err := s.pickDialer(ctx, r.Header)
+	addr := remoteAddrFromHost(r.Host)
+
+	if !s.isPortAllowed(addr) {
+		_ = writeStatusToConn(srcConn, http.StatusForbidden, ErrConnectPortNotAllowed.Error())
+
+		return nil, ErrConnectPortNotAllowed
+	}
+
+	if err := s.checkDest(addr); err != nil {
+		_ = writeStatusToConn(srcConn, http.StatusForbidden, ErrDestNotAllowed.Error())
+
+		return nil, err
+	}
+
+	ctx = s.destCtx(ctx)
+
+	retries, err := s.retries(r.Header)
 	if err != nil {
-		_ = writeErrToConn(srcConn, err)
+		_ = writeStatusToConn(srcConn, http.StatusBadRequest, err.Error())
 
-		return err
+		return nil, err
 	}
 
-	dialer.AddReq()
-	defer func() { dialer.DidReq() }()
+	if !s.acquireTunnel() {
+		_ = writeStatusToConn(srcConn, http.StatusServiceUnavailable, ErrTooManyTunnels.Error())
 
-	addr := remoteAddrFromHost(r.Host)
+		return nil, ErrTooManyTunnels
+	}
+	defer s.releaseTunnel()
 
-	dstConn, err := dialer.DialContext(ctx, "tcp", addr)
+	dialer, err := s.pickDialer(ctx, r.Header)
 	if err != nil {
 		_ = writeErrToConn(srcConn, err)
 
-		return err
+		return nil, err
+	}
+
+	var dstConn net.Conn
+
+	for i := 0; ; i++ {
+		dialer.AddReq()
+
+		dstConn, err = s.dial(ctx, dialer, addr)
+
+		// The destination is at fault, not the gate.
+		if errors.Is(err, ErrDestNotAllowed) {
+			dialer.DidReq()
+
+			_ = writeStatusToConn(srcConn, http.StatusForbidden, ErrDestNotAllowed.Error())
+
+			return dialer, ErrDestNotAllowed
+		}
+
+		s.reportResult(ctx, dialer, err)
+
+		if err == nil {
+			break
+		}
+
+		dialer.DidReq()
+
+		next, ok := s.nextDialer(ctx, dialer, i, retries)
+		if !ok {
+			_ = writeErrToConn(srcConn, err)
+
+			return dialer, err
+		}
+
+		dialer = next
 	}
+
+	defer func() { dialer.DidReq() }()
 	defer func() { _ = dstConn.Close() }()
 
 	if _, err := srcConn.Write(s.data200); err != nil {
-		return err
+		return dialer, err
+	}
+
+	s.tunnel(ctx, dialer, srcConn, dstConn)
+
+	return dialer, nil
+}
+
+// acquireTunnel counts a new tunnel, and reports false without counting it when MaxTunnels is reached.
+func (s *Pumpe) acquireTunnel() bool {
+	n := s.tunnels.Add(1)
+
+	if s.cfg.MaxTunnels > 0 && n > int64(s.cfg.MaxTunnels) {
+		s.tunnels.Add(-1)
+
+		return false
+	}
+
+	return true
+}
+
+func (s *Pumpe) releaseTunnel() {
+	s.tunnels.Add(-1)
+}
+
+// tunnel copies data between src and dst in both directions until both are done.
+//
+// With HalfCloseTimeout set, once one direction is done, the other is torn down after being idle for that long.
+// The bytes copied are counted on gt as each direction finishes.
+func (s *Pumpe) tunnel(ctx context.Context, gt gate.ExitGate, src, dst net.Conn) {
+	upc, downc := newIdleConn(ctx, src), newIdleConn(ctx, dst)
+
+	var up, down net.Conn = src, dst
+	if s.cfg.HalfCloseTimeout > 0 {
+		up, down = upc, downc
 	}
 
 	wg := &sync.WaitGroup{}
@@ -93,45 +296,141 @@ func (s *Pumpe) HandleConnect(ctx context.Context, w http.ResponseWriter, r *htt
 	go func() {
 		defer wg.Done()
 
-		xferData(dstConn, srcConn)
+		n := xferDataCtx(ctx, dst, up)
+		gt.AddBytes(0, uint64(n))
+
+		downc.arm(s.cfg.HalfCloseTimeout)
 	}()
 
 	go func() {
 		defer wg.Done()
 
-		xferData(srcConn, dstConn)
+		n := xferDataCtx(ctx, src, down)
+		gt.AddBytes(uint64(n), 0)
+
+		upc.arm(s.cfg.HalfCloseTimeout)
 	}()
 
 	wg.Wait()
+}
 
-	return nil
+// HandleHTTP forwards the request via a gate, and returns the gate used, if any.
+func (s *Pumpe) HandleHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
+	s.mtr.Start()
+
+	gt, err := s.handleHTTP(ctx, w, r)
+
+	s.mtr.Finish(kindLabel(gt), err == nil)
+
+	return gt, err
 }
 
-func (s *Pumpe) HandleHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	dialer, err := s.pickDialer(ctx, r.Header)
-	if err != nil {
-		return err
+func (s *Pumpe) handleHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) (gate.ExitGate, error) {
+	if err := s.rejectUnavailable(w, r); err != nil {
+		return nil, err
 	}
 
-	dialer.AddReq()
-	defer func() { dialer.DidReq() }()
+	if exceedsHeaderLimits(r.Header, s.cfg.MaxHeaderCount, s.cfg.MaxHeaderBytes) {
+		_ = writeHTTPErr(w, r.Header, http.StatusRequestHeaderFieldsTooLarge, ErrHeadersTooLarge.Error())
+
+		return nil, ErrHeadersTooLarge
+	}
+
+	if s.cfg.MaxBodyBytes > 0 && r.ContentLength > s.cfg.MaxBodyBytes {
+		_ = writeHTTPErr(w, r.Header, http.StatusRequestEntityTooLarge, ErrBodyTooLarge.Error())
 
-	r.RequestURI = ""
+		return nil, ErrBodyTooLarge
+	}
 
-	delHeaders(s.hopHdr, r.Header)
-	delConnectionHeaders(r.Header)
+	if err := s.checkDest(r.URL.Host); err != nil {
+		_ = writeHTTPErr(w, r.Header, http.StatusForbidden, ErrDestNotAllowed.Error())
 
-	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-		addHostToXForwardedHeader(r.Header, ip)
+		return nil, err
 	}
 
-	resp, err := dialer.Do(r)
+	ctx = s.destCtx(ctx)
+
+	shdr := selectionHeader(r.Header, r.URL.RawQuery)
+
+	retries, err := s.retries(shdr)
 	if err != nil {
-		_ = web.WriteError(w, http.StatusBadGateway, "server error")
+		_ = writeHTTPErr(w, r.Header, http.StatusBadRequest, err.Error())
+
+		return nil, err
+	}
+
+	dialer, err := s.pickDialer(ctx, shdr)
+	if err != nil {
+		return nil, err
+	}
+
+	if isUpgradeRequest(r.Header) {
+		return s.upgrade(ctx, w, r, dialer, retries)
+	}
+
+	out := newOutRequest(ctx, r)
+
+	sent := &atomic.Uint64{}
+
+	// A request with a body cannot be safely replayed.
+	if out.Body != nil && out.Body != http.NoBody {
+		retries = 0
+
+		// The length is not always known upfront, so the limit is enforced while the body is sent, too.
+		if s.cfg.MaxBodyBytes > 0 {
+			out.Body = http.MaxBytesReader(w, out.Body, s.cfg.MaxBodyBytes)
+		}
+
+		out.Body = &countingReadCloser{ReadCloser: out.Body, n: sent}
+	}
+
+	s.prepOutHeader(out.Header, r.RemoteAddr)
 
-		return err
+	var resp *http.Response
+
+	for i := 0; ; i++ {
+		dialer.AddReq()
+
+		resp, err = dialer.Do(out)
+
+		// The client is at fault, not the gate.
+		if mberr := (*http.MaxBytesError)(nil); errors.As(err, &mberr) {
+			dialer.DidReq()
+
+			_ = writeHTTPErr(w, r.Header, http.StatusRequestEntityTooLarge, ErrBodyTooLarge.Error())
+
+			return dialer, ErrBodyTooLarge
+		}
+
+		// The destination is at fault, not the gate.
+		if errors.Is(err, ErrDestNotAllowed) {
+			dialer.DidReq()
+
+			_ = writeHTTPErr(w, r.Header, http.StatusForbidden, ErrDestNotAllowed.Error())
+
+			return dialer, ErrDestNotAllowed
+		}
+
+		s.reportResult(ctx, dialer, err)
+
+		if err == nil {
+			break
+		}
+
+		dialer.DidReq()
+
+		next, ok := s.nextDialer(ctx, dialer, i, retries)
+		if !ok {
+			_ = writeHTTPErr(w, r.Header, http.StatusBadGateway, "server error")
+
+			return dialer, err
+		}
+
+		dialer = next
 	}
 
+	defer func() { dialer.DidReq() }()
+
 	if resp != nil && resp.Body != nil {
 		defer func() { _ = resp.Body.Close() }()
 	}
@@ -142,9 +441,240 @@ func (s *Pumpe) HandleHTTP(ctx context.Context, w http.ResponseWriter, r *http.R
 	copyHeader(w.Header(), resp.Header)
 
 	w.WriteHeader(resp.StatusCode)
-	_, _ = io.Copy(w, resp.Body)
+	recvd, _ := io.Copy(w, resp.Body)
 
-	return nil
+	dialer.AddBytes(uint64(recvd), sent.Load())
+
+	return dialer, nil
+}
+
+// upgrade forwards an upgrade request, e.g. a WebSocket handshake, via dialer, and then pipes data both ways.
+//
+// The response of the origin is passed to the client as is, and the connection is dedicated to the origin from then on.
+// Like a CONNECT tunnel, it counts towards MaxTunnels, and only the dial is retried.
+func (s *Pumpe) upgrade(ctx context.Context, w http.ResponseWriter, r *http.Request, dialer gate.ExitGate, retries int) (gate.ExitGate, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, model.ErrHijackingNotSupported
+	}
+
+	if !s.acquireTunnel() {
+		_ = writeHTTPErr(w, r.Header, http.StatusServiceUnavailable, ErrTooManyTunnels.Error())
+
+		return nil, ErrTooManyTunnels
+	}
+	defer s.releaseTunnel()
+
+	addr := upgradeAddr(r.URL.Host)
+
+	var dstConn net.Conn
+	var err error
+
+	for i := 0; ; i++ {
+		dialer.AddReq()
+
+		dstConn, err = s.dial(ctx, dialer, addr)
+
+		if errors.Is(err, ErrDestNotAllowed) {
+			dialer.DidReq()
+
+			_ = writeHTTPErr(w, r.Header, http.StatusForbidden, ErrDestNotAllowed.Error())
+
+			return dialer, ErrDestNotAllowed
+		}
+
+		s.reportResult(ctx, dialer, err)
+
+		if err == nil {
+			break
+		}
+
+		dialer.DidReq()
+
+		next, ok := s.nextDialer(ctx, dialer, i, retries)
+		if !ok {
+			_ = writeHTTPErr(w, r.Header, http.StatusBadGateway, "server error")
+
+			return dialer, err
+		}
+
+		dialer = next
+	}
+
+	defer func() { dialer.DidReq() }()
+	defer func() { _ = dstConn.Close() }()
+
+	out := newOutRequest(ctx, r)
+
+	// The upgrade headers are hop-by-hop, so they are put back after the others are removed.
+	proto := r.Header.Get("Upgrade")
+
+	s.prepOutHeader(out.Header, r.RemoteAddr)
+
+	out.Header.Set("Connection", "Upgrade")
+	out.Header.Set("Upgrade", proto)
+
+	srcConn, brw, err := hj.Hijack()
+	if err != nil {
+		return dialer, err
+	}
+	defer func() { _ = srcConn.Close() }()
+
+	if err := out.Write(dstConn); err != nil {
+		_ = writeStatusToConn(srcConn, http.StatusBadGateway, "server error")
+
+		return dialer, err
+	}
+
+	// The client might have sent data right after the request, and the server has read it already.
+	if n := brw.Reader.Buffered(); n > 0 {
+		data, _ := brw.Reader.Peek(n)
+
+		if _, err := dstConn.Write(data); err != nil {
+			return dialer, err
+		}
+
+		dialer.AddBytes(0, uint64(n))
+	}
+
+	s.tunnel(ctx, dialer, srcConn, dstConn)
+
+	return dialer, nil
+}
+
+// prepOutHeader removes hop-by-hop headers from hdr, and sets the ones Pumpe controls.
+func (s *Pumpe) prepOutHeader(hdr http.Header, remoteAddr string) {
+	// Read before the header is removed along with the other hop-by-hop ones.
+	noFwd, _ := strconv.ParseBool(hdr.Get(headerProxyNoFwd))
+
+	delHeaders(s.hopHdr, hdr)
+	delConnectionHeaders(hdr)
+	setUserAgent(hdr, s.cfg.StripUserAgent, s.cfg.UserAgent)
+
+	if noFwd {
+		delForwardedHeaders(hdr)
+	} else {
+		setXForwardedFor(hdr, s.cfg.XFFMode, remoteAddr)
+	}
+}
+
+// isUpgradeRequest reports whether hdr asks to switch protocols, i.e. Connection has upgrade and Upgrade is set.
+func isUpgradeRequest(hdr http.Header) bool {
+	if hdr.Get("Upgrade") == "" {
+		return false
+	}
+
+	h := hdr["Connection"]
+
+	for i := range h {
+		ss := strings.Split(h[i], ",")
+		for j := range ss {
+			if strings.EqualFold(strings.TrimSpace(ss[j]), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// upgradeAddr returns host with the default HTTP port, unless it has one.
+func upgradeAddr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), "80")
+}
+
+// newOutRequest returns a client request to forward r with.
+//
+// The server request is left intact, and the header of the result can be changed freely.
+func newOutRequest(ctx context.Context, r *http.Request) *http.Request {
+	result := r.Clone(ctx)
+
+	// RequestURI must not be set in client requests.
+	result.RequestURI = ""
+
+	// Closing the connection to the client doesn't mean closing the one to the destination.
+	result.Close = false
+
+	result.URL.RawQuery = stripQueryParams(result.URL.RawQuery, queryProxyGateID, queryProxyGateType)
+
+	return result
+}
+
+// selectionHeader returns hdr with the gate id and type set from the query params in rawQuery.
+//
+// The params are only taken when hdr selects no gate, so headers take precedence.
+// Otherwise, or when there are no such params, hdr is returned as is.
+func selectionHeader(hdr http.Header, rawQuery string) http.Header {
+	if rawQuery == "" || hdr.Get(headerProxyGateID) != "" || hdr.Get(headerProxyGateType) != "" || hdr.Get(headerProxyGateIdx) != "" || hdr.Get(headerProxyGateGrp) != "" {
+		return hdr
+	}
+
+	query, _ := url.ParseQuery(rawQuery)
+
+	id, kind := query.Get(queryProxyGateID), query.Get(queryProxyGateType)
+	if id == "" && kind == "" {
+		return hdr
+	}
+
+	result := hdr.Clone()
+
+	if id != "" {
+		result.Set(headerProxyGateID, id)
+	}
+
+	if kind != "" {
+		result.Set(headerProxyGateType, kind)
+	}
+
+	return result
+}
+
+// stripQueryParams removes the params with the given names from rawQuery.
+//
+// The other params are kept as they are, in the same order and encoding, so that the origin sees the query it would
+// have seen otherwise.
+func stripQueryParams(rawQuery string, names ...string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+
+	parts := strings.Split(rawQuery, "&")
+	result := parts[:0]
+
+	for i := range parts {
+		key, _, _ := strings.Cut(parts[i], "=")
+
+		if ukey, err := url.QueryUnescape(key); err == nil && slices.Contains(names, ukey) {
+			continue
+		}
+
+		result = append(result, parts[i])
+	}
+
+	return strings.Join(result, "&")
+}
+
+// dial connects to addr via gt, giving up after DialTimeout if set.
+//
+// ErrDialTimeout is returned when the dial timed out, but not when ctx itself is done.
+func (s *Pumpe) dial(ctx context.Context, gt gate.ExitGate, addr string) (net.Conn, error) {
+	if s.cfg.DialTimeout <= 0 {
+		return gt.DialContext(ctx, "tcp", addr)
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, s.cfg.DialTimeout)
+	defer cancel()
+
+	conn, err := gt.DialContext(dctx, "tcp", addr)
+	if err != nil && ctx.Err() == nil && errors.Is(dctx.Err(), context.DeadlineExceeded) {
+		return nil, ErrDialTimeout
+	}
+
+	return conn, err
 }
 
 func (s *Pumpe) pickDialer(ctx context.Context, hdr http.Header) (gate.ExitGate, error) {
@@ -154,15 +684,252 @@ func (s *Pumpe) pickDialer(ctx context.Context, hdr http.Header) (gate.ExitGate,
 			return nil, model.ErrInvalidUUID
 		}
 
-		return s.set.ByID(id)
+		if s.ids == nil {
+			return nil, ErrSelectionUnsupported
+		}
+
+		// Waiting smooths over brief maintenance, e.g. a refresh of the pinned gate.
+		if wait, _ := strconv.ParseBool(hdr.Get(headerProxyWait)); wait {
+			return s.ids.ByIDWait(ctx, id)
+		}
+
+		return s.ids.ByID(id)
+	}
+
+	if raw := hdr.Get(headerProxyGateIdx); raw != "" {
+		idx, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, ErrInvalidGateIndex
+		}
+
+		kind := hdr.Get(headerProxyGateType)
+		if kind == "" {
+			return nil, ErrGateIndexNoType
+		}
+
+		if s.kinds == nil {
+			return nil, ErrSelectionUnsupported
+		}
+
+		return s.kinds.ByIndex(gate.Kind(kind), idx)
+	}
+
+	if name := hdr.Get(headerProxyGateGrp); name != "" {
+		if s.grps == nil {
+			return nil, ErrSelectionUnsupported
+		}
+
+		return s.grps.ByGroup(ctx, name)
 	}
 
 	if kind := hdr.Get(headerProxyGateType); kind != "" {
-		return s.set.ByKind(ctx, gate.Kind(kind))
+		if s.kinds == nil {
+			return nil, ErrSelectionUnsupported
+		}
+
+		return s.kinds.ByKind(ctx, gate.Kind(kind))
+	}
+
+	if s.rnd == nil {
+		return nil, ErrSelectionUnsupported
 	}
 
 	// Default to a random gate.
-	return s.set.Random(ctx)
+	return s.rnd.Random(ctx)
+}
+
+// retries returns the number of retries for a request.
+//
+// The value from headerProxyRetries overrides the configured one, and is clamped to maxDialRetries.
+// Requests to a gate pinned by id are never retried, and neither are requests to a group,
+// as a retry would pick a gate outside of it.
+func (s *Pumpe) retries(hdr http.Header) (int, error) {
+	result := s.cfg.MaxDialRetries
+
+	if raw := hdr.Get(headerProxyRetries); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return 0, ErrInvalidRetries
+		}
+
+		result = min(n, maxDialRetries)
+	}
+
+	// A pinned gate cannot be replaced with another one.
+	if hdr.Get(headerProxyGateID) != "" || hdr.Get(headerProxyGateIdx) != "" || hdr.Get(headerProxyGateGrp) != "" {
+		return 0, nil
+	}
+
+	return result, nil
+}
+
+// nextDialer returns a gate of the same kind as gt to retry with, if attempt has not exhausted retries.
+func (s *Pumpe) nextDialer(ctx context.Context, gt gate.ExitGate, attempt, retries int) (gate.ExitGate, bool) {
+	if attempt >= retries || s.rnd == nil {
+		return nil, false
+	}
+
+	result, err := s.rnd.RandomExcept(ctx, gt.Kind(), gt.ID())
+	if err != nil {
+		return nil, false
+	}
+
+	return result, true
+}
+
+// reportResult reports the outcome of a request via gt to the set.
+//
+// A failure is reported only when it is the fault of the gate, see isGateFault.
+func (s *Pumpe) reportResult(ctx context.Context, gt gate.ExitGate, err error) {
+	if err != nil && !isGateFault(ctx, err) {
+		return
+	}
+
+	s.set.ReportResult(gt.ID(), err == nil)
+}
+
+// isGateFault reports whether err, returned for a request via a gate, tells that the gate is not working.
+//
+// Cancellation by the client, refused destinations, and errors coming from the target,
+// such as a refused connection, an unknown host or a bad certificate, say nothing of the gate.
+func isGateFault(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, ErrDestNotAllowed), errors.Is(err, gate.ErrWGDestNotAllowed):
+		return false
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return false
+	}
+
+	if dnsErr := (*net.DNSError)(nil); errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+
+	if isTLSErr(err) {
+		return false
+	}
+
+	return true
+}
+
+// isTLSErr reports whether err comes from the TLS handshake with the target.
+func isTLSErr(err error) bool {
+	if rerr := (tls.RecordHeaderError{}); errors.As(err, &rerr) {
+		return true
+	}
+
+	if aerr := tls.AlertError(0); errors.As(err, &aerr) {
+		return true
+	}
+
+	if cerr := (*tls.CertificateVerificationError)(nil); errors.As(err, &cerr) {
+		return true
+	}
+
+	if herr := (x509.HostnameError{}); errors.As(err, &herr) {
+		return true
+	}
+
+	if uerr := (x509.UnknownAuthorityError{}); errors.As(err, &uerr) {
+		return true
+	}
+
+	return false
+}
+
+// isPortAllowed reports whether addr is allowed by the connect ports allowlist.
+//
+// An empty allowlist allows all ports.
+func (s *Pumpe) isPortAllowed(addr string) bool {
+	if len(s.cfg.AllowedConnectPorts) == 0 {
+		return true
+	}
+
+	port, err := portFromAddr(addr)
+	if err != nil {
+		return false
+	}
+
+	for i := range s.cfg.AllowedConnectPorts {
+		if s.cfg.AllowedConnectPorts[i] == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDest returns ErrDestNotAllowed when private destinations are blocked, and addr is a literal private address.
+//
+// The addr may come with or without a port.
+// Hostnames are not resolved here, as the gate resolves them again, possibly to other addresses, or on the exit side.
+// Instead, gates check the address they actually dial, see destCtx.
+func (s *Pumpe) checkDest(addr string) error {
+	if !s.cfg.BlockPrivateDests {
+		return nil
+	}
+
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+
+	ip, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	if err != nil {
+		return nil
+	}
+
+	return s.checkDestIP(ip)
+}
+
+// destCtx returns ctx which makes gates check remote addresses at dial time when private destinations are blocked.
+func (s *Pumpe) destCtx(ctx context.Context) context.Context {
+	if !s.cfg.BlockPrivateDests {
+		return ctx
+	}
+
+	return gate.WithDestCheck(ctx, s.checkDestIP)
+}
+
+func (s *Pumpe) checkDestIP(ip netip.Addr) error {
+	if isPrivateAddr(ip) && !isAddrInPrefixes(ip, s.cfg.AllowedPrivateDests) {
+		return ErrDestNotAllowed
+	}
+
+	return nil
+}
+
+// isPrivateAddr reports whether ip is a loopback, private, link-local or unspecified address.
+func isPrivateAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+func isAddrInPrefixes(ip netip.Addr, list []netip.Prefix) bool {
+	ip = ip.Unmap()
+
+	for i := range list {
+		if list[i].Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kindLabel returns the kind of gt for metrics, or none when no gate was used.
+func kindLabel(gt gate.ExitGate) string {
+	if gt == nil {
+		return "none"
+	}
+
+	return gt.Kind().String()
 }
 
 // newHopHeaders returns a list of hop-by-hop headers.
@@ -179,6 +946,11 @@ func newHopHeaders() []string {
 		"Upgrade",
 		headerProxyGateID,
 		headerProxyGateType,
+		headerProxyRetries,
+		headerProxyWait,
+		headerProxyNoFwd,
+		headerProxyGateIdx,
+		headerProxyGateGrp,
 	}
 
 	return result
@@ -204,6 +976,46 @@ func delConnectionHeaders(hdr http.Header) {
 	}
 }
 
+// setUserAgent replaces the User-Agent in hdr with ua if not empty, or removes it if strip is set.
+//
+// Otherwise hdr is left untouched.
+func setUserAgent(hdr http.Header, strip bool, ua string) {
+	switch {
+	case ua != "":
+		hdr.Set("User-Agent", ua)
+
+	case strip:
+		// An empty value prevents the client from sending its default.
+		hdr.Set("User-Agent", "")
+	}
+}
+
+// setXForwardedFor updates X-Forwarded-For in hdr with the client's address according to mode.
+func setXForwardedFor(hdr http.Header, mode gate.XFFMode, remoteAddr string) {
+	if mode == gate.XFFModeRemove {
+		hdr.Del("X-Forwarded-For")
+
+		return
+	}
+
+	if mode == gate.XFFModeReplace {
+		hdr.Del("X-Forwarded-For")
+	}
+
+	if ip, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		addHostToXForwardedHeader(hdr, ip)
+	}
+}
+
+// delForwardedHeaders removes the headers that reveal the client's address or the proxies the request went through.
+func delForwardedHeaders(hdr http.Header) {
+	hdr.Del("Forwarded")
+	hdr.Del("Via")
+	hdr.Del("X-Forwarded-For")
+	hdr.Del("X-Forwarded-Host")
+	hdr.Del("X-Real-Ip")
+}
+
 func addHostToXForwardedHeader(hdr http.Header, host string) {
 	if ex, ok := hdr["X-Forwarded-For"]; ok {
 		host = strings.Join(ex, ", ") + ", " + host
@@ -220,17 +1032,42 @@ func copyHeader(dst, src http.Header) {
 	}
 }
 
+// remoteAddrFromHost returns host with the port, defaulting to 443.
+//
+// IPv6 literals are accepted both with and without brackets.
 func remoteAddrFromHost(host string) string {
-	result := host
-	if !strings.Contains(host, ":") {
-		result = net.JoinHostPort(result, "443")
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
 	}
 
-	return result
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), "443")
+}
+
+func portFromAddr(addr string) (int, error) {
+	_, rawPort, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(rawPort)
+}
+
+// xferDataCtx copies from src to dst until src is exhausted or ctx is done.
+//
+// Cancellation expires the read deadline on src, which unblocks the copy.
+// When src does not support deadlines, the copy is not cancellable, and it works as xferData.
+func xferDataCtx(ctx context.Context, dst io.Writer, src io.Reader) int64 {
+	if rd, ok := src.(readDeadliner); ok {
+		stop := context.AfterFunc(ctx, func() { _ = rd.SetReadDeadline(time.Now()) })
+		defer stop()
+	}
+
+	return xferData(dst, src)
 }
 
-func xferData(dst io.Writer, src io.Reader) {
-	_, _ = io.Copy(dst, src)
+// xferData copies from src to dst, closes the respective halves if supported, and returns the number of bytes copied.
+func xferData(dst io.Writer, src io.Reader) int64 {
+	n, _ := io.Copy(dst, src)
 
 	if wc, ok := dst.(writeCloser); ok {
 		_ = wc.CloseWrite()
@@ -239,6 +1076,160 @@ func xferData(dst io.Writer, src io.Reader) {
 	if rc, ok := src.(readCloser); ok {
 		_ = rc.CloseRead()
 	}
+
+	return n
+}
+
+// countingReadCloser counts the bytes read from the underlying body.
+//
+// The transport might read the body in a separate goroutine, hence the counter is atomic.
+type countingReadCloser struct {
+	io.ReadCloser
+
+	n *atomic.Uint64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(uint64(n))
+
+	return n, err
+}
+
+// idleConn makes reads fail after being idle for a timeout, once armed.
+//
+// Unarmed, reads are left as they are.
+type idleConn struct {
+	net.Conn
+
+	ctx  context.Context
+	tout *atomic.Int64
+}
+
+func newIdleConn(ctx context.Context, conn net.Conn) *idleConn {
+	result := &idleConn{
+		Conn: conn,
+		ctx:  ctx,
+		tout: &atomic.Int64{},
+	}
+
+	return result
+}
+
+// arm starts the idle timeout, which also applies to a read in progress.
+//
+// A zero or negative tout has no effect.
+func (c *idleConn) arm(tout time.Duration) {
+	if tout <= 0 {
+		return
+	}
+
+	c.tout.Store(int64(tout))
+	c.extend(tout)
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	if tout := c.tout.Load(); tout > 0 {
+		c.extend(time.Duration(tout))
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) CloseRead() error {
+	if rc, ok := c.Conn.(readCloser); ok {
+		return rc.CloseRead()
+	}
+
+	return nil
+}
+
+func (c *idleConn) extend(tout time.Duration) {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(tout))
+
+	// The extension must not undo the deadline set on cancellation.
+	if c.ctx.Err() != nil {
+		_ = c.Conn.SetReadDeadline(time.Now())
+	}
+}
+
+// rejectUnavailable responds with 503 until the first warmup of the set succeeds, or while the set is paused.
+//
+// Requests would otherwise race the initial warmup, and fail in less obvious ways.
+// Later warmups don't count, the gates that are already ready keep serving meanwhile.
+// Only the warmup is known to end soon, so Retry-After is set for it alone.
+func (s *Pumpe) rejectUnavailable(w http.ResponseWriter, r *http.Request) error {
+	switch {
+	case !isClosed(s.set.Ready()):
+		w.Header().Set("Retry-After", retryAfterWarmup)
+		_ = writeHTTPErr(w, r.Header, http.StatusServiceUnavailable, gate.ErrSetIsWarmingUp.Error())
+
+		return gate.ErrSetIsWarmingUp
+
+	case s.set.IsPaused():
+		_ = writeHTTPErr(w, r.Header, http.StatusServiceUnavailable, gate.ErrSetPaused.Error())
+
+		return gate.ErrSetPaused
+
+	default:
+		return nil
+	}
+}
+
+// isClosed reports whether c is closed, without blocking.
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// exceedsHeaderLimits reports whether hdr has more than maxN values, or more than maxBytes in names and values.
+//
+// A non-positive limit is not checked.
+func exceedsHeaderLimits(hdr http.Header, maxN, maxBytes int) bool {
+	var n, size int
+
+	for k, vals := range hdr {
+		n += len(vals)
+
+		for i := range vals {
+			size += len(k) + len(vals[i])
+		}
+	}
+
+	return (maxN > 0 && n > maxN) || (maxBytes > 0 && size > maxBytes)
+}
+
+// writeHTTPErr writes a JSON error when the request indicates a JSON client, and plain text otherwise.
+func writeHTTPErr(w http.ResponseWriter, hdr http.Header, code int, text string) error {
+	if wantsJSON(hdr) {
+		return web.WriteErrorJSON(w, code, text)
+	}
+
+	return web.WriteError(w, code, text)
+}
+
+// wantsJSON reports whether Accept or Content-Type in hdr refer to JSON.
+func wantsJSON(hdr http.Header) bool {
+	for _, v := range hdr.Values("Accept") {
+		for _, mt := range strings.Split(v, ",") {
+			if isJSONMediaType(mt) {
+				return true
+			}
+		}
+	}
+
+	return isJSONMediaType(hdr.Get("Content-Type"))
+}
+
+func isJSONMediaType(raw string) bool {
+	mt, _, _ := strings.Cut(raw, ";")
+	mt = strings.ToLower(strings.TrimSpace(mt))
+
+	return mt == "application/json" || (strings.HasPrefix(mt, "application/") && strings.HasSuffix(mt, "+json"))
 }
 
 func writeErrToConn(dst io.Writer, rerr error) error {
@@ -246,8 +1237,25 @@ func writeErrToConn(dst io.Writer, rerr error) error {
 		return nil
 	}
 
-	errm := rerr.Error()
-	msg := "HTTP/1.1 502 Bad Gateway\r\nContent-Type: text/plain\r\nContent-Length: " + strconv.Itoa(len(errm)) + "\r\n\r\n" + errm
+	return writeStatusToConn(dst, connErrStatus(rerr), rerr.Error())
+}
+
+// connErrStatus returns the status for a failed CONNECT request, telling timeouts apart from other failures.
+func connErrStatus(err error) int {
+	if errors.Is(err, ErrDialTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+
+	return http.StatusBadGateway
+}
+
+func writeStatusToConn(dst io.Writer, code int, text string) error {
+	msg := "HTTP/1.1 " + strconv.Itoa(code) + " " + http.StatusText(code) + "\r\nContent-Type: text/plain\r\nContent-Length: " + strconv.Itoa(len(text)) + "\r\n\r\n" + text
 
 	_, err := io.WriteString(dst, msg)
 
@@ -4,13 +4,22 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	should "github.com/stretchr/testify/assert"
@@ -18,6 +27,7 @@ import (
 
 	"github.com/pavelbrm/pumpe/fakenet"
 	"github.com/pavelbrm/pumpe/gate"
+	"github.com/pavelbrm/pumpe/metrics"
 	"github.com/pavelbrm/pumpe/model"
 )
 
@@ -29,9 +39,11 @@ type testCase[G, E any] struct {
 
 func TestPumpe_HandleConnect(t *testing.T) {
 	type tcGiven struct {
-		set  *mockGateSet
-		req  *http.Request
-		fnRW func() http.ResponseWriter
+		cfg     *gate.SetConfig
+		set     *mockGateSet
+		req     *http.Request
+		fnRW    func() http.ResponseWriter
+		tunnels int64
 	}
 
 	type tcExpected struct {
@@ -43,6 +55,7 @@ func TestPumpe_HandleConnect(t *testing.T) {
 		{
 			name: "error_hijacking_not_supported",
 			given: tcGiven{
+				cfg: &gate.SetConfig{},
 				set: &mockGateSet{},
 				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
 				fnRW: func() http.ResponseWriter {
@@ -54,9 +67,32 @@ func TestPumpe_HandleConnect(t *testing.T) {
 			},
 		},
 
+		{
+			name: "error_set_is_warming_up",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnReady: func() <-chan struct{} { return make(chan struct{}) },
+				},
+				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
+				fnRW: func() http.ResponseWriter {
+					rw := fakenet.NewResponseRecorderHJ(nil)
+					rw.FnHijack = func() (net.Conn, *bufio.ReadWriter, error) {
+						return nil, nil, model.Error("unexpected_hijack")
+					}
+
+					return rw
+				},
+			},
+			exp: tcExpected{
+				err: gate.ErrSetIsWarmingUp,
+			},
+		},
+
 		{
 			name: "error_hijack_error",
 			given: tcGiven{
+				cfg: &gate.SetConfig{},
 				set: &mockGateSet{},
 				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
 				fnRW: func() http.ResponseWriter {
@@ -73,9 +109,31 @@ func TestPumpe_HandleConnect(t *testing.T) {
 			},
 		},
 
+		{
+			name: "error_too_many_tunnels",
+			given: tcGiven{
+				cfg: &gate.SetConfig{MaxTunnels: 2},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_random")
+					},
+				},
+				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ(nil)
+				},
+				tunnels: 2,
+			},
+			exp: tcExpected{
+				msg: "HTTP/1.1 503 Service Unavailable\r\nContent-Type: text/plain\r\nContent-Length: 25\r\n\r\nservice: too many tunnels",
+				err: ErrTooManyTunnels,
+			},
+		},
+
 		{
 			name: "error_pick_dialer",
 			given: tcGiven{
+				cfg: &gate.SetConfig{MaxTunnels: 2},
 				set: &mockGateSet{
 					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
 						return nil, model.Error("something_went_wrong")
@@ -95,6 +153,7 @@ func TestPumpe_HandleConnect(t *testing.T) {
 		{
 			name: "error_dial_failed",
 			given: tcGiven{
+				cfg: &gate.SetConfig{},
 				set: &mockGateSet{
 					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
 						result := &gate.MockExitGate{
@@ -120,19 +179,17 @@ func TestPumpe_HandleConnect(t *testing.T) {
 		},
 
 		{
-			name: "error_write_200_failed",
+			name: "error_dial_timeout",
 			given: tcGiven{
+				cfg: &gate.SetConfig{DialTimeout: time.Millisecond},
 				set: &mockGateSet{
 					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
 						result := &gate.MockExitGate{
 							Dialer: &gate.MockNetDialer{
 								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-									conn := &fakenet.MockConn{
-										Recv: &bytes.Reader{},
-										Send: &bytes.Buffer{},
-									}
+									<-ctx.Done()
 
-									return conn, nil
+									return nil, ctx.Err()
 								},
 							},
 						}
@@ -142,42 +199,57 @@ func TestPumpe_HandleConnect(t *testing.T) {
 				},
 				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
 				fnRW: func() http.ResponseWriter {
-					rw := fakenet.NewResponseRecorderHJ(nil)
+					return fakenet.NewResponseRecorderHJ(nil)
+				},
+			},
+			exp: tcExpected{
+				msg: "HTTP/1.1 504 Gateway Timeout\r\nContent-Type: text/plain\r\nContent-Length: 23\r\n\r\nservice: dial timed out",
+				err: ErrDialTimeout,
+			},
+		},
 
-					conn := rw.ConnT()
-					conn.FnWrite = func(b []byte) (int, error) {
-						return 0, model.Error("something_went_wrong")
-					}
+		{
+			name: "error_dial_net_timeout",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Dialer: &gate.MockNetDialer{
+								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+									return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+								},
+							},
+						}
 
-					return rw
+						return result, nil
+					},
+				},
+				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ(nil)
 				},
 			},
 			exp: tcExpected{
-				err: model.Error("something_went_wrong"),
+				msg: "HTTP/1.1 504 Gateway Timeout\r\nContent-Type: text/plain\r\nContent-Length: 21\r\n\r\ndial tcp: i/o timeout",
+				err: &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded},
 			},
 		},
 
 		{
-			name: "valid",
+			name: "error_write_200_failed",
 			given: tcGiven{
+				cfg: &gate.SetConfig{},
 				set: &mockGateSet{
 					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
 						result := &gate.MockExitGate{
 							Dialer: &gate.MockNetDialer{
 								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 									conn := &fakenet.MockConn{
-										Recv: bytes.NewReader([]byte("test response from target\n")),
+										Recv: &bytes.Reader{},
 										Send: &bytes.Buffer{},
 									}
 
-									conn.FnWrite = func(b []byte) (int, error) {
-										if !bytes.Equal(b, []byte("test request from client\n")) {
-											return 0, model.Error("unexpected_write_to_target")
-										}
-
-										return conn.Send.Write(b)
-									}
-
 									return conn, nil
 								},
 							},
@@ -188,290 +260,2003 @@ func TestPumpe_HandleConnect(t *testing.T) {
 				},
 				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
 				fnRW: func() http.ResponseWriter {
-					return fakenet.NewResponseRecorderHJ([]byte("test request from client\n"))
+					rw := fakenet.NewResponseRecorderHJ(nil)
+
+					conn := rw.ConnT()
+					conn.FnWrite = func(b []byte) (int, error) {
+						return 0, model.Error("something_went_wrong")
+					}
+
+					return rw
 				},
 			},
 			exp: tcExpected{
-				msg: "HTTP/1.1 200 Connection established\r\n\r\ntest response from target\n",
+				err: model.Error("something_went_wrong"),
 			},
 		},
-	}
-
-	for i := range tests {
-		tc := tests[i]
-
-		t.Run(tc.name, func(t *testing.T) {
-			svc := NewPumpe(tc.given.set)
-
-			ctx := context.Background()
-			rw := tc.given.fnRW()
-
-			actual := svc.HandleConnect(ctx, rw, tc.given.req)
-			must.Equal(t, tc.exp.err, actual)
-
-			if tc.exp.err != nil && tc.exp.msg == "" {
-				return
-			}
-
-			switch rwt := rw.(type) {
-			case *fakenet.ResponseRecorderHJ:
-				should.Equal(t, tc.exp.msg, rwt.Body.String())
-
-				c, ok := rwt.Conn().(*fakenet.MockConn)
-				must.Equal(t, true, ok)
-
-				should.Equal(t, tc.exp.msg, c.Send.String())
-			case *httptest.ResponseRecorder:
-				should.Equal(t, tc.exp.msg, rwt.Body.String())
-			default:
-				return
-			}
-		})
-	}
-}
-
-func TestPumpe_HandleHTTP(t *testing.T) {
-	type tcGiven struct {
-		set *mockGateSet
-		req *http.Request
-	}
 
-	type tcExpected struct {
-		code int
-		msg  string
-		hdr  http.Header
-		err  error
-	}
-
-	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_pick_dialer",
+			name: "error_port_not_allowed",
 			given: tcGiven{
+				cfg: &gate.SetConfig{AllowedConnectPorts: []int{443}},
 				set: &mockGateSet{
 					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
-						return nil, model.Error("something_went_wrong")
+						return nil, model.Error("unexpected_pick_dialer")
 					},
 				},
-				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil),
+				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:22", nil),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ(nil)
+				},
 			},
 			exp: tcExpected{
-				err: model.Error("something_went_wrong"),
+				msg: "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\nContent-Length: 36\r\n\r\nservice: connect to port not allowed",
+				err: ErrConnectPortNotAllowed,
 			},
 		},
 
 		{
-			name: "error_dialer_do",
+			name: "error_dest_not_allowed",
 			given: tcGiven{
+				cfg: &gate.SetConfig{BlockPrivateDests: true},
 				set: &mockGateSet{
 					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
-						result := &gate.MockExitGate{
-							Doer: &gate.MockHTTPDoer{
-								FnDo: func(r *http.Request) (*http.Response, error) {
-									return nil, model.Error("something_went_wrong")
-								},
-							},
-						}
-
-						return result, nil
+						return nil, model.Error("unexpected_pick_dialer")
 					},
 				},
-				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil),
+				req: httptest.NewRequest(http.MethodConnect, "127.0.0.1:443", nil),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ(nil)
+				},
 			},
 			exp: tcExpected{
-				code: http.StatusBadGateway,
-				msg:  "server error",
-				err:  model.Error("something_went_wrong"),
+				msg: "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\nContent-Length: 32\r\n\r\nservice: destination not allowed",
+				err: ErrDestNotAllowed,
 			},
 		},
 
 		{
-			name: "valid",
+			name: "error_dest_not_allowed_at_dial",
 			given: tcGiven{
+				cfg: &gate.SetConfig{BlockPrivateDests: true, MaxDialRetries: 1},
 				set: &mockGateSet{
 					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
-						result := &gate.MockExitGate{
-							Doer: &gate.MockHTTPDoer{
-								FnDo: func(r *http.Request) (*http.Response, error) {
-									resp := gate.NewMockResponse()
-									resp.Body = io.NopCloser(bytes.NewBufferString("My name is Bane."))
-
-									resp.Header.Add("Proxy-Connection", "test_header_removal")
-									resp.Header.Add("X-Custom-App-Header", "test_header_preservation")
-
-									return resp, nil
-								},
-							},
-						}
+						return gate.NewDirect(time.Second), nil
+					},
 
-						return result, nil
+					fnRandomExcept: func(ctx context.Context, kind gate.Kind, id uuid.UUID) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_retry")
 					},
 				},
-				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil),
+				req: httptest.NewRequest(http.MethodConnect, "localhost:443", nil),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ(nil)
+				},
 			},
 			exp: tcExpected{
-				code: http.StatusOK,
-				msg:  "My name is Bane.",
-				hdr:  http.Header{"X-Custom-App-Header": []string{"test_header_preservation"}},
+				msg: "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\nContent-Length: 32\r\n\r\nservice: destination not allowed",
+				err: ErrDestNotAllowed,
 			},
 		},
-	}
-
-	for i := range tests {
-		tc := tests[i]
-
-		t.Run(tests[i].name, func(t *testing.T) {
-			svc := NewPumpe(tc.given.set)
-
-			ctx := context.Background()
-			rw := httptest.NewRecorder()
 
-			actual := svc.HandleHTTP(ctx, rw, tc.given.req)
-			must.Equal(t, tc.exp.err, actual)
-
-			if tc.exp.err != nil && tc.exp.code == 0 {
-				return
-			}
-
-			should.Equal(t, tc.exp.code, rw.Code)
-			should.Equal(t, tc.exp.msg, rw.Body.String())
-
-			if len(tc.exp.hdr) > 0 {
-				should.Equal(t, tc.exp.hdr, rw.Result().Header)
-			}
-		})
-	}
-}
-
-func TestPumpe_pickDialer(t *testing.T) {
-	type tcGiven struct {
-		set *mockGateSet
-		hdr http.Header
-	}
-
-	type tcExpected struct {
-		id   uuid.UUID
-		kind gate.Kind
-		err  error
-	}
-
-	tests := []testCase[tcGiven, tcExpected]{
 		{
-			name: "error_invalid_id",
+			name: "error_invalid_retries",
 			given: tcGiven{
-				set: &mockGateSet{},
-				hdr: http.Header{
-					"Proxy-Pumpe-Gate-Id": []string{"something"},
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_pick_dialer")
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil)
+					req.Header.Set("Proxy-Pumpe-Retries", "-1")
+
+					return req
+				}(),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ(nil)
 				},
 			},
 			exp: tcExpected{
-				err: model.ErrInvalidUUID,
+				msg: "HTTP/1.1 400 Bad Request\r\nContent-Type: text/plain\r\nContent-Length: 24\r\n\r\nservice: invalid retries",
+				err: ErrInvalidRetries,
 			},
 		},
 
 		{
-			name: "valid_id",
+			name: "error_dial_failed_retries_exhausted",
 			given: tcGiven{
+				cfg: &gate.SetConfig{MaxDialRetries: 1},
 				set: &mockGateSet{
-					fnByID: func(id uuid.UUID) (gate.ExitGate, error) {
-						if id != uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") {
-							return nil, model.Error("unexpected_id")
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Dialer: &gate.MockNetDialer{
+								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+									return nil, model.Error("something_went_wrong")
+								},
+							},
 						}
 
+						return result, nil
+					},
+
+					fnRandomExcept: func(ctx context.Context, kind gate.Kind, id uuid.UUID) (gate.ExitGate, error) {
 						result := &gate.MockExitGate{
-							FnID:   func() uuid.UUID { return uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") },
-							FnKind: func() gate.Kind { return gate.KindTor },
+							FnID: func() uuid.UUID { return uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") },
+							Dialer: &gate.MockNetDialer{
+								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+									return nil, model.Error("something_else_went_wrong")
+								},
+							},
 						}
 
 						return result, nil
 					},
 				},
-				hdr: http.Header{
-					"Proxy-Pumpe-Gate-Id": []string{"c0c0a000-0000-4000-a000-000000000000"},
-				},
+				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ(nil)
+				},
+			},
+			exp: tcExpected{
+				msg: "HTTP/1.1 502 Bad Gateway\r\nContent-Type: text/plain\r\nContent-Length: 25\r\n\r\nsomething_else_went_wrong",
+				err: model.Error("something_else_went_wrong"),
+			},
+		},
+
+		{
+			name: "valid_retried",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Dialer: &gate.MockNetDialer{
+								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+									return nil, model.Error("something_went_wrong")
+								},
+							},
+						}
+
+						return result, nil
+					},
+
+					fnRandomExcept: func(ctx context.Context, kind gate.Kind, id uuid.UUID) (gate.ExitGate, error) {
+						if id != uuid.MustParse("5ca1ab1e-0000-4000-a000-000000000000") {
+							return nil, model.Error("unexpected_id")
+						}
+
+						result := &gate.MockExitGate{
+							Dialer: &gate.MockNetDialer{
+								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+									conn := &fakenet.MockConn{
+										Recv: bytes.NewReader([]byte("test response from target\n")),
+										Send: &bytes.Buffer{},
+									}
+
+									return conn, nil
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil)
+					req.Header.Set("Proxy-Pumpe-Retries", "2")
+
+					return req
+				}(),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ([]byte("test request from client\n"))
+				},
+			},
+			exp: tcExpected{
+				msg: "HTTP/1.1 200 Connection established\r\n\r\ntest response from target\n",
+			},
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Dialer: &gate.MockNetDialer{
+								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+									conn := &fakenet.MockConn{
+										Recv: bytes.NewReader([]byte("test response from target\n")),
+										Send: &bytes.Buffer{},
+									}
+
+									conn.FnWrite = func(b []byte) (int, error) {
+										if !bytes.Equal(b, []byte("test request from client\n")) {
+											return 0, model.Error("unexpected_write_to_target")
+										}
+
+										return conn.Send.Write(b)
+									}
+
+									return conn, nil
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ([]byte("test request from client\n"))
+				},
+			},
+			exp: tcExpected{
+				msg: "HTTP/1.1 200 Connection established\r\n\r\ntest response from target\n",
+			},
+		},
+
+		{
+			name: "valid_connect_response",
+			given: tcGiven{
+				cfg: &gate.SetConfig{ConnectResponse: "HTTP/1.0 200 OK\r\n\r\n"},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Dialer: &gate.MockNetDialer{
+								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+									conn := &fakenet.MockConn{
+										Recv: bytes.NewReader([]byte("test response from target\n")),
+										Send: &bytes.Buffer{},
+									}
+
+									return conn, nil
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:443", nil),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ([]byte("test request from client\n"))
+				},
+			},
+			exp: tcExpected{
+				msg: "HTTP/1.0 200 OK\r\n\r\ntest response from target\n",
+			},
+		},
+
+		{
+			name: "valid_port_allowed",
+			given: tcGiven{
+				cfg: &gate.SetConfig{AllowedConnectPorts: []int{443, 8443}},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Dialer: &gate.MockNetDialer{
+								FnDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+									if addr != "httpbin.org:8443" {
+										return nil, model.Error("unexpected_addr")
+									}
+
+									conn := &fakenet.MockConn{
+										Recv: bytes.NewReader([]byte("test response from target\n")),
+										Send: &bytes.Buffer{},
+									}
+
+									return conn, nil
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: httptest.NewRequest(http.MethodConnect, "httpbin.org:8443", nil),
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ([]byte("test request from client\n"))
+				},
+			},
+			exp: tcExpected{
+				msg: "HTTP/1.1 200 Connection established\r\n\r\ntest response from target\n",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewPumpe(tc.given.cfg, tc.given.set)
+			svc.tunnels.Store(tc.given.tunnels)
+
+			ctx := context.Background()
+			rw := tc.given.fnRW()
+
+			_, actual := svc.HandleConnect(ctx, rw, tc.given.req)
+			must.Equal(t, tc.exp.err, actual)
+
+			// Finished tunnels must not be counted, nor rejected ones.
+			should.Equal(t, tc.given.tunnels, svc.tunnels.Load())
+
+			if tc.exp.err != nil && tc.exp.msg == "" {
+				return
+			}
+
+			switch rwt := rw.(type) {
+			case *fakenet.ResponseRecorderHJ:
+				should.Equal(t, tc.exp.msg, rwt.Body.String())
+
+				c, ok := rwt.Conn().(*fakenet.MockConn)
+				must.Equal(t, true, ok)
+
+				should.Equal(t, tc.exp.msg, c.Send.String())
+			case *httptest.ResponseRecorder:
+				should.Equal(t, tc.exp.msg, rwt.Body.String())
+			default:
+				return
+			}
+		})
+	}
+}
+
+func TestPumpe_HandleHTTP(t *testing.T) {
+	type tcGiven struct {
+		cfg *gate.SetConfig
+		set *mockGateSet
+		req *http.Request
+	}
+
+	type tcExpected struct {
+		code int
+		msg  string
+		hdr  http.Header
+		err  error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_set_is_warming_up",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnReady: func() <-chan struct{} { return make(chan struct{}) },
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_random")
+					},
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil),
+			},
+			exp: tcExpected{
+				code: http.StatusServiceUnavailable,
+				msg:  gate.ErrSetIsWarmingUp.Error(),
+				hdr: http.Header{
+					"Content-Type":           []string{"text/plain; charset=utf-8"},
+					"Retry-After":            []string{"5"},
+					"X-Content-Type-Options": []string{"nosniff"},
+				},
+				err: gate.ErrSetIsWarmingUp,
+			},
+		},
+
+		{
+			name: "error_set_is_paused",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnIsPaused: func() bool { return true },
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_random")
+					},
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil),
+			},
+			exp: tcExpected{
+				code: http.StatusServiceUnavailable,
+				msg:  gate.ErrSetPaused.Error(),
+				err:  gate.ErrSetPaused,
+			},
+		},
+
+		{
+			name: "error_header_count",
+			given: tcGiven{
+				cfg: &gate.SetConfig{MaxHeaderCount: 2},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_random")
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil)
+					req.Header.Add("X-Custom", "a")
+					req.Header.Add("X-Custom", "b")
+					req.Header.Add("X-Custom", "c")
+
+					return req
+				}(),
+			},
+			exp: tcExpected{
+				code: http.StatusRequestHeaderFieldsTooLarge,
+				msg:  ErrHeadersTooLarge.Error(),
+				err:  ErrHeadersTooLarge,
+			},
+		},
+
+		{
+			name: "error_dest_not_allowed",
+			given: tcGiven{
+				cfg: &gate.SetConfig{BlockPrivateDests: true},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_random")
+					},
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://192.168.0.1/admin", nil),
+			},
+			exp: tcExpected{
+				code: http.StatusForbidden,
+				msg:  ErrDestNotAllowed.Error(),
+				err:  ErrDestNotAllowed,
+			},
+		},
+
+		{
+			name: "error_dest_not_allowed_at_dial",
+			given: tcGiven{
+				cfg: &gate.SetConfig{BlockPrivateDests: true, MaxDialRetries: 1},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return gate.NewDirect(time.Second), nil
+					},
+
+					fnRandomExcept: func(ctx context.Context, kind gate.Kind, id uuid.UUID) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_retry")
+					},
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://localhost/admin", nil),
+			},
+			exp: tcExpected{
+				code: http.StatusForbidden,
+				msg:  ErrDestNotAllowed.Error(),
+				err:  ErrDestNotAllowed,
+			},
+		},
+
+		{
+			name: "error_body_content_length",
+			given: tcGiven{
+				cfg: &gate.SetConfig{MaxBodyBytes: 8},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_random")
+					},
+				},
+				req: httptest.NewRequest(http.MethodPost, "http://httpbin.org/post", strings.NewReader("0123456789")),
+			},
+			exp: tcExpected{
+				code: http.StatusRequestEntityTooLarge,
+				msg:  ErrBodyTooLarge.Error(),
+				err:  ErrBodyTooLarge,
+			},
+		},
+
+		{
+			name: "error_body_unknown_length",
+			given: tcGiven{
+				cfg: &gate.SetConfig{MaxBodyBytes: 8},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Doer: &gate.MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									_, err := io.ReadAll(r.Body)
+
+									return nil, err
+								},
+							},
+						}
+
+						return result, nil
+					},
+					fnReportResult: func(id uuid.UUID, ok bool) {
+						panic("unexpected_report_result")
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodPost, "http://httpbin.org/post", strings.NewReader("0123456789"))
+					req.ContentLength = -1
+
+					return req
+				}(),
+			},
+			exp: tcExpected{
+				code: http.StatusRequestEntityTooLarge,
+				msg:  ErrBodyTooLarge.Error(),
+				err:  ErrBodyTooLarge,
+			},
+		},
+
+		{
+			name: "valid_body_within_limit",
+			given: tcGiven{
+				cfg: &gate.SetConfig{MaxBodyBytes: 16},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Doer: &gate.MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									if _, err := io.ReadAll(r.Body); err != nil {
+										return nil, err
+									}
+
+									return gate.NewMockResponse(), nil
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: httptest.NewRequest(http.MethodPost, "http://httpbin.org/post", strings.NewReader("0123456789")),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+			},
+		},
+
+		{
+			name: "error_header_bytes",
+			given: tcGiven{
+				cfg: &gate.SetConfig{MaxHeaderBytes: 16},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_random")
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil)
+					req.Header.Set("Accept", "application/json")
+					req.Header.Set("X-Custom", strings.Repeat("a", 16))
+
+					return req
+				}(),
+			},
+			exp: tcExpected{
+				code: http.StatusRequestHeaderFieldsTooLarge,
+				msg:  `{"error":"service: request headers too large"}`,
+				err:  ErrHeadersTooLarge,
+			},
+		},
+
+		{
+			name: "error_pick_dialer",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("something_went_wrong")
+					},
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil),
+			},
+			exp: tcExpected{
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "error_dialer_do",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Doer: &gate.MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									return nil, model.Error("something_went_wrong")
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil),
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				msg:  "server error",
+				err:  model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "error_dialer_do_json",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Doer: &gate.MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									return nil, model.Error("something_went_wrong")
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil)
+					req.Header.Set("Accept", "application/json")
+
+					return req
+				}(),
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				msg:  `{"error":"server error"}`,
+				err:  model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "error_invalid_retries",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_pick_dialer")
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil)
+					req.Header.Set("Proxy-Pumpe-Retries", "many")
+
+					return req
+				}(),
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				msg:  "service: invalid retries",
+				err:  ErrInvalidRetries,
+			},
+		},
+
+		{
+			name: "valid_retried",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Doer: &gate.MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									return nil, model.Error("something_went_wrong")
+								},
+							},
+						}
+
+						return result, nil
+					},
+
+					fnRandomExcept: func(ctx context.Context, kind gate.Kind, id uuid.UUID) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Doer: &gate.MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									resp := gate.NewMockResponse()
+									resp.Body = io.NopCloser(bytes.NewBufferString("My name is Bane."))
+
+									return resp, nil
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil)
+					req.Header.Set("Proxy-Pumpe-Retries", "1")
+
+					return req
+				}(),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				msg:  "My name is Bane.",
+			},
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Doer: &gate.MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									resp := gate.NewMockResponse()
+									resp.Body = io.NopCloser(bytes.NewBufferString("My name is Bane."))
+
+									resp.Header.Add("Proxy-Connection", "test_header_removal")
+									resp.Header.Add("X-Custom-App-Header", "test_header_preservation")
+
+									return resp, nil
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				msg:  "My name is Bane.",
+				hdr:  http.Header{"X-Custom-App-Header": []string{"test_header_preservation"}},
+			},
+		},
+
+		{
+			name: "valid_no_forward",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Doer: &gate.MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									for _, key := range []string{"X-Forwarded-For", "Forwarded", "X-Real-Ip", "Proxy-Pumpe-No-Forward"} {
+										if _, ok := r.Header[key]; ok {
+											return nil, model.Error("unexpected_header: " + key)
+										}
+									}
+
+									resp := gate.NewMockResponse()
+									resp.Body = io.NopCloser(bytes.NewBufferString("My name is Bane."))
+
+									return resp, nil
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil)
+					req.Header.Set("Proxy-Pumpe-No-Forward", "true")
+					req.Header.Set("X-Forwarded-For", "192.0.2.1")
+					req.Header.Set("Forwarded", "for=192.0.2.1")
+					req.Header.Set("X-Real-Ip", "192.0.2.1")
+
+					return req
+				}(),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				msg:  "My name is Bane.",
+			},
+		},
+
+		{
+			name: "valid_compressed",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							Doer: &gate.MockHTTPDoer{
+								FnDo: func(r *http.Request) (*http.Response, error) {
+									if enc := r.Header.Get("Accept-Encoding"); enc != "gzip, deflate" {
+										return nil, model.Error("unexpected_accept_encoding: " + enc)
+									}
+
+									resp := gate.NewMockResponse()
+									resp.Body = io.NopCloser(bytes.NewBuffer([]byte{0x1f, 0x8b, 0x08, 0x00, 0xba, 0x4e}))
+
+									resp.Header.Set("Content-Encoding", "gzip")
+
+									return resp, nil
+								},
+							},
+						}
+
+						return result, nil
+					},
+				},
+				req: func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil)
+					req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+					return req
+				}(),
+			},
+			exp: tcExpected{
+				code: http.StatusOK,
+				msg:  string([]byte{0x1f, 0x8b, 0x08, 0x00, 0xba, 0x4e}),
+				hdr:  http.Header{"Content-Encoding": []string{"gzip"}},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tests[i].name, func(t *testing.T) {
+			svc := NewPumpe(tc.given.cfg, tc.given.set)
+
+			ctx := context.Background()
+			rw := httptest.NewRecorder()
+
+			_, actual := svc.HandleHTTP(ctx, rw, tc.given.req)
+			must.Equal(t, tc.exp.err, actual)
+
+			if tc.exp.err != nil && tc.exp.code == 0 {
+				return
+			}
+
+			should.Equal(t, tc.exp.code, rw.Code)
+			should.Equal(t, tc.exp.msg, rw.Body.String())
+
+			if len(tc.exp.hdr) > 0 {
+				should.Equal(t, tc.exp.hdr, rw.Result().Header)
+			}
+		})
+	}
+}
+
+func TestPumpe_HandleHTTP_metrics(t *testing.T) {
+	set := &mockGateSet{
+		fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+			result := &gate.MockExitGate{
+				FnKind: func() gate.Kind { return gate.KindTor },
+				Doer: &gate.MockHTTPDoer{
+					FnDo: func(r *http.Request) (*http.Response, error) {
+						return gate.NewMockResponse(), nil
+					},
+				},
+			}
+
+			return result, nil
+		},
+	}
+
+	reg := metrics.NewRegistry()
+
+	svc := NewPumpe(&gate.SetConfig{}, set)
+	svc.SetMetrics(metrics.NewRequests(reg))
+
+	{
+		_, err := svc.HandleHTTP(context.Background(), httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil))
+		must.Equal(t, nil, err)
+	}
+
+	set.fnIsPaused = func() bool { return true }
+
+	{
+		_, err := svc.HandleHTTP(context.Background(), httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://httpbin.org", nil))
+		must.Equal(t, gate.ErrSetPaused, err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	_, err := reg.WriteTo(buf)
+	must.Equal(t, nil, err)
+
+	should.Contains(t, buf.String(), "pumpe_requests_total{kind=\"none\",outcome=\"error\"} 1\n")
+	should.Contains(t, buf.String(), "pumpe_requests_total{kind=\"tor\",outcome=\"ok\"} 1\n")
+	should.Contains(t, buf.String(), "pumpe_requests_in_flight 0\n")
+}
+
+func TestPumpe_HandleHTTP_outRequest(t *testing.T) {
+	var out *http.Request
+
+	set := &mockGateSet{
+		fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+			result := &gate.MockExitGate{
+				Doer: &gate.MockHTTPDoer{
+					FnDo: func(r *http.Request) (*http.Response, error) {
+						out = r
+
+						return gate.NewMockResponse(), nil
+					},
+				},
+			}
+
+			return result, nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://httpbin.org/anything?q=1", nil)
+	req.Header.Set("Connection", "close")
+	req.Close = true
+
+	_, err := NewPumpe(&gate.SetConfig{}, set).HandleHTTP(context.Background(), httptest.NewRecorder(), req)
+	must.Equal(t, nil, err)
+
+	must.NotNil(t, out)
+
+	should.Equal(t, "http://httpbin.org/anything?q=1", out.URL.String())
+	should.Equal(t, "", out.RequestURI)
+	should.Equal(t, false, out.Close)
+	should.Equal(t, "", out.Header.Get("Connection"))
+
+	// The server request is left intact.
+	should.Equal(t, "http://httpbin.org/anything?q=1", req.RequestURI)
+	should.Equal(t, "close", req.Header.Get("Connection"))
+}
+
+func TestPumpe_HandleHTTP_queryParams(t *testing.T) {
+	var (
+		kind gate.Kind
+		out  *http.Request
+	)
+
+	set := &mockGateSet{
+		fnByKind: func(ctx context.Context, k gate.Kind) (gate.ExitGate, error) {
+			kind = k
+
+			result := &gate.MockExitGate{
+				Doer: &gate.MockHTTPDoer{
+					FnDo: func(r *http.Request) (*http.Response, error) {
+						out = r
+
+						return gate.NewMockResponse(), nil
+					},
+				},
+			}
+
+			return result, nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://httpbin.org/anything?q=1&_pumpe_gate_type=wireguard&b=a%2Cb+c", nil)
+
+	_, err := NewPumpe(&gate.SetConfig{}, set).HandleHTTP(context.Background(), httptest.NewRecorder(), req)
+	must.Equal(t, nil, err)
+
+	must.NotNil(t, out)
+
+	should.Equal(t, gate.KindWireGuard, kind)
+	should.Equal(t, "http://httpbin.org/anything?q=1&b=a%2Cb+c", out.URL.String())
+	should.Equal(t, "", out.Header.Get("Proxy-Pumpe-Gate-Type"))
+}
+
+func TestPumpe_HandleHTTP_bytes(t *testing.T) {
+	gt := &gate.MockExitGate{
+		Doer: &gate.MockHTTPDoer{
+			FnDo: func(r *http.Request) (*http.Response, error) {
+				if _, err := io.Copy(io.Discard, r.Body); err != nil {
+					return nil, err
+				}
+
+				resp := gate.NewMockResponse()
+				resp.Body = io.NopCloser(strings.NewReader("There is only passion."))
+
+				return resp, nil
+			},
+		},
+	}
+
+	set := &mockGateSet{
+		fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+			return gt, nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://httpbin.org/anything", strings.NewReader("Peace is a lie."))
+
+	actual, err := NewPumpe(&gate.SetConfig{}, set).HandleHTTP(context.Background(), httptest.NewRecorder(), req)
+	must.Equal(t, nil, err)
+
+	should.Equal(t, gt, actual)
+	should.Equal(t, struct{ In, Out uint64 }{In: 22, Out: 15}, gt.Bytes)
+}
+
+func TestPumpe_HandleHTTP_expectContinue(t *testing.T) {
+	type tcExpected struct {
+		code int
+		body string
+		read bool
+	}
+
+	tests := []testCase[bool, tcExpected]{
+		{
+			name: "rejected",
+			exp: tcExpected{
+				code: http.StatusExpectationFailed,
+			},
+		},
+
+		{
+			name:  "accepted",
+			given: true,
+			exp: tcExpected{
+				code: http.StatusOK,
+				body: "Peace is a lie.",
+				read: true,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			bodyc := make(chan string, 1)
+
+			set := &mockGateSet{
+				fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+					result := &gate.MockExitGate{
+						Doer: &gate.MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								resp := gate.NewMockResponse()
+
+								if !tc.given {
+									// Give the client time to send the body, had it not waited.
+									time.Sleep(100 * time.Millisecond)
+
+									resp.StatusCode = http.StatusExpectationFailed
+									bodyc <- ""
+
+									return resp, nil
+								}
+
+								data, err := io.ReadAll(r.Body)
+								if err != nil {
+									return nil, err
+								}
+
+								bodyc <- string(data)
+
+								return resp, nil
+							},
+						},
+					}
+
+					return result, nil
+				},
+			}
+
+			svc := NewPumpe(&gate.SetConfig{}, set)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = svc.HandleHTTP(r.Context(), w, r)
+			}))
+			defer srv.Close()
+
+			purl, err := url.Parse(srv.URL)
+			must.Equal(t, nil, err)
+
+			client := &http.Client{
+				Transport: &http.Transport{
+					Proxy:                 http.ProxyURL(purl),
+					ExpectContinueTimeout: 5 * time.Second,
+				},
+			}
+
+			read := &atomic.Bool{}
+			body := &flagReader{Reader: strings.NewReader("Peace is a lie."), read: read}
+
+			req, err := http.NewRequest(http.MethodPost, "http://httpbin.org/anything", body)
+			must.Equal(t, nil, err)
+
+			req.ContentLength = 15
+			req.Header.Set("Expect", "100-continue")
+
+			resp, err := client.Do(req)
+			must.Equal(t, nil, err)
+
+			_ = resp.Body.Close()
+
+			should.Equal(t, tc.exp.code, resp.StatusCode)
+			should.Equal(t, tc.exp.body, <-bodyc)
+			should.Equal(t, tc.exp.read, read.Load())
+		})
+	}
+}
+
+func TestPumpe_HandleHTTP_upgrade(t *testing.T) {
+	type tcGiven struct {
+		cfg     *gate.SetConfig
+		tunnels int64
+		fnRW    func() http.ResponseWriter
+	}
+
+	type tcExpected struct {
+		addr string
+		sent []string
+		msg  string
+		err  error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_hijacking_not_supported",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				fnRW: func() http.ResponseWriter {
+					return httptest.NewRecorder()
+				},
+			},
+			exp: tcExpected{
+				err: model.ErrHijackingNotSupported,
+			},
+		},
+
+		{
+			name: "error_too_many_tunnels",
+			given: tcGiven{
+				cfg:     &gate.SetConfig{MaxTunnels: 1},
+				tunnels: 1,
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ(nil)
+				},
+			},
+			exp: tcExpected{
+				msg: ErrTooManyTunnels.Error(),
+				err: ErrTooManyTunnels,
+			},
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				fnRW: func() http.ResponseWriter {
+					return fakenet.NewResponseRecorderHJ([]byte("frame from client"))
+				},
+			},
+			exp: tcExpected{
+				addr: "example.org:80",
+				sent: []string{
+					"GET /chat HTTP/1.1\r\nHost: example.org\r\n",
+					"\r\nConnection: Upgrade\r\n",
+					"\r\nUpgrade: websocket\r\n",
+					"\r\nSec-Websocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n",
+					"\r\n\r\nframe from client",
+				},
+				msg: "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\nframe from target",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			var addr string
+
+			target := &fakenet.MockConn{
+				Recv: bytes.NewReader([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\nframe from target")),
+				Send: &bytes.Buffer{},
+			}
+
+			set := &mockGateSet{
+				fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+					result := &gate.MockExitGate{
+						Dialer: &gate.MockNetDialer{
+							FnDialContext: func(ctx context.Context, network, a string) (net.Conn, error) {
+								addr = a
+
+								return target, nil
+							},
+						},
+						Doer: &gate.MockHTTPDoer{
+							FnDo: func(r *http.Request) (*http.Response, error) {
+								return nil, model.Error("unexpected_do")
+							},
+						},
+					}
+
+					return result, nil
+				},
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.org/chat", nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+			svc := NewPumpe(tc.given.cfg, set)
+			svc.tunnels.Store(tc.given.tunnels)
+
+			rw := tc.given.fnRW()
+
+			_, err := svc.HandleHTTP(context.Background(), rw, req)
+			must.Equal(t, tc.exp.err, err)
+
+			should.Equal(t, tc.given.tunnels, svc.tunnels.Load())
+			should.Equal(t, tc.exp.addr, addr)
+
+			for j := range tc.exp.sent {
+				should.Contains(t, target.Send.String(), tc.exp.sent[j])
+			}
+
+			if rwt, ok := rw.(*fakenet.ResponseRecorderHJ); ok {
+				should.Equal(t, tc.exp.msg, rwt.Body.String())
+			}
+		})
+	}
+}
+
+func TestSelectionHeader(t *testing.T) {
+	type tcGiven struct {
+		hdr      http.Header
+		rawQuery string
+	}
+
+	tests := []testCase[tcGiven, http.Header]{
+		{
+			name: "no_query",
+			given: tcGiven{
+				hdr: http.Header{"Accept": []string{"*/*"}},
+			},
+			exp: http.Header{"Accept": []string{"*/*"}},
+		},
+
+		{
+			name: "no_params",
+			given: tcGiven{
+				hdr:      http.Header{"Accept": []string{"*/*"}},
+				rawQuery: "q=1",
+			},
+			exp: http.Header{"Accept": []string{"*/*"}},
+		},
+
+		{
+			name: "header_takes_precedence",
+			given: tcGiven{
+				hdr:      http.Header{"Proxy-Pumpe-Gate-Type": []string{"tor"}},
+				rawQuery: "_pumpe_gate_id=c0c0a000-0000-4000-a000-000000000000&_pumpe_gate_type=wireguard",
+			},
+			exp: http.Header{"Proxy-Pumpe-Gate-Type": []string{"tor"}},
+		},
+
+		{
+			name: "valid_id",
+			given: tcGiven{
+				hdr:      http.Header{"Accept": []string{"*/*"}},
+				rawQuery: "q=1&_pumpe_gate_id=c0c0a000-0000-4000-a000-000000000000",
+			},
+			exp: http.Header{
+				"Accept":              []string{"*/*"},
+				"Proxy-Pumpe-Gate-Id": []string{"c0c0a000-0000-4000-a000-000000000000"},
+			},
+		},
+
+		{
+			name: "valid_type",
+			given: tcGiven{
+				hdr:      http.Header{},
+				rawQuery: "_pumpe_gate_type=direct",
+			},
+			exp: http.Header{
+				"Proxy-Pumpe-Gate-Type": []string{"direct"},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := selectionHeader(tc.given.hdr, tc.given.rawQuery)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestStripQueryParams(t *testing.T) {
+	tests := []testCase[string, string]{
+		{
+			name: "empty",
+		},
+
+		{
+			name:  "none",
+			given: "q=1&b=a%2Cb+c",
+			exp:   "q=1&b=a%2Cb+c",
+		},
+
+		{
+			name:  "only",
+			given: "_pumpe_gate_id=c0c0a000-0000-4000-a000-000000000000&_pumpe_gate_type=tor",
+		},
+
+		{
+			name:  "mixed",
+			given: "q=1&_pumpe_gate_type=tor&b=a%2Cb+c&flag",
+			exp:   "q=1&b=a%2Cb+c&flag",
+		},
+
+		{
+			name:  "escaped_name",
+			given: "%5Fpumpe_gate_type=tor&q=1",
+			exp:   "q=1",
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := stripQueryParams(tc.given, queryProxyGateID, queryProxyGateType)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestIsUpgradeRequest(t *testing.T) {
+	tests := []testCase[http.Header, bool]{
+		{
+			name:  "empty",
+			given: http.Header{},
+		},
+
+		{
+			name:  "no_upgrade",
+			given: http.Header{"Connection": []string{"Upgrade"}},
+		},
+
+		{
+			name:  "no_connection_token",
+			given: http.Header{"Connection": []string{"keep-alive"}, "Upgrade": []string{"websocket"}},
+		},
+
+		{
+			name:  "upgrade",
+			given: http.Header{"Connection": []string{"Upgrade"}, "Upgrade": []string{"websocket"}},
+			exp:   true,
+		},
+
+		{
+			name:  "upgrade_in_list",
+			given: http.Header{"Connection": []string{"keep-alive, upgrade"}, "Upgrade": []string{"websocket"}},
+			exp:   true,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, isUpgradeRequest(tc.given))
+		})
+	}
+}
+
+func TestUpgradeAddr(t *testing.T) {
+	tests := []testCase[string, string]{
+		{
+			name:  "host",
+			given: "example.org",
+			exp:   "example.org:80",
+		},
+
+		{
+			name:  "host_port",
+			given: "example.org:8080",
+			exp:   "example.org:8080",
+		},
+
+		{
+			name:  "ipv6",
+			given: "[2001:db8::1]",
+			exp:   "[2001:db8::1]:80",
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			should.Equal(t, tc.exp, upgradeAddr(tc.given))
+		})
+	}
+}
+
+func TestPumpe_pickDialer(t *testing.T) {
+	type tcGiven struct {
+		set gateSet
+		hdr http.Header
+	}
+
+	type tcExpected struct {
+		id   uuid.UUID
+		kind gate.Kind
+		err  error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_invalid_id",
+			given: tcGiven{
+				set: &mockGateSet{},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Id": []string{"something"},
+				},
+			},
+			exp: tcExpected{
+				err: model.ErrInvalidUUID,
+			},
+		},
+
+		{
+			name: "error_unsupported_id",
+			given: tcGiven{
+				set: &mockGateSetMin{},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Id": []string{"c0c0a000-0000-4000-a000-000000000000"},
+				},
+			},
+			exp: tcExpected{
+				err: ErrSelectionUnsupported,
+			},
+		},
+
+		{
+			name: "error_unsupported_index",
+			given: tcGiven{
+				set: &mockGateSetMin{},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Index": []string{"1"},
+					"Proxy-Pumpe-Gate-Type":  []string{"tor"},
+				},
+			},
+			exp: tcExpected{
+				err: ErrSelectionUnsupported,
+			},
+		},
+
+		{
+			name: "error_unsupported_kind",
+			given: tcGiven{
+				set: &mockGateSetMin{},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Type": []string{"tor"},
+				},
+			},
+			exp: tcExpected{
+				err: ErrSelectionUnsupported,
+			},
+		},
+
+		{
+			name: "error_unsupported_group",
+			given: tcGiven{
+				set: &mockGateSetMin{},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Group": []string{"fast"},
+				},
+			},
+			exp: tcExpected{
+				err: ErrSelectionUnsupported,
+			},
+		},
+
+		{
+			name: "error_group_not_found",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnByGroup: func(ctx context.Context, name string) (gate.ExitGate, error) {
+						return nil, gate.ErrGroupNotFound
+					},
+				},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Group": []string{"slow"},
+				},
+			},
+			exp: tcExpected{
+				err: gate.ErrGroupNotFound,
+			},
+		},
+
+		{
+			name: "valid_group",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnByGroup: func(ctx context.Context, name string) (gate.ExitGate, error) {
+						if name != "fast" {
+							return nil, model.Error("unexpected_name")
+						}
+
+						result := &gate.MockExitGate{
+							FnID:   func() uuid.UUID { return uuid.MustParse("decade00-0000-4000-a000-000000000000") },
+							FnKind: func() gate.Kind { return gate.KindWireGuard },
+						}
+
+						return result, nil
+					},
+
+					fnByKind: func(ctx context.Context, kind gate.Kind) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_by_kind")
+					},
+				},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Group": []string{"fast"},
+					"Proxy-Pumpe-Gate-Type":  []string{"tor"},
+				},
+			},
+			exp: tcExpected{
+				id:   uuid.MustParse("decade00-0000-4000-a000-000000000000"),
+				kind: gate.KindWireGuard,
+			},
+		},
+
+		{
+			name: "error_unsupported_random",
+			given: tcGiven{
+				set: &mockGateSetMin{},
+				hdr: make(http.Header),
+			},
+			exp: tcExpected{
+				err: ErrSelectionUnsupported,
+			},
+		},
+
+		{
+			name: "valid_id",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnByID: func(id uuid.UUID) (gate.ExitGate, error) {
+						if id != uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") {
+							return nil, model.Error("unexpected_id")
+						}
+
+						result := &gate.MockExitGate{
+							FnID:   func() uuid.UUID { return uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") },
+							FnKind: func() gate.Kind { return gate.KindTor },
+						}
+
+						return result, nil
+					},
+				},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Id": []string{"c0c0a000-0000-4000-a000-000000000000"},
+				},
+			},
+			exp: tcExpected{
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				kind: gate.KindTor,
+			},
+		},
+
+		{
+			name: "error_id_not_ready",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnByID: func(id uuid.UUID) (gate.ExitGate, error) {
+						return nil, gate.ErrGateNotReady
+					},
+
+					fnByIDWait: func(ctx context.Context, id uuid.UUID) (gate.ExitGate, error) {
+						panic("unexpected_wait")
+					},
+				},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Id": []string{"c0c0a000-0000-4000-a000-000000000000"},
+					"Proxy-Pumpe-Wait":    []string{"false"},
+				},
+			},
+			exp: tcExpected{
+				err: gate.ErrGateNotReady,
+			},
+		},
+
+		{
+			name: "valid_id_wait",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnByID: func(id uuid.UUID) (gate.ExitGate, error) {
+						panic("unexpected_by_id")
+					},
+
+					fnByIDWait: func(ctx context.Context, id uuid.UUID) (gate.ExitGate, error) {
+						if id != uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") {
+							return nil, model.Error("unexpected_id")
+						}
+
+						result := &gate.MockExitGate{
+							FnID:   func() uuid.UUID { return uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") },
+							FnKind: func() gate.Kind { return gate.KindTor },
+						}
+
+						return result, nil
+					},
+				},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Id": []string{"c0c0a000-0000-4000-a000-000000000000"},
+					"Proxy-Pumpe-Wait":    []string{"true"},
+				},
+			},
+			exp: tcExpected{
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				kind: gate.KindTor,
+			},
+		},
+
+		{
+			name: "error_invalid_index",
+			given: tcGiven{
+				set: &mockGateSet{},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Index": []string{"second"},
+					"Proxy-Pumpe-Gate-Type":  []string{"tor"},
+				},
+			},
+			exp: tcExpected{
+				err: ErrInvalidGateIndex,
+			},
+		},
+
+		{
+			name: "error_index_no_type",
+			given: tcGiven{
+				set: &mockGateSet{},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Index": []string{"1"},
+				},
+			},
+			exp: tcExpected{
+				err: ErrGateIndexNoType,
+			},
+		},
+
+		{
+			name: "error_index_out_of_range",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnByIndex: func(kind gate.Kind, i int) (gate.ExitGate, error) {
+						return nil, gate.ErrGateNotFound
+					},
+				},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Index": []string{"4"},
+					"Proxy-Pumpe-Gate-Type":  []string{"tor"},
+				},
+			},
+			exp: tcExpected{
+				err: gate.ErrGateNotFound,
+			},
+		},
+
+		{
+			name: "valid_index",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnByIndex: func(kind gate.Kind, i int) (gate.ExitGate, error) {
+						if kind != gate.KindTor || i != 1 {
+							return nil, model.Error("unexpected_index")
+						}
+
+						result := &gate.MockExitGate{
+							FnID:   func() uuid.UUID { return uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") },
+							FnKind: func() gate.Kind { return gate.KindTor },
+						}
+
+						return result, nil
+					},
+				},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Index": []string{"1"},
+					"Proxy-Pumpe-Gate-Type":  []string{"tor"},
+				},
+			},
+			exp: tcExpected{
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				kind: gate.KindTor,
+			},
+		},
+
+		{
+			name: "valid_type",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnByKind: func(ctx context.Context, kind gate.Kind) (gate.ExitGate, error) {
+						if kind != gate.KindTor {
+							return nil, model.Error("unexpected_kind")
+						}
+
+						result := &gate.MockExitGate{
+							FnID:   func() uuid.UUID { return uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") },
+							FnKind: func() gate.Kind { return gate.KindTor },
+						}
+
+						return result, nil
+					},
+				},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Type": []string{"tor"},
+				},
+			},
+			exp: tcExpected{
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				kind: gate.KindTor,
+			},
+		},
+
+		{
+			name: "both_id_type_id_wins",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnByID: func(id uuid.UUID) (gate.ExitGate, error) {
+						if id != uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") {
+							return nil, model.Error("unexpected_id")
+						}
+
+						result := &gate.MockExitGate{
+							FnID:   func() uuid.UUID { return uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") },
+							FnKind: func() gate.Kind { return gate.KindTor },
+						}
+
+						return result, nil
+					},
+
+					fnByKind: func(ctx context.Context, kind gate.Kind) (gate.ExitGate, error) {
+						return nil, model.Error("unexpected_by_kind")
+					},
+				},
+				hdr: http.Header{
+					"Proxy-Pumpe-Gate-Id":   []string{"c0c0a000-0000-4000-a000-000000000000"},
+					"Proxy-Pumpe-Gate-Type": []string{"tor"},
+				},
+			},
+			exp: tcExpected{
+				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
+				kind: gate.KindTor,
+			},
+		},
+
+		{
+			name: "valid_random",
+			given: tcGiven{
+				set: &mockGateSet{
+					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
+						result := &gate.MockExitGate{
+							FnID:   func() uuid.UUID { return uuid.MustParse("ad0be000-0000-4000-a000-000000000000") },
+							FnKind: func() gate.Kind { return gate.KindWireGuard },
+						}
+
+						return result, nil
+					},
+				},
+				hdr: make(http.Header),
+			},
+			exp: tcExpected{
+				id:   uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
+				kind: gate.KindWireGuard,
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tests[i].name, func(t *testing.T) {
+			svc := NewPumpe(&gate.SetConfig{}, tc.given.set)
+
+			ctx := context.Background()
+
+			actual, err := svc.pickDialer(ctx, tc.given.hdr)
+			must.Equal(t, tc.exp.err, err)
+
+			if tc.exp.err != nil {
+				return
+			}
+
+			should.Equal(t, tc.exp.id, actual.ID())
+			should.Equal(t, tc.exp.kind, actual.Kind())
+		})
+	}
+}
+
+func TestPumpe_dial(t *testing.T) {
+	type tcGiven struct {
+		tout   time.Duration
+		fnCtx  func() (context.Context, context.CancelFunc)
+		fnDial func(ctx context.Context, network, addr string) (net.Conn, error)
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "error_no_timeout",
+			given: tcGiven{
+				fnCtx: func() (context.Context, context.CancelFunc) {
+					return context.WithCancel(context.Background())
+				},
+				fnDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					if _, ok := ctx.Deadline(); ok {
+						return nil, model.Error("unexpected_deadline")
+					}
+
+					return nil, model.Error("something_went_wrong")
+				},
+			},
+			exp: model.Error("something_went_wrong"),
+		},
+
+		{
+			name: "error_timeout",
+			given: tcGiven{
+				tout: time.Millisecond,
+				fnCtx: func() (context.Context, context.CancelFunc) {
+					return context.WithCancel(context.Background())
+				},
+				fnDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					<-ctx.Done()
+
+					return nil, ctx.Err()
+				},
+			},
+			exp: ErrDialTimeout,
+		},
+
+		{
+			name: "error_ctx_done",
+			given: tcGiven{
+				tout: time.Minute,
+				fnCtx: func() (context.Context, context.CancelFunc) {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx, cancel
+				},
+				fnDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					<-ctx.Done()
+
+					return nil, ctx.Err()
+				},
+			},
+			exp: context.Canceled,
+		},
+
+		{
+			name: "valid",
+			given: tcGiven{
+				tout: time.Minute,
+				fnCtx: func() (context.Context, context.CancelFunc) {
+					return context.WithCancel(context.Background())
+				},
+				fnDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return &fakenet.MockConn{}, nil
+				},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewPumpe(&gate.SetConfig{DialTimeout: tc.given.tout}, &mockGateSet{})
+
+			gt := &gate.MockExitGate{
+				Dialer: &gate.MockNetDialer{FnDialContext: tc.given.fnDial},
+			}
+
+			ctx, cancel := tc.given.fnCtx()
+			defer cancel()
+
+			_, err := svc.dial(ctx, gt, "httpbin.org:443")
+			must.Equal(t, tc.exp, err)
+		})
+	}
+}
+
+func TestPumpe_retries(t *testing.T) {
+	type tcGiven struct {
+		cfg *gate.SetConfig
+		hdr http.Header
+	}
+
+	type tcExpected struct {
+		val int
+		err error
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "error_not_number",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				hdr: http.Header{"Proxy-Pumpe-Retries": []string{"many"}},
+			},
+			exp: tcExpected{
+				err: ErrInvalidRetries,
+			},
+		},
+
+		{
+			name: "error_negative",
+			given: tcGiven{
+				cfg: &gate.SetConfig{},
+				hdr: http.Header{"Proxy-Pumpe-Retries": []string{"-1"}},
+			},
+			exp: tcExpected{
+				err: ErrInvalidRetries,
+			},
+		},
+
+		{
+			name: "default",
+			given: tcGiven{
+				cfg: &gate.SetConfig{MaxDialRetries: 2},
+				hdr: make(http.Header),
+			},
+			exp: tcExpected{
+				val: 2,
+			},
+		},
+
+		{
+			name: "header_overrides",
+			given: tcGiven{
+				cfg: &gate.SetConfig{MaxDialRetries: 2},
+				hdr: http.Header{"Proxy-Pumpe-Retries": []string{"0"}},
 			},
 			exp: tcExpected{
-				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
-				kind: gate.KindTor,
+				val: 0,
 			},
 		},
 
 		{
-			name: "valid_type",
+			name: "header_clamped",
 			given: tcGiven{
-				set: &mockGateSet{
-					fnByKind: func(ctx context.Context, kind gate.Kind) (gate.ExitGate, error) {
-						if kind != gate.KindTor {
-							return nil, model.Error("unexpected_kind")
-						}
-
-						result := &gate.MockExitGate{
-							FnID:   func() uuid.UUID { return uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") },
-							FnKind: func() gate.Kind { return gate.KindTor },
-						}
+				cfg: &gate.SetConfig{},
+				hdr: http.Header{"Proxy-Pumpe-Retries": []string{"100"}},
+			},
+			exp: tcExpected{
+				val: 5,
+			},
+		},
 
-						return result, nil
-					},
-				},
+		{
+			name: "pinned_gate",
+			given: tcGiven{
+				cfg: &gate.SetConfig{MaxDialRetries: 2},
 				hdr: http.Header{
-					"Proxy-Pumpe-Gate-Type": []string{"tor"},
+					"Proxy-Pumpe-Gate-Id": []string{"c0c0a000-0000-4000-a000-000000000000"},
+					"Proxy-Pumpe-Retries": []string{"3"},
 				},
 			},
 			exp: tcExpected{
-				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
-				kind: gate.KindTor,
+				val: 0,
 			},
 		},
 
 		{
-			name: "both_id_type_id_wins",
+			name: "pinned_gate_index",
 			given: tcGiven{
-				set: &mockGateSet{
-					fnByID: func(id uuid.UUID) (gate.ExitGate, error) {
-						if id != uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") {
-							return nil, model.Error("unexpected_id")
-						}
-
-						result := &gate.MockExitGate{
-							FnID:   func() uuid.UUID { return uuid.MustParse("c0c0a000-0000-4000-a000-000000000000") },
-							FnKind: func() gate.Kind { return gate.KindTor },
-						}
-
-						return result, nil
-					},
-
-					fnByKind: func(ctx context.Context, kind gate.Kind) (gate.ExitGate, error) {
-						return nil, model.Error("unexpected_by_kind")
-					},
-				},
+				cfg: &gate.SetConfig{MaxDialRetries: 2},
 				hdr: http.Header{
-					"Proxy-Pumpe-Gate-Id":   []string{"c0c0a000-0000-4000-a000-000000000000"},
-					"Proxy-Pumpe-Gate-Type": []string{"tor"},
+					"Proxy-Pumpe-Gate-Index": []string{"1"},
+					"Proxy-Pumpe-Gate-Type":  []string{"tor"},
 				},
 			},
 			exp: tcExpected{
-				id:   uuid.MustParse("c0c0a000-0000-4000-a000-000000000000"),
-				kind: gate.KindTor,
+				val: 0,
 			},
 		},
 
 		{
-			name: "valid_random",
+			name: "group",
 			given: tcGiven{
-				set: &mockGateSet{
-					fnRandom: func(ctx context.Context) (gate.ExitGate, error) {
-						result := &gate.MockExitGate{
-							FnID:   func() uuid.UUID { return uuid.MustParse("ad0be000-0000-4000-a000-000000000000") },
-							FnKind: func() gate.Kind { return gate.KindWireGuard },
-						}
-
-						return result, nil
-					},
-				},
-				hdr: make(http.Header),
+				cfg: &gate.SetConfig{MaxDialRetries: 2},
+				hdr: http.Header{"Proxy-Pumpe-Gate-Group": []string{"fast"}},
 			},
 			exp: tcExpected{
-				id:   uuid.MustParse("ad0be000-0000-4000-a000-000000000000"),
-				kind: gate.KindWireGuard,
+				val: 0,
 			},
 		},
 	}
@@ -479,20 +2264,108 @@ func TestPumpe_pickDialer(t *testing.T) {
 	for i := range tests {
 		tc := tests[i]
 
-		t.Run(tests[i].name, func(t *testing.T) {
-			svc := NewPumpe(tc.given.set)
-
-			ctx := context.Background()
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewPumpe(tc.given.cfg, &mockGateSet{})
 
-			actual, err := svc.pickDialer(ctx, tc.given.hdr)
+			actual, err := svc.retries(tc.given.hdr)
 			must.Equal(t, tc.exp.err, err)
 
 			if tc.exp.err != nil {
 				return
 			}
 
-			should.Equal(t, tc.exp.id, actual.ID())
-			should.Equal(t, tc.exp.kind, actual.Kind())
+			should.Equal(t, tc.exp.val, actual)
+		})
+	}
+}
+
+func TestPumpe_checkDest(t *testing.T) {
+	type tcGiven struct {
+		cfg  *gate.SetConfig
+		addr string
+	}
+
+	tests := []testCase[tcGiven, error]{
+		{
+			name: "disabled",
+			given: tcGiven{
+				cfg:  &gate.SetConfig{},
+				addr: "127.0.0.1:443",
+			},
+		},
+
+		{
+			name: "public_ip",
+			given: tcGiven{
+				cfg:  &gate.SetConfig{BlockPrivateDests: true},
+				addr: "93.184.216.34:443",
+			},
+		},
+
+		{
+			name: "loopback",
+			given: tcGiven{
+				cfg:  &gate.SetConfig{BlockPrivateDests: true},
+				addr: "127.0.0.1:443",
+			},
+			exp: ErrDestNotAllowed,
+		},
+
+		{
+			name: "loopback_ipv6_no_port",
+			given: tcGiven{
+				cfg:  &gate.SetConfig{BlockPrivateDests: true},
+				addr: "[::1]",
+			},
+			exp: ErrDestNotAllowed,
+		},
+
+		{
+			name: "private",
+			given: tcGiven{
+				cfg:  &gate.SetConfig{BlockPrivateDests: true},
+				addr: "10.1.2.3:80",
+			},
+			exp: ErrDestNotAllowed,
+		},
+
+		{
+			name: "link_local",
+			given: tcGiven{
+				cfg:  &gate.SetConfig{BlockPrivateDests: true},
+				addr: "169.254.169.254",
+			},
+			exp: ErrDestNotAllowed,
+		},
+
+		{
+			name: "private_allowed",
+			given: tcGiven{
+				cfg: &gate.SetConfig{
+					BlockPrivateDests:   true,
+					AllowedPrivateDests: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+				},
+				addr: "10.1.2.3:80",
+			},
+		},
+
+		{
+			name: "host_not_resolved",
+			given: tcGiven{
+				cfg:  &gate.SetConfig{BlockPrivateDests: true},
+				addr: "internal.example.com:443",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewPumpe(tc.given.cfg, &mockGateSet{})
+
+			actual := svc.checkDest(tc.given.addr)
+			should.Equal(t, tc.exp, actual)
 		})
 	}
 }
@@ -513,6 +2386,11 @@ func TestNewHopHeaders(t *testing.T) {
 				"Upgrade",
 				"Proxy-Pumpe-Gate-Id",
 				"Proxy-Pumpe-Gate-Type",
+				"Proxy-Pumpe-Retries",
+				"Proxy-Pumpe-Wait",
+				"Proxy-Pumpe-No-Forward",
+				"Proxy-Pumpe-Gate-Index",
+				"Proxy-Pumpe-Gate-Group",
 			},
 		},
 	}
@@ -558,56 +2436,295 @@ func TestDelHeaders(t *testing.T) {
 					"Found_03": []string{"Found_03_01", "Found_03_02"},
 				},
 			},
-			exp: http.Header{
-				"Hdr_01": []string{"Val_01"},
+			exp: http.Header{
+				"Hdr_01": []string{"Val_01"},
+			},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			delHeaders(tests[i].given.list, tests[i].given.hdr)
+
+			should.Equal(t, tests[i].exp, tests[i].given.hdr)
+		})
+	}
+}
+
+func TestDelConnectionHeaders(t *testing.T) {
+	tests := []testCase[http.Header, http.Header]{
+		{
+			name:  "empty",
+			given: http.Header{},
+			exp:   http.Header{},
+		},
+
+		{
+			name:  "connection_header_close",
+			given: http.Header{"Connection": []string{"close"}},
+			exp:   http.Header{"Connection": []string{"close"}},
+		},
+
+		{
+			name:  "connection_header_keep_alive",
+			given: http.Header{"Connection": []string{"keep-alive"}},
+			exp:   http.Header{"Connection": []string{"keep-alive"}},
+		},
+
+		{
+			name: "connection_header_delete",
+			given: http.Header{
+				"Connection": []string{"keep-alive, Keep-Alive"},
+				"Keep-Alive": []string{"100"},
+			},
+			exp: http.Header{"Connection": []string{"keep-alive, Keep-Alive"}},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			delConnectionHeaders(tests[i].given)
+
+			should.Equal(t, tests[i].exp, tests[i].given)
+		})
+	}
+}
+
+func TestExceedsHeaderLimits(t *testing.T) {
+	type tcGiven struct {
+		hdr      http.Header
+		maxN     int
+		maxBytes int
+	}
+
+	tests := []testCase[tcGiven, bool]{
+		{
+			name: "no_limits",
+			given: tcGiven{
+				hdr: http.Header{"X-Custom": []string{"a", "b", "c"}},
+			},
+		},
+
+		{
+			name: "within_limits",
+			given: tcGiven{
+				hdr:      http.Header{"X-Custom": []string{"a", "b"}},
+				maxN:     2,
+				maxBytes: 18,
+			},
+		},
+
+		{
+			name: "too_many",
+			given: tcGiven{
+				hdr:  http.Header{"X-Custom": []string{"a", "b"}, "Accept": []string{"*/*"}},
+				maxN: 2,
+			},
+			exp: true,
+		},
+
+		{
+			name: "too_large",
+			given: tcGiven{
+				hdr:      http.Header{"X-Custom": []string{"a", "b"}},
+				maxBytes: 17,
+			},
+			exp: true,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := exceedsHeaderLimits(tc.given.hdr, tc.given.maxN, tc.given.maxBytes)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	tests := []testCase[http.Header, bool]{
+		{
+			name:  "empty",
+			given: make(http.Header),
+		},
+
+		{
+			name:  "accept_html",
+			given: http.Header{"Accept": []string{"text/html, */*;q=0.8"}},
+		},
+
+		{
+			name:  "accept_json",
+			given: http.Header{"Accept": []string{"application/json"}},
+			exp:   true,
+		},
+
+		{
+			name:  "accept_json_in_list",
+			given: http.Header{"Accept": []string{"text/html", "application/json;q=0.9"}},
+			exp:   true,
+		},
+
+		{
+			name:  "accept_json_suffix",
+			given: http.Header{"Accept": []string{"application/problem+json"}},
+			exp:   true,
+		},
+
+		{
+			name:  "content_type_json",
+			given: http.Header{"Content-Type": []string{"Application/JSON; charset=utf-8"}},
+			exp:   true,
+		},
+
+		{
+			name:  "content_type_form",
+			given: http.Header{"Content-Type": []string{"application/x-www-form-urlencoded"}},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			should.Equal(t, tests[i].exp, wantsJSON(tests[i].given))
+		})
+	}
+}
+
+func TestSetUserAgent(t *testing.T) {
+	type tcGiven struct {
+		hdr   http.Header
+		strip bool
+		ua    string
+	}
+
+	tests := []testCase[tcGiven, http.Header]{
+		{
+			name: "untouched",
+			given: tcGiven{
+				hdr: http.Header{"User-Agent": []string{"curl/8.0"}},
+			},
+			exp: http.Header{"User-Agent": []string{"curl/8.0"}},
+		},
+
+		{
+			name: "strip",
+			given: tcGiven{
+				hdr:   http.Header{"User-Agent": []string{"curl/8.0"}},
+				strip: true,
+			},
+			exp: http.Header{"User-Agent": []string{""}},
+		},
+
+		{
+			name: "replace",
+			given: tcGiven{
+				hdr: http.Header{"User-Agent": []string{"curl/8.0"}},
+				ua:  "Mozilla/5.0",
+			},
+			exp: http.Header{"User-Agent": []string{"Mozilla/5.0"}},
+		},
+
+		{
+			name: "replace_wins_over_strip",
+			given: tcGiven{
+				hdr:   make(http.Header),
+				strip: true,
+				ua:    "Mozilla/5.0",
 			},
+			exp: http.Header{"User-Agent": []string{"Mozilla/5.0"}},
 		},
 	}
 
 	for i := range tests {
 		t.Run(tests[i].name, func(t *testing.T) {
-			delHeaders(tests[i].given.list, tests[i].given.hdr)
+			setUserAgent(tests[i].given.hdr, tests[i].given.strip, tests[i].given.ua)
 
 			should.Equal(t, tests[i].exp, tests[i].given.hdr)
 		})
 	}
 }
 
-func TestDelConnectionHeaders(t *testing.T) {
-	tests := []testCase[http.Header, http.Header]{
+func TestDelForwardedHeaders(t *testing.T) {
+	hdr := http.Header{
+		"Forwarded":        []string{"for=192.0.2.1"},
+		"Via":              []string{"1.1 proxy"},
+		"X-Forwarded-For":  []string{"192.0.2.1"},
+		"X-Forwarded-Host": []string{"example.com"},
+		"X-Real-Ip":        []string{"192.0.2.1"},
+		"X-Custom":         []string{"kept"},
+	}
+
+	delForwardedHeaders(hdr)
+
+	should.Equal(t, http.Header{"X-Custom": []string{"kept"}}, hdr)
+}
+
+func TestSetXForwardedFor(t *testing.T) {
+	type tcGiven struct {
+		hdr  http.Header
+		mode gate.XFFMode
+		addr string
+	}
+
+	tests := []testCase[tcGiven, http.Header]{
 		{
-			name:  "empty",
-			given: http.Header{},
-			exp:   http.Header{},
+			name: "append",
+			given: tcGiven{
+				hdr:  http.Header{"X-Forwarded-For": []string{"127.0.0.1"}},
+				mode: gate.XFFModeAppend,
+				addr: "127.0.0.2:54321",
+			},
+			exp: http.Header{"X-Forwarded-For": []string{"127.0.0.1, 127.0.0.2"}},
 		},
 
 		{
-			name:  "connection_header_close",
-			given: http.Header{"Connection": []string{"close"}},
-			exp:   http.Header{"Connection": []string{"close"}},
+			name: "append_invalid_addr",
+			given: tcGiven{
+				hdr:  http.Header{"X-Forwarded-For": []string{"127.0.0.1"}},
+				mode: gate.XFFModeAppend,
+				addr: "127.0.0.2",
+			},
+			exp: http.Header{"X-Forwarded-For": []string{"127.0.0.1"}},
 		},
 
 		{
-			name:  "connection_header_keep_alive",
-			given: http.Header{"Connection": []string{"keep-alive"}},
-			exp:   http.Header{"Connection": []string{"keep-alive"}},
+			name: "remove",
+			given: tcGiven{
+				hdr:  http.Header{"X-Forwarded-For": []string{"127.0.0.1"}},
+				mode: gate.XFFModeRemove,
+				addr: "127.0.0.2:54321",
+			},
+			exp: http.Header{},
 		},
 
 		{
-			name: "connection_header_delete",
-			given: http.Header{
-				"Connection": []string{"keep-alive, Keep-Alive"},
-				"Keep-Alive": []string{"100"},
+			name: "replace",
+			given: tcGiven{
+				hdr:  http.Header{"X-Forwarded-For": []string{"127.0.0.1"}},
+				mode: gate.XFFModeReplace,
+				addr: "127.0.0.2:54321",
 			},
-			exp: http.Header{"Connection": []string{"keep-alive, Keep-Alive"}},
+			exp: http.Header{"X-Forwarded-For": []string{"127.0.0.2"}},
+		},
+
+		{
+			name: "replace_invalid_addr",
+			given: tcGiven{
+				hdr:  http.Header{"X-Forwarded-For": []string{"127.0.0.1"}},
+				mode: gate.XFFModeReplace,
+				addr: "127.0.0.2",
+			},
+			exp: http.Header{},
 		},
 	}
 
 	for i := range tests {
 		t.Run(tests[i].name, func(t *testing.T) {
-			delConnectionHeaders(tests[i].given)
+			setXForwardedFor(tests[i].given.hdr, tests[i].given.mode, tests[i].given.addr)
 
-			should.Equal(t, tests[i].exp, tests[i].given)
+			should.Equal(t, tests[i].exp, tests[i].given.hdr)
 		})
 	}
 }
@@ -740,6 +2857,30 @@ func TestRemoteAddrFromHost(t *testing.T) {
 			given: "example.com:443",
 			exp:   "example.com:443",
 		},
+
+		{
+			name:  "ipv4_no_port",
+			given: "192.0.2.1",
+			exp:   "192.0.2.1:443",
+		},
+
+		{
+			name:  "ipv6_bracketed_no_port",
+			given: "[::1]",
+			exp:   "[::1]:443",
+		},
+
+		{
+			name:  "ipv6_bracketed_with_port",
+			given: "[::1]:8443",
+			exp:   "[::1]:8443",
+		},
+
+		{
+			name:  "ipv6_bare",
+			given: "2001:db8::1",
+			exp:   "[2001:db8::1]:443",
+		},
 	}
 
 	for i := range tests {
@@ -749,6 +2890,42 @@ func TestRemoteAddrFromHost(t *testing.T) {
 	}
 }
 
+func TestPortFromAddr(t *testing.T) {
+	type tcExpected struct {
+		val int
+		err bool
+	}
+
+	tests := []testCase[string, tcExpected]{
+		{
+			name:  "valid",
+			given: "example.com:443",
+			exp:   tcExpected{val: 443},
+		},
+
+		{
+			name:  "error_no_port",
+			given: "example.com",
+			exp:   tcExpected{err: true},
+		},
+
+		{
+			name:  "error_not_number",
+			given: "example.com:https",
+			exp:   tcExpected{err: true},
+		},
+	}
+
+	for i := range tests {
+		t.Run(tests[i].name, func(t *testing.T) {
+			actual, err := portFromAddr(tests[i].given)
+			must.Equal(t, tests[i].exp.err, err != nil)
+
+			should.Equal(t, tests[i].exp.val, actual)
+		})
+	}
+}
+
 func TestXferData(t *testing.T) {
 	type tcGiven struct {
 		dst io.Writer
@@ -803,7 +2980,8 @@ func TestXferData(t *testing.T) {
 
 	for i := range tests {
 		t.Run(tests[i].name, func(t *testing.T) {
-			xferData(tests[i].given.dst, tests[i].given.src)
+			n := xferData(tests[i].given.dst, tests[i].given.src)
+			should.Equal(t, int64(len(tests[i].exp)), n)
 
 			bts, ok := tests[i].given.dst.(interface{ Bytes() []byte })
 			if !ok {
@@ -815,6 +2993,178 @@ func TestXferData(t *testing.T) {
 	}
 }
 
+func TestPumpe_tunnel(t *testing.T) {
+	t.Run("half_closed_idle", func(t *testing.T) {
+		client, src := net.Pipe()
+		dst, target := net.Pipe()
+		defer func() { _ = target.Close() }()
+
+		// The client sends a request and leaves, while the target never responds nor closes.
+		go func() {
+			defer func() { _ = client.Close() }()
+
+			_, _ = client.Write([]byte("Peace is a lie."))
+		}()
+
+		go func() { _, _ = io.ReadAll(target) }()
+
+		svc := NewPumpe(&gate.SetConfig{HalfCloseTimeout: 20 * time.Millisecond}, &mockGateSet{})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			svc.tunnel(context.Background(), &gate.MockExitGate{}, src, dst)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("tunnel was not torn down")
+		}
+	})
+
+	t.Run("half_closed_active", func(t *testing.T) {
+		client, src := newTCPPair(t)
+		dst, target := newTCPPair(t)
+
+		go func() {
+			_, _ = client.Write([]byte("Peace is a lie."))
+			_ = client.CloseWrite()
+		}()
+
+		// The target keeps responding for longer than the timeout, with pauses shorter than it.
+		go func() {
+			defer func() { _ = target.Close() }()
+
+			_, _ = io.ReadAll(target)
+
+			for i := 0; i < 8; i++ {
+				time.Sleep(20 * time.Millisecond)
+
+				_, _ = target.Write([]byte("There is only passion."))
+			}
+		}()
+
+		recvc := make(chan []byte, 1)
+		go func() {
+			data, _ := io.ReadAll(client)
+			recvc <- data
+		}()
+
+		gt := &gate.MockExitGate{}
+
+		svc := NewPumpe(&gate.SetConfig{HalfCloseTimeout: 100 * time.Millisecond}, &mockGateSet{})
+		svc.tunnel(context.Background(), gt, src, dst)
+
+		_ = src.Close()
+		_ = dst.Close()
+
+		should.Equal(t, []byte(strings.Repeat("There is only passion.", 8)), <-recvc)
+		should.Equal(t, struct{ In, Out uint64 }{In: 8 * 22, Out: 15}, gt.Bytes)
+	})
+}
+
+func TestIdleConn(t *testing.T) {
+	t.Run("unarmed", func(t *testing.T) {
+		conn, peer := net.Pipe()
+		defer func() { _ = conn.Close() }()
+
+		go func() {
+			defer func() { _ = peer.Close() }()
+
+			time.Sleep(20 * time.Millisecond)
+			_, _ = peer.Write([]byte("Through passion I gain strength."))
+		}()
+
+		ic := newIdleConn(context.Background(), conn)
+		ic.arm(0)
+
+		actual, err := io.ReadAll(ic)
+		must.Equal(t, nil, err)
+
+		should.Equal(t, []byte("Through passion I gain strength."), actual)
+	})
+
+	t.Run("armed_idle", func(t *testing.T) {
+		conn, peer := net.Pipe()
+		defer func() { _ = conn.Close() }()
+		defer func() { _ = peer.Close() }()
+
+		ic := newIdleConn(context.Background(), conn)
+		ic.arm(10 * time.Millisecond)
+
+		_, err := ic.Read(make([]byte, 1))
+		should.Equal(t, true, errors.Is(err, os.ErrDeadlineExceeded))
+	})
+
+	t.Run("armed_cancelled", func(t *testing.T) {
+		conn, peer := net.Pipe()
+		defer func() { _ = conn.Close() }()
+		defer func() { _ = peer.Close() }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		ic := newIdleConn(ctx, conn)
+		ic.arm(time.Hour)
+
+		_, err := ic.Read(make([]byte, 1))
+		should.Equal(t, true, errors.Is(err, os.ErrDeadlineExceeded))
+	})
+}
+
+func TestXferDataCtx(t *testing.T) {
+	t.Run("cancelled", func(t *testing.T) {
+		src, peer := net.Pipe()
+		defer func() { _ = src.Close() }()
+		defer func() { _ = peer.Close() }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			xferDataCtx(ctx, &bytes.Buffer{}, src)
+		}()
+
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("copy was not cancelled")
+		}
+	})
+
+	t.Run("valid_no_deadline", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dst := &bytes.Buffer{}
+		xferDataCtx(ctx, dst, bytes.NewBufferString("Through victory my chains are broken."))
+
+		should.Equal(t, []byte("Through victory my chains are broken."), dst.Bytes())
+	})
+
+	t.Run("valid_not_cancelled", func(t *testing.T) {
+		src, peer := net.Pipe()
+		defer func() { _ = src.Close() }()
+
+		go func() {
+			defer func() { _ = peer.Close() }()
+
+			_, _ = peer.Write([]byte("The Force shall free me."))
+		}()
+
+		dst := &bytes.Buffer{}
+		xferDataCtx(context.Background(), dst, src)
+
+		should.Equal(t, []byte("The Force shall free me."), dst.Bytes())
+	})
+}
+
 func TestWriteErrToConn(t *testing.T) {
 	type tcGiven struct {
 		rw interface {
@@ -848,6 +3198,50 @@ func TestWriteErrToConn(t *testing.T) {
 			},
 		},
 
+		{
+			name: "valid_dial_timeout",
+			given: tcGiven{
+				rw:   &strings.Builder{},
+				rerr: ErrDialTimeout,
+			},
+			exp: tcExpected{
+				text: "HTTP/1.1 504 Gateway Timeout\r\nContent-Type: text/plain\r\nContent-Length: 23\r\n\r\nservice: dial timed out",
+			},
+		},
+
+		{
+			name: "valid_deadline_exceeded",
+			given: tcGiven{
+				rw:   &strings.Builder{},
+				rerr: context.DeadlineExceeded,
+			},
+			exp: tcExpected{
+				text: "HTTP/1.1 504 Gateway Timeout\r\nContent-Type: text/plain\r\nContent-Length: 25\r\n\r\ncontext deadline exceeded",
+			},
+		},
+
+		{
+			name: "valid_net_timeout",
+			given: tcGiven{
+				rw:   &strings.Builder{},
+				rerr: &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded},
+			},
+			exp: tcExpected{
+				text: "HTTP/1.1 504 Gateway Timeout\r\nContent-Type: text/plain\r\nContent-Length: 21\r\n\r\ndial tcp: i/o timeout",
+			},
+		},
+
+		{
+			name: "valid_net_other",
+			given: tcGiven{
+				rw:   &strings.Builder{},
+				rerr: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			},
+			exp: tcExpected{
+				text: "HTTP/1.1 502 Bad Gateway\r\nContent-Type: text/plain\r\nContent-Length: 28\r\n\r\ndial tcp: connection refused",
+			},
+		},
+
 		{
 			name: "error",
 			given: tcGiven{
@@ -877,3 +3271,147 @@ func TestWriteErrToConn(t *testing.T) {
 		})
 	}
 }
+
+func TestIsGateFault(t *testing.T) {
+	type tcGiven struct {
+		ctx func() context.Context
+		err error
+	}
+
+	tests := []testCase[tcGiven, bool]{
+		{
+			name: "client_gone",
+			given: tcGiven{
+				ctx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					return ctx
+				},
+				err: model.Error("something_went_wrong"),
+			},
+		},
+
+		{
+			name: "canceled",
+			given: tcGiven{
+				err: fmt.Errorf("dial: %w", context.Canceled),
+			},
+		},
+
+		{
+			name: "dest_not_allowed",
+			given: tcGiven{
+				err: &net.OpError{Op: "dial", Net: "tcp", Err: ErrDestNotAllowed},
+			},
+		},
+
+		{
+			name: "wg_dest_not_allowed",
+			given: tcGiven{
+				err: fmt.Errorf("%w: %s", gate.ErrWGDestNotAllowed, "192.168.0.1"),
+			},
+		},
+
+		{
+			name: "connection_refused",
+			given: tcGiven{
+				err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			},
+		},
+
+		{
+			name: "host_not_found",
+			given: tcGiven{
+				err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}},
+			},
+		},
+
+		{
+			name: "tls_bad_certificate",
+			given: tcGiven{
+				err: &url.Error{Op: "Get", URL: "https://example.com", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}},
+			},
+		},
+
+		{
+			name: "dial_timeout",
+			given: tcGiven{
+				err: ErrDialTimeout,
+			},
+			exp: true,
+		},
+
+		{
+			name: "dns_timeout",
+			given: tcGiven{
+				err: &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true},
+			},
+			exp: true,
+		},
+
+		{
+			name: "other",
+			given: tcGiven{
+				err: model.Error("something_went_wrong"),
+			},
+			exp: true,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.given.ctx != nil {
+				ctx = tc.given.ctx()
+			}
+
+			actual := isGateFault(ctx, tc.given.err)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}
+
+// newTCPPair returns both ends of a loopback TCP connection, closed when t finishes.
+func newTCPPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must.Equal(t, nil, err)
+
+	defer func() { _ = ln.Close() }()
+
+	acceptc := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		acceptc <- conn
+	}()
+
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	must.Equal(t, nil, err)
+
+	accepted := <-acceptc
+	must.NotNil(t, accepted)
+
+	t.Cleanup(func() {
+		_ = dialed.Close()
+		_ = accepted.Close()
+	})
+
+	return dialed.(*net.TCPConn), accepted.(*net.TCPConn)
+}
+
+// flagReader records whether it has been read from.
+type flagReader struct {
+	io.Reader
+
+	read *atomic.Bool
+}
+
+func (r *flagReader) Read(p []byte) (int, error) {
+	r.read.Store(true)
+
+	return r.Reader.Read(p)
+}
@@ -2,9 +2,14 @@
 package web
 
 import (
+	"bufio"
+	"encoding/json"
 	"log/slog"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -14,9 +19,25 @@ const (
 	errPanicked = Error("web: app panicked")
 )
 
+const (
+	LatencyModeSeconds LatencyMode = iota
+	LatencyModeMillis
+	LatencyModeDuration
+)
+
+// LatencyMode controls how the latency of finished requests is logged.
+type LatencyMode int
+
 type App struct {
-	lg  *slog.Logger
-	mux *httprouter.Router
+	lg   *slog.Logger
+	mux  *httprouter.Router
+	smp  *sampler
+	lmod LatencyMode
+
+	// mws wrap the router, and hdl is the result, built once on the first request.
+	mws  []func(http.Handler) http.Handler
+	hdl  http.Handler
+	once *sync.Once
 
 	empty  []byte
 	jempty []byte
@@ -25,6 +46,7 @@ type App struct {
 func NewApp(lg *slog.Logger) *App {
 	result := &App{
 		lg:     lg,
+		once:   &sync.Once{},
 		empty:  []byte{},
 		jempty: []byte{'{', '}'},
 	}
@@ -49,18 +71,85 @@ func (h *App) Handler(method, path string, fn http.Handler) {
 func (h *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now().UTC()
 
-	h.mux.ServeHTTP(w, r)
+	if h.smp == nil {
+		h.serve(w, r)
+	} else {
+		sw := &statusWriter{ResponseWriter: w}
+		h.serve(sw, r)
+
+		if !sw.failed && !h.smp.sample(sw.code) {
+			return
+		}
+	}
 
 	attrs := lattrsFromReq(r)
-	attrs = append(attrs, slog.Float64("http.latency", time.Since(start).Seconds()))
+	attrs = append(attrs, latencyAttr(h.lmod, time.Since(start)))
 
 	h.lg.LogAttrs(r.Context(), slog.LevelInfo, "finished request", attrs...)
 }
 
+// Use adds mw to wrap the router, so that it sees every request, including those not found.
+//
+// Middleware is applied in the order it was added, the first one being the outermost.
+// It must be added before the app starts serving requests, as the chain is built once, on the first request.
+func (h *App) Use(mw func(http.Handler) http.Handler) {
+	h.mws = append(h.mws, mw)
+}
+
+// serve passes the request through middleware, if any, to the router.
+//
+// The router recovers panics in handlers, and panics in middleware are recovered here.
+func (h *App) serve(w http.ResponseWriter, r *http.Request) {
+	if len(h.mws) == 0 {
+		h.mux.ServeHTTP(w, r)
+		return
+	}
+
+	h.once.Do(h.wrap)
+
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			h.handlePanic(w, r, rcv)
+		}
+	}()
+
+	h.hdl.ServeHTTP(w, r)
+}
+
+// wrap builds the handler out of the router and middleware.
+func (h *App) wrap() {
+	var hdl http.Handler = h.mux
+	for i := len(h.mws) - 1; i >= 0; i-- {
+		hdl = h.mws[i](hdl)
+	}
+
+	h.hdl = hdl
+}
+
 func (h *App) Set404(nfh http.Handler) {
 	h.mux.NotFound = nfh
 }
 
+// SetLogSampling makes the app log only one in n finished requests.
+//
+// Requests that finish with an error status are always logged.
+// Values of n below 2 disable sampling, so that every request is logged.
+func (h *App) SetLogSampling(n int) {
+	if n < 2 {
+		h.smp = nil
+		return
+	}
+
+	h.smp = &sampler{n: uint64(n)}
+}
+
+// SetLatencyMode sets how the latency of finished requests is logged.
+//
+// Unknown modes fall back to seconds.
+func (h *App) SetLatencyMode(mode LatencyMode) {
+	h.lmod = mode
+}
+
 func (h *App) handlePanic(w http.ResponseWriter, r *http.Request, rcv interface{}) {
 	if rcv == nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -123,6 +212,47 @@ func WriteError(w http.ResponseWriter, code int, text string) error {
 	return err
 }
 
+// MarkFailed records that the request written to via w has failed, so that it's logged even with sampling.
+//
+// It's meant for hijacked connections, whose status the app doesn't see.
+// It does nothing when w doesn't come from an app that samples logs.
+func MarkFailed(w http.ResponseWriter) {
+	for {
+		switch wx := w.(type) {
+		case *statusWriter:
+			wx.failed = true
+
+			return
+
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = wx.Unwrap()
+
+		default:
+			return
+		}
+	}
+}
+
+// WriteErrorJSON writes text as a JSON error object, {"error": text}.
+func WriteErrorJSON(w http.ResponseWriter, code int, text string) error {
+	data, err := json.Marshal(&struct {
+		Error string `json:"error"`
+	}{
+		Error: text,
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	w.WriteHeader(code)
+	_, err = w.Write(data)
+
+	return err
+}
+
 func lattrsFromReq(r *http.Request) []slog.Attr {
 	result := []slog.Attr{
 		slog.String("http.host", r.Host),
@@ -134,3 +264,81 @@ func lattrsFromReq(r *http.Request) []slog.Attr {
 
 	return result
 }
+
+// latencyAttr returns the http.latency attribute for d in mode:
+// - seconds as a float for LatencyModeSeconds and unknown modes;
+// - whole milliseconds for LatencyModeMillis;
+// - a duration string, e.g. 1.5ms, for LatencyModeDuration.
+func latencyAttr(mode LatencyMode, d time.Duration) slog.Attr {
+	switch mode {
+	case LatencyModeMillis:
+		return slog.Int64("http.latency", d.Milliseconds())
+	case LatencyModeDuration:
+		return slog.String("http.latency", d.String())
+	default:
+		return slog.Float64("http.latency", d.Seconds())
+	}
+}
+
+// sampler decides which requests to log.
+type sampler struct {
+	n   uint64
+	cnt atomic.Uint64
+}
+
+// sample reports whether a request that finished with code should be logged.
+//
+// The first of every n requests is logged, as well as every request with an error status.
+func (s *sampler) sample(code int) bool {
+	if code >= http.StatusBadRequest {
+		return true
+	}
+
+	return (s.cnt.Add(1)-1)%s.n == 0
+}
+
+// statusWriter records the status code written by a handler.
+//
+// It keeps hijacking and flushing available to handlers.
+// The status of a hijacked connection is not seen, so failed is set via MarkFailed instead.
+type statusWriter struct {
+	http.ResponseWriter
+
+	code   int
+	failed bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	return hj.Hijack()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
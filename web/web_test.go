@@ -1,15 +1,21 @@
 package web
 
 import (
+	"bytes"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	should "github.com/stretchr/testify/assert"
 	must "github.com/stretchr/testify/require"
+
+	"github.com/pavelbrm/pumpe/fakenet"
 )
 
 type testCase[G, E any] struct {
@@ -91,6 +97,265 @@ func TestApp_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestApp_Use(t *testing.T) {
+	type tcGiven struct {
+		fn  httprouter.Handle
+		mw  func(http.Handler) http.Handler
+		req *http.Request
+	}
+
+	type tcExpected struct {
+		code  int
+		data  []byte
+		calls []string
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "valid",
+			given: tcGiven{
+				fn: func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.Write([]byte("success"))
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://localhost/test/found", nil),
+			},
+			exp: tcExpected{
+				code:  http.StatusOK,
+				data:  []byte("success"),
+				calls: []string{"first", "second"},
+			},
+		},
+
+		{
+			name: "not_found",
+			given: tcGiven{
+				fn: func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+					w.WriteHeader(http.StatusInternalServerError)
+					_, _ = w.Write([]byte("unexpected_body"))
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://localhost/test/not_found", nil),
+			},
+			exp: tcExpected{
+				code:  http.StatusNotFound,
+				calls: []string{"first", "second"},
+			},
+		},
+
+		{
+			name: "panic_in_handler",
+			given: tcGiven{
+				fn: func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+					panic("something_went_wrong")
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://localhost/test/found", nil),
+			},
+			exp: tcExpected{
+				code:  http.StatusInternalServerError,
+				calls: []string{"first", "second"},
+			},
+		},
+
+		{
+			name: "panic_in_middleware",
+			given: tcGiven{
+				fn: func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.Write([]byte("unexpected_body"))
+				},
+				mw: func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						panic("something_went_wrong")
+					})
+				},
+				req: httptest.NewRequest(http.MethodGet, "http://localhost/test/found", nil),
+			},
+			exp: tcExpected{
+				code:  http.StatusInternalServerError,
+				calls: []string{"first", "second"},
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+			app := NewApp(lg)
+
+			app.Handle(http.MethodGet, "/test/found", tc.given.fn)
+
+			var calls []string
+
+			for _, name := range []string{"first", "second"} {
+				app.Use(func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						calls = append(calls, name)
+
+						next.ServeHTTP(w, r)
+					})
+				})
+			}
+
+			if tc.given.mw != nil {
+				app.Use(tc.given.mw)
+			}
+
+			rw := httptest.NewRecorder()
+			app.ServeHTTP(rw, tc.given.req)
+
+			should.Equal(t, tc.exp.code, rw.Code)
+			should.Equal(t, tc.exp.data, rw.Body.Bytes())
+			should.Equal(t, tc.exp.calls, calls)
+		})
+	}
+}
+
+func TestApp_Use_wrapsOnce(t *testing.T) {
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := NewApp(lg)
+
+	app.Handle(http.MethodGet, "/test/found", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var wraps int
+
+	for i := 0; i < 3; i++ {
+		app.Use(func(next http.Handler) http.Handler {
+			wraps++
+
+			return next
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		app.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/test/found", nil))
+
+		should.Equal(t, http.StatusOK, rw.Code)
+	}
+
+	should.Equal(t, 3, wraps)
+}
+
+func TestApp_SetLogSampling(t *testing.T) {
+	type tcGiven struct {
+		n     int
+		codes []int
+	}
+
+	tests := []testCase[tcGiven, int]{
+		{
+			name: "disabled",
+			given: tcGiven{
+				n:     1,
+				codes: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			},
+			exp: 3,
+		},
+
+		{
+			name: "one_in_two",
+			given: tcGiven{
+				n:     2,
+				codes: []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK},
+			},
+			exp: 3,
+		},
+
+		{
+			name: "errors_always_logged",
+			given: tcGiven{
+				n:     10,
+				codes: []int{http.StatusOK, http.StatusBadGateway, http.StatusOK, http.StatusForbidden, http.StatusOK},
+			},
+			exp: 3,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			lg := slog.New(slog.NewTextHandler(buf, nil))
+
+			app := NewApp(lg)
+			app.SetLogSampling(tc.given.n)
+
+			for j := range tc.given.codes {
+				code := tc.given.codes[j]
+
+				app.Handle(http.MethodGet, "/test/"+strconv.Itoa(j), func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+					w.WriteHeader(code)
+				})
+
+				rw := httptest.NewRecorder()
+				app.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/test/"+strconv.Itoa(j), nil))
+
+				should.Equal(t, code, rw.Code)
+			}
+
+			should.Equal(t, tc.exp, strings.Count(buf.String(), "finished request"))
+		})
+	}
+}
+
+func TestApp_SetLogSampling_failed(t *testing.T) {
+	buf := &bytes.Buffer{}
+	lg := slog.New(slog.NewTextHandler(buf, nil))
+
+	app := NewApp(lg)
+	app.SetLogSampling(10)
+
+	// Middleware may wrap the writer, too.
+	app.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&unwrapWriter{ResponseWriter: w}, r)
+		})
+	})
+
+	for j, failed := range []bool{false, false, true, true} {
+		failed := failed
+
+		app.Handle(http.MethodGet, "/test/"+strconv.Itoa(j), func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			if failed {
+				MarkFailed(w)
+			}
+		})
+
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/test/"+strconv.Itoa(j), nil))
+	}
+
+	should.Equal(t, 3, strings.Count(buf.String(), "finished request"))
+}
+
+type unwrapWriter struct {
+	http.ResponseWriter
+}
+
+func (w *unwrapWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestStatusWriter_Hijack(t *testing.T) {
+	t.Run("not_supported", func(t *testing.T) {
+		sw := &statusWriter{ResponseWriter: httptest.NewRecorder()}
+
+		_, _, err := sw.Hijack()
+		should.Equal(t, http.ErrNotSupported, err)
+	})
+
+	t.Run("supported", func(t *testing.T) {
+		sw := &statusWriter{ResponseWriter: fakenet.NewResponseRecorderHJ(nil)}
+
+		_, _, err := sw.Hijack()
+		should.Equal(t, nil, err)
+	})
+}
+
 func TestApp_handlePanic(t *testing.T) {
 	type tcExpected struct {
 		code        int
@@ -314,6 +579,62 @@ func TestWriteError(t *testing.T) {
 	}
 }
 
+func TestWriteErrorJSON(t *testing.T) {
+	type tcGiven struct {
+		code int
+		text string
+	}
+
+	type tcExpected struct {
+		code int
+		data []byte
+	}
+
+	tests := []testCase[tcGiven, tcExpected]{
+		{
+			name: "bad_gateway",
+			given: tcGiven{
+				code: http.StatusBadGateway,
+				text: "server error",
+			},
+			exp: tcExpected{
+				code: http.StatusBadGateway,
+				data: []byte(`{"error":"server error"}`),
+			},
+		},
+
+		{
+			name: "escaped",
+			given: tcGiven{
+				code: http.StatusBadRequest,
+				text: `invalid "value"`,
+			},
+			exp: tcExpected{
+				code: http.StatusBadRequest,
+				data: []byte(`{"error":"invalid \"value\""}`),
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+
+			err := WriteErrorJSON(rw, tc.given.code, tc.given.text)
+			must.Equal(t, nil, err)
+
+			should.Equal(t, tc.exp.code, rw.Code)
+			should.Equal(t, tc.exp.data, rw.Body.Bytes())
+
+			resp := rw.Result()
+			should.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			should.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+		})
+	}
+}
+
 func TestLattrsFromReq(t *testing.T) {
 	tests := []testCase[*http.Request, []slog.Attr]{
 		{
@@ -338,3 +659,45 @@ func TestLattrsFromReq(t *testing.T) {
 		})
 	}
 }
+
+func TestLatencyAttr(t *testing.T) {
+	type tcGiven struct {
+		mode LatencyMode
+		d    time.Duration
+	}
+
+	tests := []testCase[tcGiven, slog.Attr]{
+		{
+			name:  "seconds",
+			given: tcGiven{mode: LatencyModeSeconds, d: 1500 * time.Millisecond},
+			exp:   slog.Float64("http.latency", 1.5),
+		},
+
+		{
+			name:  "millis",
+			given: tcGiven{mode: LatencyModeMillis, d: 1500*time.Millisecond + 900*time.Microsecond},
+			exp:   slog.Int64("http.latency", 1500),
+		},
+
+		{
+			name:  "duration",
+			given: tcGiven{mode: LatencyModeDuration, d: 1500 * time.Microsecond},
+			exp:   slog.String("http.latency", "1.5ms"),
+		},
+
+		{
+			name:  "unknown",
+			given: tcGiven{mode: LatencyMode(42), d: 250 * time.Millisecond},
+			exp:   slog.Float64("http.latency", 0.25),
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := latencyAttr(tc.given.mode, tc.given.d)
+			should.Equal(t, tc.exp, actual)
+		})
+	}
+}